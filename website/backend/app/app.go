@@ -0,0 +1,149 @@
+// Package app assembles the backend both entry points serve: the
+// background jobs and the API handlers
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/ClarityXDR/prod/website/backend/config"
+	"github.com/ClarityXDR/prod/website/backend/handlers"
+	"github.com/ClarityXDR/prod/website/backend/internal/arm"
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/defender"
+	"github.com/ClarityXDR/prod/website/backend/internal/graph"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/library"
+	"github.com/ClarityXDR/prod/website/backend/internal/logicapp"
+	"github.com/ClarityXDR/prod/website/backend/internal/response"
+	"github.com/ClarityXDR/prod/website/backend/internal/sentinel"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/bulk"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/distribution"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/enrichment"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/feeds"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/sightings"
+	"github.com/gorilla/mux"
+)
+
+// App is the backend Start assembled
+type App struct {
+	// API is the /api router, for the caller's own routes
+	API *mux.Router
+
+	importer *bulk.Importer
+}
+
+// Wait waits for the work requests started in the background, such as
+// indicator imports, to end. Call it once the context given to Start is
+// cancelled, before closing the database.
+func (a *App) Wait() {
+	a.importer.Wait()
+}
+
+// Start starts the background jobs, which run until ctx is cancelled, and
+// registers the handlers on r: the API under /api and the TAXII 2.1
+// server.
+func Start(ctx context.Context, cfg *config.Config, db *sql.DB, r *mux.Router) (*App, error) {
+	secrets, err := azure.NewSecretCipher(cfg.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	credentials := azure.NewCredentialStore(db, secrets)
+
+	// Sync threat intel feeds in the background
+	feedScheduler := feeds.NewScheduler(feeds.NewStore(db))
+	go feedScheduler.Run(ctx)
+
+	indicators := indicator.NewStore(db)
+	indicatorSweeper := indicator.NewSweeper(db, indicators)
+	go indicatorSweeper.Run(ctx)
+
+	distributor := distribution.NewDistributor(distribution.NewStore(db), indicators, credentials)
+	go distributor.Run(ctx)
+
+	var enrichmentProviders []enrichment.Provider
+	if cfg.VirusTotalAPIKey != "" {
+		enrichmentProviders = append(enrichmentProviders, enrichment.NewVirusTotal(cfg.VirusTotalAPIKey))
+	}
+	if cfg.CriminalIPAPIKey != "" {
+		enrichmentProviders = append(enrichmentProviders, enrichment.NewCriminalIP(cfg.CriminalIPAPIKey))
+	}
+	if cfg.AzureTenantID != "" && cfg.AzureClientID != "" && cfg.AzureClientSecret != "" {
+		enrichmentProviders = append(enrichmentProviders, enrichment.NewMicrosoftTI(azure.NewClientCredentials(azure.Credentials{
+			TenantID:     cfg.AzureTenantID,
+			ClientID:     cfg.AzureClientID,
+			ClientSecret: cfg.AzureClientSecret,
+		})))
+	}
+	enricher := enrichment.NewEnricher(db, indicators, enrichment.NewDBCache(db), enrichmentProviders...)
+	go enricher.Run(ctx)
+
+	importer := bulk.NewImporter(ctx, bulk.NewJobStore(db), indicators)
+
+	hunter := sightings.NewHunter(sightings.NewLogAnalyticsBackend(db, credentials), sightings.NewStore(db),
+		indicators, sightings.NewTicketStore(db))
+
+	incidentSyncer := sentinel.NewSyncer(db, sentinel.NewTenantConnectors(credentials))
+	go incidentSyncer.Run(ctx)
+	sentinelDeployer := sentinel.NewDeployer(db, arm.NewTenantSource(credentials))
+
+	logicAppCatalog := logicapp.NewCatalog(db, cfg.LogicAppsDir)
+	go func() {
+		result, err := logicAppCatalog.Sync(ctx)
+		if err != nil {
+			log.Printf("Indexing Logic App templates failed: %v", err)
+			return
+		}
+		for _, e := range result.Errors {
+			log.Printf("Skipped Logic App template %s: %s", e.File, e.Error)
+		}
+	}()
+	logicAppDeployer := logicapp.NewDeployer(db, arm.NewTenantSource(credentials), logicAppCatalog, secrets)
+	logicAppDeployer.LicenseEndpoint = cfg.LicenseAPIEndpoint
+	logicAppDeployer.Directories = graph.NewTenantSource(credentials)
+	logicAppHooks := []logicapp.AlertHook{logicapp.NewTicketHook(db)}
+	if cfg.LogicAppAlertWebhook != "" {
+		logicAppHooks = append(logicAppHooks, logicapp.NewWebhookHook(cfg.LogicAppAlertWebhook))
+	}
+	logicAppMonitor := logicapp.NewMonitor(db, arm.NewTenantSource(credentials), logicAppHooks...)
+	go logicAppMonitor.Run(ctx)
+
+	responder := response.NewResponder(db, defender.NewTenantSource(credentials))
+	go responder.Run(ctx)
+
+	ruleLibrary := library.New(cfg.RulesDir)
+
+	// Initialize handlers
+	agentHandler := handlers.NewAgentHandler(db)
+	kqlHandler := handlers.NewKQLHandler(db)
+	clientHandler := handlers.NewClientHandler(db)
+	rulesHandler := handlers.NewRulesHandler(db, ruleLibrary)
+	logicAppHandler := handlers.NewLogicAppHandler(db, logicAppCatalog, logicAppDeployer, logicAppMonitor)
+	mdeHandler := handlers.NewMDEHandler(db)
+	threatIntelHandler := handlers.NewThreatIntelHandler(db, indicators, feedScheduler, importer, distributor, enricher, hunter)
+	sentinelHandler := handlers.NewSentinelHandler(db, incidentSyncer, sentinelDeployer, ruleLibrary)
+	taxiiHandler := handlers.NewTAXIIHandler(db, indicators)
+	threatActorHandler := handlers.NewThreatActorHandler(db, indicators)
+	responseHandler := handlers.NewResponseHandler(responder)
+
+	// Register routes
+	api := r.PathPrefix("/api").Subrouter()
+	agentHandler.RegisterRoutes(api)
+	kqlHandler.RegisterRoutes(api)
+	clientHandler.RegisterRoutes(api)
+	rulesHandler.RegisterRoutes(api)
+	logicAppHandler.RegisterRoutes(api)
+	mdeHandler.RegisterRoutes(api)
+	threatIntelHandler.RegisterRoutes(api)
+	sentinelHandler.RegisterRoutes(api)
+	taxiiHandler.RegisterRoutes(api)
+	threatActorHandler.RegisterRoutes(api)
+	responseHandler.RegisterRoutes(api)
+
+	// TAXII 2.1 server for clients and partner tools
+	taxiiHandler.RegisterServerRoutes(r)
+
+	return &App{API: api, importer: importer}, nil
+}
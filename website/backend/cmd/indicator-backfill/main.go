@@ -0,0 +1,34 @@
+// Command indicator-backfill normalizes the threat indicators stored before
+// values were normalized on the way in, merges the rows that turn out to be
+// the same indicator and builds the unique index on global indicators.
+// Run it once against databases created before the normalizer, before
+// starting the upgraded server; running it again changes nothing.
+//
+//	indicator-backfill
+//
+// The database is the one DATABASE_URL names.
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/ClarityXDR/prod/website/backend/config"
+	"github.com/ClarityXDR/prod/website/backend/database"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+func main() {
+	db, err := database.Initialize(config.Load().DatabaseURL)
+	if err != nil {
+		log.Fatal("Failed to connect to the database: ", err)
+	}
+	defer db.Close()
+
+	result, err := indicator.NewStore(db).Backfill(context.Background())
+	if err != nil {
+		log.Fatal("Backfill failed: ", err)
+	}
+	log.Printf("Indicators backfilled: %d normalized, %d merged, %d unrecognized and left as stored",
+		result.Normalized, result.Merged, result.Unrecognized)
+}
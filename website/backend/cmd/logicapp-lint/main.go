@@ -0,0 +1,73 @@
+// Command logicapp-lint checks the Logic App templates of a directory for
+// credentials written into them, actions whose failure nothing handles,
+// unauthenticated HTTP actions, unresolved placeholders and tokens
+// requested for the wrong API.
+//
+//	logicapp-lint [-json] [-warnings=false] [dir]
+//
+// dir defaults to LOGIC_APPS_DIR, else ./logic-apps. The exit status is 1
+// when a template has an error finding or cannot be read.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ClarityXDR/prod/website/backend/config"
+	"github.com/ClarityXDR/prod/website/backend/internal/logicapp"
+)
+
+func main() {
+	asJSON := flag.Bool("json", false, "print the reports as JSON")
+	warnings := flag.Bool("warnings", true, "print warnings as well as errors")
+	flag.Parse()
+
+	dir := config.Load().LogicAppsDir
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	reports, err := logicapp.LintDir(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	failed := false
+	for i := range reports {
+		report := &reports[i]
+		if report.Error != "" || report.Errors() > 0 {
+			failed = true
+		}
+		if !*warnings {
+			var findings []logicapp.Finding
+			for _, f := range report.Findings {
+				if f.Severity == logicapp.SeverityError {
+					findings = append(findings, f)
+				}
+			}
+			report.Findings = findings
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, report := range reports {
+			if report.Error != "" {
+				fmt.Printf("%s: error: %s\n", report.File, report.Error)
+			}
+			for _, f := range report.Findings {
+				fmt.Printf("%s: %s: %s [%s]\n\t%s\n", report.File, f.Severity, f.Message, f.Rule, f.Path)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
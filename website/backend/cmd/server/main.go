@@ -9,6 +9,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/ClarityXDR/prod/website/backend/app"
 	"github.com/ClarityXDR/prod/website/backend/config"
 	"github.com/ClarityXDR/prod/website/backend/database"
 	"github.com/ClarityXDR/prod/website/backend/handlers"
@@ -39,28 +40,14 @@ func main() {
 	r.Use(middleware.RateLimitMiddleware)
 	r.Use(middleware.LoggingMiddleware)
 
-	// Initialize handlers
-	agentHandler := handlers.NewAgentHandler(db)
-	kqlHandler := handlers.NewKQLHandler(db)
-	clientHandler := handlers.NewClientHandler(db)
-	rulesHandler := handlers.NewRulesHandler(db)
-	licenseHandler := handlers.NewLicenseHandler(db)
-	logicAppHandler := handlers.NewLogicAppHandler(db)
-	mdeHandler := handlers.NewMDEHandler(db)
-	threatIntelHandler := handlers.NewThreatIntelHandler(db)
-	sentinelHandler := handlers.NewSentinelHandler(db)
-
-	// Register routes
-	api := r.PathPrefix("/api").Subrouter()
-	agentHandler.RegisterRoutes(api)
-	kqlHandler.RegisterRoutes(api)
-	clientHandler.RegisterRoutes(api)
-	rulesHandler.RegisterRoutes(api)
-	licenseHandler.RegisterRoutes(api)
-	logicAppHandler.RegisterRoutes(api)
-	mdeHandler.RegisterRoutes(api)
-	threatIntelHandler.RegisterRoutes(api)
-	sentinelHandler.RegisterRoutes(api)
+	// Start background jobs and register the API
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	backend, err := app.Start(jobsCtx, cfg, db, r)
+	if err != nil {
+		log.Fatal("Failed to start:", err)
+	}
+	handlers.NewLicenseHandler(db).RegisterRoutes(backend.API)
 
 	// License validation endpoint (no auth required for Logic Apps)
 	r.HandleFunc("/api/licensing/validate", handlers.NewLicenseHandler(db).ValidateLicense).Methods("GET")
@@ -119,9 +106,11 @@ func main() {
 
 	// Shutdown server
 	log.Println("Shutting down server...")
+	stopJobs()
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	backend.Wait()
 
 	log.Println("Server exited properly")
 }
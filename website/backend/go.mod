@@ -9,4 +9,5 @@ require (
 	github.com/rs/cors v1.10.1
 	golang.org/x/crypto v0.39.0
 	golang.org/x/time v0.12.0
+	gopkg.in/yaml.v3 v3.0.1
 )
\ No newline at end of file
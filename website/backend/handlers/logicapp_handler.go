@@ -1,25 +1,65 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/ClarityXDR/prod/website/backend/internal/arm"
+	"github.com/ClarityXDR/prod/website/backend/internal/graph"
+	"github.com/ClarityXDR/prod/website/backend/internal/logicapp"
 	"github.com/gorilla/mux"
 )
 
+// maxTemplateSize caps templates uploaded to be linted
+const maxTemplateSize = 4 << 20
+
 type LogicAppHandler struct {
-	db *sql.DB
+	db       *sql.DB
+	catalog  *logicapp.Catalog
+	deployer *logicapp.Deployer
+	monitor  *logicapp.Monitor
 }
 
-func NewLogicAppHandler(db *sql.DB) *LogicAppHandler {
-	return &LogicAppHandler{db: db}
+func NewLogicAppHandler(db *sql.DB, catalog *logicapp.Catalog, deployer *logicapp.Deployer,
+	monitor *logicapp.Monitor) *LogicAppHandler {
+	return &LogicAppHandler{db: db, catalog: catalog, deployer: deployer, monitor: monitor}
 }
 
 func (h *LogicAppHandler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/logicapps/clients", h.GetClients).Methods("GET")
 	r.HandleFunc("/logicapps/templates", h.GetTemplates).Methods("GET")
+	r.HandleFunc("/logicapps/templates/sync", h.SyncTemplates).Methods("POST")
+	r.HandleFunc("/logicapps/templates/{name}", h.GetTemplate).Methods("GET")
+	r.HandleFunc("/logicapps/templates/{name}/preview", h.PreviewTemplate).Methods("POST")
+	r.HandleFunc("/logicapps/templates/{name}/lint", h.LintTemplate).Methods("GET")
+	r.HandleFunc("/logicapps/templates/{name}/permissions", h.GetTemplatePermissions).Methods("GET")
+	r.HandleFunc("/logicapps/lint", h.LintTemplates).Methods("GET")
+	r.HandleFunc("/logicapps/lint", h.LintUpload).Methods("POST")
 	r.HandleFunc("/logicapps/deployments", h.GetDeployments).Methods("GET")
+	r.HandleFunc("/logicapps/deployments/bulk", h.BulkAction).Methods("POST")
+	r.HandleFunc("/logicapps/deployments/{id}", h.GetDeployment).Methods("GET")
+	r.HandleFunc("/logicapps/deployments/{id}", h.DeleteDeployment).Methods("DELETE")
+	r.HandleFunc("/logicapps/deployments/{id}/deploy", h.RetryDeployment).Methods("POST")
+	r.HandleFunc("/logicapps/deployments/{id}/enable", h.EnableDeployment).Methods("POST")
+	r.HandleFunc("/logicapps/deployments/{id}/disable", h.DisableDeployment).Methods("POST")
+	r.HandleFunc("/logicapps/deployments/{id}/upgrade", h.UpgradeDeployment).Methods("POST")
+	r.HandleFunc("/logicapps/deployments/{id}/audit", h.GetDeploymentAudit).Methods("GET")
+	r.HandleFunc("/logicapps/deployments/{id}/permissions", h.GetDeploymentPermissions).Methods("GET")
+	r.HandleFunc("/logicapps/deployments/{id}/permissions/grant", h.GrantDeploymentPermissions).Methods("POST")
+	r.HandleFunc("/logicapps/deployments/{id}/permissions/script", h.GetPermissionScript).Methods("GET")
+	r.HandleFunc("/logicapps/permissions/drift", h.GetPermissionDrift).Methods("GET")
+	r.HandleFunc("/logicapps/deployments/{id}/runs/poll", h.PollDeploymentRuns).Methods("POST")
+	r.HandleFunc("/logicapps/runs", h.GetRuns).Methods("GET")
+	r.HandleFunc("/logicapps/runs/stats", h.GetRunStats).Methods("GET")
+	r.HandleFunc("/logicapps/runs/poll", h.PollRuns).Methods("POST")
+	r.HandleFunc("/logicapps/alerts", h.GetAlerts).Methods("GET")
 	r.HandleFunc("/logicapps/deploy", h.DeployLogicApp).Methods("POST")
 	r.HandleFunc("/logicapps/disable", h.DisableLogicApp).Methods("POST")
 }
@@ -56,29 +96,172 @@ func (h *LogicAppHandler) GetClients(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(clients)
 }
 
+// GetTemplates lists the active version of every catalog template
 func (h *LogicAppHandler) GetTemplates(w http.ResponseWriter, r *http.Request) {
-	templates := []map[string]interface{}{
-		{
-			"name":        "sentinel-integration",
-			"displayName": "Sentinel Integration",
-			"description": "Integrates ClarityXDR with Microsoft Sentinel",
-		},
-		{
-			"name":        "incident-response",
-			"displayName": "Incident Response",
-			"description": "Automated incident response workflows",
-		},
-		{
-			"name":        "threat-hunting",
-			"displayName": "Threat Hunting",
-			"description": "Automated threat hunting and investigation",
-		},
+	templates, err := h.catalog.Templates(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The list names the templates; their schemas are served one by one
+	for _, t := range templates {
+		t.Schema = nil
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(templates)
 }
 
+// GetTemplate returns a template with its schema: the input of its
+// trigger, the parameters and API connections to deploy it with and the
+// API permissions it needs. The version query parameter picks a version
+// other than the active one.
+func (h *LogicAppHandler) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	t, err := h.catalog.Template(r.Context(), name, r.URL.Query().Get("version"))
+	if errors.Is(err, logicapp.ErrTemplateNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	versions, err := h.catalog.Versions(r.Context(), name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type version struct {
+		Version     string    `json:"version"`
+		Active      bool      `json:"active"`
+		ContentHash string    `json:"contentHash"`
+		CreatedAt   time.Time `json:"createdAt"`
+	}
+	response := struct {
+		*logicapp.CatalogTemplate
+		Versions []version `json:"versions"`
+	}{CatalogTemplate: t, Versions: []version{}}
+	for _, v := range versions {
+		response.Versions = append(response.Versions, version{v.Version, v.Active, v.ContentHash, v.CreatedAt})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// PreviewTemplate renders a template with parameter values as it would be
+// deployed, without deploying it. With a clientId the values ClarityXDR
+// sets for the client are filled in. Values of secure parameters are
+// masked.
+func (h *LogicAppHandler) PreviewTemplate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ClientID   string                     `json:"clientId"`
+		Version    string                     `json:"version"`
+		Parameters map[string]json.RawMessage `json:"parameters"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := logicapp.Request{ClientID: body.ClientID, TemplateName: mux.Vars(r)["name"], Parameters: body.Parameters}
+	t, ct, err := h.deployer.Preview(r.Context(), req, body.Version)
+	var invalid *logicapp.ParametersError
+	switch {
+	case errors.As(err, &invalid):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(invalid)
+		return
+	case errors.Is(err, logicapp.ErrTemplateNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, logicapp.ErrInvalidTemplate):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":       ct.Name,
+		"version":    ct.Version,
+		"definition": t.Definition,
+		"parameters": t.Parameters,
+	})
+}
+
+// SyncTemplates indexes the template directory into the catalog, adding a
+// version of each template whose file changed
+func (h *LogicAppHandler) SyncTemplates(w http.ResponseWriter, r *http.Request) {
+	result, err := h.catalog.Sync(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// LintTemplates lints the active version of every template in the catalog
+func (h *LogicAppHandler) LintTemplates(w http.ResponseWriter, r *http.Request) {
+	reports, err := h.catalog.Lint(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+// LintTemplate lints a template of the catalog. The version query
+// parameter picks a version other than the active one.
+func (h *LogicAppHandler) LintTemplate(w http.ResponseWriter, r *http.Request) {
+	ct, err := h.catalog.Template(r.Context(), mux.Vars(r)["name"], r.URL.Query().Get("version"))
+	if errors.Is(err, logicapp.ErrTemplateNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	findings := []logicapp.Finding{}
+	t, err := ct.Template()
+	if err == nil {
+		findings, err = logicapp.Lint(t)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logicapp.LintReport{Name: ct.Name, Version: ct.Version, Findings: findings})
+}
+
+// LintUpload lints a template posted as the body, as exported from the
+// Logic App code view, before it is added to the template directory
+func (h *LogicAppHandler) LintUpload(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxTemplateSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	findings := []logicapp.Finding{}
+	t, err := logicapp.ParseTemplate(data)
+	if err == nil {
+		findings, err = logicapp.Lint(t)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"findings": findings})
+}
+
 func (h *LogicAppHandler) GetDeployments(w http.ResponseWriter, r *http.Request) {
 	query := `
         SELECT 
@@ -138,28 +321,31 @@ func (h *LogicAppHandler) GetDeployments(w http.ResponseWriter, r *http.Request)
 	statsQuery := `
         SELECT 
             COUNT(*) as total,
-            COUNT(*) FILTER (WHERE status = 'Active') as active,
+            COUNT(*) FILTER (WHERE status IN ('Success', 'Active')) as active,
             COUNT(*) FILTER (WHERE status = 'Failed') as failed,
-            COUNT(*) FILTER (WHERE status = 'Pending') as pending
+            COUNT(*) FILTER (WHERE status IN ('Pending', 'Deploying')) as pending,
+            COUNT(*) FILTER (WHERE status = 'Disabled') as disabled
         FROM deployment_mgmt.logic_app_deployments
     `
 
 	var stats struct {
-		Total   int
-		Active  int
-		Failed  int
-		Pending int
+		Total    int
+		Active   int
+		Failed   int
+		Pending  int
+		Disabled int
 	}
 
-	h.db.QueryRow(statsQuery).Scan(&stats.Total, &stats.Active, &stats.Failed, &stats.Pending)
+	h.db.QueryRow(statsQuery).Scan(&stats.Total, &stats.Active, &stats.Failed, &stats.Pending, &stats.Disabled)
 
 	response := map[string]interface{}{
 		"deployments": deployments,
 		"stats": map[string]int{
-			"total":   stats.Total,
-			"active":  stats.Active,
-			"failed":  stats.Failed,
-			"pending": stats.Pending,
+			"total":    stats.Total,
+			"active":   stats.Active,
+			"failed":   stats.Failed,
+			"pending":  stats.Pending,
+			"disabled": stats.Disabled,
 		},
 	}
 
@@ -167,81 +353,458 @@ func (h *LogicAppHandler) GetDeployments(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(response)
 }
 
+// DeployLogicApp records a deployment of a template and deploys it to the
+// client's subscription in the background. The deployment is Pending until
+// Resource Manager confirms the workflow, then Success or Failed.
 func (h *LogicAppHandler) DeployLogicApp(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		ClientID         string `json:"clientId"`
-		TemplateName     string `json:"templateName"`
-		LogicAppName     string `json:"logicAppName"`
-		SubscriptionID   string `json:"subscriptionId"`
-		ResourceGroup    string `json:"resourceGroup"`
-		AzureCredentials struct {
-			TenantID     string `json:"tenantId"`
-			ClientID     string `json:"clientId"`
-			ClientSecret string `json:"clientSecret"`
-		} `json:"azureCredentials,omitempty"`
+		logicapp.Request
+		lifecycleRequest
 	}
-
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Insert deployment record
-	query := `
-        INSERT INTO deployment_mgmt.logic_app_deployments 
-        (client_id, logic_app_name, subscription_id, resource_group, template_name, status)
-        VALUES ($1, $2, $3, $4, $5, 'Pending')
-        RETURNING id
-    `
+	dep, err := h.deployer.Create(r.Context(), req.Request)
+	var invalid *logicapp.ParametersError
+	switch {
+	case errors.As(err, &invalid):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(invalid)
+		return
+	case errors.Is(err, logicapp.ErrInvalidRequest), errors.Is(err, logicapp.ErrInvalidTemplate):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, logicapp.ErrTemplateNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.start(w, r, dep.ID, req.PerformedBy)
+}
 
-	var deploymentID string
-	err := h.db.QueryRow(query, req.ClientID, req.LogicAppName,
-		req.SubscriptionID, req.ResourceGroup, req.TemplateName).Scan(&deploymentID)
+// GetDeployment returns a deployment with the error Resource Manager gave
+// if it failed
+func (h *LogicAppHandler) GetDeployment(w http.ResponseWriter, r *http.Request) {
+	dep, err := h.deployer.Deployment(r.Context(), mux.Vars(r)["id"])
+	if err == logicapp.ErrNotFound {
+		http.Error(w, "deployment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dep)
+}
+
+// RetryDeployment deploys a deployment again: a pending or failed one is
+// retried, a deployed one redeployed and a deleted one deployed anew
+func (h *LogicAppHandler) RetryDeployment(w http.ResponseWriter, r *http.Request) {
+	var body lifecycleRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.start(w, r, mux.Vars(r)["id"], body.PerformedBy)
+}
+
+func (h *LogicAppHandler) start(w http.ResponseWriter, r *http.Request, id, performedBy string) {
+	dep, err := h.deployer.Start(r.Context(), id, lifecycleActor(r, performedBy))
+	if err != nil {
+		writeLifecycleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(dep)
+}
+
+// lifecycleRequest is the body of a lifecycle action; all of it is
+// optional
+type lifecycleRequest struct {
+	PerformedBy string `json:"performedBy"`
+}
 
+// lifecycleActor returns who asked for a lifecycle action: the performedBy
+// field of the request and where the request came from. The routes are
+// not authenticated, so performedBy is recorded as claimed.
+func lifecycleActor(r *http.Request, performedBy string) logicapp.Actor {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
+		host = r.RemoteAddr
+	}
+	if net.ParseIP(host) == nil {
+		host = ""
+	}
+	return logicapp.Actor{Name: performedBy, Claimed: true, Address: host, UserAgent: r.UserAgent()}
+}
+
+// writeLifecycleError answers a failed lifecycle action. Parameter values
+// a template rejects are listed as JSON.
+func writeLifecycleError(w http.ResponseWriter, err error) {
+	var invalid *logicapp.ParametersError
+	var armErr *arm.Error
+	var graphErr *graph.Error
+	switch {
+	case errors.As(err, &invalid):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(invalid)
+	case errors.Is(err, logicapp.ErrNotFound), errors.Is(err, logicapp.ErrTemplateNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, logicapp.ErrDeploymentBusy), errors.Is(err, logicapp.ErrInvalidState),
+		errors.Is(err, logicapp.ErrNoIdentity), errors.Is(err, logicapp.ErrMissingConnection):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, logicapp.ErrNoDirectory):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	case errors.Is(err, logicapp.ErrInvalidRequest), errors.Is(err, logicapp.ErrInvalidTemplate):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.As(err, &armErr), errors.As(err, &graphErr):
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	default:
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// EnableDeployment turns the triggers of a disabled workflow back on
+func (h *LogicAppHandler) EnableDeployment(w http.ResponseWriter, r *http.Request) {
+	h.changeDeployment(w, r, h.deployer.Enable)
+}
+
+// DisableDeployment turns off the triggers of a deployed workflow
+func (h *LogicAppHandler) DisableDeployment(w http.ResponseWriter, r *http.Request) {
+	h.changeDeployment(w, r, h.deployer.Disable)
+}
+
+// DeleteDeployment deletes the workflow of a deployment from the client's
+// subscription. The deployment is kept as Deleted.
+func (h *LogicAppHandler) DeleteDeployment(w http.ResponseWriter, r *http.Request) {
+	h.changeDeployment(w, r, h.deployer.Delete)
+}
+
+func (h *LogicAppHandler) changeDeployment(w http.ResponseWriter, r *http.Request,
+	action func(context.Context, string, logicapp.Actor) (*logicapp.Deployment, error)) {
+	var body lifecycleRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	dep, err := action(r.Context(), mux.Vars(r)["id"], lifecycleActor(r, body.PerformedBy))
+	if err != nil {
+		writeLifecycleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dep)
+}
 
-	// TODO: Trigger actual Azure deployment here
+// UpgradeDeployment moves a deployment to another version of its template,
+// the active one by default, or redeploys it with other parameter values.
+// The changes to the workflow are returned; with dryRun nothing is
+// deployed.
+func (h *LogicAppHandler) UpgradeDeployment(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		logicapp.UpgradeRequest
+		lifecycleRequest
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	up, err := h.deployer.Upgrade(r.Context(), mux.Vars(r)["id"], body.UpgradeRequest,
+		lifecycleActor(r, body.PerformedBy))
+	if err != nil {
+		writeLifecycleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !up.DryRun {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(up)
+}
 
+// BulkAction enables, disables, upgrades, deletes or redeploys many
+// deployments at once, picked by ID or by template and client. The
+// outcome is listed for each deployment.
+func (h *LogicAppHandler) BulkAction(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		logicapp.BulkRequest
+		lifecycleRequest
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, err := h.deployer.Bulk(r.Context(), body.BulkRequest, lifecycleActor(r, body.PerformedBy))
+	if err != nil {
+		writeLifecycleError(w, err)
+		return
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"deploymentId": deploymentID,
-		"status":       "initiated",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"action":  body.Action,
+		"total":   len(results),
+		"failed":  failed,
+		"results": results,
 	})
 }
 
+// GetDeploymentAudit lists the lifecycle actions taken on a deployment,
+// who asked for them and how they ended
+func (h *LogicAppHandler) GetDeploymentAudit(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.deployer.Audit(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeLifecycleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// GetRuns lists polled workflow runs, newest first
+func (h *LogicAppHandler) GetRuns(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := logicapp.RunFilter{
+		DeploymentID: q.Get("deploymentId"),
+		ClientID:     q.Get("clientId"),
+		Status:       q.Get("status"),
+	}
+	for name, dst := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = n
+		}
+	}
+
+	runs, err := h.monitor.Runs(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+// GetRunStats returns the success rate and last failure of each client's
+// playbooks over the last days (30 by default)
+func (h *LogicAppHandler) GetRunStats(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid days", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	stats, err := h.monitor.Stats(r.Context(), r.URL.Query().Get("clientId"),
+		time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// GetAlerts lists open playbook failure alerts, or all of them with
+// ?all=true
+func (h *LogicAppHandler) GetAlerts(w http.ResponseWriter, r *http.Request) {
+	all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+	alerts, err := h.monitor.Alerts(r.Context(), !all)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// PollRuns polls the runs of every deployed workflow now
+func (h *LogicAppHandler) PollRuns(w http.ResponseWriter, r *http.Request) {
+	results, err := h.monitor.PollAll(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// PollDeploymentRuns polls the runs of one deployed workflow now
+func (h *LogicAppHandler) PollDeploymentRuns(w http.ResponseWriter, r *http.Request) {
+	result, err := h.monitor.Poll(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, logicapp.ErrNotFound) {
+		http.Error(w, "deployment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil && result.Error == "" {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A failed poll still reports the runs stored before it failed
+	w.Header().Set("Content-Type", "application/json")
+	if result.Error != "" {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetTemplatePermissions returns the app roles a template's managed
+// identity needs and a script granting them. The version query parameter
+// picks a version other than the active one.
+func (h *LogicAppHandler) GetTemplatePermissions(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	plan, err := h.deployer.PlanTemplate(r.Context(), name, r.URL.Query().Get("version"))
+	if err != nil {
+		writeLifecycleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*logicapp.PermissionPlan
+		Script string `json:"script"`
+	}{plan, logicapp.RemediationScript(name, "", plan.Roles)})
+}
+
+// GetDeploymentPermissions compares the app roles a deployment's managed
+// identity needs with those granted in the client's tenant
+func (h *LogicAppHandler) GetDeploymentPermissions(w http.ResponseWriter, r *http.Request) {
+	report, err := h.deployer.Permissions(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeLifecycleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GrantDeploymentPermissions grants a deployment's managed identity the app
+// roles it is missing
+func (h *LogicAppHandler) GrantDeploymentPermissions(w http.ResponseWriter, r *http.Request) {
+	var body lifecycleRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report, err := h.deployer.GrantPermissions(r.Context(), mux.Vars(r)["id"], lifecycleActor(r, body.PerformedBy))
+	if err != nil {
+		writeLifecycleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetPermissionScript returns a PowerShell script granting a deployment's
+// managed identity its app roles: those it is missing when its grants can
+// be read, otherwise all it needs
+func (h *LogicAppHandler) GetPermissionScript(w http.ResponseWriter, r *http.Request) {
+	plan, dep, err := h.deployer.Plan(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeLifecycleError(w, err)
+		return
+	}
+	roles := plan.Roles
+	if report, err := h.deployer.Permissions(r.Context(), dep.ID); err == nil {
+		roles = report.Missing
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="grant-`+dep.LogicAppName+`-permissions.ps1"`)
+	io.WriteString(w, logicapp.RemediationScript(dep.LogicAppName, dep.PrincipalID, roles))
+}
+
+// GetPermissionDrift checks the permissions of every deployed workflow
+// with a managed identity, or of one client's with clientId
+func (h *LogicAppHandler) GetPermissionDrift(w http.ResponseWriter, r *http.Request) {
+	reports, err := h.deployer.PermissionDrift(r.Context(), r.URL.Query().Get("clientId"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+// DisableLogicApp disables the workflows a client deployed under a name.
+// It is kept for callers that do not know deployment IDs; see
+// DisableDeployment.
 func (h *LogicAppHandler) DisableLogicApp(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		ClientID       string `json:"clientId"`
-		SubscriptionID string `json:"subscriptionId"`
-		ResourceGroup  string `json:"resourceGroup"`
-		LogicAppName   string `json:"logicAppName"`
+		ClientID     string `json:"clientId"`
+		LogicAppName string `json:"logicAppName"`
+		PerformedBy  string `json:"performedBy"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if req.ClientID == "" || req.LogicAppName == "" {
+		http.Error(w, "clientId and logicAppName are required", http.StatusBadRequest)
+		return
+	}
 
-	// Update deployment status
-	query := `
-        UPDATE deployment_mgmt.logic_app_deployments 
-        SET status = 'Disabled', updated_at = NOW()
-        WHERE client_id = $1 AND logic_app_name = $2
-    `
-
-	_, err := h.db.Exec(query, req.ClientID, req.LogicAppName)
+	rows, err := h.db.Query(`
+        SELECT id
+        FROM deployment_mgmt.logic_app_deployments
+        WHERE client_id::text = $1 AND logic_app_name = $2 AND status IN ($3, $4)
+    `, req.ClientID, req.LogicAppName, logicapp.StatusSuccess, logicapp.StatusDisabled)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		http.Error(w, "no deployed Logic App with that name", http.StatusNotFound)
+		return
+	}
 
-	// TODO: Trigger actual Azure disable operation here
+	results, err := h.deployer.Bulk(r.Context(), logicapp.BulkRequest{Action: logicapp.ActionDisable, DeploymentIDs: ids},
+		lifecycleActor(r, req.PerformedBy))
+	if err != nil {
+		writeLifecycleError(w, err)
+		return
+	}
+	status := "disabled"
+	for _, result := range results {
+		if result.Error != "" {
+			status = "failed"
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "disabled",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      status,
+		"deployments": results,
 	})
 }
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/defender"
+	"github.com/ClarityXDR/prod/website/backend/internal/response"
+	"github.com/gorilla/mux"
+)
+
+type ResponseHandler struct {
+	responder *response.Responder
+}
+
+func NewResponseHandler(responder *response.Responder) *ResponseHandler {
+	return &ResponseHandler{responder: responder}
+}
+
+// machineActions maps the machine routes to the actions they start
+var machineActions = map[string]string{
+	"isolate":         defender.ActionIsolate,
+	"unisolate":       defender.ActionUnisolate,
+	"scan":            defender.ActionRunAntiVirusScan,
+	"restrict":        defender.ActionRestrictCodeExecution,
+	"unrestrict":      defender.ActionUnrestrictCodeExecution,
+	"collect-package": defender.ActionCollectInvestigationPackage,
+}
+
+// RegisterRoutes registers the machine response action API
+func (h *ResponseHandler) RegisterRoutes(r *mux.Router) {
+	for route, actionType := range machineActions {
+		r.HandleFunc("/response/machines/{id}/"+route, h.startAction(actionType)).Methods("POST")
+	}
+	r.HandleFunc("/response/machines/{id}/actions", h.GetMachineActions).Methods("GET")
+	r.HandleFunc("/response/actions", h.GetActions).Methods("GET")
+	r.HandleFunc("/response/actions/poll", h.PollActions).Methods("POST")
+	r.HandleFunc("/response/actions/{id}", h.GetAction).Methods("GET")
+	r.HandleFunc("/response/actions/{id}/refresh", h.RefreshAction).Methods("POST")
+	r.HandleFunc("/response/actions/{id}/package", h.GetPackage).Methods("GET")
+	r.HandleFunc("/response/actions/{id}/ticket", h.LinkTicket).Methods("PUT")
+}
+
+// startAction returns a handler starting an action of a type on the
+// machine in the path. The body gives the client the machine belongs to
+// and the comment Defender records.
+func (h *ResponseHandler) startAction(actionType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req response.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.MachineID, req.Type = mux.Vars(r)["id"], actionType
+
+		action, err := h.responder.Start(r.Context(), req)
+		if err != nil {
+			writeResponseError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(action)
+	}
+}
+
+// GetMachineActions lists the actions taken on a machine
+func (h *ResponseHandler) GetMachineActions(w http.ResponseWriter, r *http.Request) {
+	h.listActions(w, r, mux.Vars(r)["id"])
+}
+
+// GetActions lists actions, filtered by clientId, machineId, type, status,
+// ticketId and open=true
+func (h *ResponseHandler) GetActions(w http.ResponseWriter, r *http.Request) {
+	h.listActions(w, r, r.URL.Query().Get("machineId"))
+}
+
+func (h *ResponseHandler) listActions(w http.ResponseWriter, r *http.Request, machineID string) {
+	q := r.URL.Query()
+	filter := response.Filter{
+		ClientID:  q.Get("clientId"),
+		MachineID: machineID,
+		Type:      q.Get("type"),
+		Status:    q.Get("status"),
+		Open:      q.Get("open") == "true",
+	}
+	if filter.Type != "" && !defender.IsActionType(filter.Type) {
+		http.Error(w, "unknown action type "+filter.Type, http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("ticketId"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid ticketId", http.StatusBadRequest)
+			return
+		}
+		filter.TicketID = id
+	}
+	for name, dst := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = n
+		}
+	}
+
+	actions, total, err := h.responder.Actions(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"actions": actions,
+		"total":   total,
+	})
+}
+
+// GetAction returns one action as last polled
+func (h *ResponseHandler) GetAction(w http.ResponseWriter, r *http.Request) {
+	action, err := h.responder.Action(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeResponseError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(action)
+}
+
+// RefreshAction polls Defender for an action's status now rather than
+// waiting for the background poll
+func (h *ResponseHandler) RefreshAction(w http.ResponseWriter, r *http.Request) {
+	action, err := h.responder.Refresh(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeResponseError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(action)
+}
+
+// PollActions polls every running action
+func (h *ResponseHandler) PollActions(w http.ResponseWriter, r *http.Request) {
+	results, err := h.responder.PollAll(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
+
+// GetPackage returns a short-lived download link for the investigation
+// package a collect-package action collected
+func (h *ResponseHandler) GetPackage(w http.ResponseWriter, r *http.Request) {
+	uri, err := h.responder.PackageURI(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeResponseError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"uri": uri})
+}
+
+// LinkTicket links an action to the ticket in the body
+func (h *ResponseHandler) LinkTicket(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TicketID int64 `json:"ticketId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TicketID <= 0 {
+		http.Error(w, "ticketId is required", http.StatusBadRequest)
+		return
+	}
+	action, err := h.responder.LinkTicket(r.Context(), mux.Vars(r)["id"], req.TicketID)
+	if err != nil {
+		writeResponseError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(action)
+}
+
+// writeResponseError maps response action errors to statuses. Defender
+// errors are the gateway's, except for machines it does not know.
+func writeResponseError(w http.ResponseWriter, err error) {
+	var apiErr *defender.Error
+	switch {
+	case errors.Is(err, response.ErrNotFound), errors.Is(err, response.ErrTicketNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, response.ErrInvalidRequest):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, response.ErrActionPending), errors.Is(err, response.ErrNoPackage):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case defender.IsNotFound(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.As(err, &apiErr):
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
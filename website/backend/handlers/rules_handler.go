@@ -3,20 +3,32 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
+	"github.com/ClarityXDR/prod/website/backend/internal/library"
+	"github.com/ClarityXDR/prod/website/backend/internal/sentinel"
+	"github.com/ClarityXDR/prod/website/backend/internal/sigma"
 	"github.com/gorilla/mux"
 )
 
 type RulesHandler struct {
 	*BaseHandler
+	library *library.Library
+	sigma   *sigma.Store
 }
 
-func NewRulesHandler(db *sql.DB) *RulesHandler {
-	return &RulesHandler{BaseHandler: NewBaseHandler(db)}
+func NewRulesHandler(db *sql.DB, lib *library.Library) *RulesHandler {
+	return &RulesHandler{BaseHandler: NewBaseHandler(db), library: lib, sigma: sigma.NewStore(db)}
 }
 
 func (h *RulesHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/rules/library", h.GetLibraryRules).Methods("GET")
+	r.HandleFunc("/rules/library/sentinel", h.ConvertToSentinel).Methods("POST")
+	r.HandleFunc("/rules/library/sentinel/template", h.ExportSentinelTemplate).Methods("POST")
+	r.HandleFunc("/rules/import/sigma", h.ImportSigma).Methods("POST")
+	r.HandleFunc("/rules/{id}/sigma", h.ExportSigma).Methods("GET")
 	r.HandleFunc("/rules", h.GetRules).Methods("GET")
 	r.HandleFunc("/rules/{id}", h.GetRule).Methods("GET")
 }
@@ -36,3 +48,177 @@ func (h *RulesHandler) GetRule(w http.ResponseWriter, r *http.Request) {
 		"name": "Sample Rule",
 	})
 }
+
+// GetLibraryRules lists the rules of the detection rule library
+func (h *RulesHandler) GetLibraryRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.library.Rules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rules == nil {
+		rules = []*library.Rule{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// ConvertToSentinel converts library rules to Sentinel analytics rules,
+// which can be added to a deployment's analyticsRules. Without ruleIds
+// every rule with a query is converted.
+func (h *RulesHandler) ConvertToSentinel(w http.ResponseWriter, r *http.Request) {
+	conversions, failures, ok := h.convert(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rules":  conversions,
+		"errors": failures,
+	})
+}
+
+// ExportSentinelTemplate returns the converted rules as an ARM template.
+// A rule that cannot be converted fails the export rather than being left
+// out of the template.
+func (h *RulesHandler) ExportSentinelTemplate(w http.ResponseWriter, r *http.Request) {
+	conversions, failures, ok := h.convert(w, r)
+	if !ok {
+		return
+	}
+	if len(failures) > 0 {
+		http.Error(w, failures[0].Error, http.StatusBadRequest)
+		return
+	}
+	rules := make([]sentinel.AnalyticsRule, len(conversions))
+	for i, c := range conversions {
+		rules[i] = c.Rule
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="sentinel-analytics-rules.json"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	enc.Encode(sentinel.AnalyticsRulesTemplate(rules))
+}
+
+// ImportSigma converts the Sigma rules of a YAML upload to KQL and saves
+// them as master rules. The target query parameter picks Defender or
+// Sentinel tables, and dryRun converts without saving. Rules that cannot
+// be converted are reported per rule rather than failing the upload.
+func (h *RulesHandler) ImportSigma(w http.ResponseWriter, r *http.Request) {
+	target := sigma.Defender
+	if t := r.URL.Query().Get("target"); t != "" {
+		target = sigma.Target(t)
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+
+	results, err := h.sigma.Import(r.Context(), http.MaxBytesReader(w, r.Body, sigma.MaxUploadSize), target, dryRun)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, sigma.ErrInvalidRule), errors.Is(err, sigma.ErrUnsupported):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	imported, failed := 0, 0
+	for _, res := range results {
+		if res.Error != "" {
+			failed++
+		} else {
+			imported++
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"target":   target,
+		"dryRun":   dryRun,
+		"imported": imported,
+		"failed":   failed,
+		"rules":    results,
+	})
+}
+
+// ExportSigma returns a master rule as a Sigma rule with the warnings of
+// the export
+func (h *RulesHandler) ExportSigma(w http.ResponseWriter, r *http.Request) {
+	export, err := h.sigma.Export(r.Context(), mux.Vars(r)["id"])
+	switch {
+	case errors.Is(err, sigma.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, sigma.ErrInvalidRule), errors.Is(err, sigma.ErrUnsupported):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(export)
+}
+
+type conversionFailure struct {
+	RuleID string `json:"ruleId"`
+	Error  string `json:"error"`
+}
+
+// convert converts the rules a request names. Requested rules that cannot
+// be converted are failures; when converting the whole library, rules
+// without a query are left out.
+func (h *RulesHandler) convert(w http.ResponseWriter, r *http.Request) ([]*library.Conversion, []conversionFailure, bool) {
+	var req struct {
+		RuleIDs []string `json:"ruleIds"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, nil, false
+		}
+	}
+
+	all, err := h.library.Rules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	rules := all
+	if len(req.RuleIDs) > 0 {
+		byID := make(map[string]*library.Rule, len(all))
+		for _, rule := range all {
+			byID[rule.ID] = rule
+		}
+		rules = nil
+		for _, id := range req.RuleIDs {
+			rule, ok := byID[id]
+			if !ok {
+				http.Error(w, "unknown rule "+id, http.StatusNotFound)
+				return nil, nil, false
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	conversions := []*library.Conversion{}
+	failures := []conversionFailure{}
+	for _, rule := range rules {
+		c, err := library.ToSentinel(rule)
+		switch {
+		case err == nil:
+			conversions = append(conversions, c)
+		case len(req.RuleIDs) == 0 && rule.Query == "":
+		case errors.Is(err, library.ErrNotConvertible):
+			failures = append(failures, conversionFailure{RuleID: rule.ID, Error: err.Error()})
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil, nil, false
+		}
+	}
+	return conversions, failures, true
+}
@@ -1,32 +1,328 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/ClarityXDR/prod/website/backend/internal/library"
+	"github.com/ClarityXDR/prod/website/backend/internal/sentinel"
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 )
 
 type SentinelHandler struct {
 	*BaseHandler
+	syncer   *sentinel.Syncer
+	deployer *sentinel.Deployer
+	library  *library.Library
 }
 
-func NewSentinelHandler(db *sql.DB) *SentinelHandler {
-	return &SentinelHandler{BaseHandler: NewBaseHandler(db)}
+func NewSentinelHandler(db *sql.DB, syncer *sentinel.Syncer, deployer *sentinel.Deployer,
+	lib *library.Library) *SentinelHandler {
+	return &SentinelHandler{BaseHandler: NewBaseHandler(db), syncer: syncer, deployer: deployer, library: lib}
 }
 
 func (h *SentinelHandler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/sentinel/workspaces", h.GetWorkspaces).Methods("GET")
+	r.HandleFunc("/sentinel/workspaces/{id}/sync", h.SyncWorkspace).Methods("POST")
 	r.HandleFunc("/sentinel/incidents", h.GetIncidents).Methods("GET")
+	r.HandleFunc("/sentinel/incidents/{id}", h.GetIncident).Methods("GET")
+	r.HandleFunc("/sentinel/incidents/{id}", h.UpdateIncident).Methods("PATCH")
+	r.HandleFunc("/sentinel/deployments", h.GetDeployments).Methods("GET")
+	r.HandleFunc("/sentinel/deployments", h.CreateDeployment).Methods("POST")
+	r.HandleFunc("/sentinel/deployments/{id}", h.GetDeployment).Methods("GET")
+	r.HandleFunc("/sentinel/deployments/{id}/resume", h.ResumeDeployment).Methods("POST")
+	r.HandleFunc("/sentinel/deployments/{id}/teardown", h.TeardownDeployment).Methods("POST")
+	r.HandleFunc("/sentinel/validate", h.ValidateConfig).Methods("POST")
 }
 
+// GetWorkspaces lists the workspaces registered in sentinel_deployments
+// with their incident sync state
 func (h *SentinelHandler) GetWorkspaces(w http.ResponseWriter, r *http.Request) {
+	workspaces, err := h.syncer.Workspaces(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspaces)
+}
+
+// SyncWorkspace syncs the incidents of a workspace now
+func (h *SentinelHandler) SyncWorkspace(w http.ResponseWriter, r *http.Request) {
+	result, err := h.syncer.Sync(r.Context(), mux.Vars(r)["id"])
+	if err == sentinel.ErrNotFound {
+		http.Error(w, "workspace not found", http.StatusNotFound)
+		return
+	}
+	if err != nil && result.Error == "" {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A failed sync still reports the incidents stored before it failed
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode([]interface{}{})
+	if result.Error != "" {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(result)
 }
 
+// GetIncidents lists synced incidents. status and severity take one or
+// more comma-separated values.
 func (h *SentinelHandler) GetIncidents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := sentinel.IncidentFilter{
+		DeploymentID: q.Get("workspaceId"),
+		ClientID:     q.Get("clientId"),
+		Statuses:     splitList(q.Get("status")),
+		Severities:   splitList(q.Get("severity")),
+		Owner:        q.Get("owner"),
+	}
+	for name, dst := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = n
+		}
+	}
+
+	incidents, total, err := h.syncer.Incidents(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"incidents": incidents,
+		"total":     total,
+	})
+}
+
+// GetIncident returns a synced incident with its alerts and entities
+func (h *SentinelHandler) GetIncident(w http.ResponseWriter, r *http.Request) {
+	detail, err := h.syncer.Incident(r.Context(), mux.Vars(r)["id"])
+	if err == sentinel.ErrNotFound {
+		http.Error(w, "incident not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode([]interface{}{})
+	json.NewEncoder(w).Encode(detail)
+}
+
+// UpdateIncident writes a status, severity, classification or owner
+// change back to Sentinel
+func (h *SentinelHandler) UpdateIncident(w http.ResponseWriter, r *http.Request) {
+	var update sentinel.IncidentUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	inc, err := h.syncer.UpdateIncident(r.Context(), mux.Vars(r)["id"], update)
+	switch {
+	case err == nil:
+	case err == sentinel.ErrNotFound:
+		http.Error(w, "incident not found", http.StatusNotFound)
+		return
+	case err == sentinel.ErrConflict:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, sentinel.ErrInvalidUpdate):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inc)
+}
+
+// GetDeployments lists Sentinel deployments, optionally of one client
+func (h *SentinelHandler) GetDeployments(w http.ResponseWriter, r *http.Request) {
+	deployments, err := h.deployer.Deployments(r.Context(), r.URL.Query().Get("clientId"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deployments)
+}
+
+// CreateDeployment validates a configuration and starts deploying it. The
+// deployment runs in the background; poll it for step progress.
+func (h *SentinelHandler) CreateDeployment(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ClientID      string                    `json:"clientId"`
+		Configuration sentinel.DeploymentConfig `json:"configuration"`
+		// LibraryRules are rule library IDs to convert and add to the
+		// configuration's analytics rules
+		LibraryRules []string `json:"libraryRules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.addLibraryRules(w, &req.Configuration, req.LibraryRules) {
+		return
+	}
+	if req.ClientID == "" {
+		http.Error(w, "clientId is required", http.StatusBadRequest)
+		return
+	}
+
+	dep, err := h.deployer.Create(r.Context(), req.ClientID, req.Configuration)
+	var invalid *sentinel.ValidationError
+	var pqErr *pq.Error
+	switch {
+	case err == nil:
+	case errors.As(err, &invalid):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(invalid.Result)
+		return
+	case errors.As(err, &pqErr) && (pqErr.Code == "23503" || pqErr.Code == "22P02"):
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.startDeployment(w, r, dep.ID, h.deployer.Start)
+}
+
+// ValidateConfig checks a deployment configuration without deploying it.
+// With a clientId, data connectors are checked against the client's
+// license features.
+func (h *SentinelHandler) ValidateConfig(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ClientID      string                    `json:"clientId"`
+		Configuration sentinel.DeploymentConfig `json:"configuration"`
+		// LibraryRules are rule library IDs to convert and add to the
+		// configuration's analytics rules
+		LibraryRules []string `json:"libraryRules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.addLibraryRules(w, &req.Configuration, req.LibraryRules) {
+		return
+	}
+
+	result, err := h.deployer.Validate(r.Context(), req.ClientID, &req.Configuration)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetDeployment returns a deployment with the status of each step
+func (h *SentinelHandler) GetDeployment(w http.ResponseWriter, r *http.Request) {
+	dep, err := h.deployer.Deployment(r.Context(), mux.Vars(r)["id"])
+	if err == sentinel.ErrNotFound {
+		http.Error(w, "deployment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dep)
+}
+
+// ResumeDeployment runs the steps of a deployment that have not succeeded
+func (h *SentinelHandler) ResumeDeployment(w http.ResponseWriter, r *http.Request) {
+	h.startDeployment(w, r, mux.Vars(r)["id"], h.deployer.Start)
+}
+
+// TeardownDeployment deletes the resources a deployment created
+func (h *SentinelHandler) TeardownDeployment(w http.ResponseWriter, r *http.Request) {
+	h.startDeployment(w, r, mux.Vars(r)["id"], h.deployer.StartTeardown)
+}
+
+func (h *SentinelHandler) startDeployment(w http.ResponseWriter, r *http.Request, id string,
+	start func(context.Context, string) (*sentinel.Deployment, error)) {
+	dep, err := start(r.Context(), id)
+	switch {
+	case err == nil:
+	case err == sentinel.ErrNotFound:
+		http.Error(w, "deployment not found", http.StatusNotFound)
+		return
+	case err == sentinel.ErrDeploymentBusy || errors.Is(err, sentinel.ErrDeploymentState):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(dep)
+}
+
+// splitList splits a comma-separated query value, dropping empty items
+// addLibraryRules converts library rules and adds them to a configuration
+func (h *SentinelHandler) addLibraryRules(w http.ResponseWriter, cfg *sentinel.DeploymentConfig, ids []string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	rules, err := h.library.Rules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	byID := make(map[string]*library.Rule, len(rules))
+	for _, rule := range rules {
+		byID[rule.ID] = rule
+	}
+	for _, id := range ids {
+		rule, ok := byID[id]
+		if !ok {
+			http.Error(w, "unknown library rule "+id, http.StatusBadRequest)
+			return false
+		}
+		c, err := library.ToSentinel(rule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+		cfg.AnalyticsRules = append(cfg.AnalyticsRules, c.Rule)
+	}
+	return true
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
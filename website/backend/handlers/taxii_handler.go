@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/taxii"
+	"github.com/gorilla/mux"
+)
+
+type TAXIIHandler struct {
+	db          *sql.DB
+	server      *taxii.Server
+	credentials *taxii.CredentialStore
+}
+
+func NewTAXIIHandler(db *sql.DB, indicators *indicator.Store) *TAXIIHandler {
+	credentials := taxii.NewCredentialStore(db)
+	return &TAXIIHandler{
+		db:          db,
+		server:      taxii.NewServer(taxii.NewIndicatorStore(db, indicators), credentials),
+		credentials: credentials,
+	}
+}
+
+// RegisterRoutes registers the credential management API
+func (h *TAXIIHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/threat-intel/taxii/credentials", h.GetCredentials).Methods("GET")
+	r.HandleFunc("/threat-intel/taxii/credentials", h.CreateCredential).Methods("POST")
+	r.HandleFunc("/threat-intel/taxii/credentials/{id}", h.RevokeCredential).Methods("DELETE")
+}
+
+// RegisterServerRoutes mounts the TAXII 2.1 server at /taxii2/ on the root router
+func (h *TAXIIHandler) RegisterServerRoutes(r *mux.Router) {
+	h.server.RegisterRoutes(r)
+}
+
+func (h *TAXIIHandler) GetCredentials(w http.ResponseWriter, r *http.Request) {
+	query := `
+        SELECT t.id, t.client_id, COALESCE(c.name, ''), t.username,
+            COALESCE(t.description, ''), t.is_active, t.created_at, t.last_used_at
+        FROM deployment_mgmt.taxii_credentials t
+        LEFT JOIN client_mgmt.clients c ON t.client_id = c.id
+        ORDER BY t.created_at DESC
+    `
+
+	rows, err := h.db.Query(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var credentials []map[string]interface{}
+	for rows.Next() {
+		var id, clientName, username, description string
+		var clientID sql.NullString
+		var isActive bool
+		var createdAt time.Time
+		var lastUsedAt sql.NullTime
+
+		if err := rows.Scan(&id, &clientID, &clientName, &username,
+			&description, &isActive, &createdAt, &lastUsedAt); err != nil {
+			continue
+		}
+
+		credentials = append(credentials, map[string]interface{}{
+			"id":          id,
+			"clientId":    clientID.String,
+			"clientName":  clientName,
+			"username":    username,
+			"description": description,
+			"isActive":    isActive,
+			"createdAt":   createdAt,
+			"lastUsedAt":  lastUsedAt.Time,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credentials)
+}
+
+func (h *TAXIIHandler) CreateCredential(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ClientID    string `json:"clientId"`
+		Username    string `json:"username"`
+		Description string `json:"description"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+
+	id, password, err := h.credentials.Create(r.Context(), req.ClientID, req.Username, req.Description)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The password is not stored in clear text and cannot be retrieved later
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":        id,
+		"username":  req.Username,
+		"password":  password,
+		"discovery": "/taxii2/",
+	})
+}
+
+func (h *TAXIIHandler) RevokeCredential(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := h.credentials.Revoke(r.Context(), id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Credential not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "revoked",
+	})
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/actors"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// maxEntityBundleSize caps STIX bundles uploaded to the entity import
+const maxEntityBundleSize = 64 << 20
+
+type ThreatActorHandler struct {
+	store    *actors.Store
+	importer *actors.Importer
+}
+
+func NewThreatActorHandler(db *sql.DB, indicators *indicator.Store) *ThreatActorHandler {
+	store := actors.NewStore(db, indicators)
+	return &ThreatActorHandler{
+		store:    store,
+		importer: actors.NewImporter(store, indicators),
+	}
+}
+
+// RegisterRoutes registers the threat actor, campaign and malware API
+func (h *ThreatActorHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/threat-intel/entities", h.GetEntities).Methods("GET")
+	r.HandleFunc("/threat-intel/entities", h.CreateEntity).Methods("POST")
+	r.HandleFunc("/threat-intel/entities/import", h.ImportEntities).Methods("POST")
+	r.HandleFunc("/threat-intel/entities/resolve", h.ResolveEntity).Methods("GET")
+	r.HandleFunc("/threat-intel/entities/{id}", h.GetEntity).Methods("GET")
+	r.HandleFunc("/threat-intel/entities/{id}", h.UpdateEntity).Methods("PUT")
+	r.HandleFunc("/threat-intel/entities/{id}", h.DeleteEntity).Methods("DELETE")
+	r.HandleFunc("/threat-intel/entities/{id}/profile", h.GetProfile).Methods("GET")
+	r.HandleFunc("/threat-intel/entities/{id}/links", h.LinkEntity).Methods("POST")
+	r.HandleFunc("/threat-intel/entities/{id}/links", h.UnlinkEntity).Methods("DELETE")
+}
+
+// GetEntities lists entities, filtered by kind and by a name or alias
+// search
+func (h *ThreatActorHandler) GetEntities(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := actors.Filter{Kind: q.Get("kind"), Query: q.Get("q")}
+	if filter.Kind != "" && !actors.IsKind(filter.Kind) {
+		http.Error(w, fmt.Sprintf("unknown entity kind %q", filter.Kind), http.StatusBadRequest)
+		return
+	}
+	for name, dst := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = n
+		}
+	}
+
+	entities, total, err := h.store.List(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entities": entities,
+		"total":    total,
+	})
+}
+
+// ResolveEntity finds an entity by ID, STIX identifier, name or alias,
+// such as ?name=APT28 for an actor tracked as Fancy Bear
+func (h *ThreatActorHandler) ResolveEntity(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	e, err := h.store.Resolve(r.Context(), name)
+	h.writeEntity(w, r, e, err, http.StatusOK)
+}
+
+// GetEntity returns an entity, as a STIX object with format=stix
+func (h *ThreatActorHandler) GetEntity(w http.ResponseWriter, r *http.Request) {
+	e, err := h.store.Get(r.Context(), mux.Vars(r)["id"])
+	h.writeEntity(w, r, e, err, http.StatusOK)
+}
+
+func (h *ThreatActorHandler) CreateEntity(w http.ResponseWriter, r *http.Request) {
+	var e actors.Entity
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.ID = ""
+	h.saveEntity(w, r, &e, http.StatusCreated)
+}
+
+func (h *ThreatActorHandler) UpdateEntity(w http.ResponseWriter, r *http.Request) {
+	var e actors.Entity
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.ID = mux.Vars(r)["id"]
+	h.saveEntity(w, r, &e, http.StatusOK)
+}
+
+func (h *ThreatActorHandler) saveEntity(w http.ResponseWriter, r *http.Request, e *actors.Entity, status int) {
+	if err := e.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err := h.store.Save(r.Context(), e)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+		http.Error(w, fmt.Sprintf("another %s is already named %q or has the same STIX id", e.Kind, e.Name),
+			http.StatusConflict)
+		return
+	}
+	h.writeEntity(w, r, e, err, status)
+}
+
+func (h *ThreatActorHandler) DeleteEntity(w http.ResponseWriter, r *http.Request) {
+	err := h.store.Delete(r.Context(), mux.Vars(r)["id"])
+	if err == actors.ErrNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetProfile returns everything known about an entity: related entities
+// and the indicators, MDE rules and tickets linked to it or to them
+func (h *ThreatActorHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	profile, err := h.store.Profile(r.Context(), mux.Vars(r)["id"])
+	if err == actors.ErrNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// LinkEntity links indicators, MDE master rules, tickets and other
+// entities to an entity
+func (h *ThreatActorHandler) LinkEntity(w http.ResponseWriter, r *http.Request) {
+	h.changeLinks(w, r, h.store.Link)
+}
+
+// UnlinkEntity removes links made by LinkEntity
+func (h *ThreatActorHandler) UnlinkEntity(w http.ResponseWriter, r *http.Request) {
+	h.changeLinks(w, r, h.store.Unlink)
+}
+
+func (h *ThreatActorHandler) changeLinks(w http.ResponseWriter, r *http.Request,
+	change func(ctx context.Context, id string, links actors.Links) error) {
+	var links actors.Links
+	if err := json.NewDecoder(r.Body).Decode(&links); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if err := change(r.Context(), id, links); err == actors.ErrNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	profile, err := h.store.Profile(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// ImportEntities imports intrusion sets, threat actors, campaigns, malware,
+// indicators and the relationships between them from a STIX bundle
+func (h *ThreatActorHandler) ImportEntities(w http.ResponseWriter, r *http.Request) {
+	result, err := h.importer.Import(r.Context(), http.MaxBytesReader(w, r.Body, maxEntityBundleSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *ThreatActorHandler) writeEntity(w http.ResponseWriter, r *http.Request, e *actors.Entity, err error, status int) {
+	switch err {
+	case nil:
+	case actors.ErrNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if r.URL.Query().Get("format") == "stix" {
+		json.NewEncoder(w).Encode(e.STIX())
+		return
+	}
+	json.NewEncoder(w).Encode(e)
+}
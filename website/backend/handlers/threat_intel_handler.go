@@ -1,28 +1,96 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/stix"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/bulk"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/distribution"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/enrichment"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/feeds"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/sightings"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/stats"
+	"github.com/ClarityXDR/prod/website/backend/internal/validation"
 	"github.com/gorilla/mux"
 )
 
 type ThreatIntelHandler struct {
-	db *sql.DB
+	db            *sql.DB
+	feedScheduler *feeds.Scheduler
+	normalizer    *indicator.Normalizer
+	indicators    *indicator.Store
+	importer      *bulk.Importer
+	importJobs    *bulk.JobStore
+	exporter      *bulk.Exporter
+	distributor   *distribution.Distributor
+	enricher      *enrichment.Enricher
+	enrichments   *enrichment.DBCache
+	hunter        *sightings.Hunter
+	stats         *stats.Collector
 }
 
-func NewThreatIntelHandler(db *sql.DB) *ThreatIntelHandler {
-	return &ThreatIntelHandler{db: db}
+func NewThreatIntelHandler(db *sql.DB, indicators *indicator.Store, feedScheduler *feeds.Scheduler, importer *bulk.Importer, distributor *distribution.Distributor, enricher *enrichment.Enricher, hunter *sightings.Hunter) *ThreatIntelHandler {
+	return &ThreatIntelHandler{
+		db:            db,
+		feedScheduler: feedScheduler,
+		normalizer:    indicator.NewNormalizer(),
+		indicators:    indicators,
+		importer:      importer,
+		importJobs:    bulk.NewJobStore(db),
+		exporter:      bulk.NewExporter(indicators),
+		distributor:   distributor,
+		enricher:      enricher,
+		enrichments:   enrichment.NewDBCache(db),
+		hunter:        hunter,
+		stats:         stats.NewCollector(db),
+	}
 }
 
 func (h *ThreatIntelHandler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/threat-intel/clients", h.GetClients).Methods("GET")
 	r.HandleFunc("/threat-intel/indicators", h.GetIndicators).Methods("GET")
 	r.HandleFunc("/threat-intel/indicators", h.AddIndicator).Methods("POST")
+	r.HandleFunc("/threat-intel/indicators/bulk", h.AddIndicatorsBulk).Methods("POST")
+	r.HandleFunc("/threat-intel/indicators/import", h.ImportIndicators).Methods("POST")
+	r.HandleFunc("/threat-intel/indicators/import/{id}", h.GetImportJob).Methods("GET")
+	r.HandleFunc("/threat-intel/indicators/export", h.ExportIndicators).Methods("GET")
+	r.HandleFunc("/threat-intel/indicators/refresh", h.RefreshIndicators).Methods("POST")
+	r.HandleFunc("/threat-intel/indicators/extend", h.ExtendIndicators).Methods("POST")
+	r.HandleFunc("/threat-intel/indicators/quarantine", h.GetQuarantine).Methods("GET")
+	r.HandleFunc("/threat-intel/indicators/{id}/release", h.ReleaseIndicator).Methods("POST")
+	r.HandleFunc("/threat-intel/indicators/{id}/false-positive", h.MarkFalsePositive).Methods("POST")
+	r.HandleFunc("/threat-intel/indicators/{id}/distribution", h.GetIndicatorDistribution).Methods("GET")
+	r.HandleFunc("/threat-intel/indicators/{id}/enrichment", h.GetIndicatorEnrichment).Methods("GET")
+	r.HandleFunc("/threat-intel/indicators/{id}/enrich", h.EnrichIndicator).Methods("POST")
+	r.HandleFunc("/threat-intel/allowlist", h.GetAllowList).Methods("GET")
+	r.HandleFunc("/threat-intel/allowlist", h.AddAllowListEntry).Methods("POST")
+	r.HandleFunc("/threat-intel/allowlist/{id}", h.DeleteAllowListEntry).Methods("DELETE")
+	r.HandleFunc("/threat-intel/distribution/routes", h.GetDistributionRoutes).Methods("GET")
+	r.HandleFunc("/threat-intel/distribution/routes", h.UpdateDistributionRoutes).Methods("PUT")
+	r.HandleFunc("/threat-intel/distribution/targets", h.GetDistributionTargets).Methods("GET")
+	r.HandleFunc("/threat-intel/distribution/targets", h.UpdateDistributionTarget).Methods("PUT")
+	r.HandleFunc("/threat-intel/distribution/sync", h.SyncDistribution).Methods("POST")
+	r.HandleFunc("/threat-intel/sightings", h.GetSightings).Methods("GET")
+	r.HandleFunc("/threat-intel/sightings/hunt", h.HuntSightings).Methods("POST")
+	r.HandleFunc("/threat-intel/sightings/{id}", h.GetSighting).Methods("GET")
+	r.HandleFunc("/threat-intel/sightings/{id}/ticket", h.RaiseSightingTicket).Methods("POST")
 	r.HandleFunc("/threat-intel/feeds", h.GetFeeds).Methods("GET")
+	r.HandleFunc("/threat-intel/feeds/{id}/sync", h.SyncFeed).Methods("POST")
 	r.HandleFunc("/threat-intel/stats", h.GetStats).Methods("GET")
+	r.HandleFunc("/threat-intel/stats/stream", h.StreamStats).Methods("GET")
 	r.HandleFunc("/threat-intel/sync-sentinel", h.SyncToSentinel).Methods("POST")
 }
 
@@ -58,56 +126,109 @@ func (h *ThreatIntelHandler) GetClients(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(clients)
 }
 
+// GetIndicators searches indicators. Filters are given as query
+// parameters and list parameters take several values, repeated or comma
+// separated. The first page also carries the total and facet counts unless
+// facets=false; later pages are fetched by passing back the cursor.
 func (h *ThreatIntelHandler) GetIndicators(w http.ResponseWriter, r *http.Request) {
-	query := `
-        SELECT 
-            id,
-            client_id,
-            indicator_type,
-            indicator_value,
-            threat_type,
-            confidence,
-            source,
-            created_at
-        FROM deployment_mgmt.threat_indicators
-        WHERE is_active = true
-        ORDER BY created_at DESC
-        LIMIT 1000
-    `
+	params := r.URL.Query()
+	list := func(name string) []string {
+		var values []string
+		for _, v := range params[name] {
+			for _, part := range strings.Split(v, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					values = append(values, part)
+				}
+			}
+		}
+		return values
+	}
 
-	rows, err := h.db.Query(query)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	q := indicator.Query{
+		ClientID:        params.Get("clientId"),
+		ThreatTypes:     list("threatType"),
+		Sources:         list("source"),
+		Tags:            list("tag"),
+		Text:            params.Get("q"),
+		ValuePrefix:     params.Get("prefix"),
+		IncludeInactive: params.Get("includeInactive") == "true",
+		Cursor:          params.Get("cursor"),
+		Facets:          params.Get("cursor") == "" && params.Get("facets") != "false",
+	}
+	if q.ClientID != "" && validation.ValidateUUID(q.ClientID) != nil {
+		http.Error(w, "clientId must be a UUID", http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
-
-	var indicators []map[string]interface{}
-	for rows.Next() {
-		var id, indicatorType, indicatorValue, threatType, source string
-		var clientID sql.NullString
-		var confidence int
-		var createdAt time.Time
+	for _, name := range list("type") {
+		t, ok := indicator.ParseType(name)
+		if !ok {
+			http.Error(w, "unknown indicator type: "+name, http.StatusBadRequest)
+			return
+		}
+		q.Types = append(q.Types, t)
+	}
 
-		if err := rows.Scan(&id, &clientID, &indicatorType, &indicatorValue,
-			&threatType, &confidence, &source, &createdAt); err != nil {
-			continue
+	for name, dst := range map[string]**int{"minConfidence": &q.MinConfidence, "maxConfidence": &q.MaxConfidence} {
+		if v := params.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > 100 {
+				http.Error(w, name+" must be between 0 and 100", http.StatusBadRequest)
+				return
+			}
+			*dst = &n
 		}
+	}
+	for name, dst := range map[string]*time.Time{
+		"createdAfter":  &q.CreatedAfter,
+		"createdBefore": &q.CreatedBefore,
+		"expiresAfter":  &q.ExpiresAfter,
+		"expiresBefore": &q.ExpiresBefore,
+	} {
+		if v := params.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, name+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+	if v := params.Get("within"); v != "" {
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			http.Error(w, "within must be a CIDR range", http.StatusBadRequest)
+			return
+		}
+		q.Within = network
+	}
 
-		indicators = append(indicators, map[string]interface{}{
-			"id":         id,
-			"clientId":   clientID.String,
-			"type":       indicatorType,
-			"value":      indicatorValue,
-			"threatType": threatType,
-			"confidence": confidence,
-			"source":     source,
-			"createdAt":  createdAt,
-		})
+	result, err := h.indicators.Search(r.Context(), q)
+	if err == indicator.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(indicators)
+	json.NewEncoder(w).Encode(result)
 }
 
 func (h *ThreatIntelHandler) AddIndicator(w http.ResponseWriter, r *http.Request) {
@@ -126,33 +247,359 @@ func (h *ThreatIntelHandler) AddIndicator(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	query := `
-        INSERT INTO deployment_mgmt.threat_indicators 
-        (client_id, indicator_type, indicator_value, threat_type, confidence, source, description)
-        VALUES ($1, $2, $3, $4, $5, $6, $7)
-        ON CONFLICT (client_id, indicator_type, indicator_value) 
-        DO UPDATE SET 
-            threat_type = $4,
-            confidence = $5,
-            updated_at = NOW()
-    `
+	// Type may be omitted, in which case it is detected from the value
+	indicatorType, value, err := h.normalizer.Normalize(req.Type, req.Value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stored, err := h.indicators.Upsert(r.Context(), []indicator.Indicator{{
+		ClientID:    req.ClientID,
+		Type:        indicatorType,
+		Value:       value,
+		ThreatType:  req.ThreatType,
+		Confidence:  req.Confidence,
+		Source:      req.Source,
+		Description: req.Description,
+	}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Allow-listed values are stored for review rather than deployed
+	status := "added"
+	if stored.Quarantined > 0 {
+		status = indicator.ReviewQuarantined
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": status,
+		"type":   string(indicatorType),
+		"value":  value,
+	})
+}
+
+// maxBulkIndicators bounds a single JSON bulk submission
+const maxBulkIndicators = 1000
+
+func (h *ThreatIntelHandler) AddIndicatorsBulk(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ClientID   string `json:"clientId"`
+		ThreatType string `json:"threatType"`
+		Confidence int    `json:"confidence"`
+		Source     string `json:"source"`
+		Indicators []struct {
+			Type        string `json:"type"`
+			Value       string `json:"value"`
+			ThreatType  string `json:"threatType"`
+			Confidence  int    `json:"confidence"`
+			Description string `json:"description"`
+		} `json:"indicators"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Indicators) > maxBulkIndicators {
+		http.Error(w, fmt.Sprintf("at most %d indicators can be submitted at once", maxBulkIndicators),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
 
-	var clientID interface{} = req.ClientID
-	if req.ClientID == "" {
-		clientID = nil
+	type itemResult struct {
+		Index  int    `json:"index"`
+		Input  string `json:"input"`
+		Type   string `json:"type,omitempty"`
+		Value  string `json:"value,omitempty"`
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
 	}
 
-	_, err := h.db.Exec(query, clientID, req.Type, req.Value,
-		req.ThreatType, req.Confidence, req.Source, req.Description)
+	results := make([]itemResult, len(req.Indicators))
+	var accepted []indicator.Indicator
+	seen := make(map[string]int)
+	for i, item := range req.Indicators {
+		results[i] = itemResult{Index: i, Input: item.Value}
+
+		indicatorType, value, err := h.normalizer.Normalize(item.Type, item.Value)
+		if err != nil {
+			results[i].Status = "rejected"
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Type, results[i].Value = string(indicatorType), value
 
+		ind := indicator.Indicator{
+			ClientID:    req.ClientID,
+			Type:        indicatorType,
+			Value:       value,
+			ThreatType:  item.ThreatType,
+			Confidence:  item.Confidence,
+			Source:      req.Source,
+			Description: item.Description,
+		}
+		if ind.ThreatType == "" {
+			ind.ThreatType = req.ThreatType
+		}
+		if ind.Confidence == 0 {
+			ind.Confidence = req.Confidence
+		}
+		if first, ok := seen[ind.Key()]; ok {
+			results[i].Status = "duplicate"
+			results[i].Error = fmt.Sprintf("same indicator as item %d", first)
+			continue
+		}
+		seen[ind.Key()] = i
+		results[i].Status = "accepted"
+		accepted = append(accepted, ind)
+	}
+
+	stored, err := h.indicators.Upsert(r.Context(), accepted)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "added",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"submitted":   len(req.Indicators),
+		"accepted":    len(accepted),
+		"inserted":    stored.Inserted,
+		"updated":     stored.Updated,
+		"quarantined": stored.Quarantined,
+		"results":     results,
+	})
+}
+
+// ImportIndicators accepts a CSV, plain-text, STIX bundle or MDE indicator
+// CSV file, either as the raw request body or as the "file" field of a
+// multipart form, and imports it in the background. With dryRun=true the
+// file is only validated and the job reports a preview of what would be
+// stored.
+func (h *ThreatIntelHandler) ImportIndicators(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := bulk.Options{
+		Format:     q.Get("format"),
+		ClientID:   q.Get("clientId"),
+		Source:     q.Get("source"),
+		ThreatType: q.Get("threatType"),
+		DryRun:     q.Get("dryRun") == "true",
+	}
+	if v := q.Get("confidence"); v != "" {
+		confidence, err := strconv.Atoi(v)
+		if err != nil || confidence < 0 || confidence > 100 {
+			http.Error(w, "confidence must be a number between 0 and 100", http.StatusBadRequest)
+			return
+		}
+		opts.Confidence = &confidence
+	}
+	if v := q.Get("tags"); v != "" {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				opts.Tags = append(opts.Tags, tag)
+			}
+		}
+	}
+
+	body := io.Reader(r.Body)
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				http.Error(w, "multipart form has no file field", http.StatusBadRequest)
+				return
+			}
+			if part.FormName() == "file" {
+				body = part
+				if opts.Format == "" {
+					opts.Format = importFormat(path.Ext(part.FileName()), part.Header.Get("Content-Type"))
+				}
+				break
+			}
+		}
+	} else if opts.Format == "" {
+		opts.Format = importFormat("", contentType)
+	}
+	if opts.Format == "" {
+		http.Error(w, "format must be one of csv, text, stix or mde", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.importer.Submit(r.Context(), opts, body)
+	if err == bulk.ErrTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jobId":  job.ID,
+		"status": job.Status,
+		"dryRun": opts.DryRun,
+	})
+}
+
+// importFormat guesses the import format from a file extension or media type
+func importFormat(ext, contentType string) string {
+	switch strings.ToLower(ext) {
+	case ".csv":
+		return bulk.FormatCSV
+	case ".txt":
+		return bulk.FormatText
+	case ".json":
+		return bulk.FormatSTIX
+	}
+	switch contentType {
+	case "text/csv":
+		return bulk.FormatCSV
+	case "text/plain":
+		return bulk.FormatText
+	case "application/stix+json", "application/json":
+		return bulk.FormatSTIX
+	}
+	return ""
+}
+
+func (h *ThreatIntelHandler) GetImportJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.importJobs.Get(r.Context(), mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "Import job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// ExportIndicators streams active indicators as an MDE indicator CSV, a
+// Sentinel threat intelligence upload body or SharePoint list items
+func (h *ThreatIntelHandler) ExportIndicators(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	contentType, ext, ok := bulk.ContentType(format)
+	if !ok {
+		http.Error(w, "format must be one of mde, sentinel or sharepoint", http.StatusBadRequest)
+		return
+	}
+
+	opts := bulk.ExportOptions{
+		Filter: indicator.Filter{ClientID: q.Get("clientId")},
+		Action: q.Get("action"),
+	}
+	if v := q.Get("type"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			t, ok := indicator.ParseType(name)
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown indicator type %q", name), http.StatusBadRequest)
+				return
+			}
+			opts.Filter.Types = append(opts.Filter.Types, t)
+		}
+	}
+
+	// Large exports outlive the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="indicators-%s-%s.%s"`,
+		format, time.Now().UTC().Format("20060102"), ext))
+
+	// Headers are already sent, so a failure part way can only be logged
+	count, err := h.exporter.Export(r.Context(), w, format, opts)
+	if err != nil {
+		log.Printf("Error exporting indicators as %s after %d rows: %v", format, count, err)
+	}
+}
+
+// RefreshIndicators renews indicators an analyst has confirmed are still
+// relevant, resetting their expiry and confidence as if freshly reported
+func (h *ThreatIntelHandler) RefreshIndicators(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) == 0 {
+		http.Error(w, "ids are required", http.StatusBadRequest)
+		return
+	}
+
+	refreshed, err := h.indicators.Refresh(r.Context(), req.IDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "refreshed",
+		"refreshed": refreshed,
+	})
+}
+
+// ExtendIndicators moves the expiry of indicators, either by a number of
+// days from now or to an explicit expiration date
+func (h *ThreatIntelHandler) ExtendIndicators(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs            []string   `json:"ids"`
+		Days           int        `json:"days"`
+		ExpirationDate *time.Time `json:"expirationDate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) == 0 {
+		http.Error(w, "ids are required", http.StatusBadRequest)
+		return
+	}
+
+	var until time.Time
+	switch {
+	case req.ExpirationDate != nil:
+		until = *req.ExpirationDate
+	case req.Days > 0:
+		until = time.Now().AddDate(0, 0, req.Days)
+	default:
+		http.Error(w, "either days or expirationDate is required", http.StatusBadRequest)
+		return
+	}
+	if !until.After(time.Now()) {
+		http.Error(w, "expirationDate must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	extended, err := h.indicators.Extend(r.Context(), req.IDs, until)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "extended",
+		"extended":       extended,
+		"expirationDate": until,
 	})
 }
 
@@ -161,11 +608,14 @@ func (h *ThreatIntelHandler) GetFeeds(w http.ResponseWriter, r *http.Request) {
         SELECT 
             id,
             name,
-            description,
-            enabled,
+            COALESCE(description, ''),
+            feed_type,
+            COALESCE(enabled, FALSE),
             last_update,
-            indicator_count,
-            sync_progress
+            COALESCE(last_sync_status, ''),
+            COALESCE(last_sync_error, ''),
+            COALESCE(indicator_count, 0),
+            COALESCE(sync_progress, 0)
         FROM deployment_mgmt.threat_feeds
         ORDER BY name
     `
@@ -177,60 +627,91 @@ func (h *ThreatIntelHandler) GetFeeds(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	var feeds []map[string]interface{}
+	var feedList []map[string]interface{}
 	for rows.Next() {
-		var id, name, description string
+		var id, name, description, feedType, lastSyncStatus, lastSyncError string
 		var enabled bool
 		var indicatorCount, syncProgress int
 		var lastUpdate sql.NullTime
 
-		if err := rows.Scan(&id, &name, &description, &enabled,
-			&lastUpdate, &indicatorCount, &syncProgress); err != nil {
-			continue
+		if err := rows.Scan(&id, &name, &description, &feedType, &enabled,
+			&lastUpdate, &lastSyncStatus, &lastSyncError, &indicatorCount, &syncProgress); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		feeds = append(feeds, map[string]interface{}{
+		feedList = append(feedList, map[string]interface{}{
 			"id":             id,
 			"name":           name,
 			"description":    description,
+			"feedType":       feedType,
 			"enabled":        enabled,
 			"lastUpdate":     lastUpdate.Time,
+			"lastSyncStatus": lastSyncStatus,
+			"lastSyncError":  lastSyncError,
 			"indicatorCount": indicatorCount,
 			"syncProgress":   syncProgress,
 		})
 	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(feeds)
+	json.NewEncoder(w).Encode(feedList)
 }
 
-func (h *ThreatIntelHandler) GetStats(w http.ResponseWriter, r *http.Request) {
-	var stats struct {
-		TotalIndicators int
-		ActiveFeeds     int
-		LastUpdate      sql.NullTime
+func (h *ThreatIntelHandler) SyncFeed(w http.ResponseWriter, r *http.Request) {
+	feedID := mux.Vars(r)["id"]
+
+	var exists bool
+	err := h.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM deployment_mgmt.threat_feeds WHERE id = $1)`,
+		feedID).Scan(&exists)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Feed not found", http.StatusNotFound)
+		return
 	}
 
-	query := `
-        SELECT 
-            (SELECT COUNT(*) FROM deployment_mgmt.threat_indicators WHERE is_active = true),
-            (SELECT COUNT(*) FROM deployment_mgmt.threat_feeds WHERE enabled = true),
-            (SELECT MAX(created_at) FROM deployment_mgmt.threat_indicators)
-    `
+	// Feed syncs can take minutes; progress is reported through GetFeeds
+	go func() {
+		if _, err := h.feedScheduler.SyncFeed(context.Background(), feedID); err != nil {
+			log.Printf("Manual sync of feed %s failed: %v", feedID, err)
+		}
+	}()
 
-	h.db.QueryRow(query).Scan(&stats.TotalIndicators, &stats.ActiveFeeds, &stats.LastUpdate)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"feedId": feedID,
+		"status": "syncing",
+	})
+}
 
-	response := map[string]interface{}{
-		"totalIndicators": stats.TotalIndicators,
-		"activeFeeds":     stats.ActiveFeeds,
-		"lastUpdate":      stats.LastUpdate.Time,
-		"syncStatus":      "idle",
+// GetStats returns feed health, indicator counts and the distribution
+// backlog
+func (h *ThreatIntelHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := h.stats.Collect(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(snapshot)
 }
 
+// StreamStats sends stats as Server-Sent Events, see stats.Stream
+func (h *ThreatIntelHandler) StreamStats(w http.ResponseWriter, r *http.Request) {
+	stats.NewStream(h.stats).ServeHTTP(w, r)
+}
+
+// SyncToSentinel delivers a client's pending indicators to its Sentinel
+// workspace now instead of waiting for the next distribution run
 func (h *ThreatIntelHandler) SyncToSentinel(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		ClientID string `json:"clientId"`
@@ -241,23 +722,487 @@ func (h *ThreatIntelHandler) SyncToSentinel(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Update sync status for indicators
-	query := `
-        UPDATE deployment_mgmt.threat_indicators 
-        SET synced_to_sentinel = true, synced_at = NOW()
-        WHERE client_id = $1 OR client_id IS NULL
-    `
+	h.startDistribution(w, distribution.Scope{ClientID: req.ClientID, Target: distribution.TargetSentinel})
+}
+
+// SyncDistribution delivers pending indicators now, optionally limited to
+// one client or target. Failed deliveries in scope are retried.
+func (h *ThreatIntelHandler) SyncDistribution(w http.ResponseWriter, r *http.Request) {
+	var scope distribution.Scope
+	if err := json.NewDecoder(r.Body).Decode(&scope); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if scope.Target != "" && !distribution.IsTarget(scope.Target) {
+		http.Error(w, "unknown target "+scope.Target, http.StatusBadRequest)
+		return
+	}
+
+	h.startDistribution(w, scope)
+}
+
+func (h *ThreatIntelHandler) startDistribution(w http.ResponseWriter, scope distribution.Scope) {
+	go func() {
+		if err := h.distributor.Sync(context.Background(), scope); err != nil {
+			log.Printf("Error distributing indicators for %+v: %v", scope, err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "syncing",
+		"scope":  scope,
+	})
+}
+
+// GetIndicatorDistribution returns where an indicator has been delivered
+func (h *ThreatIntelHandler) GetIndicatorDistribution(w http.ResponseWriter, r *http.Request) {
+	states, err := h.distributor.Store().States(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"indicatorId":  mux.Vars(r)["id"],
+		"distribution": states,
+	})
+}
 
-	_, err := h.db.Exec(query, req.ClientID)
+// GetIndicatorEnrichment returns the latest provider results for an
+// indicator and their combined assessment
+func (h *ThreatIntelHandler) GetIndicatorEnrichment(w http.ResponseWriter, r *http.Request) {
+	ind, err := h.indicators.Get(r.Context(), mux.Vars(r)["id"])
+	if err == indicator.ErrNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// TODO: Implement actual Sentinel sync logic
+	results, err := h.enrichments.Results(r.Context(), ind.Type, ind.Value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "synced",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"indicatorId": ind.ID,
+		"providers":   h.enricher.Providers(),
+		"results":     results,
+		"assessment":  enrichment.Assess(results),
+	})
+}
+
+// EnrichIndicator enriches an indicator now. Providers that are out of
+// quota are skipped and listed under errors.
+func (h *ThreatIntelHandler) EnrichIndicator(w http.ResponseWriter, r *http.Request) {
+	ind, err := h.indicators.Get(r.Context(), mux.Vars(r)["id"])
+	if err == indicator.ErrNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report, err := h.enricher.Enrich(r.Context(), ind, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetDistributionRoutes returns the configured routes, global and for the
+// client given, along with the built-in defaults
+func (h *ThreatIntelHandler) GetDistributionRoutes(w http.ResponseWriter, r *http.Request) {
+	routes, err := h.distributor.Store().Routes(r.Context(), r.URL.Query().Get("clientId"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"routes":   routes,
+		"defaults": distribution.DefaultRoutes(),
+	})
+}
+
+// UpdateDistributionRoutes replaces the routes of a client, or the global
+// routes when no client is given, and re-plans every indicator
+func (h *ThreatIntelHandler) UpdateDistributionRoutes(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ClientID string               `json:"clientId"`
+		Routes   []distribution.Route `json:"routes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	seen := make(map[indicator.Type]bool)
+	for i, route := range req.Routes {
+		t, ok := indicator.ParseType(string(route.Type))
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown indicator type %q", route.Type), http.StatusBadRequest)
+			return
+		}
+		if seen[t] {
+			http.Error(w, fmt.Sprintf("duplicate route for %s", t), http.StatusBadRequest)
+			return
+		}
+		seen[t] = true
+		for _, target := range route.Targets {
+			if !distribution.IsTarget(target) {
+				http.Error(w, "unknown target "+target, http.StatusBadRequest)
+				return
+			}
+		}
+		if route.MinConfidence < 0 || route.MinConfidence > 100 {
+			http.Error(w, "minConfidence must be between 0 and 100", http.StatusBadRequest)
+			return
+		}
+		req.Routes[i].Type = t
+	}
+
+	if err := h.distributor.Store().ReplaceRoutes(r.Context(), req.ClientID, req.Routes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.distributor.Replan()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "updated",
+		"routes": len(req.Routes),
+	})
+}
+
+// GetDistributionTargets returns the targets configured for a client
+func (h *ThreatIntelHandler) GetDistributionTargets(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("clientId")
+	if clientID == "" {
+		http.Error(w, "clientId is required", http.StatusBadRequest)
+		return
+	}
+
+	targets, err := h.distributor.Store().Targets(r.Context(), clientID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"targets": targets,
+	})
+}
+
+// UpdateDistributionTarget enables, disables or reconfigures a target for
+// a client and re-plans every indicator
+func (h *ThreatIntelHandler) UpdateDistributionTarget(w http.ResponseWriter, r *http.Request) {
+	var cfg distribution.TargetConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cfg.ClientID == "" {
+		http.Error(w, "clientId is required", http.StatusBadRequest)
+		return
+	}
+	if !distribution.IsTarget(cfg.Target) {
+		http.Error(w, "unknown target "+cfg.Target, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.distributor.Store().SaveTarget(r.Context(), cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.distributor.Replan()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "updated",
+		"target": cfg,
+	})
+}
+
+// HuntSightings searches client telemetry for indicators and records what
+// it finds. Lookback is in hours and defaults to a week.
+func (h *ThreatIntelHandler) HuntSightings(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ClientID      string   `json:"clientId"`
+		IndicatorIDs  []string `json:"indicatorIds"`
+		LookbackHours int      `json:"lookbackHours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.LookbackHours < 0 {
+		http.Error(w, "lookbackHours must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.hunter.Hunt(r.Context(), sightings.HuntRequest{
+		ClientID:     req.ClientID,
+		IndicatorIDs: req.IndicatorIDs,
+		Lookback:     time.Duration(req.LookbackHours) * time.Hour,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetSightings searches recorded sightings. With format=stix the page is
+// returned as a STIX bundle.
+func (h *ThreatIntelHandler) GetSightings(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := sightings.Filter{
+		ClientID:    q.Get("clientId"),
+		IndicatorID: q.Get("indicatorId"),
+		Value:       q.Get("value"),
+		Source:      q.Get("source"),
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		filter.Since = since
+	}
+	for name, dst := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = n
+		}
+	}
+
+	found, total, err := h.hunter.Store().Search(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if q.Get("format") == "stix" {
+		bundle := stix.Bundle{Type: "bundle", ID: stix.NewID("bundle"), Objects: []json.RawMessage{}}
+		for _, s := range found {
+			raw, err := json.Marshal(s.STIX())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			bundle.Objects = append(bundle.Objects, raw)
+		}
+		w.Header().Set("Content-Type", "application/stix+json;version=2.1")
+		json.NewEncoder(w).Encode(bundle)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sightings": found,
+		"total":     total,
+	})
+}
+
+// GetSighting returns one sighting
+func (h *ThreatIntelHandler) GetSighting(w http.ResponseWriter, r *http.Request) {
+	s, err := h.hunter.Store().Get(r.Context(), mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "Sighting not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// RaiseSightingTicket raises a ticket for a sighting, once
+func (h *ThreatIntelHandler) RaiseSightingTicket(w http.ResponseWriter, r *http.Request) {
+	s, err := h.hunter.RaiseTicket(r.Context(), mux.Vars(r)["id"])
+	if err == sql.ErrNoRows || err == indicator.ErrNotFound {
+		http.Error(w, "Sighting not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// GetAllowList returns the global allow-list entries and those of the
+// client given
+func (h *ThreatIntelHandler) GetAllowList(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.indicators.AllowEntries(r.Context(), r.URL.Query().Get("clientId"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// AddAllowListEntry adds an allow-list entry, quarantines the active
+// indicators it covers and re-plans distribution so covered indicators are
+// retracted
+func (h *ThreatIntelHandler) AddAllowListEntry(w http.ResponseWriter, r *http.Request) {
+	var entry indicator.AllowEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entry.ID = ""
+	if err := entry.Normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.indicators.AddAllowEntry(r.Context(), &entry); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	quarantined, err := h.indicators.Rescreen(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.distributor.Replan()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entry":       entry,
+		"quarantined": quarantined,
 	})
 }
+
+// DeleteAllowListEntry removes an allow-list entry. Indicators it
+// quarantined stay in quarantine until reviewed.
+func (h *ThreatIntelHandler) DeleteAllowListEntry(w http.ResponseWriter, r *http.Request) {
+	err := h.indicators.DeleteAllowEntry(r.Context(), mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "Allow-list entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Global indicators a client entry withheld may now be distributed
+	h.distributor.Replan()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetQuarantine lists the indicators waiting for review, newest first
+func (h *ThreatIntelHandler) GetQuarantine(w http.ResponseWriter, r *http.Request) {
+	q := indicator.Query{
+		ClientID:        r.URL.Query().Get("clientId"),
+		ReviewStatus:    indicator.ReviewQuarantined,
+		IncludeInactive: true,
+		Cursor:          r.URL.Query().Get("cursor"),
+		Facets:          r.URL.Query().Get("cursor") == "",
+	}
+	if q.ClientID != "" && validation.ValidateUUID(q.ClientID) != nil {
+		http.Error(w, "clientId must be a UUID", http.StatusBadRequest)
+		return
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	result, err := h.indicators.Search(r.Context(), q)
+	if err == indicator.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// reviewRequest is the body of the review actions
+type reviewRequest struct {
+	Reason     string `json:"reason"`
+	ReviewedBy string `json:"reviewedBy"`
+}
+
+// ReleaseIndicator approves a quarantined or retracted indicator so it is
+// distributed again
+func (h *ThreatIntelHandler) ReleaseIndicator(w http.ResponseWriter, r *http.Request) {
+	var req reviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ind, err := h.indicators.Release(r.Context(), mux.Vars(r)["id"], req.ReviewedBy)
+	h.writeReviewed(w, ind, err)
+}
+
+// MarkFalsePositive retracts an indicator from every target it was
+// distributed to and keeps feeds from reactivating it
+func (h *ThreatIntelHandler) MarkFalsePositive(w http.ResponseWriter, r *http.Request) {
+	var req reviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ind, err := h.indicators.MarkFalsePositive(r.Context(), mux.Vars(r)["id"], req.Reason, req.ReviewedBy)
+	h.writeReviewed(w, ind, err)
+}
+
+func (h *ThreatIntelHandler) writeReviewed(w http.ResponseWriter, ind *indicator.Indicator, err error) {
+	switch err {
+	case nil:
+	case indicator.ErrNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case indicator.ErrNotReviewable:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ind)
+}
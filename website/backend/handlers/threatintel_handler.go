@@ -1,61 +0,0 @@
-package handlers
-
-import (
-	"database/sql"
-	"encoding/json"
-	"net/http"
-
-	"github.com/gorilla/mux"
-)
-
-type ThreatIntelHandler struct {
-	*BaseHandler
-}
-
-func NewThreatIntelHandler(db *sql.DB) *ThreatIntelHandler {
-	return &ThreatIntelHandler{BaseHandler: NewBaseHandler(db)}
-}
-
-func (h *ThreatIntelHandler) RegisterRoutes(r *mux.Router) {
-	r.HandleFunc("/threat-intel/indicators", h.GetIndicators).Methods("GET")
-	r.HandleFunc("/threat-intel/indicators", h.CreateIndicator).Methods("POST")
-	r.HandleFunc("/threat-intel/feeds", h.GetFeeds).Methods("GET")
-	r.HandleFunc("/threat-intel/stats", h.GetStats).Methods("GET")
-	r.HandleFunc("/threat-intel/sync-sentinel", h.SyncToSentinel).Methods("POST")
-	r.HandleFunc("/threat-intel/clients", h.GetClients).Methods("GET")
-}
-
-func (h *ThreatIntelHandler) GetIndicators(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode([]interface{}{})
-}
-
-func (h *ThreatIntelHandler) CreateIndicator(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "created"})
-}
-
-func (h *ThreatIntelHandler) GetFeeds(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode([]interface{}{})
-}
-
-func (h *ThreatIntelHandler) GetStats(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"totalIndicators": 0,
-		"activeFeeds":     0,
-		"lastUpdate":      nil,
-		"syncStatus":      "idle",
-	})
-}
-
-func (h *ThreatIntelHandler) SyncToSentinel(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "synced"})
-}
-
-func (h *ThreatIntelHandler) GetClients(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode([]interface{}{})
-}
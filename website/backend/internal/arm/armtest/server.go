@@ -0,0 +1,411 @@
+// Package armtest provides an in-process Azure Resource Manager for
+// exercising deployments without network access.
+package armtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/arm"
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+)
+
+// Server is a fake Resource Manager that stores whatever resources are put
+// to it. Resources other than resource groups need their resource group,
+// and extension resources need the resource they extend. Point an
+// arm.Client at its URL with any token.
+type Server struct {
+	*httptest.Server
+
+	// AsyncPolls makes puts, deletes and actions run as long-running operations
+	// that report InProgress this many times before they finish
+	AsyncPolls int
+	// Fail makes requests whose path contains a key fail with the status
+	// it maps to, such as the name of a resource that should not deploy
+	Fail map[string]int
+	// PageSize splits lists into pages of this many items linked by
+	// nextLink. Lists ignore $filter and are sorted by resource ID.
+	PageSize int
+
+	mu         sync.Mutex
+	resources  map[string]map[string]interface{} // by lower-case resource ID
+	operations map[string]*operation
+	requests   []string
+	customerID int
+	principals int
+}
+
+type operation struct {
+	polls  int
+	finish func()
+}
+
+// NewServer starts a fake server; call Close when done
+func NewServer() *Server {
+	s := &Server{
+		Fail:       make(map[string]int),
+		resources:  make(map[string]map[string]interface{}),
+		operations: make(map[string]*operation),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Source returns a resource manager source whose clients, for any client
+// tenant, call the fake without waiting between polls
+func (s *Server) Source() arm.Source {
+	return source{s}
+}
+
+type source struct{ s *Server }
+
+func (src source) Manager(ctx context.Context, clientID string) (arm.ResourceManager, error) {
+	return src.s.Client(), nil
+}
+
+// Client returns a client calling the fake
+func (s *Server) Client() *arm.Client {
+	client := arm.NewClient(azure.StaticToken("armtest"))
+	client.BaseURL = s.URL
+	client.PollInterval = 0
+	return client
+}
+
+// AddResource stores a resource as though it already existed
+func (s *Server) AddResource(resourceID string, resource map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(resourceID, resource, arm.StateSucceeded)
+}
+
+// Resource returns a stored resource
+func (s *Server) Resource(resourceID string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.resources[strings.ToLower(resourceID)]
+	return r, ok
+}
+
+// ResourceIDs returns the IDs of the stored resources, sorted
+func (s *Server) ResourceIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for _, r := range s.resources {
+		ids = append(ids, r["id"].(string))
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Requests returns the method and path of each resource request received,
+// not counting operation polls
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeError(w, http.StatusUnauthorized, "AuthenticationFailed", "missing bearer token")
+		return
+	}
+	if r.URL.Query().Get("api-version") == "" {
+		writeError(w, http.StatusBadRequest, "MissingApiVersionParameter", "api-version is required")
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/operations/") {
+		s.pollOperation(w, strings.TrimPrefix(r.URL.Path, "/operations/"))
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	s.mu.Unlock()
+	for fragment, status := range s.Fail {
+		if strings.Contains(r.URL.Path, fragment) {
+			writeError(w, status, "InjectedFailure", "failure injected for "+fragment)
+			return
+		}
+	}
+
+	id := strings.TrimRight(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodGet:
+		s.get(w, r, id)
+	case http.MethodPut:
+		s.put(w, r, id)
+	case http.MethodDelete:
+		s.delete(w, id)
+	case http.MethodPost:
+		s.post(w, id)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not supported")
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resource, ok := s.resources[strings.ToLower(id)]; ok {
+		writeJSON(w, http.StatusOK, resource)
+		return
+	}
+
+	// A path that is not a resource lists the resources directly under it
+	prefix := strings.ToLower(id) + "/"
+	items := []map[string]interface{}{}
+	for key, resource := range s.resources {
+		if strings.HasPrefix(key, prefix) && !strings.Contains(key[len(prefix):], "/") {
+			items = append(items, resource)
+		}
+	}
+	// A collection of an existing resource, such as the runs of a
+	// workflow, may be empty
+	if _, ok := s.resources[strings.ToLower(id[:strings.LastIndex(id, "/")])]; len(items) == 0 && !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "resource "+id+" was not found")
+		return
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i]["id"].(string) < items[j]["id"].(string) })
+	page := map[string]interface{}{"value": items}
+	if s.PageSize > 0 {
+		skip, _ := strconv.Atoi(r.URL.Query().Get("$skiptoken"))
+		end := skip + s.PageSize
+		if skip > len(items) {
+			skip = len(items)
+		}
+		if end >= len(items) {
+			end = len(items)
+		} else {
+			next := *r.URL
+			q := next.Query()
+			q.Set("$skiptoken", strconv.Itoa(end))
+			next.RawQuery = q.Encode()
+			page["nextLink"] = s.URL + next.String()
+		}
+		page["value"] = items[skip:end]
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, id string) {
+	var resource map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if parent := parentID(id); parent != "" {
+		if _, ok := s.resources[strings.ToLower(parent)]; !ok {
+			code := "ParentResourceNotFound"
+			if isResourceGroup(parent) {
+				code = "ResourceGroupNotFound"
+			}
+			writeError(w, http.StatusNotFound, code, "parent resource "+parent+" was not found")
+			return
+		}
+	}
+	_, existed := s.resources[strings.ToLower(id)]
+	status := http.StatusCreated
+	if existed {
+		status = http.StatusOK
+	}
+
+	if s.AsyncPolls <= 0 {
+		writeJSON(w, status, s.store(id, resource, arm.StateSucceeded))
+		return
+	}
+	stored := s.store(id, resource, "Updating")
+	s.startOperation(w, func() {
+		stored["properties"].(map[string]interface{})["provisioningState"] = arm.StateSucceeded
+	})
+	writeJSON(w, status, stored)
+}
+
+func (s *Server) delete(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.resources[strings.ToLower(id)]; !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	remove := func() {
+		prefix := strings.ToLower(id)
+		for key := range s.resources {
+			if key == prefix || strings.HasPrefix(key, prefix+"/") {
+				delete(s.resources, key)
+			}
+		}
+	}
+	if s.AsyncPolls <= 0 {
+		remove()
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	s.startOperation(w, remove)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// post invokes an action of a stored resource. Enabling and disabling a
+// workflow set its state; other actions do nothing.
+func (s *Server) post(w http.ResponseWriter, id string) {
+	i := strings.LastIndex(id, "/")
+	resourceID, action := id[:i], strings.ToLower(id[i+1:])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resource, ok := s.resources[strings.ToLower(resourceID)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "resource "+resourceID+" was not found")
+		return
+	}
+	invoke := func() {
+		properties := resource["properties"].(map[string]interface{})
+		switch action {
+		case "enable":
+			properties["state"] = "Enabled"
+		case "disable":
+			properties["state"] = "Disabled"
+		}
+	}
+	if s.AsyncPolls <= 0 {
+		invoke()
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	s.startOperation(w, invoke)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// store saves a resource with its identity and provisioning state filled
+// in; the caller holds s.mu
+func (s *Server) store(id string, resource map[string]interface{}, state string) map[string]interface{} {
+	if resource == nil {
+		resource = make(map[string]interface{})
+	}
+	properties, _ := resource["properties"].(map[string]interface{})
+	if properties == nil {
+		properties = make(map[string]interface{})
+	}
+	properties["provisioningState"] = state
+	// Log Analytics identifies workspaces to agents and queries by a GUID
+	if strings.HasSuffix(strings.ToLower(resourceType(id)), "microsoft.operationalinsights/workspaces") {
+		if old, ok := s.resources[strings.ToLower(id)]; ok && properties["customerId"] == nil {
+			properties["customerId"] = old["properties"].(map[string]interface{})["customerId"]
+		}
+		if properties["customerId"] == nil {
+			s.customerID++
+			properties["customerId"] = fmt.Sprintf("00000000-0000-0000-0000-%012d", s.customerID)
+		}
+	}
+	// Resources with a system-assigned identity are given a principal
+	// that keeps its ID across puts
+	if identity, ok := resource["identity"].(map[string]interface{}); ok &&
+		strings.Contains(strings.ToLower(fmt.Sprint(identity["type"])), "systemassigned") {
+		if old, ok := s.resources[strings.ToLower(id)]; ok && identity["principalId"] == nil {
+			if oldIdentity, ok := old["identity"].(map[string]interface{}); ok {
+				identity["principalId"], identity["tenantId"] = oldIdentity["principalId"], oldIdentity["tenantId"]
+			}
+		}
+		if identity["principalId"] == nil {
+			s.principals++
+			identity["principalId"] = fmt.Sprintf("11111111-0000-0000-0000-%012d", s.principals)
+			identity["tenantId"] = "22222222-0000-0000-0000-000000000000"
+		}
+	}
+	resource["properties"] = properties
+	resource["id"] = id
+	resource["name"] = id[strings.LastIndex(id, "/")+1:]
+	s.resources[strings.ToLower(id)] = resource
+	return resource
+}
+
+// startOperation answers with an Azure-AsyncOperation header for an
+// operation that finishes after s.AsyncPolls polls; the caller holds s.mu
+func (s *Server) startOperation(w http.ResponseWriter, finish func()) {
+	name := fmt.Sprintf("op-%d", len(s.operations)+1)
+	s.operations[name] = &operation{polls: s.AsyncPolls, finish: finish}
+	w.Header().Set("Azure-AsyncOperation", s.URL+"/operations/"+name+"?api-version=2021-04-01")
+}
+
+func (s *Server) pollOperation(w http.ResponseWriter, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op, ok := s.operations[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "OperationNotFound", "operation "+name+" was not found")
+		return
+	}
+	if op.polls > 0 {
+		op.polls--
+		writeJSON(w, http.StatusOK, map[string]string{"status": "InProgress"})
+		return
+	}
+	if op.finish != nil {
+		op.finish()
+		op.finish = nil
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": arm.StateSucceeded})
+}
+
+// parentID returns the resource a resource must be created in: the
+// resource group of a top-level resource, the resource an extension
+// resource extends, or the parent of a child resource
+func parentID(id string) string {
+	if isResourceGroup(id) || !strings.Contains(strings.ToLower(id), "/resourcegroups/") {
+		return ""
+	}
+	i := strings.LastIndex(strings.ToLower(id), "/providers/")
+	if i < 0 {
+		return ""
+	}
+	// providers/Namespace/type/name has four segments; anything after is
+	// a child of that resource
+	segments := strings.Split(id[i+1:], "/")
+	if len(segments) > 4 {
+		return id[:i+1] + strings.Join(segments[:len(segments)-2], "/")
+	}
+	return id[:i]
+}
+
+// resourceType returns the namespace and type path of a resource ID
+func resourceType(id string) string {
+	i := strings.LastIndex(strings.ToLower(id), "/providers/")
+	if i < 0 {
+		return ""
+	}
+	segments := strings.Split(id[i+len("/providers/"):], "/")
+	var types []string
+	for j := 0; j < len(segments); j++ {
+		if j == 0 || j%2 == 1 {
+			types = append(types, segments[j])
+		}
+	}
+	return strings.Join(types, "/")
+}
+
+func isResourceGroup(id string) bool {
+	segments := strings.Split(strings.Trim(id, "/"), "/")
+	return len(segments) == 4 && strings.EqualFold(segments[2], "resourceGroups")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
@@ -0,0 +1,467 @@
+// Package arm calls Azure Resource Manager in client subscriptions: it
+// creates, reads, lists and deletes resources by ID, invokes their actions
+// and waits for long-running operations to finish
+package arm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+)
+
+const (
+	// DefaultBaseURL is the Azure Resource Manager endpoint
+	DefaultBaseURL = "https://management.azure.com"
+	// DefaultPollInterval is how often a long-running operation is polled
+	// when Resource Manager does not say
+	DefaultPollInterval = 5 * time.Second
+	// DefaultPollTimeout bounds the wait for a long-running operation
+	DefaultPollTimeout = 30 * time.Minute
+)
+
+// ResourceManager creates, reads, lists and deletes resources by resource
+// ID and invokes their actions
+type ResourceManager interface {
+	// Get reads a resource into out. It fails with an Error for which
+	// IsNotFound holds when the resource does not exist.
+	Get(ctx context.Context, resourceID, apiVersion string, out interface{}) error
+	// List reads the items of a collection, such as the runs of a workflow,
+	// following nextLink across pages, and calls each with every item.
+	// Query adds parameters such as $filter. Listing stops at the first
+	// error each returns.
+	List(ctx context.Context, collectionID, apiVersion string, query url.Values, each func(json.RawMessage) error) error
+	// Put creates or updates a resource and waits until it is provisioned.
+	// The provisioned resource is decoded into out unless it is nil.
+	Put(ctx context.Context, resourceID, apiVersion string, in, out interface{}) error
+	// Delete deletes a resource and waits until it is gone. Deleting a
+	// resource that does not exist succeeds.
+	Delete(ctx context.Context, resourceID, apiVersion string) error
+	// Post invokes an action of a resource, such as enabling a workflow,
+	// and waits until it finishes. The response, if any, is decoded into
+	// out unless it is nil.
+	Post(ctx context.Context, resourceID, action, apiVersion string, in, out interface{}) error
+}
+
+// Source returns the resource manager for a client's tenant
+type Source interface {
+	Manager(ctx context.Context, clientID string) (ResourceManager, error)
+}
+
+// TokenSource returns a token provider for a client tenant
+type TokenSource interface {
+	TokenProvider(ctx context.Context, clientID string) (azure.TokenProvider, error)
+}
+
+// TenantSource calls Resource Manager with each client's app registration
+type TenantSource struct {
+	// BaseURL overrides DefaultBaseURL, such as for a fake API
+	BaseURL string
+	// PollInterval overrides DefaultPollInterval
+	PollInterval time.Duration
+	tokens       TokenSource
+}
+
+// NewTenantSource creates a source signing in with tokens
+func NewTenantSource(tokens TokenSource) *TenantSource {
+	return &TenantSource{tokens: tokens}
+}
+
+// Manager implements Source
+func (t *TenantSource) Manager(ctx context.Context, clientID string) (ResourceManager, error) {
+	tokens, err := t.tokens.TokenProvider(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(tokens)
+	if t.BaseURL != "" {
+		client.BaseURL = t.BaseURL
+	}
+	if t.PollInterval > 0 {
+		client.PollInterval = t.PollInterval
+	}
+	return client, nil
+}
+
+// Client calls Azure Resource Manager
+type Client struct {
+	HTTPClient   *http.Client
+	BaseURL      string
+	Tokens       azure.TokenProvider
+	PollInterval time.Duration
+	PollTimeout  time.Duration
+}
+
+// NewClient creates a client for the tenant the token provider belongs to
+func NewClient(tokens azure.TokenProvider) *Client {
+	return &Client{
+		HTTPClient:   &http.Client{Timeout: time.Minute},
+		BaseURL:      DefaultBaseURL,
+		Tokens:       tokens,
+		PollInterval: DefaultPollInterval,
+		PollTimeout:  DefaultPollTimeout,
+	}
+}
+
+// Error is an error response from Resource Manager or a failed
+// long-running operation
+type Error struct {
+	StatusCode int     `json:"-"`
+	Code       string  `json:"code"`
+	Message    string  `json:"message"`
+	Target     string  `json:"target,omitempty"`
+	Details    []Error `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("resource manager returned %d", e.StatusCode)
+	}
+	msg := fmt.Sprintf("%s: %s", e.Code, e.Message)
+	if e.StatusCode != 0 {
+		msg = fmt.Sprintf("resource manager returned %d: %s", e.StatusCode, msg)
+	}
+	// The top-level message of a failed deployment is often generic; the
+	// details say what was wrong
+	for _, d := range e.Details {
+		msg += fmt.Sprintf("; %s: %s", d.Code, d.Message)
+	}
+	return msg
+}
+
+// IsNotFound reports whether err is a Resource Manager 404
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Provisioning states Resource Manager reports for finished operations.
+// Any other state means the operation is still running.
+const (
+	StateSucceeded = "Succeeded"
+	StateFailed    = "Failed"
+	StateCanceled  = "Canceled"
+)
+
+func terminal(state string) bool {
+	return state == "" || strings.EqualFold(state, StateSucceeded) ||
+		strings.EqualFold(state, StateFailed) || strings.EqualFold(state, StateCanceled)
+}
+
+// Get implements ResourceManager
+func (c *Client) Get(ctx context.Context, resourceID, apiVersion string, out interface{}) error {
+	resp, err := c.send(ctx, http.MethodGet, c.resourceURL(resourceID, apiVersion), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// List implements ResourceManager
+func (c *Client) List(ctx context.Context, collectionID, apiVersion string, query url.Values,
+	each func(json.RawMessage) error) error {
+	target := c.resourceURL(collectionID, apiVersion)
+	if len(query) > 0 {
+		target += "&" + query.Encode()
+	}
+	for target != "" {
+		resp, err := c.send(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Value    []json.RawMessage `json:"value"`
+			NextLink string            `json:"nextLink"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, item := range page.Value {
+			if err := each(item); err != nil {
+				return err
+			}
+		}
+		target = page.NextLink
+	}
+	return nil
+}
+
+// Put implements ResourceManager. An operation Resource Manager runs
+// asynchronously is followed through its Azure-AsyncOperation or Location
+// header and then the resource is read back.
+func (c *Client) Put(ctx context.Context, resourceID, apiVersion string, in, out interface{}) error {
+	resp, err := c.send(ctx, http.MethodPut, c.resourceURL(resourceID, apiVersion), in)
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := c.pollContext(ctx)
+	defer cancel()
+	if err := c.waitOperation(ctx, resp); err != nil {
+		return err
+	}
+	if operationHeader(resp) == "" {
+		var state provisioning
+		if len(body) > 0 && json.Unmarshal(body, &state) == nil && terminal(state.Properties.ProvisioningState) {
+			if err := state.err(); err != nil {
+				return err
+			}
+			if out == nil {
+				return nil
+			}
+			return json.Unmarshal(body, out)
+		}
+	}
+	return c.waitProvisioned(ctx, resourceID, apiVersion, out)
+}
+
+// Delete implements ResourceManager
+func (c *Client) Delete(ctx context.Context, resourceID, apiVersion string) error {
+	resp, err := c.send(ctx, http.MethodDelete, c.resourceURL(resourceID, apiVersion), nil)
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	ctx, cancel := c.pollContext(ctx)
+	defer cancel()
+	err = c.waitOperation(ctx, resp)
+	if IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Post implements ResourceManager
+func (c *Client) Post(ctx context.Context, resourceID, action, apiVersion string, in, out interface{}) error {
+	resp, err := c.send(ctx, http.MethodPost, c.resourceURL(strings.TrimRight(resourceID, "/")+"/"+action, apiVersion), in)
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := c.pollContext(ctx)
+	defer cancel()
+	if err := c.waitOperation(ctx, resp); err != nil {
+		return err
+	}
+	if out == nil || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+// provisioning is the part of a resource that says whether it is ready
+type provisioning struct {
+	Properties struct {
+		ProvisioningState string `json:"provisioningState"`
+	} `json:"properties"`
+}
+
+func (p provisioning) err() error {
+	state := p.Properties.ProvisioningState
+	if strings.EqualFold(state, StateFailed) || strings.EqualFold(state, StateCanceled) {
+		return &Error{Code: "Provisioning" + state, Message: "resource provisioning " + strings.ToLower(state)}
+	}
+	return nil
+}
+
+func operationHeader(resp *http.Response) string {
+	if op := resp.Header.Get("Azure-AsyncOperation"); op != "" {
+		return op
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		return resp.Header.Get("Location")
+	}
+	return ""
+}
+
+// waitOperation waits for the long-running operation a response started,
+// if any. An Azure-AsyncOperation URL reports the operation's status; a
+// Location URL answers 202 until the operation is done.
+func (c *Client) waitOperation(ctx context.Context, started *http.Response) error {
+	if op := started.Header.Get("Azure-AsyncOperation"); op != "" {
+		delay := retryAfter(started, c.PollInterval)
+		for {
+			if err := sleep(ctx, delay); err != nil {
+				return err
+			}
+			resp, err := c.send(ctx, http.MethodGet, op, nil)
+			if err != nil {
+				return err
+			}
+			var status struct {
+				Status string `json:"status"`
+				Error  *Error `json:"error"`
+			}
+			err = json.NewDecoder(resp.Body).Decode(&status)
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+			if !terminal(status.Status) {
+				delay = retryAfter(resp, c.PollInterval)
+				continue
+			}
+			if strings.EqualFold(status.Status, StateSucceeded) || status.Status == "" {
+				return nil
+			}
+			if status.Error != nil {
+				return status.Error
+			}
+			return &Error{Code: "Operation" + status.Status, Message: "operation " + strings.ToLower(status.Status)}
+		}
+	}
+
+	if started.StatusCode != http.StatusAccepted || started.Header.Get("Location") == "" {
+		return nil
+	}
+	location, delay := started.Header.Get("Location"), retryAfter(started, c.PollInterval)
+	for {
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+		resp, err := c.send(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			return nil
+		}
+		delay = retryAfter(resp, c.PollInterval)
+	}
+}
+
+// waitProvisioned reads a resource until its provisioning state is final
+func (c *Client) waitProvisioned(ctx context.Context, resourceID, apiVersion string, out interface{}) error {
+	for {
+		resp, err := c.send(ctx, http.MethodGet, c.resourceURL(resourceID, apiVersion), nil)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		var state provisioning
+		if err := json.Unmarshal(body, &state); err != nil {
+			return err
+		}
+		if terminal(state.Properties.ProvisioningState) {
+			if err := state.err(); err != nil {
+				return err
+			}
+			if out == nil {
+				return nil
+			}
+			return json.Unmarshal(body, out)
+		}
+		if err := sleep(ctx, retryAfter(resp, c.PollInterval)); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) pollContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.PollTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.PollTimeout)
+}
+
+func (c *Client) resourceURL(resourceID, apiVersion string) string {
+	return strings.TrimRight(c.BaseURL, "/") + "/" + strings.TrimLeft(resourceID, "/") + "?api-version=" + apiVersion
+}
+
+// send sends a JSON request to an absolute URL. A response with an error
+// status is returned as an Error.
+func (c *Client) send(ctx context.Context, method, target string, in interface{}) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	token, err := c.Tokens.Token(ctx, azure.ScopeManagement)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		var envelope struct {
+			Error *Error `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&envelope) == nil && envelope.Error != nil {
+			apiErr.Code, apiErr.Message = envelope.Error.Code, envelope.Error.Message
+			apiErr.Target, apiErr.Details = envelope.Error.Target, envelope.Error.Details
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// retryAfter returns the delay a response asks for, or fallback
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return fallback
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
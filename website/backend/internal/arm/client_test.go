@@ -0,0 +1,144 @@
+package arm_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/arm"
+	"github.com/ClarityXDR/prod/website/backend/internal/arm/armtest"
+)
+
+const (
+	group    = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-soar"
+	workflow = group + "/providers/Microsoft.Logic/workflows/isolate-host"
+)
+
+type resource struct {
+	ID         string `json:"id"`
+	Properties struct {
+		ProvisioningState string `json:"provisioningState"`
+		State             string `json:"state"`
+	} `json:"properties"`
+}
+
+func newServer(asyncPolls int) *armtest.Server {
+	server := armtest.NewServer()
+	server.AsyncPolls = asyncPolls
+	server.AddResource(group, map[string]interface{}{"location": "westeurope"})
+	return server
+}
+
+func TestClientPutWaitsForProvisioning(t *testing.T) {
+	for _, polls := range []int{0, 3} {
+		server := newServer(polls)
+		var out resource
+		err := server.Client().Put(context.Background(), workflow, "2019-05-01",
+			map[string]interface{}{"location": "westeurope", "properties": map[string]interface{}{"state": "Enabled"}}, &out)
+		server.Close()
+		if err != nil {
+			t.Fatalf("%d polls: %v", polls, err)
+		}
+		// The resource is only returned once Resource Manager reports it provisioned
+		if out.ID != workflow || out.Properties.ProvisioningState != arm.StateSucceeded {
+			t.Errorf("%d polls: put returned %+v, want a provisioned workflow", polls, out)
+		}
+	}
+}
+
+func TestClientPutErrors(t *testing.T) {
+	server := newServer(0)
+	defer server.Close()
+	c := server.Client()
+
+	missing := "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-missing/providers/Microsoft.Logic/workflows/x"
+	err := c.Put(context.Background(), missing, "2019-05-01", map[string]interface{}{}, nil)
+	var apiErr *arm.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != "ResourceGroupNotFound" || !arm.IsNotFound(err) {
+		t.Errorf("put into a missing group: %v, want ResourceGroupNotFound", err)
+	}
+
+	server.Fail["isolate-host"] = http.StatusConflict
+	err = c.Put(context.Background(), workflow, "2019-05-01", map[string]interface{}{}, nil)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict || arm.IsNotFound(err) {
+		t.Errorf("failed put: %v, want a 409 Error", err)
+	}
+	if _, ok := server.Resource(workflow); ok {
+		t.Error("failed put stored the resource")
+	}
+}
+
+func TestClientGetAndList(t *testing.T) {
+	server := newServer(0)
+	defer server.Close()
+	server.PageSize = 2
+	c := server.Client()
+
+	if err := c.Get(context.Background(), workflow, "2019-05-01", &resource{}); !arm.IsNotFound(err) {
+		t.Fatalf("get of a missing resource: %v, want not found", err)
+	}
+	names := []string{"a", "b", "c", "d", "e"}
+	for _, name := range names {
+		server.AddResource(group+"/providers/Microsoft.Logic/workflows/"+name, map[string]interface{}{})
+	}
+
+	var listed []string
+	err := c.List(context.Background(), group+"/providers/Microsoft.Logic/workflows", "2019-05-01", nil,
+		func(item json.RawMessage) error {
+			var r resource
+			if err := json.Unmarshal(item, &r); err != nil {
+				return err
+			}
+			listed = append(listed, r.ID)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != len(names) {
+		t.Fatalf("listed %v across pages, want %d workflows", listed, len(names))
+	}
+
+	// Listing stops at the first error the callback returns
+	stop := errors.New("stop")
+	calls := 0
+	err = c.List(context.Background(), group+"/providers/Microsoft.Logic/workflows", "2019-05-01", nil,
+		func(json.RawMessage) error {
+			calls++
+			return stop
+		})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("List = %v after %d calls, want it stopped after the first", err, calls)
+	}
+}
+
+func TestClientPostAndDelete(t *testing.T) {
+	server := newServer(2)
+	defer server.Close()
+	c := server.Client()
+	server.AddResource(workflow, map[string]interface{}{"properties": map[string]interface{}{"state": "Enabled"}})
+
+	if err := c.Post(context.Background(), workflow, "disable", "2019-05-01", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var r resource
+	if err := c.Get(context.Background(), workflow, "2019-05-01", &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Properties.State != "Disabled" {
+		t.Errorf("state = %s, want the action finished before Post returned", r.Properties.State)
+	}
+
+	if err := c.Delete(context.Background(), workflow, "2019-05-01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Resource(workflow); ok {
+		t.Error("resource still exists after Delete returned")
+	}
+	// Deleting what is gone succeeds
+	if err := c.Delete(context.Background(), workflow, "2019-05-01"); err != nil {
+		t.Errorf("second delete: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes requested for the APIs ClarityXDR calls on behalf of clients
+const (
+	ScopeGraph           = "https://graph.microsoft.com/.default"
+	ScopeManagement      = "https://management.azure.com/.default"
+	ScopeDefender        = "https://api.securitycenter.microsoft.com/.default"
+	ScopeExchange        = "https://outlook.office365.com/.default"
+	ScopeCloudAppsPortal = "05a65629-4c1b-48c1-a78b-804c4abdd4af/.default"
+	ScopeLogAnalytics    = "https://api.loganalytics.io/.default"
+)
+
+// DefaultAuthority is the Entra ID endpoint tokens are requested from
+const DefaultAuthority = "https://login.microsoftonline.com"
+
+// TokenProvider returns bearer tokens for an API scope
+type TokenProvider interface {
+	Token(ctx context.Context, scope string) (string, error)
+}
+
+// Credentials identify an app registration in a client tenant
+type Credentials struct {
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+}
+
+// ClientCredentials obtains app-only tokens with the OAuth 2.0 client
+// credentials grant and caches them until shortly before they expire
+type ClientCredentials struct {
+	Credentials Credentials
+	HTTPClient  *http.Client
+	// Authority overrides DefaultAuthority
+	Authority string
+
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+type cachedToken struct {
+	value   string
+	expires time.Time
+}
+
+// NewClientCredentials creates a token provider for an app registration
+func NewClientCredentials(creds Credentials) *ClientCredentials {
+	return &ClientCredentials{
+		Credentials: creds,
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Token implements TokenProvider
+func (c *ClientCredentials) Token(ctx context.Context, scope string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t, ok := c.tokens[scope]; ok && time.Now().Before(t.expires) {
+		return t.value, nil
+	}
+
+	authority := c.Authority
+	if authority == "" {
+		authority = DefaultAuthority
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.Credentials.ClientID},
+		"client_secret": {c.Credentials.ClientSecret},
+		"scope":         {scope},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimRight(authority, "/")+"/"+url.PathEscape(c.Credentials.TenantID)+"/oauth2/v2.0/token",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return "", fmt.Errorf("token request for tenant %s failed: %s %s",
+			c.Credentials.TenantID, body.Error, body.ErrorDescription)
+	}
+
+	if c.tokens == nil {
+		c.tokens = make(map[string]cachedToken)
+	}
+	// Renew a minute early so a token never expires mid-request
+	c.tokens[scope] = cachedToken{
+		value:   body.AccessToken,
+		expires: time.Now().Add(time.Duration(body.ExpiresIn)*time.Second - time.Minute),
+	}
+	return body.AccessToken, nil
+}
+
+// StaticToken is a TokenProvider that always returns the same token
+type StaticToken string
+
+// Token implements TokenProvider
+func (t StaticToken) Token(ctx context.Context, scope string) (string, error) {
+	return string(t), nil
+}
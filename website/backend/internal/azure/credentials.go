@@ -0,0 +1,131 @@
+package azure
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// SecretCipher encrypts and decrypts secrets stored in the database, such
+// as app registration secrets. It uses the same AES-GCM layout as
+// internal/db: the nonce followed by the sealed secret. With no key
+// configured secrets are stored in the clear.
+type SecretCipher struct {
+	gcm cipher.AEAD
+}
+
+// NewSecretCipher creates a cipher from the ENCRYPTION_KEY setting
+func NewSecretCipher(key string) (*SecretCipher, error) {
+	if key == "" {
+		return &SecretCipher{}, nil
+	}
+	// Keys are padded or truncated to 32 bytes for AES-256
+	k := make([]byte, 32)
+	copy(k, key)
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %v", err)
+	}
+	return &SecretCipher{gcm: gcm}, nil
+}
+
+// Encrypt seals a secret for storing
+func (c *SecretCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	if c.gcm == nil {
+		return plaintext, nil
+	}
+	nonce := make([]byte, c.gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens a stored secret
+func (c *SecretCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	if c.gcm == nil {
+		return ciphertext, nil
+	}
+	nonceSize := c.gcm.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return c.gcm.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], nil)
+}
+
+// CredentialStore loads the app registration ClarityXDR uses in each client
+// tenant from client_mgmt.app_registrations
+type CredentialStore struct {
+	db     *sql.DB
+	cipher *SecretCipher
+
+	mu        sync.Mutex
+	providers map[string]*ClientCredentials
+}
+
+// NewCredentialStore creates a credential store
+func NewCredentialStore(db *sql.DB, cipher *SecretCipher) *CredentialStore {
+	return &CredentialStore{db: db, cipher: cipher, providers: make(map[string]*ClientCredentials)}
+}
+
+// Credentials returns the newest active app registration of a client
+func (s *CredentialStore) Credentials(ctx context.Context, clientID string) (*Credentials, error) {
+	var creds Credentials
+	var tenantID sql.NullString
+	var secret []byte
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT c.tenant_id, ar.app_id, ar.client_secret_encrypted
+		FROM client_mgmt.app_registrations ar
+		JOIN client_mgmt.clients c ON c.id = ar.client_id
+		WHERE ar.client_id = $1
+		AND ar.status = 'active'
+		AND (ar.expires_at IS NULL OR ar.expires_at > NOW())
+		ORDER BY ar.created_at DESC
+		LIMIT 1`, clientID).Scan(&tenantID, &creds.ClientID, &secret)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("client %s has no active app registration", clientID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !tenantID.Valid || tenantID.String == "" {
+		return nil, fmt.Errorf("client %s has no tenant ID", clientID)
+	}
+	creds.TenantID = tenantID.String
+
+	plain, err := s.cipher.Decrypt(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt app registration secret for client %s: %v", clientID, err)
+	}
+	creds.ClientSecret = string(plain)
+	return &creds, nil
+}
+
+// TokenProvider returns a token provider for a client tenant. Providers are
+// reused so tokens are cached across calls; a changed app registration
+// replaces the cached provider.
+func (s *CredentialStore) TokenProvider(ctx context.Context, clientID string) (TokenProvider, error) {
+	creds, err := s.Credentials(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.providers[clientID]; ok && p.Credentials == *creds {
+		return p, nil
+	}
+	p := NewClientCredentials(*creds)
+	s.providers[clientID] = p
+	return p, nil
+}
@@ -0,0 +1,92 @@
+// Package dbtest provides scratch PostgreSQL databases built from the
+// init-scripts, for testing the SQL behind the stores. Tests using it are
+// skipped unless CLARITYXDR_TEST_DATABASE_URL gives a postgres:// URL of a
+// server the tests may create databases on.
+package dbtest
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// EnvURL names the variable holding the server URL
+const EnvURL = "CLARITYXDR_TEST_DATABASE_URL"
+
+// Open creates an empty database, runs the init-scripts on it and returns
+// a connection to it. The database is dropped when the test ends.
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+	serverURL := os.Getenv(EnvURL)
+	if serverURL == "" {
+		t.Skip(EnvURL + " is not set")
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatalf("invalid %s: %v", EnvURL, err)
+	}
+
+	admin, err := sql.Open("postgres", serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("clarityxdr_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE DATABASE " + name); err != nil {
+		admin.Close()
+		t.Fatalf("failed to create test database: %v", err)
+	}
+
+	u.Path = "/" + name
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if _, err := admin.Exec("DROP DATABASE IF EXISTS " + name); err != nil {
+			t.Logf("failed to drop test database %s: %v", name, err)
+		}
+		admin.Close()
+	})
+
+	scripts, err := filepath.Glob(filepath.Join(initScriptsDir(), "*.sql"))
+	if err != nil || len(scripts) == 0 {
+		t.Fatalf("no init-scripts found in %s", initScriptsDir())
+	}
+	sort.Strings(scripts)
+	for _, script := range scripts {
+		data, err := os.ReadFile(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(data)); err != nil {
+			t.Fatalf("%s: %v", filepath.Base(script), err)
+		}
+	}
+	return db
+}
+
+// CreateClient adds a client and returns its ID
+func CreateClient(t testing.TB, db *sql.DB, name string) string {
+	t.Helper()
+	var id string
+	if err := db.QueryRow(`
+		INSERT INTO client_mgmt.clients (name) VALUES ($1) RETURNING id`, name).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// initScriptsDir returns website/init-scripts, found relative to this file
+func initScriptsDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "..", "init-scripts")
+}
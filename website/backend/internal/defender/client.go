@@ -0,0 +1,101 @@
+package defender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+)
+
+// DefaultBaseURL is the Microsoft Defender for Endpoint API
+const DefaultBaseURL = "https://api.securitycenter.microsoft.com"
+
+// Client calls the Defender for Endpoint API of one tenant
+type Client struct {
+	HTTPClient *http.Client
+	BaseURL    string
+	Tokens     azure.TokenProvider
+}
+
+// NewClient creates a client for the tenant the token provider belongs to
+func NewClient(tokens azure.TokenProvider) *Client {
+	return &Client{
+		HTTPClient: &http.Client{Timeout: 2 * time.Minute},
+		BaseURL:    DefaultBaseURL,
+		Tokens:     tokens,
+	}
+}
+
+// Error is an error response from the Defender API
+type Error struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("defender api returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("defender api returned %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsNotFound reports whether err is a Defender API 404
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// do sends a JSON request and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.BaseURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	token, err := c.Tokens.Token(ctx, azure.ScopeDefender)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		var envelope struct {
+			Error *Error `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&envelope) == nil && envelope.Error != nil {
+			apiErr.Code, apiErr.Message = envelope.Error.Code, envelope.Error.Message
+		}
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,288 @@
+// Package defendertest provides an in-process Defender for Endpoint API for
+// exercising connectors without network access.
+package defendertest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/defender"
+)
+
+// Server is a fake Defender for Endpoint API. Point a defender.Client at
+// its URL with any token.
+//
+// Machine actions start Pending and move on each time they are read, to
+// InProgress and then Succeeded, or Failed for machines in Fail.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	nextID     int
+	indicators map[string]defender.Indicator
+	machines   map[string]string // DNS names by machine ID
+	actions    map[string]*defender.MachineAction
+	// Reject lists indicator values the fake reports as failed on import
+	Reject map[string]string
+	// Fail lists machine IDs whose actions end Failed
+	Fail map[string]bool
+}
+
+// NewServer starts a fake server; call Close when done
+func NewServer() *Server {
+	s := &Server{
+		indicators: make(map[string]defender.Indicator),
+		machines:   make(map[string]string),
+		actions:    make(map[string]*defender.MachineAction),
+		Reject:     make(map[string]string),
+		Fail:       make(map[string]bool),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Source returns a machine actions source whose clients, for any client
+// tenant, call the fake
+func (s *Server) Source() defender.Source {
+	return source{s}
+}
+
+type source struct{ s *Server }
+
+func (src source) MachineActions(ctx context.Context, clientID string) (defender.MachineActions, error) {
+	return src.s.Client(), nil
+}
+
+// Client returns a client calling the fake
+func (s *Server) Client() *defender.Client {
+	client := defender.NewClient(azure.StaticToken("defendertest"))
+	client.BaseURL = s.URL
+	return client
+}
+
+// AddMachine adds a machine that actions can be taken on
+func (s *Server) AddMachine(id, dnsName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.machines[id] = dnsName
+}
+
+// MachineActions returns the actions taken on a machine, in no order
+func (s *Server) MachineActions(machineID string) []defender.MachineAction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []defender.MachineAction
+	for _, a := range s.actions {
+		if a.MachineID == machineID {
+			out = append(out, *a)
+		}
+	}
+	return out
+}
+
+// Indicators returns the indicators currently held, keyed by ID
+func (s *Server) Indicators() map[string]defender.Indicator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]defender.Indicator, len(s.indicators))
+	for id, ind := range s.indicators {
+		out[id] = ind
+	}
+	return out
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeError(w, http.StatusUnauthorized, "Unauthorized", "missing bearer token")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/indicators/import":
+		s.importIndicators(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/indicators/BatchDelete":
+		s.deleteIndicators(w, r)
+	case r.Method == http.MethodPost && len(parts) == 4 && parts[1] == "machines":
+		s.startAction(w, r, parts[2], parts[3])
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[1] == "machineactions":
+		s.getAction(w, parts[2])
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[1] == "machineactions" && parts[3] == "GetPackageUri":
+		s.packageURI(w, parts[2])
+	default:
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "no route for "+r.URL.Path)
+	}
+}
+
+func (s *Server) importIndicators(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Indicators []defender.Indicator `json:"Indicators"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequestBody", err.Error())
+		return
+	}
+	if len(req.Indicators) > defender.MaxImportBatch {
+		writeError(w, http.StatusBadRequest, "InvalidRequestBody", "too many indicators")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var results []defender.ImportResult
+	for _, ind := range req.Indicators {
+		if reason, ok := s.Reject[ind.IndicatorValue]; ok {
+			results = append(results, defender.ImportResult{
+				Indicator: ind.IndicatorValue, IsFailed: true, FailureReason: reason,
+			})
+			continue
+		}
+		// Importing an existing value and type updates it in place
+		id := ""
+		for existingID, existing := range s.indicators {
+			if existing.IndicatorValue == ind.IndicatorValue && existing.IndicatorType == ind.IndicatorType {
+				id = existingID
+				break
+			}
+		}
+		if id == "" {
+			s.nextID++
+			id = fmt.Sprint(s.nextID)
+		}
+		s.indicators[id] = ind
+		results = append(results, defender.ImportResult{ID: id, Indicator: ind.IndicatorValue})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": results})
+}
+
+func (s *Server) deleteIndicators(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IndicatorIDs []string `json:"IndicatorIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequestBody", err.Error())
+		return
+	}
+	s.mu.Lock()
+	for _, id := range req.IndicatorIDs {
+		delete(s.indicators, id)
+	}
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// actionTypes maps machine endpoints to the action types they start
+var actionTypes = map[string]string{
+	"isolate":                     defender.ActionIsolate,
+	"unisolate":                   defender.ActionUnisolate,
+	"runAntiVirusScan":            defender.ActionRunAntiVirusScan,
+	"restrictCodeExecution":       defender.ActionRestrictCodeExecution,
+	"unrestrictCodeExecution":     defender.ActionUnrestrictCodeExecution,
+	"collectInvestigationPackage": defender.ActionCollectInvestigationPackage,
+}
+
+func (s *Server) startAction(w http.ResponseWriter, r *http.Request, machineID, endpoint string) {
+	actionType, ok := actionTypes[endpoint]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "no route for "+r.URL.Path)
+		return
+	}
+	var req defender.MachineActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequestBody", err.Error())
+		return
+	}
+	if req.Comment == "" {
+		writeError(w, http.StatusBadRequest, "InvalidRequestBody", "Comment is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dnsName, ok := s.machines[machineID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "Machine "+machineID+" was not found")
+		return
+	}
+	for _, a := range s.actions {
+		if a.MachineID == machineID && a.Type == actionType && !defender.IsFinal(a.Status) {
+			writeError(w, http.StatusBadRequest, "ActiveRequestAlreadyExists",
+				"Action is already in progress")
+			return
+		}
+	}
+	s.nextID++
+	now := time.Now().UTC()
+	action := &defender.MachineAction{
+		ID:                    fmt.Sprintf("44444444-0000-0000-0000-%012d", s.nextID),
+		Type:                  actionType,
+		Status:                defender.ActionPending,
+		MachineID:             machineID,
+		ComputerDNSName:       dnsName,
+		Requestor:             "defendertest",
+		RequestorComment:      req.Comment,
+		CreationDateTimeUtc:   now,
+		LastUpdateDateTimeUtc: now,
+	}
+	s.actions[action.ID] = action
+	writeJSON(w, http.StatusCreated, action)
+}
+
+// getAction returns an action, then moves it one step towards its end
+func (s *Server) getAction(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	action, ok := s.actions[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "Machine action "+id+" was not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, action)
+
+	switch action.Status {
+	case defender.ActionPending:
+		action.Status = defender.ActionInProgress
+	case defender.ActionInProgress:
+		action.Status = defender.ActionSucceeded
+		if s.Fail[action.MachineID] {
+			action.Status = defender.ActionFailed
+		}
+	default:
+		return
+	}
+	action.LastUpdateDateTimeUtc = time.Now().UTC()
+}
+
+func (s *Server) packageURI(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	action, ok := s.actions[id]
+	if !ok || action.Type != defender.ActionCollectInvestigationPackage {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "Machine action "+id+" was not found")
+		return
+	}
+	if action.Status != defender.ActionSucceeded {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "The package is not ready")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"value": s.URL + "/packages/" + id + ".zip"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
@@ -0,0 +1,165 @@
+package defender
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+)
+
+// Machine action types
+const (
+	ActionIsolate                     = "Isolate"
+	ActionUnisolate                   = "Unisolate"
+	ActionRunAntiVirusScan            = "RunAntiVirusScan"
+	ActionRestrictCodeExecution       = "RestrictCodeExecution"
+	ActionUnrestrictCodeExecution     = "UnrestrictCodeExecution"
+	ActionCollectInvestigationPackage = "CollectInvestigationPackage"
+)
+
+// Machine action statuses. Pending and InProgress actions are still
+// running; the others are final.
+const (
+	ActionPending    = "Pending"
+	ActionInProgress = "InProgress"
+	ActionSucceeded  = "Succeeded"
+	ActionFailed     = "Failed"
+	ActionTimeOut    = "TimeOut"
+	ActionCancelled  = "Cancelled"
+)
+
+// Isolation and scan types
+const (
+	IsolationFull      = "Full"
+	IsolationSelective = "Selective"
+	ScanQuick          = "Quick"
+	ScanFull           = "Full"
+)
+
+// actionPaths maps action types to the machine endpoints starting them
+var actionPaths = map[string]string{
+	ActionIsolate:                     "isolate",
+	ActionUnisolate:                   "unisolate",
+	ActionRunAntiVirusScan:            "runAntiVirusScan",
+	ActionRestrictCodeExecution:       "restrictCodeExecution",
+	ActionUnrestrictCodeExecution:     "unrestrictCodeExecution",
+	ActionCollectInvestigationPackage: "collectInvestigationPackage",
+}
+
+// IsActionType reports whether t is a machine action type
+func IsActionType(t string) bool {
+	_, ok := actionPaths[t]
+	return ok
+}
+
+// IsFinal reports whether a machine action status will not change
+func IsFinal(status string) bool {
+	return status != ActionPending && status != ActionInProgress
+}
+
+// MachineActionRequest is the body starting a machine action. IsolationType
+// applies to Isolate and ScanType to RunAntiVirusScan.
+type MachineActionRequest struct {
+	Comment       string `json:"Comment"`
+	IsolationType string `json:"IsolationType,omitempty"`
+	ScanType      string `json:"ScanType,omitempty"`
+}
+
+// MachineAction is a response action taken on a machine
+type MachineAction struct {
+	ID                    string    `json:"id"`
+	Type                  string    `json:"type"`
+	Status                string    `json:"status"`
+	MachineID             string    `json:"machineId"`
+	ComputerDNSName       string    `json:"computerDnsName"`
+	Requestor             string    `json:"requestor"`
+	RequestorComment      string    `json:"requestorComment"`
+	CreationDateTimeUtc   time.Time `json:"creationDateTimeUtc"`
+	LastUpdateDateTimeUtc time.Time `json:"lastUpdateDateTimeUtc"`
+}
+
+// MachineActions starts response actions on machines and follows them
+type MachineActions interface {
+	// StartMachineAction starts an action of a type on a machine. Defender
+	// refuses with ActiveRequestAlreadyExists while an action of the same
+	// type is pending on the machine.
+	StartMachineAction(ctx context.Context, machineID, actionType string, req MachineActionRequest) (*MachineAction, error)
+	// MachineAction returns the current state of an action
+	MachineAction(ctx context.Context, id string) (*MachineAction, error)
+	// PackageURI returns a short-lived download link for the package a
+	// succeeded CollectInvestigationPackage action collected
+	PackageURI(ctx context.Context, actionID string) (string, error)
+}
+
+// Source returns the machine actions API of a client's tenant
+type Source interface {
+	MachineActions(ctx context.Context, clientID string) (MachineActions, error)
+}
+
+// TokenSource returns a token provider for a client tenant
+type TokenSource interface {
+	TokenProvider(ctx context.Context, clientID string) (azure.TokenProvider, error)
+}
+
+// TenantSource calls Defender with each client's app registration, which
+// needs Machine.Isolate, Machine.Scan, Machine.RestrictExecution,
+// Machine.CollectForensics and Machine.Read.All
+type TenantSource struct {
+	// BaseURL overrides DefaultBaseURL, such as for a fake API
+	BaseURL string
+	tokens  TokenSource
+}
+
+// NewTenantSource creates a source signing in with tokens
+func NewTenantSource(tokens TokenSource) *TenantSource {
+	return &TenantSource{tokens: tokens}
+}
+
+// MachineActions implements Source
+func (t *TenantSource) MachineActions(ctx context.Context, clientID string) (MachineActions, error) {
+	tokens, err := t.tokens.TokenProvider(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(tokens)
+	if t.BaseURL != "" {
+		client.BaseURL = t.BaseURL
+	}
+	return client, nil
+}
+
+// StartMachineAction implements MachineActions
+func (c *Client) StartMachineAction(ctx context.Context, machineID, actionType string, req MachineActionRequest) (*MachineAction, error) {
+	path, ok := actionPaths[actionType]
+	if !ok {
+		return nil, fmt.Errorf("unknown machine action type %q", actionType)
+	}
+	var action MachineAction
+	if err := c.do(ctx, http.MethodPost, "/api/machines/"+url.PathEscape(machineID)+"/"+path, req, &action); err != nil {
+		return nil, err
+	}
+	return &action, nil
+}
+
+// MachineAction implements MachineActions
+func (c *Client) MachineAction(ctx context.Context, id string) (*MachineAction, error) {
+	var action MachineAction
+	if err := c.do(ctx, http.MethodGet, "/api/machineactions/"+url.PathEscape(id), nil, &action); err != nil {
+		return nil, err
+	}
+	return &action, nil
+}
+
+// PackageURI implements MachineActions
+func (c *Client) PackageURI(ctx context.Context, actionID string) (string, error) {
+	var resp struct {
+		Value string `json:"value"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/machineactions/"+url.PathEscape(actionID)+"/GetPackageUri", nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.Value, nil
+}
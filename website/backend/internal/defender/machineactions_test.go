@@ -0,0 +1,171 @@
+package defender_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/defender"
+	"github.com/ClarityXDR/prod/website/backend/internal/defender/defendertest"
+)
+
+const (
+	machine       = "1e5bc9d7e413ddd7902c2932e418702b84d0cc07"
+	failedMachine = "2f6cd0e8f524eee8a13d3a43f529813c95e1dd18"
+)
+
+func newServer() *defendertest.Server {
+	server := defendertest.NewServer()
+	server.AddMachine(machine, "ws-001.contoso.local")
+	server.AddMachine(failedMachine, "ws-002.contoso.local")
+	server.Fail[failedMachine] = true
+	return server
+}
+
+// follow reads an action until it is final and returns the statuses seen
+func follow(t *testing.T, c *defender.Client, id string) []string {
+	t.Helper()
+	var statuses []string
+	for i := 0; i < 5; i++ {
+		action, err := c.MachineAction(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, action.Status)
+		if defender.IsFinal(action.Status) {
+			return statuses
+		}
+	}
+	t.Fatalf("action %s did not finish: %v", id, statuses)
+	return nil
+}
+
+func TestClientStartMachineAction(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+	c := server.Client()
+	ctx := context.Background()
+
+	action, err := c.StartMachineAction(ctx, machine, defender.ActionIsolate, defender.MachineActionRequest{
+		Comment: "Isolated for incident 42", IsolationType: defender.IsolationSelective,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action.ID == "" || action.Type != defender.ActionIsolate || action.Status != defender.ActionPending ||
+		action.MachineID != machine || action.ComputerDNSName != "ws-001.contoso.local" ||
+		action.RequestorComment != "Isolated for incident 42" {
+		t.Errorf("started action = %+v", action)
+	}
+	if held := server.MachineActions(machine); len(held) != 1 || held[0].ID != action.ID {
+		t.Errorf("defender holds %+v, want the started action", held)
+	}
+
+	if _, err := c.StartMachineAction(ctx, machine, "Reboot", defender.MachineActionRequest{Comment: "x"}); err == nil {
+		t.Error("starting an unknown action type succeeded")
+	}
+	_, err = c.StartMachineAction(ctx, "0000000000000000000000000000000000000000", defender.ActionIsolate,
+		defender.MachineActionRequest{Comment: "x"})
+	if !defender.IsNotFound(err) {
+		t.Errorf("starting an action on an unknown machine returned %v, want a 404", err)
+	}
+}
+
+func TestClientStartMachineActionAlreadyRunning(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+	c := server.Client()
+	ctx := context.Background()
+	req := defender.MachineActionRequest{Comment: "Scan for incident 42", ScanType: defender.ScanQuick}
+
+	first, err := c.StartMachineAction(ctx, machine, defender.ActionRunAntiVirusScan, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.StartMachineAction(ctx, machine, defender.ActionRunAntiVirusScan, req)
+	var apiErr *defender.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != "ActiveRequestAlreadyExists" {
+		t.Fatalf("starting a second scan returned %v, want ActiveRequestAlreadyExists", err)
+	}
+
+	// Other action types and finished actions do not block
+	if _, err := c.StartMachineAction(ctx, machine, defender.ActionCollectInvestigationPackage,
+		defender.MachineActionRequest{Comment: "Collect for incident 42"}); err != nil {
+		t.Errorf("collecting a package while a scan runs: %v", err)
+	}
+	follow(t, c, first.ID)
+	if _, err := c.StartMachineAction(ctx, machine, defender.ActionRunAntiVirusScan, req); err != nil {
+		t.Errorf("scanning again once the first scan finished: %v", err)
+	}
+}
+
+func TestClientMachineActionProgress(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+	c := server.Client()
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		machine string
+		want    []string
+	}{
+		{machine, []string{defender.ActionPending, defender.ActionInProgress, defender.ActionSucceeded}},
+		{failedMachine, []string{defender.ActionPending, defender.ActionInProgress, defender.ActionFailed}},
+	} {
+		action, err := c.StartMachineAction(ctx, tt.machine, defender.ActionRestrictCodeExecution,
+			defender.MachineActionRequest{Comment: "Restricted for incident 42"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := follow(t, c, action.ID)
+		if len(got) != len(tt.want) {
+			t.Errorf("action on %s went %v, want %v", tt.machine, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("action on %s went %v, want %v", tt.machine, got, tt.want)
+				break
+			}
+		}
+	}
+
+	if _, err := c.MachineAction(ctx, "44444444-0000-0000-0000-999999999999"); !defender.IsNotFound(err) {
+		t.Errorf("reading an unknown action returned %v, want a 404", err)
+	}
+}
+
+func TestClientPackageURI(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+	c := server.Client()
+	ctx := context.Background()
+
+	action, err := c.StartMachineAction(ctx, machine, defender.ActionCollectInvestigationPackage,
+		defender.MachineActionRequest{Comment: "Collect for incident 42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri, err := c.PackageURI(ctx, action.ID); err == nil {
+		t.Errorf("package of a pending collection = %q, want an error", uri)
+	}
+	follow(t, c, action.ID)
+	uri, err := c.PackageURI(ctx, action.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri != server.URL+"/packages/"+action.ID+".zip" {
+		t.Errorf("package URI = %q", uri)
+	}
+
+	scan, err := c.StartMachineAction(ctx, machine, defender.ActionRunAntiVirusScan,
+		defender.MachineActionRequest{Comment: "Scan for incident 42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	follow(t, c, scan.ID)
+	if _, err := c.PackageURI(ctx, scan.ID); !defender.IsNotFound(err) {
+		t.Errorf("package of a scan returned %v, want a 404", err)
+	}
+}
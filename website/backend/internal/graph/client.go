@@ -0,0 +1,248 @@
+// Package graph calls Microsoft Graph in client tenants to read the app
+// roles of service principals and grant them to managed identities
+package graph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+)
+
+// DefaultBaseURL is the Microsoft Graph v1.0 endpoint
+const DefaultBaseURL = "https://graph.microsoft.com/v1.0"
+
+// Application IDs of the first-party APIs Logic Apps call
+const (
+	AppGraph    = "00000003-0000-0000-c000-000000000000"
+	AppDefender = "fc780465-2017-40d4-a0c5-307022471b92"
+	AppExchange = "00000002-0000-0ff1-ce00-000000000000"
+)
+
+// ServicePrincipal is the service principal of an application in a tenant
+type ServicePrincipal struct {
+	ID          string    `json:"id"`
+	AppID       string    `json:"appId"`
+	DisplayName string    `json:"displayName"`
+	AppRoles    []AppRole `json:"appRoles"`
+}
+
+// AppRole is an application permission an API offers
+type AppRole struct {
+	ID                 string   `json:"id"`
+	Value              string   `json:"value"`
+	AllowedMemberTypes []string `json:"allowedMemberTypes"`
+}
+
+// Role returns the app role applications can be granted with a value
+func (sp *ServicePrincipal) Role(value string) (AppRole, bool) {
+	for _, r := range sp.AppRoles {
+		if r.Value != value {
+			continue
+		}
+		for _, t := range r.AllowedMemberTypes {
+			if t == "Application" {
+				return r, true
+			}
+		}
+	}
+	return AppRole{}, false
+}
+
+// AppRoleAssignment grants an app role of a resource to a principal
+type AppRoleAssignment struct {
+	ID                  string `json:"id,omitempty"`
+	PrincipalID         string `json:"principalId"`
+	ResourceID          string `json:"resourceId"`
+	ResourceDisplayName string `json:"resourceDisplayName,omitempty"`
+	AppRoleID           string `json:"appRoleId"`
+}
+
+// Directory reads service principals and grants app roles in a tenant
+type Directory interface {
+	// ServicePrincipal finds the service principal of an application. It
+	// fails with an Error for which IsNotFound holds when the tenant has
+	// none.
+	ServicePrincipal(ctx context.Context, appID string) (*ServicePrincipal, error)
+	// AppRoleAssignments returns the app roles granted to a principal
+	AppRoleAssignments(ctx context.Context, principalID string) ([]AppRoleAssignment, error)
+	// AssignAppRole grants an app role of a resource to a principal
+	AssignAppRole(ctx context.Context, principalID, resourceID, appRoleID string) (*AppRoleAssignment, error)
+}
+
+// Source returns the directory of a client's tenant
+type Source interface {
+	Directory(ctx context.Context, clientID string) (Directory, error)
+}
+
+// TokenSource returns a token provider for a client tenant
+type TokenSource interface {
+	TokenProvider(ctx context.Context, clientID string) (azure.TokenProvider, error)
+}
+
+// TenantSource calls Graph with each client's app registration, which
+// needs Application.Read.All and AppRoleAssignment.ReadWrite.All
+type TenantSource struct {
+	// BaseURL overrides DefaultBaseURL, such as for a fake API
+	BaseURL string
+	tokens  TokenSource
+}
+
+// NewTenantSource creates a source signing in with tokens
+func NewTenantSource(tokens TokenSource) *TenantSource {
+	return &TenantSource{tokens: tokens}
+}
+
+// Directory implements Source
+func (t *TenantSource) Directory(ctx context.Context, clientID string) (Directory, error) {
+	tokens, err := t.tokens.TokenProvider(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(tokens)
+	if t.BaseURL != "" {
+		client.BaseURL = t.BaseURL
+	}
+	return client, nil
+}
+
+// Client calls Microsoft Graph
+type Client struct {
+	HTTPClient *http.Client
+	BaseURL    string
+	Tokens     azure.TokenProvider
+}
+
+// NewClient creates a client for the tenant the token provider belongs to
+func NewClient(tokens azure.TokenProvider) *Client {
+	return &Client{
+		HTTPClient: &http.Client{Timeout: time.Minute},
+		BaseURL:    DefaultBaseURL,
+		Tokens:     tokens,
+	}
+}
+
+// Error is an error response from Graph
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("graph returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("graph returned %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsNotFound reports whether err is a Graph 404
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// ServicePrincipal implements Directory
+func (c *Client) ServicePrincipal(ctx context.Context, appID string) (*ServicePrincipal, error) {
+	query := url.Values{"$filter": {fmt.Sprintf("appId eq '%s'", strings.ReplaceAll(appID, "'", "''"))}}
+	var page struct {
+		Value []ServicePrincipal `json:"value"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.url("/servicePrincipals")+"?"+query.Encode(), nil, &page); err != nil {
+		return nil, err
+	}
+	if len(page.Value) == 0 {
+		return nil, &Error{StatusCode: http.StatusNotFound, Code: "Request_ResourceNotFound",
+			Message: "no service principal for application " + appID}
+	}
+	return &page.Value[0], nil
+}
+
+// AppRoleAssignments implements Directory. It follows @odata.nextLink
+// across pages.
+func (c *Client) AppRoleAssignments(ctx context.Context, principalID string) ([]AppRoleAssignment, error) {
+	assignments := []AppRoleAssignment{}
+	target := c.url("/servicePrincipals/" + url.PathEscape(principalID) + "/appRoleAssignments")
+	for target != "" {
+		var page struct {
+			Value    []AppRoleAssignment `json:"value"`
+			NextLink string              `json:"@odata.nextLink"`
+		}
+		if err := c.do(ctx, http.MethodGet, target, nil, &page); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, page.Value...)
+		target = page.NextLink
+	}
+	return assignments, nil
+}
+
+// AssignAppRole implements Directory
+func (c *Client) AssignAppRole(ctx context.Context, principalID, resourceID, appRoleID string) (*AppRoleAssignment, error) {
+	in := AppRoleAssignment{PrincipalID: principalID, ResourceID: resourceID, AppRoleID: appRoleID}
+	var out AppRoleAssignment
+	target := c.url("/servicePrincipals/" + url.PathEscape(principalID) + "/appRoleAssignments")
+	if err := c.do(ctx, http.MethodPost, target, in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) url(path string) string {
+	return strings.TrimRight(c.BaseURL, "/") + path
+}
+
+// do sends a JSON request and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, target string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	token, err := c.Tokens.Token(ctx, azure.ScopeGraph)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		var envelope struct {
+			Error *Error `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&envelope) == nil && envelope.Error != nil {
+			apiErr.Code, apiErr.Message = envelope.Error.Code, envelope.Error.Message
+		}
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,221 @@
+// Package graphtest provides an in-process Microsoft Graph for exercising
+// app role grants without network access.
+package graphtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/graph"
+)
+
+// roles are the app roles the first-party APIs of a new server offer
+var roles = map[string][]string{
+	graph.AppGraph: {
+		"User.Read.All", "User.ReadWrite.All", "User.RevokeSessions.All", "Policy.Read.All",
+		"Policy.ReadWrite.ConditionalAccess", "SecurityIncident.Read.All", "SecurityIncident.ReadWrite.All",
+		"SecurityAlert.Read.All", "SecurityEvents.Read.All", "ThreatIntelligence.Read.All",
+		"OrgContact.Read.All", "Mail.Send",
+	},
+	graph.AppDefender: {
+		"Ti.Read.All", "Ti.ReadWrite.All", "Machine.Isolate", "Machine.Scan", "Machine.RestrictExecution",
+		"Machine.CollectForensics", "Machine.Offboard", "Machine.Read.All", "Alert.Read.All",
+		"AdvancedQuery.Read.All",
+	},
+	graph.AppExchange: {"Exchange.ManageAsApp"},
+}
+
+var displayNames = map[string]string{
+	graph.AppGraph:    "Microsoft Graph",
+	graph.AppDefender: "WindowsDefenderATP",
+	graph.AppExchange: "Office 365 Exchange Online",
+}
+
+// Server is a fake Graph holding service principals and the app roles
+// granted to them. It starts with the service principals of Graph,
+// Defender for Endpoint and Exchange Online. Point a graph.Client at its
+// URL with any token.
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	principals  map[string]*graph.ServicePrincipal // by object ID
+	assignments []graph.AppRoleAssignment
+	nextID      int
+}
+
+// NewServer starts a fake server; call Close when done
+func NewServer() *Server {
+	s := &Server{principals: make(map[string]*graph.ServicePrincipal)}
+	for _, appID := range []string{graph.AppGraph, graph.AppDefender, graph.AppExchange} {
+		sp := &graph.ServicePrincipal{ID: s.newID(), AppID: appID, DisplayName: displayNames[appID]}
+		for _, value := range roles[appID] {
+			sp.AppRoles = append(sp.AppRoles, graph.AppRole{
+				ID: s.newID(), Value: value, AllowedMemberTypes: []string{"Application"},
+			})
+		}
+		s.principals[sp.ID] = sp
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Source returns a directory source whose clients, for any client tenant,
+// call the fake
+func (s *Server) Source() graph.Source {
+	return source{s}
+}
+
+type source struct{ s *Server }
+
+func (src source) Directory(ctx context.Context, clientID string) (graph.Directory, error) {
+	return src.s.Client(), nil
+}
+
+// Client returns a client calling the fake
+func (s *Server) Client() *graph.Client {
+	client := graph.NewClient(azure.StaticToken("graphtest"))
+	client.BaseURL = s.URL
+	return client
+}
+
+// AddPrincipal adds a service principal, such as the managed identity of
+// a workflow, that app roles can be granted to
+func (s *Server) AddPrincipal(id, displayName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.principals[id] = &graph.ServicePrincipal{ID: id, DisplayName: displayName}
+}
+
+// Assignments returns the app roles granted to a principal
+func (s *Server) Assignments(principalID string) []graph.AppRoleAssignment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []graph.AppRoleAssignment
+	for _, a := range s.assignments {
+		if a.PrincipalID == principalID {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// newID returns a GUID-shaped ID; the caller holds s.mu or owns s
+func (s *Server) newID() string {
+	s.nextID++
+	return fmt.Sprintf("33333333-0000-0000-0000-%012d", s.nextID)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeError(w, http.StatusUnauthorized, "InvalidAuthenticationToken", "missing bearer token")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 1 && parts[0] == "servicePrincipals":
+		s.findPrincipals(w, r)
+	case len(parts) == 3 && parts[0] == "servicePrincipals" && parts[2] == "appRoleAssignments":
+		switch r.Method {
+		case http.MethodGet:
+			s.listAssignments(w, parts[1])
+		case http.MethodPost:
+			s.assign(w, r, parts[1])
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Request_BadRequest", "method not allowed")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "Request_ResourceNotFound", "no route for "+r.URL.Path)
+	}
+}
+
+// findPrincipals answers $filter=appId eq '...', the only filter supported
+func (s *Server) findPrincipals(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("$filter")
+	appID := strings.TrimSuffix(strings.TrimPrefix(filter, "appId eq '"), "'")
+	if appID == filter {
+		writeError(w, http.StatusBadRequest, "Request_UnsupportedQuery", "unsupported filter "+filter)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := []graph.ServicePrincipal{}
+	for _, sp := range s.principals {
+		if sp.AppID == appID {
+			found = append(found, *sp)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": found})
+}
+
+func (s *Server) listAssignments(w http.ResponseWriter, principalID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.principals[principalID]; !ok {
+		writeError(w, http.StatusNotFound, "Request_ResourceNotFound", "principal "+principalID+" does not exist")
+		return
+	}
+	found := []graph.AppRoleAssignment{}
+	for _, a := range s.assignments {
+		if a.PrincipalID == principalID {
+			found = append(found, a)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": found})
+}
+
+func (s *Server) assign(w http.ResponseWriter, r *http.Request, principalID string) {
+	var in graph.AppRoleAssignment
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "Request_BadRequest", err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.principals[principalID]; !ok || in.PrincipalID != principalID {
+		writeError(w, http.StatusNotFound, "Request_ResourceNotFound", "principal "+principalID+" does not exist")
+		return
+	}
+	resource, ok := s.principals[in.ResourceID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Request_ResourceNotFound", "resource "+in.ResourceID+" does not exist")
+		return
+	}
+	known := false
+	for _, role := range resource.AppRoles {
+		known = known || role.ID == in.AppRoleID
+	}
+	if !known {
+		writeError(w, http.StatusBadRequest, "Request_BadRequest", "Permission being assigned was not found on application")
+		return
+	}
+	for _, a := range s.assignments {
+		if a.PrincipalID == in.PrincipalID && a.ResourceID == in.ResourceID && a.AppRoleID == in.AppRoleID {
+			writeError(w, http.StatusBadRequest, "Request_BadRequest", "Permission being assigned already exists on the object")
+			return
+		}
+	}
+	in.ID = s.newID()
+	in.ResourceDisplayName = resource.DisplayName
+	s.assignments = append(s.assignments, in)
+	writeJSON(w, http.StatusCreated, in)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
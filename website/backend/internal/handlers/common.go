@@ -18,9 +18,7 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(response)
 }
 
-// isAuthenticated checks if the request is authenticated
+// isAuthenticated checks if the auth middleware identified a user
 func isAuthenticated(r *http.Request) bool {
-	// TODO: Implement proper authentication
-	// For now, return true to allow development
-	return true
+	return r.Context().Value("userID") != nil
 }
@@ -183,22 +183,3 @@ func (h *LicenseHandler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/licensing/licenses", h.CreateLicense).Methods("POST")
 	// ...existing code...
 }
-
-// Helper functions that were missing
-func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	response, _ := json.Marshal(payload)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	w.Write(response)
-}
-
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, map[string]string{"error": message})
-}
-
-func isAuthenticated(r *http.Request) bool {
-	// Check if the request has valid authentication
-	// This should match your authentication middleware
-	userID := r.Context().Value("userID")
-	return userID != nil
-}
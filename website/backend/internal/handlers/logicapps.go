@@ -1,34 +1,40 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
-	"strings"
 
-	"github.com/ClarityXDR/backend/internal/db"
+	"github.com/ClarityXDR/prod/website/backend/internal/arm"
+	"github.com/ClarityXDR/prod/website/backend/internal/db"
+	"github.com/ClarityXDR/prod/website/backend/internal/logicapp"
 	"github.com/gorilla/mux"
 )
 
-// AzureClient handles Azure API interactions
+// AzureClient deploys Logic Apps through Azure Resource Manager, signing
+// in to each client tenant with its app registration
 type AzureClient struct {
-	// Add Azure SDK client fields here
+	managers arm.Source
 }
 
-// NewAzureClient creates a new Azure client
-func NewAzureClient() *AzureClient {
-	return &AzureClient{
-		// Initialize with Azure credentials
-	}
+// NewAzureClient creates an Azure client
+func NewAzureClient(managers arm.Source) *AzureClient {
+	return &AzureClient{managers: managers}
 }
 
-// DeployLogicApp deploys a Logic App to Azure
-func (ac *AzureClient) DeployLogicApp(subscriptionID, resourceGroup, logicAppName, template string) error {
-	// TODO: Implement Azure Logic App deployment
-	// This would use Azure SDK to deploy the Logic App
-	return nil
+// DeployLogicApp puts a workflow template into a client's resource group
+// and waits until Resource Manager reports it provisioned. Errors carry
+// the details Resource Manager gave.
+func (ac *AzureClient) DeployLogicApp(ctx context.Context, clientID, subscriptionID, resourceGroup, logicAppName string, t *logicapp.Template) (*logicapp.Workflow, error) {
+	manager, err := ac.managers.Manager(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	return logicapp.PutWorkflow(ctx, manager, subscriptionID, resourceGroup, logicAppName, "", t, nil)
 }
 
 type LogicAppHandler struct {
@@ -37,10 +43,10 @@ type LogicAppHandler struct {
 	licenseEndpoint string
 }
 
-func NewLogicAppHandler(database *db.Database) *LogicAppHandler {
+func NewLogicAppHandler(database *db.Database, managers arm.Source) *LogicAppHandler {
 	return &LogicAppHandler{
 		db:              database,
-		azureClient:     NewAzureClient(),
+		azureClient:     NewAzureClient(managers),
 		licenseEndpoint: os.Getenv("LICENSE_API_ENDPOINT"),
 	}
 }
@@ -99,12 +105,24 @@ func (h *LogicAppHandler) DeployLogicApp(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Process template - inject license information
-	processedTemplate := h.processTemplate(string(templateContent), req.ClientID, licenseKey)
+	processedTemplate, err := h.processTemplate(templateContent, req.ClientID, licenseKey)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	// Deploy to Azure
-	err = h.azureClient.DeployLogicApp(req.SubscriptionID, req.ResourceGroup, req.LogicAppName, processedTemplate)
+	// Deploy to Azure. Only a deployment Resource Manager confirmed is
+	// recorded as a success.
+	workflow, err := h.azureClient.DeployLogicApp(r.Context(), req.ClientID, req.SubscriptionID, req.ResourceGroup, req.LogicAppName, processedTemplate)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to deploy Logic App")
+		_, recordErr := h.db.DB.Exec(`
+			INSERT INTO logic_app_deployments (client_id, logic_app_name, subscription_id, resource_group, template_name, deployed_at, status, error_message)
+			VALUES ($1, $2, $3, $4, $5, NOW(), 'Failed', $6)
+		`, clientDBID, req.LogicAppName, req.SubscriptionID, req.ResourceGroup, req.TemplateName, err.Error())
+		if recordErr != nil {
+			log.Printf("Error recording failed deployment of %s: %v", req.LogicAppName, recordErr)
+		}
+		respondWithError(w, http.StatusBadGateway, "Failed to deploy Logic App: "+err.Error())
 		return
 	}
 
@@ -113,30 +131,44 @@ func (h *LogicAppHandler) DeployLogicApp(w http.ResponseWriter, r *http.Request)
 		INSERT INTO logic_app_deployments (client_id, logic_app_name, subscription_id, resource_group, template_name, deployed_at, status)
 		VALUES ($1, $2, $3, $4, $5, NOW(), 'Success')
 	`, clientDBID, req.LogicAppName, req.SubscriptionID, req.ResourceGroup, req.TemplateName)
-
 	if err != nil {
-		// Log error but don't fail the deployment response
-		// The Logic App was deployed successfully, just couldn't record it
-		// You might want to add proper logging here
+		// The Logic App is deployed; only the record is missing
+		log.Printf("Error recording deployment of %s: %v", req.LogicAppName, err)
 	}
 
 	respondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"message": "Logic App deployed successfully",
+		"success":    true,
+		"message":    "Logic App deployed successfully",
+		"resourceId": workflow.ID,
 	})
 }
 
-func (h *LogicAppHandler) processTemplate(template, clientID, licenseKey string) string {
-	// Replace placeholders with actual values
-	template = strings.ReplaceAll(template, "00000000-0000-0000-0000-000000000000", licenseKey)
-	template = strings.ReplaceAll(template, "@{parameters('ClientID')}", clientID)
-
-	// Update license validation endpoint
-	if h.licenseEndpoint != "" {
-		template = strings.ReplaceAll(template, "https://api.yourcompany.com/licensing/validate", h.licenseEndpoint)
+// processTemplate sets the licensing parameters a template declares: the
+// client, its license key and the license validation endpoint. Values are
+// set as workflow parameters; the template text is left as it is.
+func (h *LogicAppHandler) processTemplate(content []byte, clientID, licenseKey string) (*logicapp.Template, error) {
+	t, err := logicapp.ParseTemplate(content)
+	if err != nil {
+		return nil, err
 	}
-
-	return template
+	schema, err := logicapp.Analyze(t)
+	if err != nil {
+		return nil, err
+	}
+	licensing := map[string]string{
+		logicapp.ParamClientID:        clientID,
+		logicapp.ParamLicenseGUID:     licenseKey,
+		logicapp.ParamLicenseEndpoint: h.licenseEndpoint,
+	}
+	values := make(map[string]json.RawMessage)
+	for _, p := range schema.Parameters {
+		if v := licensing[p.Name]; v != "" {
+			if values[p.Name], err = json.Marshal(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return logicapp.Render(t, values)
 }
 
 func validateDeploymentRequest(req LogicAppDeploymentRequest) error {
@@ -174,24 +206,9 @@ func isValidAzureResourceName(name string) bool {
 
 func (h *LogicAppHandler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/logicapps/deploy", h.DeployLogicApp).Methods("POST")
-	router.HandleFunc("/logicapps/disable", h.DisableLogicApp).Methods("POST")
-	router.HandleFunc("/logicapps/templates", h.GetTemplates).Methods("GET")
 	router.HandleFunc("/logicapps/clients", h.GetClients).Methods("GET")
 }
 
-// Add missing handler methods
-func (h *LogicAppHandler) DisableLogicApp(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement disable logic app functionality
-	respondWithError(w, http.StatusNotImplemented, "Not implemented yet")
-}
-
-func (h *LogicAppHandler) GetTemplates(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement get templates functionality
-	respondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"templates": []string{"basic", "advanced", "enterprise"},
-	})
-}
-
 func (h *LogicAppHandler) GetClients(w http.ResponseWriter, r *http.Request) {
 	// TODO: Implement get clients functionality
 	respondWithError(w, http.StatusNotImplemented, "Not implemented yet")
@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
-	"github.com/ClarityXDR/backend/internal/db"
+	"github.com/ClarityXDR/prod/website/backend/internal/db"
 	"github.com/gorilla/mux"
 )
 
@@ -1,45 +0,0 @@
-package handlers
-
-import (
-	"net/http"
-
-	"github.com/ClarityXDR/backend/internal/db"
-	"github.com/gorilla/mux"
-)
-
-type SentinelHandler struct {
-	db *db.Database
-}
-
-func NewSentinelHandler(database *db.Database) *SentinelHandler {
-	return &SentinelHandler{
-		db: database,
-	}
-}
-
-func (h *SentinelHandler) GetClients(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement get clients for Sentinel
-	respondWithJSON(w, http.StatusOK, []interface{}{})
-}
-
-func (h *SentinelHandler) ValidateConfig(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement config validation
-	respondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"valid":   true,
-		"message": "Configuration validation not yet implemented",
-	})
-}
-
-func (h *SentinelHandler) Deploy(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement Sentinel deployment
-	respondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"message": "Sentinel deployment not yet implemented",
-	})
-}
-
-func (h *SentinelHandler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/sentinel/clients", h.GetClients).Methods("GET")
-	router.HandleFunc("/sentinel/validate", h.ValidateConfig).Methods("POST")
-	router.HandleFunc("/sentinel/deploy", h.Deploy).Methods("POST")
-}
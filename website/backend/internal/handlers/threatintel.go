@@ -3,7 +3,7 @@ package handlers
 import (
 	"net/http"
 
-	"github.com/ClarityXDR/backend/internal/db"
+	"github.com/ClarityXDR/prod/website/backend/internal/db"
 	"github.com/gorilla/mux"
 )
 
@@ -0,0 +1,89 @@
+package indicator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// DefaultLifetimes are the IndicatorExpiration defaults from
+// cti/PowerShell/CTI-Config.json. Network ranges age like addresses.
+var DefaultLifetimes = map[Type]time.Duration{
+	TypeIPv4:        30 * day,
+	TypeIPv6:        30 * day,
+	TypeCIDR:        30 * day,
+	TypeURL:         30 * day,
+	TypeDomain:      60 * day,
+	TypeMD5:         90 * day,
+	TypeSHA1:        90 * day,
+	TypeSHA256:      90 * day,
+	TypeCertificate: 90 * day,
+	TypeEmail:       15 * day,
+}
+
+// Policy decides how long indicators stay active and how quickly
+// confidence in them fades when they are not seen again
+type Policy struct {
+	// Lifetimes is the default time to expiry per type, counted from the
+	// last time the indicator was seen
+	Lifetimes map[Type]time.Duration
+	// FallbackLifetime applies to types missing from Lifetimes
+	FallbackLifetime time.Duration
+	// Confidence halves over each lifetime an indicator goes unseen, so an
+	// indicator reaching its default expiry is held with half the
+	// confidence it was last reported with. It never decays below
+	// MinConfidence.
+	MinConfidence int
+}
+
+// DefaultPolicy returns the policy configured for the CTI platform
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Lifetimes:        DefaultLifetimes,
+		FallbackLifetime: 30 * day,
+		MinConfidence:    10,
+	}
+}
+
+// Lifetime returns the default time to expiry for an indicator type
+func (p *Policy) Lifetime(t Type) time.Duration {
+	if lifetime, ok := p.Lifetimes[t]; ok {
+		return lifetime
+	}
+	// Rows written before types were normalized use generic names
+	if parsed, ok := ParseType(string(t)); ok {
+		if lifetime, ok := p.Lifetimes[parsed]; ok {
+			return lifetime
+		}
+	}
+	return p.FallbackLifetime
+}
+
+// ExpiresAt returns the default expiry of an indicator seen at the given time
+func (p *Policy) ExpiresAt(t Type, seen time.Time) time.Time {
+	return seen.Add(p.Lifetime(t))
+}
+
+// lifetimeSQL renders Lifetime as an SQL expression giving the lifetime in
+// seconds of the indicator_type column
+func (p *Policy) lifetimeSQL() string {
+	var b strings.Builder
+	b.WriteString("CASE indicator_type")
+	for _, t := range sortedTypes(p.Lifetimes) {
+		fmt.Fprintf(&b, " WHEN '%s' THEN %d", strings.ReplaceAll(string(t), "'", "''"), int64(p.Lifetimes[t].Seconds()))
+	}
+	fmt.Fprintf(&b, " ELSE %d END", int64(p.FallbackLifetime.Seconds()))
+	return b.String()
+}
+
+func sortedTypes(m map[Type]time.Duration) []Type {
+	types := make([]Type, 0, len(m))
+	for t := range m {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
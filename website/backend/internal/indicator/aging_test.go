@@ -0,0 +1,48 @@
+package indicator
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyLifetime(t *testing.T) {
+	p := DefaultPolicy()
+	tests := []struct {
+		t    Type
+		want time.Duration
+	}{
+		{TypeIPv4, 30 * day},
+		{TypeDomain, 60 * day},
+		{TypeSHA256, 90 * day},
+		{TypeEmail, 15 * day},
+		// Generic names of rows stored before normalization
+		{"ip", 30 * day},
+		{"filehash-sha256", 90 * day},
+		{"mutex", 30 * day},
+	}
+	for _, tt := range tests {
+		if got := p.Lifetime(tt.t); got != tt.want {
+			t.Errorf("Lifetime(%s) = %s, want %s", tt.t, got, tt.want)
+		}
+	}
+
+	seen := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if got, want := p.ExpiresAt(TypeDomain, seen), seen.Add(60*day); !got.Equal(want) {
+		t.Errorf("ExpiresAt(domain) = %s, want %s", got, want)
+	}
+}
+
+func TestPolicyLifetimeSQL(t *testing.T) {
+	p := &Policy{
+		Lifetimes:        map[Type]time.Duration{TypeURL: day, TypeDomain: 2 * day, "it's": time.Hour},
+		FallbackLifetime: time.Minute,
+	}
+	want := "CASE indicator_type WHEN 'domain' THEN 172800 WHEN 'it''s' THEN 3600 WHEN 'url' THEN 86400 ELSE 60 END"
+	if got := p.lifetimeSQL(); got != want {
+		t.Errorf("lifetimeSQL() = %s, want %s", got, want)
+	}
+	if got := (&Policy{FallbackLifetime: day}).lifetimeSQL(); !strings.HasSuffix(got, "ELSE 86400 END") {
+		t.Errorf("lifetimeSQL() without lifetimes = %s, want only the fallback", got)
+	}
+}
@@ -0,0 +1,239 @@
+package indicator
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Allow-list entry kinds
+const (
+	// AllowExact matches one value of any type, and ranges containing an
+	// exact address
+	AllowExact = "exact"
+	// AllowDomain matches a domain and its subdomains, and URLs and email
+	// addresses on them. A leading "*." is accepted and ignored.
+	AllowDomain = "domain"
+	// AllowCIDR matches addresses and ranges overlapping a network
+	AllowCIDR = "cidr"
+	// AllowHashSet matches the file hashes of a publisher's hash set
+	AllowHashSet = "hash_set"
+)
+
+// Review states of an indicator
+const (
+	// ReviewQuarantined indicators matched the allow-list and wait for an
+	// analyst; they are kept inactive
+	ReviewQuarantined = "quarantined"
+	// ReviewApproved indicators were released from quarantine and are not
+	// quarantined again
+	ReviewApproved = "approved"
+	// ReviewFalsePositive indicators were retracted by an analyst and stay
+	// inactive however often feeds report them
+	ReviewFalsePositive = "false_positive"
+)
+
+// AllowEntry is one allow-list entry. Entries without a ClientID apply to
+// every client.
+type AllowEntry struct {
+	ID          string `json:"id,omitempty"`
+	ClientID    string `json:"clientId,omitempty"`
+	Kind        string `json:"kind"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+	// Hashes are the members of a hash set, which Value names
+	Hashes    []string  `json:"hashes,omitempty"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+}
+
+// Normalize validates an entry and canonicalizes its value and hashes
+func (e *AllowEntry) Normalize() error {
+	value := strings.TrimSpace(e.Value)
+	switch e.Kind {
+	case AllowExact:
+		if _, canonical, err := NewNormalizer().Normalize("", value); err == nil {
+			value = canonical
+		} else {
+			value = strings.ToLower(value)
+		}
+	case AllowDomain:
+		host, err := canonicalHost(strings.TrimPrefix(Refang(value), "*."))
+		if err != nil {
+			return fmt.Errorf("invalid domain %q: %v", e.Value, err)
+		}
+		value = host
+	case AllowCIDR:
+		prefix, err := parsePrefix(value)
+		if err != nil {
+			return fmt.Errorf("invalid network %q", e.Value)
+		}
+		value = prefix.Masked().String()
+	case AllowHashSet:
+		if value == "" {
+			return fmt.Errorf("hash set needs a name")
+		}
+		if len(e.Hashes) == 0 {
+			return fmt.Errorf("hash set %q has no hashes", value)
+		}
+		for i, h := range e.Hashes {
+			h = strings.ToLower(strings.TrimSpace(h))
+			if t, ok := Detect(h); !ok || (t != TypeMD5 && t != TypeSHA1 && t != TypeSHA256) {
+				return fmt.Errorf("invalid file hash %q in hash set %q", e.Hashes[i], value)
+			}
+			e.Hashes[i] = h
+		}
+	default:
+		return fmt.Errorf("unknown allow-list kind %q", e.Kind)
+	}
+	if value == "" {
+		return fmt.Errorf("allow-list value is empty")
+	}
+	e.Value = value
+	return nil
+}
+
+// parsePrefix parses a network or a single address
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// AllowList matches indicators against allow-list entries
+type AllowList struct {
+	entries []*AllowEntry
+	exact   map[string][]*AllowEntry
+	domains map[string][]*AllowEntry
+	hashes  map[string][]*AllowEntry
+	cidrs   []allowPrefix
+}
+
+type allowPrefix struct {
+	prefix netip.Prefix
+	entry  *AllowEntry
+}
+
+// NewAllowList compiles entries, which must be normalized, into a matcher
+func NewAllowList(entries []*AllowEntry) *AllowList {
+	l := &AllowList{
+		entries: entries,
+		exact:   make(map[string][]*AllowEntry),
+		domains: make(map[string][]*AllowEntry),
+		hashes:  make(map[string][]*AllowEntry),
+	}
+	for _, e := range entries {
+		switch e.Kind {
+		case AllowExact:
+			l.exact[e.Value] = append(l.exact[e.Value], e)
+			// Ranges containing an allowed address are caught as well
+			if prefix, err := parsePrefix(e.Value); err == nil {
+				l.cidrs = append(l.cidrs, allowPrefix{prefix, e})
+			}
+		case AllowDomain:
+			l.domains[e.Value] = append(l.domains[e.Value], e)
+		case AllowCIDR:
+			if prefix, err := netip.ParsePrefix(e.Value); err == nil {
+				l.cidrs = append(l.cidrs, allowPrefix{prefix, e})
+			}
+		case AllowHashSet:
+			for _, h := range e.Hashes {
+				l.hashes[h] = append(l.hashes[h], e)
+			}
+		}
+	}
+	return l
+}
+
+// Entries returns the compiled entries
+func (l *AllowList) Entries() []*AllowEntry {
+	return l.entries
+}
+
+// Match returns the first entry covering the indicator for its own client:
+// global entries, plus the client's entries for client indicators
+func (l *AllowList) Match(ind *Indicator) (*AllowEntry, bool) {
+	return l.match(ind, func(e *AllowEntry) bool {
+		return e.ClientID == "" || e.ClientID == ind.ClientID
+	})
+}
+
+// MatchClient returns the first entry of a client's own allow-list covering
+// the indicator. It decides whether a global indicator is withheld from
+// that client.
+func (l *AllowList) MatchClient(clientID string, ind *Indicator) (*AllowEntry, bool) {
+	return l.match(ind, func(e *AllowEntry) bool {
+		return e.ClientID != "" && e.ClientID == clientID
+	})
+}
+
+func (l *AllowList) match(ind *Indicator, applies func(*AllowEntry) bool) (*AllowEntry, bool) {
+	first := func(entries []*AllowEntry) (*AllowEntry, bool) {
+		for _, e := range entries {
+			if applies(e) {
+				return e, true
+			}
+		}
+		return nil, false
+	}
+
+	value := strings.ToLower(ind.Value)
+	if e, ok := first(l.exact[value]); ok {
+		return e, true
+	}
+
+	switch ind.Type {
+	case TypeIPv4, TypeIPv6, TypeCIDR:
+		prefix, err := parsePrefix(value)
+		if err != nil {
+			return nil, false
+		}
+		for _, p := range l.cidrs {
+			// Blocking a range that overlaps the network would block part of it
+			if p.prefix.Overlaps(prefix) && applies(p.entry) {
+				return p.entry, true
+			}
+		}
+	case TypeDomain, TypeURL, TypeEmail:
+		host := value
+		switch ind.Type {
+		case TypeURL:
+			if u, err := url.Parse(value); err == nil {
+				host = u.Hostname()
+			}
+		case TypeEmail:
+			host = value[strings.LastIndex(value, "@")+1:]
+		}
+		for host != "" {
+			if e, ok := first(l.domains[host]); ok {
+				return e, true
+			}
+			dot := strings.Index(host, ".")
+			if dot < 0 {
+				break
+			}
+			host = host[dot+1:]
+		}
+	case TypeMD5, TypeSHA1, TypeSHA256:
+		if e, ok := first(l.hashes[value]); ok {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// Reason describes an entry for review_reason
+func (e *AllowEntry) Reason() string {
+	scope := "global"
+	if e.ClientID != "" {
+		scope = "client"
+	}
+	return fmt.Sprintf("matches %s allow-list %s entry %s", scope, strings.ReplaceAll(e.Kind, "_", " "), e.Value)
+}
@@ -0,0 +1,140 @@
+package indicator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllowEntryNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry AllowEntry
+		want  string
+	}{
+		{"exact value canonicalized", AllowEntry{Kind: AllowExact, Value: " hxxps://Intranet.Contoso.com:443/ "},
+			"https://intranet.contoso.com/"},
+		{"exact value not an indicator", AllowEntry{Kind: AllowExact, Value: "Build-Server-01"}, "build-server-01"},
+		{"domain wildcard dropped", AllowEntry{Kind: AllowDomain, Value: "*.Contoso.COM."}, "contoso.com"},
+		{"defanged domain", AllowEntry{Kind: AllowDomain, Value: "contoso[.]com"}, "contoso.com"},
+		{"unicode domain", AllowEntry{Kind: AllowDomain, Value: "bücher.de"}, "xn--bcher-kva.de"},
+		{"network masked", AllowEntry{Kind: AllowCIDR, Value: "192.0.2.77/24"}, "192.0.2.0/24"},
+		{"single address", AllowEntry{Kind: AllowCIDR, Value: "198.51.100.7"}, "198.51.100.7/32"},
+		{"hash set", AllowEntry{Kind: AllowHashSet, Value: "Contoso builds",
+			Hashes: []string{" D41D8CD98F00B204E9800998ECF8427E "}}, "Contoso builds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := tt.entry
+			if err := e.Normalize(); err != nil {
+				t.Fatal(err)
+			}
+			if e.Value != tt.want {
+				t.Errorf("Value = %q, want %q", e.Value, tt.want)
+			}
+		})
+	}
+
+	e := AllowEntry{Kind: AllowHashSet, Value: "set", Hashes: []string{"D41D8CD98F00B204E9800998ECF8427E"}}
+	if err := e.Normalize(); err != nil || e.Hashes[0] != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Errorf("hash set hashes = %q, %v; want them lowercased", e.Hashes, err)
+	}
+}
+
+func TestAllowEntryNormalizeRejects(t *testing.T) {
+	for name, e := range map[string]AllowEntry{
+		"unknown kind":         {Kind: "regex", Value: ".*"},
+		"empty exact value":    {Kind: AllowExact, Value: "  "},
+		"invalid domain":       {Kind: AllowDomain, Value: "not a domain"},
+		"invalid network":      {Kind: AllowCIDR, Value: "192.0.2.0/33"},
+		"unnamed hash set":     {Kind: AllowHashSet, Hashes: []string{"d41d8cd98f00b204e9800998ecf8427e"}},
+		"empty hash set":       {Kind: AllowHashSet, Value: "set"},
+		"hash set of non-hash": {Kind: AllowHashSet, Value: "set", Hashes: []string{"evil.org"}},
+	} {
+		if err := e.Normalize(); err == nil {
+			t.Errorf("%s: Normalize() accepted %+v", name, e)
+		}
+	}
+}
+
+func TestAllowListMatch(t *testing.T) {
+	const contoso, fabrikam = "11111111-1111-1111-1111-111111111111", "22222222-2222-2222-2222-222222222222"
+	var entries []*AllowEntry
+	for _, e := range []AllowEntry{
+		{ID: "exact", Kind: AllowExact, Value: "8.8.8.8"},
+		{ID: "domain", Kind: AllowDomain, Value: "contoso.com"},
+		{ID: "cidr", Kind: AllowCIDR, Value: "192.0.2.0/24"},
+		{ID: "hashes", Kind: AllowHashSet, Value: "builds", Hashes: []string{"d41d8cd98f00b204e9800998ecf8427e"}},
+		{ID: "contoso", ClientID: contoso, Kind: AllowDomain, Value: "partner.org"},
+	} {
+		e := e
+		if err := e.Normalize(); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, &e)
+	}
+	l := NewAllowList(entries)
+
+	tests := []struct {
+		name string
+		ind  Indicator
+		want string
+	}{
+		{"exact address", Indicator{Type: TypeIPv4, Value: "8.8.8.8"}, "exact"},
+		{"range holding an exact address", Indicator{Type: TypeCIDR, Value: "8.8.8.0/24"}, "exact"},
+		{"address in a network", Indicator{Type: TypeIPv4, Value: "192.0.2.10"}, "cidr"},
+		{"range overlapping a network", Indicator{Type: TypeCIDR, Value: "192.0.0.0/16"}, "cidr"},
+		{"address outside", Indicator{Type: TypeIPv4, Value: "192.0.3.1"}, ""},
+		{"domain", Indicator{Type: TypeDomain, Value: "contoso.com"}, "domain"},
+		{"subdomain", Indicator{Type: TypeDomain, Value: "VPN.Contoso.com"}, "domain"},
+		{"domain merely ending alike", Indicator{Type: TypeDomain, Value: "evilcontoso.com"}, ""},
+		{"url on a domain", Indicator{Type: TypeURL, Value: "https://login.contoso.com/x"}, "domain"},
+		{"email on a domain", Indicator{Type: TypeEmail, Value: "it@contoso.com"}, "domain"},
+		{"hash in a set", Indicator{Type: TypeMD5, Value: "D41D8CD98F00B204E9800998ECF8427E"}, "hashes"},
+		{"other hash", Indicator{Type: TypeMD5, Value: "0cc175b9c0f1b6a831c399e269772661"}, ""},
+		{"client entry on its own indicator", Indicator{ClientID: contoso, Type: TypeDomain, Value: "partner.org"}, "contoso"},
+		{"client entry on another client", Indicator{ClientID: fabrikam, Type: TypeDomain, Value: "partner.org"}, ""},
+		{"client entry on a global indicator", Indicator{Type: TypeDomain, Value: "partner.org"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, ok := l.Match(&tt.ind)
+			got := ""
+			if ok {
+				got = e.ID
+			}
+			if got != tt.want {
+				t.Errorf("Match(%s %s) = %q, want %q", tt.ind.Type, tt.ind.Value, got, tt.want)
+			}
+		})
+	}
+
+	// MatchClient only consults the client's own entries
+	global := &Indicator{Type: TypeDomain, Value: "www.partner.org"}
+	if e, ok := l.MatchClient(contoso, global); !ok || e.ID != "contoso" {
+		t.Errorf("MatchClient(contoso) = %v, %v; want the contoso entry", e, ok)
+	}
+	if e, ok := l.MatchClient(fabrikam, global); ok {
+		t.Errorf("MatchClient(fabrikam) = %v, want no match", e)
+	}
+	if e, ok := l.MatchClient(contoso, &Indicator{Type: TypeDomain, Value: "contoso.com"}); ok {
+		t.Errorf("MatchClient on a global entry = %v, want no match", e)
+	}
+	if got := l.Entries(); !reflect.DeepEqual(got, entries) {
+		t.Errorf("Entries() = %v, want the compiled entries", got)
+	}
+}
+
+func TestAllowEntryReason(t *testing.T) {
+	tests := []struct {
+		entry AllowEntry
+		want  string
+	}{
+		{AllowEntry{Kind: AllowHashSet, Value: "builds"}, "matches global allow-list hash set entry builds"},
+		{AllowEntry{ClientID: "c", Kind: AllowDomain, Value: "contoso.com"}, "matches client allow-list domain entry contoso.com"},
+	}
+	for _, tt := range tests {
+		if got := tt.entry.Reason(); got != tt.want {
+			t.Errorf("Reason() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package indicator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// backfillPage is the number of indicators read and rewritten at a time
+const backfillPage = 5000
+
+// BackfillResult counts the indicators Backfill changed
+type BackfillResult struct {
+	// Normalized counts the indicators whose type or value changed
+	Normalized int `json:"normalized"`
+	// Merged counts the indicators folded into another one
+	Merged int `json:"merged"`
+	// Unrecognized counts the indicators the normalizer rejected, which
+	// keep their stored value
+	Unrecognized int `json:"unrecognized"`
+}
+
+// Backfill normalizes indicators stored before values were normalized on
+// the way in, with the normalizer upserts use, so the rows equal what
+// feeds and imports store from now on. Rows that turn out to be the same
+// indicator are merged into the most recently seen one, along with their
+// distributions, sightings and threat entity links. It then builds the
+// unique index deduplicating global indicators, and can be run again.
+//
+// The allow-list is not applied: allow-listed indicators are normalized
+// like any other and stay as they were reviewed.
+func (s *Store) Backfill(ctx context.Context) (BackfillResult, error) {
+	var result BackfillResult
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	// Upserts wait until the rows are merged and the index is back
+	if _, err := tx.ExecContext(ctx, `
+		LOCK TABLE deployment_mgmt.threat_indicators IN SHARE ROW EXCLUSIVE MODE;
+		DROP INDEX IF EXISTS deployment_mgmt.idx_threat_indicators_global_unique;
+		CREATE TEMP TABLE indicator_backfill (
+			id UUID PRIMARY KEY,
+			client_id UUID,
+			indicator_type TEXT NOT NULL,
+			indicator_value TEXT NOT NULL
+		) ON COMMIT DROP`); err != nil {
+		return result, err
+	}
+
+	normalizer := &Normalizer{}
+	after := "00000000-0000-0000-0000-000000000000"
+	for {
+		page, err := backfillRead(ctx, tx, after)
+		if err != nil {
+			return result, err
+		}
+		if len(page.ids) == 0 {
+			break
+		}
+		after = page.ids[len(page.ids)-1]
+		for i := range page.ids {
+			t, value, err := normalizer.Normalize(page.types[i], page.values[i])
+			if err != nil {
+				result.Unrecognized++
+				page.types[i] = strings.ToLower(page.types[i])
+				continue
+			}
+			if string(t) != page.types[i] || value != page.values[i] {
+				result.Normalized++
+			}
+			page.types[i], page.values[i] = string(t), value
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO indicator_backfill (id, client_id, indicator_type, indicator_value)
+			SELECT * FROM unnest($1::uuid[], $2::uuid[], $3::text[], $4::text[])`,
+			pq.Array(page.ids), pq.Array(page.clientIDs), pq.Array(page.types), pq.Array(page.values)); err != nil {
+			return result, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, backfillMerge); err != nil {
+		return result, fmt.Errorf("failed to merge indicators: %v", err)
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM indicator_merges").Scan(&result.Merged); err != nil {
+		return result, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		CREATE UNIQUE INDEX idx_threat_indicators_global_unique
+			ON deployment_mgmt.threat_indicators(indicator_type, indicator_value)
+			WHERE client_id IS NULL`); err != nil {
+		return result, err
+	}
+	return result, tx.Commit()
+}
+
+// backfillRows is a page of stored indicators, as parallel columns
+type backfillRows struct {
+	ids, types, values []string
+	clientIDs          []sql.NullString
+}
+
+// backfillRead reads the indicators following an ID
+func backfillRead(ctx context.Context, tx *sql.Tx, after string) (*backfillRows, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, client_id, indicator_type, indicator_value
+		FROM deployment_mgmt.threat_indicators
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2`, after, backfillPage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &backfillRows{}
+	for rows.Next() {
+		var id, t, value string
+		var clientID sql.NullString
+		if err := rows.Scan(&id, &clientID, &t, &value); err != nil {
+			return nil, err
+		}
+		page.ids = append(page.ids, id)
+		page.clientIDs = append(page.clientIDs, clientID)
+		page.types = append(page.types, t)
+		page.values = append(page.values, value)
+	}
+	return page, rows.Err()
+}
+
+// backfillMerge folds the rows of indicator_backfill that normalize to the
+// same indicator into one, listing the folded rows in indicator_merges, and
+// rewrites the kept rows to their normalized type and value
+const backfillMerge = `
+	-- Within each group of rows that normalize to the same indicator, keep the
+	-- most recently seen row and fold the others into it
+	CREATE TEMP TABLE indicator_merges ON COMMIT DROP AS
+	SELECT id, first_value(id) OVER w AS keep_id
+	FROM (
+		SELECT b.*, COALESCE(t.last_seen_at, t.updated_at, t.created_at) AS seen
+		FROM indicator_backfill b
+		JOIN deployment_mgmt.threat_indicators t ON t.id = b.id
+	) ranked
+	WINDOW w AS (
+		PARTITION BY COALESCE(client_id, '00000000-0000-0000-0000-000000000000'::uuid), indicator_type, indicator_value
+		ORDER BY seen DESC NULLS LAST, id
+	);
+
+	DELETE FROM indicator_merges WHERE id = keep_id;
+
+	UPDATE deployment_mgmt.threat_indicators t
+	SET confidence = GREATEST(t.confidence, lost.confidence),
+		base_confidence = GREATEST(t.base_confidence, lost.base_confidence),
+		tags = ARRAY(
+			SELECT unnest(t.tags)
+			UNION
+			SELECT unnest(l.tags)
+			FROM indicator_merges m
+			JOIN deployment_mgmt.threat_indicators l ON l.id = m.id
+			WHERE m.keep_id = t.id),
+		expiration_date = GREATEST(t.expiration_date, lost.expiration_date),
+		is_active = COALESCE(t.is_active, false) OR lost.is_active,
+		last_seen_at = GREATEST(t.last_seen_at, lost.last_seen_at),
+		created_at = LEAST(t.created_at, lost.created_at)
+	FROM (
+		SELECT m.keep_id,
+			MAX(l.confidence) AS confidence,
+			MAX(l.base_confidence) AS base_confidence,
+			MAX(l.expiration_date) AS expiration_date,
+			COALESCE(bool_or(l.is_active), false) AS is_active,
+			MAX(l.last_seen_at) AS last_seen_at,
+			MIN(l.created_at) AS created_at
+		FROM indicator_merges m
+		JOIN deployment_mgmt.threat_indicators l ON l.id = m.id
+		GROUP BY m.keep_id
+	) lost
+	WHERE t.id = lost.keep_id;
+
+	-- Move what referenced the merged rows onto the kept row. Where the group
+	-- has several rows for the same client and target or source, the kept
+	-- row's own wins.
+	UPDATE deployment_mgmt.indicator_distribution_queue q
+	SET indicator_id = m.keep_id
+	FROM indicator_merges m
+	WHERE q.indicator_id = m.id;
+
+	INSERT INTO deployment_mgmt.threat_entity_indicators (entity_id, indicator_id, created_at)
+	SELECT e.entity_id, m.keep_id, e.created_at
+	FROM deployment_mgmt.threat_entity_indicators e
+	JOIN indicator_merges m ON m.id = e.indicator_id
+	ON CONFLICT DO NOTHING;
+
+	CREATE TEMP TABLE distribution_merges ON COMMIT DROP AS
+	SELECT d.id, COALESCE(m.keep_id, d.indicator_id) AS indicator_id,
+		row_number() OVER (
+			PARTITION BY COALESCE(m.keep_id, d.indicator_id), d.client_id, d.target
+			ORDER BY m.id IS NULL DESC, d.updated_at DESC
+		) AS n
+	FROM deployment_mgmt.indicator_distributions d
+	LEFT JOIN indicator_merges m ON m.id = d.indicator_id
+	WHERE d.indicator_id IN (SELECT id FROM indicator_merges UNION SELECT keep_id FROM indicator_merges);
+
+	DELETE FROM deployment_mgmt.indicator_distributions d
+	USING distribution_merges w
+	WHERE d.id = w.id AND w.n > 1;
+
+	-- planned_at is cleared so the distributor replans the moved rows
+	UPDATE deployment_mgmt.indicator_distributions d
+	SET indicator_id = w.indicator_id, planned_at = NULL
+	FROM distribution_merges w
+	WHERE d.id = w.id AND d.indicator_id <> w.indicator_id;
+
+	CREATE TEMP TABLE sighting_merges ON COMMIT DROP AS
+	SELECT s.id, COALESCE(m.keep_id, s.indicator_id) AS indicator_id, s.client_id, s.source,
+		row_number() OVER (
+			PARTITION BY COALESCE(m.keep_id, s.indicator_id), s.client_id, s.source
+			ORDER BY m.id IS NULL DESC, s.last_seen DESC
+		) AS n
+	FROM deployment_mgmt.indicator_sightings s
+	LEFT JOIN indicator_merges m ON m.id = s.indicator_id
+	WHERE s.indicator_id IN (SELECT id FROM indicator_merges UNION SELECT keep_id FROM indicator_merges);
+
+	UPDATE deployment_mgmt.indicator_sightings s
+	SET indicator_id = w.indicator_id,
+		sighting_count = g.sighting_count,
+		first_seen = g.first_seen,
+		last_seen = g.last_seen,
+		ticket_id = COALESCE(s.ticket_id, g.ticket_id),
+		entities = ARRAY(
+			SELECT DISTINCT unnest(o.entities)
+			FROM sighting_merges wo
+			JOIN deployment_mgmt.indicator_sightings o ON o.id = wo.id
+			WHERE wo.indicator_id = w.indicator_id AND wo.client_id = w.client_id AND wo.source = w.source),
+		updated_at = NOW()
+	FROM sighting_merges w
+	JOIN (
+		SELECT wg.indicator_id, wg.client_id, wg.source,
+			SUM(o.sighting_count) AS sighting_count,
+			MIN(o.first_seen) AS first_seen,
+			MAX(o.last_seen) AS last_seen,
+			MIN(o.ticket_id) AS ticket_id
+		FROM sighting_merges wg
+		JOIN deployment_mgmt.indicator_sightings o ON o.id = wg.id
+		GROUP BY wg.indicator_id, wg.client_id, wg.source
+	) g ON g.indicator_id = w.indicator_id AND g.client_id = w.client_id AND g.source = w.source
+	WHERE s.id = w.id AND w.n = 1;
+
+	DELETE FROM deployment_mgmt.indicator_sightings s
+	USING sighting_merges w
+	WHERE s.id = w.id AND w.n > 1;
+
+	DELETE FROM deployment_mgmt.threat_indicators t
+	USING indicator_merges m
+	WHERE t.id = m.id;
+
+	-- Rewrite the kept rows. updated_at moves so the distributor replans them.
+	UPDATE deployment_mgmt.threat_indicators t
+	SET indicator_type = b.indicator_type,
+		indicator_value = b.indicator_value,
+		updated_at = NOW()
+	FROM indicator_backfill b
+	WHERE t.id = b.id
+	AND (t.indicator_type <> b.indicator_type OR t.indicator_value <> b.indicator_value);`
@@ -0,0 +1,109 @@
+package indicator
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+)
+
+// insertRaw stores an indicator as written before values were normalized
+func insertRaw(t *testing.T, db *sql.DB, clientID interface{}, indicatorType, value string, confidence int) {
+	t.Helper()
+	if _, err := db.Exec(`
+		INSERT INTO deployment_mgmt.threat_indicators
+			(client_id, indicator_type, indicator_value, threat_type, confidence, source, tags)
+		VALUES ($1, $2, $3, 'C2', $4, 'legacy', ARRAY[$3])`,
+		clientID, indicatorType, value, confidence); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreBackfill(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	clientID := dbtest.CreateClient(t, db, "Contoso")
+
+	// Global duplicates cannot be stored while the index exists
+	if _, err := db.Exec("DROP INDEX deployment_mgmt.idx_threat_indicators_global_unique"); err != nil {
+		t.Fatal(err)
+	}
+	insertRaw(t, db, clientID, "url", "HXXPS://user@Evil[.]Example.org:443#top", 60)
+	insertRaw(t, db, clientID, "url", "https://evil.example.org/", 80)
+	insertRaw(t, db, nil, "domain", "BÜCHER.org.", 50)
+	insertRaw(t, db, nil, "domain", "xn--bcher-kva.org", 70)
+	insertRaw(t, db, nil, "ip", "45.33.32.156", 50)
+	insertRaw(t, db, nil, "hostname", "Not An Indicator", 50)
+
+	s := NewStore(db)
+	result, err := s.Backfill(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BackfillResult{Normalized: 3, Merged: 2, Unrecognized: 1}
+	if result != want {
+		t.Errorf("Backfill() = %+v, want %+v", result, want)
+	}
+
+	rows, err := db.Query(`
+		SELECT indicator_type, indicator_value, confidence, array_length(tags, 1)
+		FROM deployment_mgmt.threat_indicators ORDER BY indicator_value COLLATE "C"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	type row struct {
+		Type, Value      string
+		Confidence, Tags int
+	}
+	var got []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.Type, &r.Value, &r.Confidence, &r.Tags); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	wantRows := []row{
+		{"ipv4", "45.33.32.156", 50, 1},
+		{"hostname", "Not An Indicator", 50, 1},
+		{"url", "https://evil.example.org/", 80, 2},
+		{"domain", "xn--bcher-kva.org", 70, 2},
+	}
+	if len(got) != len(wantRows) {
+		t.Fatalf("rows = %+v, want %+v", got, wantRows)
+	}
+	for i := range got {
+		if got[i] != wantRows[i] {
+			t.Errorf("row %d = %+v, want %+v", i, got[i], wantRows[i])
+		}
+	}
+
+	// What feeds store from now on lands on the backfilled rows
+	n := NewNormalizer()
+	var stored []Indicator
+	for _, value := range []string{"https://Evil.example.org:443", "bücher.org"} {
+		typ, canonical, err := n.Normalize("", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ind := Indicator{Type: typ, Value: canonical, ThreatType: "C2", Confidence: 50, Source: "feed"}
+		if typ == TypeURL {
+			ind.ClientID = clientID
+		}
+		stored = append(stored, ind)
+	}
+	upserted, err := s.Upsert(ctx, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upserted.Inserted != 0 || upserted.Updated != 2 {
+		t.Errorf("Upsert() after the backfill = %+v, want both indicators updated", upserted)
+	}
+
+	// A second run finds nothing left to do
+	if result, err := s.Backfill(ctx); err != nil || result != (BackfillResult{Unrecognized: 1}) {
+		t.Errorf("second Backfill() = %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,76 @@
+package indicator
+
+import (
+	"strings"
+	"time"
+)
+
+// Type identifies the kind of observable an indicator describes
+type Type string
+
+const (
+	TypeIPv4        Type = "ipv4"
+	TypeIPv6        Type = "ipv6"
+	TypeCIDR        Type = "cidr"
+	TypeDomain      Type = "domain"
+	TypeURL         Type = "url"
+	TypeMD5         Type = "md5"
+	TypeSHA1        Type = "sha1"
+	TypeSHA256      Type = "sha256"
+	TypeEmail       Type = "email"
+	TypeCertificate Type = "certificate"
+)
+
+// Indicator represents a row of deployment_mgmt.threat_indicators
+type Indicator struct {
+	ID          string     `json:"id,omitempty"`
+	ClientID    string     `json:"clientId,omitempty"`
+	Type        Type       `json:"type"`
+	Value       string     `json:"value"`
+	ThreatType  string     `json:"threatType"`
+	Confidence  int        `json:"confidence"`
+	Source      string     `json:"source"`
+	Description string     `json:"description,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	FeedID      string     `json:"feedId,omitempty"`
+	ExternalID  string     `json:"externalId,omitempty"`
+	ExpiresAt   *time.Time `json:"expirationDate,omitempty"`
+	IsActive    bool       `json:"isActive"`
+	// ReviewStatus is empty or one of the Review constants
+	ReviewStatus string    `json:"reviewStatus,omitempty"`
+	ReviewReason string    `json:"reviewReason,omitempty"`
+	CreatedAt    time.Time `json:"createdAt,omitempty"`
+	UpdatedAt    time.Time `json:"updatedAt,omitempty"`
+}
+
+// Key returns the value used to deduplicate indicators within a client scope
+func (i *Indicator) Key() string {
+	return i.ClientID + "|" + string(i.Type) + "|" + i.Value
+}
+
+// ParseType maps a type name as written by feeds and analysts to a Type
+func ParseType(s string) (Type, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "ipv4", "ipv4-addr", "ip", "ipaddress", "ip-dst", "ip-src":
+		return TypeIPv4, true
+	case "ipv6", "ipv6-addr":
+		return TypeIPv6, true
+	case "cidr", "network", "ip-range":
+		return TypeCIDR, true
+	case "domain", "domain-name", "domainname", "hostname", "fqdn":
+		return TypeDomain, true
+	case "url", "uri":
+		return TypeURL, true
+	case "md5", "filehash-md5", "filemd5":
+		return TypeMD5, true
+	case "sha1", "sha-1", "filehash-sha1", "filesha1":
+		return TypeSHA1, true
+	case "sha256", "sha-256", "filehash-sha256", "filesha256":
+		return TypeSHA256, true
+	case "email", "email-addr", "emailaddress", "email-src":
+		return TypeEmail, true
+	case "certificate", "x509-certificate", "certificatethumbprint":
+		return TypeCertificate, true
+	}
+	return "", false
+}
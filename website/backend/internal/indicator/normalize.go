@@ -0,0 +1,382 @@
+package indicator
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Error describes why a submitted value was rejected
+type Error struct {
+	Value  string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Value, e.Reason)
+}
+
+func reject(value, format string, args ...interface{}) error {
+	return &Error{Value: value, Reason: fmt.Sprintf(format, args...)}
+}
+
+// DefaultAllowedDomains are well-known domains that must never be pushed as
+// malicious, since blocking them would break every tenant
+var DefaultAllowedDomains = []string{
+	"microsoft.com", "microsoftonline.com", "windows.com", "windows.net",
+	"windowsupdate.com", "office.com", "office365.com", "office.net",
+	"outlook.com", "live.com", "azure.com", "azureedge.net", "sharepoint.com",
+	"google.com", "googleapis.com", "gstatic.com", "apple.com", "icloud.com",
+	"amazon.com", "amazonaws.com", "cloudflare.com", "akamai.net",
+	"akamaiedge.net", "github.com", "digicert.com", "verisign.com",
+}
+
+// reservedTLDs cannot resolve on the public internet (RFC 2606, RFC 6761)
+var reservedTLDs = map[string]bool{
+	"test": true, "example": true, "invalid": true, "localhost": true,
+	"local": true, "internal": true, "lan": true, "home": true, "corp": true,
+}
+
+var reservedPrefixes = mustParsePrefixes(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+	"169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24", "192.0.2.0/24",
+	"192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24",
+	"224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "64:ff9b::/96", "100::/64", "2001:db8::/32",
+	"fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+var (
+	urlSchemeRegex   = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+	hexRegex         = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+	thumbprintRegex  = regexp.MustCompile(`^([0-9a-fA-F]{2}[: ]){19,31}[0-9a-fA-F]{2}$`)
+	domainLabelRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	emailLocalRegex  = regexp.MustCompile(`^[a-zA-Z0-9.!#$%&'*+/=?^_{|}~-]{1,64}$`)
+
+	refangReplacer = strings.NewReplacer(
+		"[.]", ".", "(.)", ".", "{.}", ".", "[dot]", ".", "(dot)", ".", "[DOT]", ".",
+		"[:]", ":", "[://]", "://", "[/]", "/",
+		"[@]", "@", "[at]", "@", "(at)", "@", "[AT]", "@",
+	)
+	defangedSchemeRegex = regexp.MustCompile(`(?i)^(hxxp|hxtp|h\*\*p|fxp)(s?)(\[?:\]?//\]?)`)
+)
+
+func mustParsePrefixes(prefixes ...string) []netip.Prefix {
+	parsed := make([]netip.Prefix, len(prefixes))
+	for i, p := range prefixes {
+		parsed[i] = netip.MustParsePrefix(p)
+	}
+	return parsed
+}
+
+// Refang reverses the common defanging conventions used when sharing
+// indicators, such as hxxp:// and evil[.]com
+func Refang(value string) string {
+	value = strings.TrimSpace(value)
+	if m := defangedSchemeRegex.FindStringSubmatch(value); m != nil {
+		scheme := "http"
+		if strings.EqualFold(m[1], "fxp") {
+			scheme = "ftp"
+		}
+		value = scheme + strings.ToLower(m[2]) + "://" + value[len(m[0]):]
+	}
+	return refangReplacer.Replace(value)
+}
+
+// Detect guesses the type of a refanged value
+func Detect(value string) (Type, bool) {
+	switch {
+	case value == "":
+		return "", false
+	case urlSchemeRegex.MatchString(value):
+		return TypeURL, true
+	case strings.Contains(value, "@"):
+		return TypeEmail, true
+	case thumbprintRegex.MatchString(value):
+		return TypeCertificate, true
+	}
+
+	if strings.Contains(value, "/") {
+		if _, err := netip.ParsePrefix(value); err == nil {
+			return TypeCIDR, true
+		}
+		// A host followed by a path is a URL written without its scheme
+		host := value[:strings.Index(value, "/")]
+		if isDomain(strings.ToLower(host)) {
+			return TypeURL, true
+		}
+		return "", false
+	}
+	if addr, err := netip.ParseAddr(value); err == nil {
+		if addr.Unmap().Is4() {
+			return TypeIPv4, true
+		}
+		return TypeIPv6, true
+	}
+	if hexRegex.MatchString(value) {
+		switch len(value) {
+		case 32:
+			return TypeMD5, true
+		case 40:
+			return TypeSHA1, true
+		case 64:
+			return TypeSHA256, true
+		}
+		return "", false
+	}
+	if ascii, err := toASCII(strings.ToLower(strings.TrimSuffix(value, "."))); err == nil && isDomain(ascii) {
+		return TypeDomain, true
+	}
+	return "", false
+}
+
+// Normalizer validates and canonicalizes submitted indicators
+type Normalizer struct {
+	// AllowedDomains lists domains that are rejected, along with their
+	// subdomains and the URLs and email addresses on them
+	AllowedDomains []string
+}
+
+// NewNormalizer creates a normalizer using DefaultAllowedDomains
+func NewNormalizer() *Normalizer {
+	return &Normalizer{AllowedDomains: DefaultAllowedDomains}
+}
+
+// Normalize refangs and canonicalizes a value. The declared type may be
+// empty to auto-detect it, or a generic name such as "ip" or "hash"; it is
+// rejected when it contradicts the detected type.
+func (n *Normalizer) Normalize(declaredType, value string) (Type, string, error) {
+	original := value
+	value = Refang(value)
+	if value == "" {
+		return "", "", reject(original, "value is empty")
+	}
+
+	detected, ok := Detect(value)
+	if !ok {
+		return "", "", reject(original, "unrecognized indicator value")
+	}
+	t, err := reconcileType(declaredType, detected)
+	if err != nil {
+		return "", "", reject(original, "%v", err)
+	}
+
+	var canonical string
+	switch t {
+	case TypeIPv4, TypeIPv6:
+		canonical, t, err = normalizeIP(value)
+	case TypeCIDR:
+		canonical, t, err = normalizeCIDR(value)
+	case TypeDomain:
+		canonical, err = n.normalizeDomain(value)
+	case TypeURL:
+		canonical, err = n.normalizeURL(value)
+	case TypeEmail:
+		canonical, err = n.normalizeEmail(value)
+	case TypeMD5, TypeSHA1, TypeSHA256:
+		canonical = strings.ToLower(value)
+	case TypeCertificate:
+		canonical, err = normalizeThumbprint(value)
+	}
+	if err != nil {
+		return "", "", reject(original, "%v", err)
+	}
+	return t, canonical, nil
+}
+
+// reconcileType checks a declared type against the detected one
+func reconcileType(declared string, detected Type) (Type, error) {
+	declared = strings.ToLower(strings.TrimSpace(declared))
+	switch declared {
+	case "":
+		return detected, nil
+	case "ip", "ipaddress":
+		if detected == TypeIPv4 || detected == TypeIPv6 || detected == TypeCIDR {
+			return detected, nil
+		}
+	case "hash", "filehash":
+		if detected == TypeMD5 || detected == TypeSHA1 || detected == TypeSHA256 {
+			return detected, nil
+		}
+	default:
+		t, ok := ParseType(declared)
+		if !ok {
+			return "", fmt.Errorf("unknown indicator type %q", declared)
+		}
+		switch {
+		case t == detected:
+			return t, nil
+		case t == TypeCertificate && (detected == TypeSHA1 || detected == TypeSHA256):
+			// Thumbprints without separators look like file hashes
+			return t, nil
+		case (t == TypeIPv4 || t == TypeIPv6) && detected == TypeCIDR:
+			return detected, nil
+		}
+	}
+	return "", fmt.Errorf("value looks like %s, not %s", detected, declared)
+}
+
+func normalizeIP(value string) (string, Type, error) {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid ip address")
+	}
+	addr = addr.Unmap().WithZone("")
+	if isReserved(netip.PrefixFrom(addr, addr.BitLen())) {
+		return "", "", fmt.Errorf("private or reserved address")
+	}
+	if addr.Is4() {
+		return addr.String(), TypeIPv4, nil
+	}
+	return addr.String(), TypeIPv6, nil
+}
+
+func normalizeCIDR(value string) (string, Type, error) {
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid cidr range")
+	}
+	prefix = prefix.Masked()
+	if prefix.IsSingleIP() {
+		return normalizeIP(prefix.Addr().String())
+	}
+	if (prefix.Addr().Is4() && prefix.Bits() < 8) || (prefix.Addr().Is6() && prefix.Bits() < 16) {
+		return "", "", fmt.Errorf("cidr range is too broad")
+	}
+	if isReserved(prefix) {
+		return "", "", fmt.Errorf("cidr range overlaps private or reserved addresses")
+	}
+	return prefix.String(), TypeCIDR, nil
+}
+
+func isReserved(prefix netip.Prefix) bool {
+	for _, reserved := range reservedPrefixes {
+		if reserved.Overlaps(prefix) {
+			return true
+		}
+	}
+	return prefix.Addr() == netip.MustParseAddr("255.255.255.255")
+}
+
+func (n *Normalizer) normalizeDomain(value string) (string, error) {
+	domain, err := canonicalHost(value)
+	if err != nil {
+		return "", err
+	}
+	if n.isAllowed(domain) {
+		return "", fmt.Errorf("domain is allow-listed")
+	}
+	return domain, nil
+}
+
+func (n *Normalizer) isAllowed(domain string) bool {
+	for _, allowed := range n.AllowedDomains {
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalHost lowercases a hostname, converts it to punycode and rejects
+// names that cannot exist on the public internet
+func canonicalHost(host string) (string, error) {
+	host, err := toASCII(strings.ToLower(strings.TrimSuffix(host, ".")))
+	if err != nil {
+		return "", err
+	}
+	if !isDomain(host) {
+		return "", fmt.Errorf("invalid domain name")
+	}
+	tld := host[strings.LastIndex(host, ".")+1:]
+	if reservedTLDs[tld] {
+		return "", fmt.Errorf("reserved top-level domain")
+	}
+	return host, nil
+}
+
+func isDomain(s string) bool {
+	if len(s) > 253 || !strings.Contains(s, ".") {
+		return false
+	}
+	labels := strings.Split(s, ".")
+	for _, label := range labels {
+		if !domainLabelRegex.MatchString(label) {
+			return false
+		}
+	}
+	tld := labels[len(labels)-1]
+	return strings.HasPrefix(tld, "xn--") || strings.Trim(tld, "abcdefghijklmnopqrstuvwxyz") == ""
+}
+
+func (n *Normalizer) normalizeURL(value string) (string, error) {
+	if !urlSchemeRegex.MatchString(value) {
+		value = "http://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid url")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ftp" {
+		return "", fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+
+	hostname, port := u.Hostname(), u.Port()
+	if addr, err := netip.ParseAddr(hostname); err == nil {
+		if isReserved(netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())) {
+			return "", fmt.Errorf("url points to a private or reserved address")
+		}
+		hostname = addr.Unmap().String()
+		if addr.Unmap().Is6() {
+			hostname = "[" + hostname + "]"
+		}
+	} else if hostname, err = canonicalHost(hostname); err != nil {
+		return "", err
+	} else if n.isAllowed(hostname) {
+		return "", fmt.Errorf("url host is allow-listed")
+	}
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") || (u.Scheme == "ftp" && port == "21") {
+		port = ""
+	}
+	u.Host = hostname
+	if port != "" {
+		u.Host += ":" + port
+	}
+
+	u.User = nil
+	u.Fragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
+
+func (n *Normalizer) normalizeEmail(value string) (string, error) {
+	at := strings.LastIndex(value, "@")
+	if at <= 0 || at == len(value)-1 {
+		return "", fmt.Errorf("invalid email address")
+	}
+	local, domain := value[:at], value[at+1:]
+	if !emailLocalRegex.MatchString(local) {
+		return "", fmt.Errorf("invalid email address")
+	}
+	domain, err := canonicalHost(domain)
+	if err != nil {
+		return "", err
+	}
+	if n.isAllowed(domain) {
+		return "", fmt.Errorf("email domain is allow-listed")
+	}
+	return strings.ToLower(local) + "@" + domain, nil
+}
+
+func normalizeThumbprint(value string) (string, error) {
+	thumbprint := strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(value))
+	if !hexRegex.MatchString(thumbprint) || (len(thumbprint) != 40 && len(thumbprint) != 64) {
+		return "", fmt.Errorf("certificate thumbprint must be a SHA-1 or SHA-256 hex digest")
+	}
+	return thumbprint, nil
+}
@@ -0,0 +1,173 @@
+package indicator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRefang(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"hxxp://evil[.]com/a", "http://evil.com/a"},
+		{"hXXps[://]evil(.)com", "https://evil.com"},
+		{"fxp://files[.]evil[.]com", "ftp://files.evil.com"},
+		{"198[.]51[.]100[.]7", "198.51.100.7"},
+		{"user[at]evil[dot]com", "user@evil.com"},
+		{"  evil{.}com  ", "evil.com"},
+		{"https://already.clean/", "https://already.clean/"},
+	}
+	for _, tt := range tests {
+		if got := Refang(tt.in); got != tt.want {
+			t.Errorf("Refang(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		declared string
+		value    string
+		wantType Type
+		want     string
+	}{
+		{"ipv4", "", "8.8.4.4", TypeIPv4, "8.8.4.4"},
+		{"defanged ipv4", "ip", "8[.]8[.]4[.]4", TypeIPv4, "8.8.4.4"},
+		{"mapped ipv6 is ipv4", "", "::ffff:8.8.4.4", TypeIPv4, "8.8.4.4"},
+		{"ipv6 compressed", "ipv6", "2606:4700:0000:0000:0000:0000:0000:1111", TypeIPv6, "2606:4700::1111"},
+		{"cidr masked", "", "45.33.32.1/24", TypeCIDR, "45.33.32.0/24"},
+		{"single-address cidr is an ip", "", "45.33.32.156/32", TypeIPv4, "45.33.32.156"},
+		{"ip declared for a range", "ipv4", "45.33.32.0/24", TypeCIDR, "45.33.32.0/24"},
+		{"domain lowercased", "", "Evil.Example.ORG.", TypeDomain, "evil.example.org"},
+		{"defanged domain", "domain", "evil[.]org", TypeDomain, "evil.org"},
+		{"unicode domain to punycode", "", "bücher.de", TypeDomain, "xn--bcher-kva.de"},
+		{"unicode subdomain", "", "mañana.evil.org", TypeDomain, "xn--maana-pta.evil.org"},
+		{"defanged url", "", "hxxps://Evil[.]org:443/Path?q=1#frag", TypeURL, "https://evil.org/Path?q=1"},
+		{"url without scheme", "url", "evil.org/payload.exe", TypeURL, "http://evil.org/payload.exe"},
+		{"url credentials dropped", "", "http://user:pw@evil.org", TypeURL, "http://evil.org/"},
+		{"url with unicode host", "", "http://bücher.de/x", TypeURL, "http://xn--bcher-kva.de/x"},
+		{"email", "", "Phisher@Evil.ORG", TypeEmail, "phisher@evil.org"},
+		{"md5 lowercased", "hash", "D41D8CD98F00B204E9800998ECF8427E", TypeMD5, "d41d8cd98f00b204e9800998ecf8427e"},
+		{"sha256", "sha256", "E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855", TypeSHA256,
+			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"thumbprint with separators", "", "A9:99:3E:36:47:06:81:6A:BA:3E:25:71:78:50:C2:6C:9C:D0:D8:9D",
+			TypeCertificate, "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{"thumbprint declared for a bare digest", "certificate", "A9993E364706816ABA3E25717850C26C9CD0D89D",
+			TypeCertificate, "a9993e364706816aba3e25717850c26c9cd0d89d"},
+	}
+	n := NewNormalizer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotType, got, err := n.Normalize(tt.declared, tt.value)
+			if err != nil {
+				t.Fatalf("Normalize(%q, %q) failed: %v", tt.declared, tt.value, err)
+			}
+			if gotType != tt.wantType || got != tt.want {
+				t.Errorf("Normalize(%q, %q) = %s %q, want %s %q", tt.declared, tt.value, gotType, got, tt.wantType, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		declared string
+		value    string
+	}{
+		{"empty", "", "   "},
+		{"garbage", "", "not an indicator"},
+		{"private ipv4", "", "10.1.2.3"},
+		{"loopback", "", "127.0.0.1"},
+		{"cgnat", "", "100.64.0.1"},
+		{"documentation range", "", "203.0.113.9"},
+		{"broadcast", "", "255.255.255.255"},
+		{"multicast", "", "239.1.1.1"},
+		{"unique local ipv6", "", "fd00::1"},
+		{"link-local ipv6", "", "fe80::1"},
+		{"documentation ipv6", "", "2001:db8::1"},
+		{"range overlapping private", "", "172.0.0.0/8"},
+		{"range too broad", "", "8.0.0.0/7"},
+		{"reserved tld", "", "printer.corp"},
+		{"example tld", "", "evil.example"},
+		{"allow-listed domain", "", "login.microsoftonline.com"},
+		{"url on an allow-listed domain", "", "https://login.microsoftonline.com/x"},
+		{"url without scheme on an allow-listed domain", "", "www.microsoft.com/download"},
+		{"defanged url on an allow-listed domain", "", "hxxps://portal[.]azure[.]com/"},
+		{"email on an allow-listed domain", "", "foo@microsoft.com"},
+		{"email on an allow-listed subdomain", "", "noreply@mail.github.com"},
+		{"url to a private address", "", "http://192.168.1.1/admin"},
+		{"url with reserved tld", "", "http://intranet.local/"},
+		{"unsupported scheme", "", "javascript://evil.org/"},
+		{"odd hash length", "", "d41d8cd98f00b204e9800998ecf8427"},
+		{"declared type contradicts value", "domain", "8.8.4.4"},
+		{"hash declared for an ip", "hash", "8.8.4.4"},
+		{"unknown declared type", "mutex", "evil.org"},
+		{"email with reserved tld", "", "admin@mail.test"},
+	}
+	n := NewNormalizer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotType, got, err := n.Normalize(tt.declared, tt.value)
+			var invalid *Error
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Normalize(%q, %q) = %s %q, %v; want an *Error", tt.declared, tt.value, gotType, got, err)
+			}
+			if invalid.Value != tt.value || invalid.Reason == "" {
+				t.Errorf("error = %+v, want the submitted value and a reason", invalid)
+			}
+		})
+	}
+}
+
+func TestNormalizeAllowedDomains(t *testing.T) {
+	n := &Normalizer{AllowedDomains: []string{"corp-example.com"}}
+	if _, _, err := n.Normalize("", "vpn.corp-example.com"); err == nil {
+		t.Error("a subdomain of an allowed domain was accepted")
+	}
+	if _, got, err := n.Normalize("", "corp-example.com.evil.org"); err != nil || got != "corp-example.com.evil.org" {
+		t.Errorf("a domain merely containing an allowed one = %q, %v; want it accepted", got, err)
+	}
+	if _, got, err := n.Normalize("", "microsoft.com"); err != nil || got != "microsoft.com" {
+		t.Errorf("microsoft.com with a custom allow-list = %q, %v; want it accepted", got, err)
+	}
+	for _, value := range []string{"https://vpn.corp-example.com/login", "it@corp-example.com"} {
+		if _, _, err := n.Normalize("", value); err == nil {
+			t.Errorf("%s on an allowed domain was accepted", value)
+		}
+	}
+	if _, got, err := n.Normalize("", "https://corp-example.com.evil.org/login"); err != nil {
+		t.Errorf("a URL on a domain merely containing an allowed one = %q, %v; want it accepted", got, err)
+	}
+	// Without an allow-list nothing is held back
+	if _, got, err := (&Normalizer{}).Normalize("", "foo@microsoft.com"); err != nil || got != "foo@microsoft.com" {
+		t.Errorf("an email address with no allow-list = %q, %v; want it accepted", got, err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Type
+		ok    bool
+	}{
+		{"8.8.8.8", TypeIPv4, true},
+		{"2606:4700::1111", TypeIPv6, true},
+		{"8.8.8.0/24", TypeCIDR, true},
+		{"evil.org", TypeDomain, true},
+		{"evil.org/x", TypeURL, true},
+		{"https://evil.org", TypeURL, true},
+		{"a@evil.org", TypeEmail, true},
+		{"d41d8cd98f00b204e9800998ecf8427e", TypeMD5, true},
+		{"da39a3ee5e6b4b0d3255bfef95601890afd80709", TypeSHA1, true},
+		{"abc", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := Detect(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Detect(%q) = %s, %v; want %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package indicator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// allowListTTL is how long a loaded allow-list is used before it is read
+// again, so changes made by other processes are picked up
+const allowListTTL = time.Minute
+
+// ErrNotReviewable is returned when an indicator is not in a state the
+// review action applies to
+var ErrNotReviewable = errors.New("indicator is not awaiting review")
+
+// AllowList returns the global and client allow-list entries
+func (s *Store) AllowList(ctx context.Context) (*AllowList, error) {
+	s.allowMu.Lock()
+	defer s.allowMu.Unlock()
+	if s.allow != nil && time.Since(s.allowLoaded) < allowListTTL {
+		return s.allow, nil
+	}
+	entries, err := s.AllowEntries(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	s.allow, s.allowLoaded = NewAllowList(entries), time.Now()
+	return s.allow, nil
+}
+
+func (s *Store) invalidateAllowList() {
+	s.allowMu.Lock()
+	s.allow = nil
+	s.allowMu.Unlock()
+}
+
+// AllowEntries returns the global entries and those of a client, or every
+// entry when clientID is empty
+func (s *Store) AllowEntries(ctx context.Context, clientID string) ([]*AllowEntry, error) {
+	query := `
+		SELECT a.id, COALESCE(a.client_id::text, ''), a.kind, a.value, COALESCE(a.description, ''),
+			COALESCE(a.created_by, ''), a.created_at,
+			ARRAY(SELECT h.hash FROM deployment_mgmt.indicator_allowlist_hashes h
+				WHERE h.entry_id = a.id ORDER BY h.hash)
+		FROM deployment_mgmt.indicator_allowlist a`
+	var args []interface{}
+	if clientID != "" {
+		query += ` WHERE a.client_id IS NULL OR a.client_id = $1`
+		args = append(args, clientID)
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY a.client_id NULLS FIRST, a.kind, a.value`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AllowEntry{}
+	for rows.Next() {
+		var e AllowEntry
+		if err := rows.Scan(&e.ID, &e.ClientID, &e.Kind, &e.Value, &e.Description,
+			&e.CreatedBy, &e.CreatedAt, pq.Array(&e.Hashes)); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
+
+// AddAllowEntry normalizes and stores an entry. Adding a value already on
+// the list for the same scope updates its description and hashes.
+func (s *Store) AddAllowEntry(ctx context.Context, e *AllowEntry) error {
+	if err := e.Normalize(); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO deployment_mgmt.indicator_allowlist (client_id, kind, value, description, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (COALESCE(client_id, '00000000-0000-0000-0000-000000000000'::uuid), kind, value)
+		DO UPDATE SET description = EXCLUDED.description
+		RETURNING id, created_at`,
+		nullString(e.ClientID), e.Kind, e.Value, nullString(e.Description), nullString(e.CreatedBy)).Scan(
+		&e.ID, &e.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if e.Kind == AllowHashSet {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM deployment_mgmt.indicator_allowlist_hashes WHERE entry_id = $1`, e.ID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO deployment_mgmt.indicator_allowlist_hashes (entry_id, hash)
+			SELECT $1, unnest($2::text[])
+			ON CONFLICT DO NOTHING`, e.ID, pq.Array(e.Hashes)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.invalidateAllowList()
+	return s.touchCovered(ctx, e)
+}
+
+// DeleteAllowEntry removes an entry. Indicators it quarantined stay
+// quarantined until reviewed.
+func (s *Store) DeleteAllowEntry(ctx context.Context, id string) error {
+	var e AllowEntry
+	err := s.db.QueryRowContext(ctx, `
+		DELETE FROM deployment_mgmt.indicator_allowlist a WHERE a.id::text = $1
+		RETURNING a.id, COALESCE(a.client_id::text, ''), a.kind, a.value,
+			ARRAY(SELECT h.hash FROM deployment_mgmt.indicator_allowlist_hashes h WHERE h.entry_id = a.id)`,
+		id).Scan(&e.ID, &e.ClientID, &e.Kind, &e.Value, pq.Array(&e.Hashes))
+	if err != nil {
+		return err
+	}
+	s.invalidateAllowList()
+	return s.touchCovered(ctx, &e)
+}
+
+// touchCovered marks the global indicators a client entry covers as
+// changed. TAXII serves them to the client as revoked while the entry
+// exists, and consumers polling with added_after only see the new version
+// if updated_at moves.
+func (s *Store) touchCovered(ctx context.Context, e *AllowEntry) error {
+	if e.ClientID == "" {
+		return nil
+	}
+	list := NewAllowList([]*AllowEntry{e})
+	var ids []string
+	err := s.ForEach(ctx, Filter{}, func(ind *Indicator) error {
+		if ind.ClientID != "" {
+			return nil
+		}
+		if _, ok := list.MatchClient(e.ClientID, ind); ok {
+			ids = append(ids, ind.ID)
+		}
+		return nil
+	})
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.threat_indicators SET updated_at = NOW()
+		WHERE id::text = ANY($1) AND client_id IS NULL`, pq.Array(ids))
+	return err
+}
+
+// Rescreen quarantines the active, unreviewed indicators covered by the
+// allow-list, such as after an entry was added, and queues their removal
+// from the platforms they were distributed to. It returns the number of
+// indicators quarantined.
+func (s *Store) Rescreen(ctx context.Context) (int, error) {
+	allow, err := s.AllowList(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(allow.Entries()) == 0 {
+		return 0, nil
+	}
+
+	reasons := make(map[string][]string)
+	err = s.ForEach(ctx, Filter{}, func(ind *Indicator) error {
+		if ind.ReviewStatus != "" {
+			return nil
+		}
+		if e, ok := allow.Match(ind); ok {
+			reasons[e.Reason()] = append(reasons[e.Reason()], ind.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	quarantined := 0
+	for reason, ids := range reasons {
+		res, err := s.db.ExecContext(ctx, `
+			WITH quarantined AS (
+				UPDATE deployment_mgmt.threat_indicators
+				SET is_active = false, review_status = 'quarantined', review_reason = $2,
+					reviewed_by = NULL, reviewed_at = NULL, updated_at = NOW()
+				WHERE id::text = ANY($1) AND review_status IS NULL
+				RETURNING id
+			)
+			INSERT INTO deployment_mgmt.indicator_distribution_queue (indicator_id, action, reason)
+			SELECT id, 'remove', 'quarantined' FROM quarantined`, pq.Array(ids), reason)
+		if err != nil {
+			return quarantined, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return quarantined, err
+		}
+		quarantined += int(n)
+	}
+	return quarantined, nil
+}
+
+// Release approves a quarantined or retracted indicator. It becomes active
+// again unless it has expired, the allow-list no longer holds it back, and
+// removals the review queued that have not been processed are cancelled.
+func (s *Store) Release(ctx context.Context, id, reviewer string) (*Indicator, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE deployment_mgmt.threat_indicators
+		SET review_status = 'approved', review_reason = NULL,
+			reviewed_by = $2, reviewed_at = NOW(),
+			is_active = COALESCE(expiration_date > NOW(), true),
+			updated_at = NOW()
+		WHERE id::text = $1 AND review_status IN ('quarantined', 'false_positive')`, id, nullString(reviewer))
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 1 {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE deployment_mgmt.indicator_distribution_queue
+			SET status = 'cancelled', processed_at = NOW()
+			WHERE indicator_id::text = $1 AND action = 'remove' AND status = 'pending'
+			AND reason IN ('quarantined', 'false_positive')`, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.reviewed(ctx, res, id)
+}
+
+// MarkFalsePositive retracts an indicator: it is deactivated, its removal
+// from every platform it was distributed to is queued, and feeds reporting
+// it again do not reactivate it.
+func (s *Store) MarkFalsePositive(ctx context.Context, id, reason, reviewer string) (*Indicator, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE deployment_mgmt.threat_indicators
+		SET is_active = false, review_status = 'false_positive', review_reason = $2,
+			reviewed_by = $3, reviewed_at = NOW(), updated_at = NOW()
+		WHERE id::text = $1 AND review_status IS DISTINCT FROM 'false_positive'`,
+		id, nullString(reason), nullString(reviewer))
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 1 {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO deployment_mgmt.indicator_distribution_queue (indicator_id, action, reason)
+			VALUES ($1, 'remove', 'false_positive')`, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.reviewed(ctx, res, id)
+}
+
+// reviewed returns the indicator a review action changed, telling unknown
+// indicators apart from ones in the wrong state
+func (s *Store) reviewed(ctx context.Context, res sql.Result, id string) (*Indicator, error) {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	ind, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return ind, ErrNotReviewable
+	}
+	return ind, nil
+}
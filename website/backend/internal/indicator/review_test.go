@@ -0,0 +1,184 @@
+package indicator
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+)
+
+// idOf returns the ID of a global indicator
+func idOf(t *testing.T, db *sql.DB, value string) string {
+	t.Helper()
+	var id string
+	if err := db.QueryRow(`
+		SELECT id FROM deployment_mgmt.threat_indicators
+		WHERE client_id IS NULL AND indicator_value = $1`, value).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// removals returns the status of each queued removal of an indicator by
+// reason
+func removals(t *testing.T, db *sql.DB, id string) map[string]string {
+	t.Helper()
+	rows, err := db.Query(`
+		SELECT reason, status FROM deployment_mgmt.indicator_distribution_queue
+		WHERE indicator_id = $1 AND action = 'remove'`, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := make(map[string]string)
+	for rows.Next() {
+		var reason, status string
+		if err := rows.Scan(&reason, &status); err != nil {
+			t.Fatal(err)
+		}
+		got[reason] = status
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestStoreRescreen(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	s := NewStore(db)
+	if _, err := s.Upsert(ctx, []Indicator{
+		{Type: TypeDomain, Value: "cdn.contoso.com", ThreatType: "C2", Confidence: 80, Source: "feed"},
+		{Type: TypeDomain, Value: "approved.contoso.com", ThreatType: "C2", Confidence: 80, Source: "feed"},
+		{Type: TypeDomain, Value: "evil.org", ThreatType: "C2", Confidence: 80, Source: "feed"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cdn, approved, evil := idOf(t, db, "cdn.contoso.com"), idOf(t, db, "approved.contoso.com"), idOf(t, db, "evil.org")
+	if _, err := db.Exec(`
+		UPDATE deployment_mgmt.threat_indicators SET review_status = 'approved' WHERE id = $1`, approved); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := s.Rescreen(ctx); err != nil || n != 0 {
+		t.Fatalf("Rescreen() without entries = %d, %v; want 0", n, err)
+	}
+	if err := s.AddAllowEntry(ctx, &AllowEntry{Kind: AllowDomain, Value: "contoso.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Rescreen(ctx); err != nil || n != 1 {
+		t.Fatalf("Rescreen() = %d, %v; want 1", n, err)
+	}
+
+	ind, err := s.Get(ctx, cdn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ind.IsActive || ind.ReviewStatus != ReviewQuarantined ||
+		ind.ReviewReason != "matches global allow-list domain entry contoso.com" {
+		t.Errorf("quarantined indicator = active %v, review %s (%s)", ind.IsActive, ind.ReviewStatus, ind.ReviewReason)
+	}
+	if got := removals(t, db, cdn); len(got) != 1 || got[ReviewQuarantined] != "pending" {
+		t.Errorf("removals of the quarantined indicator = %v, want a pending quarantined one", got)
+	}
+	// Released indicators and ones the list does not cover are left alone
+	for _, id := range []string{approved, evil} {
+		if ind, err := s.Get(ctx, id); err != nil || !ind.IsActive || len(removals(t, db, id)) != 0 {
+			t.Errorf("indicator %s = %+v, %v; want it active with nothing queued", id, ind, err)
+		}
+	}
+
+	// Indicators already quarantined are not queued twice
+	if n, err := s.Rescreen(ctx); err != nil || n != 0 {
+		t.Errorf("second Rescreen() = %d, %v; want 0", n, err)
+	}
+}
+
+func TestStoreRelease(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	s := NewStore(db)
+	if _, err := s.Upsert(ctx, []Indicator{
+		{Type: TypeIPv4, Value: "203.0.113.9", ThreatType: "C2", Confidence: 80, Source: "feed"},
+		{Type: TypeIPv4, Value: "203.0.113.10", ThreatType: "C2", Confidence: 80, Source: "feed"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	id, expired := idOf(t, db, "203.0.113.9"), idOf(t, db, "203.0.113.10")
+
+	if _, err := s.Release(ctx, id, "analyst"); err != ErrNotReviewable {
+		t.Fatalf("Release() of an unreviewed indicator = %v, want ErrNotReviewable", err)
+	}
+	if _, err := s.MarkFalsePositive(ctx, id, "customer egress", "analyst"); err != nil {
+		t.Fatal(err)
+	}
+	if got := removals(t, db, id); got[ReviewFalsePositive] != "pending" {
+		t.Fatalf("removals after MarkFalsePositive = %v, want a pending false_positive one", got)
+	}
+
+	ind, err := s.Release(ctx, id, "lead")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ind.IsActive || ind.ReviewStatus != ReviewApproved || ind.ReviewReason != "" {
+		t.Errorf("released indicator = active %v, review %s (%s)", ind.IsActive, ind.ReviewStatus, ind.ReviewReason)
+	}
+	if got := removals(t, db, id); got[ReviewFalsePositive] != "cancelled" {
+		t.Errorf("removals after Release = %v, want the false_positive one cancelled", got)
+	}
+
+	// Released indicators past their expiry stay inactive
+	if _, err := s.MarkFalsePositive(ctx, expired, "", "analyst"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		UPDATE deployment_mgmt.threat_indicators SET expiration_date = NOW() - INTERVAL '1 day'
+		WHERE id = $1`, expired); err != nil {
+		t.Fatal(err)
+	}
+	if ind, err := s.Release(ctx, expired, "lead"); err != nil || ind.IsActive {
+		t.Errorf("Release() of an expired indicator = %+v, %v; want it inactive", ind, err)
+	}
+
+	if _, err := s.Release(ctx, "00000000-0000-0000-0000-000000000000", "lead"); err != ErrNotFound {
+		t.Errorf("Release() of an unknown indicator = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStoreMarkFalsePositive(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	s := NewStore(db)
+	if _, err := s.Upsert(ctx, []Indicator{
+		{Type: TypeDomain, Value: "benign.example", ThreatType: "C2", Confidence: 80, Source: "feed"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	id := idOf(t, db, "benign.example")
+
+	ind, err := s.MarkFalsePositive(ctx, id, "CDN", "analyst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ind.IsActive || ind.ReviewStatus != ReviewFalsePositive || ind.ReviewReason != "CDN" {
+		t.Errorf("retracted indicator = active %v, review %s (%s)", ind.IsActive, ind.ReviewStatus, ind.ReviewReason)
+	}
+	if _, err := s.MarkFalsePositive(ctx, id, "CDN", "analyst"); err != ErrNotReviewable {
+		t.Errorf("second MarkFalsePositive() = %v, want ErrNotReviewable", err)
+	}
+	if got := removals(t, db, id); len(got) != 1 || got[ReviewFalsePositive] != "pending" {
+		t.Errorf("removals = %v, want one pending false_positive removal", got)
+	}
+
+	// Feeds reporting it again do not reactivate it
+	if _, err := s.Upsert(ctx, []Indicator{
+		{Type: TypeDomain, Value: "benign.example", ThreatType: "C2", Confidence: 90, Source: "feed"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if ind, err := s.Get(ctx, id); err != nil || ind.IsActive {
+		t.Errorf("reported again = %+v, %v; want it inactive", ind, err)
+	}
+}
@@ -0,0 +1,294 @@
+package indicator
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/validation"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+	// maxFacetValues caps the values counted per facet
+	maxFacetValues = 50
+)
+
+// ErrInvalidCursor is returned by Search for a cursor it did not issue
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Query selects indicators for Search. Every field is optional and the
+// fields given must all match.
+type Query struct {
+	// ClientID limits results to the client's own indicators plus the global
+	// ones
+	ClientID    string
+	Types       []Type
+	ThreatTypes []string
+	Sources     []string
+	// Tags matches indicators carrying all of the tags
+	Tags          []string
+	MinConfidence *int
+	MaxConfidence *int
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+	// Text matches indicators whose value or description contains it
+	Text string
+	// ValuePrefix matches values starting with it
+	ValuePrefix string
+	// Within matches IP and CIDR indicators inside the network, such as
+	// 10.0.0.0/8
+	Within *net.IPNet
+	// ReviewStatus matches indicators in one of the Review states
+	ReviewStatus    string
+	IncludeInactive bool
+
+	// Limit is the page size, 100 by default and at most 1000
+	Limit int
+	// Cursor continues from the page that returned it
+	Cursor string
+	// Facets asks for the total and facet counts of the whole result set
+	Facets bool
+}
+
+// FacetCount is the number of matching indicators with one facet value
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// SearchResult is one page of matching indicators, newest first
+type SearchResult struct {
+	Indicators []*Indicator `json:"indicators"`
+	// Next is the cursor of the following page, empty on the last page
+	Next string `json:"next,omitempty"`
+	// Total and Facets are only set when the query asked for facets
+	Total  *int                    `json:"total,omitempty"`
+	Facets map[string][]FacetCount `json:"facets,omitempty"`
+}
+
+// facetColumns are the columns facets are counted on, by facet name
+var facetColumns = map[string]string{
+	"type":       "indicator_type",
+	"threatType": "threat_type",
+	"source":     "source",
+	"tag":        "unnest(tags)",
+	"confidence": confidenceBand,
+}
+
+// confidenceBand groups confidence the way analysts triage it
+const confidenceBand = `CASE
+		WHEN COALESCE(confidence, 0) >= 90 THEN '90-100'
+		WHEN confidence >= 75 THEN '75-89'
+		WHEN confidence >= 50 THEN '50-74'
+		ELSE '0-49' END`
+
+// networkColumn is the address of IP and CIDR indicators and NULL for the
+// other types, which the inet cast would reject. It matches the expression
+// of idx_threat_indicators_network.
+const networkColumn = `(CASE WHEN indicator_type IN ('ipv4', 'ipv6', 'cidr') THEN indicator_value::inet END)`
+
+// Search returns a page of indicators matching the query, newest first
+func (s *Store) Search(ctx context.Context, q Query) (*SearchResult, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.ClientID != "" {
+		conditions = append(conditions, "(client_id IS NULL OR client_id = "+arg(q.ClientID)+")")
+	}
+	if len(q.Types) > 0 {
+		types := make([]string, len(q.Types))
+		for i, t := range q.Types {
+			types[i] = string(t)
+		}
+		conditions = append(conditions, "indicator_type = ANY("+arg(pq.Array(types))+")")
+	}
+	if len(q.ThreatTypes) > 0 {
+		conditions = append(conditions, "threat_type = ANY("+arg(pq.Array(q.ThreatTypes))+")")
+	}
+	if len(q.Sources) > 0 {
+		conditions = append(conditions, "source = ANY("+arg(pq.Array(q.Sources))+")")
+	}
+	if len(q.Tags) > 0 {
+		conditions = append(conditions, "tags @> "+arg(pq.Array(q.Tags)))
+	}
+	if q.MinConfidence != nil {
+		conditions = append(conditions, "confidence >= "+arg(*q.MinConfidence))
+	}
+	if q.MaxConfidence != nil {
+		conditions = append(conditions, "COALESCE(confidence, 0) <= "+arg(*q.MaxConfidence))
+	}
+	if !q.CreatedAfter.IsZero() {
+		conditions = append(conditions, "created_at >= "+arg(q.CreatedAfter))
+	}
+	if !q.CreatedBefore.IsZero() {
+		conditions = append(conditions, "created_at < "+arg(q.CreatedBefore))
+	}
+	if !q.ExpiresAfter.IsZero() {
+		conditions = append(conditions, "expiration_date >= "+arg(q.ExpiresAfter))
+	}
+	if !q.ExpiresBefore.IsZero() {
+		conditions = append(conditions, "expiration_date < "+arg(q.ExpiresBefore))
+	}
+	if q.Text != "" {
+		pattern := arg("%" + escapeLike(q.Text) + "%")
+		conditions = append(conditions, "(indicator_value ILIKE "+pattern+" OR description ILIKE "+pattern+")")
+	}
+	if q.ValuePrefix != "" {
+		conditions = append(conditions, "indicator_value LIKE "+arg(escapeLike(q.ValuePrefix)+"%"))
+	}
+	if q.Within != nil {
+		conditions = append(conditions, networkColumn+" <<= "+arg(q.Within.String())+"::inet")
+	}
+	if q.ReviewStatus != "" {
+		conditions = append(conditions, "review_status = "+arg(q.ReviewStatus))
+	}
+	if !q.IncludeInactive {
+		conditions = append(conditions, "is_active = true")
+	}
+
+	result := &SearchResult{Indicators: []*Indicator{}}
+	if q.Facets {
+		where := ""
+		if len(conditions) > 0 {
+			where = "WHERE " + strings.Join(conditions, " AND ")
+		}
+		if err := s.facets(ctx, where, args, result); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.Cursor != "" {
+		created, id, err := decodeSearchCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) < (%s, %s)", arg(created), arg(id)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, COALESCE(client_id::text, ''), indicator_type, indicator_value, threat_type,
+			COALESCE(confidence, 0), source, COALESCE(description, ''), tags,
+			COALESCE(feed_id::text, ''), COALESCE(external_id, ''), expiration_date,
+			is_active, COALESCE(review_status, ''), COALESCE(review_reason, ''), created_at, updated_at
+		FROM deployment_mgmt.threat_indicators
+		`+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT `+arg(limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ind Indicator
+		var indicatorType string
+		var expiration sql.NullTime
+		if err := rows.Scan(&ind.ID, &ind.ClientID, &indicatorType, &ind.Value, &ind.ThreatType,
+			&ind.Confidence, &ind.Source, &ind.Description, pq.Array(&ind.Tags),
+			&ind.FeedID, &ind.ExternalID, &expiration, &ind.IsActive,
+			&ind.ReviewStatus, &ind.ReviewReason, &ind.CreatedAt, &ind.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if len(result.Indicators) == limit {
+			last := result.Indicators[limit-1]
+			result.Next = encodeSearchCursor(last.CreatedAt, last.ID)
+			break
+		}
+		ind.Type = Type(indicatorType)
+		if expiration.Valid {
+			ind.ExpiresAt = &expiration.Time
+		}
+		result.Indicators = append(result.Indicators, &ind)
+	}
+	return result, rows.Err()
+}
+
+// facets counts the whole result set and its most common facet values
+func (s *Store) facets(ctx context.Context, where string, args []interface{}, result *SearchResult) error {
+	var total int
+	if err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM deployment_mgmt.threat_indicators "+where, args...).Scan(&total); err != nil {
+		return err
+	}
+	result.Total = &total
+	result.Facets = make(map[string][]FacetCount, len(facetColumns))
+
+	for name, column := range facetColumns {
+		rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+			SELECT value, COUNT(*) FROM (
+				SELECT %s AS value FROM deployment_mgmt.threat_indicators %s
+			) f
+			WHERE value IS NOT NULL
+			GROUP BY value
+			ORDER BY COUNT(*) DESC, value
+			LIMIT %d`, column, where, maxFacetValues), args...)
+		if err != nil {
+			return err
+		}
+		counts := []FacetCount{}
+		for rows.Next() {
+			var c FacetCount
+			if err := rows.Scan(&c.Value, &c.Count); err != nil {
+				rows.Close()
+				return err
+			}
+			counts = append(counts, c)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		result.Facets[name] = counts
+	}
+	return nil
+}
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func encodeSearchCursor(t time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(t.UTC().Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeSearchCursor(cursor string) (time.Time, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(data), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil || validation.ValidateUUID(parts[1]) != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return t, parts[1], nil
+}
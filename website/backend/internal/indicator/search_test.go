@@ -0,0 +1,200 @@
+package indicator
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+)
+
+func TestSearchCursor(t *testing.T) {
+	created := time.Date(2024, 3, 1, 10, 0, 0, 123456000, time.FixedZone("CET", 3600))
+	id := "8f14e45f-ceea-4671-9f1b-3c1f8e0a2b7d"
+	gotTime, gotID, err := decodeSearchCursor(encodeSearchCursor(created, id))
+	if err != nil || !gotTime.Equal(created) || gotID != id {
+		t.Errorf("cursor round trip = %s %s, %v; want %s %s", gotTime, gotID, err, created, id)
+	}
+
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for name, cursor := range map[string]string{
+		"not base64":      "!!!",
+		"no separator":    encode("2024-03-01T10:00:00Z"),
+		"bad time":        encode("yesterday|" + id),
+		"id not a uuid":   encode("2024-03-01T10:00:00Z|1 OR 1=1"),
+		"empty id":        encode("2024-03-01T10:00:00Z|"),
+		"padded encoding": base64.URLEncoding.EncodeToString([]byte("2024-03-01T10:00:00Z|" + id + "x")),
+	} {
+		if _, _, err := decodeSearchCursor(cursor); err != ErrInvalidCursor {
+			t.Errorf("%s: decodeSearchCursor(%q) = %v, want ErrInvalidCursor", name, cursor, err)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got, want := escapeLike(`50%_off\`), `50\%\_off\\`; got != want {
+		t.Errorf("escapeLike() = %s, want %s", got, want)
+	}
+}
+
+// values returns the indicator values of a page, in order
+func values(result *SearchResult) []string {
+	out := []string{}
+	for _, ind := range result.Indicators {
+		out = append(out, ind.Value)
+	}
+	return out
+}
+
+func TestStoreSearch(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	contoso := dbtest.CreateClient(t, db, "Contoso")
+	fabrikam := dbtest.CreateClient(t, db, "Fabrikam")
+	s := NewStore(db)
+	_, err := s.Upsert(ctx, []Indicator{
+		{Type: TypeIPv4, Value: "45.33.32.156", ThreatType: "C2", Confidence: 95, Source: "feed-a", Tags: []string{"apt", "c2"}},
+		{Type: TypeCIDR, Value: "45.33.32.0/28", ThreatType: "C2", Confidence: 60, Source: "feed-a"},
+		{Type: TypeIPv6, Value: "2600:3c01::f03c:91ff:fe18:bb2f", ThreatType: "Scanner", Confidence: 40, Source: "feed-b"},
+		{ClientID: contoso, Type: TypeDomain, Value: "evil_100%.org", ThreatType: "Phishing", Confidence: 80,
+			Source: "feed-b", Description: "Credential harvesting", Tags: []string{"apt"}},
+		{ClientID: fabrikam, Type: TypeURL, Value: "http://evil.org/payload.exe", ThreatType: "Malware",
+			Confidence: 70, Source: "feed-a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Creation order decides paging, so spread the rows apart
+	if _, err := db.Exec(`
+		UPDATE deployment_mgmt.threat_indicators
+		SET created_at = NOW() - make_interval(mins => (SELECT COUNT(*) FROM deployment_mgmt.threat_indicators i
+			WHERE i.indicator_value > threat_indicators.indicator_value COLLATE "C")::int)`); err != nil {
+		t.Fatal(err)
+	}
+
+	minConfidence := 60
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"everything, newest first", Query{}, []string{
+			"http://evil.org/payload.exe", "evil_100%.org", "45.33.32.156", "45.33.32.0/28", "2600:3c01::f03c:91ff:fe18:bb2f"}},
+		{"client and global", Query{ClientID: contoso}, []string{
+			"evil_100%.org", "45.33.32.156", "45.33.32.0/28", "2600:3c01::f03c:91ff:fe18:bb2f"}},
+		{"types", Query{Types: []Type{TypeIPv4, TypeIPv6}}, []string{"45.33.32.156", "2600:3c01::f03c:91ff:fe18:bb2f"}},
+		{"threat type and source", Query{ThreatTypes: []string{"C2"}, Sources: []string{"feed-a"}},
+			[]string{"45.33.32.156", "45.33.32.0/28"}},
+		{"all tags", Query{Tags: []string{"apt", "c2"}}, []string{"45.33.32.156"}},
+		{"min confidence", Query{MinConfidence: &minConfidence}, []string{
+			"http://evil.org/payload.exe", "evil_100%.org", "45.33.32.156", "45.33.32.0/28"}},
+		{"text in description", Query{Text: "harvesting"}, []string{"evil_100%.org"}},
+		{"text with wildcards", Query{Text: "_100%"}, []string{"evil_100%.org"}},
+		{"wildcards are literal", Query{Text: "45_33"}, []string{}},
+		{"value prefix", Query{ValuePrefix: "45.33."}, []string{"45.33.32.156", "45.33.32.0/28"}},
+		{"within a network", Query{Within: mustCIDR(t, "45.33.0.0/16")}, []string{"45.33.32.156", "45.33.32.0/28"}},
+		{"within a narrower network", Query{Within: mustCIDR(t, "45.33.32.128/25")}, []string{"45.33.32.156"}},
+		{"within an ipv6 network", Query{Within: mustCIDR(t, "2600::/12")}, []string{"2600:3c01::f03c:91ff:fe18:bb2f"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := s.Search(ctx, tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := values(result); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreSearchPages(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	s := NewStore(db)
+	var indicators []Indicator
+	for _, v := range []string{"45.33.32.1", "45.33.32.2", "45.33.32.3", "45.33.32.4", "45.33.32.5"} {
+		indicators = append(indicators, Indicator{Type: TypeIPv4, Value: v, ThreatType: "C2", Confidence: 80, Source: "test"})
+	}
+	if _, err := s.Upsert(ctx, indicators); err != nil {
+		t.Fatal(err)
+	}
+	// Every row shares its creation time, so paging relies on the ID
+	if _, err := db.Exec("UPDATE deployment_mgmt.threat_indicators SET created_at = NOW()"); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []string
+	q := Query{Limit: 2, Facets: true}
+	for page := 0; ; page++ {
+		result, err := s.Search(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page == 0 && (result.Total == nil || *result.Total != 5) {
+			t.Errorf("first page total = %v, want 5", result.Total)
+		}
+		seen = append(seen, values(result)...)
+		if result.Next == "" {
+			break
+		}
+		if page > 3 {
+			t.Fatal("paging did not end")
+		}
+		q.Cursor, q.Facets = result.Next, false
+	}
+	sort.Strings(seen)
+	if want := []string{"45.33.32.1", "45.33.32.2", "45.33.32.3", "45.33.32.4", "45.33.32.5"}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("pages held %q, want every indicator once", seen)
+	}
+
+	if _, err := s.Search(ctx, Query{Cursor: "!!!"}); err != ErrInvalidCursor {
+		t.Errorf("Search(bad cursor) = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestStoreSearchFacets(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	s := NewStore(db)
+	_, err := s.Upsert(ctx, []Indicator{
+		{Type: TypeIPv4, Value: "45.33.32.156", ThreatType: "C2", Confidence: 95, Source: "feed-a", Tags: []string{"apt"}},
+		{Type: TypeIPv4, Value: "45.33.32.157", ThreatType: "C2", Confidence: 80, Source: "feed-a", Tags: []string{"apt", "c2"}},
+		{Type: TypeDomain, Value: "evil.org", ThreatType: "Phishing", Confidence: 30, Source: "feed-b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.Search(ctx, Query{Facets: true, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total == nil || *result.Total != 3 || len(result.Indicators) != 1 || result.Next == "" {
+		t.Fatalf("Search() = total %v with %d indicators, want 3 in total and one on the page", result.Total, len(result.Indicators))
+	}
+	want := map[string][]FacetCount{
+		"type":       {{"ipv4", 2}, {"domain", 1}},
+		"threatType": {{"C2", 2}, {"Phishing", 1}},
+		"source":     {{"feed-a", 2}, {"feed-b", 1}},
+		"tag":        {{"apt", 2}, {"c2", 1}},
+		"confidence": {{"0-49", 1}, {"75-89", 1}, {"90-100", 1}},
+	}
+	if !reflect.DeepEqual(result.Facets, want) {
+		t.Errorf("facets = %+v, want %+v", result.Facets, want)
+	}
+}
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return network
+}
@@ -0,0 +1,162 @@
+package indicator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/stix"
+)
+
+// Observable is a typed value extracted from a STIX pattern
+type Observable struct {
+	Type  Type
+	Value string
+}
+
+// FromPattern converts the comparisons of a STIX pattern into observables.
+// Comparisons on unsupported object paths are skipped.
+func FromPattern(pattern string) ([]Observable, error) {
+	comparisons, err := stix.ParsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var observables []Observable
+	for _, c := range comparisons {
+		t, ok := typeForComparison(c)
+		if !ok {
+			continue
+		}
+		value := c.Value
+		if t == TypeIPv4 || t == TypeIPv6 {
+			// Host prefixes are written as plain addresses
+			value = strings.TrimSuffix(strings.TrimSuffix(value, "/32"), "/128")
+		}
+		observables = append(observables, Observable{Type: t, Value: value})
+	}
+	if len(observables) == 0 {
+		return nil, fmt.Errorf("pattern contains no supported observables")
+	}
+	return observables, nil
+}
+
+func typeForComparison(c stix.Comparison) (Type, bool) {
+	path := strings.ToUpper(strings.ReplaceAll(c.Path, "-", ""))
+
+	switch c.ObjectType {
+	case "ipv4-addr":
+		if strings.Contains(c.Value, "/") && !strings.HasSuffix(c.Value, "/32") {
+			return TypeCIDR, true
+		}
+		return TypeIPv4, true
+	case "ipv6-addr":
+		if strings.Contains(c.Value, "/") && !strings.HasSuffix(c.Value, "/128") {
+			return TypeCIDR, true
+		}
+		return TypeIPv6, true
+	case "domain-name":
+		return TypeDomain, true
+	case "url":
+		return TypeURL, true
+	case "email-addr":
+		return TypeEmail, true
+	case "file":
+		switch path {
+		case "HASHES.MD5":
+			return TypeMD5, true
+		case "HASHES.SHA1":
+			return TypeSHA1, true
+		case "HASHES.SHA256":
+			return TypeSHA256, true
+		}
+	case "x509-certificate":
+		if strings.HasPrefix(path, "HASHES.") {
+			return TypeCertificate, true
+		}
+	}
+	return "", false
+}
+
+// Pattern renders an indicator value as a STIX 2.1 pattern
+func Pattern(t Type, value string) (string, error) {
+	switch t {
+	case TypeIPv4:
+		return stix.FormatComparison("ipv4-addr", "value", value), nil
+	case TypeIPv6:
+		return stix.FormatComparison("ipv6-addr", "value", value), nil
+	case TypeCIDR:
+		if strings.Contains(value, ":") {
+			return stix.FormatComparison("ipv6-addr", "value", value), nil
+		}
+		return stix.FormatComparison("ipv4-addr", "value", value), nil
+	case TypeDomain:
+		return stix.FormatComparison("domain-name", "value", value), nil
+	case TypeURL:
+		return stix.FormatComparison("url", "value", value), nil
+	case TypeEmail:
+		return stix.FormatComparison("email-addr", "value", value), nil
+	case TypeMD5:
+		return stix.FormatComparison("file", "hashes.MD5", value), nil
+	case TypeSHA1:
+		return stix.FormatComparison("file", "hashes.'SHA-1'", value), nil
+	case TypeSHA256:
+		return stix.FormatComparison("file", "hashes.'SHA-256'", value), nil
+	case TypeCertificate:
+		return stix.FormatComparison("x509-certificate", "hashes.'SHA-1'", value), nil
+	}
+
+	// Rows written before types were normalized use generic names
+	if parsed, ok := ParseType(string(t)); ok {
+		return Pattern(parsed, value)
+	}
+	if strings.EqualFold(string(t), "hash") {
+		switch len(value) {
+		case 32:
+			return Pattern(TypeMD5, value)
+		case 40:
+			return Pattern(TypeSHA1, value)
+		case 64:
+			return Pattern(TypeSHA256, value)
+		}
+	}
+	return "", fmt.Errorf("no stix pattern for indicator type %q", t)
+}
+
+// ToSTIX renders a stored indicator as a STIX 2.1 indicator object. The
+// object ID is derived from the row ID so repeated exports are versions of
+// the same object.
+func ToSTIX(ind *Indicator) (stix.Indicator, error) {
+	pattern, err := Pattern(ind.Type, ind.Value)
+	if err != nil {
+		return stix.Indicator{}, err
+	}
+
+	object := stix.Indicator{
+		Object: stix.Object{
+			Type:        "indicator",
+			SpecVersion: stix.SpecVersion,
+			ID:          "indicator--" + ind.ID,
+			Created:     ind.CreatedAt.UTC(),
+			Modified:    ind.UpdatedAt.UTC(),
+			Labels:      ind.Tags,
+			Revoked:     !ind.IsActive,
+		},
+		Name:        ind.Value,
+		Description: ind.Description,
+		Pattern:     pattern,
+		PatternType: "stix",
+		ValidFrom:   ind.CreatedAt.UTC(),
+	}
+	if ind.ThreatType != "" {
+		object.IndicatorTypes = []string{ind.ThreatType}
+	}
+	if ind.Confidence > 0 {
+		c := ind.Confidence
+		object.Confidence = &c
+	}
+	if ind.ExpiresAt != nil {
+		until := ind.ExpiresAt.UTC()
+		object.ValidUntil = &until
+	}
+	return object, nil
+}
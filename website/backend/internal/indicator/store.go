@@ -0,0 +1,311 @@
+package indicator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Store writes indicators to deployment_mgmt.threat_indicators
+type Store struct {
+	db     *sql.DB
+	policy *Policy
+
+	allowMu     sync.Mutex
+	allow       *AllowList
+	allowLoaded time.Time
+}
+
+// NewStore creates an indicator store using the default aging policy
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db, policy: DefaultPolicy()}
+}
+
+// Policy returns the aging policy applied by the store
+func (s *Store) Policy() *Policy {
+	return s.policy
+}
+
+// UpsertResult counts the rows affected by Upsert
+type UpsertResult struct {
+	Inserted int `json:"inserted"`
+	Updated  int `json:"updated"`
+	// Quarantined counts the indicators held back by the allow-list
+	Quarantined int `json:"quarantined"`
+}
+
+const upsertColumns = `
+	(client_id, indicator_type, indicator_value, threat_type, confidence, base_confidence,
+	 source, description, tags, feed_id, external_id, expiration_date, last_seen_at,
+	 review_status, review_reason, is_active)
+	VALUES ($1, $2, $3, $4, $5, $5, $6, $7, $8, $9, $10, $11, NOW(), $12, $13, $12::text IS NULL)`
+
+const upsertUpdate = `
+	DO UPDATE SET
+		threat_type = EXCLUDED.threat_type,
+		confidence = GREATEST(threat_indicators.confidence, EXCLUDED.confidence),
+		base_confidence = GREATEST(threat_indicators.confidence, EXCLUDED.confidence),
+		description = COALESCE(NULLIF(EXCLUDED.description, ''), threat_indicators.description),
+		tags = ARRAY(SELECT DISTINCT unnest(COALESCE(threat_indicators.tags, '{}') || EXCLUDED.tags)),
+		feed_id = COALESCE(threat_indicators.feed_id, EXCLUDED.feed_id),
+		external_id = COALESCE(EXCLUDED.external_id, threat_indicators.external_id),
+		expiration_date = GREATEST(EXCLUDED.expiration_date, threat_indicators.expiration_date),
+		is_active = NOT (threat_indicators.review_status IN ('quarantined', 'false_positive')
+			OR (EXCLUDED.review_status IS NOT NULL
+				AND threat_indicators.review_status IS DISTINCT FROM 'approved')),
+		review_status = COALESCE(threat_indicators.review_status, EXCLUDED.review_status),
+		review_reason = CASE WHEN threat_indicators.review_status IS NULL
+			THEN EXCLUDED.review_reason ELSE threat_indicators.review_reason END,
+		last_seen_at = NOW(),
+		updated_at = NOW()
+	RETURNING (xmax = 0), COALESCE(review_status, '') = 'quarantined'`
+
+// Upsert stores a batch of indicators in one transaction, merging them into
+// existing rows with the same client, type and value. Indicators without an
+// expiry get the policy default for their type, and seeing an indicator
+// again restarts its confidence decay and can only push its expiry later.
+// Indicators covered by the allow-list are stored quarantined, and
+// indicators retracted as false positives stay inactive.
+func (s *Store) Upsert(ctx context.Context, indicators []Indicator) (UpsertResult, error) {
+	var result UpsertResult
+
+	allow, err := s.AllowList(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to load allow-list: %v", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	// Global indicators have a NULL client_id, which the table's unique
+	// constraint does not cover, so they conflict on a partial index instead
+	global, err := tx.PrepareContext(ctx, `INSERT INTO deployment_mgmt.threat_indicators `+
+		upsertColumns+`
+		ON CONFLICT (indicator_type, indicator_value) WHERE client_id IS NULL`+upsertUpdate)
+	if err != nil {
+		return result, err
+	}
+	defer global.Close()
+
+	scoped, err := tx.PrepareContext(ctx, `INSERT INTO deployment_mgmt.threat_indicators `+
+		upsertColumns+`
+		ON CONFLICT (client_id, indicator_type, indicator_value)`+upsertUpdate)
+	if err != nil {
+		return result, err
+	}
+	defer scoped.Close()
+
+	now := time.Now()
+	for _, ind := range indicators {
+		expires := ind.ExpiresAt
+		if expires == nil {
+			t := s.policy.ExpiresAt(ind.Type, now)
+			expires = &t
+		}
+
+		stmt := scoped
+		var clientID interface{} = ind.ClientID
+		if ind.ClientID == "" {
+			stmt = global
+			clientID = nil
+		}
+		var review, reason sql.NullString
+		if e, ok := allow.Match(&ind); ok {
+			review = sql.NullString{String: ReviewQuarantined, Valid: true}
+			reason = sql.NullString{String: e.Reason(), Valid: true}
+		}
+		var inserted, quarantined bool
+		if err := stmt.QueryRowContext(ctx, clientID, string(ind.Type), ind.Value,
+			ind.ThreatType, ind.Confidence, ind.Source, ind.Description,
+			pq.Array(ind.Tags), nullString(ind.FeedID), nullString(ind.ExternalID),
+			expires, review, reason).Scan(&inserted, &quarantined); err != nil {
+			return result, fmt.Errorf("failed to store indicator %s: %v", ind.Value, err)
+		}
+		if inserted {
+			result.Inserted++
+		} else {
+			result.Updated++
+		}
+		if quarantined {
+			result.Quarantined++
+		}
+	}
+	return result, tx.Commit()
+}
+
+// Refresh renews indicators as if they had just been seen again: their
+// confidence is restored to the last reported value, their expiry is reset
+// to the policy default and expired indicators are reactivated. It returns
+// the number of indicators renewed.
+func (s *Store) Refresh(ctx context.Context, ids []string) (int, error) {
+	return s.renew(ctx, ids, `
+		UPDATE deployment_mgmt.threat_indicators
+		SET confidence = COALESCE(base_confidence, confidence),
+			last_seen_at = NOW(),
+			expiration_date = NOW() + make_interval(secs => `+s.policy.lifetimeSQL()+`),
+			is_active = true,
+			updated_at = NOW()
+		WHERE id::text = ANY($1)
+		AND review_status IS DISTINCT FROM 'quarantined'
+		AND review_status IS DISTINCT FROM 'false_positive'`)
+}
+
+// Extend moves the expiry of indicators to until, reactivating expired
+// ones when until is in the future. It returns the number of indicators
+// changed.
+func (s *Store) Extend(ctx context.Context, ids []string, until time.Time) (int, error) {
+	return s.renew(ctx, ids, `
+		UPDATE deployment_mgmt.threat_indicators
+		SET expiration_date = $2,
+			is_active = is_active OR ($2 > NOW()
+				AND review_status IS DISTINCT FROM 'quarantined'
+				AND review_status IS DISTINCT FROM 'false_positive'),
+			updated_at = NOW()
+		WHERE id::text = ANY($1)`, until)
+}
+
+// renew runs an update over indicators and withdraws any removals still
+// queued for the ones left active
+func (s *Store) renew(ctx context.Context, ids []string, query string, args ...interface{}) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, query, append([]interface{}{pq.Array(ids)}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	renewed, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE deployment_mgmt.indicator_distribution_queue q
+		SET status = 'cancelled', processed_at = NOW()
+		FROM deployment_mgmt.threat_indicators i
+		WHERE q.indicator_id = i.id
+		AND i.id::text = ANY($1)
+		AND i.is_active = true
+		AND q.action = 'remove'
+		AND q.status = 'pending'`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	return int(renewed), tx.Commit()
+}
+
+// Filter selects indicators for ForEach
+type Filter struct {
+	// ClientID limits results to the client's own indicators plus the global
+	// ones. An empty ClientID selects every indicator.
+	ClientID string
+	// IDs limits results to the given indicators
+	IDs             []string
+	Types           []Type
+	IncludeInactive bool
+	// UpdatedAfter limits results to indicators changed after the given time
+	UpdatedAfter time.Time
+}
+
+// ErrNotFound is returned by Get for unknown indicators
+var ErrNotFound = errors.New("indicator not found")
+
+// Get returns one indicator, active or not
+func (s *Store) Get(ctx context.Context, id string) (*Indicator, error) {
+	var found *Indicator
+	err := s.ForEach(ctx, Filter{IDs: []string{id}, IncludeInactive: true}, func(ind *Indicator) error {
+		found = ind
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+// ForEach streams the indicators matching the filter to fn in creation
+// order. Iteration stops at the first error returned by fn.
+func (s *Store) ForEach(ctx context.Context, filter Filter, fn func(*Indicator) error) error {
+	var conditions []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.ClientID != "" {
+		conditions = append(conditions, "(client_id IS NULL OR client_id = "+arg(filter.ClientID)+")")
+	}
+	if len(filter.IDs) > 0 {
+		conditions = append(conditions, "id::text = ANY("+arg(pq.Array(filter.IDs))+")")
+	}
+	if len(filter.Types) > 0 {
+		types := make([]string, len(filter.Types))
+		for i, t := range filter.Types {
+			types[i] = string(t)
+		}
+		conditions = append(conditions, "indicator_type = ANY("+arg(pq.Array(types))+")")
+	}
+	if !filter.IncludeInactive {
+		conditions = append(conditions, "is_active = true")
+	}
+	if !filter.UpdatedAfter.IsZero() {
+		conditions = append(conditions, "updated_at > "+arg(filter.UpdatedAfter))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, COALESCE(client_id::text, ''), indicator_type, indicator_value, threat_type,
+			COALESCE(confidence, 0), source, COALESCE(description, ''), tags,
+			COALESCE(feed_id::text, ''), COALESCE(external_id, ''), expiration_date,
+			is_active, COALESCE(review_status, ''), COALESCE(review_reason, ''), created_at, updated_at
+		FROM deployment_mgmt.threat_indicators
+		`+where+`
+		ORDER BY created_at, id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ind Indicator
+		var indicatorType string
+		var expiration sql.NullTime
+		if err := rows.Scan(&ind.ID, &ind.ClientID, &indicatorType, &ind.Value, &ind.ThreatType,
+			&ind.Confidence, &ind.Source, &ind.Description, pq.Array(&ind.Tags),
+			&ind.FeedID, &ind.ExternalID, &expiration, &ind.IsActive,
+			&ind.ReviewStatus, &ind.ReviewReason, &ind.CreatedAt, &ind.UpdatedAt); err != nil {
+			return err
+		}
+		ind.Type = Type(indicatorType)
+		if expiration.Valid {
+			ind.ExpiresAt = &expiration.Time
+		}
+		if err := fn(&ind); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,108 @@
+package indicator
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+const defaultSweepInterval = time.Hour
+
+// SweepResult counts the indicators changed by a sweep
+type SweepResult struct {
+	Expired int `json:"expired"`
+	Decayed int `json:"decayed"`
+}
+
+// Sweeper ages stored indicators. Each sweep deactivates indicators past
+// their expiry, queueing their removal from the platforms they were
+// distributed to, and decays the confidence of indicators that have not
+// been seen recently.
+type Sweeper struct {
+	db       *sql.DB
+	policy   *Policy
+	interval time.Duration
+}
+
+// NewSweeper creates a sweeper applying the store's aging policy
+func NewSweeper(db *sql.DB, store *Store) *Sweeper {
+	return &Sweeper{db: db, policy: store.Policy(), interval: defaultSweepInterval}
+}
+
+// Run sweeps periodically until the context is cancelled
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		result, err := s.Sweep(ctx)
+		if err != nil {
+			log.Printf("Error sweeping threat indicators: %v", err)
+		} else if result.Expired > 0 || result.Decayed > 0 {
+			log.Printf("Indicator sweep: %d expired, %d decayed", result.Expired, result.Decayed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep expires and decays indicators once
+func (s *Sweeper) Sweep(ctx context.Context) (SweepResult, error) {
+	var result SweepResult
+
+	res, err := s.db.ExecContext(ctx, `
+		WITH expired AS (
+			UPDATE deployment_mgmt.threat_indicators
+			SET is_active = false, updated_at = NOW()
+			WHERE is_active = true AND expiration_date <= NOW()
+			RETURNING id
+		)
+		INSERT INTO deployment_mgmt.indicator_distribution_queue (indicator_id, action, reason)
+		SELECT id, 'remove', 'expired' FROM expired`)
+	if err != nil {
+		return result, err
+	}
+	expired, err := res.RowsAffected()
+	if err != nil {
+		return result, err
+	}
+	result.Expired = int(expired)
+
+	// Confidence halves over each lifetime since the indicator was last seen.
+	// updated_at only moves when the decay takes an indicator across the
+	// min_confidence of a distribution route, since TAXII clients collect
+	// and the distributor replans every indicator changed since they last
+	// looked. Other decay is picked up with the next change.
+	res, err = s.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.threat_indicators
+		SET confidence = decayed.confidence,
+			updated_at = CASE WHEN EXISTS (
+				SELECT 1 FROM deployment_mgmt.distribution_routes r
+				WHERE r.indicator_type = threat_indicators.indicator_type
+				AND r.min_confidence > LEAST(decayed.confidence, threat_indicators.confidence)
+				AND r.min_confidence <= GREATEST(decayed.confidence, threat_indicators.confidence)
+			) THEN NOW() ELSE threat_indicators.updated_at END
+		FROM (
+			SELECT id, GREATEST($1, ROUND(base_confidence * power(0.5,
+				EXTRACT(EPOCH FROM NOW() - last_seen_at) / (`+s.policy.lifetimeSQL()+`))))::int AS confidence
+			FROM deployment_mgmt.threat_indicators
+			WHERE is_active = true
+			AND last_seen_at IS NOT NULL
+			AND base_confidence > $1
+		) decayed
+		WHERE threat_indicators.id = decayed.id
+		AND threat_indicators.confidence <> decayed.confidence`, s.policy.MinConfidence)
+	if err != nil {
+		return result, err
+	}
+	decayed, err := res.RowsAffected()
+	if err != nil {
+		return result, err
+	}
+	result.Decayed = int(decayed)
+	return result, nil
+}
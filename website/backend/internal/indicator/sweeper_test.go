@@ -0,0 +1,127 @@
+package indicator
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+)
+
+// addAged stores an indicator last seen ago with the given base confidence
+// and returns its ID. Its confidence and updated_at are left as they were
+// when it was last seen.
+func addAged(t *testing.T, db *sql.DB, clientID string, ty Type, value string, base int, ago time.Duration) string {
+	t.Helper()
+	var id string
+	if err := db.QueryRow(`
+		INSERT INTO deployment_mgmt.threat_indicators
+			(client_id, indicator_type, indicator_value, threat_type, confidence, base_confidence,
+			 source, is_active, last_seen_at, updated_at, expiration_date)
+		VALUES ($1, $2, $3, 'C2', $4, $4, 'test', true,
+			NOW() - make_interval(secs => $5), NOW() - make_interval(secs => $5), NOW() + INTERVAL '1 day')
+		RETURNING id`, clientID, string(ty), value, base, ago.Seconds()).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// aged returns the confidence and updated_at of an indicator
+func aged(t *testing.T, db *sql.DB, id string) (int, time.Time) {
+	t.Helper()
+	var confidence int
+	var updatedAt time.Time
+	if err := db.QueryRow(`
+		SELECT confidence, updated_at FROM deployment_mgmt.threat_indicators WHERE id = $1`, id).
+		Scan(&confidence, &updatedAt); err != nil {
+		t.Fatal(err)
+	}
+	return confidence, updatedAt
+}
+
+func TestSweeperExpires(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	clientID := dbtest.CreateClient(t, db, "Contoso")
+	id := addAged(t, db, clientID, TypeIPv4, "45.33.32.156", 80, time.Hour)
+	if _, err := db.Exec(`
+		UPDATE deployment_mgmt.threat_indicators SET expiration_date = NOW() - INTERVAL '1 minute'
+		WHERE id = $1`, id); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSweeper(db, NewStore(db))
+	result, err := s.Sweep(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Expired != 1 {
+		t.Fatalf("Sweep() = %+v, want 1 expired", result)
+	}
+	var active bool
+	var queued int
+	if err := db.QueryRow(`
+		SELECT is_active, (SELECT COUNT(*) FROM deployment_mgmt.indicator_distribution_queue
+			WHERE indicator_id = $1 AND action = 'remove' AND reason = 'expired')
+		FROM deployment_mgmt.threat_indicators WHERE id = $1`, id).Scan(&active, &queued); err != nil {
+		t.Fatal(err)
+	}
+	if active || queued != 1 {
+		t.Errorf("active = %v, queued removals = %d; want inactive with one removal queued", active, queued)
+	}
+
+	// Nothing is left to expire
+	if result, err := s.Sweep(ctx); err != nil || result.Expired != 0 {
+		t.Errorf("second Sweep() = %+v, %v; want nothing expired", result, err)
+	}
+}
+
+func TestSweeperDecays(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	clientID := dbtest.CreateClient(t, db, "Contoso")
+	lifetime := DefaultLifetimes[TypeIPv4]
+	quiet := addAged(t, db, clientID, TypeIPv4, "45.33.32.156", 80, lifetime)
+	crossing := addAged(t, db, clientID, TypeDomain, "evil.org", 80, DefaultLifetimes[TypeDomain])
+	floored := addAged(t, db, clientID, TypeURL, "http://evil.org/x", 80, 10*lifetime)
+	fresh := addAged(t, db, clientID, TypeSHA256,
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 80, time.Minute)
+	// Domains below 50 are not distributed
+	if _, err := db.Exec(`
+		INSERT INTO deployment_mgmt.distribution_routes (indicator_type, targets, min_confidence)
+		VALUES ('domain', ARRAY['MDE'], 50), ('ipv4', ARRAY['MDE'], 30)`); err != nil {
+		t.Fatal(err)
+	}
+	_, quietBefore := aged(t, db, quiet)
+	_, crossingBefore := aged(t, db, crossing)
+
+	s := NewSweeper(db, NewStore(db))
+	result, err := s.Sweep(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Decayed != 3 {
+		t.Errorf("Sweep() = %+v, want 3 decayed", result)
+	}
+
+	// A lifetime unseen halves confidence without crossing the ipv4 route
+	if c, updatedAt := aged(t, db, quiet); c != 40 || !updatedAt.Equal(quietBefore) {
+		t.Errorf("quiet indicator = %d updated %s, want 40 with updated_at kept at %s", c, updatedAt, quietBefore)
+	}
+	// Falling below the domain route's threshold is a change to distribute
+	if c, updatedAt := aged(t, db, crossing); c != 40 || !updatedAt.After(crossingBefore) {
+		t.Errorf("crossing indicator = %d updated %s, want 40 with updated_at moved on from %s", c, updatedAt, crossingBefore)
+	}
+	if c, _ := aged(t, db, floored); c != DefaultPolicy().MinConfidence {
+		t.Errorf("long unseen indicator confidence = %d, want the minimum %d", c, DefaultPolicy().MinConfidence)
+	}
+	if c, _ := aged(t, db, fresh); c != 80 {
+		t.Errorf("recently seen indicator confidence = %d, want 80", c)
+	}
+
+	// Decay already applied is not applied again
+	if result, err := s.Sweep(ctx); err != nil || result.Decayed != 0 {
+		t.Errorf("second Sweep() = %+v, %v; want nothing decayed", result, err)
+	}
+}
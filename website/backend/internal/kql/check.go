@@ -0,0 +1,259 @@
+package kql
+
+import "strings"
+
+// Operators are the tabular operators that may follow a pipe
+var Operators = map[string]bool{
+	"as": true, "consume": true, "count": true, "distinct": true, "evaluate": true, "extend": true,
+	"externaldata": true, "facet": true, "filter": true, "find": true, "fork": true, "getschema": true,
+	"invoke": true, "join": true, "limit": true, "lookup": true, "make-graph": true, "make-series": true,
+	"mv-apply": true, "mv-expand": true, "order": true, "parse": true, "parse-kv": true,
+	"parse-where": true, "partition": true, "project": true, "project-away": true,
+	"project-keep": true, "project-rename": true, "project-reorder": true, "reduce": true,
+	"render": true, "sample": true, "sample-distinct": true, "scan": true, "search": true,
+	"serialize": true, "sort": true, "summarize": true, "take": true, "top": true,
+	"top-hitters": true, "top-nested": true, "union": true, "where": true, "graph-match": true,
+}
+
+// sources are the operators that produce rows without input
+var sources = map[string]bool{
+	"datatable": true, "externaldata": true, "find": true, "print": true, "range": true,
+	"search": true, "union": true, "evaluate": true, "materialize": true, "view": true,
+}
+
+// bare are the operators that take no arguments
+var bare = map[string]bool{"count": true, "getschema": true, "serialize": true, "consume": true}
+
+// binary are tokens that cannot end an expression
+var binary = map[string]bool{
+	"==": true, "!=": true, "=~": true, "!~": true, "<": true, ">": true, "<=": true, ">=": true,
+	"<>": true, "=": true, "+": true, "-": true, "/": true, "%": true, ",": true,
+	"and": true, "or": true, "not": true, "has": true, "!has": true, "contains": true,
+	"!contains": true, "startswith": true, "!startswith": true, "endswith": true, "!endswith": true,
+	"has_any": true, "has_all": true, "in": true, "!in": true, "in~": true, "!in~": true,
+	"matches": true, "between": true, "!between": true, "by": true,
+}
+
+// Check returns the syntax errors of a query, or none. It checks that
+// strings and brackets are closed, that statements are separated by
+// semicolons and end with a tabular expression, and that each pipe is
+// followed by a known tabular operator.
+func Check(query string) []*SyntaxError {
+	tokens, err := Tokenize(query)
+	if err != nil {
+		return []*SyntaxError{err.(*SyntaxError)}
+	}
+	if len(tokens) == 0 {
+		return []*SyntaxError{newError(query, 0, "query is empty")}
+	}
+	if err := checkBrackets(query, tokens); err != nil {
+		return []*SyntaxError{err}
+	}
+	c := &checker{query: query}
+	c.statements(tokens, len(query))
+	return c.errors
+}
+
+func checkBrackets(query string, tokens []Token) *SyntaxError {
+	pairs := map[string]string{")": "(", "]": "[", "}": "{"}
+	var open []Token
+	for _, t := range tokens {
+		if t.Kind != Punct {
+			continue
+		}
+		switch t.Text {
+		case "(", "[", "{":
+			open = append(open, t)
+		case ")", "]", "}":
+			if len(open) == 0 || open[len(open)-1].Text != pairs[t.Text] {
+				return newError(query, t.Pos, "unexpected %s", t.Text)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	if len(open) > 0 {
+		last := open[len(open)-1]
+		return newError(query, last.Pos, "%s is never closed", last.Text)
+	}
+	return nil
+}
+
+type checker struct {
+	query  string
+	errors []*SyntaxError
+}
+
+func (c *checker) fail(pos int, format string, args ...interface{}) {
+	c.errors = append(c.errors, newError(c.query, pos, format, args...))
+}
+
+// statements checks a semicolon-separated list of statements ending with
+// a tabular expression. end is the position just past them.
+func (c *checker) statements(tokens []Token, end int) {
+	statements := split(tokens, ";")
+	var last []Token
+	for _, st := range statements {
+		if len(st) == 0 {
+			continue
+		}
+		last = st
+		switch first := st[0]; {
+		case first.Is("let"):
+			if len(st) < 4 || st[1].Kind != Ident || !st[2].Is("=") {
+				c.fail(first.Pos, "let needs a name, = and a value")
+				continue
+			}
+			if value := st[3:]; len(split(value, "|")) > 1 {
+				c.tabular(value, nextPos(st, end))
+			} else {
+				c.expression(value, nextPos(st, end))
+			}
+		case first.Is("set"), first.Is("declare"), first.Is("alias"), first.Is("restrict"), first.Is("pattern"):
+		case first.Is("."):
+			c.fail(first.Pos, "control commands cannot be used in a query")
+		default:
+			c.tabular(st, nextPos(st, end))
+		}
+	}
+	if last == nil {
+		c.fail(end, "query has no tabular expression")
+	} else if last[0].Is("let") || last[0].Is("set") || last[0].Is("declare") {
+		c.fail(last[0].Pos, "query ends with a %s statement instead of a tabular expression", last[0].Text)
+	}
+}
+
+// tabular checks a source followed by piped operators
+func (c *checker) tabular(tokens []Token, end int) {
+	segments := split(tokens, "|")
+	for i, seg := range segments {
+		if len(seg) == 0 {
+			pos := end
+			if i < len(segments)-1 {
+				pos = pipeBefore(tokens, i+1)
+			}
+			switch {
+			case i == 0:
+				c.fail(tokens[0].Pos, "query starts with | instead of a table")
+			case i == len(segments)-1:
+				c.fail(pipeBefore(tokens, i), "query ends with |")
+			default:
+				c.fail(pos, "nothing between two pipes")
+			}
+			continue
+		}
+		op := seg[0]
+		if i == 0 {
+			if op.Kind == Ident && Operators[op.Text] && !sources[op.Text] {
+				c.fail(op.Pos, "%s needs input; start the query with a table", op.Text)
+			}
+		} else if op.Kind != Ident || !Operators[op.Text] {
+			if op.Kind == Ident && Operators[strings.ToLower(op.Text)] {
+				c.fail(op.Pos, "unknown operator %q; operators are lower case", op.Text)
+			} else {
+				c.fail(op.Pos, "unknown operator %q", op.Text)
+			}
+			continue
+		} else if len(seg) == 1 && !bare[op.Text] {
+			c.fail(op.Pos, "%s needs arguments", op.Text)
+			continue
+		}
+		c.expression(seg, nextPos(seg, end))
+	}
+}
+
+// expression checks that an expression does not end with an operator and
+// checks the queries nested in its brackets
+func (c *checker) expression(tokens []Token, end int) {
+	if last := tokens[len(tokens)-1]; binary[last.Text] && last.Kind != String && last.Kind != Number {
+		c.fail(last.Pos, "expression ends with %s", last.Text)
+	}
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.Kind != Punct || (t.Text != "(" && t.Text != "{") {
+			continue
+		}
+		close := matching(tokens, i)
+		inner := tokens[i+1 : close]
+		if len(inner) > 0 && (len(split(inner, "|")) > 1 || len(split(inner, ";")) > 1) {
+			c.statements(inner, tokens[close].Pos)
+		} else if len(inner) > 0 {
+			c.expression(inner, tokens[close].Pos)
+		}
+		i = close
+	}
+}
+
+// split splits tokens on a separator outside brackets
+func split(tokens []Token, sep string) [][]Token {
+	var parts [][]Token
+	depth, start := 0, 0
+	for i, t := range tokens {
+		if t.Kind != Punct {
+			continue
+		}
+		switch t.Text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, tokens[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, tokens[start:])
+}
+
+// matching returns the index of the bracket closing tokens[i]
+func matching(tokens []Token, i int) int {
+	depth := 0
+	for j := i; j < len(tokens); j++ {
+		if tokens[j].Kind != Punct {
+			continue
+		}
+		switch tokens[j].Text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return len(tokens) - 1
+}
+
+// pipeBefore returns the position of the pipe opening segment n
+func pipeBefore(tokens []Token, n int) int {
+	depth, seen := 0, 0
+	for _, t := range tokens {
+		if t.Kind != Punct {
+			continue
+		}
+		switch t.Text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		case "|":
+			if depth == 0 {
+				seen++
+				if seen == n {
+					return t.Pos
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func nextPos(tokens []Token, end int) int {
+	last := tokens[len(tokens)-1]
+	if p := last.Pos + len(last.Text); p < end {
+		return p
+	}
+	return end
+}
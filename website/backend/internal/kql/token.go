@@ -0,0 +1,187 @@
+// Package kql reads Kusto Query Language queries well enough to check
+// their syntax and rewrite them. It does not know table schemas or
+// function signatures.
+package kql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// TokenKind is the lexical class of a token
+type TokenKind int
+
+// Token kinds
+const (
+	Ident TokenKind = iota
+	String
+	Number
+	Punct
+)
+
+// Token is a lexical token of a query. Pos is the byte offset of its first
+// character.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos  int
+}
+
+// Is reports whether the token is the identifier or punctuation text
+func (t Token) Is(text string) bool {
+	return (t.Kind == Ident || t.Kind == Punct) && t.Text == text
+}
+
+// SyntaxError is a problem found in a query. Line and Column are 1-based.
+type SyntaxError struct {
+	Pos     int    `json:"pos"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+func newError(query string, pos int, format string, args ...interface{}) *SyntaxError {
+	if pos > len(query) {
+		pos = len(query)
+	}
+	line := strings.Count(query[:pos], "\n") + 1
+	column := pos - strings.LastIndex(query[:pos], "\n")
+	return &SyntaxError{Pos: pos, Line: line, Column: column, Message: fmt.Sprintf(format, args...)}
+}
+
+// hyphenated are the keywords written with a hyphen, which otherwise
+// separates two identifiers being subtracted
+var hyphenated = map[string]bool{
+	"project-away": true, "project-keep": true, "project-rename": true, "project-reorder": true,
+	"mv-expand": true, "mv-apply": true, "parse-where": true, "parse-kv": true,
+	"top-nested": true, "top-hitters": true, "sample-distinct": true, "make-series": true,
+	"make-graph": true, "graph-match": true,
+}
+
+// punctuation are the multi-character operators, longest first
+var punctuation = []string{"!==", "==", "!=", "=~", "!~", "<=", ">=", "<>", "=>", ".."}
+
+// Tokenize splits a query into tokens, dropping comments and white space
+func Tokenize(query string) ([]Token, error) {
+	var tokens []Token
+	i := 0
+	for i < len(query) {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+
+		case strings.HasPrefix(query[i:], "//"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			i += end
+
+		case strings.HasPrefix(query[i:], "```") || strings.HasPrefix(query[i:], "~~~"):
+			fence := query[i : i+3]
+			end := strings.Index(query[i+3:], fence)
+			if end < 0 {
+				return nil, newError(query, i, "unterminated %s string", fence)
+			}
+			tokens = append(tokens, Token{Kind: String, Text: query[i : i+3+end+3], Pos: i})
+			i += 3 + end + 3
+
+		case c == '"' || c == '\'' ||
+			(c == '@' || c == 'h' || c == 'H') && i+1 < len(query) && (query[i+1] == '"' || query[i+1] == '\''):
+			end, err := scanString(query, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, Token{Kind: String, Text: query[i:end], Pos: i})
+			i = end
+
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			start := i
+			for i < len(query) && (isIdentChar(query[i]) || query[i] == '.' && i+1 < len(query) && query[i+1] != '.') {
+				i++
+			}
+			tokens = append(tokens, Token{Kind: Number, Text: query[start:i], Pos: start})
+
+		case isIdentStart(c) || c == '!' && i+1 < len(query) && isIdentStart(query[i+1]):
+			start := i
+			i++
+			for i < len(query) && isIdentChar(query[i]) {
+				i++
+			}
+			// project-away and friends
+			for i+1 < len(query) && query[i] == '-' && isIdentStart(query[i+1]) {
+				j := i + 1
+				for j < len(query) && isIdentChar(query[j]) {
+					j++
+				}
+				if !hyphenated[query[start:j]] {
+					break
+				}
+				i = j
+			}
+			// in~ and !in~
+			if text := query[start:i]; (text == "in" || text == "!in") && i < len(query) && query[i] == '~' {
+				i++
+			}
+			tokens = append(tokens, Token{Kind: Ident, Text: query[start:i], Pos: start})
+
+		default:
+			text := string(c)
+			for _, p := range punctuation {
+				if strings.HasPrefix(query[i:], p) {
+					text = p
+					break
+				}
+			}
+			if !strings.ContainsAny(text[:1], "|;,()[]{}=<>+-*/%.:!?~@#$^&") {
+				return nil, newError(query, i, "unexpected character %q", rune(c))
+			}
+			tokens = append(tokens, Token{Kind: Punct, Text: text, Pos: i})
+			i += len(text)
+		}
+	}
+	return tokens, nil
+}
+
+// scanString returns the end of the string literal starting at i
+func scanString(query string, i int) (int, error) {
+	start := i
+	verbatim := query[i] == '@'
+	if query[i] == '@' || query[i] == 'h' || query[i] == 'H' {
+		i++
+	}
+	quote := query[i]
+	i++
+	for i < len(query) {
+		switch c := query[i]; {
+		case c == '\n':
+			return 0, newError(query, start, "unterminated string")
+		case c == '\\' && !verbatim:
+			i += 2
+		case c == quote:
+			// A verbatim string escapes its quote by doubling it
+			if verbatim && i+1 < len(query) && query[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1, nil
+		default:
+			i++
+		}
+	}
+	return 0, newError(query, start, "unterminated string")
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || c < 0x80 && unicode.IsLetter(rune(c))
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
@@ -0,0 +1,274 @@
+// Package library reads the detection rules in the repository's rules
+// directory and converts them for the platforms clients run.
+//
+// Rules are YAML documents filed under a MITRE ATT&CK tactic folder, such
+// as defense_evasion/t1055_process_injection.yar. The Advanced Hunting
+// query of a rule lives in a .txt document of the same name at the top of
+// the library. Plain .kql files, and .txt files that are not YAML, hold a
+// query without metadata.
+package library
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrRuleNotFound is returned for a rule ID the library does not have
+var ErrRuleNotFound = errors.New("rule not found")
+
+// tactics are the tactic folders of the library and the MITRE ATT&CK
+// tactics they hold, as Sentinel names them
+var tactics = map[string]string{
+	"reconnaissance": "Reconnaissance", "resource_development": "ResourceDevelopment",
+	"initial_access": "InitialAccess", "execution": "Execution", "persistence": "Persistence",
+	"privilege_escalation": "PrivilegeEscalation", "defense_evasion": "DefenseEvasion",
+	"credential_access": "CredentialAccess", "discovery": "Discovery",
+	"lateral_movement": "LateralMovement", "collection": "Collection",
+	"command_and_control": "CommandAndControl", "exfiltration": "Exfiltration", "impact": "Impact",
+}
+
+// Rule is a detection rule of the library. ID is the rule's path in the
+// library without its extension, such as collection/t1114_email_collection.
+type Rule struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name,omitempty"`
+	Title       string   `json:"title"`
+	Author      string   `json:"author,omitempty"`
+	Date        string   `json:"date,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Severity    string   `json:"severity,omitempty"`
+	Techniques  []string `json:"techniques,omitempty"`
+	// Tactic is the tactic folder the rule is filed under, if any, such as
+	// defense_evasion
+	Tactic    string   `json:"tactic,omitempty"`
+	Tables    []string `json:"tables,omitempty"`
+	Timeframe string   `json:"timeframe,omitempty"`
+	Frequency string   `json:"frequency,omitempty"`
+	Query     string   `json:"query,omitempty"`
+	// Columns are the output columns the rule declares
+	Columns []string `json:"columns,omitempty"`
+	Files   []string `json:"files"`
+}
+
+// Library is a directory of detection rules. It is read on every call, so
+// rules added to the directory show up without a restart.
+type Library struct {
+	Dir string
+}
+
+// New returns the library in dir
+func New(dir string) *Library {
+	return &Library{Dir: dir}
+}
+
+// document is the YAML layout of a rule
+type document struct {
+	Rule string `yaml:"rule"`
+	Meta struct {
+		Title           string   `yaml:"title"`
+		Author          string   `yaml:"author"`
+		Date            string   `yaml:"date"`
+		MitreTechniques []string `yaml:"mitre_techniques"`
+		Severity        string   `yaml:"severity"`
+		Description     string   `yaml:"description"`
+	} `yaml:"meta"`
+	Tables struct {
+		Primary   stringList `yaml:"primary"`
+		Secondary stringList `yaml:"secondary"`
+	} `yaml:"tables"`
+	Timeframe string     `yaml:"timeframe"`
+	Frequency string     `yaml:"frequency"`
+	KQL       kqlSection `yaml:"kql"`
+	Output    struct {
+		RequiredColumns []string `yaml:"required_columns"`
+		EntityColumns   []string `yaml:"entity_columns"`
+		// Some rules file their query under output
+		KQL kqlSection `yaml:"kql"`
+	} `yaml:"output"`
+}
+
+type kqlSection struct {
+	Query string `yaml:"query"`
+}
+
+func (doc *document) query() string {
+	if doc.KQL.Query != "" {
+		return strings.TrimSpace(doc.KQL.Query)
+	}
+	return strings.TrimSpace(doc.Output.KQL.Query)
+}
+
+// stringList is a YAML sequence of strings or a single string
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = stringList{node.Value}
+		return nil
+	}
+	var values []string
+	if err := node.Decode(&values); err != nil {
+		return err
+	}
+	*l = values
+	return nil
+}
+
+// Rules returns the rules of the library ordered by ID
+func (l *Library) Rules() ([]*Rule, error) {
+	var rules []*Rule
+	// queries are the .txt documents at the top of the library by name
+	queries := make(map[string]*document)
+	queryFiles := make(map[string]string)
+
+	err := filepath.WalkDir(l.Dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(l.Dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		ext := filepath.Ext(rel)
+		id := strings.TrimSuffix(rel, ext)
+
+		switch ext {
+		case ".yar", ".yaml", ".yml", ".txt":
+			doc, err := readDocument(path)
+			if ext == ".txt" && (err != nil || doc.Rule == "" && doc.query() == "") {
+				// A query saved as text
+				return l.addQuery(&rules, path, id, rel)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", rel, err)
+			}
+			if ext == ".txt" && !strings.Contains(rel, "/") {
+				queries[id] = doc
+				queryFiles[id] = rel
+				return nil
+			}
+			rules = append(rules, doc.rule(id, rel))
+		case ".kql":
+			return l.addQuery(&rules, path, id, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Join each query to the rules filed under its name, and keep queries
+	// without a filed rule as rules of their own
+	filed := make(map[string]bool)
+	for _, rule := range rules {
+		name := filepath.Base(rule.ID)
+		if doc, ok := queries[name]; ok && rule.Query == "" {
+			rule.Query = doc.query()
+			rule.Files = append(rule.Files, queryFiles[name])
+			filed[name] = true
+		}
+	}
+	for name, doc := range queries {
+		if !filed[name] {
+			rules = append(rules, doc.rule(name, queryFiles[name]))
+		}
+	}
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules, nil
+}
+
+// Rule returns the rule with an ID
+func (l *Library) Rule(id string) (*Rule, error) {
+	rules, err := l.Rules()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if rule.ID == id {
+			return rule, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrRuleNotFound, id)
+}
+
+// addQuery adds a rule that is only a query
+func (l *Library) addQuery(rules *[]*Rule, path, id, rel string) error {
+	query, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	*rules = append(*rules, &Rule{
+		ID:    id,
+		Title: filepath.Base(id),
+		Query: strings.TrimSpace(string(query)),
+		Files: []string{rel},
+	})
+	return nil
+}
+
+func readDocument(path string) (*document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// Some rules start with a // filepath: comment, which is not YAML
+	var lines [][]byte
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if !bytes.HasPrefix(bytes.TrimSpace(line), []byte("//")) || len(lines) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	var doc document
+	if err := yaml.Unmarshal(bytes.Join(lines, []byte("\n")), &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (doc *document) rule(id, file string) *Rule {
+	rule := &Rule{
+		ID:          id,
+		Name:        doc.Rule,
+		Title:       doc.Meta.Title,
+		Author:      doc.Meta.Author,
+		Date:        doc.Meta.Date,
+		Description: doc.Meta.Description,
+		Severity:    doc.Meta.Severity,
+		Techniques:  doc.Meta.MitreTechniques,
+		Tables:      append(append([]string{}, doc.Tables.Primary...), doc.Tables.Secondary...),
+		Timeframe:   doc.Timeframe,
+		Frequency:   doc.Frequency,
+		Query:       doc.query(),
+		Files:       []string{file},
+	}
+	if i := strings.Index(id, "/"); i > 0 && tactics[id[:i]] != "" {
+		rule.Tactic = id[:i]
+	}
+	if rule.Title == "" {
+		rule.Title = filepath.Base(id)
+	}
+	for _, c := range append(append([]string{}, doc.Output.RequiredColumns...), doc.Output.EntityColumns...) {
+		if !contains(rule.Columns, c) {
+			rule.Columns = append(rule.Columns, c)
+		}
+	}
+	return rule
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
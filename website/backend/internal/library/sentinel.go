@@ -0,0 +1,376 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/kql"
+	"github.com/ClarityXDR/prod/website/backend/internal/sentinel"
+)
+
+// ErrNotConvertible is returned for a rule that cannot run as a Sentinel
+// analytics rule
+var ErrNotConvertible = errors.New("rule cannot be converted")
+
+// Sentinel schedules a rule between every 5 minutes and every 14 days and
+// maps at most 10 entities
+const (
+	minInterval       = 5 * time.Minute
+	maxInterval       = 14 * 24 * time.Hour
+	maxEntityMappings = 10
+	maxFieldMappings  = 3
+)
+
+var (
+	// filedTechnique matches the technique a rule file is named after, such
+	// as t1059_001_powershell_encoded
+	filedTechnique = regexp.MustCompile(`^[tT](\d{4})(?:_\d{3})?_`)
+	technique      = regexp.MustCompile(`^[tT](\d{4})(?:\.\d{3})?$`)
+	interval       = regexp.MustCompile(`^(\d+)\s*([mhd])$`)
+)
+
+// entityColumns are the Defender columns that identify an entity, in the
+// order their entities are mapped. Columns of the same group describe one
+// entity, such as the account that ran a process.
+var entityColumns = []struct {
+	column, group, entityType, identifier string
+}{
+	{"DeviceName", "device", "Host", "FullName"},
+	{"RemoteDeviceName", "remoteDevice", "Host", "FullName"},
+	{"AccountUpn", "account", "Account", "FullName"},
+	{"UserPrincipalName", "account", "Account", "FullName"},
+	{"AccountName", "account", "Account", "Name"},
+	{"AccountDomain", "account", "Account", "NTDomain"},
+	{"AccountSid", "account", "Account", "Sid"},
+	{"AccountObjectId", "account", "Account", "AadUserId"},
+	{"InitiatingProcessAccountUpn", "initiatingAccount", "Account", "FullName"},
+	{"InitiatingProcessAccountName", "initiatingAccount", "Account", "Name"},
+	{"InitiatingProcessAccountDomain", "initiatingAccount", "Account", "NTDomain"},
+	{"InitiatingProcessAccountSid", "initiatingAccount", "Account", "Sid"},
+	{"RemoteIP", "remoteIP", "IP", "Address"},
+	{"IPAddress", "ip", "IP", "Address"},
+	{"LocalIP", "localIP", "IP", "Address"},
+	{"PublicIP", "publicIP", "IP", "Address"},
+	{"RemoteUrl", "url", "URL", "Url"},
+	{"Url", "url", "URL", "Url"},
+	{"ProcessCommandLine", "process", "Process", "CommandLine"},
+	{"ProcessId", "process", "Process", "ProcessId"},
+	{"InitiatingProcessCommandLine", "initiatingProcess", "Process", "CommandLine"},
+	{"InitiatingProcessId", "initiatingProcess", "Process", "ProcessId"},
+	{"FileName", "file", "File", "Name"},
+	{"FolderPath", "file", "File", "Directory"},
+	{"SHA256", "sha256", "FileHash", "Value"},
+	{"SHA1", "sha1", "FileHash", "Value"},
+	{"MD5", "md5", "FileHash", "Value"},
+	{"RegistryKey", "registryKey", "RegistryKey", "Key"},
+	{"RegistryValueName", "registryValue", "RegistryValue", "Name"},
+	{"RegistryValueData", "registryValue", "RegistryValue", "Value"},
+	{"RecipientEmailAddress", "recipient", "Mailbox", "MailboxPrimaryAddress"},
+	{"SenderFromAddress", "sender", "Mailbox", "MailboxPrimaryAddress"},
+	{"NetworkMessageId", "message", "MailMessage", "NetworkMessageId"},
+}
+
+// Conversion is a library rule converted to a Sentinel analytics rule.
+// Warnings describe what the conversion could not carry over.
+type Conversion struct {
+	RuleID   string                 `json:"ruleId"`
+	Rule     sentinel.AnalyticsRule `json:"rule"`
+	Warnings []string               `json:"warnings,omitempty"`
+}
+
+func (c *Conversion) warnf(format string, args ...interface{}) {
+	c.Warnings = append(c.Warnings, fmt.Sprintf(format, args...))
+}
+
+// ToSentinel converts a rule to a scheduled analytics rule. The query's
+// Timestamp columns become TimeGenerated, the rule's timeframe and
+// frequency become the query period and frequency, its folder and
+// techniques become tactics and techniques, and the columns the query
+// projects are mapped to entities.
+func ToSentinel(rule *Rule) (*Conversion, error) {
+	if strings.TrimSpace(rule.Query) == "" {
+		return nil, fmt.Errorf("%w: %s has no query", ErrNotConvertible, rule.ID)
+	}
+	query, err := renameColumn(rule.Query, "Timestamp", "TimeGenerated")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrNotConvertible, rule.ID, err)
+	}
+	if errs := kql.Check(query); len(errs) > 0 {
+		return nil, fmt.Errorf("%w: %s: %v", ErrNotConvertible, rule.ID, errs[0])
+	}
+
+	c := &Conversion{
+		RuleID: rule.ID,
+		Rule: sentinel.AnalyticsRule{
+			Name:            rule.ID,
+			DisplayName:     rule.Title,
+			Description:     rule.Description,
+			Query:           query,
+			TriggerOperator: "GreaterThan",
+		},
+	}
+	if c.Rule.Description == "" {
+		c.Rule.Description = rule.Title
+	}
+	c.Rule.Severity = c.severity(rule.Severity)
+	frequency := c.interval("frequency", rule.Frequency, time.Hour)
+	period := c.interval("timeframe", rule.Timeframe, frequency)
+	if rule.Timeframe == "" {
+		c.warnf("the rule has no timeframe; each run looks back %s", formatISO(period))
+	}
+	if period < frequency {
+		c.warnf("timeframe %s is shorter than frequency %s, so events between runs are missed", rule.Timeframe, rule.Frequency)
+	}
+	c.Rule.QueryFrequency, c.Rule.QueryPeriod = formatISO(frequency), formatISO(period)
+
+	if tactic := tactics[rule.Tactic]; tactic != "" {
+		c.Rule.Tactics = []string{tactic}
+	} else {
+		c.warnf("the rule is not filed under a tactic folder, so it has no tactic")
+	}
+	c.Rule.Techniques = c.techniques(rule)
+
+	columns, projected := projectedColumns(query)
+	if !projected {
+		for _, col := range rule.Columns {
+			if col == "Timestamp" {
+				col = "TimeGenerated"
+			}
+			columns = append(columns, col)
+		}
+	}
+	c.Rule.EntityMappings = c.entityMappings(columns)
+	if len(c.Rule.EntityMappings) == 0 {
+		c.warnf("no projected column identifies an entity, so alerts will have no entities")
+	}
+	return c, nil
+}
+
+func (c *Conversion) severity(severity string) string {
+	switch strings.ToLower(severity) {
+	case "informational", "info":
+		return sentinel.SeverityInformational
+	case "low":
+		return sentinel.SeverityLow
+	case "medium":
+		return sentinel.SeverityMedium
+	case "high":
+		return sentinel.SeverityHigh
+	case "critical":
+		c.warnf("Sentinel has no critical severity; the rule is high")
+		return sentinel.SeverityHigh
+	case "":
+		c.warnf("the rule has no severity; the rule is medium")
+	default:
+		c.warnf("unknown severity %q; the rule is medium", severity)
+	}
+	return sentinel.SeverityMedium
+}
+
+// interval parses a library interval such as 7d into one Sentinel can
+// schedule
+func (c *Conversion) interval(field, value string, def time.Duration) time.Duration {
+	d, ok := parseInterval(value)
+	switch {
+	case value == "":
+		d = def
+	case !ok:
+		c.warnf("%s %q is not a number of minutes, hours or days; using %s", field, value, formatISO(def))
+		d = def
+	case d < minInterval:
+		c.warnf("%s %s is shorter than Sentinel allows; using %s", field, value, formatISO(minInterval))
+		d = minInterval
+	case d > maxInterval:
+		c.warnf("%s %s is longer than Sentinel allows; using %s", field, value, formatISO(maxInterval))
+		d = maxInterval
+	}
+	return d
+}
+
+// techniques returns the parent techniques of the rule's file name and
+// metadata. Sentinel does not take sub-techniques.
+func (c *Conversion) techniques(rule *Rule) []string {
+	var found []string
+	add := func(id string) {
+		id = "T" + id
+		if !contains(found, id) {
+			found = append(found, id)
+		}
+	}
+	if m := filedTechnique.FindStringSubmatch(filepath.Base(rule.ID)); m != nil {
+		add(m[1])
+	}
+	for _, t := range rule.Techniques {
+		if m := technique.FindStringSubmatch(strings.TrimSpace(t)); m != nil {
+			add(m[1])
+		} else {
+			c.warnf("technique %q is not a MITRE ATT&CK technique ID", t)
+		}
+	}
+	return found
+}
+
+// entityMappings maps the entity columns among columns
+func (c *Conversion) entityMappings(columns []string) []sentinel.EntityMapping {
+	var mappings []sentinel.EntityMapping
+	index := make(map[string]int)
+	for _, ec := range entityColumns {
+		if !contains(columns, ec.column) {
+			continue
+		}
+		i, ok := index[ec.group]
+		if !ok {
+			if len(mappings) == maxEntityMappings {
+				c.warnf("column %s is not mapped; Sentinel maps at most %d entities", ec.column, maxEntityMappings)
+				continue
+			}
+			i = len(mappings)
+			index[ec.group] = i
+			mappings = append(mappings, sentinel.EntityMapping{EntityType: ec.entityType})
+		}
+		m := &mappings[i]
+		if len(m.FieldMappings) == maxFieldMappings || hasIdentifier(m.FieldMappings, ec.identifier) {
+			continue
+		}
+		m.FieldMappings = append(m.FieldMappings, sentinel.FieldMapping{Identifier: ec.identifier, ColumnName: ec.column})
+	}
+	return mappings
+}
+
+func hasIdentifier(fields []sentinel.FieldMapping, identifier string) bool {
+	for _, f := range fields {
+		if f.Identifier == identifier {
+			return true
+		}
+	}
+	return false
+}
+
+// renameColumn renames a column wherever the query names it, leaving
+// strings and comments alone
+func renameColumn(query, from, to string) (string, error) {
+	tokens, err := kql.Tokenize(query)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	last := 0
+	for _, t := range tokens {
+		if t.Kind == kql.Ident && t.Text == from {
+			b.WriteString(query[last:t.Pos])
+			b.WriteString(to)
+			last = t.Pos + len(t.Text)
+		}
+	}
+	b.WriteString(query[last:])
+	return b.String(), nil
+}
+
+// projectedColumns returns the columns the last statement of a query
+// outputs, and false when no project or summarize fixes them
+func projectedColumns(query string) ([]string, bool) {
+	tokens, err := kql.Tokenize(query)
+	if err != nil {
+		return nil, false
+	}
+	statements := splitTokens(tokens, ";")
+	var last []kql.Token
+	for _, st := range statements {
+		if len(st) > 0 {
+			last = st
+		}
+	}
+	segments := splitTokens(last, "|")
+
+	var columns []string
+	for i := len(segments) - 1; i > 0; i-- {
+		seg := segments[i]
+		if len(seg) == 0 {
+			continue
+		}
+		switch seg[0].Text {
+		case "project", "project-keep", "project-reorder", "summarize":
+			return append(columns, names(seg[1:])...), true
+		case "extend":
+			columns = append(columns, names(seg[1:])...)
+		}
+	}
+	return columns, false
+}
+
+// names returns the columns a comma-separated list of columns and
+// assignments outputs, including those after a summarize's by
+func names(tokens []kql.Token) []string {
+	var cols []string
+	for _, item := range splitTokens(tokens, ",") {
+		if len(item) > 0 && item[0].Is("by") {
+			item = item[1:]
+		}
+		for j, t := range item {
+			if t.Is("by") {
+				// the end of summarize aggregations
+				cols = append(cols, names(item[j+1:])...)
+				item = item[:j]
+				break
+			}
+		}
+		switch {
+		case len(item) >= 2 && item[0].Kind == kql.Ident && item[1].Is("="):
+			cols = append(cols, item[0].Text)
+		case len(item) == 1 && item[0].Kind == kql.Ident:
+			cols = append(cols, item[0].Text)
+		}
+	}
+	return cols
+}
+
+// splitTokens splits tokens on a separator outside brackets
+func splitTokens(tokens []kql.Token, sep string) [][]kql.Token {
+	var parts [][]kql.Token
+	depth, start := 0, 0
+	for i, t := range tokens {
+		if t.Kind != kql.Punct {
+			continue
+		}
+		switch t.Text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, tokens[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, tokens[start:])
+}
+
+// parseInterval parses a library interval such as 30m, 1h or 7d
+func parseInterval(s string) (time.Duration, bool) {
+	m := interval.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, false
+	}
+	n, _ := strconv.Atoi(m[1])
+	unit := map[string]time.Duration{"m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}[m[2]]
+	return time.Duration(n) * unit, true
+}
+
+// formatISO formats a whole number of minutes as an ISO 8601 duration
+func formatISO(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("P%dD", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("PT%dH", d/time.Hour)
+	default:
+		return fmt.Sprintf("PT%dM", d/time.Minute)
+	}
+}
@@ -0,0 +1,236 @@
+package library
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/sentinel"
+)
+
+// writeLibrary writes files into a new library directory
+func writeLibrary(t *testing.T, files map[string]string) *Library {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return New(dir)
+}
+
+const encodedPowerShell = `rule: EncodedPowerShell
+meta:
+  title: "Encoded PowerShell"
+  mitre_techniques: ["T1059.001", "T1027", "Obfuscation"]
+  severity: "critical"
+  description: "PowerShell started with an encoded command"
+tables:
+  primary: "DeviceProcessEvents"
+timeframe: "2h"
+frequency: "30m"
+kql:
+  query: |
+    DeviceProcessEvents
+    | where Timestamp > ago(2h)
+    | where FileName =~ "powershell.exe" and ProcessCommandLine has "-enc" // not "Timestamp"
+    | project Timestamp, DeviceName, AccountName, AccountDomain, ProcessCommandLine, SHA256
+`
+
+const smbConnections = `DeviceNetworkEvents
+| where Timestamp > ago(1d)
+| where RemotePort == 445
+| summarize Connections = count() by DeviceName, RemoteIP
+| where Connections > 50`
+
+func TestToSentinel(t *testing.T) {
+	lib := writeLibrary(t, map[string]string{
+		"execution/t1059_001_encoded_powershell.yaml": encodedPowerShell,
+		"detect_abnormal_smb_connections.kql":         smbConnections,
+	})
+
+	rule, err := lib.Rule("execution/t1059_001_encoded_powershell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ToSentinel(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := c.Rule
+	if c.RuleID != rule.ID || got.Name != rule.ID || got.DisplayName != "Encoded PowerShell" ||
+		got.Description != "PowerShell started with an encoded command" || got.TriggerOperator != "GreaterThan" {
+		t.Errorf("rule = %+v", got)
+	}
+	if got.Severity != sentinel.SeverityHigh || got.QueryFrequency != "PT30M" || got.QueryPeriod != "PT2H" {
+		t.Errorf("severity %s, frequency %s, period %s; want High, PT30M, PT2H",
+			got.Severity, got.QueryFrequency, got.QueryPeriod)
+	}
+	if !reflect.DeepEqual(got.Tactics, []string{"Execution"}) || !reflect.DeepEqual(got.Techniques, []string{"T1059", "T1027"}) {
+		t.Errorf("tactics %v, techniques %v", got.Tactics, got.Techniques)
+	}
+	if strings.Contains(got.Query, "Timestamp,") || !strings.Contains(got.Query, "TimeGenerated > ago(2h)") ||
+		!strings.Contains(got.Query, `// not "Timestamp"`) {
+		t.Errorf("query = %s, want Timestamp columns renamed and comments kept", got.Query)
+	}
+	wantMappings := []sentinel.EntityMapping{
+		{EntityType: "Host", FieldMappings: []sentinel.FieldMapping{{Identifier: "FullName", ColumnName: "DeviceName"}}},
+		{EntityType: "Account", FieldMappings: []sentinel.FieldMapping{
+			{Identifier: "Name", ColumnName: "AccountName"}, {Identifier: "NTDomain", ColumnName: "AccountDomain"}}},
+		{EntityType: "Process", FieldMappings: []sentinel.FieldMapping{{Identifier: "CommandLine", ColumnName: "ProcessCommandLine"}}},
+		{EntityType: "FileHash", FieldMappings: []sentinel.FieldMapping{{Identifier: "Value", ColumnName: "SHA256"}}},
+	}
+	if !reflect.DeepEqual(got.EntityMappings, wantMappings) {
+		t.Errorf("entity mappings = %+v, want %+v", got.EntityMappings, wantMappings)
+	}
+	wantWarnings := []string{
+		"Sentinel has no critical severity; the rule is high",
+		`technique "Obfuscation" is not a MITRE ATT&CK technique ID`,
+	}
+	if !reflect.DeepEqual(c.Warnings, wantWarnings) {
+		t.Errorf("warnings = %q, want %q", c.Warnings, wantWarnings)
+	}
+
+	// A bare query has no metadata, so the defaults apply and are reported
+	rule, err = lib.Rule("detect_abnormal_smb_connections")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err = ToSentinel(rule); err != nil {
+		t.Fatal(err)
+	}
+	got = c.Rule
+	if got.Severity != sentinel.SeverityMedium || got.QueryFrequency != "PT1H" || got.QueryPeriod != "PT1H" ||
+		len(got.Tactics) != 0 || len(got.Techniques) != 0 {
+		t.Errorf("bare query rule = %+v", got)
+	}
+	if len(got.EntityMappings) != 2 || got.EntityMappings[0].EntityType != "Host" || got.EntityMappings[1].EntityType != "IP" {
+		t.Errorf("bare query entity mappings = %+v, want the summarize by columns", got.EntityMappings)
+	}
+	if len(c.Warnings) != 3 {
+		t.Errorf("bare query warnings = %q, want severity, timeframe and tactic", c.Warnings)
+	}
+
+	// Converted rules deploy
+	cfg := &sentinel.DeploymentConfig{
+		Workspace: sentinel.Workspace{SubscriptionID: "11111111-1111-1111-1111-111111111111",
+			ResourceGroup: "rg-sentinel", Name: "law-contoso"},
+		Location:       "westeurope",
+		TenantID:       "22222222-2222-2222-2222-222222222222",
+		DataConnectors: []sentinel.DataConnector{{Kind: "MicrosoftThreatProtection"}},
+		AnalyticsRules: []sentinel.AnalyticsRule{got},
+	}
+	rule, _ = lib.Rule("execution/t1059_001_encoded_powershell")
+	first, _ := ToSentinel(rule)
+	cfg.AnalyticsRules = append(cfg.AnalyticsRules, first.Rule)
+	if r := sentinel.NewValidator().Validate(cfg, []string{"m365-e5"}); !r.Valid {
+		t.Errorf("converted rules do not validate: %+v", r.Errors)
+	}
+}
+
+func TestToSentinelIntervals(t *testing.T) {
+	tests := []struct {
+		frequency, timeframe string
+		wantFrequency        string
+		wantPeriod           string
+		warnings             int
+	}{
+		{"1h", "1d", "PT1H", "P1D", 0},
+		{"1m", "1h", "PT5M", "PT1H", 1},
+		{"1h", "30d", "PT1H", "P14D", 1},
+		{"hourly", "1h", "PT1H", "PT1H", 1},
+		{"1d", "1h", "P1D", "PT1H", 1},
+		{"2h", "", "PT2H", "PT2H", 1},
+	}
+	for _, tt := range tests {
+		rule := &Rule{ID: "execution/interval", Title: "Interval", Severity: "low", Tactic: "execution",
+			Frequency: tt.frequency, Timeframe: tt.timeframe,
+			Query: "DeviceProcessEvents | project TimeGenerated, DeviceName"}
+		c, err := ToSentinel(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Rule.QueryFrequency != tt.wantFrequency || c.Rule.QueryPeriod != tt.wantPeriod || len(c.Warnings) != tt.warnings {
+			t.Errorf("frequency %q, timeframe %q: got %s, %s with warnings %q; want %s, %s with %d",
+				tt.frequency, tt.timeframe, c.Rule.QueryFrequency, c.Rule.QueryPeriod, c.Warnings,
+				tt.wantFrequency, tt.wantPeriod, tt.warnings)
+		}
+	}
+}
+
+func TestToSentinelNotConvertible(t *testing.T) {
+	for name, query := range map[string]string{
+		"no query":          " ",
+		"unbalanced":        "DeviceProcessEvents | where (Timestamp > ago(1h)",
+		"unterminated text": `DeviceProcessEvents | where FileName == "cmd.exe`,
+	} {
+		if _, err := ToSentinel(&Rule{ID: name, Query: query}); !errors.Is(err, ErrNotConvertible) {
+			t.Errorf("%s: ToSentinel() = %v, want ErrNotConvertible", name, err)
+		}
+	}
+}
+
+func TestProjectedColumns(t *testing.T) {
+	tests := []struct {
+		query     string
+		want      []string
+		projected bool
+	}{
+		{"T | project A, B = tolower(C), D", []string{"A", "B", "D"}, true},
+		{"T | summarize count() by DeviceName, bin(TimeGenerated, 1h)", []string{"DeviceName"}, true},
+		{"T | summarize N = dcount(X) by AccountUpn", []string{"AccountUpn", "N"}, true},
+		{"T | project A | extend B = 1", []string{"B", "A"}, true},
+		{"T | where A == 1", nil, false},
+		{"let x = T | project Q; T | project R", []string{"R"}, true},
+	}
+	for _, tt := range tests {
+		got, projected := projectedColumns(tt.query)
+		if !reflect.DeepEqual(got, tt.want) || projected != tt.projected {
+			t.Errorf("projectedColumns(%s) = %v, %v; want %v, %v", tt.query, got, projected, tt.want, tt.projected)
+		}
+	}
+}
+
+func TestEntityMappingsLimits(t *testing.T) {
+	var columns []string
+	for _, ec := range entityColumns {
+		columns = append(columns, ec.column)
+	}
+	c := &Conversion{}
+	mappings := c.entityMappings(columns)
+	if len(mappings) != maxEntityMappings || len(c.Warnings) == 0 {
+		t.Errorf("%d mappings with %d warnings, want %d and the columns left out reported",
+			len(mappings), len(c.Warnings), maxEntityMappings)
+	}
+	for _, m := range mappings {
+		if len(m.FieldMappings) > maxFieldMappings {
+			t.Errorf("%s maps %d columns", m.EntityType, len(m.FieldMappings))
+		}
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := map[string]time.Duration{"30m": 30 * time.Minute, "1H": time.Hour, " 7d ": 7 * 24 * time.Hour}
+	for s, want := range tests {
+		if got, ok := parseInterval(s); !ok || got != want {
+			t.Errorf("parseInterval(%q) = %s, %v; want %s", s, got, ok, want)
+		}
+	}
+	for _, s := range []string{"", "1w", "h", "1.5h"} {
+		if _, ok := parseInterval(s); ok {
+			t.Errorf("parseInterval(%q) accepted", s)
+		}
+	}
+	if got := formatISO(90 * time.Minute); got != "PT90M" {
+		t.Errorf("formatISO(90m) = %s, want PT90M", got)
+	}
+}
@@ -0,0 +1,261 @@
+package logicapp
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// FailureAlert is raised when the latest runs of a deployed playbook all
+// failed. It stays open, counting further failures, until a run succeeds.
+type FailureAlert struct {
+	ID                  string     `json:"id"`
+	DeploymentID        string     `json:"deploymentId"`
+	ClientID            string     `json:"clientId,omitempty"`
+	ClientName          string     `json:"clientName,omitempty"`
+	LogicAppName        string     `json:"logicAppName"`
+	TemplateName        string     `json:"templateName"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastRunName         string     `json:"lastRunName"`
+	LastError           string     `json:"lastError,omitempty"`
+	NotifyError         string     `json:"notifyError,omitempty"`
+	RaisedAt            time.Time  `json:"raisedAt"`
+	UpdatedAt           time.Time  `json:"updatedAt"`
+	ResolvedAt          *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// AlertHook is told when a playbook starts failing repeatedly, such as to
+// page someone or open a ticket. It is told once per alert.
+type AlertHook interface {
+	PlaybookFailing(ctx context.Context, alert *FailureAlert) error
+}
+
+// WebhookHook posts alerts as JSON to a URL
+type WebhookHook struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// NewWebhookHook creates a hook posting to url
+func NewWebhookHook(url string) *WebhookHook {
+	return &WebhookHook{URL: url, HTTPClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// PlaybookFailing implements AlertHook
+func (h *WebhookHook) PlaybookFailing(ctx context.Context, alert *FailureAlert) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"event": "logicapp.failing",
+		"alert": alert,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("alert webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// TicketHook opens a ticket for each alert
+type TicketHook struct {
+	db *sql.DB
+}
+
+// NewTicketHook creates a ticket hook
+func NewTicketHook(db *sql.DB) *TicketHook {
+	return &TicketHook{db: db}
+}
+
+// PlaybookFailing implements AlertHook
+func (h *TicketHook) PlaybookFailing(ctx context.Context, alert *FailureAlert) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Logic App %s (template %s) failed its last %d runs.\n", alert.LogicAppName,
+		alert.TemplateName, alert.ConsecutiveFailures)
+	if alert.LastError != "" {
+		fmt.Fprintf(&b, "\nLast error: %s\n", alert.LastError)
+	}
+	client := alert.ClientName
+	if client == "" {
+		client = alert.ClientID
+	}
+	fmt.Fprintf(&b, "\nClient: %s\nDeployment: %s\nLast run: %s\n", client, alert.DeploymentID, alert.LastRunName)
+
+	_, err := h.db.ExecContext(ctx, `
+		INSERT INTO tickets (title, description, agent_type, status, priority, tags)
+		VALUES ($1, $2, 'kql_hunting', 'open', 'high', $3)`,
+		fmt.Sprintf("Playbook failing: %s for %s", alert.LogicAppName, client), b.String(),
+		pq.Array([]string{"logic-app", "playbook-failure", alert.TemplateName}))
+	return err
+}
+
+const alertColumns = `
+	a.id, a.deployment_id, COALESCE(a.client_id::text, ''), COALESCE(c.name, ''), d.logic_app_name,
+	d.template_name, a.consecutive_failures, a.last_run_name, COALESCE(a.last_error, ''),
+	COALESCE(a.notify_error, ''), a.raised_at, a.updated_at, a.resolved_at`
+
+func scanAlert(row interface{ Scan(...interface{}) error }) (*FailureAlert, error) {
+	var a FailureAlert
+	var resolved sql.NullTime
+	if err := row.Scan(&a.ID, &a.DeploymentID, &a.ClientID, &a.ClientName, &a.LogicAppName, &a.TemplateName,
+		&a.ConsecutiveFailures, &a.LastRunName, &a.LastError, &a.NotifyError, &a.RaisedAt, &a.UpdatedAt,
+		&resolved); err != nil {
+		return nil, err
+	}
+	if resolved.Valid {
+		a.ResolvedAt = &resolved.Time
+	}
+	return &a, nil
+}
+
+// Alerts returns failure alerts, newest first: only open ones, or also
+// those resolved
+func (m *Monitor) Alerts(ctx context.Context, openOnly bool) ([]*FailureAlert, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT "+alertColumns+`
+		FROM deployment_mgmt.logic_app_alerts a
+		JOIN deployment_mgmt.logic_app_deployments d ON d.id = a.deployment_id
+		LEFT JOIN client_mgmt.clients c ON c.id = a.client_id
+		WHERE NOT $1 OR a.resolved_at IS NULL
+		ORDER BY a.raised_at DESC
+		LIMIT 200`, openOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	alerts := []*FailureAlert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// evaluate counts the failed runs a deployment finished last, in a row.
+// At the threshold an alert is raised and the hooks told; further failures
+// update it. The open alert is resolved once a run succeeds. It returns
+// an alert only when one was raised.
+func (m *Monitor) evaluate(ctx context.Context, t *monitored) (*FailureAlert, error) {
+	threshold := m.Threshold
+	if threshold <= 0 {
+		threshold = DefaultFailureThreshold
+	}
+	// Cancelled and skipped runs neither break nor extend a streak
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT run_name, status, COALESCE(error_code, ''), COALESCE(error_message, ''), failed_actions
+		FROM deployment_mgmt.logic_app_runs
+		WHERE deployment_id = $1 AND (status = $2 OR status = ANY($3))
+		ORDER BY start_time DESC
+		LIMIT 100`, t.ID, RunSucceeded, pq.Array(failedStatuses))
+	if err != nil {
+		return nil, err
+	}
+	var failures int
+	var last *Run
+	for rows.Next() {
+		var r Run
+		var actions []byte
+		if err := rows.Scan(&r.RunName, &r.Status, &r.ErrorCode, &r.ErrorMessage, &actions); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if r.Status == RunSucceeded {
+			break
+		}
+		if err := json.Unmarshal(actions, &r.FailedActions); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if last == nil {
+			last = &r
+		}
+		failures++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if failures == 0 {
+		_, err := m.db.ExecContext(ctx, `
+			UPDATE deployment_mgmt.logic_app_alerts SET resolved_at = NOW(), updated_at = NOW()
+			WHERE deployment_id = $1 AND resolved_at IS NULL`, t.ID)
+		return nil, err
+	}
+	if failures < threshold {
+		return nil, nil
+	}
+
+	reason := last.Reason()
+	res, err := m.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.logic_app_alerts
+		SET consecutive_failures = $2, last_run_name = $3, last_error = $4, updated_at = NOW()
+		WHERE deployment_id = $1 AND resolved_at IS NULL`, t.ID, failures, last.RunName, nullString(reason))
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return nil, err
+	}
+
+	alert := &FailureAlert{
+		DeploymentID:        t.ID,
+		ClientID:            t.ClientID,
+		ClientName:          t.ClientName,
+		LogicAppName:        t.LogicAppName,
+		TemplateName:        t.TemplateName,
+		ConsecutiveFailures: failures,
+		LastRunName:         last.RunName,
+		LastError:           reason,
+	}
+	err = m.db.QueryRowContext(ctx, `
+		INSERT INTO deployment_mgmt.logic_app_alerts
+			(deployment_id, client_id, consecutive_failures, last_run_name, last_error)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5)
+		RETURNING id, raised_at, updated_at`,
+		t.ID, t.ClientID, failures, last.RunName, nullString(reason)).Scan(&alert.ID, &alert.RaisedAt, &alert.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	m.notify(ctx, alert)
+	return alert, nil
+}
+
+// notify tells the hooks about a new alert. Hook failures are recorded on
+// the alert rather than failing the poll.
+func (m *Monitor) notify(ctx context.Context, alert *FailureAlert) {
+	var errs []string
+	for _, hook := range m.hooks {
+		if err := hook.PlaybookFailing(ctx, alert); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) == 0 {
+		return
+	}
+	alert.NotifyError = strings.Join(errs, "; ")
+	if _, err := m.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.logic_app_alerts SET notify_error = $2 WHERE id = $1`,
+		alert.ID, alert.NotifyError); err != nil {
+		log.Printf("Error recording alert notification failure for %s: %v", alert.DeploymentID, err)
+	}
+}
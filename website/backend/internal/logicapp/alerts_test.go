@@ -0,0 +1,112 @@
+package logicapp_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/logicapp"
+)
+
+// recordingHook records the alerts it is told about and fails with err
+type recordingHook struct {
+	alerts []*logicapp.FailureAlert
+	err    error
+}
+
+func (h *recordingHook) PlaybookFailing(ctx context.Context, alert *logicapp.FailureAlert) error {
+	h.alerts = append(h.alerts, alert)
+	return h.err
+}
+
+func TestMonitorRaisesOneAlertPerStreak(t *testing.T) {
+	server := newServer(0)
+	defer server.Close()
+	d, db, clientID := newDeployer(t, server)
+	ctx := context.Background()
+	dep := deployRespond(t, d, clientID)
+	hook := &recordingHook{}
+	m := logicapp.NewMonitor(db, server.Source(), hook)
+	m.Threshold = 2
+
+	start := time.Now().Add(-6 * time.Hour).Truncate(time.Second)
+	poll := func() *logicapp.FailureAlert {
+		t.Helper()
+		result, err := m.Poll(ctx, dep.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Alert
+	}
+	open := func() []*logicapp.FailureAlert {
+		t.Helper()
+		alerts, err := m.Alerts(ctx, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return alerts
+	}
+
+	// One failure is below the threshold; cancelled runs do not break a
+	// streak
+	addRun(server, dep, "run-1", logicapp.RunFailed, start, "Isolate")
+	addRun(server, dep, "run-2", logicapp.RunCancelled, start.Add(time.Hour))
+	if alert := poll(); alert != nil || len(open()) != 0 || len(hook.alerts) != 0 {
+		t.Fatalf("alert raised after one failure")
+	}
+
+	// The second failure in a row raises the alert and tells the hook
+	addRun(server, dep, "run-3", logicapp.RunTimedOut, start.Add(2*time.Hour))
+	alert := poll()
+	if alert == nil || alert.ConsecutiveFailures != 2 || alert.LastRunName != "run-3" ||
+		alert.ClientName != "Contoso" || alert.LogicAppName != "respond" {
+		t.Fatalf("alert = %+v, want one for the two failures ending with run-3", alert)
+	}
+	if len(hook.alerts) != 1 || hook.alerts[0].ID != alert.ID {
+		t.Fatalf("hook told of %d alerts, want the one raised", len(hook.alerts))
+	}
+
+	// Further failures update the open alert without raising another
+	addRun(server, dep, "run-4", logicapp.RunFailed, start.Add(3*time.Hour), "Isolate")
+	if alert := poll(); alert != nil {
+		t.Errorf("poll raised %+v, want the open alert updated", alert)
+	}
+	alerts := open()
+	if len(alerts) != 1 || alerts[0].ID != alert.ID || alerts[0].ConsecutiveFailures != 3 ||
+		alerts[0].LastRunName != "run-4" || alerts[0].LastError != "Isolate: Isolate failed" {
+		t.Fatalf("open alerts = %+v, want the one alert at 3 failures", alerts)
+	}
+	if len(hook.alerts) != 1 {
+		t.Errorf("hook told of %d alerts, want it told once", len(hook.alerts))
+	}
+
+	// A success resolves it
+	addRun(server, dep, "run-5", logicapp.RunSucceeded, start.Add(4*time.Hour))
+	if alert := poll(); alert != nil || len(open()) != 0 {
+		t.Fatal("alert still open after a successful run")
+	}
+	all, err := m.Alerts(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].ResolvedAt == nil {
+		t.Fatalf("alerts = %+v, want the one resolved", all)
+	}
+
+	// A new streak raises a new alert; a hook failing is recorded on it
+	hook.err = errors.New("pager unavailable")
+	addRun(server, dep, "run-6", logicapp.RunFailed, start.Add(5*time.Hour), "Isolate")
+	addRun(server, dep, "run-7", logicapp.RunFailed, start.Add(5*time.Hour+time.Minute), "Isolate")
+	second := poll()
+	if second == nil || second.ID == alert.ID || second.ConsecutiveFailures != 2 {
+		t.Fatalf("alert = %+v, want a new one for the new streak", second)
+	}
+	alerts = open()
+	if len(alerts) != 1 || alerts[0].ID != second.ID || alerts[0].NotifyError != "pager unavailable" {
+		t.Errorf("open alerts = %+v, want the new one with the hook's error", alerts)
+	}
+	if len(hook.alerts) != 2 {
+		t.Errorf("hook told of %d alerts, want 2", len(hook.alerts))
+	}
+}
@@ -0,0 +1,140 @@
+package logicapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// Lifecycle actions recorded in the audit trail of a deployment
+const (
+	ActionDeploy  = "deploy"
+	ActionEnable  = "enable"
+	ActionDisable = "disable"
+	ActionUpgrade = "upgrade"
+	ActionDelete  = "delete"
+	// ActionGrantPermissions grants app roles to a workflow's identity
+	ActionGrantPermissions = "grant_permissions"
+)
+
+// Outcomes of audited actions
+const (
+	OutcomeSucceeded = "Succeeded"
+	OutcomeFailed    = "Failed"
+)
+
+// auditType is the deployment_type of Logic App audit entries
+const auditType = "logic_app"
+
+// Actor is who asked for a lifecycle action
+type Actor struct {
+	// Name is the user or service that asked
+	Name string
+	// Claimed marks a Name the caller gave without it being
+	// authenticated. The audit trail records it as claimed.
+	Claimed bool
+	// Address is the IP address the request came from
+	Address   string
+	UserAgent string
+}
+
+// AuditEntry is a lifecycle action recorded against a deployment
+type AuditEntry struct {
+	ID        string          `json:"id"`
+	Action    string          `json:"action"`
+	Actor     string          `json:"actor,omitempty"`
+	Outcome   string          `json:"outcome"`
+	Details   json.RawMessage `json:"details,omitempty"`
+	IPAddress string          `json:"ipAddress,omitempty"`
+	UserAgent string          `json:"userAgent,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// Audit returns the actions recorded against a deployment, newest first
+func (d *Deployer) Audit(ctx context.Context, id string) ([]*AuditEntry, error) {
+	dep, err := d.Deployment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, action, COALESCE(actor, ''), COALESCE(outcome, ''), COALESCE(details, '{}'),
+			COALESCE(host(ip_address), ''), COALESCE(user_agent, ''), created_at
+		FROM deployment_mgmt.deployment_audit
+		WHERE deployment_type = $1 AND deployment_id = $2
+		ORDER BY created_at DESC
+		LIMIT 200`, auditType, dep.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.Action, &e.Actor, &e.Outcome, &details, &e.IPAddress, &e.UserAgent,
+			&e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Details = details
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
+
+// audit records the outcome of an action on a deployment. The action has
+// happened by then, so failing to record it is logged rather than
+// returned.
+func (d *Deployer) audit(ctx context.Context, dep *Deployment, action string, actor Actor,
+	details map[string]interface{}, actionErr error) {
+	outcome := OutcomeSucceeded
+	all := map[string]interface{}{
+		"logicAppName":    dep.LogicAppName,
+		"templateName":    dep.TemplateName,
+		"templateVersion": dep.TemplateVersion,
+		"status":          dep.Status,
+	}
+	for k, v := range details {
+		all[k] = v
+	}
+	if actor.Claimed && actor.Name != "" {
+		all["actorClaimed"] = true
+	}
+	if actionErr != nil {
+		outcome = OutcomeFailed
+		all["error"] = actionErr.Error()
+	}
+	data, err := json.Marshal(all)
+	if err != nil {
+		log.Printf("Auditing %s of Logic App deployment %s failed: %v", action, dep.ID, err)
+		return
+	}
+
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	_, err = d.db.ExecContext(saveCtx, `
+		INSERT INTO deployment_mgmt.deployment_audit
+			(deployment_type, deployment_id, client_id, action, actor, outcome, details, ip_address, user_agent)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, $6, $7, NULLIF($8, '')::inet, $9)`,
+		auditType, dep.ID, dep.ClientID, action, nullString(actor.Name), outcome, data, actor.Address,
+		nullString(actor.UserAgent))
+	if err != nil {
+		log.Printf("Auditing %s of Logic App deployment %s failed: %v", action, dep.ID, err)
+	}
+}
+
+// auditFailure records an action that failed before it could run, such
+// as one the deployment's status did not allow. Actions on deployments
+// that do not exist are not recorded.
+func (d *Deployer) auditFailure(ctx context.Context, id, action string, actor Actor, actionErr error) {
+	if errors.Is(actionErr, ErrNotFound) {
+		return
+	}
+	dep, err := d.Deployment(ctx, id)
+	if err != nil {
+		return
+	}
+	d.audit(ctx, dep, action, actor, nil, actionErr)
+}
@@ -0,0 +1,448 @@
+package logicapp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TemplateType is the template_type of Logic App templates in
+// deployment_mgmt.deployment_templates
+const TemplateType = "logic_app"
+
+// firstVersion is the version a template is first indexed with
+const firstVersion = "1.0"
+
+// readmeEntry is a README line describing a template file, such as
+// "- `http-mde-isolatemachine.json` - Isolate machines via Microsoft Defender"
+var readmeEntry = regexp.MustCompile("^\\s*[-*]\\s*`([^`*]+)\\.json`\\s*[-–:]\\s*(.+)$")
+
+// words whose display spelling is not their title case
+var displayWords = map[string]string{
+	"ad": "AD", "api": "API", "av": "AV", "cs": "CS", "entraid": "Entra ID", "exo": "EXO",
+	"fedramp": "FedRAMP", "graphapi": "Graph API", "ip": "IP", "ipv4": "IPv4", "kql": "KQL",
+	"mde": "MDE", "ms": "MS", "soc": "SOC", "ti": "TI", "url": "URL", "virustotal": "VirusTotal",
+	"adaptivecard": "Adaptive Card", "namedlocation": "Named Location", "securescore": "Secure Score",
+	"connectionfilter": "Connection Filter", "passreset": "Password Reset", "sessionrevoke": "Session Revoke",
+	"emailforwarding": "Email Forwarding", "accountdisabled": "Account Disabled", "indiccators": "Indicators",
+	"isolatemachine": "Isolate Machine", "alerttrigger": "Alert Trigger", "logicapp": "Logic App", "30days": "30 Days",
+}
+
+// CatalogTemplate is a version of a template in the catalog
+type CatalogTemplate struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+	Description string    `json:"description"`
+	Version     string    `json:"version"`
+	Tags        []string  `json:"tags"`
+	Active      bool      `json:"active"`
+	ContentHash string    `json:"contentHash"`
+	Schema      *Schema   `json:"schema,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	// Content is the template as indexed
+	Content []byte `json:"-"`
+}
+
+// Template parses the content of a catalog template
+func (t *CatalogTemplate) Template() (*Template, error) {
+	return ParseTemplate(t.Content)
+}
+
+// SyncResult is the outcome of indexing the template directory
+type SyncResult struct {
+	// Added are templates indexed for the first time and Updated those
+	// whose content changed, which were given a new version
+	Added     []string `json:"added"`
+	Updated   []string `json:"updated"`
+	Unchanged int      `json:"unchanged"`
+	// Retired are templates whose file is gone. Their versions are kept
+	// for the deployments made from them but no longer offered.
+	Retired []string    `json:"retired"`
+	Errors  []SyncError `json:"errors"`
+}
+
+// SyncError is a file of the template directory that is not a template
+type SyncError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// Catalog indexes the templates of a directory into
+// deployment_mgmt.deployment_templates. Each change to a template's file
+// is a new version of it; deployments are made from the active version.
+type Catalog struct {
+	db  *sql.DB
+	dir string
+}
+
+// NewCatalog creates a catalog of the templates of a directory
+func NewCatalog(db *sql.DB, dir string) *Catalog {
+	return &Catalog{db: db, dir: dir}
+}
+
+// Sync indexes the templates of the directory. Files that are not
+// workflow templates are reported and skipped.
+func (c *Catalog) Sync(ctx context.Context) (*SyncResult, error) {
+	files, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		if _, err := os.Stat(c.dir); err != nil {
+			return nil, fmt.Errorf("reading templates: %w", err)
+		}
+	}
+	sort.Strings(files)
+	descriptions := readDescriptions(filepath.Join(c.dir, "README.md"))
+
+	result := &SyncResult{Added: []string{}, Updated: []string{}, Retired: []string{}, Errors: []SyncError{}}
+	names := []string{}
+	for _, file := range files {
+		base := filepath.Base(file)
+		name := strings.TrimSuffix(base, ".json")
+		if !templateName.MatchString(name) {
+			result.Errors = append(result.Errors, SyncError{File: base, Error: "the file name is not a template name"})
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+		t, err := ParseTemplate(data)
+		if err != nil {
+			result.Errors = append(result.Errors, SyncError{File: base, Error: err.Error()})
+			continue
+		}
+		schema, err := Analyze(t)
+		if err != nil {
+			result.Errors = append(result.Errors, SyncError{File: base, Error: fmt.Sprintf("%v: %v", ErrInvalidTemplate, err)})
+			continue
+		}
+		names = append(names, name)
+
+		entry := &CatalogTemplate{
+			Name:        name,
+			DisplayName: displayName(name),
+			Description: descriptions[name],
+			Tags:        templateTags(schema),
+			Schema:      schema,
+			Content:     data,
+		}
+		if entry.Description == "" {
+			entry.Description = describe(schema)
+		}
+		added, changed, err := c.index(ctx, entry)
+		if err != nil {
+			return nil, fmt.Errorf("indexing %s: %w", base, err)
+		}
+		switch {
+		case added:
+			result.Added = append(result.Added, name)
+		case changed:
+			result.Updated = append(result.Updated, name)
+		default:
+			result.Unchanged++
+		}
+	}
+
+	rows, err := c.db.QueryContext(ctx, `
+		UPDATE deployment_mgmt.deployment_templates
+		SET is_active = false, updated_at = NOW()
+		WHERE template_type = $1 AND is_active AND NOT (name = ANY($2))
+		RETURNING name`, TemplateType, pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		result.Retired = append(result.Retired, name)
+	}
+	return result, rows.Err()
+}
+
+// index saves a template as the active version of its name. Content that
+// is already the latest version only refreshes what was worked out of it.
+func (c *Catalog) index(ctx context.Context, t *CatalogTemplate) (added, changed bool, err error) {
+	sum := sha256.Sum256(t.Content)
+	t.ContentHash = hex.EncodeToString(sum[:])
+	schema, err := json.Marshal(t.Schema)
+	if err != nil {
+		return false, false, err
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, false, err
+	}
+	defer tx.Rollback()
+
+	// Serialize syncs of a template so two of them cannot both add the
+	// same version
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, TemplateType+"/"+t.Name); err != nil {
+		return false, false, err
+	}
+	var id, version string
+	var hash sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, version, content_hash FROM deployment_mgmt.deployment_templates
+		WHERE template_type = $1 AND name = $2
+		ORDER BY created_at DESC, version DESC
+		LIMIT 1`, TemplateType, t.Name).Scan(&id, &version, &hash)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		added, version = true, firstVersion
+	case err != nil:
+		return false, false, err
+	case hash.String == t.ContentHash:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE deployment_mgmt.deployment_templates
+			SET display_name = $2, description = $3, parameters = $4, tags = $5,
+				is_active = true, updated_at = NOW()
+			WHERE id = $1`, id, t.DisplayName, t.Description, schema, pq.Array(t.Tags))
+		if err != nil {
+			return false, false, err
+		}
+		return false, false, tx.Commit()
+	default:
+		changed, version = true, nextVersion(version)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE deployment_mgmt.deployment_templates
+		SET is_active = false, updated_at = NOW()
+		WHERE template_type = $1 AND name = $2 AND is_active`, TemplateType, t.Name); err != nil {
+		return false, false, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO deployment_mgmt.deployment_templates
+			(template_type, name, display_name, description, version, template_content,
+			 parameters, tags, is_active, content_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, true, $9)`,
+		TemplateType, t.Name, t.DisplayName, t.Description, version, string(t.Content),
+		schema, pq.Array(t.Tags), t.ContentHash)
+	if err != nil {
+		return false, false, err
+	}
+	return added, changed, tx.Commit()
+}
+
+const catalogColumns = `
+	id, name, COALESCE(display_name, name), COALESCE(description, ''), version,
+	COALESCE(tags, '{}'), is_active, COALESCE(content_hash, ''), COALESCE(parameters, 'null'),
+	template_content, created_at, updated_at`
+
+func scanCatalogTemplate(row interface{ Scan(...interface{}) error }) (*CatalogTemplate, error) {
+	var t CatalogTemplate
+	var schema []byte
+	var content string
+	var active sql.NullBool
+	var createdAt, updatedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.Name, &t.DisplayName, &t.Description, &t.Version, pq.Array(&t.Tags),
+		&active, &t.ContentHash, &schema, &content, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(schema, &t.Schema); err != nil {
+		return nil, err
+	}
+	t.Active, t.Content = active.Bool, []byte(content)
+	t.CreatedAt, t.UpdatedAt = createdAt.Time, updatedAt.Time
+	return &t, nil
+}
+
+// Templates returns the active version of every template
+func (c *Catalog) Templates(ctx context.Context) ([]*CatalogTemplate, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT "+catalogColumns+`
+		FROM deployment_mgmt.deployment_templates
+		WHERE template_type = $1 AND is_active
+		ORDER BY name`, TemplateType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	templates := []*CatalogTemplate{}
+	for rows.Next() {
+		t, err := scanCatalogTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// Template returns a version of a template, or its active version when
+// version is empty
+func (c *Catalog) Template(ctx context.Context, name, version string) (*CatalogTemplate, error) {
+	query := "SELECT " + catalogColumns + `
+		FROM deployment_mgmt.deployment_templates
+		WHERE template_type = $1 AND name = $2 AND is_active`
+	args := []interface{}{TemplateType, name}
+	if version != "" {
+		query = "SELECT " + catalogColumns + `
+			FROM deployment_mgmt.deployment_templates
+			WHERE template_type = $1 AND name = $2 AND version = $3`
+		args = append(args, version)
+	}
+	t, err := scanCatalogTemplate(c.db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		if version != "" {
+			return nil, fmt.Errorf("%w: %q version %s", ErrTemplateNotFound, name, version)
+		}
+		return nil, fmt.Errorf("%w: %q", ErrTemplateNotFound, name)
+	}
+	return t, err
+}
+
+// Versions returns the versions of a template, latest first
+func (c *Catalog) Versions(ctx context.Context, name string) ([]*CatalogTemplate, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT "+catalogColumns+`
+		FROM deployment_mgmt.deployment_templates
+		WHERE template_type = $1 AND name = $2
+		ORDER BY created_at DESC, version DESC`, TemplateType, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := []*CatalogTemplate{}
+	for rows.Next() {
+		t, err := scanCatalogTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrTemplateNotFound, name)
+	}
+	return versions, nil
+}
+
+// nextVersion returns the version after a version: the minor version of
+// major.minor versions is raised, other versions get a .1 added
+func nextVersion(version string) string {
+	if i := strings.LastIndex(version, "."); i >= 0 {
+		if minor, err := strconv.Atoi(version[i+1:]); err == nil {
+			return version[:i+1] + strconv.Itoa(minor+1)
+		}
+	}
+	return version + ".1"
+}
+
+// displayName spells out a template file name, such as
+// "http-reset-user-password-graphapi" as "Reset User Password Graph API"
+func displayName(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' || r == ' ' })
+	if len(words) > 1 && (strings.EqualFold(words[0], "http") || strings.EqualFold(words[0], "https")) {
+		words = words[1:]
+	}
+	for i, w := range words {
+		switch {
+		case displayWords[strings.ToLower(w)] != "":
+			words[i] = displayWords[strings.ToLower(w)]
+		default:
+			// Words written in mixed case, such as ADAccountDisabled,
+			// keep their case
+			words[i] = strings.ToUpper(w[:1]) + w[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// readDescriptions reads the descriptions of templates the README of the
+// template directory lists
+func readDescriptions(path string) map[string]string {
+	descriptions := make(map[string]string)
+	f, err := os.Open(path)
+	if err != nil {
+		return descriptions
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m := readmeEntry.FindStringSubmatch(scanner.Text()); m != nil {
+			descriptions[m[1]] = strings.TrimSpace(m[2])
+		}
+	}
+	return descriptions
+}
+
+// describe says what starts a workflow, for templates the README does
+// not describe
+func describe(s *Schema) string {
+	if len(s.Triggers) == 0 {
+		return ""
+	}
+	t := s.Triggers[0]
+	switch {
+	case t.Type == "Request":
+		return "Runs when called over HTTP"
+	case t.Type == "Recurrence":
+		return "Runs on a schedule"
+	case strings.Contains(t.Path, "/incident-creation"):
+		return "Runs on Microsoft Sentinel incidents"
+	case strings.HasPrefix(t.Path, "/entity/"):
+		return "Runs on Microsoft Sentinel entities"
+	case t.Path == "/subscribe":
+		return "Runs on Microsoft Sentinel alerts"
+	}
+	return "Runs on " + strings.ToLower(t.Type) + " trigger " + strings.ReplaceAll(t.Name, "_", " ")
+}
+
+// templateTags tags a template with the kind of its trigger and the APIs
+// it uses
+func templateTags(s *Schema) []string {
+	var tags []string
+	for _, t := range s.Triggers {
+		tag := strings.ToLower(t.Type)
+		switch {
+		case strings.Contains(t.Path, "/incident-creation"):
+			tag = "sentinel-incident"
+		case strings.HasPrefix(t.Path, "/entity/"):
+			tag = "sentinel-entity"
+		case t.Path == "/subscribe":
+			tag = "sentinel-alert"
+		}
+		tags = appendTag(tags, tag)
+	}
+	for _, c := range s.Connections {
+		tags = appendTag(tags, c.API)
+	}
+	for _, p := range s.Permissions {
+		tags = appendTag(tags, strings.ToLower(strings.ReplaceAll(p.API, " ", "-")))
+	}
+	return tags
+}
+
+func appendTag(tags []string, tag string) []string {
+	for _, t := range tags {
+		if t == tag {
+			return tags
+		}
+	}
+	return append(tags, tag)
+}
@@ -0,0 +1,114 @@
+package logicapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/arm"
+)
+
+// connectionAPIVersion is the Microsoft.Web API version API connections
+// are read with
+const connectionAPIVersion = "2016-06-01"
+
+// ErrMissingConnection is returned for templates using API connections
+// that do not exist in the resource group deployed to
+var ErrMissingConnection = errors.New("API connection not found")
+
+// bindConnections points the $connections of a template at the managed
+// APIs of a location and the API connections of a resource group.
+// Templates are exported with the IDs of the subscription they were built
+// in, or placeholders; each connection is expected in the workflow's
+// resource group under its connectionName, or its name in $connections.
+// It returns the bound template and the resource IDs of the connections.
+func bindConnections(t *Template, subscriptionID, resourceGroup, location string) (*Template, []string, error) {
+	schema, err := Analyze(t)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(schema.Connections) == 0 {
+		return t, nil, nil
+	}
+
+	params := make(map[string]json.RawMessage)
+	if len(t.Parameters) > 0 {
+		if err := json.Unmarshal(t.Parameters, &params); err != nil {
+			return nil, nil, fmt.Errorf("%w: parameters must be an object", ErrInvalidTemplate)
+		}
+	}
+	var connections struct {
+		Value map[string]map[string]json.RawMessage `json:"value"`
+	}
+	if p, ok := params["$connections"]; ok {
+		if err := json.Unmarshal(p, &connections); err != nil {
+			return nil, nil, fmt.Errorf("%w: $connections must be an object of connections", ErrInvalidTemplate)
+		}
+	}
+	if connections.Value == nil {
+		connections.Value = make(map[string]map[string]json.RawMessage)
+	}
+
+	// Managed APIs are named by the location's short form, as in westeurope
+	location = strings.ToLower(strings.ReplaceAll(location, " ", ""))
+	var ids []string
+	for _, c := range schema.Connections {
+		name := c.ConnectionName
+		if name == "" {
+			name = c.Name
+		}
+		entry := connections.Value[c.Name]
+		if entry == nil {
+			entry = make(map[string]json.RawMessage)
+		}
+		id := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Web/connections/%s",
+			subscriptionID, resourceGroup, name)
+		values := map[string]string{
+			"id":             fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Web/locations/%s/managedApis/%s", subscriptionID, location, c.API),
+			"connectionId":   id,
+			"connectionName": name,
+		}
+		for key, value := range values {
+			if entry[key], err = json.Marshal(value); err != nil {
+				return nil, nil, err
+			}
+		}
+		connections.Value[c.Name] = entry
+		ids = append(ids, id)
+	}
+
+	if params["$connections"], err = json.Marshal(connections); err != nil {
+		return nil, nil, err
+	}
+	out := &Template{Definition: t.Definition}
+	if out.Parameters, err = json.Marshal(params); err != nil {
+		return nil, nil, err
+	}
+	return out, ids, nil
+}
+
+// checkConnections fails with ErrMissingConnection, naming them, when any
+// of the API connections a workflow is bound to does not exist. The
+// connections have to be created and authorized in the portal, which
+// ClarityXDR cannot do on a client's behalf.
+func checkConnections(ctx context.Context, manager arm.ResourceManager, resourceGroup string, ids []string) error {
+	var missing []string
+	for _, id := range ids {
+		name := id[strings.LastIndex(id, "/")+1:]
+		err := manager.Get(ctx, id, connectionAPIVersion, nil)
+		if arm.IsNotFound(err) {
+			missing = append(missing, name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading API connection %s: %w", name, err)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: create and authorize %s in resource group %s before deploying",
+			ErrMissingConnection, strings.Join(missing, ", "), resourceGroup)
+	}
+	return nil
+}
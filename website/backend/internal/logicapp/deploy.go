@@ -0,0 +1,606 @@
+package logicapp
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/arm"
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/graph"
+)
+
+// Deployment statuses. A deployment is only Success once Resource Manager
+// has confirmed the workflow is provisioned; a Disabled one is provisioned
+// with its triggers turned off.
+const (
+	StatusPending   = "Pending"
+	StatusDeploying = "Deploying"
+	StatusSuccess   = "Success"
+	StatusFailed    = "Failed"
+	StatusDisabled  = "Disabled"
+	StatusDeleted   = "Deleted"
+)
+
+// deployTimeout bounds a deployment running in the background
+const deployTimeout = 30 * time.Minute
+
+// Tags put on deployed workflows so they can be traced back
+const (
+	TagTemplate        = "clarityxdr-template"
+	TagTemplateVersion = "clarityxdr-template-version"
+	TagDeployment      = "clarityxdr-deployment"
+)
+
+var (
+	// ErrNotFound is returned for unknown deployments
+	ErrNotFound = errors.New("deployment not found")
+	// ErrInvalidRequest is returned for deployment requests with missing or
+	// malformed fields
+	ErrInvalidRequest = errors.New("invalid deployment request")
+	// ErrDeploymentBusy is returned when a deployment is already running
+	ErrDeploymentBusy = errors.New("deployment is already running")
+	// ErrInvalidState is returned for lifecycle actions a deployment's
+	// status does not allow, such as enabling a deleted workflow
+	ErrInvalidState = errors.New("action not allowed in the deployment's status")
+)
+
+var (
+	subscriptionID = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	resourceGroup  = regexp.MustCompile(`^[-\w.()]{1,90}$`)
+	workflowName   = regexp.MustCompile(`^[-\w.()]{1,80}$`)
+)
+
+// Request asks for a template to be deployed as a workflow
+type Request struct {
+	ClientID       string `json:"clientId"`
+	SubscriptionID string `json:"subscriptionId"`
+	ResourceGroup  string `json:"resourceGroup"`
+	LogicAppName   string `json:"logicAppName"`
+	TemplateName   string `json:"templateName"`
+	// Location defaults to the location of the resource group
+	Location string `json:"location,omitempty"`
+	// Parameters are values of the parameters the template declares
+	Parameters map[string]json.RawMessage `json:"parameters,omitempty"`
+	// GrantPermissions grants the workflow's managed identity the app
+	// roles its template needs once it is deployed
+	GrantPermissions bool `json:"grantPermissions,omitempty"`
+}
+
+// Validate checks the fields of a request against Azure's naming rules
+func (r *Request) Validate() error {
+	switch {
+	case r.ClientID == "":
+		return fmt.Errorf("%w: clientId is required", ErrInvalidRequest)
+	case r.TemplateName == "":
+		return fmt.Errorf("%w: templateName is required", ErrInvalidRequest)
+	case !subscriptionID.MatchString(r.SubscriptionID):
+		return fmt.Errorf("%w: subscriptionId must be a subscription GUID", ErrInvalidRequest)
+	case !resourceGroup.MatchString(r.ResourceGroup) || strings.HasSuffix(r.ResourceGroup, "."):
+		return fmt.Errorf("%w: resourceGroup is not a valid resource group name", ErrInvalidRequest)
+	case !workflowName.MatchString(r.LogicAppName):
+		return fmt.Errorf("%w: logicAppName must be 1-80 letters, digits, hyphens, underscores, periods or parentheses", ErrInvalidRequest)
+	}
+	return nil
+}
+
+// Deployment is a row of deployment_mgmt.logic_app_deployments
+type Deployment struct {
+	ID             string `json:"id"`
+	ClientID       string `json:"clientId"`
+	LogicAppName   string `json:"logicAppName"`
+	SubscriptionID string `json:"subscriptionId"`
+	ResourceGroup  string `json:"resourceGroup"`
+	TemplateName   string `json:"templateName"`
+	// TemplateVersion is the catalog version of the template deployed
+	TemplateVersion string `json:"templateVersion,omitempty"`
+	Status          string `json:"status"`
+	// State is the state the workflow is deployed in, Enabled or Disabled
+	State          string `json:"state,omitempty"`
+	Location       string `json:"location,omitempty"`
+	ResourceID     string `json:"resourceId,omitempty"`
+	AccessEndpoint string `json:"accessEndpoint,omitempty"`
+	// PrincipalID is the object ID of the workflow's managed identity
+	PrincipalID      string `json:"principalId,omitempty"`
+	GrantPermissions bool   `json:"grantPermissions,omitempty"`
+	Error            string `json:"error,omitempty"`
+	// Parameters are the values the deployment was requested with. Values
+	// of secure parameters are masked.
+	Parameters map[string]json.RawMessage `json:"parameters,omitempty"`
+	DeployedAt *time.Time                 `json:"deployedAt,omitempty"`
+	CreatedAt  time.Time                  `json:"createdAt"`
+	UpdatedAt  time.Time                  `json:"updatedAt"`
+
+	// secureParameters are the encrypted values of secure parameters
+	secureParameters []byte
+}
+
+// metadata is what a deployment records of the deployed workflow
+type metadata struct {
+	TemplateVersion string `json:"templateVersion,omitempty"`
+	State           string `json:"state,omitempty"`
+	Location        string `json:"location,omitempty"`
+	ResourceID      string `json:"resourceId,omitempty"`
+	AccessEndpoint  string `json:"accessEndpoint,omitempty"`
+	PrincipalID     string `json:"principalId,omitempty"`
+	// GrantPermissions is whether to grant app roles after deploying
+	GrantPermissions bool `json:"grantPermissions,omitempty"`
+}
+
+// Deployer deploys catalog templates into client subscriptions with each
+// client's app registration
+type Deployer struct {
+	db       *sql.DB
+	managers arm.Source
+	catalog  *Catalog
+	secrets  *azure.SecretCipher
+
+	// LicenseEndpoint is given to templates declaring a LicenseAPIEndpoint
+	// parameter, when set
+	LicenseEndpoint string
+	// Directories grant the managed identities of workflows the app roles
+	// their templates need. Without them permissions can only be planned.
+	Directories graph.Source
+}
+
+// NewDeployer creates a deployer of the templates of a catalog. The values
+// of secure parameters are stored encrypted with secrets.
+func NewDeployer(db *sql.DB, managers arm.Source, catalog *Catalog, secrets *azure.SecretCipher) *Deployer {
+	return &Deployer{db: db, managers: managers, catalog: catalog, secrets: secrets}
+}
+
+// Create validates a request and records a pending deployment of the
+// active version of its template
+func (d *Deployer) Create(ctx context.Context, req Request) (*Deployment, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	ct, err := d.catalog.Template(ctx, req.TemplateName, "")
+	if err != nil {
+		return nil, err
+	}
+	t, err := ct.Template()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.render(ctx, req.ClientID, t, req.Parameters); err != nil {
+		return nil, err
+	}
+	plain, secure, err := d.storedParameters(t, req.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := json.Marshal(metadata{TemplateVersion: ct.Version, Location: req.Location,
+		GrantPermissions: req.GrantPermissions})
+	if err != nil {
+		return nil, err
+	}
+	dep := &Deployment{
+		ClientID:         req.ClientID,
+		LogicAppName:     req.LogicAppName,
+		SubscriptionID:   req.SubscriptionID,
+		ResourceGroup:    req.ResourceGroup,
+		TemplateName:     req.TemplateName,
+		TemplateVersion:  ct.Version,
+		Status:           StatusPending,
+		Location:         req.Location,
+		GrantPermissions: req.GrantPermissions,
+		secureParameters: secure,
+	}
+	if plain != nil {
+		if err := json.Unmarshal(plain, &dep.Parameters); err != nil {
+			return nil, err
+		}
+	}
+	err = d.db.QueryRowContext(ctx, `
+		INSERT INTO deployment_mgmt.logic_app_deployments
+			(client_id, logic_app_name, subscription_id, resource_group, template_name, status, metadata,
+			 parameters, secure_parameters, deployed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULL)
+		RETURNING id, created_at, updated_at`,
+		req.ClientID, req.LogicAppName, req.SubscriptionID, req.ResourceGroup, req.TemplateName,
+		dep.Status, meta, plain, secure).Scan(&dep.ID, &dep.CreatedAt, &dep.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return dep, nil
+}
+
+// Preview renders the template a request would deploy without recording
+// a deployment. A version other than the active one can be previewed.
+// Values of secure parameters are masked.
+func (d *Deployer) Preview(ctx context.Context, req Request, version string) (*Template, *CatalogTemplate, error) {
+	if req.TemplateName == "" {
+		return nil, nil, fmt.Errorf("%w: templateName is required", ErrInvalidRequest)
+	}
+	ct, err := d.catalog.Template(ctx, req.TemplateName, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := ct.Template()
+	if err != nil {
+		return nil, nil, err
+	}
+	rendered, err := d.render(ctx, req.ClientID, t, req.Parameters)
+	if err != nil {
+		return nil, nil, err
+	}
+	masked, err := Mask(rendered)
+	if err != nil {
+		return nil, nil, err
+	}
+	return masked, ct, nil
+}
+
+// Parameters ClarityXDR sets when a template declares them
+const (
+	ParamClientID        = "ClientID"
+	ParamLicenseGUID     = "LicenseGUID"
+	ParamLicenseEndpoint = "LicenseAPIEndpoint"
+)
+
+// render renders a template for a client with values and the parameters
+// ClarityXDR sets, which cannot be given values: the client a workflow
+// runs for, the key of its license and where licenses are checked.
+// Without a client only the license endpoint is set.
+func (d *Deployer) render(ctx context.Context, clientID string, t *Template, values map[string]json.RawMessage) (*Template, error) {
+	_, declared, err := declarations(t)
+	if err != nil {
+		return nil, err
+	}
+	system := make(map[string]string)
+	var issues []ParameterIssue
+	if _, ok := declared[ParamLicenseEndpoint]; ok && d.LicenseEndpoint != "" {
+		system[ParamLicenseEndpoint] = d.LicenseEndpoint
+	}
+	if _, ok := declared[ParamClientID]; ok && clientID != "" {
+		system[ParamClientID] = clientID
+	}
+	if _, ok := declared[ParamLicenseGUID]; ok && clientID != "" {
+		key, err := d.licenseKey(ctx, clientID)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			issues = append(issues, ParameterIssue{ParamLicenseGUID, "the client has no active license"})
+		} else {
+			system[ParamLicenseGUID] = key
+		}
+	}
+
+	all := make(map[string]json.RawMessage, len(values)+len(system))
+	for name, v := range values {
+		all[name] = v
+	}
+	for name, value := range system {
+		if _, ok := values[name]; ok {
+			issues = append(issues, ParameterIssue{name, "the value is set by ClarityXDR"})
+		}
+		if all[name], err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+
+	rendered, err := Render(t, all)
+	var invalid *ParametersError
+	switch {
+	case errors.As(err, &invalid):
+		issues = append(issues, invalid.Issues...)
+	case err != nil:
+		return nil, err
+	}
+	if len(issues) > 0 {
+		sort.Slice(issues, func(i, j int) bool { return issues[i].Parameter < issues[j].Parameter })
+		return nil, &ParametersError{Issues: issues}
+	}
+	return rendered, nil
+}
+
+// licenseKey returns the key of a client's active license, or "" when it
+// has none
+func (d *Deployer) licenseKey(ctx context.Context, clientID string) (string, error) {
+	var key string
+	err := d.db.QueryRowContext(ctx, `
+		SELECT l.license_key
+		FROM license_mgmt.licenses l
+		JOIN license_mgmt.clients c ON c.id = l.client_id
+		WHERE c.client_id::text = $1 AND l.is_active AND l.expiration_date >= CURRENT_DATE
+		ORDER BY l.expiration_date DESC
+		LIMIT 1`, clientID).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return key, err
+}
+
+// storedParameters returns the values of a request as they are stored:
+// the JSON of all values with those of secure parameters masked, and the
+// encrypted JSON of the secure values. Either is nil when there are none.
+func (d *Deployer) storedParameters(t *Template, values map[string]json.RawMessage) (plain, secure []byte, err error) {
+	if len(values) == 0 {
+		return nil, nil, nil
+	}
+	names, err := SecureParameters(t)
+	if err != nil {
+		return nil, nil, err
+	}
+	masked, err := json.Marshal(MaskedValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	plainValues := make(map[string]json.RawMessage)
+	secureValues := make(map[string]json.RawMessage)
+	for name, v := range values {
+		plainValues[name] = v
+		for _, s := range names {
+			if s == name {
+				plainValues[name], secureValues[name] = masked, v
+			}
+		}
+	}
+	if plain, err = json.Marshal(plainValues); err != nil {
+		return nil, nil, err
+	}
+	if len(secureValues) > 0 {
+		data, err := json.Marshal(secureValues)
+		if err != nil {
+			return nil, nil, err
+		}
+		if secure, err = d.secrets.Encrypt(data); err != nil {
+			return nil, nil, err
+		}
+	}
+	return plain, secure, nil
+}
+
+// parameters returns the values a deployment was requested with, the
+// values of secure parameters decrypted
+func (d *Deployer) parameters(dep *Deployment) (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage, len(dep.Parameters))
+	for name, v := range dep.Parameters {
+		values[name] = v
+	}
+	if len(dep.secureParameters) == 0 {
+		return values, nil
+	}
+	data, err := d.secrets.Decrypt(dep.secureParameters)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting secure parameters: %w", err)
+	}
+	var secure map[string]json.RawMessage
+	if err := json.Unmarshal(data, &secure); err != nil {
+		return nil, err
+	}
+	for name, v := range secure {
+		values[name] = v
+	}
+	return values, nil
+}
+
+const deploymentColumns = `
+	id, COALESCE(client_id::text, ''), logic_app_name, subscription_id, resource_group, template_name,
+	status, COALESCE(metadata, '{}'), COALESCE(parameters, '{}'), secure_parameters,
+	COALESCE(error_message, ''), deployed_at, created_at, updated_at`
+
+func scanDeployment(row interface{ Scan(...interface{}) error }) (*Deployment, error) {
+	var dep Deployment
+	var meta, params []byte
+	var deployedAt, createdAt, updatedAt sql.NullTime
+	if err := row.Scan(&dep.ID, &dep.ClientID, &dep.LogicAppName, &dep.SubscriptionID, &dep.ResourceGroup,
+		&dep.TemplateName, &dep.Status, &meta, &params, &dep.secureParameters, &dep.Error,
+		&deployedAt, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(params, &dep.Parameters); err != nil {
+		return nil, err
+	}
+	if len(dep.Parameters) == 0 {
+		dep.Parameters = nil
+	}
+	var m metadata
+	if err := json.Unmarshal(meta, &m); err == nil {
+		dep.TemplateVersion, dep.State = m.TemplateVersion, m.State
+		dep.Location, dep.ResourceID, dep.AccessEndpoint = m.Location, m.ResourceID, m.AccessEndpoint
+		dep.PrincipalID, dep.GrantPermissions = m.PrincipalID, m.GrantPermissions
+	}
+	// deployed_at defaults to when a deployment was requested; it is only
+	// a deployment time once the workflow was confirmed
+	if deployedAt.Valid && dep.provisioned() {
+		dep.DeployedAt = &deployedAt.Time
+	}
+	dep.CreatedAt, dep.UpdatedAt = createdAt.Time, updatedAt.Time
+	return &dep, nil
+}
+
+// provisioned reports whether a deployment's workflow was confirmed
+func (dep *Deployment) provisioned() bool {
+	return dep.Status == StatusSuccess || dep.Status == StatusDisabled
+}
+
+// Deployment returns a deployment
+func (d *Deployer) Deployment(ctx context.Context, id string) (*Deployment, error) {
+	dep, err := scanDeployment(d.db.QueryRowContext(ctx, "SELECT "+deploymentColumns+`
+		FROM deployment_mgmt.logic_app_deployments WHERE id::text = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return dep, err
+}
+
+// Deploy puts the workflow of a deployment and returns the deployment as
+// it ended. A deployment that fails is recorded as Failed with the error
+// Resource Manager gave.
+func (d *Deployer) Deploy(ctx context.Context, id string, actor Actor) (*Deployment, error) {
+	dep, err := d.claim(ctx, id)
+	if err != nil {
+		d.auditFailure(ctx, id, ActionDeploy, actor, err)
+		return nil, err
+	}
+	return dep, d.deploy(ctx, dep, ActionDeploy, actor, nil)
+}
+
+// Start claims a deployment and deploys it in the background. It returns
+// the deployment as it started.
+func (d *Deployer) Start(ctx context.Context, id string, actor Actor) (*Deployment, error) {
+	dep, err := d.claim(ctx, id)
+	if err != nil {
+		d.auditFailure(ctx, id, ActionDeploy, actor, err)
+		return nil, err
+	}
+	return d.start(ctx, dep, ActionDeploy, actor, nil), nil
+}
+
+// start deploys a claimed deployment in the background and returns it as
+// it started
+func (d *Deployer) start(ctx context.Context, dep *Deployment, action string, actor Actor,
+	details map[string]interface{}) *Deployment {
+	started := *dep
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), deployTimeout)
+		defer cancel()
+		if err := d.deploy(ctx, dep, action, actor, details); err != nil {
+			log.Printf("Logic App deployment %s of %s failed: %v", dep.ID, dep.LogicAppName, err)
+		}
+	}()
+	return &started
+}
+
+// claim moves a deployment to Deploying so no one else runs it. Pending
+// and failed deployments can be run; running a deployed one again
+// redeploys its template and a deleted one deploys it anew.
+func (d *Deployer) claim(ctx context.Context, id string) (*Deployment, error) {
+	dep, err := d.Deployment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if dep.ClientID == "" {
+		return nil, fmt.Errorf("deployment %s belongs to no client", dep.ID)
+	}
+	res, err := d.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.logic_app_deployments
+		SET status = $3, error_message = NULL, updated_at = NOW()
+		WHERE id = $1 AND status = $2
+			AND (status <> $3 OR updated_at < NOW() - $4 * INTERVAL '1 second')`,
+		dep.ID, dep.Status, StatusDeploying, deployTimeout.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrDeploymentBusy
+	}
+	dep.Status, dep.Error = StatusDeploying, ""
+	return dep, nil
+}
+
+// deploy puts the workflow of a claimed deployment and records the
+// outcome in the deployment and its audit trail. A deployment asking for
+// its permissions to be granted has them granted once it is provisioned;
+// failing to grant them is audited but does not fail the deployment.
+func (d *Deployer) deploy(ctx context.Context, dep *Deployment, action string, actor Actor,
+	details map[string]interface{}) error {
+	workflow, err := d.putDeployment(ctx, dep)
+	err = d.finish(ctx, dep, workflow, err)
+	d.audit(ctx, dep, action, actor, details, err)
+	if err == nil && dep.GrantPermissions && dep.PrincipalID != "" {
+		if _, grantErr := d.grant(ctx, dep, actor); grantErr != nil {
+			log.Printf("Granting permissions to Logic App deployment %s failed: %v", dep.ID, grantErr)
+		}
+	}
+	return err
+}
+
+func (d *Deployer) putDeployment(ctx context.Context, dep *Deployment) (*Workflow, error) {
+	// Deployments made before the catalog had versions deploy the active
+	// version, which they are then pinned to
+	ct, err := d.catalog.Template(ctx, dep.TemplateName, dep.TemplateVersion)
+	if err != nil {
+		return nil, err
+	}
+	dep.TemplateVersion = ct.Version
+	t, err := ct.Template()
+	if err != nil {
+		return nil, err
+	}
+	values, err := d.parameters(dep)
+	if err != nil {
+		return nil, err
+	}
+	if t, err = d.render(ctx, dep.ClientID, t, values); err != nil {
+		return nil, err
+	}
+	manager, err := d.managers.Manager(ctx, dep.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	// A disabled workflow stays disabled when it is redeployed or upgraded
+	if dep.State == "" {
+		dep.State = StateEnabled
+	}
+	tags := map[string]string{TagTemplate: dep.TemplateName, TagTemplateVersion: dep.TemplateVersion, TagDeployment: dep.ID}
+	return putWorkflow(ctx, manager, dep.SubscriptionID, dep.ResourceGroup, dep.LogicAppName,
+		dep.Location, dep.State, t, tags)
+}
+
+// finish records the outcome of a run and returns runErr
+func (d *Deployer) finish(ctx context.Context, dep *Deployment, workflow *Workflow, runErr error) error {
+	dep.Status, dep.Error = StatusSuccess, ""
+	if dep.State == StateDisabled {
+		dep.Status = StatusDisabled
+	}
+	if runErr != nil {
+		dep.Status, dep.Error = StatusFailed, runErr.Error()
+	}
+	if workflow != nil {
+		dep.ResourceID, dep.Location = workflow.ID, workflow.Location
+		dep.AccessEndpoint = workflow.Properties.AccessEndpoint
+		dep.PrincipalID = ""
+		if workflow.Identity != nil {
+			dep.PrincipalID = workflow.Identity.PrincipalID
+		}
+	}
+	meta, err := json.Marshal(dep.metadata())
+	if err != nil {
+		return err
+	}
+
+	// The outcome is recorded even when the run was cancelled
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	var deployedAt sql.NullTime
+	err = d.db.QueryRowContext(saveCtx, `
+		UPDATE deployment_mgmt.logic_app_deployments
+		SET status = $2, error_message = $3, metadata = $4,
+			deployed_at = CASE WHEN $5 THEN NOW() ELSE deployed_at END, updated_at = NOW()
+		WHERE id = $1
+		RETURNING deployed_at, updated_at`,
+		dep.ID, dep.Status, nullString(dep.Error), meta, runErr == nil).Scan(&deployedAt, &dep.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if deployedAt.Valid && dep.provisioned() {
+		dep.DeployedAt = &deployedAt.Time
+	}
+	return runErr
+}
+
+func (dep *Deployment) metadata() metadata {
+	return metadata{TemplateVersion: dep.TemplateVersion, State: dep.State, Location: dep.Location,
+		ResourceID: dep.ResourceID, AccessEndpoint: dep.AccessEndpoint, PrincipalID: dep.PrincipalID,
+		GrantPermissions: dep.GrantPermissions}
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
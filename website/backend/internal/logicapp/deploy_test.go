@@ -0,0 +1,215 @@
+package logicapp_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/arm"
+	"github.com/ClarityXDR/prod/website/backend/internal/arm/armtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/logicapp"
+)
+
+func newDeployer(t *testing.T, server *armtest.Server) (*logicapp.Deployer, *sql.DB, string) {
+	t.Helper()
+	db := dbtest.Open(t)
+	dir := t.TempDir()
+	for name, content := range map[string]string{"http-respond": httpTemplate, "contain-host": containTemplate} {
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	catalog := logicapp.NewCatalog(db, dir)
+	result, err := catalog.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("template not indexed: %+v", result.Errors)
+	}
+	secrets, err := azure.NewSecretCipher("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logicapp.NewDeployer(db, server.Source(), catalog, secrets), db, dbtest.CreateClient(t, db, "Contoso")
+}
+
+func TestDeployerRecordsConfirmedDeployments(t *testing.T) {
+	server := newServer(3)
+	defer server.Close()
+	d, _, clientID := newDeployer(t, server)
+	ctx := context.Background()
+
+	dep, err := d.Create(ctx, logicapp.Request{
+		ClientID: clientID, SubscriptionID: subscription, ResourceGroup: group,
+		LogicAppName: "respond", TemplateName: "http-respond",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dep.Status != logicapp.StatusPending || dep.DeployedAt != nil {
+		t.Fatalf("created deployment = %+v, want pending and not deployed", dep)
+	}
+
+	dep, err = d.Deploy(ctx, dep.ID, logicapp.Actor{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	workflow, ok := server.Resource(dep.ResourceID)
+	if !ok {
+		t.Fatalf("Resource Manager holds no workflow %s", dep.ResourceID)
+	}
+	state := workflow["properties"].(map[string]interface{})["provisioningState"]
+	if dep.Status != logicapp.StatusSuccess || dep.DeployedAt == nil || state != arm.StateSucceeded {
+		t.Errorf("deployment = %+v with the workflow %v, want Success once provisioned", dep, state)
+	}
+}
+
+func TestDeployerRecordsFailedDeployments(t *testing.T) {
+	server := newServer(0)
+	defer server.Close()
+	d, _, clientID := newDeployer(t, server)
+	ctx := context.Background()
+	server.Fail["broken"] = http.StatusBadRequest
+
+	dep, err := d.Create(ctx, logicapp.Request{
+		ClientID: clientID, SubscriptionID: subscription, ResourceGroup: group,
+		LogicAppName: "broken", TemplateName: "http-respond",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Deploy(ctx, dep.ID, logicapp.Actor{Name: "test"}); err == nil {
+		t.Fatal("deploying a workflow Resource Manager refused succeeded")
+	}
+	dep, err = d.Deployment(ctx, dep.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dep.Status != logicapp.StatusFailed || dep.Error == "" || dep.DeployedAt != nil {
+		t.Errorf("deployment = %+v, want Failed with the error and no deployment time", dep)
+	}
+}
+
+func TestDeployerChangesDeployedWorkflows(t *testing.T) {
+	server := newServer(0)
+	defer server.Close()
+	d, _, clientID := newDeployer(t, server)
+	ctx := context.Background()
+	actor := logicapp.Actor{Name: "test"}
+
+	dep, err := d.Create(ctx, logicapp.Request{
+		ClientID: clientID, SubscriptionID: subscription, ResourceGroup: group,
+		LogicAppName: "respond", TemplateName: "http-respond",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dep, err = d.Deploy(ctx, dep.ID, actor); err != nil {
+		t.Fatal(err)
+	}
+	if dep, err = d.Disable(ctx, dep.ID, actor); err != nil {
+		t.Fatal(err)
+	}
+	if dep.Status != logicapp.StatusDisabled {
+		t.Errorf("disabled deployment is %s, want %s", dep.Status, logicapp.StatusDisabled)
+	}
+
+	// A failed action leaves the deployment as it was, not claimed
+	server.Fail["respond/enable"] = http.StatusInternalServerError
+	if _, err := d.Enable(ctx, dep.ID, actor); err == nil {
+		t.Fatal("enabling a workflow Resource Manager refused succeeded")
+	}
+	if dep, err = d.Deployment(ctx, dep.ID); err != nil {
+		t.Fatal(err)
+	}
+	if dep.Status != logicapp.StatusDisabled {
+		t.Errorf("deployment is %s after a failed enable, want %s", dep.Status, logicapp.StatusDisabled)
+	}
+	delete(server.Fail, "respond/enable")
+
+	if dep, err = d.Enable(ctx, dep.ID, actor); err != nil {
+		t.Fatal(err)
+	}
+	if dep.Status != logicapp.StatusSuccess {
+		t.Errorf("enabled deployment is %s, want %s", dep.Status, logicapp.StatusSuccess)
+	}
+	resourceID := dep.ResourceID
+	if dep, err = d.Delete(ctx, dep.ID, actor); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Resource(resourceID); ok || dep.Status != logicapp.StatusDeleted {
+		t.Errorf("deleted deployment is %s with the workflow left %v, want %s and none", dep.Status, ok, logicapp.StatusDeleted)
+	}
+}
+
+func TestDeployerTakesOverInterruptedChanges(t *testing.T) {
+	server := newServer(0)
+	defer server.Close()
+	d, db, clientID := newDeployer(t, server)
+	ctx := context.Background()
+	actor := logicapp.Actor{Name: "alice", Claimed: true}
+
+	dep, err := d.Create(ctx, logicapp.Request{
+		ClientID: clientID, SubscriptionID: subscription, ResourceGroup: group,
+		LogicAppName: "respond", TemplateName: "http-respond",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dep, err = d.Deploy(ctx, dep.ID, actor); err != nil {
+		t.Fatal(err)
+	}
+	resourceID := dep.ResourceID
+
+	// A deployment running elsewhere keeps its claim
+	if _, err := db.Exec(`UPDATE deployment_mgmt.logic_app_deployments SET status = $2 WHERE id = $1`,
+		dep.ID, logicapp.StatusDeploying); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Delete(ctx, dep.ID, actor); !errors.Is(err, logicapp.ErrDeploymentBusy) {
+		t.Fatalf("deleting a deploying deployment: err = %v, want ErrDeploymentBusy", err)
+	}
+	if _, err := d.Enable(ctx, dep.ID, actor); !errors.Is(err, logicapp.ErrDeploymentBusy) {
+		t.Fatalf("enabling a deploying deployment: err = %v, want ErrDeploymentBusy", err)
+	}
+
+	// One left Deploying past the deployment timeout is taken over as
+	// failed, which can be deleted but not enabled
+	if _, err := db.Exec(`UPDATE deployment_mgmt.logic_app_deployments
+		SET updated_at = NOW() - INTERVAL '31 minutes' WHERE id = $1`, dep.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Enable(ctx, dep.ID, actor); !errors.Is(err, logicapp.ErrDeploymentBusy) {
+		t.Fatalf("enabling an interrupted deployment: err = %v, want ErrDeploymentBusy", err)
+	}
+	if dep, err = d.Delete(ctx, dep.ID, actor); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Resource(resourceID); ok || dep.Status != logicapp.StatusDeleted {
+		t.Errorf("deleted deployment is %s with the workflow left %v, want %s and none", dep.Status, ok, logicapp.StatusDeleted)
+	}
+
+	// The actor's name was given by the caller
+	entries, err := d.Audit(ctx, dep.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 || entries[0].Action != logicapp.ActionDelete || entries[0].Actor != "alice" {
+		t.Fatalf("audit = %+v, want the delete by alice first", entries)
+	}
+	var details map[string]interface{}
+	if err := json.Unmarshal(entries[0].Details, &details); err != nil {
+		t.Fatal(err)
+	}
+	if details["actorClaimed"] != true {
+		t.Errorf("audit details = %v, want the actor recorded as claimed", details)
+	}
+}
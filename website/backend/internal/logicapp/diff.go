@@ -0,0 +1,160 @@
+package logicapp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kinds of changes between two templates
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// Change is a value that differs between two templates
+type Change struct {
+	// Path addresses the value, such as $.definition.actions.Isolate.inputs
+	Path string          `json:"path"`
+	Kind string          `json:"kind"`
+	From json.RawMessage `json:"from,omitempty"`
+	To   json.RawMessage `json:"to,omitempty"`
+}
+
+// identifier matches object keys that can follow a dot in a path
+var identifier = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+
+// Diff compares the definitions and parameter values of two templates.
+// Objects are compared key by key and arrays item by item, so a change
+// is reported at the deepest value that differs.
+func Diff(from, to *Template) ([]Change, error) {
+	parts := []struct {
+		name     string
+		from, to json.RawMessage
+	}{
+		{"definition", from.Definition, to.Definition},
+		{"parameters", from.Parameters, to.Parameters},
+	}
+	changes := []Change{}
+	for _, part := range parts {
+		a, err := decodeValue(part.from)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidTemplate, part.name, err)
+		}
+		b, err := decodeValue(part.to)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidTemplate, part.name, err)
+		}
+		if changes, err = diffValues(changes, "$."+part.name, a, b); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+func decodeValue(data json.RawMessage) (interface{}, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	err := dec.Decode(&v)
+	return v, err
+}
+
+func diffValues(changes []Change, path string, a, b interface{}) ([]Change, error) {
+	switch {
+	case a == nil && b == nil:
+		return changes, nil
+	case a == nil:
+		return change(changes, path, ChangeAdded, nil, b)
+	case b == nil:
+		return change(changes, path, ChangeRemoved, a, nil)
+	}
+
+	aMap, aIsMap := a.(map[string]interface{})
+	bMap, bIsMap := b.(map[string]interface{})
+	if aIsMap && bIsMap {
+		keys := make([]string, 0, len(aMap)+len(bMap))
+		for k := range aMap {
+			keys = append(keys, k)
+		}
+		for k := range bMap {
+			if _, ok := aMap[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		var err error
+		for _, k := range keys {
+			if changes, err = diffValues(changes, path+pathKey(k), aMap[k], bMap[k]); err != nil {
+				return nil, err
+			}
+		}
+		return changes, nil
+	}
+
+	aList, aIsList := a.([]interface{})
+	bList, bIsList := b.([]interface{})
+	if aIsList && bIsList {
+		var err error
+		for i := 0; i < len(aList) || i < len(bList); i++ {
+			var x, y interface{}
+			if i < len(aList) {
+				x = aList[i]
+			}
+			if i < len(bList) {
+				y = bList[i]
+			}
+			if changes, err = diffValues(changes, fmt.Sprintf("%s[%d]", path, i), x, y); err != nil {
+				return nil, err
+			}
+		}
+		return changes, nil
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return changes, nil
+	}
+	// 1 and 1.0 are the same value written differently
+	if x, ok := a.(json.Number); ok {
+		if y, ok := b.(json.Number); ok {
+			fx, errX := x.Float64()
+			fy, errY := y.Float64()
+			if errX == nil && errY == nil && fx == fy {
+				return changes, nil
+			}
+		}
+	}
+	return change(changes, path, ChangeChanged, a, b)
+}
+
+func change(changes []Change, path, kind string, from, to interface{}) ([]Change, error) {
+	c := Change{Path: path, Kind: kind}
+	var err error
+	if from != nil {
+		if c.From, err = json.Marshal(from); err != nil {
+			return nil, err
+		}
+	}
+	if to != nil {
+		if c.To, err = json.Marshal(to); err != nil {
+			return nil, err
+		}
+	}
+	return append(changes, c), nil
+}
+
+// pathKey returns the path segment of an object key
+func pathKey(key string) string {
+	if identifier.MatchString(key) {
+		return "." + key
+	}
+	return "['" + strings.ReplaceAll(key, "'", `\'`) + "']"
+}
@@ -0,0 +1,445 @@
+package logicapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/arm"
+	"github.com/lib/pq"
+)
+
+const (
+	// maxBulk bounds the deployments one bulk action changes
+	maxBulk = 500
+	// bulkWorkers is how many deployments a bulk action changes at once
+	bulkWorkers = 4
+)
+
+// Enable turns the triggers of a disabled workflow back on
+func (d *Deployer) Enable(ctx context.Context, id string, actor Actor) (*Deployment, error) {
+	return d.setState(ctx, id, ActionEnable, StateEnabled, actor)
+}
+
+// Disable turns off the triggers of a workflow. It keeps its definition
+// and parameters and can be enabled again.
+func (d *Deployer) Disable(ctx context.Context, id string, actor Actor) (*Deployment, error) {
+	return d.setState(ctx, id, ActionDisable, StateDisabled, actor)
+}
+
+func (d *Deployer) setState(ctx context.Context, id, action, state string, actor Actor) (*Deployment, error) {
+	return d.change(ctx, id, action, actor, []string{StatusSuccess, StatusDisabled},
+		func(ctx context.Context, manager arm.ResourceManager, dep *Deployment) error {
+			if err := SetWorkflowState(ctx, manager, dep.SubscriptionID, dep.ResourceGroup, dep.LogicAppName, state); err != nil {
+				return err
+			}
+			dep.State, dep.Status = state, StatusSuccess
+			if state == StateDisabled {
+				dep.Status = StatusDisabled
+			}
+			return nil
+		})
+}
+
+// Delete deletes the workflow of a deployment. The deployment is kept as
+// Deleted with its audit trail and can be deployed again.
+func (d *Deployer) Delete(ctx context.Context, id string, actor Actor) (*Deployment, error) {
+	return d.change(ctx, id, ActionDelete, actor, []string{StatusPending, StatusSuccess, StatusDisabled, StatusFailed},
+		func(ctx context.Context, manager arm.ResourceManager, dep *Deployment) error {
+			if err := DeleteWorkflow(ctx, manager, dep.SubscriptionID, dep.ResourceGroup, dep.LogicAppName); err != nil {
+				return err
+			}
+			dep.Status, dep.State, dep.AccessEndpoint, dep.DeployedAt = StatusDeleted, "", "", nil
+			return nil
+		})
+}
+
+// change runs an action on the workflow of a deployment in one of the
+// allowed statuses, saves the status it leaves the deployment in and
+// audits it. The deployment is claimed as Deploying while the action
+// runs, so deployments and other actions cannot start meanwhile, without
+// holding a transaction open across the calls to Resource Manager.
+func (d *Deployer) change(ctx context.Context, id, action string, actor Actor, allowed []string,
+	run func(context.Context, arm.ResourceManager, *Deployment) error) (*Deployment, error) {
+	dep, err := d.claimChange(ctx, id, allowed)
+	if dep == nil {
+		d.auditFailure(ctx, id, action, actor, err)
+		return nil, err
+	}
+	if err == nil {
+		err = d.runChange(ctx, dep, run)
+	}
+	d.audit(ctx, dep, action, actor, nil, err)
+	if err != nil {
+		return nil, err
+	}
+	return dep, nil
+}
+
+// claimChange moves a deployment whose status allows an action to
+// Deploying. A deployment left Deploying for longer than a deployment may
+// run was interrupted, such as by a restart; it is taken over as Failed.
+// It returns the deployment as it was read, even when it cannot be
+// claimed.
+func (d *Deployer) claimChange(ctx context.Context, id string, allowed []string) (*Deployment, error) {
+	dep, err := d.Deployment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case dep.Status == StatusDeploying && !contains(allowed, StatusFailed):
+		return dep, ErrDeploymentBusy
+	case dep.Status != StatusDeploying && !contains(allowed, dep.Status):
+		return dep, fmt.Errorf("%w: the deployment is %s", ErrInvalidState, dep.Status)
+	case dep.ClientID == "":
+		return dep, fmt.Errorf("deployment %s belongs to no client", dep.ID)
+	}
+	// The deployment is claimed as it was read, so a deployment or action
+	// that started in between keeps it
+	res, err := d.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.logic_app_deployments
+		SET status = $3, updated_at = NOW()
+		WHERE id = $1 AND status = $2 AND updated_at = $4
+			AND (status <> $3 OR updated_at < NOW() - $5 * INTERVAL '1 second')`,
+		dep.ID, dep.Status, StatusDeploying, dep.UpdatedAt, deployTimeout.Seconds())
+	if err != nil {
+		return dep, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return dep, err
+	} else if n == 0 {
+		return dep, ErrDeploymentBusy
+	}
+	if dep.Status == StatusDeploying {
+		dep.Status = StatusFailed
+	}
+	return dep, nil
+}
+
+// runChange runs the action of a claimed deployment and records the
+// status it leaves the deployment in. A failed action leaves the
+// deployment as it was before it was claimed.
+func (d *Deployer) runChange(ctx context.Context, dep *Deployment,
+	run func(context.Context, arm.ResourceManager, *Deployment) error) error {
+	before := *dep
+	manager, runErr := d.managers.Manager(ctx, dep.ClientID)
+	if runErr == nil {
+		runErr = run(ctx, manager, dep)
+	}
+	if runErr != nil {
+		*dep = before
+	}
+	meta, err := json.Marshal(dep.metadata())
+	if err != nil {
+		return err
+	}
+
+	// The outcome is recorded even when the request was cancelled, so the
+	// deployment is not left claimed
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	err = d.db.QueryRowContext(saveCtx, `
+		UPDATE deployment_mgmt.logic_app_deployments
+		SET status = $2, metadata = $3, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at`, dep.ID, dep.Status, meta).Scan(&dep.UpdatedAt)
+	if runErr != nil {
+		return runErr
+	}
+	return err
+}
+
+// UpgradeRequest asks for a deployment to be moved to another version of
+// its template, or redeployed with other parameter values
+type UpgradeRequest struct {
+	// Version defaults to the active version of the template
+	Version string `json:"version,omitempty"`
+	// Parameters change values the deployment was requested with; values
+	// not given are kept
+	Parameters map[string]json.RawMessage `json:"parameters,omitempty"`
+	// DryRun returns the changes without deploying them
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// Upgrade is a deployment moving to another version of its template
+type Upgrade struct {
+	Deployment  *Deployment `json:"deployment"`
+	FromVersion string      `json:"fromVersion"`
+	ToVersion   string      `json:"toVersion"`
+	// Changes are the differences between the workflow as deployed and as
+	// upgraded. Values of secure parameters are masked.
+	Changes []Change `json:"changes"`
+	DryRun  bool     `json:"dryRun"`
+}
+
+// Upgrade redeploys a deployment with another version of its template
+// in the background, keeping the workflow enabled or disabled as it was.
+// Values of parameters the new version no longer declares are dropped.
+// A dry run only returns what would change.
+func (d *Deployer) Upgrade(ctx context.Context, id string, req UpgradeRequest, actor Actor) (*Upgrade, error) {
+	up, err := d.upgrade(ctx, id, req, actor)
+	if err != nil && !req.DryRun {
+		d.auditFailure(ctx, id, ActionUpgrade, actor, err)
+	}
+	return up, err
+}
+
+func (d *Deployer) upgrade(ctx context.Context, id string, req UpgradeRequest, actor Actor) (*Upgrade, error) {
+	dep, err := d.Deployment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if dep.Status == StatusDeploying {
+		return nil, ErrDeploymentBusy
+	}
+	if !contains([]string{StatusSuccess, StatusDisabled, StatusFailed}, dep.Status) {
+		return nil, fmt.Errorf("%w: the deployment is %s", ErrInvalidState, dep.Status)
+	}
+	ct, err := d.catalog.Template(ctx, dep.TemplateName, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	if ct.Version == dep.TemplateVersion && len(req.Parameters) == 0 {
+		return nil, fmt.Errorf("%w: the deployment is already at version %s", ErrInvalidRequest, ct.Version)
+	}
+	t, err := ct.Template()
+	if err != nil {
+		return nil, err
+	}
+	_, declared, err := declarations(t)
+	if err != nil {
+		return nil, err
+	}
+	values, err := d.parameters(dep)
+	if err != nil {
+		return nil, err
+	}
+	for name := range values {
+		if _, ok := declared[name]; !ok {
+			delete(values, name)
+		}
+	}
+	for name, v := range req.Parameters {
+		values[name] = v
+	}
+	rendered, err := d.render(ctx, dep.ClientID, t, values)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := d.changes(ctx, dep, rendered)
+	if err != nil {
+		return nil, err
+	}
+	up := &Upgrade{Deployment: dep, FromVersion: dep.TemplateVersion, ToVersion: ct.Version, Changes: changes,
+		DryRun: req.DryRun}
+	if req.DryRun {
+		return up, nil
+	}
+
+	plain, secure, err := d.storedParameters(t, values)
+	if err != nil {
+		return nil, err
+	}
+	next := *dep
+	next.TemplateVersion, next.Status, next.Error, next.secureParameters = ct.Version, StatusDeploying, "", secure
+	next.Parameters = nil
+	if plain != nil {
+		if err := json.Unmarshal(plain, &next.Parameters); err != nil {
+			return nil, err
+		}
+	}
+	meta, err := json.Marshal(next.metadata())
+	if err != nil {
+		return nil, err
+	}
+	// The deployment is claimed as it was read, so values someone else
+	// saved in between are not overwritten
+	res, err := d.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.logic_app_deployments
+		SET status = $3, error_message = NULL, metadata = $4, parameters = $5, secure_parameters = $6,
+			updated_at = NOW()
+		WHERE id = $1 AND status = $2 AND updated_at = $7`,
+		dep.ID, dep.Status, StatusDeploying, meta, plain, secure, dep.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrDeploymentBusy
+	}
+
+	details := map[string]interface{}{"fromVersion": up.FromVersion, "toVersion": up.ToVersion, "changes": len(changes)}
+	up.Deployment = d.start(ctx, &next, ActionUpgrade, actor, details)
+	return up, nil
+}
+
+// changes compares the workflow of a deployment as it was deployed with a
+// rendered template. Values of secure parameters are masked on both sides.
+func (d *Deployer) changes(ctx context.Context, dep *Deployment, to *Template) ([]Change, error) {
+	ct, err := d.catalog.Template(ctx, dep.TemplateName, dep.TemplateVersion)
+	if err != nil {
+		return nil, err
+	}
+	from, err := ct.Template()
+	if err != nil {
+		return nil, err
+	}
+	values, err := d.parameters(dep)
+	if err != nil {
+		return nil, err
+	}
+	// The deployed values may no longer render, such as when the client's
+	// license has lapsed since; the template is then compared as it is
+	rendered, err := d.render(ctx, dep.ClientID, from, values)
+	switch {
+	case err == nil:
+		from = rendered
+	case !errors.Is(err, ErrInvalidParameters):
+		return nil, err
+	}
+	if from, err = Mask(from); err != nil {
+		return nil, err
+	}
+	if to, err = Mask(to); err != nil {
+		return nil, err
+	}
+	return Diff(from, to)
+}
+
+// BulkRequest applies a lifecycle action to many deployments, picked by
+// ID or by template and client. Deleted deployments are only picked by ID.
+type BulkRequest struct {
+	// Action is enable, disable, upgrade, delete or deploy
+	Action        string   `json:"action"`
+	DeploymentIDs []string `json:"deploymentIds,omitempty"`
+	TemplateName  string   `json:"templateName,omitempty"`
+	ClientIDs     []string `json:"clientIds,omitempty"`
+	// Version is the template version upgrades go to, by default the
+	// active one
+	Version string `json:"version,omitempty"`
+}
+
+// BulkResult is the outcome of a bulk action for one deployment
+type BulkResult struct {
+	DeploymentID string `json:"deploymentId"`
+	ClientID     string `json:"clientId,omitempty"`
+	LogicAppName string `json:"logicAppName,omitempty"`
+	// Status is the deployment's status after the action
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Bulk applies an action to each deployment a request picks, a few at a
+// time, and returns the outcome for each. Deployments and upgrades are
+// started in the background as they are one by one. Each deployment is
+// audited on its own.
+func (d *Deployer) Bulk(ctx context.Context, req BulkRequest, actor Actor) ([]BulkResult, error) {
+	var run func(id string) (*Deployment, error)
+	switch req.Action {
+	case ActionEnable:
+		run = func(id string) (*Deployment, error) { return d.Enable(ctx, id, actor) }
+	case ActionDisable:
+		run = func(id string) (*Deployment, error) { return d.Disable(ctx, id, actor) }
+	case ActionDelete:
+		run = func(id string) (*Deployment, error) { return d.Delete(ctx, id, actor) }
+	case ActionDeploy:
+		run = func(id string) (*Deployment, error) { return d.Start(ctx, id, actor) }
+	case ActionUpgrade:
+		run = func(id string) (*Deployment, error) {
+			up, err := d.Upgrade(ctx, id, UpgradeRequest{Version: req.Version}, actor)
+			if err != nil {
+				return nil, err
+			}
+			return up.Deployment, nil
+		}
+	default:
+		return nil, fmt.Errorf("%w: action must be enable, disable, upgrade, delete or deploy", ErrInvalidRequest)
+	}
+	if len(req.DeploymentIDs) == 0 && req.TemplateName == "" && len(req.ClientIDs) == 0 {
+		return nil, fmt.Errorf("%w: deploymentIds, templateName or clientIds is required", ErrInvalidRequest)
+	}
+	results, err := d.bulkTargets(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	sem := make(chan struct{}, bulkWorkers)
+	var wg sync.WaitGroup
+	for i := range results {
+		if results[i].Error != "" {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *BulkResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			dep, err := run(r.DeploymentID)
+			if err != nil {
+				r.Error = err.Error()
+				return
+			}
+			r.Status = dep.Status
+		}(&results[i])
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// bulkTargets returns a result for each deployment a bulk request picks.
+// IDs that match no deployment get a result with an error.
+func (d *Deployer) bulkTargets(ctx context.Context, req BulkRequest) ([]BulkResult, error) {
+	ids := append([]string{}, req.DeploymentIDs...)
+	clients := append([]string{}, req.ClientIDs...)
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id::text, COALESCE(client_id::text, ''), logic_app_name
+		FROM deployment_mgmt.logic_app_deployments
+		WHERE (cardinality($1::text[]) = 0 OR id::text = ANY($1))
+			AND ($2::text = '' OR template_name = $2)
+			AND (cardinality($3::text[]) = 0 OR client_id::text = ANY($3))
+			AND (cardinality($1::text[]) > 0 OR status <> $4)
+		ORDER BY created_at
+		LIMIT $5`,
+		pq.Array(ids), req.TemplateName, pq.Array(clients), StatusDeleted, maxBulk+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []BulkResult{}
+	found := make(map[string]bool)
+	for rows.Next() {
+		var r BulkResult
+		if err := rows.Scan(&r.DeploymentID, &r.ClientID, &r.LogicAppName); err != nil {
+			return nil, err
+		}
+		found[r.DeploymentID] = true
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(results) > maxBulk {
+		return nil, fmt.Errorf("%w: more than %d deployments match", ErrInvalidRequest, maxBulk)
+	}
+	for _, id := range ids {
+		if !found[id] {
+			found[id] = true
+			results = append(results, BulkResult{DeploymentID: id, Error: ErrNotFound.Error()})
+		}
+	}
+	return results, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
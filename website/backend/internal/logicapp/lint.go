@@ -0,0 +1,472 @@
+package logicapp
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Rules checked by Lint
+const (
+	// RuleSecret flags credentials written into a template
+	RuleSecret = "plaintext-secret"
+	// RuleFailureHandling flags actions calling out whose failure nothing
+	// handles
+	RuleFailureHandling = "missing-failure-handling"
+	// RuleHTTPAuthentication flags HTTP actions sending no credentials
+	RuleHTTPAuthentication = "http-without-authentication"
+	// RulePlaceholder flags sample values left for the deployer to fill in
+	RulePlaceholder = "unresolved-placeholder"
+	// RuleAudience flags tokens requested for another API than the one
+	// called
+	RuleAudience = "audience-mismatch"
+)
+
+// Severities of findings
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Finding is a problem Lint found in a template
+type Finding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	// Path addresses the offending value, as in Change
+	Path string `json:"path"`
+	// Action is the action the value belongs to, if any
+	Action  string `json:"action,omitempty"`
+	Message string `json:"message"`
+}
+
+// LintReport is the outcome of linting one template
+type LintReport struct {
+	Name     string    `json:"name"`
+	Version  string    `json:"version,omitempty"`
+	File     string    `json:"file,omitempty"`
+	Findings []Finding `json:"findings"`
+	// Error is set for files that are not workflow templates
+	Error string `json:"error,omitempty"`
+}
+
+// Errors counts the findings of severity error
+func (r *LintReport) Errors() int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Severity == SeverityError {
+			n++
+		}
+	}
+	return n
+}
+
+// fallibleTypes are the action types that call other services and so can
+// fail for reasons outside the workflow
+var fallibleTypes = map[string]bool{
+	"http": true, "httpwebhook": true, "apiconnection": true, "apiconnectionwebhook": true,
+	"function": true, "workflow": true,
+}
+
+// audiences are the token audiences of the APIs HTTP actions call, by
+// host. The first audience is the one to use; the others are accepted.
+var audiences = map[string][]string{
+	"graph.microsoft.com":              {"https://graph.microsoft.com"},
+	"api.securitycenter.microsoft.com": {"https://api.securitycenter.microsoft.com", "https://api.securitycenter.windows.com", "https://api.security.microsoft.com"},
+	"api.securitycenter.windows.com":   {"https://api.securitycenter.microsoft.com", "https://api.securitycenter.windows.com", "https://api.security.microsoft.com"},
+	"api.security.microsoft.com":       {"https://api.security.microsoft.com", "https://api.securitycenter.microsoft.com", "https://api.securitycenter.windows.com"},
+	"management.azure.com":             {"https://management.azure.com"},
+	"api.loganalytics.io":              {"https://api.loganalytics.io"},
+	"api.loganalytics.azure.com":       {"https://api.loganalytics.io"},
+	"outlook.office365.com":            {"https://outlook.office365.com"},
+	"vault.azure.net":                  {"https://vault.azure.net"},
+}
+
+// msiDefaultAudience is the audience of managed identity tokens requested
+// without one
+const msiDefaultAudience = "https://management.azure.com"
+
+var (
+	// secretKey matches the names of object keys holding credentials
+	secretKey = regexp.MustCompile(`(?i)^(password|passwd|secret|client_?secret|api_?key|x-api-key|api-key|` +
+		`access_?token|token|sharedaccesskey|ocp-apim-subscription-key|authorization)$`)
+	// secretValues match credentials wherever they appear
+	secretValues = []struct {
+		pattern *regexp.Regexp
+		what    string
+	}{
+		{regexp.MustCompile(`(?i)client_secret=[^&@\s]`), "a client secret"},
+		{regexp.MustCompile(`\beyJ[\w-]{10,}\.[\w-]{10,}\.`), "a bearer token"},
+		{regexp.MustCompile(`(?i)\b(AccountKey|SharedAccessKey)=[^;@\s]`), "a storage or Service Bus key"},
+		{regexp.MustCompile(`[?&]sig=[\w%+/=-]{20,}`), "a Logic App callback signature"},
+	}
+	// placeholders match sample values in exported templates
+	placeholders = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bprod-x+\b`),
+		regexp.MustCompile(`(?i)\bx{4,}\b`),
+		regexp.MustCompile(`\b0{8}-0{4}-0{4}-0{4}-0{12}\b`),
+		regexp.MustCompile(`(?i)\byour[-_]?(resource[-_]?group|tenant|subscription|workspace|connection|client|app|domain|org)\w*`),
+		regexp.MustCompile(`(?i)<(your|enter|insert|replace)[ _-][^<>]*>`),
+		regexp.MustCompile(`(?i)\b(replace[-_]?me|change[-_]?me)\b`),
+		regexp.MustCompile(`(?i)/locations/region/`),
+	}
+	// authHeaders are headers that carry credentials
+	authHeaders = []string{"authorization", "x-api-key", "api-key", "ocp-apim-subscription-key"}
+)
+
+// Lint checks a template for credentials written into it, actions whose
+// failure nothing handles, unauthenticated HTTP actions, placeholders
+// left unresolved and tokens requested for the wrong API. Findings are
+// ordered by path.
+func Lint(t *Template) ([]Finding, error) {
+	definition, err := decodeValue(t.Definition)
+	if err != nil {
+		return nil, fmt.Errorf("%w: definition: %v", ErrInvalidTemplate, err)
+	}
+	parameters, err := decodeValue(t.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("%w: parameters: %v", ErrInvalidTemplate, err)
+	}
+	def, _ := definition.(map[string]interface{})
+	if def == nil {
+		return nil, fmt.Errorf("%w: definition must be an object", ErrInvalidTemplate)
+	}
+
+	l := &linter{findings: []Finding{}}
+	walkStrings("$.definition", "", def, l.checkString)
+	// API connection IDs are bound to the resource group at deployment
+	if p, ok := parameters.(map[string]interface{}); ok && p["$connections"] != nil {
+		unbound := make(map[string]interface{}, len(p))
+		for k, v := range p {
+			if k != "$connections" {
+				unbound[k] = v
+			}
+		}
+		parameters = unbound
+	}
+	walkStrings("$.parameters", "", parameters, l.checkString)
+	l.checkParameters(def, parameters)
+	actions, _ := def["actions"].(map[string]interface{})
+	l.checkScope("$.definition.actions", actions, false)
+
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		return l.findings[i].Path < l.findings[j].Path
+	})
+	return l.findings, nil
+}
+
+// LintDir lints the templates of a directory. Files that are not workflow
+// templates are reported with an error rather than failing the others.
+func LintDir(dir string) ([]LintReport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("reading templates: %w", err)
+		}
+	}
+	sort.Strings(files)
+	reports := []LintReport{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		base := filepath.Base(file)
+		reports = append(reports, lintReport(strings.TrimSuffix(base, ".json"), "", base, data))
+	}
+	return reports, nil
+}
+
+// Lint lints the active version of every template in the catalog
+func (c *Catalog) Lint(ctx context.Context) ([]LintReport, error) {
+	templates, err := c.Templates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reports := []LintReport{}
+	for _, ct := range templates {
+		reports = append(reports, lintReport(ct.Name, ct.Version, "", ct.Content))
+	}
+	return reports, nil
+}
+
+func lintReport(name, version, file string, data []byte) LintReport {
+	report := LintReport{Name: name, Version: version, File: file, Findings: []Finding{}}
+	t, err := ParseTemplate(data)
+	if err == nil {
+		var findings []Finding
+		if findings, err = Lint(t); err == nil {
+			report.Findings = findings
+		}
+	}
+	if err != nil {
+		report.Error = strings.TrimPrefix(err.Error(), ErrInvalidTemplate.Error()+": ")
+	}
+	return report
+}
+
+type linter struct {
+	findings []Finding
+}
+
+func (l *linter) add(rule, severity, path, action, format string, args ...interface{}) {
+	l.findings = append(l.findings, Finding{
+		Rule:     rule,
+		Severity: severity,
+		Path:     path,
+		Action:   action,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// checkString looks for credentials and placeholders in a string value
+func (l *linter) checkString(path, key, s string) {
+	if key == "$schema" || s == "" || s == MaskedValue {
+		return
+	}
+	if secretKey.MatchString(key) && literalSecret(s) {
+		l.add(RuleSecret, SeverityError, path, "", "%s is written into the template; take it from a secure parameter or Key Vault", key)
+	} else {
+		for _, v := range secretValues {
+			if v.pattern.MatchString(expression.ReplaceAllString(s, "")) {
+				l.add(RuleSecret, SeverityError, path, "", "the value contains %s; take it from a secure parameter or Key Vault", v.what)
+				break
+			}
+		}
+	}
+	for _, p := range placeholders {
+		if m := p.FindString(s); m != "" {
+			l.add(RulePlaceholder, SeverityError, path, "", "%q is a placeholder to replace before deploying", m)
+			break
+		}
+	}
+}
+
+// literalSecret reports whether a credential value is written out rather
+// than computed by an expression. An authorization header naming its
+// scheme is literal only if its credentials are.
+func literalSecret(s string) bool {
+	if strings.HasPrefix(s, "@") && !strings.HasPrefix(s, "@@") {
+		return false
+	}
+	if i := strings.IndexByte(s, ' '); i > 0 {
+		switch strings.ToLower(s[:i]) {
+		case "bearer", "basic", "sharedaccesssignature":
+			s = strings.TrimSpace(s[i+1:])
+		}
+	}
+	return s != "" && !expression.MatchString(s)
+}
+
+// checkParameters flags secure parameters with a default or a value
+func (l *linter) checkParameters(def map[string]interface{}, parameters interface{}) {
+	declared, _ := def["parameters"].(map[string]interface{})
+	values, _ := parameters.(map[string]interface{})
+	for _, name := range sortedNames(declared) {
+		p, _ := declared[name].(map[string]interface{})
+		typ, _ := p["type"].(string)
+		if name == "$connections" || !(declaration{Type: typ}).secure(name) {
+			continue
+		}
+		base := "$.definition.parameters" + pathKey(name)
+		if v, ok := p["defaultValue"]; ok && !emptyValue(v) {
+			l.add(RuleSecret, SeverityError, base+".defaultValue", "",
+				"secure parameter %s has a default value; give it when deploying", name)
+		}
+		if value, ok := values[name].(map[string]interface{}); ok && !emptyValue(value["value"]) {
+			l.add(RuleSecret, SeverityError, "$.parameters"+pathKey(name)+".value", "",
+				"secure parameter %s has a value in the template; give it when deploying", name)
+		}
+	}
+}
+
+func emptyValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// checkScope checks the actions of a scope. handled is whether the scope
+// itself has a failure handler, which covers its actions.
+func (l *linter) checkScope(path string, actions map[string]interface{}, handled bool) {
+	// Actions run after a failure or timeout of a sibling handle it
+	handlers := make(map[string]bool)
+	for _, name := range sortedNames(actions) {
+		action, _ := actions[name].(map[string]interface{})
+		runAfter, _ := action["runAfter"].(map[string]interface{})
+		for predecessor, statuses := range runAfter {
+			list, _ := statuses.([]interface{})
+			for _, s := range list {
+				if status, _ := s.(string); strings.EqualFold(status, "Failed") || strings.EqualFold(status, "TimedOut") {
+					handlers[predecessor] = true
+				}
+			}
+		}
+	}
+
+	for _, name := range sortedNames(actions) {
+		action, _ := actions[name].(map[string]interface{})
+		if action == nil {
+			continue
+		}
+		actionPath := path + pathKey(name)
+		typ, _ := action["type"].(string)
+		covered := handled || handlers[name]
+		if fallibleTypes[strings.ToLower(typ)] && !covered {
+			l.add(RuleFailureHandling, SeverityWarning, actionPath+".runAfter", name,
+				"nothing runs after %s action %s fails or times out", typ, name)
+		}
+		if strings.EqualFold(typ, "Http") || strings.EqualFold(typ, "HttpWebhook") {
+			l.checkHTTP(actionPath, name, action)
+		}
+
+		nested, _ := action["actions"].(map[string]interface{})
+		l.checkScope(actionPath+".actions", nested, covered)
+		if e, ok := action["else"].(map[string]interface{}); ok {
+			nested, _ := e["actions"].(map[string]interface{})
+			l.checkScope(actionPath+".else.actions", nested, covered)
+		}
+		cases, _ := action["cases"].(map[string]interface{})
+		for _, c := range sortedNames(cases) {
+			branch, _ := cases[c].(map[string]interface{})
+			nested, _ := branch["actions"].(map[string]interface{})
+			l.checkScope(actionPath+".cases"+pathKey(c)+".actions", nested, covered)
+		}
+		if d, ok := action["default"].(map[string]interface{}); ok {
+			nested, _ := d["actions"].(map[string]interface{})
+			l.checkScope(actionPath+".default.actions", nested, covered)
+		}
+	}
+}
+
+// checkHTTP checks the authentication of an HTTP action against the API
+// it calls
+func (l *linter) checkHTTP(path, name string, action map[string]interface{}) {
+	inputs, _ := action["inputs"].(map[string]interface{})
+	uri, _ := inputs["uri"].(string)
+	auth, _ := inputs["authentication"].(map[string]interface{})
+	authType, _ := auth["type"].(string)
+	host := ""
+	if u, err := url.Parse(expression.ReplaceAllString(uri, "{id}")); err == nil && !strings.HasPrefix(uri, "@") {
+		host = strings.ToLower(u.Hostname())
+	}
+
+	if authType == "" || strings.EqualFold(authType, "None") {
+		if hasAuthHeader(inputs) || selfAuthenticating(uri, host) {
+			return
+		}
+		severity := SeverityWarning
+		if _, known := audienceOf(host); known {
+			severity = SeverityError
+		}
+		target := host
+		if target == "" {
+			target = "its URI"
+		}
+		l.add(RuleHTTPAuthentication, severity, path+".inputs", name,
+			"HTTP action %s calls %s without authentication", name, target)
+		return
+	}
+
+	if !strings.EqualFold(authType, "ManagedServiceIdentity") && !strings.EqualFold(authType, "ActiveDirectoryOAuth") {
+		return
+	}
+	expected, known := audienceOf(host)
+	if !known {
+		return
+	}
+	audience, _ := auth["audience"].(string)
+	if strings.HasPrefix(audience, "@") || expression.MatchString(audience) {
+		return
+	}
+	given := audience
+	if given == "" {
+		if !strings.EqualFold(authType, "ManagedServiceIdentity") {
+			return
+		}
+		given = msiDefaultAudience
+	}
+	for _, a := range expected {
+		if strings.EqualFold(strings.TrimRight(given, "/"), a) {
+			return
+		}
+	}
+	if audience == "" {
+		l.add(RuleAudience, SeverityError, path+".inputs.authentication", name,
+			"HTTP action %s calls %s with a managed identity token for Azure Resource Manager; set the audience to %s",
+			name, host, expected[0])
+		return
+	}
+	l.add(RuleAudience, SeverityError, path+".inputs.authentication.audience", name,
+		"HTTP action %s calls %s with a token for %s; the audience should be %s", name, host, audience, expected[0])
+}
+
+// audienceOf returns the audiences accepted by the API at a host
+func audienceOf(host string) ([]string, bool) {
+	if strings.HasSuffix(host, ".vault.azure.net") {
+		host = "vault.azure.net"
+	}
+	a, ok := audiences[host]
+	return a, ok
+}
+
+func hasAuthHeader(inputs map[string]interface{}) bool {
+	headers, _ := inputs["headers"].(map[string]interface{})
+	for name := range headers {
+		for _, h := range authHeaders {
+			if strings.EqualFold(name, h) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selfAuthenticating reports whether a URI needs no credentials besides
+// itself: token endpoints, and Logic App callback URLs and other SAS URLs
+// carrying their signature
+func selfAuthenticating(uri, host string) bool {
+	if host == "login.microsoftonline.com" || host == "login.microsoft.com" {
+		return true
+	}
+	return strings.Contains(uri, "sig=")
+}
+
+// walkStrings calls fn for every string in a decoded JSON value, with its
+// path and the key it is the value of
+func walkStrings(path, key string, v interface{}, fn func(path, key, s string)) {
+	switch v := v.(type) {
+	case string:
+		fn(path, key, v)
+	case map[string]interface{}:
+		for _, k := range sortedNames(v) {
+			walkStrings(path+pathKey(k), k, v[k], fn)
+		}
+	case []interface{}:
+		for i, item := range v {
+			walkStrings(fmt.Sprintf("%s[%d]", path, i), key, item, fn)
+		}
+	}
+}
+
+func sortedNames(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,295 @@
+package logicapp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidParameters is returned for parameter values a template cannot
+// be deployed with
+var ErrInvalidParameters = errors.New("invalid logic app parameters")
+
+// MaskedValue stands in for the values of secure parameters in previews
+const MaskedValue = "********"
+
+// secretName matches the names of parameters holding secrets, which are
+// deployed as secure parameters even when a template declares them as
+// plain strings
+var secretName = regexp.MustCompile(`(?i)(secret|password|passwd|apikey|api_key|token|credential)s?$`)
+
+// ParameterIssue is a problem with the value of one parameter
+type ParameterIssue struct {
+	Parameter string `json:"parameter"`
+	Message   string `json:"message"`
+}
+
+// ParametersError lists the problems with the parameter values of a
+// deployment
+type ParametersError struct {
+	Issues []ParameterIssue `json:"errors"`
+}
+
+func (e *ParametersError) Error() string {
+	var msgs []string
+	for _, issue := range e.Issues {
+		msgs = append(msgs, issue.Parameter+": "+issue.Message)
+	}
+	return ErrInvalidParameters.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+func (e *ParametersError) Unwrap() error {
+	return ErrInvalidParameters
+}
+
+// declaration is a parameter as a workflow definition declares it
+type declaration struct {
+	Type         string          `json:"type"`
+	DefaultValue json.RawMessage `json:"defaultValue,omitempty"`
+}
+
+// secure reports whether the values of a declared parameter are secrets
+func (d declaration) secure(name string) bool {
+	t := strings.ToLower(d.Type)
+	return t == "securestring" || t == "secureobject" || (t == "string" && secretName.MatchString(name))
+}
+
+// checkType returns why a value does not fit the type of a parameter, or
+// "" when it does
+func checkType(paramType string, value json.RawMessage) string {
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return "the value is not JSON"
+	}
+	ok := true
+	switch strings.ToLower(paramType) {
+	case "string", "securestring":
+		_, ok = v.(string)
+	case "int":
+		n, isNumber := v.(json.Number)
+		_, err := n.Int64()
+		ok = isNumber && err == nil
+	case "float":
+		_, ok = v.(json.Number)
+	case "bool":
+		_, ok = v.(bool)
+	case "array":
+		_, ok = v.([]interface{})
+	case "object", "secureobject":
+		_, ok = v.(map[string]interface{})
+	}
+	if !ok {
+		return fmt.Sprintf("the value must be of type %s", paramType)
+	}
+	return ""
+}
+
+// Render sets the parameters of a template to values, checking them
+// against the parameters its definition declares. Parameters declared
+// without a default must be given a value, here or in the template.
+// Parameters holding secrets are declared secure in the rendered
+// definition so their values are hidden from run history.
+func Render(t *Template, values map[string]json.RawMessage) (*Template, error) {
+	definition, declared, err := declarations(t)
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[string]json.RawMessage)
+	if len(t.Parameters) > 0 {
+		if err := json.Unmarshal(t.Parameters, &params); err != nil {
+			return nil, fmt.Errorf("%w: parameters must be an object", ErrInvalidTemplate)
+		}
+	}
+
+	var issues []ParameterIssue
+	for _, name := range sortedKeys(values) {
+		d, ok := declared[name]
+		switch {
+		case name == "$connections":
+			issues = append(issues, ParameterIssue{name, "API connections are bound to those of the resource group deployed to"})
+			continue
+		case !ok:
+			issues = append(issues, ParameterIssue{name, "the template has no such parameter"})
+			continue
+		}
+		if msg := checkType(d.Type, values[name]); msg != "" {
+			issues = append(issues, ParameterIssue{name, msg})
+			continue
+		}
+		value, err := json.Marshal(struct {
+			Value json.RawMessage `json:"value"`
+		}{values[name]})
+		if err != nil {
+			return nil, err
+		}
+		params[name] = value
+	}
+
+	changed := false
+	for _, name := range declaredNames(declared) {
+		d := declared[name]
+		_, given := values[name]
+		if _, ok := params[name]; !ok && !given && d.DefaultValue == nil {
+			issues = append(issues, ParameterIssue{name, "a value is required"})
+		}
+		if d.secure(name) && strings.EqualFold(d.Type, "string") {
+			d.Type = "SecureString"
+			declared[name] = d
+			changed = true
+		}
+	}
+	if len(issues) > 0 {
+		return nil, &ParametersError{Issues: issues}
+	}
+
+	out := &Template{Definition: t.Definition}
+	if changed {
+		if out.Definition, err = withDeclarations(definition, declared); err != nil {
+			return nil, err
+		}
+	}
+	if len(params) > 0 {
+		if out.Parameters, err = json.Marshal(params); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Mask replaces the values and defaults of the secure parameters of a
+// template with MaskedValue
+func Mask(t *Template) (*Template, error) {
+	definition, declared, err := declarations(t)
+	if err != nil {
+		return nil, err
+	}
+	masked, _ := json.Marshal(MaskedValue)
+	maskedValue, _ := json.Marshal(struct {
+		Value string `json:"value"`
+	}{MaskedValue})
+
+	params := make(map[string]json.RawMessage)
+	if len(t.Parameters) > 0 {
+		if err := json.Unmarshal(t.Parameters, &params); err != nil {
+			return nil, fmt.Errorf("%w: parameters must be an object", ErrInvalidTemplate)
+		}
+	}
+	for name, d := range declared {
+		if !d.secure(name) {
+			continue
+		}
+		if d.DefaultValue != nil {
+			d.DefaultValue = masked
+			declared[name] = d
+		}
+		if _, ok := params[name]; ok {
+			params[name] = maskedValue
+		}
+	}
+
+	out := &Template{}
+	if out.Definition, err = withDeclarations(definition, declared); err != nil {
+		return nil, err
+	}
+	if len(params) > 0 {
+		if out.Parameters, err = json.Marshal(params); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// SecureParameters returns the names of the parameters of a template whose
+// values are secrets
+func SecureParameters(t *Template) ([]string, error) {
+	_, declared, err := declarations(t)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range declaredNames(declared) {
+		if declared[name].secure(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// declarations returns the definition of a template and the type and
+// default of the parameters it declares, other than $connections
+func declarations(t *Template) (map[string]json.RawMessage, map[string]declaration, error) {
+	var definition map[string]json.RawMessage
+	if err := json.Unmarshal(t.Definition, &definition); err != nil {
+		return nil, nil, fmt.Errorf("%w: definition must be an object", ErrInvalidTemplate)
+	}
+	var raw map[string]json.RawMessage
+	if p, ok := definition["parameters"]; ok {
+		if err := json.Unmarshal(p, &raw); err != nil {
+			return nil, nil, fmt.Errorf("%w: definition parameters must be an object", ErrInvalidTemplate)
+		}
+	}
+	declared := make(map[string]declaration)
+	for name, p := range raw {
+		if name == "$connections" {
+			continue
+		}
+		var d declaration
+		if err := json.Unmarshal(p, &d); err != nil {
+			return nil, nil, fmt.Errorf("%w: parameter %s: %v", ErrInvalidTemplate, name, err)
+		}
+		declared[name] = d
+	}
+	return definition, declared, nil
+}
+
+// withDeclarations returns a definition with the type and default of its
+// declared parameters replaced, keeping the rest of each declaration
+func withDeclarations(definition map[string]json.RawMessage, declared map[string]declaration) (json.RawMessage, error) {
+	var raw map[string]map[string]json.RawMessage
+	if p, ok := definition["parameters"]; ok {
+		if err := json.Unmarshal(p, &raw); err != nil {
+			return nil, fmt.Errorf("%w: definition parameters must be objects", ErrInvalidTemplate)
+		}
+	}
+	for name, d := range declared {
+		if raw[name] == nil {
+			continue
+		}
+		typ, err := json.Marshal(d.Type)
+		if err != nil {
+			return nil, err
+		}
+		raw[name]["type"] = typ
+		if d.DefaultValue != nil {
+			raw[name]["defaultValue"] = d.DefaultValue
+		}
+	}
+	out := make(map[string]json.RawMessage, len(definition))
+	for k, v := range definition {
+		out[k] = v
+	}
+	if raw != nil {
+		p, err := json.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		out["parameters"] = p
+	}
+	return json.Marshal(out)
+}
+
+// declaredNames returns the names of declared parameters in order
+func declaredNames(declared map[string]declaration) []string {
+	names := make([]string, 0, len(declared))
+	for name := range declared {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,395 @@
+package logicapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/graph"
+)
+
+var (
+	// ErrNoIdentity is returned for permission checks of deployments whose
+	// workflow has no managed identity, such as those not yet deployed
+	ErrNoIdentity = errors.New("the deployed workflow has no managed identity")
+	// ErrNoDirectory is returned for grants when the deployer has no
+	// directories to grant app roles in
+	ErrNoDirectory = errors.New("granting app roles is not configured")
+)
+
+// appIDs are the applications whose app roles managed identities are
+// granted, by API
+var appIDs = map[string]string{
+	APIGraph:    graph.AppGraph,
+	APIDefender: graph.AppDefender,
+	APIExchange: graph.AppExchange,
+}
+
+// RoleGrant is an app role of an API
+type RoleGrant struct {
+	API   string `json:"api"`
+	AppID string `json:"appId"`
+	Role  string `json:"role"`
+}
+
+// PermissionPlan is what the managed identity of a template's workflow
+// needs to be granted
+type PermissionPlan struct {
+	// Roles are the app roles its HTTP actions need
+	Roles []RoleGrant `json:"roles"`
+	// Unresolved are managed identity calls that need something other
+	// than an app role, such as a Key Vault access policy, or whose
+	// permission is not known
+	Unresolved []Permission `json:"unresolved"`
+}
+
+// PlanPermissions works out the app roles the HTTP actions of a template
+// authenticating with the workflow's managed identity need. HTTP actions
+// using other credentials are not planned for.
+func PlanPermissions(s *Schema) *PermissionPlan {
+	plan := &PermissionPlan{Roles: []RoleGrant{}, Unresolved: []Permission{}}
+	seen := make(map[RoleGrant]bool)
+	for _, p := range s.Permissions {
+		if !strings.EqualFold(p.Authentication, "ManagedServiceIdentity") {
+			continue
+		}
+		appID, ok := appIDs[p.API]
+		if !ok || p.Permission == "" {
+			plan.Unresolved = append(plan.Unresolved, p)
+			continue
+		}
+		grant := RoleGrant{API: p.API, AppID: appID, Role: p.Permission}
+		if !seen[grant] {
+			seen[grant] = true
+			plan.Roles = append(plan.Roles, grant)
+		}
+	}
+	sortGrants(plan.Roles)
+	return plan
+}
+
+func sortGrants(grants []RoleGrant) {
+	sort.Slice(grants, func(i, j int) bool {
+		if grants[i].API != grants[j].API {
+			return grants[i].API < grants[j].API
+		}
+		return grants[i].Role < grants[j].Role
+	})
+}
+
+// PermissionReport compares the app roles a deployed workflow's managed
+// identity needs with those it was granted
+type PermissionReport struct {
+	DeploymentID string `json:"deploymentId"`
+	LogicAppName string `json:"logicAppName"`
+	ClientID     string `json:"clientId"`
+	PrincipalID  string `json:"principalId,omitempty"`
+	PermissionPlan
+	// Granted are the app roles of the planned APIs the identity holds
+	Granted []RoleGrant `json:"granted"`
+	// Missing are needed roles not granted and Extra granted roles not
+	// needed
+	Missing []RoleGrant `json:"missing"`
+	Extra   []RoleGrant `json:"extra"`
+	InSync  bool        `json:"inSync"`
+	// Error is why the grants could not be read, in drift listings
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// Plan works out the app roles of the template version a deployment is
+// pinned to
+func (d *Deployer) Plan(ctx context.Context, id string) (*PermissionPlan, *Deployment, error) {
+	dep, err := d.Deployment(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	ct, err := d.catalog.Template(ctx, dep.TemplateName, dep.TemplateVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, err := planTemplate(ct)
+	return plan, dep, err
+}
+
+// PlanTemplate works out the app roles of a version of a catalog
+// template, or of its active version when version is empty
+func (d *Deployer) PlanTemplate(ctx context.Context, name, version string) (*PermissionPlan, error) {
+	ct, err := d.catalog.Template(ctx, name, version)
+	if err != nil {
+		return nil, err
+	}
+	return planTemplate(ct)
+}
+
+func planTemplate(ct *CatalogTemplate) (*PermissionPlan, error) {
+	t, err := ct.Template()
+	if err != nil {
+		return nil, err
+	}
+	s, err := Analyze(t)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	return PlanPermissions(s), nil
+}
+
+// Permissions reports the drift between the app roles a deployment's
+// managed identity needs and those granted to it in the client's tenant
+func (d *Deployer) Permissions(ctx context.Context, id string) (*PermissionReport, error) {
+	plan, dep, err := d.Plan(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	report, _, err := d.check(ctx, dep, plan)
+	return report, err
+}
+
+// GrantPermissions grants a deployment's managed identity the app roles it
+// is missing and returns the permissions as they ended
+func (d *Deployer) GrantPermissions(ctx context.Context, id string, actor Actor) (*PermissionReport, error) {
+	dep, err := d.Deployment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return d.grant(ctx, dep, actor)
+}
+
+// grant grants the missing app roles of a deployment and audits the
+// outcome. Deployments without an identity, or a deployer that cannot
+// grant, are not audited.
+func (d *Deployer) grant(ctx context.Context, dep *Deployment, actor Actor) (*PermissionReport, error) {
+	ct, err := d.catalog.Template(ctx, dep.TemplateName, dep.TemplateVersion)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := planTemplate(ct)
+	if err != nil {
+		return nil, err
+	}
+	report, principals, err := d.check(ctx, dep, plan)
+	if errors.Is(err, ErrNoIdentity) || errors.Is(err, ErrNoDirectory) || (err == nil && report.InSync) {
+		return report, err
+	}
+	granted := []string{}
+	if err == nil {
+		granted, err = d.assign(ctx, dep, report.Missing, principals)
+	}
+	d.audit(ctx, dep, ActionGrantPermissions, actor, map[string]interface{}{
+		"principalId": dep.PrincipalID,
+		"granted":     granted,
+	}, err)
+	if err != nil {
+		return report, err
+	}
+	report, _, err = d.check(ctx, dep, plan)
+	return report, err
+}
+
+// assign grants app roles to a deployment's identity, stopping at the
+// first that fails. It returns the roles granted.
+func (d *Deployer) assign(ctx context.Context, dep *Deployment, roles []RoleGrant,
+	principals map[string]*graph.ServicePrincipal) ([]string, error) {
+	granted := []string{}
+	directory, err := d.Directories.Directory(ctx, dep.ClientID)
+	if err != nil {
+		return granted, err
+	}
+	for _, g := range roles {
+		resource, ok := principals[g.AppID]
+		if !ok {
+			return granted, fmt.Errorf("the client tenant has no service principal for %s", g.API)
+		}
+		role, ok := resource.Role(g.Role)
+		if !ok {
+			return granted, fmt.Errorf("%s has no app role %s", g.API, g.Role)
+		}
+		if _, err := directory.AssignAppRole(ctx, dep.PrincipalID, resource.ID, role.ID); err != nil {
+			return granted, fmt.Errorf("granting %s of %s: %w", g.Role, g.API, err)
+		}
+		granted = append(granted, g.Role)
+	}
+	return granted, nil
+}
+
+// check reads the app roles granted to a deployment's identity. It also
+// returns the service principals of the planned APIs the tenant has, by
+// application ID.
+func (d *Deployer) check(ctx context.Context, dep *Deployment,
+	plan *PermissionPlan) (*PermissionReport, map[string]*graph.ServicePrincipal, error) {
+	report := &PermissionReport{
+		DeploymentID:   dep.ID,
+		LogicAppName:   dep.LogicAppName,
+		ClientID:       dep.ClientID,
+		PrincipalID:    dep.PrincipalID,
+		PermissionPlan: *plan,
+		Granted:        []RoleGrant{},
+		Missing:        []RoleGrant{},
+		Extra:          []RoleGrant{},
+		CheckedAt:      time.Now().UTC(),
+	}
+	if dep.PrincipalID == "" {
+		return report, nil, ErrNoIdentity
+	}
+	if d.Directories == nil {
+		return report, nil, ErrNoDirectory
+	}
+	directory, err := d.Directories.Directory(ctx, dep.ClientID)
+	if err != nil {
+		return report, nil, err
+	}
+
+	// Grants name the resource's service principal and app role by ID
+	principals := make(map[string]*graph.ServicePrincipal)
+	byID := make(map[string]*graph.ServicePrincipal)
+	for api, appID := range appIDs {
+		sp, err := directory.ServicePrincipal(ctx, appID)
+		if graph.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return report, nil, fmt.Errorf("reading the service principal of %s: %w", api, err)
+		}
+		principals[appID], byID[sp.ID] = sp, sp
+	}
+	assignments, err := directory.AppRoleAssignments(ctx, dep.PrincipalID)
+	if err != nil {
+		return report, nil, fmt.Errorf("reading the app roles of %s: %w", dep.PrincipalID, err)
+	}
+	held := make(map[RoleGrant]bool)
+	for _, a := range assignments {
+		sp, ok := byID[a.ResourceID]
+		if !ok {
+			continue
+		}
+		for _, role := range sp.AppRoles {
+			if role.ID != a.AppRoleID {
+				continue
+			}
+			for api, appID := range appIDs {
+				if appID == sp.AppID {
+					g := RoleGrant{API: api, AppID: appID, Role: role.Value}
+					held[g] = true
+					report.Granted = append(report.Granted, g)
+				}
+			}
+		}
+	}
+
+	needed := make(map[RoleGrant]bool)
+	for _, g := range plan.Roles {
+		needed[g] = true
+		if !held[g] {
+			report.Missing = append(report.Missing, g)
+		}
+	}
+	for _, g := range report.Granted {
+		if !needed[g] {
+			report.Extra = append(report.Extra, g)
+		}
+	}
+	sortGrants(report.Granted)
+	sortGrants(report.Extra)
+	report.InSync = len(report.Missing) == 0
+	return report, principals, nil
+}
+
+// PermissionDrift checks the permissions of every provisioned deployment
+// with a managed identity, for every client or one. A deployment whose
+// grants cannot be read does not stop the others; its error is in its
+// report.
+func (d *Deployer) PermissionDrift(ctx context.Context, clientID string) ([]*PermissionReport, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id FROM deployment_mgmt.logic_app_deployments
+		WHERE status IN ($1, $2) AND COALESCE(metadata->>'principalId', '') <> ''
+			AND ($3::text = '' OR client_id::text = $3)
+		ORDER BY logic_app_name`, StatusSuccess, StatusDisabled, clientID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	reports := []*PermissionReport{}
+	for _, id := range ids {
+		plan, dep, err := d.Plan(ctx, id)
+		if err != nil {
+			reports = append(reports, &PermissionReport{DeploymentID: id, Error: err.Error(), CheckedAt: time.Now().UTC()})
+			continue
+		}
+		report, _, err := d.check(ctx, dep, plan)
+		if err != nil {
+			report.Error = err.Error()
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// RemediationScript returns a PowerShell script granting app roles to a
+// managed identity with Microsoft Graph PowerShell, for when ClarityXDR
+// cannot grant them itself. Roles already granted are skipped, so the
+// script can be run again. Without a principal ID the script asks for one.
+func RemediationScript(logicAppName, principalID string, roles []RoleGrant) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Grants Logic App %s's managed identity the app roles its HTTP actions need.\n", logicAppName)
+	b.WriteString("# Run in Azure Cloud Shell as a Privileged Role Administrator or Global Administrator.\n\n")
+	if principalID == "" {
+		b.WriteString("$managedIdentityObjectId = Read-Host \"Object ID of the Logic App's managed identity\"\n")
+	} else {
+		fmt.Fprintf(&b, "$managedIdentityObjectId = %s\n", psQuote(principalID))
+	}
+	b.WriteString("$permissions = @(\n")
+	for i, r := range roles {
+		sep := ","
+		if i == len(roles)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(&b, "    @{ AppId = %s; Role = %s }%s\n", psQuote(r.AppID), psQuote(r.Role), sep)
+	}
+	b.WriteString(`)
+
+Connect-MgGraph -Scopes "Application.Read.All","AppRoleAssignment.ReadWrite.All" -NoWelcome
+
+$granted = Get-MgServicePrincipalAppRoleAssignment -ServicePrincipalId $managedIdentityObjectId -All
+foreach ($permission in $permissions) {
+    $resource = Get-MgServicePrincipal -Filter "appId eq '$($permission.AppId)'"
+    if (-not $resource) {
+        Write-Error "The tenant has no service principal for application $($permission.AppId)"
+        continue
+    }
+    $appRole = $resource.AppRoles | Where-Object { $_.Value -eq $permission.Role -and $_.AllowedMemberTypes -contains "Application" }
+    if (-not $appRole) {
+        Write-Error "$($resource.DisplayName) has no app role $($permission.Role)"
+        continue
+    }
+    if ($granted | Where-Object { $_.AppRoleId -eq $appRole.Id -and $_.ResourceId -eq $resource.Id }) {
+        Write-Host "$($permission.Role) on $($resource.DisplayName) is already granted" -ForegroundColor Yellow
+        continue
+    }
+    New-MgServicePrincipalAppRoleAssignment -ServicePrincipalId $managedIdentityObjectId ` + "`" + `
+        -PrincipalId $managedIdentityObjectId -ResourceId $resource.Id -AppRoleId $appRole.Id | Out-Null
+    Write-Host "Granted $($permission.Role) on $($resource.DisplayName)" -ForegroundColor Green
+}
+`)
+	return b.String()
+}
+
+// psQuote quotes a PowerShell string literal
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
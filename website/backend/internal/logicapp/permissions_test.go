@@ -0,0 +1,276 @@
+package logicapp_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/graph"
+	"github.com/ClarityXDR/prod/website/backend/internal/graph/graphtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/logicapp"
+)
+
+// containTemplate isolates a device and signs its user out with the
+// workflow's managed identity
+const containTemplate = `{
+	"definition": {
+		"$schema": "https://schema.management.azure.com/providers/Microsoft.Logic/schemas/2016-06-01/workflowdefinition.json#",
+		"contentVersion": "1.0.0.0",
+		"triggers": {"manual": {"type": "Request", "kind": "Http", "inputs": {"schema": {}}}},
+		"actions": {
+			"Isolate": {"type": "Http", "inputs": {"method": "POST",
+				"uri": "https://api.securitycenter.microsoft.com/api/machines/@{triggerBody()?['machineId']}/isolate",
+				"authentication": {"type": "ManagedServiceIdentity", "audience": "https://api.securitycenter.microsoft.com"}}},
+			"Release": {"type": "Http", "inputs": {"method": "POST",
+				"uri": "https://api.securitycenter.microsoft.com/api/machines/@{triggerBody()?['machineId']}/unisolate",
+				"authentication": {"type": "ManagedServiceIdentity", "audience": "https://api.securitycenter.microsoft.com"}}},
+			"Get_manager": {"type": "Http", "inputs": {"method": "GET",
+				"uri": "https://graph.microsoft.com/v1.0/users/@{triggerBody()?['upn']}/manager",
+				"authentication": {"type": "ManagedServiceIdentity", "audience": "https://graph.microsoft.com"}}},
+			"Revoke_sessions": {"type": "Http", "inputs": {"method": "POST",
+				"uri": "https://graph.microsoft.com/v1.0/users/@{triggerBody()?['upn']}/revokeSignInSessions",
+				"authentication": {"type": "ManagedServiceIdentity", "audience": "https://graph.microsoft.com"}}},
+			"List_groups": {"type": "Http", "inputs": {"method": "GET",
+				"uri": "https://graph.microsoft.com/v1.0/groups",
+				"authentication": {"type": "ManagedServiceIdentity", "audience": "https://graph.microsoft.com"}}},
+			"Get_secret": {"type": "Http", "inputs": {"method": "GET",
+				"uri": "https://soar-kv.vault.azure.net/secrets/pager?api-version=7.4",
+				"authentication": {"type": "ManagedServiceIdentity", "audience": "https://vault.azure.net"}}},
+			"Page": {"type": "Http", "inputs": {"method": "POST",
+				"uri": "https://graph.microsoft.com/v1.0/users/@{triggerBody()?['upn']}/sendMail",
+				"authentication": {"type": "ActiveDirectoryOAuth", "tenant": "contoso.onmicrosoft.com"}}}
+		}
+	}
+}`
+
+// containRoles are the app roles containTemplate needs
+var containRoles = []logicapp.RoleGrant{
+	{API: logicapp.APIGraph, AppID: graph.AppGraph, Role: "User.Read.All"},
+	{API: logicapp.APIGraph, AppID: graph.AppGraph, Role: "User.RevokeSessions.All"},
+	{API: logicapp.APIDefender, AppID: graph.AppDefender, Role: "Machine.Isolate"},
+}
+
+func TestPlanPermissions(t *testing.T) {
+	s, err := logicapp.Analyze(parse(t, containTemplate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := logicapp.PlanPermissions(s)
+	if !sameGrants(plan.Roles, containRoles) {
+		t.Errorf("roles = %+v, want %+v once each, sorted", plan.Roles, containRoles)
+	}
+	// Key Vault needs an access policy and /groups no known role; the
+	// action with an app registration is not planned for
+	unresolved := map[string]string{}
+	for _, p := range plan.Unresolved {
+		unresolved[p.API] = p.Path
+	}
+	if len(plan.Unresolved) != 2 || unresolved[logicapp.APIKeyVault] != "/secrets/pager" ||
+		unresolved[logicapp.APIGraph] != "/groups" {
+		t.Errorf("unresolved = %+v, want the Key Vault and /groups calls", plan.Unresolved)
+	}
+
+	s, err = logicapp.Analyze(parse(t, httpTemplate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan := logicapp.PlanPermissions(s); len(plan.Roles) != 0 || len(plan.Unresolved) != 0 {
+		t.Errorf("plan = %+v, want nothing for a workflow without a managed identity", plan)
+	}
+}
+
+func sameGrants(a, b []logicapp.RoleGrant) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// deployContain deploys contain-host as the named workflow
+func deployContain(t *testing.T, d *logicapp.Deployer, clientID, name string, grant bool) *logicapp.Deployment {
+	t.Helper()
+	ctx := context.Background()
+	dep, err := d.Create(ctx, logicapp.Request{
+		ClientID: clientID, SubscriptionID: subscription, ResourceGroup: group,
+		LogicAppName: name, TemplateName: "contain-host", GrantPermissions: grant,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dep, err = d.Deploy(ctx, dep.ID, logicapp.Actor{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if dep.PrincipalID == "" {
+		t.Fatalf("deployment = %+v, want the workflow's managed identity", dep)
+	}
+	return dep
+}
+
+func TestDeployerGrantPermissions(t *testing.T) {
+	server := newServer(0)
+	defer server.Close()
+	directory := graphtest.NewServer()
+	defer directory.Close()
+	d, _, clientID := newDeployer(t, server)
+	ctx := context.Background()
+	actor := logicapp.Actor{Name: "test"}
+
+	plan, err := d.PlanTemplate(ctx, "contain-host", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameGrants(plan.Roles, containRoles) {
+		t.Errorf("catalog plan = %+v, want %+v", plan.Roles, containRoles)
+	}
+
+	// Without directories nothing can be granted
+	dep := deployContain(t, d, clientID, "contain", true)
+	if _, err := d.Permissions(ctx, dep.ID); !errors.Is(err, logicapp.ErrNoDirectory) {
+		t.Fatalf("err = %v, want ErrNoDirectory", err)
+	}
+	d.Directories = directory.Source()
+
+	// A grant failing after a deployment is audited without failing it
+	dep = deployContain(t, d, clientID, "contain-2", true)
+	if dep.Status != logicapp.StatusSuccess {
+		t.Fatalf("deployment is %s, want %s though its identity is unknown to Graph", dep.Status, logicapp.StatusSuccess)
+	}
+	entries, err := d.Audit(ctx, dep.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 || entries[0].Action != logicapp.ActionGrantPermissions || entries[0].Outcome != logicapp.OutcomeFailed {
+		t.Fatalf("audit = %+v, want the failed grant first", entries)
+	}
+
+	directory.AddPrincipal(dep.PrincipalID, "contain-2")
+	report, err := d.Permissions(ctx, dep.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.InSync || !sameGrants(report.Missing, containRoles) || len(report.Granted) != 0 ||
+		report.PrincipalID != dep.PrincipalID || len(report.Unresolved) != 2 {
+		t.Fatalf("report = %+v, want every planned role missing", report)
+	}
+
+	report, err = d.GrantPermissions(ctx, dep.ID, actor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.InSync || len(report.Missing) != 0 || !sameGrants(report.Granted, containRoles) {
+		t.Fatalf("report = %+v, want every planned role granted", report)
+	}
+	if n := len(directory.Assignments(dep.PrincipalID)); n != len(containRoles) {
+		t.Errorf("Graph holds %d assignments, want %d", n, len(containRoles))
+	}
+	entries, err = d.Audit(ctx, dep.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var details struct {
+		Granted []string `json:"granted"`
+	}
+	if len(entries) == 0 || entries[0].Outcome != logicapp.OutcomeSucceeded {
+		t.Fatalf("audit = %+v, want the grant recorded", entries)
+	}
+	if err := json.Unmarshal(entries[0].Details, &details); err != nil || len(details.Granted) != len(containRoles) {
+		t.Errorf("audit details = %s, want the roles granted", entries[0].Details)
+	}
+
+	// Roles granted outside the plan are extra but keep it in sync; a
+	// grant with nothing missing changes and audits nothing
+	client := directory.Client()
+	defender, err := client.ServicePrincipal(ctx, graph.AppDefender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan, _ := defender.Role("Machine.Scan")
+	if _, err := client.AssignAppRole(ctx, dep.PrincipalID, defender.ID, scan.ID); err != nil {
+		t.Fatal(err)
+	}
+	report, err = d.GrantPermissions(ctx, dep.ID, actor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra := []logicapp.RoleGrant{{API: logicapp.APIDefender, AppID: graph.AppDefender, Role: "Machine.Scan"}}
+	if !report.InSync || !sameGrants(report.Extra, extra) || len(report.Granted) != len(containRoles)+1 {
+		t.Errorf("report = %+v, want in sync with Machine.Scan extra", report)
+	}
+	if n := len(directory.Assignments(dep.PrincipalID)); n != len(containRoles)+1 {
+		t.Errorf("Graph holds %d assignments, want %d", n, len(containRoles)+1)
+	}
+	if again, err := d.Audit(ctx, dep.ID); err != nil || len(again) != len(entries) {
+		t.Errorf("audit has %d entries, want %d", len(again), len(entries))
+	}
+}
+
+func TestDeployerPermissionDrift(t *testing.T) {
+	server := newServer(0)
+	defer server.Close()
+	directory := graphtest.NewServer()
+	defer directory.Close()
+	d, db, clientID := newDeployer(t, server)
+	d.Directories = directory.Source()
+	ctx := context.Background()
+
+	respond := deployRespond(t, d, clientID)
+	if _, err := d.Permissions(ctx, respond.ID); !errors.Is(err, logicapp.ErrNoIdentity) {
+		t.Errorf("err = %v, want ErrNoIdentity for a workflow without one", err)
+	}
+	known := deployContain(t, d, clientID, "contain", false)
+	directory.AddPrincipal(known.PrincipalID, "contain")
+	if _, err := d.GrantPermissions(ctx, known.ID, logicapp.Actor{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	// Drift is any planned role no longer granted, here one never granted
+	drifted := deployContain(t, d, clientID, "contain-2", false)
+	directory.AddPrincipal(drifted.PrincipalID, "contain-2")
+	unknown := deployContain(t, d, clientID, "contain-3", false)
+
+	reports, err := d.PermissionDrift(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("reports = %+v, want one per workflow with an identity", reports)
+	}
+	if r := reports[0]; r.DeploymentID != known.ID || !r.InSync || r.Error != "" {
+		t.Errorf("contain = %+v, want in sync", r)
+	}
+	if r := reports[1]; r.DeploymentID != drifted.ID || r.InSync || !sameGrants(r.Missing, containRoles) {
+		t.Errorf("contain-2 = %+v, want every role missing", r)
+	}
+	if r := reports[2]; r.DeploymentID != unknown.ID || r.Error == "" {
+		t.Errorf("contain-3 = %+v, want the error reading its grants", r)
+	}
+
+	other := dbtest.CreateClient(t, db, "Fabrikam")
+	if reports, err := d.PermissionDrift(ctx, other); err != nil || len(reports) != 0 {
+		t.Errorf("drift of another client = %+v, %v; want none", reports, err)
+	}
+}
+
+func TestRemediationScript(t *testing.T) {
+	script := logicapp.RemediationScript("contain", "4f1e'9c2", containRoles)
+	for _, want := range []string{
+		"$managedIdentityObjectId = '4f1e''9c2'",
+		"@{ AppId = '" + graph.AppGraph + "'; Role = 'User.Read.All' },",
+		"@{ AppId = '" + graph.AppDefender + "'; Role = 'Machine.Isolate' }\n)",
+		"New-MgServicePrincipalAppRoleAssignment",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script lacks %q:\n%s", want, script)
+		}
+	}
+	if script := logicapp.RemediationScript("contain", "", containRoles); !strings.Contains(script, "Read-Host") {
+		t.Errorf("script without a principal does not ask for one:\n%s", script)
+	}
+}
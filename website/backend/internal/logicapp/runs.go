@@ -0,0 +1,592 @@
+package logicapp
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/arm"
+	"github.com/lib/pq"
+)
+
+const (
+	// DefaultMonitorInterval is how often run history is polled in the
+	// background
+	DefaultMonitorInterval = 5 * time.Minute
+	// RunLookback is how far back the first poll of a deployment reaches
+	RunLookback = 7 * 24 * time.Hour
+	// DefaultFailureThreshold is how many runs in a row must fail to raise
+	// an alert
+	DefaultFailureThreshold = 3
+)
+
+// Run statuses Logic Apps reports
+const (
+	RunRunning   = "Running"
+	RunWaiting   = "Waiting"
+	RunSucceeded = "Succeeded"
+	RunFailed    = "Failed"
+	RunCancelled = "Cancelled"
+	RunSkipped   = "Skipped"
+	RunTimedOut  = "TimedOut"
+)
+
+// failedStatuses are the statuses of runs and actions that failed
+var failedStatuses = []string{RunFailed, "Faulted", RunTimedOut, "Aborted"}
+
+// finishedStatuses are the statuses of runs that will not change
+var finishedStatuses = append([]string{RunSucceeded, RunCancelled, RunSkipped, "Ignored"}, failedStatuses...)
+
+// Run is a run of a deployed workflow, stored in
+// deployment_mgmt.logic_app_runs
+type Run struct {
+	ID           string     `json:"id"`
+	DeploymentID string     `json:"deploymentId"`
+	ClientID     string     `json:"clientId,omitempty"`
+	LogicAppName string     `json:"logicAppName,omitempty"`
+	RunName      string     `json:"runName"`
+	Status       string     `json:"status"`
+	TriggerName  string     `json:"triggerName,omitempty"`
+	StartTime    time.Time  `json:"startTime"`
+	EndTime      *time.Time `json:"endTime,omitempty"`
+	ErrorCode    string     `json:"errorCode,omitempty"`
+	ErrorMessage string     `json:"errorMessage,omitempty"`
+	// FailedActions are the actions of a failed run that failed
+	FailedActions []FailedAction `json:"failedActions"`
+}
+
+// FailedAction is an action that failed in a run
+type FailedAction struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Reason returns why a run failed: the error of its first failed action
+// that gave one, else the error of the run
+func (r *Run) Reason() string {
+	for _, a := range r.FailedActions {
+		if a.Message != "" {
+			return a.Name + ": " + a.Message
+		}
+	}
+	if r.ErrorMessage != "" {
+		return r.ErrorMessage
+	}
+	if len(r.FailedActions) > 0 {
+		a := r.FailedActions[0]
+		return a.Name + ": " + a.Status
+	}
+	return r.ErrorCode
+}
+
+// runResource is a run or run action as Resource Manager lists it
+type runResource struct {
+	Name       string `json:"name"`
+	Properties struct {
+		Status    string     `json:"status"`
+		Code      string     `json:"code"`
+		StartTime time.Time  `json:"startTime"`
+		EndTime   *time.Time `json:"endTime"`
+		Error     *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+		Trigger struct {
+			Name string `json:"name"`
+		} `json:"trigger"`
+	} `json:"properties"`
+}
+
+// PollResult summarizes the poll of one deployment
+type PollResult struct {
+	DeploymentID string `json:"deploymentId"`
+	// Runs counts the runs stored or updated
+	Runs   int           `json:"runs"`
+	Failed int           `json:"failed"`
+	Alert  *FailureAlert `json:"alert,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// Monitor copies the run history of deployed workflows into
+// deployment_mgmt.logic_app_runs and raises an alert when a playbook fails
+// repeatedly
+type Monitor struct {
+	db       *sql.DB
+	managers arm.Source
+	hooks    []AlertHook
+	interval time.Duration
+
+	// Threshold is how many runs in a row must fail to raise an alert
+	Threshold int
+
+	mu sync.Mutex
+}
+
+// NewMonitor creates a monitor telling hooks about failing playbooks
+func NewMonitor(db *sql.DB, managers arm.Source, hooks ...AlertHook) *Monitor {
+	return &Monitor{db: db, managers: managers, hooks: hooks, interval: DefaultMonitorInterval,
+		Threshold: DefaultFailureThreshold}
+}
+
+// Run polls every deployed workflow until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		results, err := m.PollAll(ctx)
+		if err != nil {
+			log.Printf("Error polling Logic App runs: %v", err)
+		}
+		for _, r := range results {
+			if r.Error != "" {
+				log.Printf("Error polling runs of Logic App deployment %s: %s", r.DeploymentID, r.Error)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PollAll polls the deployed workflows of every client. A deployment that
+// fails to poll does not stop the others; its error is in its result.
+func (m *Monitor) PollAll(ctx context.Context) ([]PollResult, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT id FROM deployment_mgmt.logic_app_deployments
+		WHERE client_id IS NOT NULL AND status IN ($1, $2)
+		ORDER BY runs_synced_at NULLS FIRST`, StatusSuccess, StatusDisabled)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var results []PollResult
+	for _, id := range ids {
+		result, err := m.Poll(ctx, id)
+		if err != nil && result.Error == "" {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// monitored is a deployment with its run sync state
+type monitored struct {
+	ID, ClientID, ClientName, LogicAppName, TemplateName string
+	SubscriptionID, ResourceGroup                        string
+	SyncedUntil                                          sql.NullTime
+}
+
+// Poll fetches the runs of a deployment's workflow started since its last
+// poll, with the failed actions of failed runs, and raises or resolves its
+// failure alert
+func (m *Monitor) Poll(ctx context.Context, deploymentID string) (PollResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := PollResult{DeploymentID: deploymentID}
+	var t monitored
+	err := m.db.QueryRowContext(ctx, `
+		SELECT d.id, COALESCE(d.client_id::text, ''), COALESCE(c.name, ''), d.logic_app_name, d.template_name,
+			d.subscription_id, d.resource_group, d.runs_synced_until
+		FROM deployment_mgmt.logic_app_deployments d
+		LEFT JOIN client_mgmt.clients c ON c.id = d.client_id
+		WHERE d.id::text = $1`, deploymentID).Scan(&t.ID, &t.ClientID, &t.ClientName, &t.LogicAppName,
+		&t.TemplateName, &t.SubscriptionID, &t.ResourceGroup, &t.SyncedUntil)
+	if err == sql.ErrNoRows {
+		return result, ErrNotFound
+	}
+	if err != nil {
+		return result, err
+	}
+	if t.ClientID == "" {
+		return result, fmt.Errorf("deployment %s belongs to no client", t.ID)
+	}
+
+	since := time.Now().Add(-RunLookback)
+	if t.SyncedUntil.Valid {
+		since = t.SyncedUntil.Time
+	}
+	until, err := m.poll(ctx, &t, since, &result)
+
+	var syncError sql.NullString
+	if err != nil {
+		result.Error = err.Error()
+		syncError = sql.NullString{String: err.Error(), Valid: true}
+	}
+	if _, dbErr := m.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.logic_app_deployments
+		SET runs_synced_until = $2, runs_synced_at = NOW(), run_sync_error = $3
+		WHERE id = $1`, t.ID, until, syncError); dbErr != nil && err == nil {
+		err = dbErr
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Alert, err = m.evaluate(ctx, &t)
+	return result, err
+}
+
+// poll stores the runs started since a time and returns where the next
+// poll resumes: the start of the earliest run still in progress, else of
+// the latest run. Runs already stored as finished are skipped.
+func (m *Monitor) poll(ctx context.Context, t *monitored, since time.Time, result *PollResult) (time.Time, error) {
+	manager, err := m.managers.Manager(ctx, t.ClientID)
+	if err != nil {
+		return since, err
+	}
+	runsID := WorkflowID(t.SubscriptionID, t.ResourceGroup, t.LogicAppName) + "/runs"
+	query := url.Values{"$filter": {"startTime ge " + since.UTC().Format(time.RFC3339)}}
+	var listed []runResource
+	err = manager.List(ctx, runsID, WorkflowAPIVersion, query, func(item json.RawMessage) error {
+		var r runResource
+		if err := json.Unmarshal(item, &r); err != nil {
+			return err
+		}
+		listed = append(listed, r)
+		return nil
+	})
+	if arm.IsNotFound(err) {
+		return since, fmt.Errorf("workflow %s was not found in resource group %s", t.LogicAppName, t.ResourceGroup)
+	}
+	if err != nil {
+		return since, fmt.Errorf("failed to list runs: %v", err)
+	}
+
+	known, err := m.finishedRuns(ctx, t.ID, since)
+	if err != nil {
+		return since, err
+	}
+	sort.Slice(listed, func(i, j int) bool {
+		return listed[i].Properties.StartTime.Before(listed[j].Properties.StartTime)
+	})
+	until := since
+	var open *time.Time
+	resume := func() time.Time {
+		if open != nil && open.Before(until) {
+			return *open
+		}
+		return until
+	}
+	for _, r := range listed {
+		start := r.Properties.StartTime
+		if !contains(finishedStatuses, r.Properties.Status) && (open == nil || start.Before(*open)) {
+			open = &start
+		}
+		if start.After(until) {
+			until = start
+		}
+		if known[r.Name] == r.Properties.Status {
+			continue
+		}
+
+		run := &Run{
+			DeploymentID:  t.ID,
+			ClientID:      t.ClientID,
+			RunName:       r.Name,
+			Status:        r.Properties.Status,
+			TriggerName:   r.Properties.Trigger.Name,
+			StartTime:     start,
+			EndTime:       r.Properties.EndTime,
+			FailedActions: []FailedAction{},
+		}
+		if e := r.Properties.Error; e != nil {
+			run.ErrorCode, run.ErrorMessage = e.Code, e.Message
+		}
+		if contains(failedStatuses, run.Status) {
+			result.Failed++
+			if run.FailedActions, err = failedActions(ctx, manager, runsID+"/"+r.Name); err != nil {
+				return resume(), fmt.Errorf("failed to read actions of run %s: %v", r.Name, err)
+			}
+		}
+		if err := m.store(ctx, run); err != nil {
+			return resume(), fmt.Errorf("failed to store run %s: %v", r.Name, err)
+		}
+		result.Runs++
+	}
+	return resume(), nil
+}
+
+// failedActions lists the actions of a run that failed
+func failedActions(ctx context.Context, manager arm.ResourceManager, runID string) ([]FailedAction, error) {
+	actions := []FailedAction{}
+	err := manager.List(ctx, runID+"/actions", WorkflowAPIVersion, nil, func(item json.RawMessage) error {
+		var a runResource
+		if err := json.Unmarshal(item, &a); err != nil {
+			return err
+		}
+		if !contains(failedStatuses, a.Properties.Status) {
+			return nil
+		}
+		failed := FailedAction{Name: a.Name, Status: a.Properties.Status, Code: a.Properties.Code}
+		if e := a.Properties.Error; e != nil {
+			failed.Message = e.Message
+			if e.Code != "" {
+				failed.Code = e.Code
+			}
+		}
+		actions = append(actions, failed)
+		return nil
+	})
+	return actions, err
+}
+
+// finishedRuns returns the status of the finished runs of a deployment
+// stored since a time, by run name
+func (m *Monitor) finishedRuns(ctx context.Context, deploymentID string, since time.Time) (map[string]string, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT run_name, status FROM deployment_mgmt.logic_app_runs
+		WHERE deployment_id = $1 AND start_time >= $2 AND status = ANY($3)`,
+		deploymentID, since, pq.Array(finishedStatuses))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	known := make(map[string]string)
+	for rows.Next() {
+		var name, status string
+		if err := rows.Scan(&name, &status); err != nil {
+			return nil, err
+		}
+		known[name] = status
+	}
+	return known, rows.Err()
+}
+
+// store upserts a run
+func (m *Monitor) store(ctx context.Context, run *Run) error {
+	actions, err := json.Marshal(run.FailedActions)
+	if err != nil {
+		return err
+	}
+	return m.db.QueryRowContext(ctx, `
+		INSERT INTO deployment_mgmt.logic_app_runs
+			(deployment_id, client_id, run_name, status, trigger_name, start_time, end_time,
+			 error_code, error_message, failed_actions, synced_at)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5, $6, $7, $8, $9, $10, NOW())
+		ON CONFLICT (deployment_id, run_name) DO UPDATE SET
+			status = EXCLUDED.status, trigger_name = EXCLUDED.trigger_name, start_time = EXCLUDED.start_time,
+			end_time = EXCLUDED.end_time, error_code = EXCLUDED.error_code,
+			error_message = EXCLUDED.error_message, failed_actions = EXCLUDED.failed_actions,
+			synced_at = NOW()
+		RETURNING id`,
+		run.DeploymentID, run.ClientID, run.RunName, run.Status, nullString(run.TriggerName), run.StartTime,
+		run.EndTime, nullString(run.ErrorCode), nullString(run.ErrorMessage), actions).Scan(&run.ID)
+}
+
+// RunFilter selects stored runs
+type RunFilter struct {
+	DeploymentID string
+	ClientID     string
+	Status       string
+	Limit        int
+	Offset       int
+}
+
+// Runs returns stored runs, newest first
+func (m *Monitor) Runs(ctx context.Context, f RunFilter) ([]*Run, error) {
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 100
+	}
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT r.id, r.deployment_id, COALESCE(r.client_id::text, ''), d.logic_app_name, r.run_name, r.status,
+			COALESCE(r.trigger_name, ''), r.start_time, r.end_time, COALESCE(r.error_code, ''),
+			COALESCE(r.error_message, ''), r.failed_actions
+		FROM deployment_mgmt.logic_app_runs r
+		JOIN deployment_mgmt.logic_app_deployments d ON d.id = r.deployment_id
+		WHERE ($1::text = '' OR r.deployment_id::text = $1)
+			AND ($2::text = '' OR r.client_id::text = $2)
+			AND ($3::text = '' OR r.status = $3)
+		ORDER BY r.start_time DESC
+		LIMIT $4 OFFSET $5`, f.DeploymentID, f.ClientID, f.Status, f.Limit, f.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*Run{}
+	for rows.Next() {
+		var r Run
+		var end sql.NullTime
+		var actions []byte
+		if err := rows.Scan(&r.ID, &r.DeploymentID, &r.ClientID, &r.LogicAppName, &r.RunName, &r.Status,
+			&r.TriggerName, &r.StartTime, &end, &r.ErrorCode, &r.ErrorMessage, &actions); err != nil {
+			return nil, err
+		}
+		if end.Valid {
+			r.EndTime = &end.Time
+		}
+		if err := json.Unmarshal(actions, &r.FailedActions); err != nil {
+			return nil, err
+		}
+		runs = append(runs, &r)
+	}
+	return runs, rows.Err()
+}
+
+// RunStats are the outcomes of runs. The success rate counts finished runs
+// that succeeded or failed; it is 0 without any.
+type RunStats struct {
+	Runs        int         `json:"runs"`
+	Succeeded   int         `json:"succeeded"`
+	Failed      int         `json:"failed"`
+	SuccessRate float64     `json:"successRate"`
+	LastFailure *RunFailure `json:"lastFailure,omitempty"`
+}
+
+// RunFailure is the latest failed run of a playbook
+type RunFailure struct {
+	DeploymentID string    `json:"deploymentId"`
+	LogicAppName string    `json:"logicAppName"`
+	RunName      string    `json:"runName"`
+	Reason       string    `json:"reason"`
+	At           time.Time `json:"at"`
+}
+
+// PlaybookStats are the run outcomes of one deployment
+type PlaybookStats struct {
+	DeploymentID string `json:"deploymentId"`
+	LogicAppName string `json:"logicAppName"`
+	TemplateName string `json:"templateName"`
+	RunStats
+	LastPollAt *time.Time `json:"lastPollAt,omitempty"`
+	PollError  string     `json:"pollError,omitempty"`
+	Alerting   bool       `json:"alerting"`
+}
+
+// ClientStats are the run outcomes of a client's playbooks
+type ClientStats struct {
+	ClientID   string `json:"clientId"`
+	ClientName string `json:"clientName"`
+	RunStats
+	Playbooks []PlaybookStats `json:"playbooks"`
+}
+
+func (s *RunStats) rate() {
+	if finished := s.Succeeded + s.Failed; finished > 0 {
+		s.SuccessRate = float64(s.Succeeded) / float64(finished)
+	}
+}
+
+// Stats returns the outcomes of the runs each client's deployed playbooks
+// started since a time, for every client or one. The last failure is the
+// latest ever polled.
+func (m *Monitor) Stats(ctx context.Context, clientID string, since time.Time) ([]ClientStats, error) {
+	failures, err := m.lastFailures(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT d.id, COALESCE(d.client_id::text, ''), COALESCE(c.name, ''), d.logic_app_name, d.template_name,
+			d.runs_synced_at, COALESCE(d.run_sync_error, ''),
+			COUNT(r.id), COUNT(r.id) FILTER (WHERE r.status = $2), COUNT(r.id) FILTER (WHERE r.status = ANY($3)),
+			EXISTS (SELECT 1 FROM deployment_mgmt.logic_app_alerts a
+				WHERE a.deployment_id = d.id AND a.resolved_at IS NULL)
+		FROM deployment_mgmt.logic_app_deployments d
+		LEFT JOIN client_mgmt.clients c ON c.id = d.client_id
+		LEFT JOIN deployment_mgmt.logic_app_runs r ON r.deployment_id = d.id AND r.start_time >= $1
+		WHERE d.client_id IS NOT NULL AND d.status IN ($4, $5) AND ($6::text = '' OR d.client_id::text = $6)
+		GROUP BY d.id, c.name
+		ORDER BY c.name, d.logic_app_name`,
+		since, RunSucceeded, pq.Array(failedStatuses), StatusSuccess, StatusDisabled, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []ClientStats{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var p PlaybookStats
+		var client, clientName string
+		var polledAt sql.NullTime
+		if err := rows.Scan(&p.DeploymentID, &client, &clientName, &p.LogicAppName, &p.TemplateName, &polledAt,
+			&p.PollError, &p.Runs, &p.Succeeded, &p.Failed, &p.Alerting); err != nil {
+			return nil, err
+		}
+		if polledAt.Valid {
+			p.LastPollAt = &polledAt.Time
+		}
+		p.LastFailure = failures[p.DeploymentID]
+		p.rate()
+
+		i, ok := index[client]
+		if !ok {
+			i = len(clients)
+			index[client] = i
+			clients = append(clients, ClientStats{ClientID: client, ClientName: clientName, Playbooks: []PlaybookStats{}})
+		}
+		c := &clients[i]
+		c.Runs += p.Runs
+		c.Succeeded += p.Succeeded
+		c.Failed += p.Failed
+		if p.LastFailure != nil && (c.LastFailure == nil || p.LastFailure.At.After(c.LastFailure.At)) {
+			c.LastFailure = p.LastFailure
+		}
+		c.Playbooks = append(c.Playbooks, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range clients {
+		clients[i].rate()
+	}
+	return clients, nil
+}
+
+// lastFailures returns the latest failed run of each deployment, for every
+// client or one
+func (m *Monitor) lastFailures(ctx context.Context, clientID string) (map[string]*RunFailure, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT DISTINCT ON (r.deployment_id) r.deployment_id, d.logic_app_name, r.run_name, r.start_time,
+			COALESCE(r.error_code, ''), COALESCE(r.error_message, ''), r.failed_actions
+		FROM deployment_mgmt.logic_app_runs r
+		JOIN deployment_mgmt.logic_app_deployments d ON d.id = r.deployment_id
+		WHERE r.status = ANY($1) AND ($2::text = '' OR r.client_id::text = $2)
+		ORDER BY r.deployment_id, r.start_time DESC`, pq.Array(failedStatuses), clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := make(map[string]*RunFailure)
+	for rows.Next() {
+		var f RunFailure
+		var run Run
+		var actions []byte
+		if err := rows.Scan(&f.DeploymentID, &f.LogicAppName, &f.RunName, &f.At, &run.ErrorCode,
+			&run.ErrorMessage, &actions); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(actions, &run.FailedActions); err != nil {
+			return nil, err
+		}
+		f.Reason = run.Reason()
+		failures[f.DeploymentID] = &f
+	}
+	return failures, rows.Err()
+}
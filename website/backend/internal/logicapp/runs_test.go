@@ -0,0 +1,191 @@
+package logicapp_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/arm/armtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/logicapp"
+)
+
+// deployRespond deploys the http-respond template as the workflow respond
+func deployRespond(t *testing.T, d *logicapp.Deployer, clientID string) *logicapp.Deployment {
+	t.Helper()
+	ctx := context.Background()
+	dep, err := d.Create(ctx, logicapp.Request{
+		ClientID: clientID, SubscriptionID: subscription, ResourceGroup: group,
+		LogicAppName: "respond", TemplateName: "http-respond",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dep, err = d.Deploy(ctx, dep.ID, logicapp.Actor{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	return dep
+}
+
+// addRun stores a run of a deployment's workflow as Resource Manager lists
+// it. Each failed action is stored with an error naming it.
+func addRun(server *armtest.Server, dep *logicapp.Deployment, name, status string, start time.Time,
+	failedActions ...string) {
+	properties := map[string]interface{}{
+		"status":    status,
+		"startTime": start.UTC().Format(time.RFC3339),
+		"trigger":   map[string]interface{}{"name": "manual"},
+	}
+	if status != logicapp.RunRunning {
+		properties["endTime"] = start.Add(time.Minute).UTC().Format(time.RFC3339)
+	}
+	runID := dep.ResourceID + "/runs/" + name
+	if len(failedActions) > 0 {
+		properties["error"] = map[string]interface{}{"code": "ActionFailed", "message": "An action failed."}
+		server.AddResource(runID+"/actions/Response", map[string]interface{}{
+			"properties": map[string]interface{}{"status": logicapp.RunSucceeded},
+		})
+	}
+	server.AddResource(runID, map[string]interface{}{"properties": properties})
+	for _, action := range failedActions {
+		server.AddResource(runID+"/actions/"+action, map[string]interface{}{
+			"properties": map[string]interface{}{
+				"status": logicapp.RunFailed,
+				"code":   "BadRequest",
+				"error":  map[string]interface{}{"message": action + " failed"},
+			},
+		})
+	}
+}
+
+func TestMonitorPoll(t *testing.T) {
+	server := newServer(0)
+	defer server.Close()
+	d, db, clientID := newDeployer(t, server)
+	ctx := context.Background()
+	dep := deployRespond(t, d, clientID)
+	m := logicapp.NewMonitor(db, server.Source())
+
+	start := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	addRun(server, dep, "run-1", logicapp.RunSucceeded, start)
+	addRun(server, dep, "run-2", logicapp.RunFailed, start.Add(time.Hour), "Isolate")
+	addRun(server, dep, "run-3", logicapp.RunRunning, start.Add(2*time.Hour))
+
+	result, err := m.Poll(ctx, dep.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Runs != 3 || result.Failed != 1 || result.Alert != nil || result.Error != "" {
+		t.Fatalf("poll = %+v, want 3 runs stored, 1 failed and no alert", result)
+	}
+	runs, err := m.Runs(ctx, logicapp.RunFilter{DeploymentID: dep.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 3 || runs[0].RunName != "run-3" || runs[2].RunName != "run-1" {
+		t.Fatalf("runs = %+v, want the three newest first", runs)
+	}
+	failed := runs[1]
+	if failed.Status != logicapp.RunFailed || failed.ErrorCode != "ActionFailed" || failed.TriggerName != "manual" ||
+		len(failed.FailedActions) != 1 || failed.FailedActions[0].Name != "Isolate" ||
+		failed.FailedActions[0].Code != "BadRequest" {
+		t.Errorf("failed run = %+v, want its failed action only", failed)
+	}
+	if reason := failed.Reason(); reason != "Isolate: Isolate failed" {
+		t.Errorf("reason = %q, want the failed action's error", reason)
+	}
+	if runs[0].EndTime != nil || len(runs[2].FailedActions) != 0 {
+		t.Errorf("runs = %+v, want no end time while running and no failed actions for a success", runs)
+	}
+
+	// A run still in progress is updated once it finishes
+	addRun(server, dep, "run-3", logicapp.RunSucceeded, start.Add(2*time.Hour))
+	if _, err := m.Poll(ctx, dep.ID); err != nil {
+		t.Fatal(err)
+	}
+	runs, err = m.Runs(ctx, logicapp.RunFilter{DeploymentID: dep.ID, Status: logicapp.RunSucceeded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].RunName != "run-3" || runs[0].EndTime == nil {
+		t.Errorf("succeeded runs = %+v, want run-3 finished", runs)
+	}
+
+	stats, err := m.Stats(ctx, clientID, start.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || len(stats[0].Playbooks) != 1 {
+		t.Fatalf("stats = %+v, want the client's one playbook", stats)
+	}
+	s := stats[0]
+	if s.Runs != 3 || s.Succeeded != 2 || s.Failed != 1 || s.SuccessRate < 0.66 || s.SuccessRate > 0.67 ||
+		s.LastFailure == nil || s.LastFailure.RunName != "run-2" || s.LastFailure.Reason != "Isolate: Isolate failed" {
+		t.Errorf("client stats = %+v", s)
+	}
+	if p := s.Playbooks[0]; p.LastPollAt == nil || p.PollError != "" || p.Alerting {
+		t.Errorf("playbook stats = %+v, want polled without an error or alert", p)
+	}
+}
+
+func TestMonitorPollFailure(t *testing.T) {
+	server := newServer(0)
+	defer server.Close()
+	d, db, clientID := newDeployer(t, server)
+	ctx := context.Background()
+	dep := deployRespond(t, d, clientID)
+	m := logicapp.NewMonitor(db, server.Source())
+	addRun(server, dep, "run-1", logicapp.RunFailed, time.Now().Add(-time.Hour), "Isolate")
+
+	if _, err := m.Poll(ctx, "00000000-0000-0000-0000-000000000000"); !errors.Is(err, logicapp.ErrNotFound) {
+		t.Errorf("polling an unknown deployment: err = %v, want ErrNotFound", err)
+	}
+
+	// Failing to read the failed actions stores nothing and is recorded
+	server.Fail["/runs/run-1/actions"] = http.StatusInternalServerError
+	result, err := m.Poll(ctx, dep.ID)
+	if err == nil || result.Runs != 0 || !strings.Contains(result.Error, "run-1") {
+		t.Fatalf("poll = %+v, %v; want the actions error", result, err)
+	}
+	stats, err := m.Stats(ctx, clientID, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Playbooks[0].PollError == "" || stats[0].Runs != 0 {
+		t.Fatalf("stats = %+v, want the poll error and no runs", stats)
+	}
+
+	// PollAll reports the failure of each deployment in its result
+	delete(server.Fail, "/runs/run-1/actions")
+	server.Fail["/runs"] = http.StatusInternalServerError
+	results, err := m.PollAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].DeploymentID != dep.ID || results[0].Error == "" {
+		t.Fatalf("poll all = %+v, want the list error", results)
+	}
+
+	// The next poll succeeds and clears the error
+	delete(server.Fail, "/runs")
+	if result, err = m.Poll(ctx, dep.ID); err != nil || result.Runs != 1 || result.Failed != 1 {
+		t.Fatalf("poll = %+v, %v; want the failed run stored", result, err)
+	}
+	stats, err = m.Stats(ctx, clientID, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := stats[0].Playbooks[0]; p.PollError != "" || p.Failed != 1 {
+		t.Errorf("playbook stats = %+v, want the error cleared and the failure counted", p)
+	}
+
+	// A workflow deleted outside the deployment is reported as such
+	if err := logicapp.DeleteWorkflow(ctx, server.Client(), subscription, group, "respond"); err != nil {
+		t.Fatal(err)
+	}
+	if result, err = m.Poll(ctx, dep.ID); err == nil || !strings.Contains(result.Error, "was not found") {
+		t.Errorf("poll = %+v, %v; want the workflow reported missing", result, err)
+	}
+}
@@ -0,0 +1,362 @@
+package logicapp
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema is what deploying a template asks for: the input its triggers
+// take, the parameters of its definition, the API connections it uses and
+// the API permissions its HTTP actions need
+type Schema struct {
+	Triggers    []Trigger    `json:"triggers"`
+	Parameters  []Parameter  `json:"parameters"`
+	Connections []Connection `json:"connections"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// Trigger is a trigger of a workflow
+type Trigger struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Kind string `json:"kind,omitempty"`
+	// Connection is the API connection of connector triggers, such as
+	// Microsoft Sentinel incident and entity triggers
+	Connection string `json:"connection,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Method     string `json:"method,omitempty"`
+	// RequestSchema is the JSON schema of the body of request triggers
+	RequestSchema json.RawMessage `json:"requestSchema,omitempty"`
+	Recurrence    json.RawMessage `json:"recurrence,omitempty"`
+}
+
+// Parameter is a parameter of a workflow definition. Parameters without a
+// default value must be given when deploying.
+type Parameter struct {
+	Name         string          `json:"name"`
+	Type         string          `json:"type"`
+	DefaultValue json.RawMessage `json:"defaultValue,omitempty"`
+	Description  string          `json:"description,omitempty"`
+	Required     bool            `json:"required"`
+	Secure       bool            `json:"secure"`
+}
+
+// Connection is an entry of the $connections parameter: the API connection
+// resource a workflow uses for a managed connector
+type Connection struct {
+	Name           string `json:"name"`
+	API            string `json:"api"`
+	ConnectionName string `json:"connectionName,omitempty"`
+}
+
+// Permission is an API permission an HTTP action of a workflow needs.
+// Permission is empty for endpoints with no known permission.
+type Permission struct {
+	API            string `json:"api"`
+	Permission     string `json:"permission,omitempty"`
+	Method         string `json:"method"`
+	Path           string `json:"path"`
+	Authentication string `json:"authentication"`
+}
+
+// APIs whose permissions are worked out for HTTP actions
+const (
+	APIGraph    = "Microsoft Graph"
+	APIDefender = "WindowsDefenderATP"
+	APIKeyVault = "Azure Key Vault"
+	APIExchange = "Office 365 Exchange Online"
+)
+
+// apiHosts are the hosts of the APIs permissions are worked out for
+var apiHosts = map[string]string{
+	"graph.microsoft.com":              APIGraph,
+	"api.securitycenter.windows.com":   APIDefender,
+	"api.securitycenter.microsoft.com": APIDefender,
+	"api.security.microsoft.com":       APIDefender,
+	"outlook.office365.com":            APIExchange,
+}
+
+// permissionRule gives the permission an API needs for requests of a
+// method to paths matching a pattern. Paths are matched with their API
+// version removed and expressions replaced by {id}.
+type permissionRule struct {
+	api        string
+	methods    string
+	path       *regexp.Regexp
+	permission string
+}
+
+var permissionRules = []permissionRule{
+	{APIGraph, "POST", regexp.MustCompile(`^/users/\{id\}/revokeSignInSessions$`), "User.RevokeSessions.All"},
+	{APIGraph, "GET", regexp.MustCompile(`^/users/\{id\}/manager$`), "User.Read.All"},
+	{APIGraph, "POST", regexp.MustCompile(`^/users/\{id\}/sendMail$`), "Mail.Send"},
+	{APIGraph, "PATCH", regexp.MustCompile(`^/users/\{id\}$`), "User.ReadWrite.All"},
+	{APIGraph, "GET", regexp.MustCompile(`^/users(/|$)`), "User.Read.All"},
+	{APIGraph, "GET", regexp.MustCompile(`^/identity/conditionalAccess/`), "Policy.Read.All"},
+	{APIGraph, "PATCH POST PUT DELETE", regexp.MustCompile(`^/identity/conditionalAccess/`), "Policy.ReadWrite.ConditionalAccess"},
+	{APIGraph, "GET", regexp.MustCompile(`^/security/incidents`), "SecurityIncident.Read.All"},
+	{APIGraph, "PATCH POST", regexp.MustCompile(`^/security/incidents`), "SecurityIncident.ReadWrite.All"},
+	{APIGraph, "GET", regexp.MustCompile(`^/security/alerts_v2`), "SecurityAlert.Read.All"},
+	{APIGraph, "GET", regexp.MustCompile(`^/security/threatIntelligence/`), "ThreatIntelligence.Read.All"},
+	{APIGraph, "GET", regexp.MustCompile(`^/contacts`), "OrgContact.Read.All"},
+	{APIDefender, "GET", regexp.MustCompile(`^/api/indicators`), "Ti.Read.All"},
+	{APIDefender, "POST PATCH DELETE", regexp.MustCompile(`^/api/indicators`), "Ti.ReadWrite.All"},
+	{APIDefender, "POST", regexp.MustCompile(`^/api/machines/\{id\}/(isolate|unisolate)$`), "Machine.Isolate"},
+	{APIDefender, "POST", regexp.MustCompile(`^/api/machines/\{id\}/runAntiVirusScan$`), "Machine.Scan"},
+	{APIDefender, "POST", regexp.MustCompile(`^/api/machines/\{id\}/(restrictCodeExecution|unrestrictCodeExecution)$`), "Machine.RestrictExecution"},
+	{APIDefender, "POST", regexp.MustCompile(`^/api/machines/\{id\}/collectInvestigationPackage$`), "Machine.CollectForensics"},
+	{APIDefender, "POST", regexp.MustCompile(`^/api/machines/\{id\}/offboard$`), "Machine.Offboard"},
+	{APIDefender, "GET", regexp.MustCompile(`^/api/machines`), "Machine.Read.All"},
+	{APIDefender, "GET", regexp.MustCompile(`^/api/machineactions`), "Machine.Read.All"},
+	{APIDefender, "GET", regexp.MustCompile(`^/api/alerts`), "Alert.Read.All"},
+	{APIDefender, "POST", regexp.MustCompile(`^/api/advancedqueries/run$`), "AdvancedQuery.Read.All"},
+	{APIKeyVault, "GET", regexp.MustCompile(`^/secrets/`), "secrets/get"},
+	{APIExchange, "GET POST", regexp.MustCompile(`^/adminapi/`), "Exchange.ManageAsApp"},
+}
+
+var (
+	// expression is a Logic App expression embedded in a string
+	expression = regexp.MustCompile(`@\{[^{}]*(?:\{[^{}]*\}[^{}]*)*\}`)
+	// apiVersion is the version segment leading a Graph path
+	apiVersion = regexp.MustCompile(`^/(v1\.0|beta)(/|$)`)
+	// connectionRef is a reference to an entry of $connections
+	connectionRef = regexp.MustCompile(`\$connections'\)\['([^']+)'\]`)
+	// connectionSuffix numbers the connections of a workflow to one API
+	connectionSuffix = regexp.MustCompile(`[-_]\d+$`)
+)
+
+// Analyze works out the schema of a template
+func Analyze(t *Template) (*Schema, error) {
+	var definition struct {
+		Triggers   map[string]json.RawMessage `json:"triggers"`
+		Actions    map[string]json.RawMessage `json:"actions"`
+		Parameters map[string]json.RawMessage `json:"parameters"`
+	}
+	if err := json.Unmarshal(t.Definition, &definition); err != nil {
+		return nil, err
+	}
+	s := &Schema{
+		Triggers:    []Trigger{},
+		Parameters:  []Parameter{},
+		Connections: []Connection{},
+		Permissions: []Permission{},
+	}
+
+	for _, name := range sortedKeys(definition.Triggers) {
+		var trigger struct {
+			Type       string          `json:"type"`
+			Kind       string          `json:"kind"`
+			Recurrence json.RawMessage `json:"recurrence"`
+			Inputs     struct {
+				Path   string          `json:"path"`
+				Method string          `json:"method"`
+				Schema json.RawMessage `json:"schema"`
+				Host   struct {
+					Connection struct {
+						Name string `json:"name"`
+					} `json:"connection"`
+				} `json:"host"`
+			} `json:"inputs"`
+		}
+		if err := json.Unmarshal(definition.Triggers[name], &trigger); err != nil {
+			return nil, err
+		}
+		tr := Trigger{
+			Name:          name,
+			Type:          trigger.Type,
+			Kind:          trigger.Kind,
+			Path:          trigger.Inputs.Path,
+			Method:        strings.ToUpper(trigger.Inputs.Method),
+			RequestSchema: trigger.Inputs.Schema,
+			Recurrence:    trigger.Recurrence,
+		}
+		if m := connectionRef.FindStringSubmatch(trigger.Inputs.Host.Connection.Name); m != nil {
+			tr.Connection = m[1]
+		}
+		s.Triggers = append(s.Triggers, tr)
+	}
+
+	for _, name := range sortedKeys(definition.Parameters) {
+		if name == "$connections" {
+			continue
+		}
+		var p struct {
+			Type         string          `json:"type"`
+			DefaultValue json.RawMessage `json:"defaultValue"`
+			Metadata     struct {
+				Description string `json:"description"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(definition.Parameters[name], &p); err != nil {
+			return nil, err
+		}
+		s.Parameters = append(s.Parameters, Parameter{
+			Name:         name,
+			Type:         p.Type,
+			DefaultValue: p.DefaultValue,
+			Description:  p.Metadata.Description,
+			Required:     p.DefaultValue == nil,
+			Secure:       declaration{Type: p.Type}.secure(name),
+		})
+	}
+
+	// Connections are those given in $connections and any the definition
+	// refers to without one, whose API is guessed from the name
+	var values struct {
+		Connections struct {
+			Value map[string]json.RawMessage `json:"value"`
+		} `json:"$connections"`
+	}
+	if len(t.Parameters) > 0 {
+		if err := json.Unmarshal(t.Parameters, &values); err != nil {
+			return nil, err
+		}
+	}
+	seen := make(map[string]bool)
+	for _, name := range sortedKeys(values.Connections.Value) {
+		var c struct {
+			ID             string `json:"id"`
+			ConnectionName string `json:"connectionName"`
+		}
+		if err := json.Unmarshal(values.Connections.Value[name], &c); err != nil {
+			return nil, err
+		}
+		api := c.ID[strings.LastIndex(c.ID, "/")+1:]
+		s.Connections = append(s.Connections, Connection{Name: name, API: strings.ToLower(api), ConnectionName: c.ConnectionName})
+		seen[name] = true
+	}
+	for _, m := range connectionRef.FindAllStringSubmatch(string(t.Definition), -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			s.Connections = append(s.Connections, Connection{Name: m[1], API: strings.ToLower(connectionSuffix.ReplaceAllString(m[1], ""))})
+		}
+	}
+
+	var walkErr error
+	walkActions(definition.Actions, func(action json.RawMessage) {
+		if walkErr != nil {
+			return
+		}
+		p, err := httpPermission(action)
+		if err != nil {
+			walkErr = err
+		} else if p != nil && !containsPermission(s.Permissions, *p) {
+			s.Permissions = append(s.Permissions, *p)
+		}
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	return s, nil
+}
+
+// walkActions calls fn for every action of a scope, including the actions
+// nested in conditions, switches, loops and scopes
+func walkActions(actions map[string]json.RawMessage, fn func(json.RawMessage)) {
+	for _, name := range sortedKeys(actions) {
+		action := actions[name]
+		fn(action)
+		var nested struct {
+			Actions map[string]json.RawMessage `json:"actions"`
+			Else    struct {
+				Actions map[string]json.RawMessage `json:"actions"`
+			} `json:"else"`
+			Cases   map[string]json.RawMessage `json:"cases"`
+			Default struct {
+				Actions map[string]json.RawMessage `json:"actions"`
+			} `json:"default"`
+		}
+		if json.Unmarshal(action, &nested) != nil {
+			continue
+		}
+		walkActions(nested.Actions, fn)
+		walkActions(nested.Else.Actions, fn)
+		for _, c := range sortedKeys(nested.Cases) {
+			var branch struct {
+				Actions map[string]json.RawMessage `json:"actions"`
+			}
+			if json.Unmarshal(nested.Cases[c], &branch) == nil {
+				walkActions(branch.Actions, fn)
+			}
+		}
+		walkActions(nested.Default.Actions, fn)
+	}
+}
+
+// httpPermission returns the permission an HTTP action calling a known
+// API needs, or nil for other actions
+func httpPermission(action json.RawMessage) (*Permission, error) {
+	var kind struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(action, &kind); err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(kind.Type, "Http") {
+		return nil, nil
+	}
+	var http struct {
+		Inputs struct {
+			Method         string `json:"method"`
+			URI            string `json:"uri"`
+			Authentication struct {
+				Type string `json:"type"`
+			} `json:"authentication"`
+		} `json:"inputs"`
+	}
+	if err := json.Unmarshal(action, &http); err != nil {
+		return nil, err
+	}
+	uri := expression.ReplaceAllString(http.Inputs.URI, "{id}")
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return nil, nil
+	}
+	host := strings.ToLower(u.Host)
+	api, ok := apiHosts[host]
+	if !ok && strings.HasSuffix(host, ".vault.azure.net") {
+		api, ok = APIKeyVault, true
+	}
+	if !ok {
+		return nil, nil
+	}
+	path := u.Path
+	if api == APIGraph {
+		path = apiVersion.ReplaceAllString(path, "/")
+	}
+	auth := http.Inputs.Authentication.Type
+	if auth == "" {
+		auth = "None"
+	}
+	p := &Permission{API: api, Method: strings.ToUpper(http.Inputs.Method), Path: path, Authentication: auth}
+	for _, rule := range permissionRules {
+		if rule.api == api && strings.Contains(" "+rule.methods+" ", " "+p.Method+" ") && rule.path.MatchString(path) {
+			p.Permission = rule.permission
+			break
+		}
+	}
+	return p, nil
+}
+
+func containsPermission(list []Permission, p Permission) bool {
+	for _, q := range list {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of an object in order, so that schemas come
+// out the same for the same template
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,49 @@
+// Package logicapp keeps a versioned catalog of the Logic App workflow
+// templates of the logic-apps directory, deploys them into client
+// subscriptions through Azure Resource Manager and records each deployment
+// in deployment_mgmt.logic_app_deployments
+package logicapp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	// ErrTemplateNotFound is returned for templates not in the catalog
+	ErrTemplateNotFound = errors.New("logic app template not found")
+	// ErrInvalidTemplate is returned for templates that are not workflow
+	// definitions
+	ErrInvalidTemplate = errors.New("invalid logic app template")
+)
+
+// templateName is a template file name without its .json extension
+var templateName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Template is a workflow as exported from the Logic App code view: its
+// definition and the values of its parameters, such as $connections
+type Template struct {
+	Definition json.RawMessage `json:"definition"`
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ParseTemplate reads a workflow template. Templates saved by Windows
+// editors start with a byte order mark, which is skipped.
+func ParseTemplate(data []byte) (*Template, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	var t Template
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	var definition map[string]json.RawMessage
+	if err := json.Unmarshal(t.Definition, &definition); err != nil || definition == nil {
+		return nil, fmt.Errorf("%w: definition must be an object", ErrInvalidTemplate)
+	}
+	if _, ok := definition["triggers"]; !ok {
+		return nil, fmt.Errorf("%w: the definition has no triggers", ErrInvalidTemplate)
+	}
+	return &t, nil
+}
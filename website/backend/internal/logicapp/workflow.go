@@ -0,0 +1,146 @@
+package logicapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/arm"
+)
+
+const (
+	// WorkflowAPIVersion is the Microsoft.Logic API version workflows are
+	// deployed with
+	WorkflowAPIVersion      = "2019-05-01"
+	resourceGroupAPIVersion = "2021-04-01"
+)
+
+// Workflow states
+const (
+	StateEnabled  = "Enabled"
+	StateDisabled = "Disabled"
+)
+
+// Workflow is a Microsoft.Logic/workflows resource
+type Workflow struct {
+	ID         string             `json:"id,omitempty"`
+	Name       string             `json:"name,omitempty"`
+	Location   string             `json:"location"`
+	Tags       map[string]string  `json:"tags,omitempty"`
+	Identity   *WorkflowIdentity  `json:"identity,omitempty"`
+	Properties WorkflowProperties `json:"properties"`
+}
+
+// WorkflowIdentity is the managed identity of a workflow
+type WorkflowIdentity struct {
+	Type string `json:"type"`
+	// PrincipalID is the object ID of a system-assigned identity's
+	// service principal, which app roles are granted to
+	PrincipalID string `json:"principalId,omitempty"`
+	TenantID    string `json:"tenantId,omitempty"`
+}
+
+// IdentitySystemAssigned is the type of system-assigned identities
+const IdentitySystemAssigned = "SystemAssigned"
+
+// managedIdentityAuth matches actions and connections authenticating with
+// the workflow's managed identity
+var managedIdentityAuth = regexp.MustCompile(`(?i)"type"\s*:\s*"ManagedServiceIdentity"`)
+
+// WorkflowProperties are the properties of a workflow
+type WorkflowProperties struct {
+	State             string          `json:"state,omitempty"`
+	ProvisioningState string          `json:"provisioningState,omitempty"`
+	Definition        json.RawMessage `json:"definition,omitempty"`
+	Parameters        json.RawMessage `json:"parameters,omitempty"`
+	// AccessEndpoint is the base URL of the workflow's triggers
+	AccessEndpoint string `json:"accessEndpoint,omitempty"`
+	Version        string `json:"version,omitempty"`
+}
+
+// WorkflowID returns the resource ID of a workflow
+func WorkflowID(subscriptionID, resourceGroup, name string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Logic/workflows/%s",
+		subscriptionID, resourceGroup, name)
+}
+
+// PutWorkflow creates or updates an enabled workflow from a template and
+// returns it as provisioned. Without a location the workflow is put in
+// the location of its resource group. The template's API connections are
+// bound to those of the resource group, which must exist. Workflows whose
+// template authenticates with a managed identity get a system-assigned one.
+func PutWorkflow(ctx context.Context, manager arm.ResourceManager, subscriptionID, resourceGroup, name, location string,
+	t *Template, tags map[string]string) (*Workflow, error) {
+	return putWorkflow(ctx, manager, subscriptionID, resourceGroup, name, location, StateEnabled, t, tags)
+}
+
+// putWorkflow creates or updates a workflow in a state
+func putWorkflow(ctx context.Context, manager arm.ResourceManager, subscriptionID, resourceGroup, name, location, state string,
+	t *Template, tags map[string]string) (*Workflow, error) {
+	if location == "" {
+		var group struct {
+			Location string `json:"location"`
+		}
+		groupID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", subscriptionID, resourceGroup)
+		if err := manager.Get(ctx, groupID, resourceGroupAPIVersion, &group); err != nil {
+			return nil, fmt.Errorf("reading resource group %s: %w", resourceGroup, err)
+		}
+		location = group.Location
+		if location == "" {
+			return nil, fmt.Errorf("resource group %s has no location", resourceGroup)
+		}
+	}
+	t, connections, err := bindConnections(t, subscriptionID, resourceGroup, location)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConnections(ctx, manager, resourceGroup, connections); err != nil {
+		return nil, err
+	}
+
+	in := Workflow{
+		Location: location,
+		Tags:     tags,
+		Properties: WorkflowProperties{
+			State:      state,
+			Definition: t.Definition,
+			Parameters: t.Parameters,
+		},
+	}
+	if managedIdentityAuth.Match(t.Definition) {
+		in.Identity = &WorkflowIdentity{Type: IdentitySystemAssigned}
+	}
+	var out Workflow
+	if err := manager.Put(ctx, WorkflowID(subscriptionID, resourceGroup, name), WorkflowAPIVersion, in, &out); err != nil {
+		return nil, err
+	}
+	// Put fails on Failed and Canceled; anything but Succeeded left over
+	// means Resource Manager did not confirm the workflow
+	if state := out.Properties.ProvisioningState; state != "" && !strings.EqualFold(state, arm.StateSucceeded) {
+		return nil, &arm.Error{Code: "Provisioning" + state, Message: "workflow provisioning ended " + state}
+	}
+	return &out, nil
+}
+
+// SetWorkflowState enables or disables a workflow. A disabled workflow
+// keeps its definition and parameters but its triggers do not fire.
+func SetWorkflowState(ctx context.Context, manager arm.ResourceManager, subscriptionID, resourceGroup, name, state string) error {
+	var action string
+	switch state {
+	case StateEnabled:
+		action = "enable"
+	case StateDisabled:
+		action = "disable"
+	default:
+		return fmt.Errorf("unknown workflow state %q", state)
+	}
+	return manager.Post(ctx, WorkflowID(subscriptionID, resourceGroup, name), action, WorkflowAPIVersion, nil, nil)
+}
+
+// DeleteWorkflow deletes a workflow. Deleting a workflow that does not
+// exist succeeds.
+func DeleteWorkflow(ctx context.Context, manager arm.ResourceManager, subscriptionID, resourceGroup, name string) error {
+	return manager.Delete(ctx, WorkflowID(subscriptionID, resourceGroup, name), WorkflowAPIVersion)
+}
@@ -0,0 +1,229 @@
+package logicapp_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/arm"
+	"github.com/ClarityXDR/prod/website/backend/internal/arm/armtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/logicapp"
+)
+
+const (
+	subscription = "11111111-1111-1111-1111-111111111111"
+	group        = "rg-soar"
+	groupID      = "/subscriptions/" + subscription + "/resourceGroups/" + group
+)
+
+const httpTemplate = `{
+	"definition": {
+		"$schema": "https://schema.management.azure.com/providers/Microsoft.Logic/schemas/2016-06-01/workflowdefinition.json#",
+		"contentVersion": "1.0.0.0",
+		"triggers": {"manual": {"type": "Request", "kind": "Http", "inputs": {"schema": {}}}},
+		"actions": {"Response": {"type": "Response", "inputs": {"statusCode": 200}}}
+	}
+}`
+
+const identityTemplate = `{
+	"definition": {
+		"$schema": "https://schema.management.azure.com/providers/Microsoft.Logic/schemas/2016-06-01/workflowdefinition.json#",
+		"contentVersion": "1.0.0.0",
+		"triggers": {"manual": {"type": "Request", "kind": "Http", "inputs": {"schema": {}}}},
+		"actions": {
+			"Isolate": {
+				"type": "Http",
+				"inputs": {
+					"method": "POST",
+					"uri": "https://api.securitycenter.microsoft.com/api/machines/1/isolate",
+					"authentication": {"type": "ManagedServiceIdentity", "audience": "https://api.securitycenter.microsoft.com"}
+				}
+			}
+		}
+	}
+}`
+
+func parse(t *testing.T, content string) *logicapp.Template {
+	t.Helper()
+	tmpl, err := logicapp.ParseTemplate([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmpl
+}
+
+func newServer(asyncPolls int) *armtest.Server {
+	server := armtest.NewServer()
+	server.AsyncPolls = asyncPolls
+	server.AddResource(groupID, map[string]interface{}{"location": "westeurope"})
+	return server
+}
+
+func TestPutWorkflow(t *testing.T) {
+	server := newServer(3)
+	defer server.Close()
+
+	w, err := logicapp.PutWorkflow(context.Background(), server.Client(), subscription, group, "isolate-host", "",
+		parse(t, identityTemplate), map[string]string{logicapp.TagTemplate: "isolate-host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.ID != logicapp.WorkflowID(subscription, group, "isolate-host") ||
+		w.Properties.ProvisioningState != arm.StateSucceeded || w.Properties.State != logicapp.StateEnabled {
+		t.Errorf("workflow = %+v, want it enabled and provisioned", w)
+	}
+	if w.Location != "westeurope" {
+		t.Errorf("location = %q, want the resource group's", w.Location)
+	}
+	if w.Identity == nil || w.Identity.Type != logicapp.IdentitySystemAssigned || w.Identity.PrincipalID == "" {
+		t.Errorf("identity = %+v, want a system-assigned identity for a template using one", w.Identity)
+	}
+
+	w, err = logicapp.PutWorkflow(context.Background(), server.Client(), subscription, group, "respond", "northeurope",
+		parse(t, httpTemplate), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Location != "northeurope" || w.Identity != nil {
+		t.Errorf("workflow = %+v, want it in the given location without an identity", w)
+	}
+}
+
+func TestPutWorkflowFailure(t *testing.T) {
+	server := newServer(0)
+	defer server.Close()
+	server.Fail["isolate-host"] = http.StatusBadRequest
+
+	_, err := logicapp.PutWorkflow(context.Background(), server.Client(), subscription, group, "isolate-host", "",
+		parse(t, httpTemplate), nil)
+	var apiErr *arm.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("err = %v, want the Resource Manager error", err)
+	}
+}
+
+// unconfirmed is a resource manager whose puts return without the
+// resource reaching a final provisioning state
+type unconfirmed struct {
+	arm.ResourceManager
+}
+
+func (unconfirmed) Put(ctx context.Context, resourceID, apiVersion string, in, out interface{}) error {
+	return json.Unmarshal([]byte(`{"id":"`+resourceID+`","properties":{"provisioningState":"Updating"}}`), out)
+}
+
+func TestPutWorkflowUnconfirmed(t *testing.T) {
+	_, err := logicapp.PutWorkflow(context.Background(), unconfirmed{}, subscription, group, "isolate-host", "westeurope",
+		parse(t, httpTemplate), nil)
+	if err == nil {
+		t.Fatal("a workflow Resource Manager did not confirm was reported as deployed")
+	}
+}
+
+func TestSetWorkflowStateAndDelete(t *testing.T) {
+	server := newServer(2)
+	defer server.Close()
+	c := server.Client()
+	ctx := context.Background()
+	if _, err := logicapp.PutWorkflow(ctx, c, subscription, group, "isolate-host", "", parse(t, httpTemplate), nil); err != nil {
+		t.Fatal(err)
+	}
+	id := logicapp.WorkflowID(subscription, group, "isolate-host")
+
+	for _, state := range []string{logicapp.StateDisabled, logicapp.StateEnabled} {
+		if err := logicapp.SetWorkflowState(ctx, c, subscription, group, "isolate-host", state); err != nil {
+			t.Fatal(err)
+		}
+		var w logicapp.Workflow
+		if err := c.Get(ctx, id, logicapp.WorkflowAPIVersion, &w); err != nil {
+			t.Fatal(err)
+		}
+		if w.Properties.State != state {
+			t.Errorf("state = %s, want %s", w.Properties.State, state)
+		}
+	}
+	if err := logicapp.SetWorkflowState(ctx, c, subscription, group, "isolate-host", "Suspended"); err == nil {
+		t.Error("setting an unknown state succeeded")
+	}
+
+	if err := logicapp.DeleteWorkflow(ctx, c, subscription, group, "isolate-host"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Resource(id); ok {
+		t.Error("workflow still exists")
+	}
+}
+
+// connectionTemplate is exported the way the portal does, with the
+// managed API and connection of the subscription it was built in
+const connectionTemplate = `{
+	"definition": {
+		"$schema": "https://schema.management.azure.com/providers/Microsoft.Logic/schemas/2016-06-01/workflowdefinition.json#",
+		"contentVersion": "1.0.0.0",
+		"parameters": {"$connections": {"type": "Object", "defaultValue": {}}},
+		"triggers": {"manual": {"type": "Request", "kind": "Http", "inputs": {"schema": {}}}},
+		"actions": {
+			"Post_message": {
+				"type": "ApiConnection",
+				"inputs": {
+					"host": {"connection": {"name": "@parameters('$connections')['teams']['connectionId']"}},
+					"method": "post",
+					"path": "/v3/beta/teams/conversation/message/poster/Flow bot/location/Channel"
+				}
+			}
+		}
+	},
+	"parameters": {
+		"$connections": {
+			"value": {
+				"teams": {
+					"id": "/subscriptions/00000000-0000-0000-0000-000000000000/providers/Microsoft.Web/locations/region/managedApis/teams",
+					"connectionId": "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/YourResourceGroup/providers/Microsoft.Web/connections/your-connection",
+					"connectionName": "teams-connection"
+				}
+			}
+		}
+	}
+}`
+
+func TestPutWorkflowBindsConnections(t *testing.T) {
+	server := newServer(0)
+	defer server.Close()
+	ctx := context.Background()
+	tmpl := parse(t, connectionTemplate)
+
+	_, err := logicapp.PutWorkflow(ctx, server.Client(), subscription, group, "notify", "", tmpl, nil)
+	if !errors.Is(err, logicapp.ErrMissingConnection) || !strings.Contains(err.Error(), "teams-connection") {
+		t.Fatalf("err = %v, want the missing teams-connection named", err)
+	}
+	if _, ok := server.Resource(logicapp.WorkflowID(subscription, group, "notify")); ok {
+		t.Fatal("workflow was put without its connection")
+	}
+
+	connectionID := groupID + "/providers/Microsoft.Web/connections/teams-connection"
+	server.AddResource(connectionID, map[string]interface{}{"location": "westeurope"})
+	w, err := logicapp.PutWorkflow(ctx, server.Client(), subscription, group, "notify", "", tmpl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var params struct {
+		Connections struct {
+			Value map[string]struct {
+				ID             string `json:"id"`
+				ConnectionID   string `json:"connectionId"`
+				ConnectionName string `json:"connectionName"`
+			} `json:"value"`
+		} `json:"$connections"`
+	}
+	if err := json.Unmarshal(w.Properties.Parameters, &params); err != nil {
+		t.Fatal(err)
+	}
+	teams := params.Connections.Value["teams"]
+	wantAPI := "/subscriptions/" + subscription + "/providers/Microsoft.Web/locations/westeurope/managedApis/teams"
+	if teams.ID != wantAPI || teams.ConnectionID != connectionID || teams.ConnectionName != "teams-connection" {
+		t.Errorf("teams connection = %+v, want it bound to %s and %s", teams, wantAPI, connectionID)
+	}
+}
@@ -0,0 +1,412 @@
+// Package response takes response actions on Defender for Endpoint
+// machines, such as isolating them or collecting an investigation package,
+// and follows each action until Defender reports it finished
+package response
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/defender"
+)
+
+var (
+	// ErrNotFound is returned for unknown actions
+	ErrNotFound = errors.New("response action not found")
+	// ErrInvalidRequest is returned for action requests with missing or
+	// malformed fields
+	ErrInvalidRequest = errors.New("invalid response action request")
+	// ErrActionPending is returned when an action of the same type is
+	// still running on the machine
+	ErrActionPending = errors.New("an action of this type is already running on the machine")
+	// ErrTicketNotFound is returned when linking an action to an unknown
+	// ticket
+	ErrTicketNotFound = errors.New("ticket not found")
+	// ErrNoPackage is returned when asking for the package of an action
+	// that did not collect one
+	ErrNoPackage = errors.New("the action has no investigation package")
+)
+
+// machineID matches Defender machine IDs, 40 hex digits
+var machineID = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+
+// Action is a response action on a machine, stored in
+// deployment_mgmt.response_actions
+type Action struct {
+	ID               string     `json:"id"`
+	ClientID         string     `json:"clientId"`
+	ClientName       string     `json:"clientName,omitempty"`
+	MachineID        string     `json:"machineId"`
+	ComputerName     string     `json:"computerName,omitempty"`
+	Type             string     `json:"type"`
+	DefenderActionID string     `json:"defenderActionId"`
+	Status           string     `json:"status"`
+	Comment          string     `json:"comment"`
+	IsolationType    string     `json:"isolationType,omitempty"`
+	ScanType         string     `json:"scanType,omitempty"`
+	TicketID         *int64     `json:"ticketId,omitempty"`
+	RequestedBy      string     `json:"requestedBy,omitempty"`
+	PollError        string     `json:"pollError,omitempty"`
+	PolledAt         *time.Time `json:"polledAt,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	UpdatedAt        time.Time  `json:"updatedAt"`
+	CompletedAt      *time.Time `json:"completedAt,omitempty"`
+}
+
+// Final reports whether Defender has finished the action
+func (a *Action) Final() bool {
+	return a.CompletedAt != nil
+}
+
+// Request starts an action on a machine
+type Request struct {
+	ClientID  string `json:"clientId"`
+	MachineID string `json:"machineId"`
+	Type      string `json:"type"`
+	Comment   string `json:"comment"`
+	// IsolationType is Full or Selective for Isolate; it defaults to Full
+	IsolationType string `json:"isolationType,omitempty"`
+	// ScanType is Quick or Full for RunAntiVirusScan; it defaults to Quick
+	ScanType    string `json:"scanType,omitempty"`
+	TicketID    *int64 `json:"ticketId,omitempty"`
+	RequestedBy string `json:"requestedBy,omitempty"`
+}
+
+// validate checks a request and fills in its defaults
+func (req *Request) validate() error {
+	var problems []string
+	if req.ClientID == "" {
+		problems = append(problems, "clientId is required")
+	}
+	if !machineID.MatchString(req.MachineID) {
+		problems = append(problems, "machineId must be a Defender machine ID")
+	}
+	if !defender.IsActionType(req.Type) {
+		problems = append(problems, fmt.Sprintf("unknown action type %q", req.Type))
+	}
+	if strings.TrimSpace(req.Comment) == "" {
+		problems = append(problems, "comment is required")
+	}
+
+	switch {
+	case req.Type != defender.ActionIsolate && req.IsolationType != "":
+		problems = append(problems, "isolationType only applies to Isolate")
+	case req.Type == defender.ActionIsolate && req.IsolationType == "":
+		req.IsolationType = defender.IsolationFull
+	case req.Type == defender.ActionIsolate && req.IsolationType != defender.IsolationFull &&
+		req.IsolationType != defender.IsolationSelective:
+		problems = append(problems, "isolationType must be Full or Selective")
+	}
+	switch {
+	case req.Type != defender.ActionRunAntiVirusScan && req.ScanType != "":
+		problems = append(problems, "scanType only applies to RunAntiVirusScan")
+	case req.Type == defender.ActionRunAntiVirusScan && req.ScanType == "":
+		req.ScanType = defender.ScanQuick
+	case req.Type == defender.ActionRunAntiVirusScan && req.ScanType != defender.ScanQuick &&
+		req.ScanType != defender.ScanFull:
+		problems = append(problems, "scanType must be Quick or Full")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidRequest, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Filter selects actions; empty fields match any
+type Filter struct {
+	ClientID  string
+	MachineID string
+	Type      string
+	Status    string
+	TicketID  int64
+	// Open selects only actions Defender has not finished
+	Open   bool
+	Limit  int
+	Offset int
+}
+
+// Responder starts response actions through the Defender API of each
+// client's tenant and tracks them in deployment_mgmt.response_actions
+type Responder struct {
+	db       *sql.DB
+	actions  defender.Source
+	interval time.Duration
+}
+
+// NewResponder creates a responder calling Defender through actions
+func NewResponder(db *sql.DB, actions defender.Source) *Responder {
+	return &Responder{db: db, actions: actions, interval: DefaultPollInterval}
+}
+
+// Start starts an action on a machine and records it. It refuses an
+// action while one of the same type is still running on the machine.
+func (r *Responder) Start(ctx context.Context, req Request) (*Action, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	var known bool
+	if err := r.db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM client_mgmt.clients WHERE id::text = $1)", req.ClientID).Scan(&known); err != nil {
+		return nil, err
+	}
+	if !known {
+		return nil, fmt.Errorf("%w: unknown client %s", ErrInvalidRequest, req.ClientID)
+	}
+	if req.TicketID != nil {
+		if err := r.checkTicket(ctx, *req.TicketID); err != nil {
+			return nil, err
+		}
+	}
+	var pending bool
+	if err := r.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM deployment_mgmt.response_actions
+			WHERE client_id::text = $1 AND LOWER(machine_id) = LOWER($2) AND action_type = $3
+				AND completed_at IS NULL)`,
+		req.ClientID, req.MachineID, req.Type).Scan(&pending); err != nil {
+		return nil, err
+	}
+	if pending {
+		return nil, ErrActionPending
+	}
+
+	api, err := r.actions.MachineActions(ctx, req.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	started, err := api.StartMachineAction(ctx, req.MachineID, req.Type, defender.MachineActionRequest{
+		Comment:       req.Comment,
+		IsolationType: req.IsolationType,
+		ScanType:      req.ScanType,
+	})
+	var apiErr *defender.Error
+	if errors.As(err, &apiErr) && apiErr.Code == "ActiveRequestAlreadyExists" {
+		return nil, fmt.Errorf("%w: %v", ErrActionPending, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var id string
+	err = r.db.QueryRowContext(ctx, `
+		INSERT INTO deployment_mgmt.response_actions
+			(client_id, machine_id, computer_name, action_type, defender_action_id, status, comment,
+			 isolation_type, scan_type, ticket_id, requested_by, completed_at)
+		VALUES ($1::uuid, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11,
+			CASE WHEN $12::boolean THEN NOW() END)
+		RETURNING id`,
+		req.ClientID, req.MachineID, nullString(started.ComputerDNSName), req.Type, started.ID, started.Status,
+		req.Comment, nullString(req.IsolationType), nullString(req.ScanType), req.TicketID,
+		nullString(req.RequestedBy), defender.IsFinal(started.Status)).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("defender action %s started but not recorded: %w", started.ID, err)
+	}
+	action, err := r.Action(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if action.TicketID != nil {
+		r.comment(ctx, action, fmt.Sprintf("Started %s", describe(action)))
+	}
+	return action, nil
+}
+
+const actionColumns = `
+	a.id, a.client_id, COALESCE(c.name, ''), a.machine_id, COALESCE(a.computer_name, ''), a.action_type,
+	a.defender_action_id, a.status, a.comment, COALESCE(a.isolation_type, ''), COALESCE(a.scan_type, ''),
+	a.ticket_id, COALESCE(a.requested_by, ''), COALESCE(a.poll_error, ''), a.polled_at, a.created_at,
+	a.updated_at, a.completed_at`
+
+func scanAction(row interface{ Scan(...interface{}) error }) (*Action, error) {
+	var a Action
+	var ticketID sql.NullInt64
+	var polled, completed sql.NullTime
+	if err := row.Scan(&a.ID, &a.ClientID, &a.ClientName, &a.MachineID, &a.ComputerName, &a.Type,
+		&a.DefenderActionID, &a.Status, &a.Comment, &a.IsolationType, &a.ScanType, &ticketID,
+		&a.RequestedBy, &a.PollError, &polled, &a.CreatedAt, &a.UpdatedAt, &completed); err != nil {
+		return nil, err
+	}
+	if ticketID.Valid {
+		a.TicketID = &ticketID.Int64
+	}
+	if polled.Valid {
+		a.PolledAt = &polled.Time
+	}
+	if completed.Valid {
+		a.CompletedAt = &completed.Time
+	}
+	return &a, nil
+}
+
+// Action returns one action
+func (r *Responder) Action(ctx context.Context, id string) (*Action, error) {
+	action, err := scanAction(r.db.QueryRowContext(ctx, "SELECT "+actionColumns+`
+		FROM deployment_mgmt.response_actions a
+		LEFT JOIN client_mgmt.clients c ON c.id = a.client_id
+		WHERE a.id::text = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return action, err
+}
+
+// Actions returns the actions matching filter, newest first, and how many
+// match in all
+func (r *Responder) Actions(ctx context.Context, filter Filter) ([]*Action, int, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if filter.ClientID != "" {
+		conditions = append(conditions, "a.client_id::text = "+arg(filter.ClientID))
+	}
+	if filter.MachineID != "" {
+		conditions = append(conditions, "LOWER(a.machine_id) = LOWER("+arg(filter.MachineID)+")")
+	}
+	if filter.Type != "" {
+		conditions = append(conditions, "a.action_type = "+arg(filter.Type))
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "a.status = "+arg(filter.Status))
+	}
+	if filter.TicketID != 0 {
+		conditions = append(conditions, "a.ticket_id = "+arg(filter.TicketID))
+	}
+	if filter.Open {
+		conditions = append(conditions, "a.completed_at IS NULL")
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := r.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM deployment_mgmt.response_actions a "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	rows, err := r.db.QueryContext(ctx, "SELECT "+actionColumns+`
+		FROM deployment_mgmt.response_actions a
+		LEFT JOIN client_mgmt.clients c ON c.id = a.client_id `+where+`
+		ORDER BY a.created_at DESC, a.id
+		LIMIT `+arg(limit)+` OFFSET `+arg(filter.Offset), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	actions := []*Action{}
+	for rows.Next() {
+		a, err := scanAction(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, total, rows.Err()
+}
+
+// LinkTicket links an action to a ticket, replacing any earlier link, and
+// comments on the ticket with the action's status
+func (r *Responder) LinkTicket(ctx context.Context, id string, ticketID int64) (*Action, error) {
+	if err := r.checkTicket(ctx, ticketID); err != nil {
+		return nil, err
+	}
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.response_actions
+		SET ticket_id = $2, updated_at = NOW()
+		WHERE id::text = $1`, id, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNotFound
+	}
+	action, err := r.Action(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r.comment(ctx, action, fmt.Sprintf("Linked %s, now %s", describe(action), action.Status))
+	return action, nil
+}
+
+// PackageURI returns a short-lived download link for the investigation
+// package an action collected
+func (r *Responder) PackageURI(ctx context.Context, id string) (string, error) {
+	action, err := r.Action(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if action.Type != defender.ActionCollectInvestigationPackage {
+		return "", fmt.Errorf("%w: it is a %s action", ErrNoPackage, action.Type)
+	}
+	if action.Status != defender.ActionSucceeded {
+		return "", fmt.Errorf("%w: the collection is %s", ErrNoPackage, action.Status)
+	}
+	api, err := r.actions.MachineActions(ctx, action.ClientID)
+	if err != nil {
+		return "", err
+	}
+	return api.PackageURI(ctx, action.DefenderActionID)
+}
+
+func (r *Responder) checkTicket(ctx context.Context, ticketID int64) error {
+	var exists bool
+	if err := r.db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM tickets WHERE id = $1)", ticketID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %d", ErrTicketNotFound, ticketID)
+	}
+	return nil
+}
+
+// comment adds an internal comment to the action's ticket. Failing to
+// comment does not fail the action, so errors are only logged.
+func (r *Responder) comment(ctx context.Context, action *Action, content string) {
+	if action.TicketID == nil {
+		return
+	}
+	if _, err := r.db.ExecContext(ctx, `
+		INSERT INTO ticket_comments (ticket_id, content, is_internal)
+		VALUES ($1, $2, TRUE)`, *action.TicketID, content); err != nil {
+		log.Printf("Error commenting on ticket %d for response action %s: %v", *action.TicketID, action.ID, err)
+	}
+}
+
+// describe names an action and its target for ticket comments
+func describe(a *Action) string {
+	machine := a.MachineID
+	if a.ComputerName != "" {
+		machine = a.ComputerName + " (" + a.MachineID + ")"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s on %s", a.Type, machine)
+	if a.IsolationType != "" {
+		fmt.Fprintf(&b, ", %s isolation", a.IsolationType)
+	}
+	if a.ScanType != "" {
+		fmt.Fprintf(&b, ", %s scan", a.ScanType)
+	}
+	fmt.Fprintf(&b, " [response action %s, Defender action %s]", a.ID, a.DefenderActionID)
+	return b.String()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,233 @@
+package response
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/defender"
+	"github.com/ClarityXDR/prod/website/backend/internal/defender/defendertest"
+)
+
+const testMachine = "1e5bc9d7e413ddd7902c2932e418702b84d0cc07"
+
+func TestRequestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		req  Request
+		// problem is part of the error; empty for valid requests
+		problem       string
+		isolationType string
+		scanType      string
+	}{
+		{name: "isolation defaults to full",
+			req:           Request{Type: defender.ActionIsolate},
+			isolationType: defender.IsolationFull},
+		{name: "selective isolation",
+			req:           Request{Type: defender.ActionIsolate, IsolationType: defender.IsolationSelective},
+			isolationType: defender.IsolationSelective},
+		{name: "scan defaults to quick",
+			req:      Request{Type: defender.ActionRunAntiVirusScan},
+			scanType: defender.ScanQuick},
+		{name: "full scan",
+			req:      Request{Type: defender.ActionRunAntiVirusScan, ScanType: defender.ScanFull},
+			scanType: defender.ScanFull},
+		{name: "upper-case machine ID",
+			req: Request{Type: defender.ActionUnisolate, MachineID: strings.ToUpper(testMachine)}},
+		{name: "short machine ID",
+			req:     Request{Type: defender.ActionUnisolate, MachineID: testMachine[1:]},
+			problem: "machineId must be a Defender machine ID"},
+		{name: "non-hex machine ID",
+			req:     Request{Type: defender.ActionUnisolate, MachineID: "z" + testMachine[1:]},
+			problem: "machineId must be a Defender machine ID"},
+		{name: "unknown type",
+			req:     Request{Type: "Reboot"},
+			problem: `unknown action type "Reboot"`},
+		{name: "blank comment",
+			req:     Request{Type: defender.ActionUnisolate, Comment: " "},
+			problem: "comment is required"},
+		{name: "missing client",
+			req:     Request{Type: defender.ActionUnisolate, ClientID: "-"},
+			problem: "clientId is required"},
+		{name: "isolation type on a scan",
+			req:     Request{Type: defender.ActionRunAntiVirusScan, IsolationType: defender.IsolationFull},
+			problem: "isolationType only applies to Isolate"},
+		{name: "unknown isolation type",
+			req:     Request{Type: defender.ActionIsolate, IsolationType: "Partial"},
+			problem: "isolationType must be Full or Selective"},
+		{name: "scan type on isolation",
+			req:     Request{Type: defender.ActionIsolate, ScanType: defender.ScanQuick},
+			problem: "scanType only applies to RunAntiVirusScan"},
+		{name: "unknown scan type",
+			req:     Request{Type: defender.ActionRunAntiVirusScan, ScanType: "Deep"},
+			problem: "scanType must be Quick or Full"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			switch req.ClientID {
+			case "":
+				req.ClientID = "11111111-1111-1111-1111-111111111111"
+			case "-":
+				req.ClientID = ""
+			}
+			if req.MachineID == "" {
+				req.MachineID = testMachine
+			}
+			if req.Comment == "" {
+				req.Comment = "Response to incident 42"
+			}
+
+			err := req.validate()
+			if tt.problem != "" {
+				if !errors.Is(err, ErrInvalidRequest) || !strings.Contains(err.Error(), tt.problem) {
+					t.Errorf("validate() = %v, want %v mentioning %q", err, ErrInvalidRequest, tt.problem)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validate() = %v", err)
+			}
+			if req.IsolationType != tt.isolationType || req.ScanType != tt.scanType {
+				t.Errorf("defaults = %q, %q, want %q, %q", req.IsolationType, req.ScanType, tt.isolationType, tt.scanType)
+			}
+		})
+	}
+}
+
+func TestRequestValidateReportsEveryProblem(t *testing.T) {
+	req := Request{Type: defender.ActionRunAntiVirusScan, IsolationType: defender.IsolationFull, ScanType: "Deep"}
+	err := req.validate()
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("validate() = %v, want %v", err, ErrInvalidRequest)
+	}
+	for _, problem := range []string{
+		"clientId is required", "machineId must be a Defender machine ID", "comment is required",
+		"isolationType only applies to Isolate", "scanType must be Quick or Full",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("validate() = %v, missing %q", err, problem)
+		}
+	}
+}
+
+// newResponder returns a responder calling server, with a client named
+// Contoso and a ticket to link actions to
+func newResponder(t *testing.T, server *defendertest.Server) (r *Responder, db *sql.DB, clientID string, ticketID int64) {
+	t.Helper()
+	db = dbtest.Open(t)
+	clientID = dbtest.CreateClient(t, db, "Contoso")
+	if err := db.QueryRow(`
+		INSERT INTO tickets (title, description, agent_type)
+		VALUES ('Incident 42', 'Ransomware on WS01', 'soc') RETURNING id`).Scan(&ticketID); err != nil {
+		t.Fatal(err)
+	}
+	server.AddMachine(testMachine, "ws01.contoso.com")
+	return NewResponder(db, server.Source()), db, clientID, ticketID
+}
+
+// ticketComments returns the comments on a ticket, oldest first
+func ticketComments(t *testing.T, db *sql.DB, ticketID int64) []string {
+	t.Helper()
+	rows, err := db.Query("SELECT content FROM ticket_comments WHERE ticket_id = $1 ORDER BY id", ticketID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var comments []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			t.Fatal(err)
+		}
+		comments = append(comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return comments
+}
+
+func TestResponderStart(t *testing.T) {
+	server := defendertest.NewServer()
+	defer server.Close()
+	r, db, clientID, ticketID := newResponder(t, server)
+	ctx := context.Background()
+
+	action, err := r.Start(ctx, Request{
+		ClientID: clientID, MachineID: testMachine, Type: defender.ActionIsolate,
+		Comment: "Response to incident 42", TicketID: &ticketID, RequestedBy: "analyst@contoso.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action.Status != defender.ActionPending || action.Final() || action.ClientName != "Contoso" ||
+		action.ComputerName != "ws01.contoso.com" || action.IsolationType != defender.IsolationFull ||
+		action.RequestedBy != "analyst@contoso.com" || action.TicketID == nil || *action.TicketID != ticketID {
+		t.Fatalf("action = %+v, want a pending full isolation of ws01", action)
+	}
+	started := server.MachineActions(testMachine)
+	if len(started) != 1 || started[0].ID != action.DefenderActionID || started[0].RequestorComment != action.Comment {
+		t.Fatalf("Defender actions = %+v, want the isolation", started)
+	}
+	if comments := ticketComments(t, db, ticketID); len(comments) != 1 ||
+		!strings.HasPrefix(comments[0], "Started Isolate on ws01.contoso.com ("+testMachine+"), Full isolation") {
+		t.Errorf("ticket comments = %q, want the start noted", comments)
+	}
+
+	// A second isolation is refused before Defender is asked, whatever the
+	// case of the machine ID
+	_, err = r.Start(ctx, Request{ClientID: clientID, MachineID: strings.ToUpper(testMachine),
+		Type: defender.ActionIsolate, Comment: "Again"})
+	if !errors.Is(err, ErrActionPending) {
+		t.Errorf("second isolation: err = %v, want %v", err, ErrActionPending)
+	}
+	if n := len(server.MachineActions(testMachine)); n != 1 {
+		t.Errorf("Defender holds %d actions, want 1", n)
+	}
+
+	// Defender refusing an action running outside ClarityXDR is pending too
+	if _, err := server.Client().StartMachineAction(ctx, testMachine, defender.ActionRunAntiVirusScan,
+		defender.MachineActionRequest{Comment: "Started in the portal", ScanType: defender.ScanQuick}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Start(ctx, Request{ClientID: clientID, MachineID: testMachine,
+		Type: defender.ActionRunAntiVirusScan, Comment: "Scan"})
+	if !errors.Is(err, ErrActionPending) {
+		t.Errorf("scan running in Defender: err = %v, want %v", err, ErrActionPending)
+	}
+
+	missingTicket := ticketID + 1
+	for _, tt := range []struct {
+		name string
+		req  Request
+		want error
+	}{
+		{name: "unknown client",
+			req:  Request{ClientID: "00000000-0000-0000-0000-000000000000", MachineID: testMachine},
+			want: ErrInvalidRequest},
+		{name: "unknown ticket",
+			req:  Request{ClientID: clientID, MachineID: testMachine, TicketID: &missingTicket},
+			want: ErrTicketNotFound},
+		{name: "unknown machine",
+			req: Request{ClientID: clientID, MachineID: strings.Repeat("ab", 20)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Type, tt.req.Comment = defender.ActionCollectInvestigationPackage, "Collect"
+			_, err := r.Start(ctx, tt.req)
+			if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	actions, total, err := r.Actions(ctx, Filter{ClientID: clientID, Open: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(actions) != 1 || actions[0].ID != action.ID {
+		t.Errorf("open actions = %+v, want only the isolation recorded", actions)
+	}
+}
@@ -0,0 +1,166 @@
+package response
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/defender"
+)
+
+// DefaultPollInterval is how often running actions are polled in the
+// background. Most actions finish within minutes.
+const DefaultPollInterval = time.Minute
+
+// pollTimeout bounds how long a poll waits for Defender
+const pollTimeout = 2 * time.Minute
+
+// PollResult is the outcome of polling one action
+type PollResult struct {
+	ActionID string `json:"actionId"`
+	Status   string `json:"status,omitempty"`
+	// Completed is set when the poll found the action finished
+	Completed bool   `json:"completed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Run polls running actions until ctx is cancelled
+func (r *Responder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		results, err := r.PollAll(ctx)
+		if err != nil {
+			log.Printf("Error polling response actions: %v", err)
+		}
+		for _, res := range results {
+			if res.Error != "" {
+				log.Printf("Error polling response action %s: %s", res.ActionID, res.Error)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PollAll polls every action Defender has not finished. An action that
+// fails to poll does not stop the others; its error is in its result.
+func (r *Responder) PollAll(ctx context.Context) ([]PollResult, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id FROM deployment_mgmt.response_actions
+		WHERE completed_at IS NULL
+		ORDER BY polled_at NULLS FIRST`)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var results []PollResult
+	for _, id := range ids {
+		result := PollResult{ActionID: id}
+		action, err := r.Refresh(ctx, id)
+		if action != nil {
+			result.Status, result.Completed = action.Status, action.Final()
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Refresh reads the status of an action from Defender and stores it. An
+// action already finished, or being polled by another caller, is returned
+// as stored. When the action finishes, its ticket gets a comment saying how
+// it ended.
+func (r *Responder) Refresh(ctx context.Context, id string) (*Action, error) {
+	action, err := r.Action(ctx, id)
+	if err != nil || action.Final() {
+		return action, err
+	}
+	// The action is claimed so that one poll at a time calls Defender. A
+	// claim outlives its poll only by the time taken to record it, so one
+	// older than twice the poll timeout was left by an interrupted poll.
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.response_actions
+		SET polling_since = NOW()
+		WHERE id = $1 AND completed_at IS NULL
+			AND (polling_since IS NULL OR polling_since < NOW() - $2 * INTERVAL '1 second')`,
+		action.ID, 2*pollTimeout.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return action, nil
+	}
+
+	current, pollErr := r.poll(ctx, action)
+
+	// The outcome is recorded even when the request was cancelled, so the
+	// action is not left claimed
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	if pollErr != nil {
+		if _, err := r.db.ExecContext(saveCtx, `
+			UPDATE deployment_mgmt.response_actions
+			SET poll_error = $2, polled_at = NOW(), polling_since = NULL
+			WHERE id = $1`, action.ID, pollErr.Error()); err != nil {
+			return nil, err
+		}
+		return action, pollErr
+	}
+
+	final := defender.IsFinal(current.Status)
+	if _, err := r.db.ExecContext(saveCtx, `
+		UPDATE deployment_mgmt.response_actions
+		SET status = $2, computer_name = COALESCE($3, computer_name), poll_error = NULL, polled_at = NOW(),
+			polling_since = NULL,
+			updated_at = CASE WHEN status <> $2 THEN NOW() ELSE updated_at END,
+			completed_at = CASE WHEN $4::boolean THEN NOW() END
+		WHERE id = $1`, action.ID, current.Status, nullString(current.ComputerDNSName), final); err != nil {
+		return nil, err
+	}
+	if action, err = r.Action(saveCtx, id); err != nil {
+		return nil, err
+	}
+	if final {
+		note := fmt.Sprintf("%s ended %s", describe(action), action.Status)
+		if action.Status == defender.ActionSucceeded && action.Type == defender.ActionCollectInvestigationPackage {
+			note += "; download the package from /api/response/actions/" + action.ID + "/package"
+		}
+		r.comment(saveCtx, action, note)
+	}
+	return action, nil
+}
+
+// poll reads an action's status from Defender, giving up after
+// pollTimeout
+func (r *Responder) poll(ctx context.Context, action *Action) (*defender.MachineAction, error) {
+	ctx, cancel := context.WithTimeout(ctx, pollTimeout)
+	defer cancel()
+	api, err := r.actions.MachineActions(ctx, action.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	return api.MachineAction(ctx, action.DefenderActionID)
+}
@@ -0,0 +1,148 @@
+package response
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/defender"
+	"github.com/ClarityXDR/prod/website/backend/internal/defender/defendertest"
+)
+
+// failingSource fails to reach Defender for every client
+type failingSource struct{ err error }
+
+func (s failingSource) MachineActions(ctx context.Context, clientID string) (defender.MachineActions, error) {
+	return nil, s.err
+}
+
+func TestResponderRefresh(t *testing.T) {
+	server := defendertest.NewServer()
+	defer server.Close()
+	r, db, clientID, ticketID := newResponder(t, server)
+	ctx := context.Background()
+
+	action, err := r.Start(ctx, Request{
+		ClientID: clientID, MachineID: testMachine, Type: defender.ActionCollectInvestigationPackage,
+		Comment: "Response to incident 42", TicketID: &ticketID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake moves the action on each time it is read
+	for _, want := range []string{defender.ActionPending, defender.ActionInProgress, defender.ActionSucceeded} {
+		if action, err = r.Refresh(ctx, action.ID); err != nil {
+			t.Fatal(err)
+		}
+		if action.Status != want || action.PolledAt == nil || action.Final() != (want == defender.ActionSucceeded) {
+			t.Fatalf("action = %+v, want it polled and %s", action, want)
+		}
+	}
+	comments := ticketComments(t, db, ticketID)
+	if len(comments) != 2 || !strings.Contains(comments[1], "ended Succeeded; download the package from "+
+		"/api/response/actions/"+action.ID+"/package") {
+		t.Fatalf("ticket comments = %q, want the end noted with the package link", comments)
+	}
+
+	// A finished action is not polled again
+	polled := *action.PolledAt
+	if action, err = r.Refresh(ctx, action.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !action.PolledAt.Equal(polled) || len(ticketComments(t, db, ticketID)) != 2 {
+		t.Errorf("finished action polled again: %+v", action)
+	}
+
+	if _, err := r.Refresh(ctx, "00000000-0000-0000-0000-000000000000"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown action: err = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestResponderRefreshClaim(t *testing.T) {
+	server := defendertest.NewServer()
+	defer server.Close()
+	r, db, clientID, _ := newResponder(t, server)
+	ctx := context.Background()
+	server.Fail[testMachine] = true
+
+	action, err := r.Start(ctx, Request{
+		ClientID: clientID, MachineID: testMachine, Type: defender.ActionRunAntiVirusScan, Comment: "Scan",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pollingSince := func() (claimed bool) {
+		t.Helper()
+		if err := db.QueryRow(`
+			SELECT polling_since IS NOT NULL FROM deployment_mgmt.response_actions
+			WHERE id = $1`, action.ID).Scan(&claimed); err != nil {
+			t.Fatal(err)
+		}
+		return claimed
+	}
+
+	// An action another poll is calling Defender for is returned as stored
+	if _, err := db.Exec(`
+		UPDATE deployment_mgmt.response_actions SET polling_since = NOW() WHERE id = $1`, action.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Refresh(ctx, action.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.PolledAt != nil || server.MachineActions(testMachine)[0].Status != defender.ActionPending {
+		t.Fatalf("action = %+v, want it left to the other poll", got)
+	}
+
+	// A claim left by an interrupted poll is taken over
+	if _, err := db.Exec(`
+		UPDATE deployment_mgmt.response_actions
+		SET polling_since = NOW() - INTERVAL '1 hour' WHERE id = $1`, action.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = r.Refresh(ctx, action.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got.PolledAt == nil || pollingSince() {
+		t.Fatalf("action = %+v, want it polled and released", got)
+	}
+
+	// Failing to reach Defender is recorded and releases the claim
+	unreachable := NewResponder(db, failingSource{errors.New("token expired")})
+	if _, err := unreachable.Refresh(ctx, action.ID); err == nil || !strings.Contains(err.Error(), "token expired") {
+		t.Fatalf("err = %v, want the source's error", err)
+	}
+	if got, err = r.Action(ctx, action.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got.PollError != "token expired" || pollingSince() {
+		t.Fatalf("action = %+v, want the poll error recorded and the claim released", got)
+	}
+
+	// PollAll polls the open actions until Defender ends them; the poll
+	// error is cleared by the next poll
+	results, err := r.PollAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ActionID != action.ID || results[0].Completed || results[0].Error != "" {
+		t.Fatalf("results = %+v, want the scan polled", results)
+	}
+	if results, err = r.PollAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Completed || results[0].Status != defender.ActionFailed {
+		t.Fatalf("results = %+v, want the scan failed", results)
+	}
+	if got, err = r.Action(ctx, action.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got.PollError != "" || !got.Final() {
+		t.Errorf("action = %+v, want it finished without a poll error", got)
+	}
+	if results, err = r.PollAll(ctx); err != nil || len(results) != 0 {
+		t.Errorf("results = %+v, %v; want no open actions", results, err)
+	}
+}
@@ -0,0 +1,325 @@
+package sentinel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+)
+
+const (
+	// DefaultBaseURL is Azure Resource Manager
+	DefaultBaseURL = "https://management.azure.com"
+	// APIVersion is the Microsoft.SecurityInsights API version used
+	APIVersion = "2024-03-01"
+)
+
+// Connector reads and triages the incidents of Sentinel workspaces
+type Connector interface {
+	// ListIncidents returns the incidents modified at or after since,
+	// oldest modification first
+	ListIncidents(ctx context.Context, ws Workspace, since time.Time) ([]Incident, error)
+	Alerts(ctx context.Context, ws Workspace, incident string) ([]Alert, error)
+	Entities(ctx context.Context, ws Workspace, incident string) ([]Entity, error)
+	// UpdateIncident writes an incident back. It fails with a 412 Error
+	// wrapping ErrConflict when the incident changed since its ETag was read.
+	UpdateIncident(ctx context.Context, ws Workspace, inc *Incident) (*Incident, error)
+}
+
+// Client calls the Sentinel API through Azure Resource Manager
+type Client struct {
+	HTTPClient *http.Client
+	BaseURL    string
+	Tokens     azure.TokenProvider
+}
+
+// NewClient creates a client for the tenant the token provider belongs to
+func NewClient(tokens azure.TokenProvider) *Client {
+	return &Client{
+		HTTPClient: &http.Client{Timeout: time.Minute},
+		BaseURL:    DefaultBaseURL,
+		Tokens:     tokens,
+	}
+}
+
+// Error is an error response from Azure Resource Manager
+type Error struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("sentinel api returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("sentinel api returned %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Unwrap makes a 412, refusing a write with a stale ETag, match ErrConflict
+func (e *Error) Unwrap() error {
+	if e.StatusCode == http.StatusPreconditionFailed {
+		return ErrConflict
+	}
+	return nil
+}
+
+// Wire formats of the Microsoft.SecurityInsights resources
+
+type incidentResource struct {
+	ID         string             `json:"id,omitempty"`
+	Name       string             `json:"name,omitempty"`
+	ETag       string             `json:"etag,omitempty"`
+	Properties incidentProperties `json:"properties"`
+}
+
+type incidentProperties struct {
+	Title                 string     `json:"title"`
+	Description           string     `json:"description,omitempty"`
+	Severity              string     `json:"severity"`
+	Status                string     `json:"status"`
+	Classification        string     `json:"classification,omitempty"`
+	ClassificationReason  string     `json:"classificationReason,omitempty"`
+	ClassificationComment string     `json:"classificationComment,omitempty"`
+	Owner                 *Owner     `json:"owner,omitempty"`
+	Labels                []label    `json:"labels,omitempty"`
+	IncidentNumber        int        `json:"incidentNumber,omitempty"`
+	IncidentURL           string     `json:"incidentUrl,omitempty"`
+	CreatedTimeUTC        *time.Time `json:"createdTimeUtc,omitempty"`
+	LastModifiedTimeUTC   *time.Time `json:"lastModifiedTimeUtc,omitempty"`
+	FirstActivityTimeUTC  *time.Time `json:"firstActivityTimeUtc,omitempty"`
+	LastActivityTimeUTC   *time.Time `json:"lastActivityTimeUtc,omitempty"`
+	AdditionalData        *struct {
+		AlertsCount int `json:"alertsCount"`
+	} `json:"additionalData,omitempty"`
+}
+
+type label struct {
+	LabelName string `json:"labelName"`
+	LabelType string `json:"labelType,omitempty"`
+}
+
+type alertResource struct {
+	Name       string `json:"name"`
+	Properties struct {
+		AlertDisplayName string    `json:"alertDisplayName"`
+		Severity         string    `json:"severity"`
+		Status           string    `json:"status"`
+		ProductName      string    `json:"productName"`
+		Tactics          []string  `json:"tactics"`
+		TimeGenerated    time.Time `json:"timeGenerated"`
+	} `json:"properties"`
+}
+
+type entityResource struct {
+	Name       string                 `json:"name"`
+	Kind       string                 `json:"kind"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+func (r *incidentResource) incident() Incident {
+	p := r.Properties
+	inc := Incident{
+		Name:                  r.Name,
+		ResourceID:            r.ID,
+		ETag:                  r.ETag,
+		Number:                p.IncidentNumber,
+		Title:                 p.Title,
+		Description:           p.Description,
+		Severity:              p.Severity,
+		Status:                p.Status,
+		Classification:        p.Classification,
+		ClassificationReason:  p.ClassificationReason,
+		ClassificationComment: p.ClassificationComment,
+		Labels:                []string{},
+		FirstActivityAt:       p.FirstActivityTimeUTC,
+		LastActivityAt:        p.LastActivityTimeUTC,
+		URL:                   p.IncidentURL,
+	}
+	if p.Owner != nil {
+		inc.Owner = *p.Owner
+	}
+	for _, l := range p.Labels {
+		inc.Labels = append(inc.Labels, l.LabelName)
+	}
+	if p.AdditionalData != nil {
+		inc.AlertCount = p.AdditionalData.AlertsCount
+	}
+	if p.CreatedTimeUTC != nil {
+		inc.CreatedAt = *p.CreatedTimeUTC
+	}
+	if p.LastModifiedTimeUTC != nil {
+		inc.LastModifiedAt = *p.LastModifiedTimeUTC
+	}
+	return inc
+}
+
+// workspacePath returns the path of the Sentinel provider in a workspace
+func workspacePath(ws Workspace) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.OperationalInsights/workspaces/%s/providers/Microsoft.SecurityInsights",
+		url.PathEscape(ws.SubscriptionID), url.PathEscape(ws.ResourceGroup), url.PathEscape(ws.Name))
+}
+
+// ListIncidents implements Connector, following nextLink across pages
+func (c *Client) ListIncidents(ctx context.Context, ws Workspace, since time.Time) ([]Incident, error) {
+	query := url.Values{
+		"api-version": {APIVersion},
+		"$orderby":    {"properties/lastModifiedTimeUtc asc"},
+	}
+	if !since.IsZero() {
+		query.Set("$filter", "properties/lastModifiedTimeUtc ge "+since.UTC().Format(time.RFC3339Nano))
+	}
+	next := strings.TrimRight(c.BaseURL, "/") + workspacePath(ws) + "/incidents?" + query.Encode()
+
+	var incidents []Incident
+	for next != "" {
+		var page struct {
+			Value    []incidentResource `json:"value"`
+			NextLink string             `json:"nextLink"`
+		}
+		if err := c.do(ctx, http.MethodGet, next, nil, nil, &page); err != nil {
+			return nil, err
+		}
+		for i := range page.Value {
+			incidents = append(incidents, page.Value[i].incident())
+		}
+		next = page.NextLink
+	}
+	return incidents, nil
+}
+
+// Alerts implements Connector
+func (c *Client) Alerts(ctx context.Context, ws Workspace, incident string) ([]Alert, error) {
+	var resp struct {
+		Value []alertResource `json:"value"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.incidentURL(ws, incident, "/alerts"), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	alerts := []Alert{}
+	for _, a := range resp.Value {
+		alerts = append(alerts, Alert{
+			Name:          a.Name,
+			DisplayName:   a.Properties.AlertDisplayName,
+			Severity:      a.Properties.Severity,
+			Status:        a.Properties.Status,
+			ProductName:   a.Properties.ProductName,
+			Tactics:       append([]string{}, a.Properties.Tactics...),
+			TimeGenerated: a.Properties.TimeGenerated,
+		})
+	}
+	return alerts, nil
+}
+
+// Entities implements Connector
+func (c *Client) Entities(ctx context.Context, ws Workspace, incident string) ([]Entity, error) {
+	var resp struct {
+		Entities []entityResource `json:"entities"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.incidentURL(ws, incident, "/entities"), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	entities := []Entity{}
+	for _, e := range resp.Entities {
+		friendly, _ := e.Properties["friendlyName"].(string)
+		entities = append(entities, Entity{Name: e.Name, Kind: e.Kind, FriendlyName: friendly, Properties: e.Properties})
+	}
+	return entities, nil
+}
+
+// UpdateIncident implements Connector
+func (c *Client) UpdateIncident(ctx context.Context, ws Workspace, inc *Incident) (*Incident, error) {
+	body := incidentResource{
+		ETag: inc.ETag,
+		Properties: incidentProperties{
+			Title:                 inc.Title,
+			Description:           inc.Description,
+			Severity:              inc.Severity,
+			Status:                inc.Status,
+			Classification:        inc.Classification,
+			ClassificationReason:  inc.ClassificationReason,
+			ClassificationComment: inc.ClassificationComment,
+		},
+	}
+	if inc.Owner != (Owner{}) {
+		owner := inc.Owner
+		body.Properties.Owner = &owner
+	}
+	for _, l := range inc.Labels {
+		body.Properties.Labels = append(body.Properties.Labels, label{LabelName: l, LabelType: "User"})
+	}
+
+	headers := map[string]string{}
+	if inc.ETag != "" {
+		headers["If-Match"] = inc.ETag
+	}
+	var resp incidentResource
+	if err := c.do(ctx, http.MethodPut, c.incidentURL(ws, inc.Name, ""), headers, body, &resp); err != nil {
+		return nil, err
+	}
+	updated := resp.incident()
+	return &updated, nil
+}
+
+func (c *Client) incidentURL(ws Workspace, incident, suffix string) string {
+	return strings.TrimRight(c.BaseURL, "/") + workspacePath(ws) + "/incidents/" + url.PathEscape(incident) +
+		suffix + "?api-version=" + APIVersion
+}
+
+// do sends a JSON request to an absolute URL and decodes the JSON response
+// into out
+func (c *Client) do(ctx context.Context, method, target string, headers map[string]string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	token, err := c.Tokens.Token(ctx, azure.ScopeManagement)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		var envelope struct {
+			Error *Error `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&envelope) == nil && envelope.Error != nil {
+			apiErr.Code, apiErr.Message = envelope.Error.Code, envelope.Error.Message
+		}
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
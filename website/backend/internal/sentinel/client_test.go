@@ -0,0 +1,145 @@
+package sentinel_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/sentinel"
+	"github.com/ClarityXDR/prod/website/backend/internal/sentinel/sentineltest"
+)
+
+var workspace = sentinel.Workspace{
+	SubscriptionID: "11111111-1111-1111-1111-111111111111",
+	ResourceGroup:  "rg-sentinel",
+	Name:           "law-contoso",
+}
+
+func newClient(server *sentineltest.Server) *sentinel.Client {
+	c := sentinel.NewClient(azure.StaticToken("test"))
+	c.BaseURL = server.URL
+	return c
+}
+
+func TestClientListIncidents(t *testing.T) {
+	server := sentineltest.NewServer()
+	defer server.Close()
+	server.PageSize = 2
+
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		server.AddIncident(workspace, sentinel.Incident{
+			Title:          "Suspicious sign-in",
+			LastModifiedAt: start.Add(time.Duration(i) * time.Hour),
+			Labels:         []string{"triage"},
+		}, nil, nil)
+	}
+	// Incidents of other workspaces are not listed
+	other := workspace
+	other.Name = "law-fabrikam"
+	server.AddIncident(other, sentinel.Incident{Title: "Elsewhere", LastModifiedAt: start}, nil, nil)
+
+	c := newClient(server)
+	incidents, err := c.ListIncidents(context.Background(), workspace, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incidents) != 5 {
+		t.Fatalf("listed %d incidents across pages, want 5", len(incidents))
+	}
+	for i, inc := range incidents {
+		if !inc.LastModifiedAt.Equal(start.Add(time.Duration(i) * time.Hour)) {
+			t.Errorf("incident %d modified at %s, want oldest modification first", i, inc.LastModifiedAt)
+		}
+		if inc.ETag == "" || inc.Title != "Suspicious sign-in" || len(inc.Labels) != 1 || inc.Labels[0] != "triage" {
+			t.Errorf("incident %d = %+v", i, inc)
+		}
+	}
+
+	// The lower bound is inclusive
+	incidents, err = c.ListIncidents(context.Background(), workspace, start.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incidents) != 2 || !incidents[0].LastModifiedAt.Equal(start.Add(3*time.Hour)) {
+		t.Fatalf("incidents since the fourth = %+v, want the last two", incidents)
+	}
+}
+
+func TestClientAlertsAndEntities(t *testing.T) {
+	server := sentineltest.NewServer()
+	defer server.Close()
+	generated := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	inc := server.AddIncident(workspace, sentinel.Incident{Title: "Ransomware"},
+		[]sentinel.Alert{{
+			Name: "alert-1", DisplayName: "Mass file encryption", Severity: sentinel.SeverityHigh,
+			Status: "New", ProductName: "Microsoft Defender for Endpoint", Tactics: []string{"Impact"},
+			TimeGenerated: generated,
+		}},
+		[]sentinel.Entity{{
+			Name: "entity-1", Kind: "Host", FriendlyName: "WS-0042",
+			Properties: map[string]interface{}{"hostName": "ws-0042"},
+		}})
+	c := newClient(server)
+
+	alerts, err := c.Alerts(context.Background(), workspace, inc.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 1 || alerts[0].DisplayName != "Mass file encryption" || alerts[0].Severity != sentinel.SeverityHigh ||
+		len(alerts[0].Tactics) != 1 || !alerts[0].TimeGenerated.Equal(generated) {
+		t.Errorf("alerts = %+v", alerts)
+	}
+
+	entities, err := c.Entities(context.Background(), workspace, inc.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 1 || entities[0].Kind != "Host" || entities[0].FriendlyName != "WS-0042" ||
+		entities[0].Properties["hostName"] != "ws-0042" {
+		t.Errorf("entities = %+v", entities)
+	}
+
+	server.Fail[inc.Name+"/alerts"] = http.StatusInternalServerError
+	_, err = c.Alerts(context.Background(), workspace, inc.Name)
+	var apiErr *sentinel.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError || apiErr.Code != "InjectedFailure" {
+		t.Errorf("err = %v, want the API error", err)
+	}
+}
+
+func TestClientUpdateIncident(t *testing.T) {
+	server := sentineltest.NewServer()
+	defer server.Close()
+	inc := server.AddIncident(workspace, sentinel.Incident{Title: "Phishing"}, nil, nil)
+	c := newClient(server)
+
+	inc.Status = sentinel.StatusClosed
+	inc.Classification = sentinel.ClassificationFalsePositive
+	inc.ClassificationReason = "IncorrectAlertLogic"
+	inc.Owner = sentinel.Owner{Email: "analyst@contoso.com"}
+	updated, err := c.UpdateIncident(context.Background(), workspace, &inc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status != sentinel.StatusClosed || updated.ETag == inc.ETag || updated.Owner.Email != "analyst@contoso.com" {
+		t.Fatalf("updated = %+v, want closed with a new ETag", updated)
+	}
+	held, _ := server.Incident(workspace, inc.Name)
+	if held.Classification != sentinel.ClassificationFalsePositive {
+		t.Errorf("Sentinel holds %+v, want the classification written", held)
+	}
+
+	// Writing with the ETag read before the update is refused
+	_, err = c.UpdateIncident(context.Background(), workspace, &inc)
+	if !errors.Is(err, sentinel.ErrConflict) {
+		t.Fatalf("err = %v, want ErrConflict", err)
+	}
+	var apiErr *sentinel.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("err = %v, want a 412 API error", err)
+	}
+}
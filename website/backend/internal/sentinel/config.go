@@ -0,0 +1,287 @@
+package sentinel
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/validation"
+)
+
+// API versions of the resources a deployment creates besides the
+// Microsoft.SecurityInsights ones, which use APIVersion
+const (
+	ResourceGroupAPIVersion = "2021-04-01"
+	WorkspaceAPIVersion     = "2022-10-01"
+	WorkbookAPIVersion      = "2022-04-01"
+	LogicAppAPIVersion      = "2019-05-01"
+)
+
+const (
+	// DefaultRetentionDays is the workspace retention Sentinel includes at
+	// no extra charge
+	DefaultRetentionDays = 90
+	// DefaultWorkspaceSKU is the pay-as-you-go Log Analytics tier
+	DefaultWorkspaceSKU = "PerGB2018"
+)
+
+// DeploymentConfig describes the Sentinel workspace a deployment creates
+// in a client subscription and the content it installs there
+type DeploymentConfig struct {
+	Workspace Workspace `json:"workspace"`
+	Location  string    `json:"location"`
+	// TenantID is the client tenant, which tenant-wide data connectors
+	// collect from
+	TenantID       string          `json:"tenantId,omitempty"`
+	SKU            string          `json:"sku,omitempty"`
+	RetentionDays  int             `json:"retentionDays,omitempty"`
+	DataConnectors []DataConnector `json:"dataConnectors,omitempty"`
+	AnalyticsRules []AnalyticsRule `json:"analyticsRules,omitempty"`
+	Workbooks      []Workbook      `json:"workbooks,omitempty"`
+	Playbooks      []Playbook      `json:"playbooks,omitempty"`
+}
+
+// DataConnector connects a Microsoft data source to the workspace.
+// DataTypes defaults to every data type the kind offers.
+type DataConnector struct {
+	Kind      string   `json:"kind"`
+	Name      string   `json:"name,omitempty"`
+	DataTypes []string `json:"dataTypes,omitempty"`
+}
+
+// AnalyticsRule is a scheduled analytics rule. Durations are ISO 8601,
+// such as PT1H.
+type AnalyticsRule struct {
+	Name                string          `json:"name"`
+	DisplayName         string          `json:"displayName"`
+	Description         string          `json:"description,omitempty"`
+	Severity            string          `json:"severity"`
+	Enabled             *bool           `json:"enabled,omitempty"`
+	Query               string          `json:"query"`
+	QueryFrequency      string          `json:"queryFrequency,omitempty"`
+	QueryPeriod         string          `json:"queryPeriod,omitempty"`
+	TriggerOperator     string          `json:"triggerOperator,omitempty"`
+	TriggerThreshold    int             `json:"triggerThreshold"`
+	SuppressionDuration string          `json:"suppressionDuration,omitempty"`
+	SuppressionEnabled  bool            `json:"suppressionEnabled,omitempty"`
+	Tactics             []string        `json:"tactics,omitempty"`
+	Techniques          []string        `json:"techniques,omitempty"`
+	EntityMappings      []EntityMapping `json:"entityMappings,omitempty"`
+}
+
+// EntityMapping maps query columns to the identifiers of an entity type
+type EntityMapping struct {
+	EntityType    string         `json:"entityType"`
+	FieldMappings []FieldMapping `json:"fieldMappings"`
+}
+
+// FieldMapping maps a query column to an entity identifier
+type FieldMapping struct {
+	Identifier string `json:"identifier"`
+	ColumnName string `json:"columnName"`
+}
+
+// Workbook is a Sentinel workbook. SerializedData is the workbook's
+// gallery template JSON.
+type Workbook struct {
+	Name           string `json:"name,omitempty"`
+	DisplayName    string `json:"displayName"`
+	SerializedData string `json:"serializedData"`
+}
+
+// Playbook is a Logic App workflow deployed next to the workspace
+type Playbook struct {
+	Name       string                 `json:"name"`
+	Definition json.RawMessage        `json:"definition"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+func (dc DataConnector) name() string {
+	if dc.Name != "" {
+		return dc.Name
+	}
+	return dc.Kind
+}
+
+// plannedStep is a resource a deployment puts, in the order it is put
+type plannedStep struct {
+	name       string
+	resourceID string
+	apiVersion string
+	body       interface{}
+	// workspace marks the step whose resource is the workspace itself
+	workspace bool
+}
+
+// plan returns the steps that deploy a configuration. Each step puts one
+// resource with a name derived from the configuration, so running a step
+// again updates what it created before.
+func (c *DeploymentConfig) plan() []plannedStep {
+	ws := c.Workspace
+	resourceGroup := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", ws.SubscriptionID, ws.ResourceGroup)
+	insights := ws.ResourceID() + "/providers/Microsoft.SecurityInsights"
+
+	sku, retention := c.SKU, c.RetentionDays
+	if sku == "" {
+		sku = DefaultWorkspaceSKU
+	}
+	if retention == 0 {
+		retention = DefaultRetentionDays
+	}
+
+	steps := []plannedStep{
+		{
+			name:       "resourceGroup",
+			resourceID: resourceGroup,
+			apiVersion: ResourceGroupAPIVersion,
+			body:       map[string]interface{}{"location": c.Location},
+		},
+		{
+			name:       "workspace",
+			resourceID: ws.ResourceID(),
+			apiVersion: WorkspaceAPIVersion,
+			body: map[string]interface{}{
+				"location": c.Location,
+				"properties": map[string]interface{}{
+					"sku":             map[string]string{"name": sku},
+					"retentionInDays": retention,
+				},
+			},
+			workspace: true,
+		},
+		{
+			name:       "sentinel",
+			resourceID: insights + "/onboardingStates/default",
+			apiVersion: APIVersion,
+			body:       map[string]interface{}{"properties": map[string]interface{}{}},
+		},
+	}
+
+	for _, dc := range c.DataConnectors {
+		dataTypes := dc.DataTypes
+		if len(dataTypes) == 0 {
+			dataTypes = connectorKinds[dc.Kind].dataTypes
+		}
+		states := make(map[string]interface{})
+		for _, dt := range dataTypes {
+			states[dt] = map[string]string{"state": "enabled"}
+		}
+		properties := map[string]interface{}{"dataTypes": states}
+		if connectorKinds[dc.Kind].scope == scopeSubscription {
+			properties["subscriptionId"] = ws.SubscriptionID
+		} else {
+			properties["tenantId"] = c.TenantID
+		}
+		steps = append(steps, plannedStep{
+			name:       "dataConnector/" + dc.name(),
+			resourceID: insights + "/dataConnectors/" + resourceGUID(ws.ResourceID(), "dataConnector", dc.name()),
+			apiVersion: APIVersion,
+			body:       map[string]interface{}{"kind": dc.Kind, "properties": properties},
+		})
+	}
+
+	for _, rule := range c.AnalyticsRules {
+		steps = append(steps, plannedStep{
+			name:       "analyticsRule/" + rule.Name,
+			resourceID: insights + "/alertRules/" + resourceGUID(ws.ResourceID(), "analyticsRule", rule.Name),
+			apiVersion: APIVersion,
+			body:       map[string]interface{}{"kind": "Scheduled", "properties": rule.properties()},
+		})
+	}
+
+	for _, wb := range c.Workbooks {
+		name := wb.Name
+		if name == "" {
+			name = resourceGUID(ws.ResourceID(), "workbook", wb.DisplayName)
+		}
+		steps = append(steps, plannedStep{
+			name:       "workbook/" + wb.DisplayName,
+			resourceID: resourceGroup + "/providers/Microsoft.Insights/workbooks/" + name,
+			apiVersion: WorkbookAPIVersion,
+			body: map[string]interface{}{
+				"location": c.Location,
+				"kind":     "shared",
+				"properties": map[string]interface{}{
+					"displayName":    wb.DisplayName,
+					"serializedData": wb.SerializedData,
+					"category":       "sentinel",
+					"sourceId":       ws.ResourceID(),
+				},
+			},
+		})
+	}
+
+	for _, pb := range c.Playbooks {
+		parameters := make(map[string]interface{})
+		for k, v := range pb.Parameters {
+			parameters[k] = map[string]interface{}{"value": v}
+		}
+		steps = append(steps, plannedStep{
+			name:       "playbook/" + pb.Name,
+			resourceID: resourceGroup + "/providers/Microsoft.Logic/workflows/" + pb.Name,
+			apiVersion: LogicAppAPIVersion,
+			body: map[string]interface{}{
+				"location": c.Location,
+				"identity": map[string]string{"type": "SystemAssigned"},
+				"properties": map[string]interface{}{
+					"state":      "Enabled",
+					"definition": pb.Definition,
+					"parameters": parameters,
+				},
+			},
+		})
+	}
+	return steps
+}
+
+// properties renders a rule as the properties of a Scheduled alert rule,
+// filling in defaults
+func (r AnalyticsRule) properties() map[string]interface{} {
+	enabled := r.Enabled == nil || *r.Enabled
+	orDefault := func(v, def string) string {
+		if v == "" {
+			return def
+		}
+		return v
+	}
+	properties := map[string]interface{}{
+		"displayName":         r.DisplayName,
+		"description":         r.Description,
+		"severity":            orDefault(r.Severity, SeverityMedium),
+		"enabled":             enabled,
+		"query":               r.Query,
+		"queryFrequency":      orDefault(r.QueryFrequency, "PT1H"),
+		"queryPeriod":         orDefault(r.QueryPeriod, "PT1H"),
+		"triggerOperator":     orDefault(r.TriggerOperator, "GreaterThan"),
+		"triggerThreshold":    r.TriggerThreshold,
+		"suppressionDuration": orDefault(r.SuppressionDuration, "PT5H"),
+		"suppressionEnabled":  r.SuppressionEnabled,
+		"tactics":             nonNil(r.Tactics),
+		"techniques":          nonNil(r.Techniques),
+	}
+	if len(r.EntityMappings) > 0 {
+		properties["entityMappings"] = r.EntityMappings
+	}
+	return properties
+}
+
+// resourceGUID returns the resource name for a configured item. Sentinel
+// and workbook resources are named by GUID, so the GUID is derived from
+// the item's name to keep redeployments pointing at the same resource.
+func resourceGUID(scope, kind, name string) string {
+	if validation.ValidateUUID(name) == nil {
+		return strings.ToLower(name)
+	}
+	sum := sha1.Sum([]byte(strings.ToLower(scope + "|" + kind + "|" + name)))
+	sum[6] = sum[6]&0x0f | 0x50 // version 5
+	sum[8] = sum[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
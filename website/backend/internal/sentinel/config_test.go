@@ -0,0 +1,115 @@
+package sentinel
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	disabled := false
+	cfg := &DeploymentConfig{
+		Workspace: Workspace{SubscriptionID: "sub", ResourceGroup: "rg-sentinel", Name: "law-contoso"},
+		Location:  "westeurope",
+		TenantID:  "tenant",
+		DataConnectors: []DataConnector{
+			{Kind: "AzureSecurityCenter"},
+			{Kind: "Office365", Name: "o365-exchange", DataTypes: []string{"exchange"}},
+		},
+		AnalyticsRules: []AnalyticsRule{
+			{Name: "brute-force", DisplayName: "Brute force", Query: "SigninLogs", Enabled: &disabled,
+				Tactics: []string{"CredentialAccess"}},
+		},
+		Workbooks: []Workbook{{DisplayName: "Overview", SerializedData: "{}"}},
+		Playbooks: []Playbook{{Name: "pb-isolate", Definition: json.RawMessage(`{}`),
+			Parameters: map[string]interface{}{"tenant": "contoso"}}},
+	}
+	steps := cfg.plan()
+
+	var names []string
+	for _, s := range steps {
+		names = append(names, s.name)
+	}
+	want := []string{"resourceGroup", "workspace", "sentinel", "dataConnector/AzureSecurityCenter",
+		"dataConnector/o365-exchange", "analyticsRule/brute-force", "workbook/Overview", "playbook/pb-isolate"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("steps = %v, want %v", names, want)
+	}
+
+	const rg = "/subscriptions/sub/resourceGroups/rg-sentinel"
+	const insights = rg + "/providers/Microsoft.OperationalInsights/workspaces/law-contoso/providers/Microsoft.SecurityInsights"
+	if steps[0].resourceID != rg || steps[0].apiVersion != ResourceGroupAPIVersion {
+		t.Errorf("resource group step = %+v", steps[0])
+	}
+	if !steps[1].workspace || steps[1].resourceID != cfg.Workspace.ResourceID() {
+		t.Errorf("workspace step = %+v", steps[1])
+	}
+	workspace := steps[1].body.(map[string]interface{})["properties"].(map[string]interface{})
+	if workspace["sku"].(map[string]string)["name"] != DefaultWorkspaceSKU || workspace["retentionInDays"] != DefaultRetentionDays {
+		t.Errorf("workspace properties = %v, want the defaults", workspace)
+	}
+	if steps[2].resourceID != insights+"/onboardingStates/default" || steps[2].apiVersion != APIVersion {
+		t.Errorf("onboarding step = %+v", steps[2])
+	}
+
+	// Subscription connectors name the subscription, tenant ones the tenant,
+	// and connectors without data types get every type of their kind
+	asc := steps[3].body.(map[string]interface{})["properties"].(map[string]interface{})
+	if asc["subscriptionId"] != "sub" || asc["tenantId"] != nil ||
+		!reflect.DeepEqual(asc["dataTypes"], map[string]interface{}{"alerts": map[string]string{"state": "enabled"}}) {
+		t.Errorf("Defender for Cloud connector = %v", asc)
+	}
+	o365 := steps[4].body.(map[string]interface{})["properties"].(map[string]interface{})
+	if o365["tenantId"] != "tenant" || len(o365["dataTypes"].(map[string]interface{})) != 1 {
+		t.Errorf("Office 365 connector = %v", o365)
+	}
+	if !strings.HasPrefix(steps[4].resourceID, insights+"/dataConnectors/") {
+		t.Errorf("connector resource = %s", steps[4].resourceID)
+	}
+
+	rule := steps[5].body.(map[string]interface{})
+	properties := rule["properties"].(map[string]interface{})
+	if rule["kind"] != "Scheduled" || properties["enabled"] != false || properties["severity"] != SeverityMedium ||
+		properties["queryFrequency"] != "PT1H" || properties["suppressionDuration"] != "PT5H" ||
+		properties["triggerOperator"] != "GreaterThan" || !reflect.DeepEqual(properties["techniques"], []string{}) {
+		t.Errorf("analytics rule = %v, want the defaults filled in", rule)
+	}
+	if _, ok := properties["entityMappings"]; ok {
+		t.Error("rule without entity mappings has an entityMappings property")
+	}
+
+	if want := rg + "/providers/Microsoft.Insights/workbooks/" +
+		resourceGUID(cfg.Workspace.ResourceID(), "workbook", "Overview"); steps[6].resourceID != want {
+		t.Errorf("workbook resource = %s, want %s", steps[6].resourceID, want)
+	}
+	playbook := steps[7].body.(map[string]interface{})["properties"].(map[string]interface{})
+	if steps[7].resourceID != rg+"/providers/Microsoft.Logic/workflows/pb-isolate" ||
+		!reflect.DeepEqual(playbook["parameters"], map[string]interface{}{"tenant": map[string]interface{}{"value": "contoso"}}) {
+		t.Errorf("playbook step = %+v", steps[7])
+	}
+
+	// Planning again names every resource the same, so redeploying updates
+	for i, s := range cfg.plan() {
+		if s.resourceID != steps[i].resourceID {
+			t.Errorf("step %s planned as %s, then %s", s.name, steps[i].resourceID, s.resourceID)
+		}
+	}
+}
+
+func TestResourceGUID(t *testing.T) {
+	a := resourceGUID("/scope", "analyticsRule", "Brute Force")
+	if a != resourceGUID("/SCOPE", "analyticsRule", "brute force") {
+		t.Error("resourceGUID() depends on case")
+	}
+	if a == resourceGUID("/scope", "workbook", "Brute Force") || a == resourceGUID("/other", "analyticsRule", "Brute Force") {
+		t.Error("resourceGUID() collides across kinds or scopes")
+	}
+	if len(a) != 36 || a[14] != '5' || !strings.ContainsRune("89ab", rune(a[19])) {
+		t.Errorf("resourceGUID() = %s, want a version 5 UUID", a)
+	}
+	const id = "8F14E45F-CEEA-4671-9F1B-3C1F8E0A2B7D"
+	if got := resourceGUID("/scope", "workbook", id); got != strings.ToLower(id) {
+		t.Errorf("resourceGUID(%s) = %s, want the UUID kept", id, got)
+	}
+}
@@ -0,0 +1,446 @@
+package sentinel
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/arm"
+)
+
+// Deployment statuses
+const (
+	DeploymentPending        = "pending"
+	DeploymentRunning        = "deploying"
+	DeploymentCompleted      = "completed"
+	DeploymentFailed         = "failed"
+	DeploymentTearingDown    = "tearing_down"
+	DeploymentTornDown       = "torn_down"
+	DeploymentTeardownFailed = "teardown_failed"
+)
+
+// Step statuses
+const (
+	StepPending   = "pending"
+	StepRunning   = "running"
+	StepSucceeded = "succeeded"
+	StepFailed    = "failed"
+	// StepSkipped marks a resource teardown left in place because it
+	// existed before the deployment
+	StepSkipped = "skipped"
+	StepRemoved = "removed"
+)
+
+// staleAfter is how long a deployment may go without progress before it is
+// taken to have been interrupted, such as by a restart, and may be run again
+const staleAfter = time.Hour
+
+var (
+	// ErrDeploymentBusy is returned when a deployment is already running
+	ErrDeploymentBusy = errors.New("deployment is already running")
+	// ErrDeploymentState is returned when a deployment's status does not
+	// allow the action
+	ErrDeploymentState = errors.New("deployment status does not allow this action")
+)
+
+// Step is the progress of one resource of a deployment, stored in
+// deployment_steps
+type Step struct {
+	Name       string `json:"name"`
+	ResourceID string `json:"resourceId"`
+	Status     string `json:"status"`
+	// Existed is set when the resource was found before the deployment
+	// first put it; teardown leaves such resources in place
+	Existed    bool       `json:"existed,omitempty"`
+	Attempts   int        `json:"attempts"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Deployment is a row of deployment_mgmt.sentinel_deployments
+type Deployment struct {
+	ID          string           `json:"id"`
+	ClientID    string           `json:"clientId"`
+	Status      string           `json:"status"`
+	Config      DeploymentConfig `json:"configuration"`
+	Steps       []Step           `json:"steps"`
+	WorkspaceID string           `json:"workspaceId,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	DeployedAt  *time.Time       `json:"deployedAt,omitempty"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	UpdatedAt   time.Time        `json:"updatedAt"`
+}
+
+// Deployer deploys Sentinel workspaces into client subscriptions as a
+// sequence of resource puts, recording each step so a failed deployment
+// resumes where it stopped
+type Deployer struct {
+	// Validator checks configurations before they are deployed
+	Validator *Validator
+	db        *sql.DB
+	managers  arm.Source
+}
+
+// NewDeployer creates a deployer
+func NewDeployer(db *sql.DB, managers arm.Source) *Deployer {
+	return &Deployer{Validator: NewValidator(), db: db, managers: managers}
+}
+
+// Create validates a configuration and records a pending deployment of it.
+// A configuration with errors is refused with a ValidationError.
+func (d *Deployer) Create(ctx context.Context, clientID string, cfg DeploymentConfig) (*Deployment, error) {
+	result, err := d.Validate(ctx, clientID, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	dep := &Deployment{ClientID: clientID, Status: DeploymentPending, Config: cfg}
+	dep.Steps = mergeSteps(cfg.plan(), nil)
+
+	configuration, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := json.Marshal(dep.Steps)
+	if err != nil {
+		return nil, err
+	}
+	err = d.db.QueryRowContext(ctx, `
+		INSERT INTO deployment_mgmt.sentinel_deployments
+			(client_id, workspace_name, subscription_id, resource_group, location,
+			 deployment_status, configuration, deployment_steps)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at, updated_at`,
+		clientID, cfg.Workspace.Name, cfg.Workspace.SubscriptionID, cfg.Workspace.ResourceGroup,
+		cfg.Location, dep.Status, configuration, steps).Scan(&dep.ID, &dep.CreatedAt, &dep.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return dep, nil
+}
+
+const deploymentColumns = `
+	id, COALESCE(client_id::text, ''), deployment_status, configuration, COALESCE(deployment_steps, '[]'),
+	subscription_id, resource_group, workspace_name, location, COALESCE(workspace_id, ''),
+	COALESCE(error_message, ''), deployed_at, created_at, updated_at`
+
+func scanDeployment(row interface{ Scan(...interface{}) error }) (*Deployment, error) {
+	var dep Deployment
+	var configuration, steps []byte
+	var ws Workspace
+	var location string
+	var deployedAt sql.NullTime
+	if err := row.Scan(&dep.ID, &dep.ClientID, &dep.Status, &configuration, &steps,
+		&ws.SubscriptionID, &ws.ResourceGroup, &ws.Name, &location, &dep.WorkspaceID,
+		&dep.Error, &deployedAt, &dep.CreatedAt, &dep.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(configuration, &dep.Config); err != nil {
+		return nil, fmt.Errorf("deployment %s has an unreadable configuration: %v", dep.ID, err)
+	}
+	if err := json.Unmarshal(steps, &dep.Steps); err != nil {
+		return nil, fmt.Errorf("deployment %s has unreadable steps: %v", dep.ID, err)
+	}
+	// The columns are authoritative for deployments registered by hand
+	dep.Config.Workspace, dep.Config.Location = ws, location
+	if dep.Steps == nil {
+		dep.Steps = []Step{}
+	}
+	if deployedAt.Valid {
+		dep.DeployedAt = &deployedAt.Time
+	}
+	return &dep, nil
+}
+
+// Deployment returns a deployment with its steps
+func (d *Deployer) Deployment(ctx context.Context, id string) (*Deployment, error) {
+	dep, err := scanDeployment(d.db.QueryRowContext(ctx, "SELECT "+deploymentColumns+`
+		FROM deployment_mgmt.sentinel_deployments WHERE id::text = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return dep, err
+}
+
+// Deployments returns the deployments of a client, or of every client when
+// clientID is empty, newest first
+func (d *Deployer) Deployments(ctx context.Context, clientID string) ([]*Deployment, error) {
+	query := "SELECT " + deploymentColumns + " FROM deployment_mgmt.sentinel_deployments"
+	var args []interface{}
+	if clientID != "" {
+		query += " WHERE client_id::text = $1"
+		args = append(args, clientID)
+	}
+	rows, err := d.db.QueryContext(ctx, query+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deployments := []*Deployment{}
+	for rows.Next() {
+		dep, err := scanDeployment(rows)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, dep)
+	}
+	return deployments, rows.Err()
+}
+
+// Deploy runs the steps of a deployment that have not succeeded yet and
+// returns the deployment as it ended. A pending deployment starts from
+// the first step; a failed one resumes at the step that failed.
+func (d *Deployer) Deploy(ctx context.Context, id string) (*Deployment, error) {
+	dep, err := d.claim(ctx, id, DeploymentRunning)
+	if err != nil {
+		return nil, err
+	}
+	return dep, d.deploy(ctx, dep)
+}
+
+// Teardown deletes what a deployment created, newest resource first, and
+// returns the deployment as it ended
+func (d *Deployer) Teardown(ctx context.Context, id string) (*Deployment, error) {
+	dep, err := d.claim(ctx, id, DeploymentTearingDown)
+	if err != nil {
+		return nil, err
+	}
+	return dep, d.teardown(ctx, dep)
+}
+
+// Start claims a deployment and deploys it in the background. It returns
+// the deployment as it started.
+func (d *Deployer) Start(ctx context.Context, id string) (*Deployment, error) {
+	return d.start(ctx, id, DeploymentRunning, d.deploy)
+}
+
+// StartTeardown claims a deployment and tears it down in the background.
+// It returns the deployment as teardown started.
+func (d *Deployer) StartTeardown(ctx context.Context, id string) (*Deployment, error) {
+	return d.start(ctx, id, DeploymentTearingDown, d.teardown)
+}
+
+func (d *Deployer) start(ctx context.Context, id, status string, run func(context.Context, *Deployment) error) (*Deployment, error) {
+	dep, err := d.claim(ctx, id, status)
+	if err != nil {
+		return nil, err
+	}
+	started := *dep
+	started.Steps = append([]Step{}, dep.Steps...)
+	go func() {
+		if err := run(context.WithoutCancel(ctx), dep); err != nil {
+			log.Printf("Sentinel deployment %s ended with %s: %v", dep.ID, dep.Status, err)
+		}
+	}()
+	return &started, nil
+}
+
+// claim moves a deployment into a running status so no one else runs it
+func (d *Deployer) claim(ctx context.Context, id, status string) (*Deployment, error) {
+	dep, err := d.Deployment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	running := dep.Status == DeploymentRunning || dep.Status == DeploymentTearingDown
+	if running && time.Since(dep.UpdatedAt) < staleAfter {
+		return nil, ErrDeploymentBusy
+	}
+	if !running {
+		allowed := map[string][]string{
+			DeploymentRunning:     {DeploymentPending, DeploymentFailed, DeploymentTornDown},
+			DeploymentTearingDown: {DeploymentCompleted, DeploymentFailed, DeploymentTeardownFailed},
+		}[status]
+		if !contains(allowed, dep.Status) {
+			return nil, fmt.Errorf("%w: deployment is %s", ErrDeploymentState, dep.Status)
+		}
+	}
+	if dep.ClientID == "" {
+		return nil, fmt.Errorf("deployment %s belongs to no client", dep.ID)
+	}
+
+	// Guarded by the status read so two callers cannot both claim it
+	res, err := d.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.sentinel_deployments
+		SET deployment_status = $3, error_message = NULL, updated_at = NOW()
+		WHERE id = $1 AND deployment_status = $2
+			AND (deployment_status NOT IN ('deploying', 'tearing_down')
+				OR updated_at < NOW() - $4 * INTERVAL '1 second')`,
+		dep.ID, dep.Status, status, staleAfter.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrDeploymentBusy
+	}
+	dep.Status, dep.Error = status, ""
+	return dep, nil
+}
+
+func (d *Deployer) deploy(ctx context.Context, dep *Deployment) error {
+	manager, err := d.managers.Manager(ctx, dep.ClientID)
+	if err != nil {
+		return d.finish(ctx, dep, DeploymentFailed, err)
+	}
+	plan := dep.Config.plan()
+	dep.Steps = mergeSteps(plan, dep.Steps)
+
+	for i, p := range plan {
+		step := &dep.Steps[i]
+		if step.Status == StepSucceeded {
+			continue
+		}
+		started := time.Now().UTC()
+		step.Status, step.Error, step.StartedAt, step.FinishedAt = StepRunning, "", &started, nil
+		if err := d.saveSteps(ctx, dep); err != nil {
+			return err
+		}
+
+		err := d.apply(ctx, manager, p, step, dep)
+		finished := time.Now().UTC()
+		step.FinishedAt = &finished
+		if err != nil {
+			step.Status, step.Error = StepFailed, err.Error()
+			return d.finish(ctx, dep, DeploymentFailed, fmt.Errorf("step %s failed: %v", step.Name, err))
+		}
+		step.Status = StepSucceeded
+		if err := d.saveSteps(ctx, dep); err != nil {
+			return err
+		}
+	}
+	return d.finish(ctx, dep, DeploymentCompleted, nil)
+}
+
+// apply puts the resource of a step. The first attempt checks whether the
+// resource already exists so teardown knows to leave it alone.
+func (d *Deployer) apply(ctx context.Context, manager arm.ResourceManager, p plannedStep, step *Step, dep *Deployment) error {
+	if step.Attempts == 0 {
+		err := manager.Get(ctx, p.resourceID, p.apiVersion, nil)
+		if err == nil {
+			step.Existed = true
+		} else if !arm.IsNotFound(err) {
+			return err
+		}
+	}
+	step.Attempts++
+
+	var out struct {
+		Properties struct {
+			CustomerID string `json:"customerId"`
+		} `json:"properties"`
+	}
+	if err := manager.Put(ctx, p.resourceID, p.apiVersion, p.body, &out); err != nil {
+		return err
+	}
+	if p.workspace && out.Properties.CustomerID != "" {
+		dep.WorkspaceID = out.Properties.CustomerID
+	}
+	return nil
+}
+
+func (d *Deployer) teardown(ctx context.Context, dep *Deployment) error {
+	manager, err := d.managers.Manager(ctx, dep.ClientID)
+	if err != nil {
+		return d.finish(ctx, dep, DeploymentTeardownFailed, err)
+	}
+	plan := dep.Config.plan()
+	dep.Steps = mergeSteps(plan, dep.Steps)
+
+	for i := len(plan) - 1; i >= 0; i-- {
+		step := &dep.Steps[i]
+		switch {
+		case step.Attempts == 0 || step.Status == StepRemoved || step.Status == StepSkipped:
+			continue
+		case step.Existed:
+			step.Status = StepSkipped
+			continue
+		}
+		started := time.Now().UTC()
+		step.Status, step.Error, step.StartedAt, step.FinishedAt = StepRunning, "", &started, nil
+		if err := d.saveSteps(ctx, dep); err != nil {
+			return err
+		}
+
+		err := manager.Delete(ctx, plan[i].resourceID, plan[i].apiVersion)
+		finished := time.Now().UTC()
+		step.FinishedAt = &finished
+		if err != nil {
+			step.Status, step.Error = StepFailed, err.Error()
+			return d.finish(ctx, dep, DeploymentTeardownFailed,
+				fmt.Errorf("teardown of %s failed: %v", step.Name, err))
+		}
+		step.Status = StepRemoved
+		if err := d.saveSteps(ctx, dep); err != nil {
+			return err
+		}
+	}
+	dep.WorkspaceID = ""
+	return d.finish(ctx, dep, DeploymentTornDown, nil)
+}
+
+// mergeSteps lines up the recorded progress of a deployment with its plan
+func mergeSteps(plan []plannedStep, recorded []Step) []Step {
+	byName := make(map[string]Step)
+	for _, s := range recorded {
+		byName[s.Name] = s
+	}
+	steps := make([]Step, len(plan))
+	for i, p := range plan {
+		step, ok := byName[p.name]
+		if !ok {
+			step = Step{Name: p.name, Status: StepPending}
+		}
+		step.ResourceID = p.resourceID
+		steps[i] = step
+	}
+	return steps
+}
+
+func (d *Deployer) saveSteps(ctx context.Context, dep *Deployment) error {
+	steps, err := json.Marshal(dep.Steps)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.sentinel_deployments
+		SET deployment_steps = $2, updated_at = NOW()
+		WHERE id = $1`, dep.ID, steps)
+	return err
+}
+
+// finish records the outcome of a run and returns runErr
+func (d *Deployer) finish(ctx context.Context, dep *Deployment, status string, runErr error) error {
+	dep.Status, dep.Error = status, ""
+	if runErr != nil {
+		dep.Error = runErr.Error()
+	}
+	steps, err := json.Marshal(dep.Steps)
+	if err != nil {
+		return err
+	}
+	var deployedAt sql.NullTime
+	err = d.db.QueryRowContext(ctx, `
+		UPDATE deployment_mgmt.sentinel_deployments
+		SET deployment_status = $2, deployment_steps = $3, error_message = $4, workspace_id = $5,
+			deployed_at = CASE WHEN $6 THEN NOW() ELSE deployed_at END, updated_at = NOW()
+		WHERE id = $1
+		RETURNING deployed_at, updated_at`,
+		dep.ID, status, steps, nullString(dep.Error), nullString(dep.WorkspaceID),
+		status == DeploymentCompleted).Scan(&deployedAt, &dep.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if deployedAt.Valid {
+		dep.DeployedAt = &deployedAt.Time
+	}
+	return runErr
+}
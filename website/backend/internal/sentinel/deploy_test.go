@@ -0,0 +1,235 @@
+package sentinel_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/arm/armtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/sentinel"
+)
+
+// deployConfig returns a configuration that passes validation without a
+// client license
+func deployConfig() sentinel.DeploymentConfig {
+	return sentinel.DeploymentConfig{
+		Workspace:      workspace,
+		Location:       "westeurope",
+		DataConnectors: []sentinel.DataConnector{{Kind: "AzureSecurityCenter"}},
+		Workbooks:      []sentinel.Workbook{{DisplayName: "Overview", SerializedData: `{"version": "Notebook/1.0", "items": []}`}},
+	}
+}
+
+const resourceGroupID = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-sentinel"
+
+// steps returns the status of each step of a deployment by name
+func steps(dep *sentinel.Deployment) map[string]string {
+	got := make(map[string]string)
+	for _, s := range dep.Steps {
+		got[s.Name] = s.Status
+	}
+	return got
+}
+
+// puts counts the puts of a resource
+func puts(server *armtest.Server, resourceID string) int {
+	n := 0
+	for _, r := range server.Requests() {
+		if strings.EqualFold(r, "PUT "+resourceID) {
+			n++
+		}
+	}
+	return n
+}
+
+func newDeployment(t *testing.T, db *sql.DB, d *sentinel.Deployer) *sentinel.Deployment {
+	t.Helper()
+	dep, err := d.Create(context.Background(), dbtest.CreateClient(t, db, "Contoso"), deployConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dep
+}
+
+func TestDeployerDeploy(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	server := armtest.NewServer()
+	defer server.Close()
+	server.AsyncPolls = 1
+	d := sentinel.NewDeployer(db, server.Source())
+
+	dep := newDeployment(t, db, d)
+	if dep.Status != sentinel.DeploymentPending || len(dep.Steps) != 5 || dep.Steps[0].Status != sentinel.StepPending {
+		t.Fatalf("created deployment = %+v, want five pending steps", dep)
+	}
+
+	dep, err := d.Deploy(ctx, dep.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dep.Status != sentinel.DeploymentCompleted || dep.DeployedAt == nil || dep.Error != "" {
+		t.Errorf("deployment = %s (%s), want completed", dep.Status, dep.Error)
+	}
+	for name, status := range steps(dep) {
+		if status != sentinel.StepSucceeded {
+			t.Errorf("step %s is %s, want succeeded", name, status)
+		}
+	}
+	if _, ok := server.Resource(workspace.ResourceID() + "/providers/Microsoft.SecurityInsights/onboardingStates/default"); !ok {
+		t.Error("Sentinel was not onboarded to the workspace")
+	}
+
+	// The stored deployment carries the Log Analytics workspace ID, which
+	// the incident syncer and hunters use
+	stored, err := d.Deployment(ctx, dep.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != sentinel.DeploymentCompleted || stored.WorkspaceID == "" || stored.WorkspaceID != dep.WorkspaceID {
+		t.Errorf("stored deployment = %s with workspace %q, want completed with %q",
+			stored.Status, stored.WorkspaceID, dep.WorkspaceID)
+	}
+
+	if _, err := d.Deploy(ctx, dep.ID); !errors.Is(err, sentinel.ErrDeploymentState) {
+		t.Errorf("Deploy() of a completed deployment = %v, want ErrDeploymentState", err)
+	}
+}
+
+func TestDeployerDeployFailure(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	server := armtest.NewServer()
+	defer server.Close()
+	d := sentinel.NewDeployer(db, server.Source())
+	dep := newDeployment(t, db, d)
+
+	server.Fail["/workbooks/"] = http.StatusForbidden
+	failed, err := d.Deploy(ctx, dep.ID)
+	if err == nil || failed.Status != sentinel.DeploymentFailed || !strings.Contains(failed.Error, "workbook/Overview") {
+		t.Fatalf("Deploy() = %v with status %s (%s), want failed at the workbook", err, failed.Status, failed.Error)
+	}
+	got := steps(failed)
+	if got["workbook/Overview"] != sentinel.StepFailed || got["dataConnector/AzureSecurityCenter"] != sentinel.StepSucceeded {
+		t.Errorf("steps after the failure = %v", got)
+	}
+	if stored, err := d.Deployment(ctx, dep.ID); err != nil || stored.Status != sentinel.DeploymentFailed || stored.Error == "" {
+		t.Errorf("stored deployment = %+v, %v; want failed with the error", stored, err)
+	}
+
+	// Deploying again resumes at the failed step
+	delete(server.Fail, "/workbooks/")
+	resumed, err := d.Deploy(ctx, dep.ID)
+	if err != nil || resumed.Status != sentinel.DeploymentCompleted {
+		t.Fatalf("resumed Deploy() = %v with status %s, want completed", err, resumed.Status)
+	}
+	if n := puts(server, resourceGroupID); n != 1 {
+		t.Errorf("resource group put %d times, want once", n)
+	}
+	for _, s := range resumed.Steps {
+		if s.Status != sentinel.StepSucceeded || s.Error != "" {
+			t.Errorf("step %s = %s (%s) after resuming, want succeeded", s.Name, s.Status, s.Error)
+		}
+	}
+}
+
+func TestDeployerTeardown(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	server := armtest.NewServer()
+	defer server.Close()
+	// The client's resource group existed before the deployment
+	server.AddResource(resourceGroupID, map[string]interface{}{"location": "westeurope"})
+	d := sentinel.NewDeployer(db, server.Source())
+	dep := newDeployment(t, db, d)
+	if _, err := d.Deploy(ctx, dep.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Fail[workspace.Name+"/providers/Microsoft.SecurityInsights/dataConnectors"] = http.StatusConflict
+	failed, err := d.Teardown(ctx, dep.ID)
+	if err == nil || failed.Status != sentinel.DeploymentTeardownFailed {
+		t.Fatalf("Teardown() = %v with status %s, want teardown_failed", err, failed.Status)
+	}
+	if got := steps(failed); got["workbook/Overview"] != sentinel.StepRemoved ||
+		got["dataConnector/AzureSecurityCenter"] != sentinel.StepFailed || got["workspace"] != sentinel.StepSucceeded {
+		t.Errorf("steps after the failed teardown = %v, want it stopped at the data connector", got)
+	}
+	if _, ok := server.Resource(workspace.ResourceID()); !ok {
+		t.Error("workspace deleted after an earlier step failed")
+	}
+
+	delete(server.Fail, workspace.Name+"/providers/Microsoft.SecurityInsights/dataConnectors")
+	torn, err := d.Teardown(ctx, dep.ID)
+	if err != nil || torn.Status != sentinel.DeploymentTornDown || torn.WorkspaceID != "" {
+		t.Fatalf("retried Teardown() = %v with status %s, want torn_down", err, torn.Status)
+	}
+	if got := steps(torn); got["resourceGroup"] != sentinel.StepSkipped || got["workspace"] != sentinel.StepRemoved {
+		t.Errorf("steps after teardown = %v, want the pre-existing resource group skipped", got)
+	}
+	if _, ok := server.Resource(resourceGroupID); !ok {
+		t.Error("teardown deleted the client's own resource group")
+	}
+	if _, ok := server.Resource(workspace.ResourceID()); ok {
+		t.Error("teardown left the workspace")
+	}
+
+	// A torn down deployment can be deployed again
+	if redeployed, err := d.Deploy(ctx, dep.ID); err != nil || redeployed.Status != sentinel.DeploymentCompleted {
+		t.Errorf("Deploy() after teardown = %v with status %s, want completed", err, redeployed.Status)
+	}
+}
+
+func TestDeployerClaim(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	server := armtest.NewServer()
+	defer server.Close()
+	d := sentinel.NewDeployer(db, server.Source())
+	dep := newDeployment(t, db, d)
+
+	if _, err := db.Exec(`
+		UPDATE deployment_mgmt.sentinel_deployments
+		SET deployment_status = 'deploying', updated_at = NOW() WHERE id = $1`, dep.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Deploy(ctx, dep.ID); err != sentinel.ErrDeploymentBusy {
+		t.Fatalf("Deploy() of a running deployment = %v, want ErrDeploymentBusy", err)
+	}
+	if _, err := d.Teardown(ctx, dep.ID); err != sentinel.ErrDeploymentBusy {
+		t.Fatalf("Teardown() of a running deployment = %v, want ErrDeploymentBusy", err)
+	}
+
+	// A run that stopped making progress was interrupted and is taken over
+	if _, err := db.Exec(`
+		UPDATE deployment_mgmt.sentinel_deployments
+		SET updated_at = NOW() - INTERVAL '2 hours' WHERE id = $1`, dep.ID); err != nil {
+		t.Fatal(err)
+	}
+	if taken, err := d.Deploy(ctx, dep.ID); err != nil || taken.Status != sentinel.DeploymentCompleted {
+		t.Errorf("Deploy() of a stale deployment = %v, want it taken over and completed", err)
+	}
+
+	if _, err := d.Deploy(ctx, "00000000-0000-0000-0000-000000000000"); err != sentinel.ErrNotFound {
+		t.Errorf("Deploy() of an unknown deployment = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDeployerCreateInvalid(t *testing.T) {
+	db := dbtest.Open(t)
+	server := armtest.NewServer()
+	defer server.Close()
+	d := sentinel.NewDeployer(db, server.Source())
+
+	cfg := deployConfig()
+	cfg.Location = "atlantis"
+	_, err := d.Create(context.Background(), dbtest.CreateClient(t, db, "Contoso"), cfg)
+	var invalid *sentinel.ValidationError
+	if !errors.As(err, &invalid) || !errors.Is(err, sentinel.ErrInvalidConfig) {
+		t.Errorf("Create() of an invalid configuration = %v, want a ValidationError", err)
+	}
+}
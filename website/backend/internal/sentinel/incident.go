@@ -0,0 +1,178 @@
+// Package sentinel connects to the Microsoft Sentinel workspaces of client
+// tenants: it syncs their incidents into local tables and writes triage
+// decisions back
+package sentinel
+
+import (
+	"fmt"
+	"time"
+)
+
+// Incident statuses
+const (
+	StatusNew    = "New"
+	StatusActive = "Active"
+	StatusClosed = "Closed"
+)
+
+// Incident severities
+const (
+	SeverityInformational = "Informational"
+	SeverityLow           = "Low"
+	SeverityMedium        = "Medium"
+	SeverityHigh          = "High"
+)
+
+// Classifications of closed incidents
+const (
+	ClassificationUndetermined   = "Undetermined"
+	ClassificationTruePositive   = "TruePositive"
+	ClassificationBenignPositive = "BenignPositive"
+	ClassificationFalsePositive  = "FalsePositive"
+)
+
+// classificationReasons are the reasons Sentinel accepts for each
+// classification, the default first
+var classificationReasons = map[string][]string{
+	ClassificationUndetermined:   {""},
+	ClassificationTruePositive:   {"SuspiciousActivity"},
+	ClassificationBenignPositive: {"SuspiciousButExpected"},
+	ClassificationFalsePositive:  {"IncorrectAlertLogic", "InaccurateData"},
+}
+
+// Workspace identifies a Log Analytics workspace with Sentinel enabled
+type Workspace struct {
+	SubscriptionID string `json:"subscriptionId"`
+	ResourceGroup  string `json:"resourceGroup"`
+	Name           string `json:"name"`
+}
+
+// ResourceID returns the Azure resource ID of the workspace
+func (w Workspace) ResourceID() string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.OperationalInsights/workspaces/%s",
+		w.SubscriptionID, w.ResourceGroup, w.Name)
+}
+
+// Owner is the analyst an incident is assigned to
+type Owner struct {
+	ObjectID          string `json:"objectId,omitempty"`
+	Email             string `json:"email,omitempty"`
+	AssignedTo        string `json:"assignedTo,omitempty"`
+	UserPrincipalName string `json:"userPrincipalName,omitempty"`
+}
+
+// Incident is a Sentinel incident. ID is the local identifier of a synced
+// incident; Name is the incident's identifier in Sentinel.
+type Incident struct {
+	ID                    string     `json:"id,omitempty"`
+	DeploymentID          string     `json:"deploymentId,omitempty"`
+	ClientID              string     `json:"clientId,omitempty"`
+	Name                  string     `json:"name"`
+	ResourceID            string     `json:"resourceId,omitempty"`
+	ETag                  string     `json:"etag,omitempty"`
+	Number                int        `json:"number"`
+	Title                 string     `json:"title"`
+	Description           string     `json:"description,omitempty"`
+	Severity              string     `json:"severity"`
+	Status                string     `json:"status"`
+	Classification        string     `json:"classification,omitempty"`
+	ClassificationReason  string     `json:"classificationReason,omitempty"`
+	ClassificationComment string     `json:"classificationComment,omitempty"`
+	Owner                 Owner      `json:"owner"`
+	Labels                []string   `json:"labels"`
+	AlertCount            int        `json:"alertCount"`
+	FirstActivityAt       *time.Time `json:"firstActivityAt,omitempty"`
+	LastActivityAt        *time.Time `json:"lastActivityAt,omitempty"`
+	CreatedAt             time.Time  `json:"createdAt"`
+	LastModifiedAt        time.Time  `json:"lastModifiedAt"`
+	URL                   string     `json:"url,omitempty"`
+	SyncedAt              time.Time  `json:"syncedAt,omitempty"`
+}
+
+// Alert is an alert grouped into an incident
+type Alert struct {
+	Name          string    `json:"name"`
+	DisplayName   string    `json:"displayName"`
+	Severity      string    `json:"severity"`
+	Status        string    `json:"status"`
+	ProductName   string    `json:"productName,omitempty"`
+	Tactics       []string  `json:"tactics"`
+	TimeGenerated time.Time `json:"timeGenerated"`
+}
+
+// Entity is an account, host, IP address or other entity involved in an
+// incident. Properties holds the kind-specific fields as Sentinel reports
+// them.
+type Entity struct {
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	FriendlyName string                 `json:"friendlyName,omitempty"`
+	Properties   map[string]interface{} `json:"properties,omitempty"`
+}
+
+// IncidentUpdate is a triage decision written back to Sentinel. Nil fields
+// are left unchanged.
+type IncidentUpdate struct {
+	Status                *string `json:"status,omitempty"`
+	Severity              *string `json:"severity,omitempty"`
+	Classification        *string `json:"classification,omitempty"`
+	ClassificationReason  *string `json:"classificationReason,omitempty"`
+	ClassificationComment *string `json:"classificationComment,omitempty"`
+	Owner                 *Owner  `json:"owner,omitempty"`
+}
+
+// Apply changes an incident as the update asks and validates the result
+func (u IncidentUpdate) Apply(inc *Incident) error {
+	if u.Status != nil {
+		inc.Status = *u.Status
+	}
+	if u.Severity != nil {
+		inc.Severity = *u.Severity
+	}
+	if u.Classification != nil {
+		inc.Classification = *u.Classification
+	}
+	if u.ClassificationReason != nil {
+		inc.ClassificationReason = *u.ClassificationReason
+	}
+	if u.ClassificationComment != nil {
+		inc.ClassificationComment = *u.ClassificationComment
+	}
+	if u.Owner != nil {
+		inc.Owner = *u.Owner
+	}
+
+	switch inc.Status {
+	case StatusNew, StatusActive:
+		// Sentinel clears the classification of reopened incidents
+		inc.Classification, inc.ClassificationReason, inc.ClassificationComment = "", "", ""
+	case StatusClosed:
+		if inc.Classification == "" {
+			return fmt.Errorf("closing an incident requires a classification")
+		}
+		reasons, ok := classificationReasons[inc.Classification]
+		if !ok {
+			return fmt.Errorf("unknown classification %q", inc.Classification)
+		}
+		if inc.ClassificationReason == "" || len(reasons) == 1 {
+			inc.ClassificationReason = reasons[0]
+		}
+		valid := false
+		for _, r := range reasons {
+			valid = valid || r == inc.ClassificationReason
+		}
+		if !valid {
+			return fmt.Errorf("classification reason %q does not apply to %s incidents",
+				inc.ClassificationReason, inc.Classification)
+		}
+	default:
+		return fmt.Errorf("unknown incident status %q", inc.Status)
+	}
+
+	switch inc.Severity {
+	case SeverityInformational, SeverityLow, SeverityMedium, SeverityHigh:
+	default:
+		return fmt.Errorf("unknown incident severity %q", inc.Severity)
+	}
+	return nil
+}
@@ -0,0 +1,407 @@
+// Package sentineltest provides an in-process Sentinel incidents API for
+// exercising the connector and syncer without network access.
+package sentineltest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/sentinel"
+)
+
+// DefaultPageSize is the number of incidents listed per page
+const DefaultPageSize = 50
+
+// Server is a fake Azure Resource Manager serving the Sentinel incident
+// API of any number of workspaces. Point a sentinel.Client at its URL with
+// any token.
+type Server struct {
+	*httptest.Server
+
+	// PageSize is the number of incidents per list page
+	PageSize int
+	// Fail makes requests whose path contains a key fail with the status
+	// it maps to, such as the name of an incident whose alerts should not load
+	Fail map[string]int
+
+	mu        sync.Mutex
+	etag      int
+	number    int
+	incidents map[string]*record // by workspace resource ID and incident name
+}
+
+type record struct {
+	workspace string
+	incident  sentinel.Incident
+	alerts    []sentinel.Alert
+	entities  []sentinel.Entity
+}
+
+// NewServer starts a fake server; call Close when done
+func NewServer() *Server {
+	s := &Server{
+		PageSize:  DefaultPageSize,
+		Fail:      make(map[string]int),
+		incidents: make(map[string]*record),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Connectors returns a connector source whose clients, for any client
+// tenant, call the fake
+func (s *Server) Connectors() sentinel.ConnectorSource {
+	return connectors{s}
+}
+
+type connectors struct{ s *Server }
+
+func (c connectors) Connector(ctx context.Context, clientID string) (sentinel.Connector, error) {
+	client := sentinel.NewClient(azure.StaticToken("sentineltest"))
+	client.BaseURL = c.s.URL
+	return client, nil
+}
+
+// AddIncident creates an incident in a workspace and returns it as
+// Sentinel holds it. An empty name, number or timestamps are filled in.
+func (s *Server) AddIncident(ws sentinel.Workspace, inc sentinel.Incident, alerts []sentinel.Alert, entities []sentinel.Entity) sentinel.Incident {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.number++
+	if inc.Name == "" {
+		inc.Name = fmt.Sprintf("00000000-0000-0000-0000-%012d", s.number)
+	}
+	if inc.Number == 0 {
+		inc.Number = s.number
+	}
+	if inc.Status == "" {
+		inc.Status = sentinel.StatusNew
+	}
+	if inc.Severity == "" {
+		inc.Severity = sentinel.SeverityMedium
+	}
+	if inc.LastModifiedAt.IsZero() {
+		inc.LastModifiedAt = time.Now().UTC()
+	}
+	if inc.CreatedAt.IsZero() {
+		inc.CreatedAt = inc.LastModifiedAt
+	}
+	inc.ResourceID = ws.ResourceID() + "/providers/Microsoft.SecurityInsights/Incidents/" + inc.Name
+	inc.AlertCount = len(alerts)
+	inc.ETag = s.nextETag()
+	s.incidents[key(ws.ResourceID(), inc.Name)] = &record{
+		workspace: ws.ResourceID(), incident: inc, alerts: alerts, entities: entities,
+	}
+	return inc
+}
+
+// UpdateIncident changes an incident as an analyst working in Sentinel
+// would, giving it a new ETag and modification time
+func (s *Server) UpdateIncident(ws sentinel.Workspace, name string, change func(*sentinel.Incident)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.incidents[key(ws.ResourceID(), name)]
+	if !ok {
+		return
+	}
+	change(&r.incident)
+	r.incident.ETag = s.nextETag()
+	r.incident.LastModifiedAt = time.Now().UTC()
+}
+
+// Incident returns an incident as Sentinel holds it
+func (s *Server) Incident(ws sentinel.Workspace, name string) (sentinel.Incident, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.incidents[key(ws.ResourceID(), name)]
+	if !ok {
+		return sentinel.Incident{}, false
+	}
+	return r.incident, true
+}
+
+func key(workspace, name string) string {
+	return strings.ToLower(workspace) + "|" + strings.ToLower(name)
+}
+
+func (s *Server) nextETag() string {
+	s.etag++
+	return fmt.Sprintf(`"%08x-0000-0000-0000-000000000000"`, s.etag)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeError(w, http.StatusUnauthorized, "AuthenticationFailed", "missing bearer token")
+		return
+	}
+	if r.URL.Query().Get("api-version") == "" {
+		writeError(w, http.StatusBadRequest, "MissingApiVersionParameter", "api-version is required")
+		return
+	}
+	for fragment, status := range s.Fail {
+		if strings.Contains(r.URL.Path, fragment) {
+			writeError(w, status, "InjectedFailure", "failure injected for "+fragment)
+			return
+		}
+	}
+
+	const provider = "/providers/Microsoft.SecurityInsights/incidents"
+	i := strings.Index(strings.ToLower(r.URL.Path), strings.ToLower(provider))
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "no route for "+r.URL.Path)
+		return
+	}
+	workspace := r.URL.Path[:i]
+	rest := strings.Trim(r.URL.Path[i+len(provider):], "/")
+	parts := strings.Split(rest, "/")
+
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		s.listIncidents(w, r, workspace)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.getIncident(w, workspace, parts[0])
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		s.putIncident(w, r, workspace, parts[0])
+	case len(parts) == 2 && parts[1] == "alerts" && r.Method == http.MethodPost:
+		s.listAlerts(w, workspace, parts[0])
+	case len(parts) == 2 && parts[1] == "entities" && r.Method == http.MethodPost:
+		s.listEntities(w, workspace, parts[0])
+	default:
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "no route for "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func (s *Server) listIncidents(w http.ResponseWriter, r *http.Request, workspace string) {
+	q := r.URL.Query()
+	var since time.Time
+	if filter := q.Get("$filter"); filter != "" {
+		const prefix = "properties/lastModifiedTimeUtc ge "
+		if !strings.HasPrefix(filter, prefix) {
+			writeError(w, http.StatusBadRequest, "BadRequest", "unsupported filter "+filter)
+			return
+		}
+		t, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(filter, prefix))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "BadRequest", err.Error())
+			return
+		}
+		since = t
+	}
+	skip, _ := strconv.Atoi(q.Get("$skipToken"))
+
+	s.mu.Lock()
+	var matched []sentinel.Incident
+	for _, rec := range s.incidents {
+		if strings.EqualFold(rec.workspace, workspace) && !rec.incident.LastModifiedAt.Before(since) {
+			matched = append(matched, rec.incident)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].LastModifiedAt.Equal(matched[j].LastModifiedAt) {
+			return matched[i].LastModifiedAt.Before(matched[j].LastModifiedAt)
+		}
+		return matched[i].Number < matched[j].Number
+	})
+
+	page := map[string]interface{}{"value": []interface{}{}}
+	var values []interface{}
+	for i := skip; i < len(matched) && i < skip+s.PageSize; i++ {
+		values = append(values, resource(matched[i]))
+	}
+	if values != nil {
+		page["value"] = values
+	}
+	if skip+s.PageSize < len(matched) {
+		q.Set("$skipToken", strconv.Itoa(skip+s.PageSize))
+		next := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: q.Encode()}
+		page["nextLink"] = next.String()
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) getIncident(w http.ResponseWriter, workspace, name string) {
+	s.mu.Lock()
+	rec, ok := s.incidents[key(workspace, name)]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NotFound", "incident not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, resource(rec.incident))
+}
+
+func (s *Server) putIncident(w http.ResponseWriter, r *http.Request, workspace, name string) {
+	var body struct {
+		ETag       string `json:"etag"`
+		Properties struct {
+			Title                 string          `json:"title"`
+			Description           string          `json:"description"`
+			Severity              string          `json:"severity"`
+			Status                string          `json:"status"`
+			Classification        string          `json:"classification"`
+			ClassificationReason  string          `json:"classificationReason"`
+			ClassificationComment string          `json:"classificationComment"`
+			Owner                 *sentinel.Owner `json:"owner"`
+			Labels                []struct {
+				LabelName string `json:"labelName"`
+			} `json:"labels"`
+		} `json:"properties"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	p := body.Properties
+	if p.Title == "" || p.Severity == "" || p.Status == "" {
+		writeError(w, http.StatusBadRequest, "BadRequest", "title, severity and status are required")
+		return
+	}
+	if p.Status == sentinel.StatusClosed && p.Classification == "" {
+		writeError(w, http.StatusBadRequest, "BadRequest", "closed incidents require a classification")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.incidents[key(workspace, name)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NotFound", "incident not found")
+		return
+	}
+	etag := r.Header.Get("If-Match")
+	if etag == "" {
+		etag = body.ETag
+	}
+	if etag != "" && etag != rec.incident.ETag {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "etag does not match")
+		return
+	}
+
+	inc := &rec.incident
+	inc.Title, inc.Description, inc.Severity, inc.Status = p.Title, p.Description, p.Severity, p.Status
+	inc.Classification, inc.ClassificationReason, inc.ClassificationComment =
+		p.Classification, p.ClassificationReason, p.ClassificationComment
+	inc.Owner = sentinel.Owner{}
+	if p.Owner != nil {
+		inc.Owner = *p.Owner
+	}
+	inc.Labels = nil
+	for _, l := range p.Labels {
+		inc.Labels = append(inc.Labels, l.LabelName)
+	}
+	inc.ETag = s.nextETag()
+	inc.LastModifiedAt = time.Now().UTC()
+	writeJSON(w, http.StatusOK, resource(*inc))
+}
+
+func (s *Server) listAlerts(w http.ResponseWriter, workspace, name string) {
+	s.mu.Lock()
+	rec, ok := s.incidents[key(workspace, name)]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NotFound", "incident not found")
+		return
+	}
+	values := []interface{}{}
+	for _, a := range rec.alerts {
+		values = append(values, map[string]interface{}{
+			"id":   workspace + "/providers/Microsoft.SecurityInsights/Entities/" + a.Name,
+			"name": a.Name,
+			"kind": "SecurityAlert",
+			"properties": map[string]interface{}{
+				"alertDisplayName": a.DisplayName,
+				"severity":         a.Severity,
+				"status":           a.Status,
+				"productName":      a.ProductName,
+				"tactics":          a.Tactics,
+				"timeGenerated":    a.TimeGenerated,
+			},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": values})
+}
+
+func (s *Server) listEntities(w http.ResponseWriter, workspace, name string) {
+	s.mu.Lock()
+	rec, ok := s.incidents[key(workspace, name)]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NotFound", "incident not found")
+		return
+	}
+	entities := []interface{}{}
+	for _, e := range rec.entities {
+		properties := map[string]interface{}{"friendlyName": e.FriendlyName}
+		for k, v := range e.Properties {
+			properties[k] = v
+		}
+		entities = append(entities, map[string]interface{}{
+			"id":         workspace + "/providers/Microsoft.SecurityInsights/Entities/" + e.Name,
+			"name":       e.Name,
+			"kind":       e.Kind,
+			"properties": properties,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"entities": entities})
+}
+
+// resource renders an incident in the Resource Manager wire format
+func resource(inc sentinel.Incident) map[string]interface{} {
+	labels := []interface{}{}
+	for _, l := range inc.Labels {
+		labels = append(labels, map[string]string{"labelName": l, "labelType": "User"})
+	}
+	properties := map[string]interface{}{
+		"title":                 inc.Title,
+		"description":           inc.Description,
+		"severity":              inc.Severity,
+		"status":                inc.Status,
+		"owner":                 inc.Owner,
+		"labels":                labels,
+		"incidentNumber":        inc.Number,
+		"incidentUrl":           "https://portal.azure.com/#asset/Microsoft_Azure_Security_Insights/Incident" + inc.ResourceID,
+		"createdTimeUtc":        inc.CreatedAt,
+		"lastModifiedTimeUtc":   inc.LastModifiedAt,
+		"additionalData":        map[string]interface{}{"alertsCount": inc.AlertCount},
+		"classification":        inc.Classification,
+		"classificationReason":  inc.ClassificationReason,
+		"classificationComment": inc.ClassificationComment,
+	}
+	if inc.FirstActivityAt != nil {
+		properties["firstActivityTimeUtc"] = inc.FirstActivityAt
+	}
+	if inc.LastActivityAt != nil {
+		properties["lastActivityTimeUtc"] = inc.LastActivityAt
+	}
+	return map[string]interface{}{
+		"id":         inc.ResourceID,
+		"name":       inc.Name,
+		"etag":       inc.ETag,
+		"type":       "Microsoft.SecurityInsights/Incidents",
+		"properties": properties,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
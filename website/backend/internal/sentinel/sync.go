@@ -0,0 +1,578 @@
+package sentinel
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/lib/pq"
+)
+
+const (
+	// DefaultSyncInterval is how often workspaces are synced in the background
+	DefaultSyncInterval = 5 * time.Minute
+	// InitialLookback is how far back the first sync of a workspace reaches
+	InitialLookback = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrNotFound is returned for unknown workspaces and incidents
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when an incident changed in Sentinel since it
+	// was last synced; sync the workspace and retry
+	ErrConflict = errors.New("incident was changed in Sentinel since it was last synced")
+	// ErrInvalidUpdate wraps the reason an incident update was refused
+	ErrInvalidUpdate = errors.New("invalid incident update")
+)
+
+// ConnectorSource returns the connector for a client's tenant
+type ConnectorSource interface {
+	Connector(ctx context.Context, clientID string) (Connector, error)
+}
+
+// TokenSource returns a token provider for a client tenant
+type TokenSource interface {
+	TokenProvider(ctx context.Context, clientID string) (azure.TokenProvider, error)
+}
+
+// TenantConnectors connects to Sentinel with each client's app registration
+type TenantConnectors struct {
+	// BaseURL overrides DefaultBaseURL, such as for a fake API
+	BaseURL string
+	tokens  TokenSource
+}
+
+// NewTenantConnectors creates a connector source signing in with tokens
+func NewTenantConnectors(tokens TokenSource) *TenantConnectors {
+	return &TenantConnectors{tokens: tokens}
+}
+
+// Connector implements ConnectorSource
+func (t *TenantConnectors) Connector(ctx context.Context, clientID string) (Connector, error) {
+	tokens, err := t.tokens.TokenProvider(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(tokens)
+	if t.BaseURL != "" {
+		client.BaseURL = t.BaseURL
+	}
+	return client, nil
+}
+
+// RegisteredWorkspace is a workspace registered in
+// deployment_mgmt.sentinel_deployments with its sync state
+type RegisteredWorkspace struct {
+	DeploymentID     string    `json:"deploymentId"`
+	ClientID         string    `json:"clientId,omitempty"`
+	ClientName       string    `json:"clientName,omitempty"`
+	Workspace        Workspace `json:"workspace"`
+	WorkspaceID      string    `json:"workspaceId,omitempty"`
+	Location         string    `json:"location"`
+	DeploymentStatus string    `json:"deploymentStatus"`
+	// SyncedUntil is the last modification time of the incidents synced
+	SyncedUntil *time.Time `json:"syncedUntil,omitempty"`
+	LastSyncAt  *time.Time `json:"lastSyncAt,omitempty"`
+	SyncError   string     `json:"syncError,omitempty"`
+	Incidents   int        `json:"incidents"`
+	Open        int        `json:"openIncidents"`
+}
+
+// SyncResult summarizes the sync of one workspace
+type SyncResult struct {
+	DeploymentID string `json:"deploymentId"`
+	Created      int    `json:"created"`
+	Updated      int    `json:"updated"`
+	Error        string `json:"error,omitempty"`
+}
+
+// IncidentFilter selects synced incidents
+type IncidentFilter struct {
+	DeploymentID string
+	ClientID     string
+	Statuses     []string
+	Severities   []string
+	// Owner matches the assignee's name, email or user principal name
+	Owner  string
+	Limit  int
+	Offset int
+}
+
+// IncidentDetail is an incident with its alerts and entities
+type IncidentDetail struct {
+	*Incident
+	Alerts   []Alert  `json:"alerts"`
+	Entities []Entity `json:"entities"`
+}
+
+// Syncer copies the incidents of registered workspaces into
+// deployment_mgmt.sentinel_incidents and writes triage decisions back
+type Syncer struct {
+	db         *sql.DB
+	connectors ConnectorSource
+	interval   time.Duration
+
+	mu sync.Mutex
+}
+
+// NewSyncer creates a syncer
+func NewSyncer(db *sql.DB, connectors ConnectorSource) *Syncer {
+	return &Syncer{db: db, connectors: connectors, interval: DefaultSyncInterval}
+}
+
+// Run syncs every completed deployment until ctx is cancelled
+func (s *Syncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		results, err := s.SyncAll(ctx)
+		if err != nil {
+			log.Printf("Error syncing Sentinel incidents: %v", err)
+		}
+		for _, r := range results {
+			if r.Error != "" {
+				log.Printf("Error syncing Sentinel deployment %s: %s", r.DeploymentID, r.Error)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Workspaces returns the registered workspaces
+func (s *Syncer) Workspaces(ctx context.Context) ([]RegisteredWorkspace, error) {
+	return s.workspaces(ctx, "")
+}
+
+// Workspace returns one registered workspace
+func (s *Syncer) Workspace(ctx context.Context, deploymentID string) (*RegisteredWorkspace, error) {
+	found, err := s.workspaces(ctx, deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, ErrNotFound
+	}
+	return &found[0], nil
+}
+
+func (s *Syncer) workspaces(ctx context.Context, deploymentID string) ([]RegisteredWorkspace, error) {
+	query := `
+		SELECT d.id, COALESCE(d.client_id::text, ''), COALESCE(c.name, ''), d.subscription_id,
+			d.resource_group, d.workspace_name, COALESCE(d.workspace_id, ''), d.location,
+			d.deployment_status, d.incidents_synced_until, d.incidents_synced_at,
+			COALESCE(d.incident_sync_error, ''),
+			(SELECT COUNT(*) FROM deployment_mgmt.sentinel_incidents i WHERE i.deployment_id = d.id),
+			(SELECT COUNT(*) FROM deployment_mgmt.sentinel_incidents i
+				WHERE i.deployment_id = d.id AND i.status <> 'Closed')
+		FROM deployment_mgmt.sentinel_deployments d
+		LEFT JOIN client_mgmt.clients c ON c.id = d.client_id`
+	var args []interface{}
+	if deploymentID != "" {
+		query += ` WHERE d.id::text = $1`
+		args = append(args, deploymentID)
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY c.name, d.workspace_name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []RegisteredWorkspace{}
+	for rows.Next() {
+		var w RegisteredWorkspace
+		var until, syncedAt sql.NullTime
+		if err := rows.Scan(&w.DeploymentID, &w.ClientID, &w.ClientName, &w.Workspace.SubscriptionID,
+			&w.Workspace.ResourceGroup, &w.Workspace.Name, &w.WorkspaceID, &w.Location,
+			&w.DeploymentStatus, &until, &syncedAt, &w.SyncError, &w.Incidents, &w.Open); err != nil {
+			return nil, err
+		}
+		if until.Valid {
+			w.SyncedUntil = &until.Time
+		}
+		if syncedAt.Valid {
+			w.LastSyncAt = &syncedAt.Time
+		}
+		workspaces = append(workspaces, w)
+	}
+	return workspaces, rows.Err()
+}
+
+// SyncAll syncs the completed deployments of every client. A workspace
+// that fails to sync does not stop the others; its error is in its result.
+func (s *Syncer) SyncAll(ctx context.Context) ([]SyncResult, error) {
+	workspaces, err := s.Workspaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var results []SyncResult
+	for _, w := range workspaces {
+		if w.ClientID == "" || w.DeploymentStatus != "completed" {
+			continue
+		}
+		result, err := s.Sync(ctx, w.DeploymentID)
+		if err != nil && result.Error == "" {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Sync fetches the incidents of a workspace changed since its last sync,
+// with their alerts and entities. The sync resumes after the last incident
+// stored, so a failure part way through loses no work.
+func (s *Syncer) Sync(ctx context.Context, deploymentID string) (SyncResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := SyncResult{DeploymentID: deploymentID}
+	w, err := s.Workspace(ctx, deploymentID)
+	if err != nil {
+		return result, err
+	}
+	if w.ClientID == "" {
+		return result, fmt.Errorf("deployment %s belongs to no client", deploymentID)
+	}
+
+	since := time.Now().Add(-InitialLookback)
+	if w.SyncedUntil != nil {
+		since = *w.SyncedUntil
+	}
+	until := since
+	err = s.sync(ctx, w, since, &until, &result)
+
+	var syncError sql.NullString
+	if err != nil {
+		result.Error = err.Error()
+		syncError = sql.NullString{String: err.Error(), Valid: true}
+	}
+	if _, dbErr := s.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.sentinel_deployments
+		SET incidents_synced_until = $2, incidents_synced_at = NOW(), incident_sync_error = $3
+		WHERE id = $1`, w.DeploymentID, until, syncError); dbErr != nil && err == nil {
+		err = dbErr
+	}
+	return result, err
+}
+
+func (s *Syncer) sync(ctx context.Context, w *RegisteredWorkspace, since time.Time, until *time.Time, result *SyncResult) error {
+	connector, err := s.connectors.Connector(ctx, w.ClientID)
+	if err != nil {
+		return err
+	}
+	incidents, err := connector.ListIncidents(ctx, w.Workspace, since)
+	if err != nil {
+		return fmt.Errorf("failed to list incidents: %v", err)
+	}
+	for i := range incidents {
+		inc := &incidents[i]
+		alerts, err := connector.Alerts(ctx, w.Workspace, inc.Name)
+		if err != nil {
+			return fmt.Errorf("failed to read alerts of incident %d: %v", inc.Number, err)
+		}
+		entities, err := connector.Entities(ctx, w.Workspace, inc.Name)
+		if err != nil {
+			return fmt.Errorf("failed to read entities of incident %d: %v", inc.Number, err)
+		}
+		inc.DeploymentID, inc.ClientID = w.DeploymentID, w.ClientID
+		inserted, err := s.store(ctx, inc, alerts, entities)
+		if err != nil {
+			return fmt.Errorf("failed to store incident %d: %v", inc.Number, err)
+		}
+		if inserted {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+		if inc.LastModifiedAt.After(*until) {
+			*until = inc.LastModifiedAt
+		}
+	}
+	return nil
+}
+
+// store upserts an incident, replacing its alerts and entities unless they
+// are nil
+func (s *Syncer) store(ctx context.Context, inc *Incident, alerts []Alert, entities []Entity) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var inserted bool
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO deployment_mgmt.sentinel_incidents
+			(deployment_id, client_id, incident_name, resource_id, etag, incident_number, title, description,
+			 severity, status, classification, classification_reason, classification_comment,
+			 owner_object_id, owner_email, owner_assigned_to, owner_upn, labels, alert_count,
+			 first_activity_at, last_activity_at, created_time, last_modified_time, incident_url, synced_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19,
+			$20, $21, $22, $23, $24, NOW())
+		ON CONFLICT (deployment_id, incident_name) DO UPDATE SET
+			resource_id = EXCLUDED.resource_id, etag = EXCLUDED.etag,
+			incident_number = EXCLUDED.incident_number, title = EXCLUDED.title,
+			description = EXCLUDED.description, severity = EXCLUDED.severity, status = EXCLUDED.status,
+			classification = EXCLUDED.classification, classification_reason = EXCLUDED.classification_reason,
+			classification_comment = EXCLUDED.classification_comment,
+			owner_object_id = EXCLUDED.owner_object_id, owner_email = EXCLUDED.owner_email,
+			owner_assigned_to = EXCLUDED.owner_assigned_to, owner_upn = EXCLUDED.owner_upn,
+			labels = EXCLUDED.labels, alert_count = EXCLUDED.alert_count,
+			first_activity_at = EXCLUDED.first_activity_at, last_activity_at = EXCLUDED.last_activity_at,
+			created_time = EXCLUDED.created_time, last_modified_time = EXCLUDED.last_modified_time,
+			incident_url = EXCLUDED.incident_url, synced_at = NOW()
+		RETURNING id, (xmax = 0)`,
+		inc.DeploymentID, inc.ClientID, inc.Name, inc.ResourceID, inc.ETag, inc.Number, inc.Title,
+		nullString(inc.Description), inc.Severity, inc.Status, nullString(inc.Classification),
+		nullString(inc.ClassificationReason), nullString(inc.ClassificationComment),
+		nullString(inc.Owner.ObjectID), nullString(inc.Owner.Email), nullString(inc.Owner.AssignedTo),
+		nullString(inc.Owner.UserPrincipalName), pq.Array(inc.Labels), inc.AlertCount,
+		inc.FirstActivityAt, inc.LastActivityAt, inc.CreatedAt, inc.LastModifiedAt,
+		nullString(inc.URL)).Scan(&inc.ID, &inserted)
+	if err != nil {
+		return false, err
+	}
+
+	if alerts != nil {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM deployment_mgmt.sentinel_incident_alerts WHERE incident_id = $1`, inc.ID); err != nil {
+			return false, err
+		}
+		for _, a := range alerts {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO deployment_mgmt.sentinel_incident_alerts
+					(incident_id, alert_name, display_name, severity, status, product_name, tactics, time_generated)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+				inc.ID, a.Name, a.DisplayName, a.Severity, a.Status, nullString(a.ProductName),
+				pq.Array(a.Tactics), a.TimeGenerated); err != nil {
+				return false, err
+			}
+		}
+	}
+	if entities != nil {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM deployment_mgmt.sentinel_incident_entities WHERE incident_id = $1`, inc.ID); err != nil {
+			return false, err
+		}
+		for _, e := range entities {
+			properties, err := json.Marshal(e.Properties)
+			if err != nil {
+				return false, err
+			}
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO deployment_mgmt.sentinel_incident_entities
+					(incident_id, entity_name, kind, friendly_name, properties)
+				VALUES ($1, $2, $3, $4, $5)`,
+				inc.ID, e.Name, e.Kind, nullString(e.FriendlyName), properties); err != nil {
+				return false, err
+			}
+		}
+	}
+	return inserted, tx.Commit()
+}
+
+const incidentColumns = `
+	i.id, i.deployment_id, i.client_id, i.incident_name, i.resource_id, COALESCE(i.etag, ''),
+	i.incident_number, i.title, COALESCE(i.description, ''), i.severity, i.status,
+	COALESCE(i.classification, ''), COALESCE(i.classification_reason, ''),
+	COALESCE(i.classification_comment, ''), COALESCE(i.owner_object_id, ''), COALESCE(i.owner_email, ''),
+	COALESCE(i.owner_assigned_to, ''), COALESCE(i.owner_upn, ''), i.labels, i.alert_count,
+	i.first_activity_at, i.last_activity_at, i.created_time, i.last_modified_time,
+	COALESCE(i.incident_url, ''), i.synced_at`
+
+func scanIncident(row interface{ Scan(...interface{}) error }) (*Incident, error) {
+	var inc Incident
+	var firstActivity, lastActivity sql.NullTime
+	if err := row.Scan(&inc.ID, &inc.DeploymentID, &inc.ClientID, &inc.Name, &inc.ResourceID, &inc.ETag,
+		&inc.Number, &inc.Title, &inc.Description, &inc.Severity, &inc.Status,
+		&inc.Classification, &inc.ClassificationReason, &inc.ClassificationComment,
+		&inc.Owner.ObjectID, &inc.Owner.Email, &inc.Owner.AssignedTo, &inc.Owner.UserPrincipalName,
+		pq.Array(&inc.Labels), &inc.AlertCount, &firstActivity, &lastActivity,
+		&inc.CreatedAt, &inc.LastModifiedAt, &inc.URL, &inc.SyncedAt); err != nil {
+		return nil, err
+	}
+	if firstActivity.Valid {
+		inc.FirstActivityAt = &firstActivity.Time
+	}
+	if lastActivity.Valid {
+		inc.LastActivityAt = &lastActivity.Time
+	}
+	if inc.Labels == nil {
+		inc.Labels = []string{}
+	}
+	return &inc, nil
+}
+
+// Incidents returns synced incidents, most recently modified first, and
+// the number matching the filter
+func (s *Syncer) Incidents(ctx context.Context, filter IncidentFilter) ([]*Incident, int, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if filter.DeploymentID != "" {
+		conditions = append(conditions, "i.deployment_id::text = "+arg(filter.DeploymentID))
+	}
+	if filter.ClientID != "" {
+		conditions = append(conditions, "i.client_id::text = "+arg(filter.ClientID))
+	}
+	if len(filter.Statuses) > 0 {
+		conditions = append(conditions, "i.status = ANY("+arg(pq.Array(filter.Statuses))+")")
+	}
+	if len(filter.Severities) > 0 {
+		conditions = append(conditions, "i.severity = ANY("+arg(pq.Array(filter.Severities))+")")
+	}
+	if filter.Owner != "" {
+		owner := arg(filter.Owner)
+		conditions = append(conditions, fmt.Sprintf("(lower(i.owner_assigned_to) = lower(%[1]s) OR "+
+			"lower(i.owner_email) = lower(%[1]s) OR lower(i.owner_upn) = lower(%[1]s))", owner))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM deployment_mgmt.sentinel_incidents i "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT "+incidentColumns+`
+		FROM deployment_mgmt.sentinel_incidents i `+where+`
+		ORDER BY i.last_modified_time DESC, i.id
+		LIMIT `+arg(limit)+` OFFSET `+arg(filter.Offset), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	incidents := []*Incident{}
+	for rows.Next() {
+		inc, err := scanIncident(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		incidents = append(incidents, inc)
+	}
+	return incidents, total, rows.Err()
+}
+
+// Incident returns a synced incident with its alerts and entities
+func (s *Syncer) Incident(ctx context.Context, id string) (*IncidentDetail, error) {
+	inc, err := scanIncident(s.db.QueryRowContext(ctx, "SELECT "+incidentColumns+`
+		FROM deployment_mgmt.sentinel_incidents i WHERE i.id::text = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	detail := &IncidentDetail{Incident: inc, Alerts: []Alert{}, Entities: []Entity{}}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT alert_name, display_name, severity, status, COALESCE(product_name, ''), tactics, time_generated
+		FROM deployment_mgmt.sentinel_incident_alerts
+		WHERE incident_id = $1
+		ORDER BY time_generated`, inc.ID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var a Alert
+		if err := rows.Scan(&a.Name, &a.DisplayName, &a.Severity, &a.Status, &a.ProductName,
+			pq.Array(&a.Tactics), &a.TimeGenerated); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if a.Tactics == nil {
+			a.Tactics = []string{}
+		}
+		detail.Alerts = append(detail.Alerts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT entity_name, kind, COALESCE(friendly_name, ''), properties
+		FROM deployment_mgmt.sentinel_incident_entities
+		WHERE incident_id = $1
+		ORDER BY kind, friendly_name`, inc.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e Entity
+		var properties []byte
+		if err := rows.Scan(&e.Name, &e.Kind, &e.FriendlyName, &properties); err != nil {
+			return nil, err
+		}
+		if len(properties) > 0 {
+			if err := json.Unmarshal(properties, &e.Properties); err != nil {
+				return nil, err
+			}
+		}
+		detail.Entities = append(detail.Entities, e)
+	}
+	return detail, rows.Err()
+}
+
+// UpdateIncident writes a triage decision back to Sentinel and stores the
+// incident Sentinel returns. Sentinel refuses the write with ErrConflict
+// when the incident changed there since the last sync.
+func (s *Syncer) UpdateIncident(ctx context.Context, id string, update IncidentUpdate) (*Incident, error) {
+	detail, err := s.Incident(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	inc := detail.Incident
+	if err := update.Apply(inc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUpdate, err)
+	}
+	w, err := s.Workspace(ctx, inc.DeploymentID)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := s.connectors.Connector(ctx, inc.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := connector.UpdateIncident(ctx, w.Workspace, inc)
+	if errors.Is(err, ErrConflict) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	updated.DeploymentID, updated.ClientID = inc.DeploymentID, inc.ClientID
+	if _, err := s.store(ctx, updated, nil, nil); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
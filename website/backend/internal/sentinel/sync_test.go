@@ -0,0 +1,206 @@
+package sentinel_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/sentinel"
+	"github.com/ClarityXDR/prod/website/backend/internal/sentinel/sentineltest"
+)
+
+// register records a deployment of the test workspace for a client and
+// returns its ID
+func register(t *testing.T, db *sql.DB, clientID, status string) string {
+	t.Helper()
+	var id string
+	if err := db.QueryRow(`
+		INSERT INTO deployment_mgmt.sentinel_deployments
+			(client_id, workspace_name, subscription_id, resource_group, location, deployment_status, configuration)
+		VALUES ($1, $2, $3, $4, 'westeurope', $5, '{}')
+		RETURNING id`,
+		clientID, workspace.Name, workspace.SubscriptionID, workspace.ResourceGroup, status).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestSyncerSync(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	server := sentineltest.NewServer()
+	defer server.Close()
+	server.PageSize = 1
+	deployment := register(t, db, dbtest.CreateClient(t, db, "Contoso"), "completed")
+	s := sentinel.NewSyncer(db, server.Connectors())
+
+	modified := time.Now().UTC().Add(-time.Hour).Truncate(time.Microsecond)
+	ransomware := server.AddIncident(workspace, sentinel.Incident{Title: "Ransomware", Severity: sentinel.SeverityHigh,
+		LastModifiedAt: modified},
+		[]sentinel.Alert{{Name: "alert-1", DisplayName: "Mass file encryption", Severity: sentinel.SeverityHigh,
+			Status: "New", Tactics: []string{"Impact"}, TimeGenerated: modified}},
+		[]sentinel.Entity{{Name: "entity-1", Kind: "Host", FriendlyName: "WS-0042",
+			Properties: map[string]interface{}{"hostName": "ws-0042"}}})
+	server.AddIncident(workspace, sentinel.Incident{Title: "Phishing", LastModifiedAt: modified.Add(-time.Minute)}, nil, nil)
+	// Incidents older than the initial lookback are not synced
+	server.AddIncident(workspace, sentinel.Incident{Title: "Ancient",
+		LastModifiedAt: time.Now().Add(-sentinel.InitialLookback - time.Hour)}, nil, nil)
+
+	result, err := s.Sync(ctx, deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Created != 2 || result.Updated != 0 || result.Error != "" {
+		t.Fatalf("first sync = %+v, want 2 incidents created", result)
+	}
+	w, err := s.Workspace(ctx, deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Incidents != 2 || w.Open != 2 || w.SyncedUntil == nil || !w.SyncedUntil.Equal(modified) || w.LastSyncAt == nil {
+		t.Errorf("workspace after the first sync = %+v, want synced until the newest incident", w)
+	}
+
+	incidents, total, err := s.Incidents(ctx, sentinel.IncidentFilter{DeploymentID: deployment,
+		Severities: []string{sentinel.SeverityHigh}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || incidents[0].Title != "Ransomware" || incidents[0].ETag != ransomware.ETag {
+		t.Fatalf("high severity incidents = %d, %+v", total, incidents)
+	}
+	detail, err := s.Incident(ctx, incidents[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(detail.Alerts) != 1 || detail.Alerts[0].DisplayName != "Mass file encryption" ||
+		len(detail.Entities) != 1 || detail.Entities[0].Properties["hostName"] != "ws-0042" {
+		t.Errorf("incident detail = %+v", detail)
+	}
+
+	// An analyst working in Sentinel closes the incident; the next sync
+	// picks up only that change
+	server.UpdateIncident(workspace, ransomware.Name, func(inc *sentinel.Incident) {
+		inc.Status = sentinel.StatusClosed
+		inc.Classification = sentinel.ClassificationTruePositive
+		inc.ClassificationReason = "SuspiciousActivity"
+	})
+	result, err = s.Sync(ctx, deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Created != 0 || result.Updated < 1 {
+		t.Errorf("second sync = %+v, want the closed incident updated", result)
+	}
+	if closed, total, err := s.Incidents(ctx, sentinel.IncidentFilter{Statuses: []string{sentinel.StatusClosed}}); err != nil ||
+		total != 1 || closed[0].Classification != sentinel.ClassificationTruePositive {
+		t.Errorf("closed incidents = %d, %+v, %v", total, closed, err)
+	}
+}
+
+func TestSyncerSyncFailure(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	server := sentineltest.NewServer()
+	defer server.Close()
+	deployment := register(t, db, dbtest.CreateClient(t, db, "Contoso"), "completed")
+	s := sentinel.NewSyncer(db, server.Connectors())
+
+	modified := time.Now().UTC().Add(-time.Hour).Truncate(time.Microsecond)
+	first := server.AddIncident(workspace, sentinel.Incident{Title: "First", LastModifiedAt: modified.Add(-time.Hour)}, nil, nil)
+	second := server.AddIncident(workspace, sentinel.Incident{Title: "Second", LastModifiedAt: modified}, nil, nil)
+	server.Fail[second.Name+"/entities"] = http.StatusInternalServerError
+
+	// The incident stored before the failure is kept and the sync resumes
+	// after it
+	result, err := s.Sync(ctx, deployment)
+	if err == nil || result.Created != 1 || result.Error == "" {
+		t.Fatalf("failed sync = %+v, %v; want one incident created and the error", result, err)
+	}
+	w, err := s.Workspace(ctx, deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.SyncError == "" || w.SyncedUntil == nil || !w.SyncedUntil.Equal(first.LastModifiedAt) {
+		t.Errorf("workspace after the failure = %+v, want the error and synced until the first incident", w)
+	}
+
+	delete(server.Fail, second.Name+"/entities")
+	result, err = s.Sync(ctx, deployment)
+	if err != nil || result.Created != 1 {
+		t.Fatalf("retried sync = %+v, %v; want the second incident created", result, err)
+	}
+	if w, err := s.Workspace(ctx, deployment); err != nil || w.SyncError != "" || w.Incidents != 2 {
+		t.Errorf("workspace after the retry = %+v, %v; want the error cleared", w, err)
+	}
+}
+
+func TestSyncerSyncAll(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	server := sentineltest.NewServer()
+	defer server.Close()
+	completed := register(t, db, dbtest.CreateClient(t, db, "Contoso"), "completed")
+	register(t, db, dbtest.CreateClient(t, db, "Fabrikam"), "deploying")
+	server.AddIncident(workspace, sentinel.Incident{Title: "Phishing"}, nil, nil)
+
+	results, err := sentinel.NewSyncer(db, server.Connectors()).SyncAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].DeploymentID != completed || results[0].Created != 1 {
+		t.Errorf("SyncAll() = %+v, want only the completed deployment synced", results)
+	}
+}
+
+func TestSyncerUpdateIncident(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	server := sentineltest.NewServer()
+	defer server.Close()
+	deployment := register(t, db, dbtest.CreateClient(t, db, "Contoso"), "completed")
+	s := sentinel.NewSyncer(db, server.Connectors())
+	inc := server.AddIncident(workspace, sentinel.Incident{Title: "Phishing"}, nil, nil)
+	if _, err := s.Sync(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	synced, _, err := s.Incidents(ctx, sentinel.IncidentFilter{DeploymentID: deployment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := synced[0].ID
+
+	closed, classification := sentinel.StatusClosed, sentinel.ClassificationFalsePositive
+	if _, err := s.UpdateIncident(ctx, id, sentinel.IncidentUpdate{Status: &closed}); !errors.Is(err, sentinel.ErrInvalidUpdate) {
+		t.Fatalf("closing without a classification = %v, want ErrInvalidUpdate", err)
+	}
+	updated, err := s.UpdateIncident(ctx, id, sentinel.IncidentUpdate{Status: &closed, Classification: &classification,
+		Owner: &sentinel.Owner{Email: "analyst@contoso.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ID != id || updated.Status != closed || updated.ClassificationReason != "IncorrectAlertLogic" {
+		t.Errorf("updated incident = %+v", updated)
+	}
+	if held, _ := server.Incident(workspace, inc.Name); held.Status != closed || held.Owner.Email != "analyst@contoso.com" {
+		t.Errorf("Sentinel holds %+v, want the decision written back", held)
+	}
+	if stored, err := s.Incident(ctx, id); err != nil || stored.ETag != updated.ETag || stored.Status != closed {
+		t.Errorf("stored incident = %+v, %v; want Sentinel's new version", stored, err)
+	}
+
+	// A change made in Sentinel since the last sync wins
+	server.UpdateIncident(workspace, inc.Name, func(inc *sentinel.Incident) { inc.Severity = sentinel.SeverityHigh })
+	active := sentinel.StatusActive
+	if _, err := s.UpdateIncident(ctx, id, sentinel.IncidentUpdate{Status: &active}); err != sentinel.ErrConflict {
+		t.Errorf("update of a changed incident = %v, want ErrConflict", err)
+	}
+	if _, err := s.UpdateIncident(ctx, "00000000-0000-0000-0000-000000000000",
+		sentinel.IncidentUpdate{Status: &active}); err != sentinel.ErrNotFound {
+		t.Errorf("update of an unknown incident = %v, want ErrNotFound", err)
+	}
+}
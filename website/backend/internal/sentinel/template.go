@@ -0,0 +1,53 @@
+package sentinel
+
+import (
+	"fmt"
+	"strings"
+)
+
+// templateSchema is the schema of resource group deployment templates
+const templateSchema = "https://schema.management.azure.com/schemas/2019-04-01/deploymentTemplate.json#"
+
+// AnalyticsRulesTemplate returns an ARM deployment template that installs
+// rules in the workspace named by its workspace parameter. Bicep users can
+// decompile it with az bicep decompile or deploy it as a module. Each
+// rule's resource name is a GUID derived from the workspace and rule name,
+// so deploying the template again updates the rules instead of adding
+// copies.
+func AnalyticsRulesTemplate(rules []AnalyticsRule) map[string]interface{} {
+	resources := make([]interface{}, 0, len(rules))
+	for _, rule := range rules {
+		properties := rule.properties()
+		for k, v := range properties {
+			if s, ok := v.(string); ok && strings.HasPrefix(s, "[") {
+				// A value starting with [ is read as an expression unless
+				// the bracket is doubled
+				properties[k] = "[" + s
+			}
+		}
+		resources = append(resources, map[string]interface{}{
+			"type":       "Microsoft.OperationalInsights/workspaces/providers/alertRules",
+			"apiVersion": APIVersion,
+			"name": fmt.Sprintf("[concat(parameters('workspace'), '/Microsoft.SecurityInsights/', guid(parameters('workspace'), %s))]",
+				armString(rule.Name)),
+			"kind":       "Scheduled",
+			"properties": properties,
+		})
+	}
+	return map[string]interface{}{
+		"$schema":        templateSchema,
+		"contentVersion": "1.0.0.0",
+		"parameters": map[string]interface{}{
+			"workspace": map[string]interface{}{
+				"type":     "string",
+				"metadata": map[string]string{"description": "Name of the Log Analytics workspace Sentinel is enabled on"},
+			},
+		},
+		"resources": resources,
+	}
+}
+
+// armString quotes a string literal for a template expression
+func armString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,538 @@
+package sentinel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/kql"
+	"github.com/ClarityXDR/prod/website/backend/internal/validation"
+)
+
+// ErrInvalidConfig is what a ValidationError unwraps to
+var ErrInvalidConfig = errors.New("invalid deployment configuration")
+
+// SupportedRegions are the Azure regions where Log Analytics workspaces can
+// have Sentinel enabled
+var SupportedRegions = []string{
+	"australiacentral", "australiaeast", "australiasoutheast", "brazilsouth", "canadacentral",
+	"canadaeast", "centralindia", "centralus", "eastasia", "eastus", "eastus2", "francecentral",
+	"germanywestcentral", "israelcentral", "italynorth", "japaneast", "japanwest", "koreacentral",
+	"northcentralus", "northeurope", "norwayeast", "polandcentral", "qatarcentral", "southafricanorth",
+	"southcentralus", "southeastasia", "swedencentral", "switzerlandnorth", "uaenorth", "uksouth",
+	"ukwest", "westcentralus", "westeurope", "westus", "westus2", "westus3",
+}
+
+// Scopes of data connectors
+const (
+	scopeTenant       = "tenant"
+	scopeSubscription = "subscription"
+)
+
+type connectorKind struct {
+	scope     string
+	dataTypes []string
+	// features are the license features of which the client needs one
+	features []string
+}
+
+// connectorKinds are the data connector kinds a deployment can create
+var connectorKinds = map[string]connectorKind{
+	"AzureActiveDirectory":                      {scopeTenant, []string{"alerts"}, []string{"entra-id-p2", "m365-e5"}},
+	"AzureAdvancedThreatProtection":             {scopeTenant, []string{"alerts"}, []string{"defender-for-identity", "m365-e5"}},
+	"AzureSecurityCenter":                       {scopeSubscription, []string{"alerts"}, []string{"defender-for-cloud"}},
+	"MicrosoftCloudAppSecurity":                 {scopeTenant, []string{"alerts", "discoveryLogs"}, []string{"defender-for-cloud-apps", "m365-e5"}},
+	"MicrosoftDefenderAdvancedThreatProtection": {scopeTenant, []string{"alerts"}, []string{"defender-for-endpoint", "m365-e5"}},
+	"MicrosoftThreatProtection":                 {scopeTenant, []string{"incidents"}, []string{"microsoft-defender-xdr", "m365-e5"}},
+	"Office365":                                 {scopeTenant, []string{"exchange", "sharePoint", "teams"}, []string{"office-365", "m365-e3", "m365-e5"}},
+	"OfficeATP":                                 {scopeTenant, []string{"alerts"}, []string{"defender-for-office-365", "m365-e5"}},
+	"ThreatIntelligence":                        {scopeTenant, []string{"indicators"}, nil},
+}
+
+// xdrTables are the Defender XDR tables only the MicrosoftThreatProtection
+// connector streams into a workspace
+var xdrTables = map[string]bool{
+	"AlertEvidence": true, "AlertInfo": true, "CloudAppEvents": true, "DeviceEvents": true,
+	"DeviceFileCertificateInfo": true, "DeviceFileEvents": true, "DeviceImageLoadEvents": true,
+	"DeviceInfo": true, "DeviceLogonEvents": true, "DeviceNetworkEvents": true, "DeviceNetworkInfo": true,
+	"DeviceProcessEvents": true, "DeviceRegistryEvents": true, "EmailAttachmentInfo": true,
+	"EmailEvents": true, "EmailPostDeliveryEvents": true, "EmailUrlInfo": true,
+	"IdentityDirectoryEvents": true, "IdentityLogonEvents": true, "IdentityQueryEvents": true,
+	"UrlClickEvents": true,
+}
+
+// Tactics are the MITRE ATT&CK tactics analytics rules may be tagged with
+var Tactics = []string{
+	"Reconnaissance", "ResourceDevelopment", "InitialAccess", "Execution", "Persistence",
+	"PrivilegeEscalation", "DefenseEvasion", "CredentialAccess", "Discovery", "LateralMovement",
+	"Collection", "CommandAndControl", "Exfiltration", "Impact", "ImpairProcessControl",
+	"InhibitResponseFunction",
+}
+
+// EntityTypes are the entity types analytics rules can map columns to
+var EntityTypes = []string{
+	"Account", "AzureResource", "CloudApplication", "DNS", "File", "FileHash", "Host", "IoTDevice",
+	"IP", "Mailbox", "MailCluster", "MailMessage", "Malware", "Process", "RegistryKey",
+	"RegistryValue", "SecurityGroup", "SubmissionMail", "URL",
+}
+
+// Limits Sentinel puts on scheduled analytics rules and workspaces
+const (
+	maxQueryLength      = 10000
+	maxEntityMappings   = 10
+	maxFieldMappings    = 3
+	minQueryInterval    = 5 * time.Minute
+	maxQueryInterval    = 14 * 24 * time.Hour
+	maxSuppression      = 24 * time.Hour
+	maxTriggerThreshold = 10000
+	minRetentionDays    = 30
+	maxRetentionDays    = 730
+)
+
+var (
+	techniquePattern    = regexp.MustCompile(`^T\d{4}$`)
+	subTechniquePattern = regexp.MustCompile(`^T\d{4}\.\d{3}$`)
+	playbookNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.()-]{1,80}$`)
+	durationPattern     = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+)
+
+// Issue is a problem with one part of a configuration. Path is a JSON path
+// into the configuration, such as $.analyticsRules[2].query.
+type Issue struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationResult lists the errors that stop a configuration from
+// deploying and the warnings that do not
+type ValidationResult struct {
+	Valid    bool    `json:"valid"`
+	Errors   []Issue `json:"errors"`
+	Warnings []Issue `json:"warnings"`
+}
+
+func (r *ValidationResult) errorf(path, format string, args ...interface{}) {
+	r.Errors = append(r.Errors, Issue{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *ValidationResult) warnf(path, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, Issue{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns a ValidationError when the result has errors
+func (r *ValidationResult) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	return &ValidationError{Result: r}
+}
+
+// ValidationError is returned for a configuration that cannot be deployed
+type ValidationError struct {
+	Result *ValidationResult
+}
+
+func (e *ValidationError) Error() string {
+	var msgs []string
+	for _, issue := range e.Result.Errors {
+		msgs = append(msgs, issue.Path+": "+issue.Message)
+	}
+	return ErrInvalidConfig.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+// Validator checks deployment configurations before they are deployed
+type Validator struct {
+	// Regions are the locations workspaces may be deployed to
+	Regions []string
+}
+
+// NewValidator creates a validator allowing SupportedRegions
+func NewValidator() *Validator {
+	return &Validator{Regions: SupportedRegions}
+}
+
+// Validate checks a configuration. features are the license features of
+// the client it is deployed for, or nil when they are unknown, in which
+// case data connector prerequisites are only warned about.
+func (v *Validator) Validate(cfg *DeploymentConfig, features []string) *ValidationResult {
+	r := &ValidationResult{Errors: []Issue{}, Warnings: []Issue{}}
+	v.workspace(cfg, r)
+	connected := v.dataConnectors(cfg, features, r)
+	v.analyticsRules(cfg, connected, r)
+	v.workbooks(cfg, r)
+	v.playbooks(cfg, r)
+	r.Valid = len(r.Errors) == 0
+	return r
+}
+
+func (v *Validator) workspace(cfg *DeploymentConfig, r *ValidationResult) {
+	ws := cfg.Workspace
+	if err := validation.ValidateUUID(ws.SubscriptionID); err != nil {
+		r.errorf("$.workspace.subscriptionId", "subscription ID must be a GUID")
+	}
+	if err := validation.ValidateResourceName(ws.ResourceGroup); err != nil {
+		r.errorf("$.workspace.resourceGroup", "%v", err)
+	}
+	if err := validation.ValidateResourceName(ws.Name); err != nil {
+		r.errorf("$.workspace.name", "%v", err)
+	} else if len(ws.Name) < 4 {
+		r.errorf("$.workspace.name", "workspace names must be at least 4 characters")
+	}
+
+	location := strings.ToLower(strings.ReplaceAll(cfg.Location, " ", ""))
+	switch {
+	case cfg.Location == "":
+		r.errorf("$.location", "location is required")
+	case !contains(v.Regions, location):
+		r.errorf("$.location", "%s is not an allowed region; use one of %s", cfg.Location, strings.Join(v.Regions, ", "))
+	case location != cfg.Location:
+		r.warnf("$.location", "write the region as %s", location)
+	}
+
+	if cfg.TenantID != "" && validation.ValidateUUID(cfg.TenantID) != nil {
+		r.errorf("$.tenantId", "tenant ID must be a GUID")
+	}
+	if cfg.SKU != "" && cfg.SKU != DefaultWorkspaceSKU && cfg.SKU != "CapacityReservation" {
+		r.errorf("$.sku", "sku must be %s or CapacityReservation", DefaultWorkspaceSKU)
+	}
+	switch days := cfg.RetentionDays; {
+	case days == 0:
+	case days < minRetentionDays || days > maxRetentionDays:
+		r.errorf("$.retentionDays", "retention must be between %d and %d days", minRetentionDays, maxRetentionDays)
+	case days < DefaultRetentionDays:
+		r.warnf("$.retentionDays", "Sentinel includes %d days of retention; %d days discards data at no saving",
+			DefaultRetentionDays, days)
+	case days > DefaultRetentionDays:
+		r.warnf("$.retentionDays", "retention beyond %d days is billed per GB", DefaultRetentionDays)
+	}
+}
+
+// dataConnectors checks the data connectors and returns the kinds
+// configured
+func (v *Validator) dataConnectors(cfg *DeploymentConfig, features []string, r *ValidationResult) map[string]bool {
+	connected := make(map[string]bool)
+	names := make(map[string]int)
+	tenantRequired := false
+	for i, dc := range cfg.DataConnectors {
+		path := fmt.Sprintf("$.dataConnectors[%d]", i)
+		kind, ok := connectorKinds[dc.Kind]
+		if !ok {
+			r.errorf(path+".kind", "unsupported data connector kind %q", dc.Kind)
+			continue
+		}
+		connected[dc.Kind] = true
+		if first, ok := names[strings.ToLower(dc.name())]; ok {
+			r.errorf(path, "data connector %s is already configured at $.dataConnectors[%d]", dc.name(), first)
+		} else {
+			names[strings.ToLower(dc.name())] = i
+		}
+		if kind.scope == scopeTenant && cfg.TenantID == "" && !tenantRequired {
+			tenantRequired = true
+			r.errorf("$.tenantId", "tenantId is required by the %s data connector", dc.Kind)
+		}
+		for j, dt := range dc.DataTypes {
+			if !contains(kind.dataTypes, dt) {
+				r.errorf(fmt.Sprintf("%s.dataTypes[%d]", path, j), "%s has no data type %q; it has %s",
+					dc.Kind, dt, strings.Join(kind.dataTypes, ", "))
+			}
+		}
+		if len(kind.features) > 0 && features != nil && !containsAny(features, kind.features) {
+			r.errorf(path+".kind", "%s requires one of the license features %s", dc.Kind, strings.Join(kind.features, ", "))
+		}
+	}
+	if features == nil && len(cfg.DataConnectors) > 0 {
+		r.warnf("$.dataConnectors", "the client's license features are unknown, so connector prerequisites were not checked")
+	}
+	return connected
+}
+
+func (v *Validator) analyticsRules(cfg *DeploymentConfig, connected map[string]bool, r *ValidationResult) {
+	names := make(map[string]int)
+	displayNames := make(map[string]int)
+	for i, rule := range cfg.AnalyticsRules {
+		path := fmt.Sprintf("$.analyticsRules[%d]", i)
+		if rule.Name == "" {
+			r.errorf(path+".name", "name is required")
+		} else if first, ok := names[strings.ToLower(rule.Name)]; ok {
+			r.errorf(path+".name", "rule name %q is already used at $.analyticsRules[%d]", rule.Name, first)
+		} else {
+			names[strings.ToLower(rule.Name)] = i
+		}
+		if rule.DisplayName == "" {
+			r.errorf(path+".displayName", "displayName is required")
+		} else if first, ok := displayNames[strings.ToLower(rule.DisplayName)]; ok {
+			r.warnf(path+".displayName", "display name %q is also used at $.analyticsRules[%d]", rule.DisplayName, first)
+		} else {
+			displayNames[strings.ToLower(rule.DisplayName)] = i
+		}
+		if rule.Severity != "" && !contains([]string{SeverityInformational, SeverityLow, SeverityMedium, SeverityHigh}, rule.Severity) {
+			r.errorf(path+".severity", "severity must be Informational, Low, Medium or High")
+		}
+
+		v.query(rule, path, connected, r)
+
+		frequency := checkDuration(r, path+".queryFrequency", rule.QueryFrequency, minQueryInterval, maxQueryInterval)
+		period := checkDuration(r, path+".queryPeriod", rule.QueryPeriod, minQueryInterval, maxQueryInterval)
+		if rule.QueryFrequency == "" {
+			frequency = time.Hour
+		}
+		if rule.QueryPeriod == "" {
+			period = time.Hour
+		}
+		if frequency > 0 && period > 0 && period < frequency {
+			r.warnf(path+".queryPeriod", "the rule looks back %s but runs every %s, so events in between are never queried",
+				period, frequency)
+		}
+		checkDuration(r, path+".suppressionDuration", rule.SuppressionDuration, minQueryInterval, maxSuppression)
+
+		if rule.TriggerOperator != "" && !contains([]string{"GreaterThan", "LessThan", "Equal", "NotEqual"}, rule.TriggerOperator) {
+			r.errorf(path+".triggerOperator", "triggerOperator must be GreaterThan, LessThan, Equal or NotEqual")
+		}
+		if rule.TriggerThreshold < 0 || rule.TriggerThreshold > maxTriggerThreshold {
+			r.errorf(path+".triggerThreshold", "triggerThreshold must be between 0 and %d", maxTriggerThreshold)
+		}
+		for j, tactic := range rule.Tactics {
+			if !contains(Tactics, tactic) {
+				r.errorf(fmt.Sprintf("%s.tactics[%d]", path, j), "unknown tactic %q", tactic)
+			}
+		}
+		for j, technique := range rule.Techniques {
+			p := fmt.Sprintf("%s.techniques[%d]", path, j)
+			switch {
+			case subTechniquePattern.MatchString(technique):
+				r.errorf(p, "%s is a sub-technique; list its technique %s", technique, technique[:5])
+			case !techniquePattern.MatchString(technique):
+				r.errorf(p, "technique %q is not a MITRE ATT&CK technique ID such as T1059", technique)
+			}
+		}
+		v.entityMappings(rule, path, r)
+	}
+}
+
+func (v *Validator) query(rule AnalyticsRule, path string, connected map[string]bool, r *ValidationResult) {
+	if strings.TrimSpace(rule.Query) == "" {
+		r.errorf(path+".query", "query is required")
+		return
+	}
+	if len(rule.Query) > maxQueryLength {
+		r.errorf(path+".query", "query is %d characters; Sentinel allows %d", len(rule.Query), maxQueryLength)
+	}
+	for _, err := range kql.Check(rule.Query) {
+		r.errorf(path+".query", "%v", err)
+	}
+
+	tokens, err := kql.Tokenize(rule.Query)
+	if err != nil {
+		return
+	}
+	usesTimestamp, usesTimeGenerated := false, false
+	warned := make(map[string]bool)
+	for _, t := range tokens {
+		if t.Kind != kql.Ident {
+			continue
+		}
+		switch {
+		case t.Text == "Timestamp":
+			usesTimestamp = true
+		case t.Text == "TimeGenerated":
+			usesTimeGenerated = true
+		case xdrTables[t.Text] && !connected["MicrosoftThreatProtection"] && !warned[t.Text]:
+			warned[t.Text] = true
+			r.warnf(path+".query", "%s is only filled by the MicrosoftThreatProtection data connector, which is not configured", t.Text)
+		}
+	}
+	if usesTimestamp && !usesTimeGenerated {
+		r.warnf(path+".query", "the query filters on Timestamp; Sentinel schedules rules on TimeGenerated")
+	}
+}
+
+func (v *Validator) entityMappings(rule AnalyticsRule, path string, r *ValidationResult) {
+	if len(rule.EntityMappings) > maxEntityMappings {
+		r.errorf(path+".entityMappings", "a rule can map at most %d entities", maxEntityMappings)
+	}
+	columns := make(map[string]bool)
+	if tokens, err := kql.Tokenize(rule.Query); err == nil {
+		for _, t := range tokens {
+			if t.Kind == kql.Ident {
+				columns[t.Text] = true
+			}
+		}
+	}
+	for i, m := range rule.EntityMappings {
+		p := fmt.Sprintf("%s.entityMappings[%d]", path, i)
+		if !contains(EntityTypes, m.EntityType) {
+			r.errorf(p+".entityType", "unknown entity type %q", m.EntityType)
+		}
+		switch n := len(m.FieldMappings); {
+		case n == 0:
+			r.errorf(p+".fieldMappings", "map at least one column")
+		case n > maxFieldMappings:
+			r.errorf(p+".fieldMappings", "an entity can map at most %d columns", maxFieldMappings)
+		}
+		for j, f := range m.FieldMappings {
+			fp := fmt.Sprintf("%s.fieldMappings[%d]", p, j)
+			if f.Identifier == "" || f.ColumnName == "" {
+				r.errorf(fp, "identifier and columnName are required")
+			} else if !columns[f.ColumnName] {
+				r.warnf(fp+".columnName", "the query never mentions column %s", f.ColumnName)
+			}
+		}
+	}
+}
+
+func (v *Validator) workbooks(cfg *DeploymentConfig, r *ValidationResult) {
+	names := make(map[string]int)
+	for i, wb := range cfg.Workbooks {
+		path := fmt.Sprintf("$.workbooks[%d]", i)
+		if wb.DisplayName == "" {
+			r.errorf(path+".displayName", "displayName is required")
+		} else if first, ok := names[strings.ToLower(wb.DisplayName)]; ok {
+			r.errorf(path+".displayName", "workbook %q is already configured at $.workbooks[%d]", wb.DisplayName, first)
+		} else {
+			names[strings.ToLower(wb.DisplayName)] = i
+		}
+		if wb.Name != "" && validation.ValidateUUID(wb.Name) != nil {
+			r.errorf(path+".name", "workbook names must be GUIDs; leave it empty to derive one")
+		}
+		if wb.SerializedData == "" {
+			r.errorf(path+".serializedData", "serializedData is required")
+		} else if !json.Valid([]byte(wb.SerializedData)) {
+			r.errorf(path+".serializedData", "serializedData is not valid JSON")
+		}
+	}
+}
+
+func (v *Validator) playbooks(cfg *DeploymentConfig, r *ValidationResult) {
+	names := make(map[string]int)
+	for i, pb := range cfg.Playbooks {
+		path := fmt.Sprintf("$.playbooks[%d]", i)
+		if !playbookNamePattern.MatchString(pb.Name) {
+			r.errorf(path+".name", "playbook names are 1 to 80 letters, digits, hyphens, underscores, periods or parentheses")
+		} else if first, ok := names[strings.ToLower(pb.Name)]; ok {
+			r.errorf(path+".name", "playbook %q is already configured at $.playbooks[%d]", pb.Name, first)
+		} else {
+			names[strings.ToLower(pb.Name)] = i
+		}
+
+		var definition map[string]json.RawMessage
+		switch {
+		case len(pb.Definition) == 0:
+			r.errorf(path+".definition", "definition is required")
+		case json.Unmarshal(pb.Definition, &definition) != nil:
+			r.errorf(path+".definition", "definition must be a workflow definition object")
+		case definition["triggers"] == nil:
+			r.errorf(path+".definition.triggers", "the workflow has no triggers")
+		case definition["$schema"] == nil:
+			r.warnf(path+".definition", "the workflow definition has no $schema")
+		}
+	}
+}
+
+// checkDuration checks an optional ISO 8601 duration and returns it, or 0
+// when it is empty or invalid
+func checkDuration(r *ValidationResult, path, value string, min, max time.Duration) time.Duration {
+	if value == "" {
+		return 0
+	}
+	d, ok := parseDuration(value)
+	if !ok {
+		r.errorf(path, "%q is not an ISO 8601 duration such as PT1H or P1D", value)
+		return 0
+	}
+	if d < min || d > max {
+		r.errorf(path, "must be between %s and %s", min, max)
+	}
+	return d
+}
+
+// parseDuration parses the day and time parts of an ISO 8601 duration
+func parseDuration(s string) (time.Duration, bool) {
+	m := durationPattern.FindStringSubmatch(s)
+	if m == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, false
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if m[i+1] != "" {
+			n, _ := strconv.Atoi(m[i+1])
+			d += time.Duration(n) * unit
+		}
+	}
+	return d, true
+}
+
+// LicenseFeatures returns the features of a client's active licenses, or
+// nil when the client has none. Licensed clients share their UUID with
+// client_mgmt.clients.
+func (d *Deployer) LicenseFeatures(ctx context.Context, clientID string) ([]string, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT COALESCE(l.features, '[]')
+		FROM license_mgmt.licenses l
+		JOIN license_mgmt.clients c ON c.id = l.client_id
+		WHERE c.client_id::text = $1 AND l.is_active AND l.expiration_date >= CURRENT_DATE`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var features []string
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var licensed []string
+		if err := json.Unmarshal(raw, &licensed); err != nil {
+			return nil, err
+		}
+		if features == nil {
+			features = []string{}
+		}
+		for _, f := range licensed {
+			if !contains(features, f) {
+				features = append(features, f)
+			}
+		}
+	}
+	return features, rows.Err()
+}
+
+// Validate checks a configuration against the license of the client it
+// would be deployed for. clientID may be empty.
+func (d *Deployer) Validate(ctx context.Context, clientID string, cfg *DeploymentConfig) (*ValidationResult, error) {
+	var features []string
+	if clientID != "" {
+		var err error
+		if features, err = d.LicenseFeatures(ctx, clientID); err != nil {
+			return nil, err
+		}
+	}
+	return d.Validator.Validate(cfg, features), nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, w := range wanted {
+		if contains(values, w) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package sentinel
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns a configuration without errors or warnings when
+// validated with the m365-e5 license feature
+func validConfig() *DeploymentConfig {
+	return &DeploymentConfig{
+		Workspace: Workspace{SubscriptionID: "11111111-1111-1111-1111-111111111111",
+			ResourceGroup: "rg-sentinel", Name: "law-contoso"},
+		Location: "westeurope",
+		TenantID: "22222222-2222-2222-2222-222222222222",
+		DataConnectors: []DataConnector{
+			{Kind: "MicrosoftThreatProtection"},
+			{Kind: "Office365", DataTypes: []string{"exchange"}},
+		},
+		AnalyticsRules: []AnalyticsRule{{
+			Name:           "encoded-powershell",
+			DisplayName:    "Encoded PowerShell",
+			Severity:       SeverityHigh,
+			Query:          "DeviceProcessEvents | where TimeGenerated > ago(1h) | where ProcessCommandLine has '-enc'",
+			QueryFrequency: "PT1H",
+			QueryPeriod:    "PT2H",
+			Tactics:        []string{"Execution"},
+			Techniques:     []string{"T1059"},
+			EntityMappings: []EntityMapping{{EntityType: "Process",
+				FieldMappings: []FieldMapping{{Identifier: "CommandLine", ColumnName: "ProcessCommandLine"}}}},
+		}},
+		Workbooks: []Workbook{{DisplayName: "Overview", SerializedData: `{"items": []}`}},
+		Playbooks: []Playbook{{Name: "pb-isolate",
+			Definition: json.RawMessage(`{"$schema": "workflowdefinition.json#", "triggers": {}}`)}},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	features := []string{"m365-e5"}
+	if r := NewValidator().Validate(validConfig(), features); !r.Valid || len(r.Errors) != 0 || len(r.Warnings) != 0 {
+		t.Fatalf("valid configuration = %+v, want no issues", r)
+	}
+
+	type check struct {
+		path  string
+		error bool
+	}
+	errorAt := func(path string) check { return check{path, true} }
+	warningAt := func(path string) check { return check{path, false} }
+	rule := func(cfg *DeploymentConfig) *AnalyticsRule { return &cfg.AnalyticsRules[0] }
+
+	tests := []struct {
+		name     string
+		change   func(*DeploymentConfig)
+		features []string
+		want     check
+	}{
+		// Workspace
+		{"subscription not a GUID", func(c *DeploymentConfig) { c.Workspace.SubscriptionID = "prod" }, features,
+			errorAt("$.workspace.subscriptionId")},
+		{"invalid resource group", func(c *DeploymentConfig) { c.Workspace.ResourceGroup = "rg/sentinel" }, features,
+			errorAt("$.workspace.resourceGroup")},
+		{"short workspace name", func(c *DeploymentConfig) { c.Workspace.Name = "law" }, features,
+			errorAt("$.workspace.name")},
+		{"no location", func(c *DeploymentConfig) { c.Location = "" }, features, errorAt("$.location")},
+		{"unsupported region", func(c *DeploymentConfig) { c.Location = "atlantis" }, features, errorAt("$.location")},
+		{"region display name", func(c *DeploymentConfig) { c.Location = "West Europe" }, features, warningAt("$.location")},
+		{"tenant not a GUID", func(c *DeploymentConfig) { c.TenantID = "contoso.com" }, features, errorAt("$.tenantId")},
+		{"unknown sku", func(c *DeploymentConfig) { c.SKU = "Free" }, features, errorAt("$.sku")},
+		{"retention too short", func(c *DeploymentConfig) { c.RetentionDays = 7 }, features, errorAt("$.retentionDays")},
+		{"retention below the free tier", func(c *DeploymentConfig) { c.RetentionDays = 60 }, features,
+			warningAt("$.retentionDays")},
+		{"billed retention", func(c *DeploymentConfig) { c.RetentionDays = 365 }, features, warningAt("$.retentionDays")},
+
+		// Data connectors
+		{"unknown connector", func(c *DeploymentConfig) { c.DataConnectors[0].Kind = "Syslog" }, features,
+			errorAt("$.dataConnectors[0].kind")},
+		{"duplicate connector", func(c *DeploymentConfig) { c.DataConnectors[1] = c.DataConnectors[0] }, features,
+			errorAt("$.dataConnectors[1]")},
+		{"tenant connector without tenant", func(c *DeploymentConfig) { c.TenantID = "" }, features, errorAt("$.tenantId")},
+		{"unknown data type", func(c *DeploymentConfig) { c.DataConnectors[1].DataTypes = []string{"yammer"} }, features,
+			errorAt("$.dataConnectors[1].dataTypes[0]")},
+		{"unlicensed connector", func(c *DeploymentConfig) {}, []string{"office-365"},
+			errorAt("$.dataConnectors[0].kind")},
+		{"license unknown", func(c *DeploymentConfig) {}, nil, warningAt("$.dataConnectors")},
+
+		// Analytics rules
+		{"rule without name", func(c *DeploymentConfig) { rule(c).Name = "" }, features, errorAt("$.analyticsRules[0].name")},
+		{"duplicate rule name", func(c *DeploymentConfig) {
+			second := *rule(c)
+			second.Name, second.DisplayName = "Encoded-PowerShell", "Other"
+			c.AnalyticsRules = append(c.AnalyticsRules, second)
+		}, features, errorAt("$.analyticsRules[1].name")},
+		{"rule without display name", func(c *DeploymentConfig) { rule(c).DisplayName = "" }, features,
+			errorAt("$.analyticsRules[0].displayName")},
+		{"duplicate display name", func(c *DeploymentConfig) {
+			second := *rule(c)
+			second.Name = "other"
+			c.AnalyticsRules = append(c.AnalyticsRules, second)
+		}, features, warningAt("$.analyticsRules[1].displayName")},
+		{"unknown severity", func(c *DeploymentConfig) { rule(c).Severity = "Critical" }, features,
+			errorAt("$.analyticsRules[0].severity")},
+		{"no query", func(c *DeploymentConfig) { rule(c).Query = " " }, features, errorAt("$.analyticsRules[0].query")},
+		{"query too long", func(c *DeploymentConfig) {
+			rule(c).Query = "DeviceProcessEvents | where TimeGenerated > ago(1h) // " + strings.Repeat("x", maxQueryLength)
+		}, features, errorAt("$.analyticsRules[0].query")},
+		{"query syntax error", func(c *DeploymentConfig) { rule(c).Query = "SigninLogs | where (TimeGenerated > ago(1h)" },
+			features, errorAt("$.analyticsRules[0].query")},
+		{"XDR table without its connector", func(c *DeploymentConfig) { c.DataConnectors = c.DataConnectors[1:] }, features,
+			warningAt("$.analyticsRules[0].query")},
+		{"Timestamp filter", func(c *DeploymentConfig) {
+			rule(c).Query = "DeviceProcessEvents | where Timestamp > ago(1h) | where ProcessCommandLine has '-enc'"
+		}, features, warningAt("$.analyticsRules[0].query")},
+		{"duration not ISO 8601", func(c *DeploymentConfig) { rule(c).QueryFrequency = "1h" }, features,
+			errorAt("$.analyticsRules[0].queryFrequency")},
+		{"frequency too short", func(c *DeploymentConfig) { rule(c).QueryFrequency = "PT1M" }, features,
+			errorAt("$.analyticsRules[0].queryFrequency")},
+		{"period too long", func(c *DeploymentConfig) { rule(c).QueryPeriod = "P15D" }, features,
+			errorAt("$.analyticsRules[0].queryPeriod")},
+		{"period shorter than frequency", func(c *DeploymentConfig) { rule(c).QueryPeriod = "PT30M" }, features,
+			warningAt("$.analyticsRules[0].queryPeriod")},
+		{"suppression too long", func(c *DeploymentConfig) { rule(c).SuppressionDuration = "P2D" }, features,
+			errorAt("$.analyticsRules[0].suppressionDuration")},
+		{"unknown trigger operator", func(c *DeploymentConfig) { rule(c).TriggerOperator = "Above" }, features,
+			errorAt("$.analyticsRules[0].triggerOperator")},
+		{"negative threshold", func(c *DeploymentConfig) { rule(c).TriggerThreshold = -1 }, features,
+			errorAt("$.analyticsRules[0].triggerThreshold")},
+		{"unknown tactic", func(c *DeploymentConfig) { rule(c).Tactics = []string{"Execution", "Hacking"} }, features,
+			errorAt("$.analyticsRules[0].tactics[1]")},
+		{"sub-technique", func(c *DeploymentConfig) { rule(c).Techniques = []string{"T1059.001"} }, features,
+			errorAt("$.analyticsRules[0].techniques[0]")},
+		{"not a technique", func(c *DeploymentConfig) { rule(c).Techniques = []string{"PowerShell"} }, features,
+			errorAt("$.analyticsRules[0].techniques[0]")},
+		{"too many entity mappings", func(c *DeploymentConfig) {
+			for len(rule(c).EntityMappings) <= maxEntityMappings {
+				rule(c).EntityMappings = append(rule(c).EntityMappings, rule(c).EntityMappings[0])
+			}
+		}, features, errorAt("$.analyticsRules[0].entityMappings")},
+		{"unknown entity type", func(c *DeploymentConfig) { rule(c).EntityMappings[0].EntityType = "Device" }, features,
+			errorAt("$.analyticsRules[0].entityMappings[0].entityType")},
+		{"entity without columns", func(c *DeploymentConfig) { rule(c).EntityMappings[0].FieldMappings = nil }, features,
+			errorAt("$.analyticsRules[0].entityMappings[0].fieldMappings")},
+		{"entity with too many columns", func(c *DeploymentConfig) {
+			m := &rule(c).EntityMappings[0]
+			m.FieldMappings = append(m.FieldMappings, m.FieldMappings[0], m.FieldMappings[0], m.FieldMappings[0])
+		}, features, errorAt("$.analyticsRules[0].entityMappings[0].fieldMappings")},
+		{"field mapping without identifier", func(c *DeploymentConfig) {
+			rule(c).EntityMappings[0].FieldMappings[0].Identifier = ""
+		}, features, errorAt("$.analyticsRules[0].entityMappings[0].fieldMappings[0]")},
+		{"column not in the query", func(c *DeploymentConfig) {
+			rule(c).EntityMappings[0].FieldMappings[0].ColumnName = "InitiatingProcessCommandLine"
+		}, features, warningAt("$.analyticsRules[0].entityMappings[0].fieldMappings[0].columnName")},
+
+		// Workbooks
+		{"workbook without display name", func(c *DeploymentConfig) { c.Workbooks[0].DisplayName = "" }, features,
+			errorAt("$.workbooks[0].displayName")},
+		{"duplicate workbook", func(c *DeploymentConfig) { c.Workbooks = append(c.Workbooks, c.Workbooks[0]) }, features,
+			errorAt("$.workbooks[1].displayName")},
+		{"workbook name not a GUID", func(c *DeploymentConfig) { c.Workbooks[0].Name = "overview" }, features,
+			errorAt("$.workbooks[0].name")},
+		{"workbook without data", func(c *DeploymentConfig) { c.Workbooks[0].SerializedData = "" }, features,
+			errorAt("$.workbooks[0].serializedData")},
+		{"workbook data not JSON", func(c *DeploymentConfig) { c.Workbooks[0].SerializedData = "{items" }, features,
+			errorAt("$.workbooks[0].serializedData")},
+
+		// Playbooks
+		{"invalid playbook name", func(c *DeploymentConfig) { c.Playbooks[0].Name = "isolate host" }, features,
+			errorAt("$.playbooks[0].name")},
+		{"duplicate playbook", func(c *DeploymentConfig) { c.Playbooks = append(c.Playbooks, c.Playbooks[0]) }, features,
+			errorAt("$.playbooks[1].name")},
+		{"no definition", func(c *DeploymentConfig) { c.Playbooks[0].Definition = nil }, features,
+			errorAt("$.playbooks[0].definition")},
+		{"definition not an object", func(c *DeploymentConfig) { c.Playbooks[0].Definition = json.RawMessage(`[]`) },
+			features, errorAt("$.playbooks[0].definition")},
+		{"workflow without triggers", func(c *DeploymentConfig) { c.Playbooks[0].Definition = json.RawMessage(`{}`) },
+			features, errorAt("$.playbooks[0].definition.triggers")},
+		{"workflow without schema", func(c *DeploymentConfig) {
+			c.Playbooks[0].Definition = json.RawMessage(`{"triggers": {}}`)
+		}, features, warningAt("$.playbooks[0].definition")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.change(cfg)
+			r := NewValidator().Validate(cfg, tt.features)
+			issues := r.Warnings
+			if tt.want.error {
+				issues = r.Errors
+			}
+			found := false
+			for _, issue := range issues {
+				found = found || issue.Path == tt.want.path
+			}
+			if !found {
+				t.Errorf("issues = %+v, want one at %s (error %v)", r, tt.want.path, tt.want.error)
+			}
+			if r.Valid != (len(r.Errors) == 0) || (r.Err() == nil) != r.Valid {
+				t.Errorf("Valid = %v with %d errors", r.Valid, len(r.Errors))
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"PT5M":      5 * time.Minute,
+		"PT1H":      time.Hour,
+		"P1D":       24 * time.Hour,
+		"P1DT12H":   36 * time.Hour,
+		"PT1H30M5S": time.Hour + 30*time.Minute + 5*time.Second,
+	}
+	for s, want := range tests {
+		if got, ok := parseDuration(s); !ok || got != want {
+			t.Errorf("parseDuration(%s) = %s, %v; want %s", s, got, ok, want)
+		}
+	}
+	for _, s := range []string{"", "P", "PT", "P1DT", "1h", "PT1.5H", "P1W"} {
+		if _, ok := parseDuration(s); ok {
+			t.Errorf("parseDuration(%q) accepted", s)
+		}
+	}
+}
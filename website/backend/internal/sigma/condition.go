@@ -0,0 +1,150 @@
+package sigma
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// expr is a parsed detection condition. Leaves name a search identifier.
+type expr struct {
+	op     string // and, or, not, search
+	args   []*expr
+	search *Search
+}
+
+// parseCondition parses a rule's conditions into one expression. Several
+// conditions match when any of them does.
+func parseCondition(d *Detection) (*expr, error) {
+	var alternatives []*expr
+	for _, cond := range d.Conditions {
+		if strings.Contains(cond, "|") {
+			return nil, fmt.Errorf("%w: aggregation conditions such as %q", ErrUnsupported, cond)
+		}
+		p := &conditionParser{tokens: conditionTokens(cond), detection: d}
+		e, err := p.or()
+		if err != nil {
+			return nil, fmt.Errorf("condition %q: %w", cond, err)
+		}
+		if p.pos < len(p.tokens) {
+			return nil, fmt.Errorf("%w: condition %q: unexpected %q", ErrInvalidRule, cond, p.tokens[p.pos])
+		}
+		alternatives = append(alternatives, e)
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return &expr{op: "or", args: alternatives}, nil
+}
+
+func conditionTokens(cond string) []string {
+	cond = strings.NewReplacer("(", " ( ", ")", " ) ").Replace(cond)
+	return strings.Fields(cond)
+}
+
+type conditionParser struct {
+	tokens    []string
+	pos       int
+	detection *Detection
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return strings.ToLower(p.tokens[p.pos])
+	}
+	return ""
+}
+
+func (p *conditionParser) or() (*expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr{op: "or", args: []*expr{left, right}}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) and() (*expr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" {
+		p.pos++
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr{op: "and", args: []*expr{left, right}}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) not() (*expr, error) {
+	if p.peek() == "not" {
+		p.pos++
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &expr{op: "not", args: []*expr{e}}, nil
+	}
+	return p.primary()
+}
+
+func (p *conditionParser) primary() (*expr, error) {
+	tok := p.peek()
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("%w: condition ends too early", ErrInvalidRule)
+	case tok == "(":
+		p.pos++
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("%w: ( is never closed", ErrInvalidRule)
+		}
+		p.pos++
+		return e, nil
+	case tok == "1" || tok == "all" || tok == "any":
+		if p.pos+2 >= len(p.tokens) || strings.ToLower(p.tokens[p.pos+1]) != "of" {
+			return nil, fmt.Errorf("%w: %s must be followed by of", ErrInvalidRule, tok)
+		}
+		pattern := p.tokens[p.pos+2]
+		p.pos += 3
+		var matched []*expr
+		for _, s := range p.detection.Searches {
+			if ok, _ := path.Match(pattern, s.Name); ok || pattern == "them" {
+				matched = append(matched, &expr{op: "search", search: s})
+			}
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("%w: no search identifier matches %s", ErrInvalidRule, pattern)
+		}
+		if len(matched) == 1 {
+			return matched[0], nil
+		}
+		op := "or"
+		if tok == "all" {
+			op = "and"
+		}
+		return &expr{op: op, args: matched}, nil
+	default:
+		name := p.tokens[p.pos]
+		p.pos++
+		s := p.detection.search(name)
+		if s == nil {
+			return nil, fmt.Errorf("%w: unknown search identifier %s", ErrInvalidRule, name)
+		}
+		return &expr{op: "search", search: s}, nil
+	}
+}
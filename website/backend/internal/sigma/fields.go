@@ -0,0 +1,200 @@
+package sigma
+
+import "strings"
+
+// Target is the platform a rule is converted for
+type Target string
+
+// Targets. Defender queries Advanced Hunting tables; Sentinel queries the
+// built-in ASIM parsers, which normalize every connected source.
+const (
+	Defender Target = "defender"
+	Sentinel Target = "sentinel"
+)
+
+// fieldMap maps a Sigma field to a column. name marks a column holding
+// only the file name of the Sigma path field, which is used when exporting
+// to Sigma but not when converting from it.
+type fieldMap struct {
+	sigma  string
+	column string
+	name   bool
+}
+
+// logSource is how a Sigma log source is queried on each target.
+// Ignored fields are recorded by the source but cannot be queried; their
+// matches are left out of the query with a warning.
+type logSource struct {
+	category string
+	tables   map[Target]string
+	fields   map[Target][]fieldMap
+	ignored  []string
+}
+
+var logSources = []*logSource{
+	{
+		category: "process_creation",
+		tables:   map[Target]string{Defender: "DeviceProcessEvents", Sentinel: "_Im_ProcessCreate"},
+		fields: map[Target][]fieldMap{
+			Defender: {
+				{sigma: "Image", column: "FolderPath"},
+				{sigma: "Image", column: "FileName", name: true},
+				{sigma: "OriginalFileName", column: "ProcessVersionInfoOriginalFileName"},
+				{sigma: "CommandLine", column: "ProcessCommandLine"},
+				{sigma: "ProcessId", column: "ProcessId"},
+				{sigma: "IntegrityLevel", column: "ProcessIntegrityLevel"},
+				{sigma: "Company", column: "ProcessVersionInfoCompanyName"},
+				{sigma: "Product", column: "ProcessVersionInfoProductName"},
+				{sigma: "Description", column: "ProcessVersionInfoFileDescription"},
+				{sigma: "User", column: "AccountName"},
+				{sigma: "LogonId", column: "LogonId"},
+				{sigma: "ParentImage", column: "InitiatingProcessFolderPath"},
+				{sigma: "ParentImage", column: "InitiatingProcessFileName", name: true},
+				{sigma: "ParentCommandLine", column: "InitiatingProcessCommandLine"},
+				{sigma: "ParentProcessId", column: "InitiatingProcessId"},
+				{sigma: "ParentUser", column: "InitiatingProcessAccountName"},
+				{sigma: "Computer", column: "DeviceName"},
+				{sigma: "sha256", column: "SHA256"},
+				{sigma: "sha1", column: "SHA1"},
+				{sigma: "md5", column: "MD5"},
+			},
+			Sentinel: {
+				{sigma: "Image", column: "TargetProcessName"},
+				{sigma: "CommandLine", column: "TargetProcessCommandLine"},
+				{sigma: "ProcessId", column: "TargetProcessId"},
+				{sigma: "IntegrityLevel", column: "TargetProcessIntegrityLevel"},
+				{sigma: "CurrentDirectory", column: "TargetProcessCurrentDirectory"},
+				{sigma: "Company", column: "TargetProcessFileCompany"},
+				{sigma: "Product", column: "TargetProcessFileProduct"},
+				{sigma: "Description", column: "TargetProcessFileDescription"},
+				{sigma: "User", column: "TargetUsername"},
+				{sigma: "ParentImage", column: "ActingProcessName"},
+				{sigma: "ParentCommandLine", column: "ActingProcessCommandLine"},
+				{sigma: "ParentProcessId", column: "ActingProcessId"},
+				{sigma: "ParentUser", column: "ActorUsername"},
+				{sigma: "Computer", column: "DvcHostname"},
+				{sigma: "sha256", column: "TargetProcessSHA256"},
+				{sigma: "sha1", column: "TargetProcessSHA1"},
+				{sigma: "md5", column: "TargetProcessMD5"},
+				{sigma: "Imphash", column: "TargetProcessIMPHASH"},
+			},
+		},
+	},
+	{
+		category: "network_connection",
+		tables:   map[Target]string{Defender: "DeviceNetworkEvents", Sentinel: "_Im_NetworkSession"},
+		fields: map[Target][]fieldMap{
+			Defender: {
+				{sigma: "Image", column: "InitiatingProcessFolderPath"},
+				{sigma: "Image", column: "InitiatingProcessFileName", name: true},
+				{sigma: "CommandLine", column: "InitiatingProcessCommandLine"},
+				{sigma: "User", column: "InitiatingProcessAccountName"},
+				{sigma: "Protocol", column: "Protocol"},
+				{sigma: "SourceIp", column: "LocalIP"},
+				{sigma: "SourcePort", column: "LocalPort"},
+				{sigma: "DestinationIp", column: "RemoteIP"},
+				{sigma: "DestinationPort", column: "RemotePort"},
+				{sigma: "DestinationHostname", column: "RemoteUrl"},
+				{sigma: "Computer", column: "DeviceName"},
+			},
+			Sentinel: {
+				{sigma: "Image", column: "SrcProcessName"},
+				{sigma: "User", column: "SrcUsername"},
+				{sigma: "Protocol", column: "NetworkProtocol"},
+				{sigma: "SourceIp", column: "SrcIpAddr"},
+				{sigma: "SourcePort", column: "SrcPortNumber"},
+				{sigma: "SourceHostname", column: "SrcHostname"},
+				{sigma: "DestinationIp", column: "DstIpAddr"},
+				{sigma: "DestinationPort", column: "DstPortNumber"},
+				{sigma: "DestinationHostname", column: "DstHostname"},
+				{sigma: "Computer", column: "DvcHostname"},
+			},
+		},
+		ignored: []string{"Initiated", "DestinationIsIpv6", "SourceIsIpv6"},
+	},
+	{
+		category: "file_event",
+		tables:   map[Target]string{Defender: "DeviceFileEvents", Sentinel: "_Im_FileEvent"},
+		fields: map[Target][]fieldMap{
+			Defender: {
+				{sigma: "TargetFilename", column: "FolderPath"},
+				{sigma: "TargetFilename", column: "FileName", name: true},
+				{sigma: "Image", column: "InitiatingProcessFolderPath"},
+				{sigma: "Image", column: "InitiatingProcessFileName", name: true},
+				{sigma: "User", column: "InitiatingProcessAccountName"},
+				{sigma: "Computer", column: "DeviceName"},
+				{sigma: "sha256", column: "SHA256"},
+				{sigma: "sha1", column: "SHA1"},
+				{sigma: "md5", column: "MD5"},
+			},
+			Sentinel: {
+				{sigma: "TargetFilename", column: "TargetFilePath"},
+				{sigma: "Image", column: "ActingProcessName"},
+				{sigma: "User", column: "ActorUsername"},
+				{sigma: "Computer", column: "DvcHostname"},
+				{sigma: "sha256", column: "TargetFileSHA256"},
+				{sigma: "sha1", column: "TargetFileSHA1"},
+				{sigma: "md5", column: "TargetFileMD5"},
+			},
+		},
+		ignored: []string{"CreationUtcTime"},
+	},
+}
+
+// hashColumns are the fields of a Hashes value such as SHA256=...
+var hashColumns = map[string]string{"SHA256": "sha256", "SHA1": "sha1", "MD5": "md5", "IMPHASH": "Imphash"}
+
+// products are the Sigma products whose events Defender and the ASIM
+// parsers normalize to the same columns
+var products = map[string]bool{"": true, "windows": true, "linux": true, "macos": true}
+
+func findLogSource(category string) *logSource {
+	for _, ls := range logSources {
+		if ls.category == category {
+			return ls
+		}
+	}
+	return nil
+}
+
+// column returns the column a Sigma field is queried as
+func (ls *logSource) column(target Target, field string) (string, bool) {
+	for _, f := range ls.fields[target] {
+		if !f.name && strings.EqualFold(f.sigma, field) {
+			return f.column, true
+		}
+	}
+	return "", false
+}
+
+func (ls *logSource) isIgnored(field string) bool {
+	for _, f := range ls.ignored {
+		if strings.EqualFold(f, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// logSourceOf returns the log source and target a table is queried for
+func logSourceOf(table string) (*logSource, Target) {
+	for _, ls := range logSources {
+		for target, t := range ls.tables {
+			if t == table {
+				return ls, target
+			}
+		}
+	}
+	return nil, ""
+}
+
+// sigmaField returns the Sigma field of a column, and whether the column
+// holds only the file name of the field
+func (ls *logSource) sigmaField(target Target, column string) (fieldMap, bool) {
+	for _, f := range ls.fields[target] {
+		if f.column == column {
+			return f, true
+		}
+	}
+	return fieldMap{}, false
+}
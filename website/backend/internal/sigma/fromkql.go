@@ -0,0 +1,646 @@
+package sigma
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/kql"
+	"gopkg.in/yaml.v3"
+)
+
+// ExportMeta is the metadata of an exported rule, which the query does
+// not carry
+type ExportMeta struct {
+	ID          string
+	Title       string
+	Description string
+	Author      string
+	Date        string
+	Level       string
+	Tags        []string
+}
+
+// Export is a query exported as a Sigma rule. Warnings describe where the
+// rule matches differently than the query.
+type Export struct {
+	Sigma    string   `json:"sigma"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (e *Export) warnf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	for _, w := range e.Warnings {
+		if w == msg {
+			return
+		}
+	}
+	e.Warnings = append(e.Warnings, msg)
+}
+
+// FromKQL exports a query as a Sigma rule. Only queries that filter one of
+// the covered tables with where are exported, such as
+//
+//	DeviceProcessEvents
+//	| where FileName =~ "powershell.exe" and ProcessCommandLine has "-enc"
+//
+// project and take are left out; any other operator is unsupported.
+func FromKQL(query string, meta ExportMeta) (*Export, error) {
+	tokens, err := kql.Tokenize(query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	statements := 0
+	for _, st := range splitTokens(tokens, ";") {
+		if len(st) > 0 {
+			statements++
+		}
+	}
+	if statements != 1 {
+		return nil, fmt.Errorf("%w: only single statement queries export to Sigma", ErrUnsupported)
+	}
+	segments := splitTokens(tokens, "|")
+	if len(segments[0]) != 1 || segments[0][0].Kind != kql.Ident {
+		return nil, fmt.Errorf("%w: the query must start with a table", ErrUnsupported)
+	}
+	table := segments[0][0].Text
+	ls, target := logSourceOf(table)
+	if ls == nil {
+		return nil, fmt.Errorf("%w: table %s has no Sigma log source", ErrUnsupported, table)
+	}
+
+	exp := &Export{}
+	x := &exporter{export: exp, source: ls, target: target}
+	var filters []*filter
+	var fields []string
+	for _, seg := range segments[1:] {
+		if len(seg) == 0 {
+			continue
+		}
+		switch seg[0].Text {
+		case "where", "filter":
+			p := &filterParser{tokens: seg[1:], exporter: x}
+			f, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if p.pos < len(p.tokens) {
+				return nil, fmt.Errorf("%w: cannot export %q", ErrUnsupported, p.tokens[p.pos].Text)
+			}
+			filters = append(filters, f)
+		case "project":
+			for _, item := range splitTokens(seg[1:], ",") {
+				if len(item) == 1 && item[0].Kind == kql.Ident {
+					if f, ok := ls.sigmaField(target, item[0].Text); ok && !f.name {
+						fields = append(fields, f.sigma)
+					}
+				}
+			}
+		case "take", "limit", "order", "sort":
+			exp.warnf("%s has no Sigma equivalent and is left out", seg[0].Text)
+		default:
+			return nil, fmt.Errorf("%w: operator %s", ErrUnsupported, seg[0].Text)
+		}
+	}
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("%w: the query has no where filter", ErrUnsupported)
+	}
+
+	root := filters[0]
+	if len(filters) > 1 {
+		root = &filter{op: "and", args: filters}
+	}
+	condition := x.condition(simplify(root), 0)
+	detection := x.detection(condition)
+
+	doc := sigmaDocument{
+		Title:       meta.Title,
+		ID:          meta.ID,
+		Status:      "experimental",
+		Description: meta.Description,
+		Author:      meta.Author,
+		Date:        meta.Date,
+		Tags:        meta.Tags,
+		LogSource:   LogSource{Category: ls.category, Product: "windows"},
+		Detection:   detection,
+		Level:       meta.Level,
+		Fields:      fields,
+	}
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, err
+	}
+	exp.Sigma = string(out)
+	return exp, nil
+}
+
+// sigmaDocument is the layout of an exported rule, in the order Sigma
+// rules list their keys
+type sigmaDocument struct {
+	Title       string     `yaml:"title"`
+	ID          string     `yaml:"id,omitempty"`
+	Status      string     `yaml:"status"`
+	Description string     `yaml:"description,omitempty"`
+	Author      string     `yaml:"author,omitempty"`
+	Date        string     `yaml:"date,omitempty"`
+	Tags        []string   `yaml:"tags,omitempty"`
+	LogSource   LogSource  `yaml:"logsource"`
+	Detection   *yaml.Node `yaml:"detection"`
+	Level       string     `yaml:"level,omitempty"`
+	Fields      []string   `yaml:"fields,omitempty"`
+}
+
+// filter is a parsed where expression. Leaves compare one Sigma field.
+type filter struct {
+	op    string // and, or, match
+	args  []*filter
+	match *FieldMatch
+	// negated leaves become filters excluded with not
+	negated bool
+}
+
+type exporter struct {
+	export *Export
+	source *logSource
+	target Target
+	// selections are the search identifiers made so far
+	selections []*selection
+}
+
+type selection struct {
+	negated bool
+	matches []*FieldMatch
+	name    string
+}
+
+// condition renders a filter as a Sigma condition, collecting its
+// selections. Placeholders are replaced once every selection is named.
+func (x *exporter) condition(f *filter, parent int) string {
+	switch f.op {
+	case "match":
+		return x.add(f.negated, f.match)
+	}
+
+	var parts []string
+	args := f.args
+	if f.op == "and" {
+		// Merge the field matches of an and into one selection
+		var merged []*FieldMatch
+		var rest []*filter
+		for _, a := range args {
+			if a.op == "match" && !a.negated && !hasField(merged, a.match.Field) {
+				merged = append(merged, a.match)
+			} else {
+				rest = append(rest, a)
+			}
+		}
+		if len(merged) > 0 {
+			parts = append(parts, x.add(false, merged...))
+		}
+		args = rest
+	}
+	for _, a := range args {
+		parts = append(parts, x.condition(a, precedence[f.op]))
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	s := strings.Join(parts, " "+f.op+" ")
+	if parent > precedence[f.op] {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// simplify flattens nested ands and ors and merges alternatives on the
+// same field into one list, so the and they are part of can keep them in
+// one selection
+func simplify(f *filter) *filter {
+	if f.op == "match" {
+		return f
+	}
+	var args []*filter
+	for _, a := range f.args {
+		a = simplify(a)
+		if a.op == f.op {
+			args = append(args, a.args...)
+			continue
+		}
+		if f.op == "or" && a.op == "match" && !a.negated && len(args) > 0 {
+			last := args[len(args)-1]
+			if last.op == "match" && !last.negated && sameMatch(last.match, a.match) {
+				last.match.Values = append(last.match.Values, a.match.Values...)
+				continue
+			}
+		}
+		args = append(args, a)
+	}
+	if len(args) == 1 {
+		return args[0]
+	}
+	return &filter{op: f.op, args: args}
+}
+
+// add adds a selection and returns its placeholder
+func (x *exporter) add(negated bool, matches ...*FieldMatch) string {
+	x.selections = append(x.selections, &selection{negated: negated, matches: matches})
+	placeholder := fmt.Sprintf("\x00%d\x00", len(x.selections)-1)
+	if negated {
+		return "not " + placeholder
+	}
+	return placeholder
+}
+
+// detection names the selections and returns the detection node
+func (x *exporter) detection(condition string) *yaml.Node {
+	counts := map[bool]int{}
+	for _, s := range x.selections {
+		counts[s.negated]++
+	}
+	seen := map[bool]int{}
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for i, s := range x.selections {
+		base := "selection"
+		if s.negated {
+			base = "filter"
+		}
+		seen[s.negated]++
+		s.name = base
+		if counts[s.negated] > 1 {
+			s.name = fmt.Sprintf("%s_%d", base, seen[s.negated])
+		}
+		condition = strings.Replace(condition, fmt.Sprintf("\x00%d\x00", i), s.name, 1)
+
+		m := &yaml.Node{Kind: yaml.MappingNode}
+		for _, fm := range s.matches {
+			key := strings.Join(append([]string{fm.Field}, fm.Modifiers...), "|")
+			m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, valuesNode(fm.Values))
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: s.name}, m)
+	}
+	node.Content = append(node.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: "condition"},
+		&yaml.Node{Kind: yaml.ScalarNode, Value: condition})
+	return node
+}
+
+func valuesNode(values []interface{}) *yaml.Node {
+	scalar := func(v interface{}) *yaml.Node {
+		if v == nil {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+		}
+		if s, ok := v.(string); ok {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+		}
+		var n yaml.Node
+		n.Encode(v)
+		return &n
+	}
+	if len(values) == 1 {
+		return scalar(values[0])
+	}
+	seq := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, v := range values {
+		seq.Content = append(seq.Content, scalar(v))
+	}
+	return seq
+}
+
+func hasField(matches []*FieldMatch, field string) bool {
+	for _, m := range matches {
+		if m.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func sameMatch(a, b *FieldMatch) bool {
+	return a.Field == b.Field && strings.Join(a.Modifiers, "|") == strings.Join(b.Modifiers, "|") && !a.HasModifier("all")
+}
+
+// filterParser parses the expression of a where operator
+type filterParser struct {
+	tokens   []kql.Token
+	pos      int
+	exporter *exporter
+}
+
+func (p *filterParser) peek() kql.Token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return kql.Token{Kind: kql.Punct}
+}
+
+func (p *filterParser) or() (*filter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().Is("or") {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = joinFilters("or", left, right)
+	}
+	return left, nil
+}
+
+func (p *filterParser) and() (*filter, error) {
+	left, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().Is("and") {
+		p.pos++
+		right, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		left = joinFilters("and", left, right)
+	}
+	return left, nil
+}
+
+func joinFilters(op string, a, b *filter) *filter {
+	var args []*filter
+	for _, f := range []*filter{a, b} {
+		if f.op == op {
+			args = append(args, f.args...)
+		} else {
+			args = append(args, f)
+		}
+	}
+	return &filter{op: op, args: args}
+}
+
+// operators are the KQL comparisons that export, with the Sigma modifier
+// and whether they negate
+var operators = map[string]struct {
+	modifier string
+	negated  bool
+	cased    bool
+}{
+	"==": {cased: true}, "=~": {}, "!=": {negated: true, cased: true}, "!~": {negated: true},
+	"has": {modifier: "contains"}, "!has": {modifier: "contains", negated: true},
+	"has_cs": {modifier: "contains", cased: true}, "contains": {modifier: "contains"},
+	"!contains": {modifier: "contains", negated: true}, "contains_cs": {modifier: "contains", cased: true},
+	"startswith": {modifier: "startswith"}, "!startswith": {modifier: "startswith", negated: true},
+	"startswith_cs": {modifier: "startswith", cased: true}, "endswith": {modifier: "endswith"},
+	"!endswith": {modifier: "endswith", negated: true}, "endswith_cs": {modifier: "endswith", cased: true},
+	"in": {cased: true}, "in~": {}, "!in": {negated: true, cased: true}, "!in~": {negated: true},
+	"has_any": {modifier: "contains"}, "has_all": {modifier: "contains"},
+}
+
+func (p *filterParser) primary() (*filter, error) {
+	t := p.peek()
+	switch {
+	case t.Is("("):
+		p.pos++
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek().Is(")") {
+			return nil, fmt.Errorf("%w: ( is never closed", ErrInvalidRule)
+		}
+		p.pos++
+		return f, nil
+	case t.Is("isempty") || t.Is("isnotempty") || t.Is("isnull") || t.Is("isnotnull"):
+		if p.pos+3 >= len(p.tokens) || !p.tokens[p.pos+1].Is("(") || !p.tokens[p.pos+3].Is(")") {
+			return nil, fmt.Errorf("%w: %s of an expression", ErrUnsupported, t.Text)
+		}
+		field, _, err := p.exporter.field(p.tokens[p.pos+2].Text)
+		if err != nil {
+			return nil, err
+		}
+		if hashAlgorithm(field) != "" {
+			return nil, fmt.Errorf("%w: %s of a hash column", ErrUnsupported, t.Text)
+		}
+		p.pos += 4
+		negated := t.Is("isnotempty") || t.Is("isnotnull")
+		return &filter{op: "match", negated: negated, match: &FieldMatch{Field: field, Values: []interface{}{nil}}}, nil
+	case t.Kind != kql.Ident:
+		return nil, fmt.Errorf("%w: cannot export %q", ErrUnsupported, t.Text)
+	}
+
+	column := t.Text
+	p.pos++
+	opTok := p.peek()
+	op, ok := operators[opTok.Text]
+	if !ok || opTok.Kind == kql.String {
+		if opTok.Is("matches") && p.pos+2 < len(p.tokens) && p.tokens[p.pos+1].Is("regex") {
+			p.pos += 2
+			return p.leaf(column, "matches regex", "re", false, false)
+		}
+		return nil, fmt.Errorf("%w: cannot export %s %s", ErrUnsupported, column, opTok.Text)
+	}
+	p.pos++
+	if opTok.Text == "has" || opTok.Text == "!has" || opTok.Text == "has_any" || opTok.Text == "has_all" {
+		p.exporter.export.warnf("%s matches whole terms; Sigma's contains also matches inside words", opTok.Text)
+	}
+	if op.cased && opTok.Text != "in" && opTok.Text != "!in" {
+		p.exporter.export.warnf("Sigma matches are case-insensitive, unlike %s", opTok.Text)
+	}
+	f, err := p.leaf(column, opTok.Text, op.modifier, op.negated, opTok.Text == "has_all")
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// leaf parses the value or list of values of a comparison
+func (p *filterParser) leaf(column, op, modifier string, negated, all bool) (*filter, error) {
+	var values []interface{}
+	if p.peek().Is("(") {
+		end := p.pos
+		for end < len(p.tokens) && !p.tokens[end].Is(")") {
+			end++
+		}
+		if end == len(p.tokens) {
+			return nil, fmt.Errorf("%w: ( is never closed", ErrInvalidRule)
+		}
+		for _, item := range splitTokens(p.tokens[p.pos+1:end], ",") {
+			v, err := literal(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		p.pos = end + 1
+	} else {
+		v, err := literal(p.tokens[p.pos:min(p.pos+1, len(p.tokens))])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		p.pos++
+	}
+
+	field, name, err := p.exporter.field(column)
+	if err != nil {
+		return nil, err
+	}
+	if alg := hashAlgorithm(field); alg != "" {
+		// Sigma matches hashes within the Hashes field
+		if modifier != "" {
+			return nil, fmt.Errorf("%w: %s on a hash column", ErrUnsupported, op)
+		}
+		for i, v := range values {
+			values[i] = fmt.Sprintf("%s=%v", alg, v)
+		}
+		field, modifier = "Hashes", "contains"
+	}
+	m := &FieldMatch{Field: field}
+	if modifier != "" {
+		m.Modifiers = append(m.Modifiers, modifier)
+	}
+	if all {
+		m.Modifiers = append(m.Modifiers, "all")
+	}
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if modifier != "re" {
+			s = escapeWildcards(s)
+		}
+		if name {
+			// The column holds the file name of a path field
+			switch modifier {
+			case "":
+				s = `\` + s
+			case "startswith":
+				s = `\` + s
+			}
+		}
+		values[i] = s
+	}
+	if name {
+		switch modifier {
+		case "":
+			m.Modifiers = []string{"endswith"}
+		case "startswith":
+			m.Modifiers = []string{"contains"}
+		}
+	}
+	m.Values = values
+	return &filter{op: "match", negated: negated, match: m}, nil
+}
+
+// field returns the Sigma field of a column and whether the column only
+// holds the file name of the field
+func (x *exporter) field(column string) (string, bool, error) {
+	f, ok := x.source.sigmaField(x.target, column)
+	if !ok {
+		return "", false, fmt.Errorf("%w: column %s has no Sigma field in %s", ErrUnsupported, column, x.source.category)
+	}
+	return f.sigma, f.name, nil
+}
+
+// hashAlgorithm returns the algorithm of a hash field, or "" for other
+// fields
+func hashAlgorithm(field string) string {
+	for alg, f := range hashColumns {
+		if f == field {
+			return alg
+		}
+	}
+	return ""
+}
+
+// literal returns the value of a string or number literal
+func literal(tokens []kql.Token) (interface{}, error) {
+	if len(tokens) != 1 {
+		return nil, fmt.Errorf("%w: only literal values export", ErrUnsupported)
+	}
+	t := tokens[0]
+	switch t.Kind {
+	case kql.Number:
+		if n, err := strconv.ParseInt(t.Text, 10, 64); err == nil {
+			return int(n), nil
+		}
+		if f, err := strconv.ParseFloat(t.Text, 64); err == nil {
+			return f, nil
+		}
+	case kql.String:
+		return unquote(t.Text)
+	case kql.Ident:
+		switch t.Text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: only literal values export, not %s", ErrUnsupported, t.Text)
+}
+
+// unquote decodes a KQL string literal
+func unquote(s string) (string, error) {
+	verbatim := false
+	if s[0] == 'h' || s[0] == 'H' {
+		s = s[1:]
+	}
+	if s[0] == '@' {
+		verbatim, s = true, s[1:]
+	}
+	if strings.HasPrefix(s, "```") || strings.HasPrefix(s, "~~~") {
+		return s[3 : len(s)-3], nil
+	}
+	quote, body := s[0], s[1:len(s)-1]
+	if verbatim {
+		return strings.ReplaceAll(body, string([]byte{quote, quote}), string(quote)), nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(body); i++ {
+		if body[i] != '\\' || i+1 == len(body) {
+			b.WriteByte(body[i])
+			continue
+		}
+		i++
+		switch body[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(body[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// escapeWildcards escapes the characters Sigma reads as wildcards
+func escapeWildcards(s string) string {
+	return strings.NewReplacer(`\*`, `\\\*`, `\?`, `\\\?`, `\\`, `\\\\`, `*`, `\*`, `?`, `\?`).Replace(s)
+}
+
+// splitTokens splits tokens on a separator outside brackets
+func splitTokens(tokens []kql.Token, sep string) [][]kql.Token {
+	var parts [][]kql.Token
+	depth, start := 0, 0
+	for i, t := range tokens {
+		if t.Kind != kql.Punct {
+			continue
+		}
+		switch t.Text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, tokens[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, tokens[start:])
+}
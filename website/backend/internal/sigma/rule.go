@@ -0,0 +1,241 @@
+// Package sigma converts Sigma detection rules to KQL for Defender
+// Advanced Hunting and Sentinel, and simple KQL filter queries back to
+// Sigma. It covers the Windows process_creation, network_connection and
+// file_event log sources.
+package sigma
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrInvalidRule is returned for documents that are not Sigma rules
+	ErrInvalidRule = errors.New("invalid sigma rule")
+	// ErrUnsupported is returned for rules that use a feature the
+	// conversion cannot express
+	ErrUnsupported = errors.New("unsupported")
+)
+
+// Rule is a Sigma rule
+type Rule struct {
+	Title          string    `yaml:"title"`
+	ID             string    `yaml:"id"`
+	Status         string    `yaml:"status"`
+	Description    string    `yaml:"description"`
+	References     []string  `yaml:"references"`
+	Author         string    `yaml:"author"`
+	Date           string    `yaml:"date"`
+	Modified       string    `yaml:"modified"`
+	Tags           []string  `yaml:"tags"`
+	LogSource      LogSource `yaml:"logsource"`
+	Detection      Detection `yaml:"detection"`
+	FalsePositives []string  `yaml:"falsepositives"`
+	Level          string    `yaml:"level"`
+	Fields         []string  `yaml:"fields"`
+	// Action marks the documents of a rule collection, which are not
+	// rules of their own
+	Action string `yaml:"action"`
+	// Text is the YAML document the rule was parsed from
+	Text string `yaml:"-"`
+}
+
+// LogSource names the events a rule applies to
+type LogSource struct {
+	Category string `yaml:"category,omitempty"`
+	Product  string `yaml:"product,omitempty"`
+	Service  string `yaml:"service,omitempty"`
+}
+
+// Detection is the search identifiers of a rule and the condition that
+// combines them. Searches are kept in the order the rule defines them.
+type Detection struct {
+	Searches   []*Search
+	Conditions []string
+	Timeframe  string
+}
+
+// Search is a named search identifier. It matches an event when any of its
+// maps matches, and a map matches when all of its field matches do.
+// Keywords are values searched for in any field.
+type Search struct {
+	Name     string
+	Maps     [][]*FieldMatch
+	Keywords []interface{}
+}
+
+// FieldMatch compares a field to values. With no modifier, the field
+// matches when it equals any of the values.
+type FieldMatch struct {
+	Field     string
+	Modifiers []string
+	// Values are strings, numbers, booleans or nil
+	Values []interface{}
+}
+
+// HasModifier reports whether the match has a modifier
+func (m *FieldMatch) HasModifier(name string) bool {
+	for _, mod := range m.Modifiers {
+		if mod == name {
+			return true
+		}
+	}
+	return false
+}
+
+// search returns the search identifier with a name
+func (d *Detection) search(name string) *Search {
+	for _, s := range d.Searches {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// UnmarshalYAML reads the search identifiers and condition of a detection
+func (d *Detection) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: detection must be a map", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		switch key {
+		case "condition":
+			if value.Kind == yaml.SequenceNode {
+				if err := value.Decode(&d.Conditions); err != nil {
+					return err
+				}
+			} else {
+				d.Conditions = []string{value.Value}
+			}
+		case "timeframe":
+			d.Timeframe = value.Value
+		default:
+			s, err := parseSearch(key, value)
+			if err != nil {
+				return err
+			}
+			d.Searches = append(d.Searches, s)
+		}
+	}
+	return nil
+}
+
+func parseSearch(name string, node *yaml.Node) (*Search, error) {
+	s := &Search{Name: name}
+	switch node.Kind {
+	case yaml.MappingNode:
+		m, err := parseMap(node)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		s.Maps = append(s.Maps, m)
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if item.Kind == yaml.MappingNode {
+				m, err := parseMap(item)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", name, err)
+				}
+				s.Maps = append(s.Maps, m)
+				continue
+			}
+			v, err := scalar(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			s.Keywords = append(s.Keywords, v)
+		}
+	default:
+		v, err := scalar(node)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		s.Keywords = append(s.Keywords, v)
+	}
+	return s, nil
+}
+
+func parseMap(node *yaml.Node) ([]*FieldMatch, error) {
+	var matches []*FieldMatch
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		parts := strings.Split(node.Content[i].Value, "|")
+		m := &FieldMatch{Field: parts[0], Modifiers: parts[1:]}
+		value := node.Content[i+1]
+		if value.Kind == yaml.SequenceNode {
+			for _, item := range value.Content {
+				v, err := scalar(item)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", m.Field, err)
+				}
+				m.Values = append(m.Values, v)
+			}
+		} else {
+			v, err := scalar(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", m.Field, err)
+			}
+			m.Values = append(m.Values, v)
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
+
+func scalar(node *yaml.Node) (interface{}, error) {
+	if node.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("line %d: expected a value", node.Line)
+	}
+	switch node.Tag {
+	case "!!null":
+		return nil, nil
+	case "!!int", "!!float", "!!bool":
+		var v interface{}
+		if err := node.Decode(&v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return node.Value, nil
+}
+
+// documentSeparator splits a YAML stream into documents
+var documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// Documents splits a YAML stream into its documents, dropping empty ones
+func Documents(data []byte) []string {
+	var docs []string
+	for _, doc := range documentSeparator.Split(string(data), -1) {
+		if strings.TrimSpace(doc) != "" {
+			docs = append(docs, strings.TrimSpace(doc)+"\n")
+		}
+	}
+	return docs
+}
+
+// Parse parses one Sigma rule document
+func Parse(doc string) (*Rule, error) {
+	var rule Rule
+	if err := yaml.Unmarshal([]byte(doc), &rule); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	rule.Text = doc
+	switch {
+	case rule.Action != "":
+		return nil, fmt.Errorf("%w: rule collections (action: %s) are not supported", ErrUnsupported, rule.Action)
+	case rule.Title == "":
+		return nil, fmt.Errorf("%w: title is required", ErrInvalidRule)
+	case rule.LogSource == LogSource{}:
+		return nil, fmt.Errorf("%w: logsource is required", ErrInvalidRule)
+	case len(rule.Detection.Searches) == 0:
+		return nil, fmt.Errorf("%w: detection has no search identifiers", ErrInvalidRule)
+	case len(rule.Detection.Conditions) == 0:
+		return nil, fmt.Errorf("%w: detection has no condition", ErrInvalidRule)
+	}
+	return &rule, nil
+}
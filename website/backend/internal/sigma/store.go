@@ -0,0 +1,232 @@
+package sigma
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// MaxUploadSize bounds the size of an uploaded Sigma file
+	MaxUploadSize = 4 << 20
+	// maxRules is the largest number of rules read from a single upload
+	maxRules = 1000
+)
+
+// ErrNotFound is returned when exporting a rule that does not exist
+var ErrNotFound = errors.New("rule not found")
+
+// categories are the rule categories of the ATT&CK tactics Sigma tags name
+var categories = map[string]string{
+	"attack.initial_access":       "Initial Access",
+	"attack.persistence":          "Persistence",
+	"attack.privilege_escalation": "Privilege Escalation",
+	"attack.defense_evasion":      "Defense Evasion",
+	"attack.credential_access":    "Credential Theft",
+	"attack.lateral_movement":     "Lateral Movement",
+	"attack.exfiltration":         "Data Exfiltration",
+}
+
+// levels are the Sigma levels, which are also the rule severities
+var levels = map[string]bool{"informational": true, "low": true, "medium": true, "high": true, "critical": true}
+
+var (
+	// techniqueTag is the Sigma tag of an ATT&CK technique
+	techniqueTag = regexp.MustCompile(`^attack\.(t\d{4}(?:\.\d{3})?)$`)
+	// techniqueID is a technique as the detection library tags it
+	techniqueID = regexp.MustCompile(`^T\d{4}(?:\.\d{3})?$`)
+)
+
+// Store imports Sigma rules into mde_rules.master_rules and exports rules
+// from it
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates a Sigma rule store
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// ImportResult is the outcome of importing one document of an upload.
+// A rule that cannot be converted has an Error and is not saved.
+type ImportResult struct {
+	Document int      `json:"document"`
+	Title    string   `json:"title,omitempty"`
+	SigmaID  string   `json:"sigmaId,omitempty"`
+	RuleID   string   `json:"ruleId,omitempty"`
+	Created  bool     `json:"created"`
+	Table    string   `json:"table,omitempty"`
+	Query    string   `json:"query,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Import converts the rules of a Sigma YAML stream for a target and saves
+// them as master rules. Rules are matched to earlier imports by their
+// Sigma id. A dry run converts the rules without saving them.
+func (s *Store) Import(ctx context.Context, r io.Reader, target Target, dryRun bool) ([]*ImportResult, error) {
+	if target != Defender && target != Sentinel {
+		return nil, fmt.Errorf("%w: target %q", ErrUnsupported, target)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	docs := Documents(data)
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("%w: the upload has no rules", ErrInvalidRule)
+	}
+	if len(docs) > maxRules {
+		return nil, fmt.Errorf("%w: an upload holds at most %d rules", ErrInvalidRule, maxRules)
+	}
+
+	results := make([]*ImportResult, len(docs))
+	for i, doc := range docs {
+		res := &ImportResult{Document: i + 1}
+		results[i] = res
+		rule, err := Parse(doc)
+		if err != nil {
+			res.Error = err.Error()
+			continue
+		}
+		res.Title, res.SigmaID = rule.Title, rule.ID
+		c, err := ToKQL(rule, target)
+		if err != nil {
+			res.Error = err.Error()
+			continue
+		}
+		res.Table, res.Query, res.Warnings = c.Table, c.Query, c.Warnings
+		switch {
+		case rule.Level == "":
+			res.Warnings = append(res.Warnings, "the rule has no level and is imported as medium")
+		case !levels[rule.Level]:
+			res.Warnings = append(res.Warnings, fmt.Sprintf("level %q is not a Sigma level; the rule is imported as medium", rule.Level))
+		}
+		if dryRun {
+			continue
+		}
+		if err := s.save(ctx, rule, res); err != nil {
+			return nil, fmt.Errorf("saving %s: %w", rule.Title, err)
+		}
+	}
+	return results, nil
+}
+
+// save upserts a converted rule, setting its RuleID and Created
+func (s *Store) save(ctx context.Context, rule *Rule, res *ImportResult) error {
+	severity := rule.Level
+	if !levels[severity] {
+		severity = "medium"
+	}
+	var tags []string
+	category := ""
+	for _, tag := range rule.Tags {
+		tag = strings.ToLower(tag)
+		tags = appendUnique(tags, tag)
+		if m := techniqueTag.FindStringSubmatch(tag); m != nil {
+			tags = appendUnique(tags, strings.ToUpper(m[1]))
+		}
+		if name, ok := categories[tag]; ok && category == "" {
+			category = name
+		}
+	}
+	var sourceID interface{}
+	if rule.ID != "" {
+		sourceID = rule.ID
+	}
+	warnings := res.Warnings
+	if warnings == nil {
+		warnings = []string{}
+	}
+
+	// source_id is NULL for rules without an id, which never conflict
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO mde_rules.master_rules
+			(title, description, rule_text, rule_type, category_id, severity, tags, author,
+			 source, source_id, source_text, conversion_warnings)
+		VALUES ($1, $2, $3, 'detection',
+			(SELECT id FROM mde_rules.categories WHERE name = $4),
+			$5, $6, NULLIF($7, ''), 'sigma', $8, $9, $10)
+		ON CONFLICT (source, source_id) DO UPDATE SET
+			title = EXCLUDED.title,
+			description = EXCLUDED.description,
+			rule_text = EXCLUDED.rule_text,
+			category_id = COALESCE(EXCLUDED.category_id, master_rules.category_id),
+			severity = EXCLUDED.severity,
+			tags = EXCLUDED.tags,
+			author = EXCLUDED.author,
+			source_text = EXCLUDED.source_text,
+			conversion_warnings = EXCLUDED.conversion_warnings,
+			updated_at = NOW()
+		RETURNING id, xmax = 0`,
+		rule.Title, rule.Description, res.Query, category, severity, pq.Array(tags),
+		rule.Author, sourceID, rule.Text, pq.Array(warnings),
+	).Scan(&res.RuleID, &res.Created)
+	return err
+}
+
+// Export returns a master rule as a Sigma rule. Rules imported from Sigma
+// are returned as imported; others are exported from their query.
+func (s *Store) Export(ctx context.Context, id string) (*Export, error) {
+	var (
+		title, query, severity      string
+		description, author, source sql.NullString
+		sourceText                  sql.NullString
+		tags                        []string
+		updated                     time.Time
+	)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT title, description, rule_text, severity, tags, author, updated_at,
+			source, source_text
+		FROM mde_rules.master_rules WHERE id::text = $1`, id,
+	).Scan(&title, &description, &query, &severity, pq.Array(&tags), &author, &updated,
+		&source, &sourceText)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if source.String == "sigma" && sourceText.String != "" {
+		return &Export{Sigma: sourceText.String}, nil
+	}
+
+	meta := ExportMeta{
+		ID:          id,
+		Title:       title,
+		Description: description.String,
+		Author:      author.String,
+		Date:        updated.Format("2006-01-02"),
+		Level:       strings.ToLower(severity),
+	}
+	if !levels[meta.Level] {
+		meta.Level = "medium"
+	}
+	for _, tag := range tags {
+		switch {
+		case techniqueID.MatchString(tag):
+			meta.Tags = appendUnique(meta.Tags, "attack."+strings.ToLower(tag))
+		case strings.Contains(tag, "."):
+			// Sigma tags are namespaced; free-form tags are left out
+			meta.Tags = appendUnique(meta.Tags, strings.ToLower(tag))
+		}
+	}
+	return FromKQL(query, meta)
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
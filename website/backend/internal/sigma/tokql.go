@@ -0,0 +1,552 @@
+package sigma
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/kql"
+)
+
+// Conversion is a Sigma rule converted to KQL. Warnings describe where the
+// query matches differently than the rule.
+type Conversion struct {
+	Target   Target   `json:"target"`
+	Table    string   `json:"table"`
+	Query    string   `json:"query"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (c *Conversion) warnf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	for _, w := range c.Warnings {
+		if w == msg {
+			return
+		}
+	}
+	c.Warnings = append(c.Warnings, msg)
+}
+
+// windashes are the characters the windash modifier lets stand in for a
+// leading - of a command line flag
+var windashes = []string{"-", "/", "–", "—", "―"}
+
+// ToKQL converts a rule to a query of the target's table for its log
+// source
+func ToKQL(rule *Rule, target Target) (*Conversion, error) {
+	ls := findLogSource(rule.LogSource.Category)
+	switch {
+	case target != Defender && target != Sentinel:
+		return nil, fmt.Errorf("%w: target %q", ErrUnsupported, target)
+	case rule.LogSource.Category == "":
+		return nil, fmt.Errorf("%w: log sources without a category (service %q)", ErrUnsupported, rule.LogSource.Service)
+	case ls == nil:
+		return nil, fmt.Errorf("%w: log source category %s", ErrUnsupported, rule.LogSource.Category)
+	case !products[strings.ToLower(rule.LogSource.Product)]:
+		return nil, fmt.Errorf("%w: log source product %s", ErrUnsupported, rule.LogSource.Product)
+	}
+
+	cond, err := parseCondition(&rule.Detection)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conversion{Target: target, Table: ls.tables[target]}
+	b := &builder{conv: c, source: ls, target: target}
+	where, err := b.expr(cond)
+	if err != nil {
+		return nil, err
+	}
+	if where.op == "true" {
+		return nil, fmt.Errorf("%w: every match of the rule is on a field %s does not record", ErrUnsupported, c.Table)
+	}
+
+	var query strings.Builder
+	query.WriteString(c.Table)
+	if where.op == "and" {
+		for _, arg := range where.args {
+			query.WriteString("\n| where " + arg.render(0))
+		}
+	} else {
+		query.WriteString("\n| where " + where.render(0))
+	}
+	c.Query = query.String()
+
+	switch strings.ToLower(rule.Status) {
+	case "experimental", "test":
+		c.warnf("the rule's status is %s; expect false positives", rule.Status)
+	case "deprecated", "unsupported":
+		c.warnf("the rule's status is %s", rule.Status)
+	}
+	if rule.Detection.Timeframe != "" {
+		c.warnf("timeframe %s only applies to aggregations and is ignored", rule.Detection.Timeframe)
+	}
+	if errs := kql.Check(c.Query); len(errs) > 0 {
+		return nil, fmt.Errorf("converted query is invalid: %v", errs[0])
+	}
+	return c, nil
+}
+
+// kqlExpr is a KQL boolean expression under construction. Leaves hold
+// their text; "true" is a condition that always holds, left by matches on
+// ignored fields.
+type kqlExpr struct {
+	op   string // and, or, not, leaf, true
+	args []*kqlExpr
+	text string
+}
+
+var alwaysTrue = &kqlExpr{op: "true"}
+
+func combine(op string, args []*kqlExpr) *kqlExpr {
+	var kept []*kqlExpr
+	for _, a := range args {
+		switch {
+		case a.op == "true" && op == "and":
+			continue
+		case a.op == "true" && op == "or":
+			return alwaysTrue
+		case a.op == op:
+			kept = append(kept, a.args...)
+		default:
+			kept = append(kept, a)
+		}
+	}
+	switch len(kept) {
+	case 0:
+		return alwaysTrue
+	case 1:
+		return kept[0]
+	}
+	return &kqlExpr{op: op, args: kept}
+}
+
+var precedence = map[string]int{"or": 1, "and": 2}
+
+func (e *kqlExpr) render(parent int) string {
+	switch e.op {
+	case "leaf":
+		return e.text
+	case "true":
+		return "true"
+	case "not":
+		return "not(" + e.args[0].render(0) + ")"
+	}
+	parts := make([]string, len(e.args))
+	for i, a := range e.args {
+		parts[i] = a.render(precedence[e.op])
+	}
+	s := strings.Join(parts, " "+e.op+" ")
+	if parent > precedence[e.op] {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+type builder struct {
+	conv   *Conversion
+	source *logSource
+	target Target
+}
+
+func (b *builder) expr(e *expr) (*kqlExpr, error) {
+	switch e.op {
+	case "search":
+		return b.search(e.search)
+	case "not":
+		inner, err := b.expr(e.args[0])
+		if err != nil {
+			return nil, err
+		}
+		if inner.op == "true" {
+			// The ignored match would have excluded events; keep them
+			return alwaysTrue, nil
+		}
+		return &kqlExpr{op: "not", args: []*kqlExpr{inner}}, nil
+	}
+	var args []*kqlExpr
+	for _, a := range e.args {
+		k, err := b.expr(a)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, k)
+	}
+	return combine(e.op, args), nil
+}
+
+func (b *builder) search(s *Search) (*kqlExpr, error) {
+	if len(s.Keywords) > 0 {
+		return nil, fmt.Errorf("%w: keyword search %s; match a field instead", ErrUnsupported, s.Name)
+	}
+	var maps []*kqlExpr
+	for _, m := range s.Maps {
+		var fields []*kqlExpr
+		for _, fm := range m {
+			k, err := b.field(fm)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", s.Name, err)
+			}
+			fields = append(fields, k)
+		}
+		maps = append(maps, combine("and", fields))
+	}
+	return combine("or", maps), nil
+}
+
+func (b *builder) field(m *FieldMatch) (*kqlExpr, error) {
+	if strings.EqualFold(m.Field, "Hashes") || strings.EqualFold(m.Field, "Hash") {
+		return b.hashes(m)
+	}
+	column, ok := b.source.column(b.target, m.Field)
+	if !ok {
+		if b.source.isIgnored(m.Field) {
+			b.conv.warnf("%s is not recorded by %s; its match is left out", m.Field, b.conv.Table)
+			return alwaysTrue, nil
+		}
+		return nil, fmt.Errorf("%w: field %s of %s has no %s column", ErrUnsupported, m.Field, b.source.category, b.target)
+	}
+	return b.match(column, m.Modifiers, m.Values)
+}
+
+// hashes converts a Hashes field, whose values are ALGORITHM=value
+func (b *builder) hashes(m *FieldMatch) (*kqlExpr, error) {
+	var alternatives []*kqlExpr
+	for _, v := range m.Values {
+		s, _ := v.(string)
+		algorithm, hash, ok := strings.Cut(s, "=")
+		field := hashColumns[strings.ToUpper(algorithm)]
+		column, mapped := b.source.column(b.target, field)
+		switch {
+		case !ok || field == "":
+			return nil, fmt.Errorf("%w: hash %q is not ALGORITHM=value", ErrInvalidRule, s)
+		case !mapped:
+			b.conv.warnf("%s hashes are not recorded by %s; they are left out", strings.ToUpper(algorithm), b.conv.Table)
+			continue
+		}
+		k, err := b.match(column, nil, []interface{}{hash})
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, k)
+	}
+	if len(alternatives) == 0 {
+		return nil, fmt.Errorf("%w: none of the hashes of %s can be queried", ErrUnsupported, b.conv.Table)
+	}
+	if m.HasModifier("all") {
+		return combine("and", alternatives), nil
+	}
+	return combine("or", alternatives), nil
+}
+
+// match converts a column compared to values with modifiers
+func (b *builder) match(column string, modifiers []string, values []interface{}) (*kqlExpr, error) {
+	var op string
+	all, cased, windash := false, false, false
+	var reFlags string
+	for _, mod := range modifiers {
+		switch mod {
+		case "contains", "startswith", "endswith", "re", "cidr", "gt", "gte", "lt", "lte", "exists":
+			if op != "" {
+				return nil, fmt.Errorf("%w: modifiers %s and %s together", ErrUnsupported, op, mod)
+			}
+			op = mod
+		case "all":
+			all = true
+		case "cased":
+			cased = true
+		case "windash":
+			windash = true
+		case "i", "m", "s":
+			reFlags += mod
+		default:
+			return nil, fmt.Errorf("%w: modifier %s", ErrUnsupported, mod)
+		}
+	}
+	if windash {
+		values = expandWindash(values)
+	}
+
+	var terms []*kqlExpr
+	for _, v := range values {
+		t, err := b.term(column, op, v, cased, reFlags)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+	if all {
+		return combine("and", terms), nil
+	}
+	if t := b.inList(column, op, values, cased); t != nil {
+		return t, nil
+	}
+	return combine("or", terms), nil
+}
+
+// inList returns a single in or in~ test for several plain values, or nil
+func (b *builder) inList(column, op string, values []interface{}, cased bool) *kqlExpr {
+	if op != "" || len(values) < 2 {
+		return nil
+	}
+	quoted := make([]string, len(values))
+	numeric := 0
+	for i, v := range values {
+		if _, isString := v.(string); !isString {
+			if n, ok := number(v); ok {
+				quoted[i] = n
+				numeric++
+				continue
+			}
+		}
+		s, ok := v.(string)
+		if !ok || strings.ContainsAny(s, "*?") {
+			return nil
+		}
+		quoted[i] = quote(s)
+	}
+	in := "in~"
+	switch {
+	case numeric == len(values) || cased:
+		in = "in"
+	case numeric > 0:
+		return nil
+	}
+	return &kqlExpr{op: "leaf", text: fmt.Sprintf("%s %s (%s)", column, in, strings.Join(quoted, ", "))}
+}
+
+func (b *builder) term(column, op string, v interface{}, cased bool, reFlags string) (*kqlExpr, error) {
+	leaf := func(format string, args ...interface{}) (*kqlExpr, error) {
+		return &kqlExpr{op: "leaf", text: fmt.Sprintf(format, args...)}, nil
+	}
+	switch op {
+	case "exists":
+		if v == false || v == "false" {
+			return leaf("isempty(%s)", column)
+		}
+		return leaf("isnotempty(%s)", column)
+	case "gt", "gte", "lt", "lte":
+		n, ok := number(v)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s needs a number, not %v", ErrInvalidRule, op, v)
+		}
+		cmp := map[string]string{"gt": ">", "gte": ">=", "lt": "<", "lte": "<="}[op]
+		return leaf("%s %s %s", column, cmp, n)
+	case "cidr":
+		s, _ := v.(string)
+		if strings.Contains(s, ":") {
+			return leaf("ipv6_is_in_range(%s, %s)", column, quote(s))
+		}
+		return leaf("ipv4_is_in_range(%s, %s)", column, quote(s))
+	case "re":
+		s, _ := v.(string)
+		if strings.Contains(s, "(?=") || strings.Contains(s, "(?!") || strings.Contains(s, "(?<") {
+			return nil, fmt.Errorf("%w: lookaround in regular expression %q", ErrUnsupported, s)
+		}
+		if reFlags != "" {
+			s = "(?" + reFlags + ")" + s
+		}
+		return leaf("%s matches regex %s", column, quote(s))
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return leaf("isempty(%s)", column)
+	case bool:
+		return leaf("%s == %t", column, v)
+	case int, int64, float64:
+		n, _ := number(v)
+		return leaf("%s == %s", column, n)
+	}
+	// The wildcards of a modifier are added after parsing, so a value
+	// ending in a backslash does not escape them
+	p := parsePattern(fmt.Sprint(v))
+	any := patternPart{wildcard: '*'}
+	switch op {
+	case "contains":
+		p = append(append(pattern{any}, p...), any)
+	case "startswith":
+		p = append(p, any)
+	case "endswith":
+		p = append(pattern{any}, p...)
+	}
+	return b.pattern(column, p, cased)
+}
+
+// pattern converts a value with Sigma wildcards to the cheapest KQL test
+func (b *builder) pattern(column string, p pattern, cased bool) (*kqlExpr, error) {
+	suffix := ""
+	if cased {
+		suffix = "_cs"
+	}
+	leaf := func(format string, args ...interface{}) (*kqlExpr, error) {
+		return &kqlExpr{op: "leaf", text: fmt.Sprintf(format, args...)}, nil
+	}
+
+	literal, leading, trailing, simple := p.simple()
+	switch {
+	case simple && literal == "" && (leading || trailing):
+		return leaf("isnotempty(%s)", column)
+	case simple && leading && trailing:
+		return leaf("%s contains%s %s", column, suffix, quote(literal))
+	case simple && leading:
+		return leaf("%s endswith%s %s", column, suffix, quote(literal))
+	case simple && trailing:
+		return leaf("%s startswith%s %s", column, suffix, quote(literal))
+	case simple && cased:
+		return leaf("%s == %s", column, quote(literal))
+	case simple:
+		return leaf("%s =~ %s", column, quote(literal))
+	}
+	re := p.regex()
+	if !cased {
+		re = "(?i)" + re
+	}
+	return leaf("%s matches regex %s", column, quote(re))
+}
+
+// patternPart is a run of literal text or a wildcard of a Sigma value
+type patternPart struct {
+	text     string
+	wildcard byte // '*' or '?' for wildcards
+}
+
+type pattern []patternPart
+
+// parsePattern splits a Sigma value into literal text and wildcards. A
+// backslash escapes a wildcard or a backslash and is literal otherwise.
+func parsePattern(s string) pattern {
+	var p pattern
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			p = append(p, patternPart{text: lit.String()})
+			lit.Reset()
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(`*?\`, s[i+1]) >= 0:
+			lit.WriteByte(s[i+1])
+			i++
+		case c == '*' || c == '?':
+			flush()
+			p = append(p, patternPart{wildcard: c})
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	flush()
+	return p
+}
+
+// simple reports whether the pattern is literal text with at most a *
+// on either end
+func (p pattern) simple() (literal string, leading, trailing, ok bool) {
+	parts := p
+	for len(parts) > 0 && parts[0].wildcard == '*' {
+		leading, parts = true, parts[1:]
+	}
+	for len(parts) > 0 && parts[len(parts)-1].wildcard == '*' {
+		trailing, parts = true, parts[:len(parts)-1]
+	}
+	switch len(parts) {
+	case 0:
+		return "", leading, trailing, true
+	case 1:
+		if parts[0].wildcard == 0 {
+			return parts[0].text, leading, trailing, true
+		}
+	}
+	return "", false, false, false
+}
+
+func (p pattern) regex() string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, part := range p {
+		switch part.wildcard {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexpQuote(part.text))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+func regexpQuote(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`\.+*?()|[]{}^$`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// quote returns a KQL string literal. Values with backslashes, such as
+// paths, are written verbatim.
+func quote(s string) string {
+	if strings.Contains(s, `\`) {
+		return `@"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return strconv.Quote(s)
+}
+
+func number(v interface{}) (string, bool) {
+	switch n := v.(type) {
+	case int:
+		return strconv.Itoa(n), true
+	case int64:
+		return strconv.FormatInt(n, 10), true
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64), true
+	case string:
+		if _, err := strconv.ParseFloat(n, 64); err == nil {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+// expandWindash returns each value once for every windash, substituted for
+// the dashes that start a command line flag: a leading dash or one after
+// whitespace. Dashes inside words, such as that of Invoke-Expression, are
+// kept.
+func expandWindash(values []interface{}) []interface{} {
+	var out []interface{}
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok || !strings.Contains(s, "-") {
+			out = append(out, v)
+			continue
+		}
+		var flags []int
+		for i := range s {
+			if s[i] == '-' && (i == 0 || unicode.IsSpace(rune(s[i-1]))) {
+				flags = append(flags, i)
+			}
+		}
+		if len(flags) == 0 {
+			out = append(out, v)
+			continue
+		}
+		for _, dash := range windashes {
+			var b strings.Builder
+			last := 0
+			for _, i := range flags {
+				b.WriteString(s[last:i])
+				b.WriteString(dash)
+				last = i + 1
+			}
+			b.WriteString(s[last:])
+			out = append(out, b.String())
+		}
+	}
+	return out
+}
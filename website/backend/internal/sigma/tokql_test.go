@@ -0,0 +1,244 @@
+package sigma
+
+import (
+	"reflect"
+	"testing"
+)
+
+// rule parses a rule of a Windows log source with the given detection
+func rule(t *testing.T, category, detection string) *Rule {
+	t.Helper()
+	r, err := Parse("title: Test\nlogsource:\n  category: " + category + "\n  product: windows\ndetection:\n" + detection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestToKQL(t *testing.T) {
+	tests := []struct {
+		name      string
+		category  string
+		detection string
+		defender  string
+		sentinel  string
+	}{{
+		name: "modifiers",
+		detection: `  selection:
+    Image|endswith: '\powershell.exe'
+    CommandLine|contains|all: [' -nop', 'IEX']
+  parent:
+    ParentImage|startswith: 'C:\Windows\'
+    CommandLine|re: '^[a-z]+\.exe'
+  cased:
+    CommandLine|contains|cased: 'Invoke-Mimikatz'
+  condition: selection and (parent or cased)
+`,
+		defender: `DeviceProcessEvents
+| where FolderPath endswith @"\powershell.exe"
+| where ProcessCommandLine contains " -nop"
+| where ProcessCommandLine contains "IEX"
+| where InitiatingProcessFolderPath startswith @"C:\Windows\" and ProcessCommandLine matches regex @"^[a-z]+\.exe" or ProcessCommandLine contains_cs "Invoke-Mimikatz"`,
+		sentinel: `_Im_ProcessCreate
+| where TargetProcessName endswith @"\powershell.exe"
+| where TargetProcessCommandLine contains " -nop"
+| where TargetProcessCommandLine contains "IEX"
+| where ActingProcessName startswith @"C:\Windows\" and TargetProcessCommandLine matches regex @"^[a-z]+\.exe" or TargetProcessCommandLine contains_cs "Invoke-Mimikatz"`,
+	}, {
+		name:     "network modifiers",
+		category: "network_connection",
+		detection: `  selection:
+    DestinationIp|cidr: '10.0.0.0/8'
+    DestinationPort|gt: 1024
+    DestinationHostname|exists: true
+  condition: selection
+`,
+		defender: `DeviceNetworkEvents
+| where ipv4_is_in_range(RemoteIP, "10.0.0.0/8")
+| where RemotePort > 1024
+| where isnotempty(RemoteUrl)`,
+		sentinel: `_Im_NetworkSession
+| where ipv4_is_in_range(DstIpAddr, "10.0.0.0/8")
+| where DstPortNumber > 1024
+| where isnotempty(DstHostname)`,
+	}, {
+		name: "1 of",
+		detection: `  selection_img:
+    Image|endswith: ['\rundll32.exe', '\regsvr32.exe']
+  selection_cli:
+    CommandLine|contains: 'http'
+  filter:
+    User: SYSTEM
+  condition: 1 of selection_*
+`,
+		defender: `DeviceProcessEvents
+| where FolderPath endswith @"\rundll32.exe" or FolderPath endswith @"\regsvr32.exe" or ProcessCommandLine contains "http"`,
+		sentinel: `_Im_ProcessCreate
+| where TargetProcessName endswith @"\rundll32.exe" or TargetProcessName endswith @"\regsvr32.exe" or TargetProcessCommandLine contains "http"`,
+	}, {
+		name: "all of them",
+		detection: `  selection_img:
+    Image|endswith: '\certutil.exe'
+  selection_cli:
+    CommandLine|contains: 'urlcache'
+  condition: all of them
+`,
+		defender: `DeviceProcessEvents
+| where FolderPath endswith @"\certutil.exe"
+| where ProcessCommandLine contains "urlcache"`,
+		sentinel: `_Im_ProcessCreate
+| where TargetProcessName endswith @"\certutil.exe"
+| where TargetProcessCommandLine contains "urlcache"`,
+	}, {
+		name: "not filter",
+		detection: `  selection:
+    Image|endswith: '\powershell.exe'
+  filter:
+    ParentImage: ['C:\Windows\explorer.exe', 'C:\Windows\System32\svchost.exe']
+  condition: selection and not filter
+`,
+		defender: `DeviceProcessEvents
+| where FolderPath endswith @"\powershell.exe"
+| where not(InitiatingProcessFolderPath in~ (@"C:\Windows\explorer.exe", @"C:\Windows\System32\svchost.exe"))`,
+		sentinel: `_Im_ProcessCreate
+| where TargetProcessName endswith @"\powershell.exe"
+| where not(ActingProcessName in~ (@"C:\Windows\explorer.exe", @"C:\Windows\System32\svchost.exe"))`,
+	}, {
+		name: "windash",
+		detection: `  selection:
+    CommandLine|windash|contains: [' -enc ', 'Invoke-Expression']
+  condition: selection
+`,
+		defender: `DeviceProcessEvents
+| where ProcessCommandLine contains " -enc " or ProcessCommandLine contains " /enc " or ProcessCommandLine contains " –enc " or ProcessCommandLine contains " —enc " or ProcessCommandLine contains " ―enc " or ProcessCommandLine contains "Invoke-Expression"`,
+		sentinel: `_Im_ProcessCreate
+| where TargetProcessCommandLine contains " -enc " or TargetProcessCommandLine contains " /enc " or TargetProcessCommandLine contains " –enc " or TargetProcessCommandLine contains " —enc " or TargetProcessCommandLine contains " ―enc " or TargetProcessCommandLine contains "Invoke-Expression"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			category := tt.category
+			if category == "" {
+				category = "process_creation"
+			}
+			r := rule(t, category, tt.detection)
+			for target, want := range map[Target]string{Defender: tt.defender, Sentinel: tt.sentinel} {
+				c, err := ToKQL(r, target)
+				if err != nil {
+					t.Fatalf("%s: %v", target, err)
+				}
+				if c.Query != want {
+					t.Errorf("%s query =\n%s\nwant\n%s", target, c.Query, want)
+				}
+				if len(c.Warnings) > 0 {
+					t.Errorf("%s warnings = %v", target, c.Warnings)
+				}
+			}
+		})
+	}
+}
+
+func TestExpandWindash(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  []interface{}
+	}{
+		{"-enc", []interface{}{"-enc", "/enc", "–enc", "—enc", "―enc"}},
+		{"-nop -w hidden", []interface{}{"-nop -w hidden", "/nop /w hidden", "–nop –w hidden", "—nop —w hidden", "―nop ―w hidden"}},
+		{"cmd\t-c", []interface{}{"cmd\t-c", "cmd\t/c", "cmd\t–c", "cmd\t—c", "cmd\t―c"}},
+		{"Invoke-Expression", []interface{}{"Invoke-Expression"}},
+		{"Invoke-WebRequest -Uri", []interface{}{"Invoke-WebRequest -Uri", "Invoke-WebRequest /Uri", "Invoke-WebRequest –Uri", "Invoke-WebRequest —Uri", "Invoke-WebRequest ―Uri"}},
+		{"no dash", []interface{}{"no dash"}},
+		{1024, []interface{}{1024}},
+	}
+	for _, tt := range tests {
+		if got := expandWindash([]interface{}{tt.value}); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandWindash(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFromKQLRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		defender string
+		sentinel string
+	}{{
+		name: "file name",
+		query: `DeviceProcessEvents
+| where FileName =~ "powershell.exe" and ProcessCommandLine contains "-enc"`,
+		defender: `DeviceProcessEvents
+| where FolderPath endswith @"\powershell.exe"
+| where ProcessCommandLine contains "-enc"`,
+		sentinel: `_Im_ProcessCreate
+| where TargetProcessName endswith @"\powershell.exe"
+| where TargetProcessCommandLine contains "-enc"`,
+	}, {
+		name: "alternatives and exclusions",
+		query: `DeviceProcessEvents
+| where ProcessCommandLine startswith "cmd" or ProcessCommandLine startswith "powershell"
+| where InitiatingProcessFolderPath !in~ (@"C:\Windows\explorer.exe", @"C:\Windows\System32\svchost.exe")
+| where ProcessCommandLine !contains "healthcheck"`,
+		defender: `DeviceProcessEvents
+| where ProcessCommandLine startswith "cmd" or ProcessCommandLine startswith "powershell"
+| where not(InitiatingProcessFolderPath in~ (@"C:\Windows\explorer.exe", @"C:\Windows\System32\svchost.exe"))
+| where not(ProcessCommandLine contains "healthcheck")`,
+		sentinel: `_Im_ProcessCreate
+| where TargetProcessCommandLine startswith "cmd" or TargetProcessCommandLine startswith "powershell"
+| where not(ActingProcessName in~ (@"C:\Windows\explorer.exe", @"C:\Windows\System32\svchost.exe"))
+| where not(TargetProcessCommandLine contains "healthcheck")`,
+	}, {
+		name: "numbers",
+		query: `DeviceNetworkEvents
+| where RemotePort in (4444, 8080) and isnotempty(RemoteUrl)`,
+		defender: `DeviceNetworkEvents
+| where RemotePort in (4444, 8080)
+| where not(isempty(RemoteUrl))`,
+		sentinel: `_Im_NetworkSession
+| where DstPortNumber in (4444, 8080)
+| where not(isempty(DstHostname))`,
+	}, {
+		name: "regex from sentinel",
+		query: `_Im_ProcessCreate
+| where TargetProcessName endswith @"\rundll32.exe" and TargetProcessCommandLine matches regex "(?i)javascript:"`,
+		defender: `DeviceProcessEvents
+| where FolderPath endswith @"\rundll32.exe"
+| where ProcessCommandLine matches regex "(?i)javascript:"`,
+		sentinel: `_Im_ProcessCreate
+| where TargetProcessName endswith @"\rundll32.exe"
+| where TargetProcessCommandLine matches regex "(?i)javascript:"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp, err := FromKQL(tt.query, ExportMeta{Title: "Round trip"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(exp.Warnings) > 0 {
+				t.Errorf("export warnings = %v", exp.Warnings)
+			}
+			r, err := Parse(exp.Sigma)
+			if err != nil {
+				t.Fatalf("exported rule does not parse: %v\n%s", err, exp.Sigma)
+			}
+			for target, want := range map[Target]string{Defender: tt.defender, Sentinel: tt.sentinel} {
+				c, err := ToKQL(r, target)
+				if err != nil {
+					t.Fatalf("%s: %v\n%s", target, err, exp.Sigma)
+				}
+				if c.Query != want {
+					t.Errorf("%s query =\n%s\nwant\n%s\nfrom\n%s", target, c.Query, want, exp.Sigma)
+				}
+			}
+		})
+	}
+
+	// Sigma matches are case-insensitive, which the export warns of
+	exp, err := FromKQL("DeviceNetworkEvents\n| where RemoteUrl contains_cs \"Evil\"", ExportMeta{Title: "Cased"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exp.Warnings) != 1 {
+		t.Errorf("warnings = %v, want the case-insensitivity warning", exp.Warnings)
+	}
+}
@@ -0,0 +1,70 @@
+package stix
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Comparison is a single "object:path = 'value'" term of a STIX pattern
+type Comparison struct {
+	ObjectType string
+	Path       string
+	Value      string
+}
+
+var (
+	comparisonRegex = regexp.MustCompile(`([a-z0-9-]+):([A-Za-z0-9_.'\-]+)\s*(=|!=|LIKE|MATCHES|IN|>|<|>=|<=)\s*'((?:[^'\\]|\\.)*)'`)
+	connectiveRegex = regexp.MustCompile(`\b(AND|FOLLOWEDBY)\b`)
+)
+
+// ParsePattern extracts the equality comparisons of a STIX pattern.
+// Only patterns made of equality comparisons joined by OR are supported,
+// which covers the single-observable indicators published by TI feeds.
+func ParsePattern(pattern string) ([]Comparison, error) {
+	matches := comparisonRegex.FindAllStringSubmatchIndex(pattern, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no comparison expressions in pattern")
+	}
+
+	var comparisons []Comparison
+	var rest strings.Builder
+	last := 0
+	for _, m := range matches {
+		rest.WriteString(pattern[last:m[0]])
+		last = m[1]
+
+		operator := pattern[m[6]:m[7]]
+		if operator != "=" {
+			return nil, fmt.Errorf("unsupported pattern operator %q", operator)
+		}
+		comparisons = append(comparisons, Comparison{
+			ObjectType: pattern[m[2]:m[3]],
+			Path:       strings.ReplaceAll(pattern[m[4]:m[5]], "'", ""),
+			Value:      unescapeLiteral(pattern[m[8]:m[9]]),
+		})
+	}
+	rest.WriteString(pattern[last:])
+
+	// Anything left over besides brackets and OR means the indicator
+	// describes a combination of observables rather than a single value
+	if connectiveRegex.MatchString(rest.String()) {
+		return nil, fmt.Errorf("compound pattern is not supported")
+	}
+	return comparisons, nil
+}
+
+// FormatComparison renders a single comparison as a STIX pattern
+func FormatComparison(objectType, path, value string) string {
+	return fmt.Sprintf("[%s:%s = '%s']", objectType, path, escapeLiteral(value))
+}
+
+func unescapeLiteral(s string) string {
+	s = strings.ReplaceAll(s, `\'`, `'`)
+	return strings.ReplaceAll(s, `\\`, `\`)
+}
+
+func escapeLiteral(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, `'`, `\'`)
+}
@@ -0,0 +1,264 @@
+package stix
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SpecVersion is the STIX specification version produced and accepted by this package
+const SpecVersion = "2.1"
+
+// Object holds the common properties shared by all STIX domain objects
+type Object struct {
+	Type        string    `json:"type"`
+	SpecVersion string    `json:"spec_version,omitempty"`
+	ID          string    `json:"id"`
+	Created     time.Time `json:"created"`
+	Modified    time.Time `json:"modified"`
+	Labels      []string  `json:"labels,omitempty"`
+	Confidence  *int      `json:"confidence,omitempty"`
+	Revoked     bool      `json:"revoked,omitempty"`
+}
+
+// Indicator represents a STIX 2.1 indicator object
+type Indicator struct {
+	Object
+	Name           string     `json:"name,omitempty"`
+	Description    string     `json:"description,omitempty"`
+	IndicatorTypes []string   `json:"indicator_types,omitempty"`
+	Pattern        string     `json:"pattern"`
+	PatternType    string     `json:"pattern_type"`
+	ValidFrom      time.Time  `json:"valid_from"`
+	ValidUntil     *time.Time `json:"valid_until,omitempty"`
+}
+
+// Sighting represents a STIX 2.1 sighting: an indicator observed
+// WhereSightedRefs, Count times between FirstSeen and LastSeen
+type Sighting struct {
+	Object
+	Description      string     `json:"description,omitempty"`
+	FirstSeen        *time.Time `json:"first_seen,omitempty"`
+	LastSeen         *time.Time `json:"last_seen,omitempty"`
+	Count            int        `json:"count,omitempty"`
+	SightingOfRef    string     `json:"sighting_of_ref"`
+	WhereSightedRefs []string   `json:"where_sighted_refs,omitempty"`
+}
+
+// ExternalReference points to information outside STIX, such as a MITRE
+// ATT&CK entry
+type ExternalReference struct {
+	SourceName  string `json:"source_name"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+	ExternalID  string `json:"external_id,omitempty"`
+}
+
+// IntrusionSet represents a STIX 2.1 intrusion set: a grouping of
+// adversarial behaviour believed to be run by one organization
+type IntrusionSet struct {
+	Object
+	Name               string              `json:"name"`
+	Description        string              `json:"description,omitempty"`
+	Aliases            []string            `json:"aliases,omitempty"`
+	FirstSeen          *time.Time          `json:"first_seen,omitempty"`
+	LastSeen           *time.Time          `json:"last_seen,omitempty"`
+	Goals              []string            `json:"goals,omitempty"`
+	ResourceLevel      string              `json:"resource_level,omitempty"`
+	PrimaryMotivation  string              `json:"primary_motivation,omitempty"`
+	ExternalReferences []ExternalReference `json:"external_references,omitempty"`
+}
+
+// ThreatActor represents a STIX 2.1 threat actor
+type ThreatActor struct {
+	Object
+	Name               string              `json:"name"`
+	Description        string              `json:"description,omitempty"`
+	ThreatActorTypes   []string            `json:"threat_actor_types,omitempty"`
+	Aliases            []string            `json:"aliases,omitempty"`
+	FirstSeen          *time.Time          `json:"first_seen,omitempty"`
+	LastSeen           *time.Time          `json:"last_seen,omitempty"`
+	Goals              []string            `json:"goals,omitempty"`
+	Sophistication     string              `json:"sophistication,omitempty"`
+	ResourceLevel      string              `json:"resource_level,omitempty"`
+	PrimaryMotivation  string              `json:"primary_motivation,omitempty"`
+	ExternalReferences []ExternalReference `json:"external_references,omitempty"`
+}
+
+// Campaign represents a STIX 2.1 campaign
+type Campaign struct {
+	Object
+	Name               string              `json:"name"`
+	Description        string              `json:"description,omitempty"`
+	Aliases            []string            `json:"aliases,omitempty"`
+	FirstSeen          *time.Time          `json:"first_seen,omitempty"`
+	LastSeen           *time.Time          `json:"last_seen,omitempty"`
+	Objective          string              `json:"objective,omitempty"`
+	ExternalReferences []ExternalReference `json:"external_references,omitempty"`
+}
+
+// Malware represents a STIX 2.1 malware object, either a family or an
+// instance
+type Malware struct {
+	Object
+	Name               string              `json:"name,omitempty"`
+	Description        string              `json:"description,omitempty"`
+	MalwareTypes       []string            `json:"malware_types,omitempty"`
+	IsFamily           bool                `json:"is_family"`
+	Aliases            []string            `json:"aliases,omitempty"`
+	FirstSeen          *time.Time          `json:"first_seen,omitempty"`
+	LastSeen           *time.Time          `json:"last_seen,omitempty"`
+	ExternalReferences []ExternalReference `json:"external_references,omitempty"`
+}
+
+// Relationship represents a STIX 2.1 relationship between two objects,
+// such as an indicator that "indicates" a malware family
+type Relationship struct {
+	Object
+	RelationshipType string `json:"relationship_type"`
+	Description      string `json:"description,omitempty"`
+	SourceRef        string `json:"source_ref"`
+	TargetRef        string `json:"target_ref"`
+}
+
+// Bundle is a collection of arbitrary STIX objects
+type Bundle struct {
+	Type    string            `json:"type"`
+	ID      string            `json:"id"`
+	Objects []json.RawMessage `json:"objects"`
+}
+
+// NewID returns a random identifier for an object of the given type
+func NewID(objectType string) string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%s--%x-%x-%x-%x-%x", objectType, b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// ObjectType returns the "type" property of a raw STIX object
+func ObjectType(raw json.RawMessage) (string, error) {
+	var header struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return "", err
+	}
+	if header.Type == "" {
+		return "", fmt.Errorf("stix object has no type")
+	}
+	return header.Type, nil
+}
+
+// ParseBundle decodes a STIX bundle document
+func ParseBundle(data []byte) (*Bundle, error) {
+	var bundle Bundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid stix bundle: %v", err)
+	}
+	if bundle.Type != "bundle" {
+		return nil, fmt.Errorf("expected stix bundle, got type %q", bundle.Type)
+	}
+	return &bundle, nil
+}
+
+// Indicators returns every indicator object contained in the bundle
+func (b *Bundle) Indicators() ([]Indicator, error) {
+	var indicators []Indicator
+	for _, raw := range b.Objects {
+		objectType, err := ObjectType(raw)
+		if err != nil || objectType != "indicator" {
+			continue
+		}
+		var indicator Indicator
+		if err := json.Unmarshal(raw, &indicator); err != nil {
+			return nil, fmt.Errorf("invalid indicator object: %v", err)
+		}
+		indicators = append(indicators, indicator)
+	}
+	return indicators, nil
+}
+
+// BundleReader streams the objects of a bundle without holding the whole
+// document in memory
+type BundleReader struct {
+	dec     *json.Decoder
+	started bool
+	done    bool
+}
+
+// NewBundleReader creates a reader over a bundle document
+func NewBundleReader(r io.Reader) *BundleReader {
+	return &BundleReader{dec: json.NewDecoder(r)}
+}
+
+// Next returns the next object of the bundle, or io.EOF after the last one
+func (b *BundleReader) Next() (json.RawMessage, error) {
+	if b.done {
+		return nil, io.EOF
+	}
+	if !b.started {
+		if err := b.seekObjects(); err != nil {
+			return nil, err
+		}
+		b.started = true
+	}
+	if !b.dec.More() {
+		b.done = true
+		return nil, io.EOF
+	}
+	var raw json.RawMessage
+	if err := b.dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid stix bundle: %v", err)
+	}
+	return raw, nil
+}
+
+// seekObjects advances the decoder to the first element of the objects array
+func (b *BundleReader) seekObjects() error {
+	if err := b.expectDelim('{'); err != nil {
+		return err
+	}
+	for b.dec.More() {
+		tok, err := b.dec.Token()
+		if err != nil {
+			return fmt.Errorf("invalid stix bundle: %v", err)
+		}
+		key, _ := tok.(string)
+		switch key {
+		case "objects":
+			return b.expectDelim('[')
+		case "type":
+			var value string
+			if err := b.dec.Decode(&value); err != nil {
+				return fmt.Errorf("invalid stix bundle: %v", err)
+			}
+			if value != "bundle" {
+				return fmt.Errorf("expected stix bundle, got type %q", value)
+			}
+		default:
+			var skip json.RawMessage
+			if err := b.dec.Decode(&skip); err != nil {
+				return fmt.Errorf("invalid stix bundle: %v", err)
+			}
+		}
+	}
+	b.done = true
+	return nil
+}
+
+func (b *BundleReader) expectDelim(want json.Delim) error {
+	tok, err := b.dec.Token()
+	if err != nil {
+		return fmt.Errorf("invalid stix bundle: %v", err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("invalid stix bundle: expected %q", want)
+	}
+	return nil
+}
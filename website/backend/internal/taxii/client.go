@@ -0,0 +1,149 @@
+package taxii
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client is a minimal TAXII 2.1 consumer
+type Client struct {
+	HTTPClient *http.Client
+	Username   string
+	Password   string
+	APIKey     string
+	// APIKeyHeader defaults to "Authorization" when an API key is set
+	APIKeyHeader string
+}
+
+// NewClient creates a TAXII client with a default HTTP timeout
+func NewClient() *Client {
+	return &Client{
+		HTTPClient: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// Page is a single page of collection objects
+type Page struct {
+	Envelope
+	// DateAddedLast is the added date of the newest object in the page,
+	// used as the added_after cursor for the next incremental sync
+	DateAddedLast time.Time
+}
+
+// ObjectsQuery filters a collection objects request
+type ObjectsQuery struct {
+	AddedAfter time.Time
+	Limit      int
+	Next       string
+	Types      []string
+}
+
+// Discover fetches the discovery resource of a TAXII server
+func (c *Client) Discover(ctx context.Context, discoveryURL string) (*Discovery, error) {
+	var discovery Discovery
+	if _, err := c.get(ctx, discoveryURL, &discovery); err != nil {
+		return nil, err
+	}
+	return &discovery, nil
+}
+
+// Collections lists the collections of an API root
+func (c *Client) Collections(ctx context.Context, apiRoot string) ([]Collection, error) {
+	var collections Collections
+	if _, err := c.get(ctx, joinURL(apiRoot, "collections/"), &collections); err != nil {
+		return nil, err
+	}
+	return collections.Collections, nil
+}
+
+// Objects fetches a page of objects from a collection
+func (c *Client) Objects(ctx context.Context, apiRoot, collectionID string, query ObjectsQuery) (*Page, error) {
+	params := url.Values{}
+	if !query.AddedAfter.IsZero() {
+		params.Set("added_after", query.AddedAfter.UTC().Format(time.RFC3339Nano))
+	}
+	if query.Limit > 0 {
+		params.Set("limit", strconv.Itoa(query.Limit))
+	}
+	if query.Next != "" {
+		params.Set("next", query.Next)
+	}
+	if len(query.Types) > 0 {
+		params.Set("match[type]", strings.Join(query.Types, ","))
+	}
+
+	endpoint := joinURL(apiRoot, "collections/"+url.PathEscape(collectionID)+"/objects/")
+	if encoded := params.Encode(); encoded != "" {
+		endpoint += "?" + encoded
+	}
+
+	var page Page
+	header, err := c.get(ctx, endpoint, &page.Envelope)
+	if err != nil {
+		return nil, err
+	}
+	if last := header.Get(DateAddedLastHeader); last != "" {
+		if t, err := time.Parse(time.RFC3339Nano, last); err == nil {
+			page.DateAddedLast = t
+		}
+	}
+	return &page, nil
+}
+
+func (c *Client) get(ctx context.Context, endpoint string, out interface{}) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", MediaType)
+	c.authorize(req)
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("taxii request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var taxiiErr Error
+		if json.Unmarshal(body, &taxiiErr) == nil && taxiiErr.Title != "" {
+			return nil, fmt.Errorf("taxii server returned %d: %s", resp.StatusCode, taxiiErr.Title)
+		}
+		return nil, fmt.Errorf("taxii server returned %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return nil, fmt.Errorf("invalid taxii response: %v", err)
+	}
+	return resp.Header, nil
+}
+
+func (c *Client) authorize(req *http.Request) {
+	if c.APIKey != "" {
+		header := c.APIKeyHeader
+		if header == "" {
+			header = "Authorization"
+		}
+		req.Header.Set(header, c.APIKey)
+		return
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+func joinURL(base, path string) string {
+	return strings.TrimRight(base, "/") + "/" + path
+}
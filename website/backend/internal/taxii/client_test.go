@@ -0,0 +1,170 @@
+package taxii_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/taxii"
+	"github.com/ClarityXDR/prod/website/backend/internal/taxii/taxiitest"
+)
+
+func indicators(n int) []json.RawMessage {
+	objects := make([]json.RawMessage, n)
+	for i := range objects {
+		objects[i] = json.RawMessage(fmt.Sprintf(`{"type":"indicator","id":"indicator--%d"}`, i))
+	}
+	return objects
+}
+
+func objectIDs(t *testing.T, objects []json.RawMessage) []string {
+	t.Helper()
+	var ids []string
+	for _, raw := range objects {
+		var object struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(raw, &object); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, object.ID)
+	}
+	return ids
+}
+
+func TestClientDiscoversCollections(t *testing.T) {
+	server := taxiitest.NewServer()
+	defer server.Close()
+	server.AddObjects("feed", time.Now(), indicators(1)...)
+
+	client := taxii.NewClient()
+	ctx := context.Background()
+	discovery, err := client.Discover(ctx, server.URL+"/taxii2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if discovery.Default != server.APIRoot() || len(discovery.APIRoots) != 1 {
+		t.Fatalf("discovery = %+v, want the API root %s", discovery, server.APIRoot())
+	}
+
+	collections, err := client.Collections(ctx, discovery.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collections) != 1 || collections[0].ID != "feed" || !collections[0].CanRead {
+		t.Fatalf("collections = %+v, want the readable collection feed", collections)
+	}
+}
+
+func TestClientFollowsNext(t *testing.T) {
+	server := taxiitest.NewServer()
+	defer server.Close()
+	server.PageSize = 2
+	added := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	server.AddObjects("feed", added, indicators(5)...)
+
+	client := taxii.NewClient()
+	var ids []string
+	var last time.Time
+	query := taxii.ObjectsQuery{}
+	for {
+		page, err := client.Objects(context.Background(), server.APIRoot(), "feed", query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, objectIDs(t, page.Objects)...)
+		last = page.DateAddedLast
+		if !page.More {
+			break
+		}
+		if page.Next == "" {
+			t.Fatal("page has more objects but no next")
+		}
+		query.Next = page.Next
+	}
+
+	want := "indicator--0 indicator--1 indicator--2 indicator--3 indicator--4"
+	if got := strings.Join(ids, " "); got != want {
+		t.Errorf("objects = %s, want %s", got, want)
+	}
+	if server.Requests != 3 {
+		t.Errorf("requests = %d, want 3 pages of 2", server.Requests)
+	}
+	if want := added.Add(4 * time.Millisecond); !last.Equal(want) {
+		t.Errorf("last page added up to %s, want %s", last, want)
+	}
+}
+
+func TestClientAddedAfter(t *testing.T) {
+	server := taxiitest.NewServer()
+	defer server.Close()
+	added := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	server.AddObjects("feed", added, indicators(3)...)
+
+	client := taxii.NewClient()
+	page, err := client.Objects(context.Background(), server.APIRoot(), "feed", taxii.ObjectsQuery{
+		AddedAfter: added.Add(time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(objectIDs(t, page.Objects), " "); got != "indicator--2" {
+		t.Errorf("objects added after the second = %s, want indicator--2", got)
+	}
+
+	// Resuming from the last date added returns nothing new
+	page, err = client.Objects(context.Background(), server.APIRoot(), "feed", taxii.ObjectsQuery{
+		AddedAfter: page.DateAddedLast,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Objects) != 0 || page.More {
+		t.Errorf("resumed page = %d objects, more %v; want none", len(page.Objects), page.More)
+	}
+}
+
+func TestClientAuthentication(t *testing.T) {
+	server := taxiitest.NewServer()
+	defer server.Close()
+	server.Username, server.Password = "consumer", "secret"
+
+	tests := []struct {
+		name               string
+		username, password string
+		wantErr            bool
+	}{
+		{"no credentials", "", "", true},
+		{"wrong password", "consumer", "guess", true},
+		{"valid", "consumer", "secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := taxii.NewClient()
+			client.Username, client.Password = tt.username, tt.password
+			_, err := client.Discover(context.Background(), server.URL+"/taxii2/")
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "401") {
+					t.Fatalf("err = %v, want a 401", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestClientUnknownCollection(t *testing.T) {
+	server := taxiitest.NewServer()
+	defer server.Close()
+
+	_, err := taxii.NewClient().Objects(context.Background(), server.APIRoot(), "missing", taxii.ObjectsQuery{})
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("err = %v, want a 404", err)
+	}
+}
@@ -0,0 +1,325 @@
+package taxii
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ErrNotFound is returned by a Backend for unknown or inaccessible collections
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalidNext is returned by a Backend for a next parameter it did not issue
+var ErrInvalidNext = errors.New("invalid next parameter")
+
+// Principal is an authenticated TAXII consumer
+type Principal struct {
+	CredentialID string
+	// ClientID limits the principal to its own client collection; empty
+	// principals can only read the global collection
+	ClientID string
+}
+
+// Authenticator validates TAXII credentials
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (*Principal, error)
+}
+
+// StoredObject is a STIX object together with its TAXII metadata
+type StoredObject struct {
+	ID        string
+	DateAdded time.Time
+	Version   time.Time
+	Object    json.RawMessage
+}
+
+// ObjectFilter holds the supported objects/manifest query parameters
+type ObjectFilter struct {
+	AddedAfter time.Time
+	Limit      int
+	Next       string
+	Types      []string
+	IDs        []string
+}
+
+// ObjectPage is a page of stored objects
+type ObjectPage struct {
+	Objects []StoredObject
+	More    bool
+	Next    string
+}
+
+// Backend provides the collections and objects served by the server
+type Backend interface {
+	Collections(ctx context.Context, principal *Principal) ([]Collection, error)
+	Objects(ctx context.Context, principal *Principal, collectionID string, filter ObjectFilter) (*ObjectPage, error)
+}
+
+// ManifestRecord describes an object without its content
+type ManifestRecord struct {
+	ID        string    `json:"id"`
+	DateAdded time.Time `json:"date_added"`
+	Version   time.Time `json:"version"`
+	MediaType string    `json:"media_type"`
+}
+
+// Manifest is the response of the manifest endpoint
+type Manifest struct {
+	More    bool             `json:"more"`
+	Next    string           `json:"next,omitempty"`
+	Objects []ManifestRecord `json:"objects,omitempty"`
+}
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// Server is a read-only TAXII 2.1 server with a single API root
+type Server struct {
+	Title   string
+	APIRoot string
+	backend Backend
+	auth    Authenticator
+}
+
+// NewServer creates a TAXII server exposing the "clarityxdr" API root
+func NewServer(backend Backend, auth Authenticator) *Server {
+	return &Server{
+		Title:   "ClarityXDR Threat Intelligence",
+		APIRoot: "clarityxdr",
+		backend: backend,
+		auth:    auth,
+	}
+}
+
+// RegisterRoutes mounts the TAXII endpoints under /taxii2/
+func (s *Server) RegisterRoutes(r *mux.Router) {
+	t := r.PathPrefix("/taxii2").Subrouter()
+	t.HandleFunc("/", s.authenticated(s.discovery)).Methods("GET")
+	t.HandleFunc("/{root}/", s.authenticated(s.apiRootInfo)).Methods("GET")
+	t.HandleFunc("/{root}/collections/", s.authenticated(s.collections)).Methods("GET")
+	t.HandleFunc("/{root}/collections/{id}/", s.authenticated(s.collection)).Methods("GET")
+	t.HandleFunc("/{root}/collections/{id}/objects/", s.authenticated(s.objects)).Methods("GET")
+	t.HandleFunc("/{root}/collections/{id}/objects/{objectId}/", s.authenticated(s.object)).Methods("GET")
+	t.HandleFunc("/{root}/collections/{id}/manifest/", s.authenticated(s.manifest)).Methods("GET")
+}
+
+type principalHandler func(w http.ResponseWriter, r *http.Request, principal *Principal)
+
+func (s *Server) authenticated(next principalHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsTAXII(r.Header.Get("Accept")) {
+			writeError(w, http.StatusNotAcceptable, "The requested media type is not supported")
+			return
+		}
+
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="TAXII"`)
+			writeError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		principal, err := s.auth.Authenticate(r.Context(), username, password)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="TAXII"`)
+			writeError(w, http.StatusUnauthorized, "Invalid credentials")
+			return
+		}
+
+		if root, ok := mux.Vars(r)["root"]; ok && root != s.APIRoot {
+			writeError(w, http.StatusNotFound, "API root not found")
+			return
+		}
+		next(w, r, principal)
+	}
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request, _ *Principal) {
+	root := "/taxii2/" + s.APIRoot + "/"
+	writeTAXII(w, http.StatusOK, Discovery{
+		Title:    s.Title,
+		Default:  root,
+		APIRoots: []string{root},
+	})
+}
+
+func (s *Server) apiRootInfo(w http.ResponseWriter, r *http.Request, _ *Principal) {
+	writeTAXII(w, http.StatusOK, APIRoot{
+		Title:            s.Title,
+		Versions:         []string{MediaType},
+		MaxContentLength: 10485760,
+	})
+}
+
+func (s *Server) collections(w http.ResponseWriter, r *http.Request, principal *Principal) {
+	collections, err := s.backend.Collections(r.Context(), principal)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list collections")
+		return
+	}
+	writeTAXII(w, http.StatusOK, Collections{Collections: collections})
+}
+
+func (s *Server) collection(w http.ResponseWriter, r *http.Request, principal *Principal) {
+	collections, err := s.backend.Collections(r.Context(), principal)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list collections")
+		return
+	}
+	id := mux.Vars(r)["id"]
+	for _, c := range collections {
+		if c.ID == id {
+			writeTAXII(w, http.StatusOK, c)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "Collection not found")
+}
+
+func (s *Server) objects(w http.ResponseWriter, r *http.Request, principal *Principal) {
+	page, ok := s.queryObjects(w, r, principal, ObjectFilter{})
+	if !ok {
+		return
+	}
+	envelope := Envelope{More: page.More, Next: page.Next}
+	for _, o := range page.Objects {
+		envelope.Objects = append(envelope.Objects, o.Object)
+	}
+	setDateAddedHeaders(w, page.Objects)
+	writeTAXII(w, http.StatusOK, envelope)
+}
+
+func (s *Server) object(w http.ResponseWriter, r *http.Request, principal *Principal) {
+	page, ok := s.queryObjects(w, r, principal, ObjectFilter{IDs: []string{mux.Vars(r)["objectId"]}})
+	if !ok {
+		return
+	}
+	if len(page.Objects) == 0 {
+		writeError(w, http.StatusNotFound, "Object not found")
+		return
+	}
+	envelope := Envelope{More: page.More, Next: page.Next}
+	for _, o := range page.Objects {
+		envelope.Objects = append(envelope.Objects, o.Object)
+	}
+	setDateAddedHeaders(w, page.Objects)
+	writeTAXII(w, http.StatusOK, envelope)
+}
+
+func (s *Server) manifest(w http.ResponseWriter, r *http.Request, principal *Principal) {
+	page, ok := s.queryObjects(w, r, principal, ObjectFilter{})
+	if !ok {
+		return
+	}
+	manifest := Manifest{More: page.More, Next: page.Next}
+	for _, o := range page.Objects {
+		manifest.Objects = append(manifest.Objects, ManifestRecord{
+			ID:        o.ID,
+			DateAdded: o.DateAdded,
+			Version:   o.Version,
+			MediaType: STIXMediaType,
+		})
+	}
+	setDateAddedHeaders(w, page.Objects)
+	writeTAXII(w, http.StatusOK, manifest)
+}
+
+// queryObjects parses the request filters and fetches a page of objects,
+// writing an error response and returning false on failure
+func (s *Server) queryObjects(w http.ResponseWriter, r *http.Request, principal *Principal, filter ObjectFilter) (*ObjectPage, bool) {
+	query := r.URL.Query()
+
+	if v := query.Get("added_after"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid added_after timestamp")
+			return nil, false
+		}
+		filter.AddedAfter = t
+	}
+
+	filter.Limit = defaultPageSize
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return nil, false
+		}
+		filter.Limit = limit
+	}
+	if filter.Limit > maxPageSize {
+		filter.Limit = maxPageSize
+	}
+
+	filter.Next = query.Get("next")
+	if v := query.Get("match[type]"); v != "" {
+		filter.Types = strings.Split(v, ",")
+	}
+	if v := query.Get("match[id]"); v != "" {
+		filter.IDs = append(filter.IDs, strings.Split(v, ",")...)
+	}
+
+	page, err := s.backend.Objects(r.Context(), principal, mux.Vars(r)["id"], filter)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "Collection not found")
+		return nil, false
+	case errors.Is(err, ErrInvalidNext):
+		writeError(w, http.StatusBadRequest, "Invalid next parameter")
+		return nil, false
+	case err != nil:
+		log.Printf("TAXII: listing objects failed: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to list objects")
+		return nil, false
+	}
+	return page, true
+}
+
+func setDateAddedHeaders(w http.ResponseWriter, objects []StoredObject) {
+	if len(objects) == 0 {
+		return
+	}
+	first, last := objects[0].DateAdded, objects[0].DateAdded
+	for _, o := range objects[1:] {
+		if o.DateAdded.Before(first) {
+			first = o.DateAdded
+		}
+		if o.DateAdded.After(last) {
+			last = o.DateAdded
+		}
+	}
+	w.Header().Set(DateAddedFirstHeader, first.UTC().Format(time.RFC3339Nano))
+	w.Header().Set(DateAddedLastHeader, last.UTC().Format(time.RFC3339Nano))
+}
+
+func acceptsTAXII(accept string) bool {
+	if accept == "" {
+		return true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		switch mediaType {
+		case "application/taxii+json", "application/json", "*/*", "application/*":
+			return true
+		}
+	}
+	return false
+}
+
+func writeTAXII(w http.ResponseWriter, code int, payload interface{}) {
+	w.Header().Set("Content-Type", MediaType)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func writeError(w http.ResponseWriter, code int, title string) {
+	writeTAXII(w, code, Error{Title: title, HTTPStatus: strconv.Itoa(code)})
+}
@@ -0,0 +1,73 @@
+package taxii
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+type staticAuth struct{}
+
+func (staticAuth) Authenticate(ctx context.Context, username, password string) (*Principal, error) {
+	return &Principal{CredentialID: username}, nil
+}
+
+// errorBackend fails every objects query with err
+type errorBackend struct{ err error }
+
+func (b errorBackend) Collections(ctx context.Context, principal *Principal) ([]Collection, error) {
+	return nil, nil
+}
+
+func (b errorBackend) Objects(ctx context.Context, principal *Principal, collectionID string, filter ObjectFilter) (*ObjectPage, error) {
+	return nil, b.err
+}
+
+func TestServerObjectErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantTitle  string
+	}{
+		{"unknown collection", ErrNotFound, http.StatusNotFound, "Collection not found"},
+		{"invalid next", fmt.Errorf("decoding cursor: %w", ErrInvalidNext), http.StatusBadRequest, "Invalid next parameter"},
+		{"backend failure", errors.New("pq: connection refused"), http.StatusInternalServerError, "Failed to list objects"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mux.NewRouter()
+			NewServer(errorBackend{tt.err}, staticAuth{}).RegisterRoutes(r)
+
+			req := httptest.NewRequest("GET", "/taxii2/clarityxdr/collections/global/objects/?next=abc", nil)
+			req.SetBasicAuth("consumer", "secret")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			body := w.Body.String()
+			if !strings.Contains(body, tt.wantTitle) {
+				t.Errorf("body = %s, want the title %q", body, tt.wantTitle)
+			}
+			if strings.Contains(body, "pq:") {
+				t.Errorf("body = %s leaks the backend error", body)
+			}
+		})
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	for _, next := range []string{"%%%", "bm8tc2VwYXJhdG9y", "bm90LWEtdGltZXxpZA"} {
+		if _, _, err := decodeCursor(next); !errors.Is(err, ErrInvalidNext) {
+			t.Errorf("decodeCursor(%q) = %v, want ErrInvalidNext", next, err)
+		}
+	}
+}
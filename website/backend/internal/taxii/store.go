@@ -0,0 +1,299 @@
+package taxii
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GlobalCollectionID identifies the collection of indicators shared with every client
+const GlobalCollectionID = "5c0a1f2e-8d7b-4c3a-9e6f-1b2d3c4e5f60"
+
+// IndicatorStore serves deployment_mgmt.threat_indicators as STIX indicators.
+// Clients read the global collection plus a collection of their own indicators.
+type IndicatorStore struct {
+	db         *sql.DB
+	indicators *indicator.Store
+}
+
+// NewIndicatorStore creates a TAXII backend over the indicators table. The
+// indicator store provides the allow-list.
+func NewIndicatorStore(db *sql.DB, indicators *indicator.Store) *IndicatorStore {
+	return &IndicatorStore{db: db, indicators: indicators}
+}
+
+// Collections implements Backend
+func (s *IndicatorStore) Collections(ctx context.Context, principal *Principal) ([]Collection, error) {
+	collections := []Collection{{
+		ID:          GlobalCollectionID,
+		Title:       "ClarityXDR Global Indicators",
+		Description: "Curated indicators shared with all clients",
+		CanRead:     true,
+		MediaTypes:  []string{STIXMediaType},
+	}}
+	if principal.ClientID == "" {
+		return collections, nil
+	}
+
+	var name string
+	err := s.db.QueryRowContext(ctx, `SELECT name FROM client_mgmt.clients WHERE id = $1`,
+		principal.ClientID).Scan(&name)
+	if err != nil {
+		return nil, err
+	}
+	return append(collections, Collection{
+		ID:          principal.ClientID,
+		Title:       name + " Indicators",
+		Description: "Indicators curated for " + name,
+		CanRead:     true,
+		MediaTypes:  []string{STIXMediaType},
+	}), nil
+}
+
+// Objects implements Backend. Objects are ordered by the time the indicator
+// was last changed so consumers polling with added_after receive updates and
+// revocations as new versions. Global indicators a client's own allow-list
+// covers are served to that client as revoked, as the distributor withholds
+// them from its platforms.
+func (s *IndicatorStore) Objects(ctx context.Context, principal *Principal, collectionID string, filter ObjectFilter) (*ObjectPage, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	switch {
+	case collectionID == GlobalCollectionID:
+		conditions = append(conditions, "client_id IS NULL")
+	case principal.ClientID != "" && collectionID == principal.ClientID:
+		conditions = append(conditions, "client_id = "+arg(principal.ClientID))
+	default:
+		return nil, ErrNotFound
+	}
+
+	if len(filter.Types) > 0 && !containsString(filter.Types, "indicator") {
+		return &ObjectPage{}, nil
+	}
+	if len(filter.IDs) > 0 {
+		var ids []string
+		for _, id := range filter.IDs {
+			ids = append(ids, strings.TrimPrefix(id, "indicator--"))
+		}
+		conditions = append(conditions, "id::text = ANY("+arg(pq.Array(ids))+")")
+	}
+	if !filter.AddedAfter.IsZero() {
+		conditions = append(conditions, "updated_at > "+arg(filter.AddedAfter))
+	}
+	if filter.Next != "" {
+		after, afterID, err := decodeCursor(filter.Next)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, fmt.Sprintf("(updated_at, id) > (%s, %s)", arg(after), arg(afterID)))
+	}
+
+	var allow *indicator.AllowList
+	if collectionID == GlobalCollectionID && principal.ClientID != "" {
+		var err error
+		if allow, err = s.indicators.AllowList(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	query := `
+		SELECT id, indicator_type, indicator_value, threat_type, confidence,
+			COALESCE(description, ''), tags, expiration_date, is_active, created_at, updated_at
+		FROM deployment_mgmt.threat_indicators
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY updated_at, id
+		LIMIT ` + arg(limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &ObjectPage{}
+	scanned := 0
+	var lastAdded time.Time
+	var lastID string
+	for rows.Next() {
+		var ind indicator.Indicator
+		var threatType, indicatorType string
+		var confidence sql.NullInt64
+		var expiration sql.NullTime
+
+		if err := rows.Scan(&ind.ID, &indicatorType, &ind.Value, &threatType, &confidence,
+			&ind.Description, pq.Array(&ind.Tags), &expiration, &ind.IsActive,
+			&ind.CreatedAt, &ind.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if scanned == limit {
+			page.More = true
+			break
+		}
+		scanned++
+		lastAdded, lastID = ind.UpdatedAt, ind.ID
+
+		ind.Type = indicator.Type(indicatorType)
+		ind.ThreatType = threatType
+		ind.Confidence = int(confidence.Int64)
+		if expiration.Valid {
+			ind.ExpiresAt = &expiration.Time
+		}
+		if allow != nil {
+			if _, ok := allow.MatchClient(principal.ClientID, &ind); ok {
+				ind.IsActive = false
+			}
+		}
+		object, err := indicator.ToSTIX(&ind)
+		if err != nil {
+			log.Printf("TAXII: skipping indicator %s: %v", ind.ID, err)
+			continue
+		}
+
+		raw, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		page.Objects = append(page.Objects, StoredObject{
+			ID:        object.ID,
+			DateAdded: ind.UpdatedAt.UTC(),
+			Version:   ind.UpdatedAt.UTC(),
+			Object:    raw,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if page.More {
+		page.Next = encodeCursor(lastAdded, lastID)
+	}
+	return page, nil
+}
+
+func encodeCursor(t time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(t.UTC().Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeCursor(next string) (time.Time, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(next)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidNext
+	}
+	parts := strings.SplitN(string(data), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", ErrInvalidNext
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", ErrInvalidNext
+	}
+	return t, parts[1], nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CredentialStore authenticates TAXII consumers against deployment_mgmt.taxii_credentials
+type CredentialStore struct {
+	db *sql.DB
+}
+
+// NewCredentialStore creates a credential store
+func NewCredentialStore(db *sql.DB) *CredentialStore {
+	return &CredentialStore{db: db}
+}
+
+// Authenticate implements Authenticator
+func (s *CredentialStore) Authenticate(ctx context.Context, username, password string) (*Principal, error) {
+	var principal Principal
+	var clientID sql.NullString
+	var passwordHash string
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, client_id, password_hash
+		FROM deployment_mgmt.taxii_credentials
+		WHERE username = $1 AND is_active = true
+	`, username).Scan(&principal.CredentialID, &clientID, &passwordHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+	principal.ClientID = clientID.String
+
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.taxii_credentials SET last_used_at = NOW() WHERE id = $1
+	`, principal.CredentialID); err != nil {
+		log.Printf("Error updating TAXII credential usage: %v", err)
+	}
+	return &principal, nil
+}
+
+// Create issues a new credential and returns its generated password,
+// which is only available at creation time
+func (s *CredentialStore) Create(ctx context.Context, clientID, username, description string) (string, string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(secret)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", err
+	}
+
+	var client interface{} = clientID
+	if clientID == "" {
+		client = nil
+	}
+	var id string
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO deployment_mgmt.taxii_credentials (client_id, username, password_hash, description)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, client, username, string(hash), description).Scan(&id)
+	if err != nil {
+		return "", "", err
+	}
+	return id, password, nil
+}
+
+// Revoke deactivates a credential
+func (s *CredentialStore) Revoke(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.taxii_credentials SET is_active = false WHERE id = $1
+	`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
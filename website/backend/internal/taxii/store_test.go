@@ -0,0 +1,77 @@
+package taxii
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// revoked returns the revoked flag of each object of a page by pattern
+func revoked(t *testing.T, page *ObjectPage) map[string]bool {
+	t.Helper()
+	got := make(map[string]bool)
+	for _, o := range page.Objects {
+		var object struct {
+			Pattern string `json:"pattern"`
+			Revoked bool   `json:"revoked"`
+		}
+		if err := json.Unmarshal(o.Object, &object); err != nil {
+			t.Fatal(err)
+		}
+		got[object.Pattern] = object.Revoked
+	}
+	return got
+}
+
+func TestIndicatorStoreClientAllowList(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	contoso := dbtest.CreateClient(t, db, "Contoso")
+	fabrikam := dbtest.CreateClient(t, db, "Fabrikam")
+	indicators := indicator.NewStore(db)
+	if _, err := indicators.Upsert(ctx, []indicator.Indicator{
+		{Type: indicator.TypeDomain, Value: "partner.org", ThreatType: "C2", Confidence: 80, Source: "feed"},
+		{Type: indicator.TypeDomain, Value: "evil.org", ThreatType: "C2", Confidence: 80, Source: "feed"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewIndicatorStore(db, indicators)
+	const partner, evil = "[domain-name:value = 'partner.org']", "[domain-name:value = 'evil.org']"
+
+	before, err := s.Objects(ctx, &Principal{ClientID: contoso}, GlobalCollectionID, ObjectFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	polled := time.Now()
+	if got := revoked(t, before); len(got) != 2 || got[partner] || got[evil] {
+		t.Fatalf("objects before the entry = %v, want both active", got)
+	}
+
+	if err := indicators.AddAllowEntry(ctx, &indicator.AllowEntry{
+		ClientID: contoso, Kind: indicator.AllowDomain, Value: "partner.org"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Contoso sees the indicator its allow-list covers revoked, as a new
+	// version, while it stays active for everyone else
+	after, err := s.Objects(ctx, &Principal{ClientID: contoso}, GlobalCollectionID, ObjectFilter{AddedAfter: polled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := revoked(t, after); len(got) != 1 || !got[partner] {
+		t.Errorf("Contoso objects added after the entry = %v, want partner.org revoked", got)
+	}
+	for _, principal := range []*Principal{{ClientID: fabrikam}, {}} {
+		page, err := s.Objects(ctx, principal, GlobalCollectionID, ObjectFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := revoked(t, page); len(got) != 2 || got[partner] || got[evil] {
+			t.Errorf("objects for %q = %v, want both active", principal.ClientID, got)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package taxii
+
+import (
+	"encoding/json"
+)
+
+// MediaType is the content type used by TAXII 2.1 endpoints
+const MediaType = "application/taxii+json;version=2.1"
+
+// STIXMediaType is the content type used for STIX content served over TAXII
+const STIXMediaType = "application/stix+json;version=2.1"
+
+// DateAddedFirstHeader and DateAddedLastHeader bound the objects of an envelope
+const (
+	DateAddedFirstHeader = "X-TAXII-Date-Added-First"
+	DateAddedLastHeader  = "X-TAXII-Date-Added-Last"
+)
+
+// Discovery is the response of the TAXII discovery endpoint
+type Discovery struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Contact     string   `json:"contact,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	APIRoots    []string `json:"api_roots"`
+}
+
+// APIRoot describes a TAXII API root
+type APIRoot struct {
+	Title            string   `json:"title"`
+	Description      string   `json:"description,omitempty"`
+	Versions         []string `json:"versions"`
+	MaxContentLength int      `json:"max_content_length"`
+}
+
+// Collection describes a TAXII collection
+type Collection struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	CanRead     bool     `json:"can_read"`
+	CanWrite    bool     `json:"can_write"`
+	MediaTypes  []string `json:"media_types,omitempty"`
+}
+
+// Collections is the response of the collections endpoint
+type Collections struct {
+	Collections []Collection `json:"collections"`
+}
+
+// Envelope wraps a page of STIX objects returned by the objects endpoint
+type Envelope struct {
+	More    bool              `json:"more"`
+	Next    string            `json:"next,omitempty"`
+	Objects []json.RawMessage `json:"objects,omitempty"`
+}
+
+// Error is the TAXII error message resource
+type Error struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	HTTPStatus  string `json:"http_status,omitempty"`
+}
@@ -0,0 +1,172 @@
+// Package taxiitest provides an in-process TAXII 2.1 server for exercising
+// feed ingestion without network access.
+package taxiitest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/taxii"
+)
+
+// Server is a stub TAXII server holding a single API root named "api"
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	collections map[string][]entry
+	// PageSize bounds the number of objects returned per request
+	PageSize int
+	// Requests counts the object requests served, for asserting pagination
+	Requests int
+	// Username and Password, when Username is set, are the basic auth
+	// credentials every request must carry
+	Username string
+	Password string
+}
+
+type entry struct {
+	added  time.Time
+	object json.RawMessage
+}
+
+// NewServer starts a stub server; call Close when done
+func NewServer() *Server {
+	s := &Server{
+		collections: make(map[string][]entry),
+		PageSize:    100,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// APIRoot returns the URL of the stub API root
+func (s *Server) APIRoot() string {
+	return s.URL + "/api/"
+}
+
+// AddObjects appends objects to a collection, creating it if needed
+func (s *Server) AddObjects(collectionID string, added time.Time, objects ...json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, object := range objects {
+		// Keep added dates strictly increasing so cursors are unambiguous
+		s.collections[collectionID] = append(s.collections[collectionID], entry{
+			added:  added.Add(time.Duration(i) * time.Millisecond),
+			object: object,
+		})
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Username != "" {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != s.Username || password != s.Password {
+			writeJSON(w, http.StatusUnauthorized, taxii.Error{Title: "Invalid credentials"}, nil)
+			return
+		}
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "taxii2":
+		writeJSON(w, http.StatusOK, taxii.Discovery{
+			Title:    "ClarityXDR stub TAXII server",
+			Default:  s.APIRoot(),
+			APIRoots: []string{s.APIRoot()},
+		}, nil)
+	case path == "api":
+		writeJSON(w, http.StatusOK, taxii.APIRoot{
+			Title:            "stub",
+			Versions:         []string{taxii.MediaType},
+			MaxContentLength: 10485760,
+		}, nil)
+	case path == "api/collections":
+		s.mu.Lock()
+		var collections taxii.Collections
+		for id := range s.collections {
+			collections.Collections = append(collections.Collections, taxii.Collection{
+				ID: id, Title: id, CanRead: true, MediaTypes: []string{taxii.STIXMediaType},
+			})
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, collections, nil)
+	case len(parts) == 4 && parts[0] == "api" && parts[1] == "collections" && parts[3] == "objects":
+		s.serveObjects(w, r, parts[2])
+	default:
+		writeJSON(w, http.StatusNotFound, taxii.Error{Title: "not found"}, nil)
+	}
+}
+
+func (s *Server) serveObjects(w http.ResponseWriter, r *http.Request, collectionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Requests++
+
+	entries, ok := s.collections[collectionID]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, taxii.Error{Title: "collection not found"}, nil)
+		return
+	}
+
+	query := r.URL.Query()
+	var addedAfter time.Time
+	if v := query.Get("added_after"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, taxii.Error{Title: "invalid added_after"}, nil)
+			return
+		}
+		addedAfter = t
+	}
+	limit := s.PageSize
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 && v < limit {
+		limit = v
+	}
+	offset, _ := strconv.Atoi(query.Get("next"))
+
+	var matched []entry
+	for _, e := range entries {
+		if e.added.After(addedAfter) {
+			matched = append(matched, e)
+		}
+	}
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+	end := offset + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	page := matched[offset:end]
+
+	envelope := taxii.Envelope{More: end < len(matched)}
+	if envelope.More {
+		envelope.Next = strconv.Itoa(end)
+	}
+	header := http.Header{}
+	for _, e := range page {
+		envelope.Objects = append(envelope.Objects, e.object)
+	}
+	if len(page) > 0 {
+		header.Set(taxii.DateAddedFirstHeader, page[0].added.UTC().Format(time.RFC3339Nano))
+		header.Set(taxii.DateAddedLastHeader, page[len(page)-1].added.UTC().Format(time.RFC3339Nano))
+	}
+	writeJSON(w, http.StatusOK, envelope, header)
+}
+
+func writeJSON(w http.ResponseWriter, code int, payload interface{}, header http.Header) {
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Type", taxii.MediaType)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(payload)
+}
@@ -0,0 +1,128 @@
+// Package actors tracks the adversaries behind indicators: threat actors,
+// intrusion sets, campaigns and malware families, modelled on the STIX 2.1
+// objects of the same names
+package actors
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/stix"
+)
+
+// Entity kinds, named after their STIX object types
+const (
+	KindThreatActor  = "threat-actor"
+	KindIntrusionSet = "intrusion-set"
+	KindCampaign     = "campaign"
+	KindMalware      = "malware"
+)
+
+// IsKind reports whether kind is one of the Kind constants
+func IsKind(kind string) bool {
+	switch kind {
+	case KindThreatActor, KindIntrusionSet, KindCampaign, KindMalware:
+		return true
+	}
+	return false
+}
+
+// Relationship types between entities and to indicators
+const (
+	RelIndicates    = "indicates"
+	RelAttributedTo = "attributed-to"
+	RelUses         = "uses"
+	RelVariantOf    = "variant-of"
+	RelRelatedTo    = "related-to"
+)
+
+// Entity is a threat actor, intrusion set, campaign or malware family
+type Entity struct {
+	ID          string   `json:"id,omitempty"`
+	Kind        string   `json:"kind"`
+	Name        string   `json:"name"`
+	Aliases     []string `json:"aliases"`
+	Description string   `json:"description,omitempty"`
+	// Types holds threat_actor_types for actors and malware_types for malware
+	Types []string `json:"types"`
+	// Motivation is the STIX primary_motivation of actors and intrusion sets
+	Motivation     string `json:"motivation,omitempty"`
+	Sophistication string `json:"sophistication,omitempty"`
+	// Objective is the STIX objective of a campaign
+	Objective string     `json:"objective,omitempty"`
+	FirstSeen *time.Time `json:"firstSeen,omitempty"`
+	LastSeen  *time.Time `json:"lastSeen,omitempty"`
+	// ExternalID is the STIX identifier the entity was imported from
+	ExternalID string                   `json:"externalId,omitempty"`
+	References []stix.ExternalReference `json:"references"`
+	CreatedAt  time.Time                `json:"createdAt,omitempty"`
+	UpdatedAt  time.Time                `json:"updatedAt,omitempty"`
+}
+
+// Validate checks an entity before it is stored and tidies its aliases
+func (e *Entity) Validate() error {
+	if !IsKind(e.Kind) {
+		return fmt.Errorf("unknown entity kind %q", e.Kind)
+	}
+	e.Name = strings.TrimSpace(e.Name)
+	if e.Name == "" {
+		return fmt.Errorf("entity name is required")
+	}
+
+	seen := map[string]bool{strings.ToLower(e.Name): true}
+	aliases := []string{}
+	for _, a := range e.Aliases {
+		a = strings.TrimSpace(a)
+		if a != "" && !seen[strings.ToLower(a)] {
+			seen[strings.ToLower(a)] = true
+			aliases = append(aliases, a)
+		}
+	}
+	e.Aliases = aliases
+	if e.Types == nil {
+		e.Types = []string{}
+	}
+	if e.References == nil {
+		e.References = []stix.ExternalReference{}
+	}
+	return nil
+}
+
+// STIXID returns the STIX identifier of the entity: the one it was
+// imported from, or one derived from its ID
+func (e *Entity) STIXID() string {
+	if e.ExternalID != "" {
+		return e.ExternalID
+	}
+	return e.Kind + "--" + e.ID
+}
+
+// STIX returns the entity as a STIX object
+func (e *Entity) STIX() interface{} {
+	object := stix.Object{
+		Type:        e.Kind,
+		SpecVersion: stix.SpecVersion,
+		ID:          e.STIXID(),
+		Created:     e.CreatedAt,
+		Modified:    e.UpdatedAt,
+	}
+	switch e.Kind {
+	case KindThreatActor:
+		return stix.ThreatActor{Object: object, Name: e.Name, Description: e.Description,
+			ThreatActorTypes: e.Types, Aliases: e.Aliases, FirstSeen: e.FirstSeen, LastSeen: e.LastSeen,
+			Sophistication: e.Sophistication, PrimaryMotivation: e.Motivation, ExternalReferences: e.References}
+	case KindIntrusionSet:
+		return stix.IntrusionSet{Object: object, Name: e.Name, Description: e.Description,
+			Aliases: e.Aliases, FirstSeen: e.FirstSeen, LastSeen: e.LastSeen,
+			PrimaryMotivation: e.Motivation, ExternalReferences: e.References}
+	case KindCampaign:
+		return stix.Campaign{Object: object, Name: e.Name, Description: e.Description,
+			Aliases: e.Aliases, FirstSeen: e.FirstSeen, LastSeen: e.LastSeen,
+			Objective: e.Objective, ExternalReferences: e.References}
+	default:
+		return stix.Malware{Object: object, Name: e.Name, Description: e.Description,
+			MalwareTypes: e.Types, IsFamily: true, Aliases: e.Aliases, FirstSeen: e.FirstSeen,
+			LastSeen: e.LastSeen, ExternalReferences: e.References}
+	}
+}
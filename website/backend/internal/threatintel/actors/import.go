@@ -0,0 +1,304 @@
+package actors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/stix"
+	"github.com/lib/pq"
+)
+
+const (
+	// ImportSource is the source of indicators imported with entities
+	ImportSource = "stix-import"
+	// maxImportErrors caps the errors reported by an import
+	maxImportErrors = 100
+)
+
+// ImportResult summarizes a bundle import
+type ImportResult struct {
+	Entities      int `json:"entities"`
+	Indicators    int `json:"indicators"`
+	Quarantined   int `json:"quarantined"`
+	Relationships int `json:"relationships"`
+	// Skipped counts objects of other types and relationships whose ends
+	// are neither entities nor indicators
+	Skipped int      `json:"skipped"`
+	Errors  []string `json:"errors"`
+}
+
+func (r *ImportResult) fail(format string, args ...interface{}) {
+	if len(r.Errors) < maxImportErrors {
+		r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+	}
+}
+
+// Importer loads entities, their indicators and the relationships between
+// them from STIX bundles
+type Importer struct {
+	store      *Store
+	indicators *indicator.Store
+	normalizer *indicator.Normalizer
+}
+
+// NewImporter creates an importer
+func NewImporter(store *Store, indicators *indicator.Store) *Importer {
+	return &Importer{store: store, indicators: indicators, normalizer: indicator.NewNormalizer()}
+}
+
+// Import reads a STIX bundle. Intrusion sets, threat actors, campaigns and
+// malware become entities, merged with those imported before by STIX
+// identifier or name. Indicator objects are stored as global indicators.
+// Relationships between entities are recorded, and relationships between
+// an indicator and an entity link the two; both ends may be in the bundle
+// or imported earlier.
+func (i *Importer) Import(ctx context.Context, r io.Reader) (*ImportResult, error) {
+	result := &ImportResult{Errors: []string{}}
+	entities := make(map[string]string)
+	var indicators []indicator.Indicator
+	var relationships []stix.Relationship
+
+	bundle := stix.NewBundleReader(r)
+	for {
+		raw, err := bundle.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		objectType, err := stix.ObjectType(raw)
+		if err != nil {
+			result.fail("%v", err)
+			continue
+		}
+		switch {
+		case IsKind(objectType):
+			e, err := entityFromSTIX(objectType, raw)
+			if err == nil {
+				err = i.store.Save(ctx, e)
+			}
+			if err != nil {
+				result.fail("%s: %v", objectType, err)
+				continue
+			}
+			entities[e.ExternalID] = e.ID
+			result.Entities++
+		case objectType == "indicator":
+			inds, err := i.indicatorsFromSTIX(raw)
+			if err != nil {
+				result.fail("indicator: %v", err)
+				continue
+			}
+			indicators = append(indicators, inds...)
+		case objectType == "relationship":
+			var rel stix.Relationship
+			if err := json.Unmarshal(raw, &rel); err != nil {
+				result.fail("malformed relationship: %v", err)
+				continue
+			}
+			if !rel.Revoked {
+				relationships = append(relationships, rel)
+			}
+		default:
+			result.Skipped++
+		}
+	}
+
+	if len(indicators) > 0 {
+		upserted, err := i.indicators.Upsert(ctx, indicators)
+		if err != nil {
+			return nil, err
+		}
+		result.Indicators = upserted.Inserted + upserted.Updated
+		result.Quarantined = upserted.Quarantined
+	}
+
+	for _, rel := range relationships {
+		linked, err := i.relate(ctx, rel, entities)
+		if err != nil {
+			result.fail("%s: %v", rel.ID, err)
+			continue
+		}
+		if linked {
+			result.Relationships++
+		} else {
+			result.Skipped++
+		}
+	}
+	return result, nil
+}
+
+// relate records a relationship, reporting false when its ends are not
+// both entities or an indicator and an entity
+func (i *Importer) relate(ctx context.Context, rel stix.Relationship, entities map[string]string) (bool, error) {
+	source, target := stixType(rel.SourceRef), stixType(rel.TargetRef)
+	switch {
+	case IsKind(source) && IsKind(target):
+		sourceID, err := i.entityID(ctx, rel.SourceRef, entities)
+		if err != nil {
+			return false, err
+		}
+		targetID, err := i.entityID(ctx, rel.TargetRef, entities)
+		if err != nil {
+			return false, err
+		}
+		return true, i.store.Link(ctx, sourceID, Links{
+			Entities: []EntityLink{{ID: targetID, Relationship: rel.RelationshipType}},
+		})
+	case source == "indicator" && IsKind(target), IsKind(source) && target == "indicator":
+		indicatorRef, entityRef := rel.SourceRef, rel.TargetRef
+		if source != "indicator" {
+			indicatorRef, entityRef = entityRef, indicatorRef
+		}
+		entityID, err := i.entityID(ctx, entityRef, entities)
+		if err != nil {
+			return false, err
+		}
+		ids, err := i.indicatorIDs(ctx, indicatorRef)
+		if err != nil {
+			return false, err
+		}
+		if len(ids) == 0 {
+			return false, fmt.Errorf("indicator %s was not imported", indicatorRef)
+		}
+		return true, i.store.Link(ctx, entityID, Links{Indicators: ids})
+	}
+	return false, nil
+}
+
+// entityID resolves a STIX reference to an entity imported now or earlier,
+// or to one exported with an identifier derived from its ID
+func (i *Importer) entityID(ctx context.Context, ref string, entities map[string]string) (string, error) {
+	if id, ok := entities[ref]; ok {
+		return id, nil
+	}
+	var id string
+	err := i.store.db.QueryRowContext(ctx, `
+		SELECT id FROM deployment_mgmt.threat_entities
+		WHERE external_id = $1 OR (kind = $2 AND id::text = $3)
+		ORDER BY external_id = $1 DESC NULLS LAST
+		LIMIT 1`, ref, stixType(ref), strings.TrimPrefix(ref, stixType(ref)+"--")).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("entity %s not found", ref)
+	}
+	entities[ref] = id
+	return id, nil
+}
+
+// indicatorIDs returns the global indicators imported from a STIX indicator
+func (i *Importer) indicatorIDs(ctx context.Context, ref string) ([]string, error) {
+	var ids []string
+	err := i.store.db.QueryRowContext(ctx, `
+		SELECT ARRAY(SELECT id::text FROM deployment_mgmt.threat_indicators
+			WHERE client_id IS NULL AND external_id = $1)`, ref).Scan(pq.Array(&ids))
+	return ids, err
+}
+
+func (i *Importer) indicatorsFromSTIX(raw json.RawMessage) ([]indicator.Indicator, error) {
+	var object stix.Indicator
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, fmt.Errorf("malformed indicator: %v", err)
+	}
+	if object.Revoked {
+		return nil, nil
+	}
+	if object.PatternType != "stix" {
+		return nil, fmt.Errorf("%s: unsupported pattern type %q", object.ID, object.PatternType)
+	}
+	observables, err := indicator.FromPattern(object.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", object.ID, err)
+	}
+
+	var indicators []indicator.Indicator
+	for _, o := range observables {
+		t, value, err := i.normalizer.Normalize(string(o.Type), o.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", object.ID, err)
+		}
+		ind := indicator.Indicator{
+			Type:        t,
+			Value:       value,
+			ThreatType:  "unknown",
+			Confidence:  50,
+			Source:      ImportSource,
+			ExternalID:  object.ID,
+			Description: object.Description,
+			Tags:        object.Labels,
+			ExpiresAt:   object.ValidUntil,
+			IsActive:    true,
+		}
+		if ind.Description == "" {
+			ind.Description = object.Name
+		}
+		if len(object.IndicatorTypes) > 0 {
+			ind.ThreatType = object.IndicatorTypes[0]
+		}
+		if object.Confidence != nil {
+			ind.Confidence = *object.Confidence
+		}
+		indicators = append(indicators, ind)
+	}
+	return indicators, nil
+}
+
+// entityFromSTIX converts an intrusion set, threat actor, campaign or
+// malware object
+func entityFromSTIX(kind string, raw json.RawMessage) (*Entity, error) {
+	e := &Entity{Kind: kind}
+	var object stix.Object
+	switch kind {
+	case KindIntrusionSet:
+		var o stix.IntrusionSet
+		if err := json.Unmarshal(raw, &o); err != nil {
+			return nil, err
+		}
+		object = o.Object
+		e.Name, e.Description, e.Aliases, e.Motivation = o.Name, o.Description, o.Aliases, o.PrimaryMotivation
+		e.FirstSeen, e.LastSeen, e.References = o.FirstSeen, o.LastSeen, o.ExternalReferences
+	case KindThreatActor:
+		var o stix.ThreatActor
+		if err := json.Unmarshal(raw, &o); err != nil {
+			return nil, err
+		}
+		object = o.Object
+		e.Name, e.Description, e.Aliases, e.Types = o.Name, o.Description, o.Aliases, o.ThreatActorTypes
+		e.Motivation, e.Sophistication = o.PrimaryMotivation, o.Sophistication
+		e.FirstSeen, e.LastSeen, e.References = o.FirstSeen, o.LastSeen, o.ExternalReferences
+	case KindCampaign:
+		var o stix.Campaign
+		if err := json.Unmarshal(raw, &o); err != nil {
+			return nil, err
+		}
+		object = o.Object
+		e.Name, e.Description, e.Aliases, e.Objective = o.Name, o.Description, o.Aliases, o.Objective
+		e.FirstSeen, e.LastSeen, e.References = o.FirstSeen, o.LastSeen, o.ExternalReferences
+	case KindMalware:
+		var o stix.Malware
+		if err := json.Unmarshal(raw, &o); err != nil {
+			return nil, err
+		}
+		object = o.Object
+		e.Name, e.Description, e.Aliases, e.Types = o.Name, o.Description, o.Aliases, o.MalwareTypes
+		e.FirstSeen, e.LastSeen, e.References = o.FirstSeen, o.LastSeen, o.ExternalReferences
+	}
+	if object.ID == "" {
+		return nil, fmt.Errorf("object has no id")
+	}
+	e.ExternalID = object.ID
+	return e, nil
+}
+
+// stixType returns the object type of a STIX identifier
+func stixType(id string) string {
+	if i := strings.Index(id, "--"); i > 0 {
+		return id[:i]
+	}
+	return ""
+}
@@ -0,0 +1,214 @@
+package actors
+
+import (
+	"context"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/lib/pq"
+)
+
+// maxProfileIndicators caps the indicators listed in a profile; Total
+// still counts all of them
+const maxProfileIndicators = 500
+
+// RelatedEntity is an entity related to the profiled one. Direction is
+// "outgoing" when the profiled entity is the relationship's source, such as
+// a campaign attributed-to an intrusion set, and "incoming" otherwise.
+type RelatedEntity struct {
+	Entity       *Entity `json:"entity"`
+	Relationship string  `json:"relationship"`
+	Direction    string  `json:"direction"`
+}
+
+// LinkedIndicator is an indicator of the profiled entity. Via names the
+// related entity the indicator is linked to when it is not linked directly.
+type LinkedIndicator struct {
+	*indicator.Indicator
+	Via string `json:"via,omitempty"`
+}
+
+// LinkedRule is an MDE master rule detecting the entity
+type LinkedRule struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	RuleType string `json:"ruleType"`
+	Severity string `json:"severity"`
+	IsActive bool   `json:"isActive"`
+}
+
+// LinkedTicket is a ticket about the entity
+type LinkedTicket struct {
+	ID        int64     `json:"id"`
+	Title     string    `json:"title"`
+	Status    string    `json:"status"`
+	Priority  string    `json:"priority"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Profile is everything known about an entity
+type Profile struct {
+	Entity          *Entity           `json:"entity"`
+	Related         []RelatedEntity   `json:"related"`
+	Indicators      []LinkedIndicator `json:"indicators"`
+	IndicatorsTotal int               `json:"indicatorsTotal"`
+	Rules           []LinkedRule      `json:"rules"`
+	Tickets         []LinkedTicket    `json:"tickets"`
+}
+
+// Profile returns an entity with its related entities and the indicators,
+// rules and tickets linked to it. Indicators, rules and tickets of directly
+// related entities are included too, so an intrusion set's profile covers
+// the malware it uses and the campaigns attributed to it.
+func (s *Store) Profile(ctx context.Context, id string) (*Profile, error) {
+	e, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	p := &Profile{
+		Entity:     e,
+		Related:    []RelatedEntity{},
+		Indicators: []LinkedIndicator{},
+		Rules:      []LinkedRule{},
+		Tickets:    []LinkedTicket{},
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.relationship_type, 'outgoing', `+entityColumns+`
+		FROM deployment_mgmt.threat_entity_relationships r
+		JOIN deployment_mgmt.threat_entities e ON e.id = r.target_id
+		WHERE r.source_id = $1
+		UNION ALL
+		SELECT r.relationship_type, 'incoming', `+entityColumns+`
+		FROM deployment_mgmt.threat_entity_relationships r
+		JOIN deployment_mgmt.threat_entities e ON e.id = r.source_id
+		WHERE r.target_id = $1
+		ORDER BY 1, 2`, e.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rel RelatedEntity
+		var relationship, direction string
+		related, err := scanEntity(scannerFunc(func(dest ...interface{}) error {
+			return rows.Scan(append([]interface{}{&relationship, &direction}, dest...)...)
+		}))
+		if err != nil {
+			return nil, err
+		}
+		rel.Entity, rel.Relationship, rel.Direction = related, relationship, direction
+		p.Related = append(p.Related, rel)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// The entity itself comes first so direct links win over indirect ones
+	scope := []string{e.ID}
+	names := map[string]string{e.ID: ""}
+	for _, r := range p.Related {
+		if _, ok := names[r.Entity.ID]; !ok {
+			scope = append(scope, r.Entity.ID)
+			names[r.Entity.ID] = r.Entity.Name
+		}
+	}
+
+	if err := s.profileIndicators(ctx, p, scope, names); err != nil {
+		return nil, err
+	}
+	if err := s.profileRules(ctx, p, scope); err != nil {
+		return nil, err
+	}
+	if err := s.profileTickets(ctx, p, scope); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// scannerFunc lets scanEntity read entity columns that follow others
+type scannerFunc func(dest ...interface{}) error
+
+func (f scannerFunc) Scan(dest ...interface{}) error {
+	return f(dest...)
+}
+
+func (s *Store) profileIndicators(ctx context.Context, p *Profile, scope []string, names map[string]string) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT indicator_id, entity_id, COUNT(*) OVER () FROM (
+			SELECT DISTINCT ON (l.indicator_id) l.indicator_id, l.entity_id, l.created_at
+			FROM deployment_mgmt.threat_entity_indicators l
+			WHERE l.entity_id::text = ANY($1)
+			ORDER BY l.indicator_id, l.entity_id::text <> $2, l.created_at
+		) d
+		ORDER BY entity_id::text <> $2, created_at DESC
+		LIMIT $3`, pq.Array(scope), scope[0], maxProfileIndicators)
+	if err != nil {
+		return err
+	}
+	via := make(map[string]string)
+	var ids []string
+	for rows.Next() {
+		var indicatorID, entityID string
+		if err := rows.Scan(&indicatorID, &entityID, &p.IndicatorsTotal); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, indicatorID)
+		via[indicatorID] = names[entityID]
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.indicators.ForEach(ctx, indicator.Filter{IDs: ids, IncludeInactive: true}, func(ind *indicator.Indicator) error {
+		p.Indicators = append(p.Indicators, LinkedIndicator{Indicator: ind, Via: via[ind.ID]})
+		return nil
+	})
+}
+
+func (s *Store) profileRules(ctx context.Context, p *Profile, scope []string) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT r.id, r.title, r.rule_type, r.severity, COALESCE(r.is_active, true)
+		FROM deployment_mgmt.threat_entity_rules l
+		JOIN mde_rules.master_rules r ON r.id = l.rule_id
+		WHERE l.entity_id::text = ANY($1)
+		ORDER BY r.title`, pq.Array(scope))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r LinkedRule
+		if err := rows.Scan(&r.ID, &r.Title, &r.RuleType, &r.Severity, &r.IsActive); err != nil {
+			return err
+		}
+		p.Rules = append(p.Rules, r)
+	}
+	return rows.Err()
+}
+
+func (s *Store) profileTickets(ctx context.Context, p *Profile, scope []string) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT t.id, t.title, t.status, t.priority, t.created_at
+		FROM deployment_mgmt.threat_entity_tickets l
+		JOIN tickets t ON t.id = l.ticket_id
+		WHERE l.entity_id::text = ANY($1)
+		ORDER BY t.created_at DESC`, pq.Array(scope))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t LinkedTicket
+		if err := rows.Scan(&t.ID, &t.Title, &t.Status, &t.Priority, &t.CreatedAt); err != nil {
+			return err
+		}
+		p.Tickets = append(p.Tickets, t)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,358 @@
+package actors
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/stix"
+	"github.com/lib/pq"
+)
+
+// ErrNotFound is returned for unknown entities
+var ErrNotFound = errors.New("entity not found")
+
+// Filter selects entities to list
+type Filter struct {
+	Kind string
+	// Query matches names and aliases containing it
+	Query  string
+	Limit  int
+	Offset int
+}
+
+// EntityLink relates the entity being linked to another entity
+type EntityLink struct {
+	ID           string `json:"id"`
+	Relationship string `json:"relationship"`
+}
+
+// Links are the objects linked to or unlinked from an entity at once
+type Links struct {
+	Indicators []string     `json:"indicators,omitempty"`
+	Rules      []string     `json:"rules,omitempty"`
+	Tickets    []int64      `json:"tickets,omitempty"`
+	Entities   []EntityLink `json:"entities,omitempty"`
+}
+
+// Store persists entities and their links
+type Store struct {
+	db         *sql.DB
+	indicators *indicator.Store
+}
+
+// NewStore creates an entity store. Profiles read linked indicators from
+// the indicator store.
+func NewStore(db *sql.DB, indicators *indicator.Store) *Store {
+	return &Store{db: db, indicators: indicators}
+}
+
+// entityColumns are the columns scanEntity reads, from the entities table
+// aliased as e
+const entityColumns = `
+	e.id, e.kind, e.name, e.aliases, COALESCE(e.description, ''), e.types, COALESCE(e.motivation, ''),
+	COALESCE(e.sophistication, ''), COALESCE(e.objective, ''), e.first_seen, e.last_seen,
+	COALESCE(e.external_id, ''), e.external_references, e.created_at, e.updated_at`
+
+func scanEntity(row interface{ Scan(...interface{}) error }) (*Entity, error) {
+	var e Entity
+	var firstSeen, lastSeen sql.NullTime
+	var references []byte
+	if err := row.Scan(&e.ID, &e.Kind, &e.Name, pq.Array(&e.Aliases), &e.Description, pq.Array(&e.Types),
+		&e.Motivation, &e.Sophistication, &e.Objective, &firstSeen, &lastSeen, &e.ExternalID,
+		&references, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if firstSeen.Valid {
+		e.FirstSeen = &firstSeen.Time
+	}
+	if lastSeen.Valid {
+		e.LastSeen = &lastSeen.Time
+	}
+	if len(references) > 0 {
+		if err := json.Unmarshal(references, &e.References); err != nil {
+			return nil, fmt.Errorf("invalid references for entity %s: %v", e.ID, err)
+		}
+	}
+	if e.Aliases == nil {
+		e.Aliases = []string{}
+	}
+	if e.Types == nil {
+		e.Types = []string{}
+	}
+	if e.References == nil {
+		e.References = []stix.ExternalReference{}
+	}
+	return &e, nil
+}
+
+// List returns the entities matching the filter by name and their total
+func (s *Store) List(ctx context.Context, filter Filter) ([]*Entity, int, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if filter.Kind != "" {
+		conditions = append(conditions, "e.kind = "+arg(filter.Kind))
+	}
+	if filter.Query != "" {
+		pattern := arg("%" + escapeLike(filter.Query) + "%")
+		conditions = append(conditions, "(e.name ILIKE "+pattern+
+			" OR EXISTS (SELECT 1 FROM unnest(e.aliases) a WHERE a ILIKE "+pattern+"))")
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM deployment_mgmt.threat_entities e "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT "+entityColumns+`
+		FROM deployment_mgmt.threat_entities e `+where+`
+		ORDER BY e.name, e.id
+		LIMIT `+arg(limit)+` OFFSET `+arg(filter.Offset), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entities := []*Entity{}
+	for rows.Next() {
+		e, err := scanEntity(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		entities = append(entities, e)
+	}
+	return entities, total, rows.Err()
+}
+
+// Get returns one entity
+func (s *Store) Get(ctx context.Context, id string) (*Entity, error) {
+	e, err := scanEntity(s.db.QueryRowContext(ctx, "SELECT "+entityColumns+`
+		FROM deployment_mgmt.threat_entities e WHERE e.id::text = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return e, err
+}
+
+// Resolve finds an entity by ID, STIX identifier, name or alias. Names and
+// aliases are matched case insensitively, preferring actors.
+func (s *Store) Resolve(ctx context.Context, ref string) (*Entity, error) {
+	e, err := scanEntity(s.db.QueryRowContext(ctx, "SELECT "+entityColumns+`
+		FROM deployment_mgmt.threat_entities e
+		WHERE e.id::text = $1 OR e.external_id = $1
+			OR lower(e.name) = lower($1)
+			OR EXISTS (SELECT 1 FROM unnest(e.aliases) a WHERE lower(a) = lower($1))
+		ORDER BY e.id::text = $1 DESC, e.external_id = $1 DESC NULLS LAST, lower(e.name) = lower($1) DESC,
+			CASE e.kind WHEN 'threat-actor' THEN 0 WHEN 'intrusion-set' THEN 1
+				WHEN 'campaign' THEN 2 ELSE 3 END
+		LIMIT 1`, ref))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return e, err
+}
+
+// Save creates or updates an entity. Entities without an ID are merged
+// into the existing entity with the same STIX identifier, or else the same
+// kind and name, so repeated imports update rather than duplicate.
+func (s *Store) Save(ctx context.Context, e *Entity) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	references, err := json.Marshal(e.References)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{e.Kind, e.Name, pq.Array(e.Aliases), nullString(e.Description), pq.Array(e.Types),
+		nullString(e.Motivation), nullString(e.Sophistication), nullString(e.Objective),
+		e.FirstSeen, e.LastSeen, nullString(e.ExternalID), references}
+
+	if e.ID != "" {
+		row := s.db.QueryRowContext(ctx, `
+			UPDATE deployment_mgmt.threat_entities e
+			SET kind = $2, name = $3, aliases = $4, description = $5, types = $6, motivation = $7,
+				sophistication = $8, objective = $9, first_seen = $10, last_seen = $11,
+				external_id = COALESCE($12, e.external_id), external_references = $13, updated_at = NOW()
+			WHERE e.id::text = $1
+			RETURNING `+entityColumns, append([]interface{}{e.ID}, args...)...)
+		saved, err := scanEntity(row)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		*e = *saved
+		return nil
+	}
+
+	var existing string
+	err = s.db.QueryRowContext(ctx, `
+		SELECT id FROM deployment_mgmt.threat_entities
+		WHERE ($1 <> '' AND external_id = $1) OR (kind = $2 AND lower(name) = lower($3))
+		ORDER BY external_id = $1 DESC NULLS LAST
+		LIMIT 1`, e.ExternalID, e.Kind, e.Name).Scan(&existing)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if existing != "" {
+		e.ID = existing
+		return s.Save(ctx, e)
+	}
+
+	saved, err := scanEntity(s.db.QueryRowContext(ctx, `
+		INSERT INTO deployment_mgmt.threat_entities AS e
+			(kind, name, aliases, description, types, motivation, sophistication, objective,
+			 first_seen, last_seen, external_id, external_references)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING `+entityColumns, args...))
+	if err != nil {
+		return err
+	}
+	*e = *saved
+	return nil
+}
+
+// Delete removes an entity and its links
+func (s *Store) Delete(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM deployment_mgmt.threat_entities WHERE id::text = $1`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Link links indicators, MDE master rules, tickets and other entities to
+// an entity. Indicators are linked as indicating the entity; entity links
+// default to related-to. Existing links are left alone.
+func (s *Store) Link(ctx context.Context, id string, links Links) error {
+	return s.links(ctx, id, links, false)
+}
+
+// Unlink removes links made by Link
+func (s *Store) Unlink(ctx context.Context, id string, links Links) error {
+	return s.links(ctx, id, links, true)
+}
+
+func (s *Store) links(ctx context.Context, id string, links Links, remove bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM deployment_mgmt.threat_entities WHERE id::text = $1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+
+	type statement struct {
+		link, unlink string
+		values       interface{}
+		count        int
+	}
+	statements := []statement{
+		{`INSERT INTO deployment_mgmt.threat_entity_indicators (entity_id, indicator_id)
+			SELECT $1::uuid, i.id FROM deployment_mgmt.threat_indicators i WHERE i.id::text = ANY($2)
+			ON CONFLICT DO NOTHING`,
+			`DELETE FROM deployment_mgmt.threat_entity_indicators
+			WHERE entity_id = $1::uuid AND indicator_id::text = ANY($2)`,
+			pq.Array(links.Indicators), len(links.Indicators)},
+		{`INSERT INTO deployment_mgmt.threat_entity_rules (entity_id, rule_id)
+			SELECT $1::uuid, r.id FROM mde_rules.master_rules r WHERE r.id::text = ANY($2)
+			ON CONFLICT DO NOTHING`,
+			`DELETE FROM deployment_mgmt.threat_entity_rules
+			WHERE entity_id = $1::uuid AND rule_id::text = ANY($2)`,
+			pq.Array(links.Rules), len(links.Rules)},
+		{`INSERT INTO deployment_mgmt.threat_entity_tickets (entity_id, ticket_id)
+			SELECT $1::uuid, unnest($2::integer[])
+			ON CONFLICT DO NOTHING`,
+			`DELETE FROM deployment_mgmt.threat_entity_tickets
+			WHERE entity_id = $1::uuid AND ticket_id = ANY($2::integer[])`,
+			pq.Array(links.Tickets), len(links.Tickets)},
+	}
+	for _, st := range statements {
+		if st.count == 0 {
+			continue
+		}
+		query := st.link
+		if remove {
+			query = st.unlink
+		}
+		if _, err := tx.ExecContext(ctx, query, id, st.values); err != nil {
+			return err
+		}
+	}
+
+	for _, l := range links.Entities {
+		if l.ID == id {
+			return fmt.Errorf("an entity cannot be related to itself")
+		}
+		rel := l.Relationship
+		if rel == "" {
+			rel = RelRelatedTo
+		}
+		if remove {
+			_, err = tx.ExecContext(ctx, `
+				DELETE FROM deployment_mgmt.threat_entity_relationships
+				WHERE source_id = $1::uuid AND target_id::text = $2 AND relationship_type = $3`, id, l.ID, rel)
+		} else {
+			var res sql.Result
+			res, err = tx.ExecContext(ctx, `
+				INSERT INTO deployment_mgmt.threat_entity_relationships (source_id, target_id, relationship_type)
+				SELECT $1::uuid, e.id, $3 FROM deployment_mgmt.threat_entities e WHERE e.id::text = $2
+				ON CONFLICT DO NOTHING`, id, l.ID, rel)
+			if err == nil {
+				var n int64
+				if n, err = res.RowsAffected(); err == nil && n == 0 {
+					var found bool
+					if err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM
+						deployment_mgmt.threat_entities WHERE id::text = $1)`, l.ID).Scan(&found); err == nil && !found {
+						err = fmt.Errorf("entity %s not found", l.ID)
+					}
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
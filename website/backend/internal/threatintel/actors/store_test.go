@@ -0,0 +1,217 @@
+package actors
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// save stores a new entity
+func save(t *testing.T, s *Store, kind, name string, aliases ...string) *Entity {
+	t.Helper()
+	e := &Entity{Kind: kind, Name: name, Aliases: aliases}
+	if err := s.Save(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// indicatorIDs stores global domain indicators and returns their IDs
+func indicatorIDs(t *testing.T, db *sql.DB, indicators *indicator.Store, domains ...string) []string {
+	t.Helper()
+	var batch []indicator.Indicator
+	for _, d := range domains {
+		batch = append(batch, indicator.Indicator{Type: indicator.TypeDomain, Value: d,
+			ThreatType: "C2", Confidence: 80, Source: "test"})
+	}
+	if _, err := indicators.Upsert(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(domains))
+	for i, d := range domains {
+		if err := db.QueryRow(`SELECT id FROM deployment_mgmt.threat_indicators
+			WHERE client_id IS NULL AND indicator_value = $1`, d).Scan(&ids[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ids
+}
+
+func TestStoreSave(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	s := NewStore(db, indicator.NewStore(db))
+
+	e := save(t, s, KindThreatActor, " APT29 ", "Cozy Bear", "apt29", "", "cozy bear")
+	if e.ID == "" || e.Name != "APT29" || len(e.Aliases) != 1 || e.Aliases[0] != "Cozy Bear" {
+		t.Fatalf("saved entity = %+v, want a trimmed name and one alias", e)
+	}
+
+	// Saving the same kind and name again updates rather than duplicates
+	again := &Entity{Kind: KindThreatActor, Name: "apt29", Motivation: "espionage"}
+	if err := s.Save(ctx, again); err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != e.ID || again.Motivation != "espionage" {
+		t.Errorf("merged entity = %s %q, want %s with the new motivation", again.ID, again.Motivation, e.ID)
+	}
+	// The same name as another kind is a different entity
+	if malware := save(t, s, KindMalware, "APT29"); malware.ID == e.ID {
+		t.Error("malware with an actor's name was merged into the actor")
+	}
+
+	e.Description = "Russian SVR"
+	if err := s.Save(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx, e.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Description != "Russian SVR" || got.Motivation != "" {
+		t.Errorf("updated entity = %+v", got)
+	}
+
+	if err := s.Save(ctx, &Entity{ID: "00000000-0000-0000-0000-000000000000",
+		Kind: KindCampaign, Name: "Gone"}); err != ErrNotFound {
+		t.Errorf("Save() of an unknown ID = %v, want ErrNotFound", err)
+	}
+	if err := s.Save(ctx, &Entity{Kind: "vulnerability", Name: "CVE"}); err == nil {
+		t.Error("Save() accepted an unknown kind")
+	}
+}
+
+func TestStoreListAndResolve(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	s := NewStore(db, indicator.NewStore(db))
+	actor := save(t, s, KindThreatActor, "Midnight Blizzard", "NOBELIUM", "APT29")
+	set := save(t, s, KindIntrusionSet, "APT29")
+	save(t, s, KindMalware, "SUNBURST")
+
+	list, total, err := s.List(ctx, Filter{Query: "apt29"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(list) != 2 || list[0].ID != set.ID || list[1].ID != actor.ID {
+		t.Errorf("List(apt29) = %d entities of %d, want the intrusion set then the actor", len(list), total)
+	}
+	if list, total, err := s.List(ctx, Filter{Kind: KindMalware}); err != nil || total != 1 || list[0].Name != "SUNBURST" {
+		t.Errorf("List(malware) = %v, %d, %v", list, total, err)
+	}
+	if list, total, err := s.List(ctx, Filter{Query: "%"}); err != nil || total != 0 || len(list) != 0 {
+		t.Errorf("List(%%) = %v, %d, %v; want wildcards matched literally", list, total, err)
+	}
+
+	// A name beats an alias, and actors beat other kinds
+	tests := map[string]string{
+		actor.ID:            actor.ID,
+		"midnight blizzard": actor.ID,
+		"nobelium":          actor.ID,
+		"APT29":             set.ID,
+	}
+	for ref, want := range tests {
+		if e, err := s.Resolve(ctx, ref); err != nil || e.ID != want {
+			t.Errorf("Resolve(%s) = %v, %v; want %s", ref, e, err, want)
+		}
+	}
+	if _, err := s.Resolve(ctx, "Lazarus"); err != ErrNotFound {
+		t.Errorf("Resolve(Lazarus) = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	indicators := indicator.NewStore(db)
+	s := NewStore(db, indicators)
+	e := save(t, s, KindCampaign, "SolarWinds")
+	if err := s.Link(ctx, e.ID, Links{Indicators: indicatorIDs(t, db, indicators, "avsvmcloud.com")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete(ctx, e.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, e.ID); err != ErrNotFound {
+		t.Errorf("Get() after Delete() = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, e.ID); err != ErrNotFound {
+		t.Errorf("second Delete() = %v, want ErrNotFound", err)
+	}
+	var links int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM deployment_mgmt.threat_entity_indicators`).Scan(&links); err != nil {
+		t.Fatal(err)
+	}
+	if links != 0 {
+		t.Errorf("%d indicator links left after Delete()", links)
+	}
+}
+
+func TestStoreLink(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	indicators := indicator.NewStore(db)
+	s := NewStore(db, indicators)
+	set := save(t, s, KindIntrusionSet, "APT29")
+	malware := save(t, s, KindMalware, "SUNBURST")
+	ids := indicatorIDs(t, db, indicators, "avsvmcloud.com", "freescanonline.com", "deftsecurity.com")
+
+	if err := s.Link(ctx, set.ID, Links{Indicators: ids[:1],
+		Entities: []EntityLink{{ID: malware.ID, Relationship: RelUses}}}); err != nil {
+		t.Fatal(err)
+	}
+	// Indicators of the malware show up on the intrusion set through it,
+	// unless they are linked directly too
+	if err := s.Link(ctx, malware.ID, Links{Indicators: ids}); err != nil {
+		t.Fatal(err)
+	}
+	// Linking again leaves the existing links alone
+	if err := s.Link(ctx, set.ID, Links{Indicators: ids[:1]}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := s.Profile(ctx, set.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Related) != 1 || p.Related[0].Entity.ID != malware.ID ||
+		p.Related[0].Relationship != RelUses || p.Related[0].Direction != "outgoing" {
+		t.Errorf("related = %+v, want SUNBURST used by the set", p.Related)
+	}
+	via := make(map[string]string)
+	for _, ind := range p.Indicators {
+		via[ind.Value] = ind.Via
+	}
+	if p.IndicatorsTotal != 3 || len(via) != 3 || via["avsvmcloud.com"] != "" ||
+		via["freescanonline.com"] != "SUNBURST" || via["deftsecurity.com"] != "SUNBURST" {
+		t.Errorf("profile indicators = %v of %d", via, p.IndicatorsTotal)
+	}
+	if p, err := s.Profile(ctx, malware.ID); err != nil || len(p.Related) != 1 || p.Related[0].Direction != "incoming" {
+		t.Errorf("malware profile related = %+v, %v; want the set, incoming", p, err)
+	}
+
+	if err := s.Unlink(ctx, malware.ID, Links{Indicators: ids[1:]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unlink(ctx, set.ID, Links{Entities: []EntityLink{{ID: malware.ID, Relationship: RelUses}}}); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := s.Profile(ctx, set.ID); err != nil || len(p.Related) != 0 || p.IndicatorsTotal != 1 {
+		t.Errorf("profile after Unlink() = %+v, %v; want one direct indicator", p, err)
+	}
+
+	unknown := "00000000-0000-0000-0000-000000000000"
+	if err := s.Link(ctx, unknown, Links{Indicators: ids}); err != ErrNotFound {
+		t.Errorf("Link() to an unknown entity = %v, want ErrNotFound", err)
+	}
+	if err := s.Link(ctx, set.ID, Links{Entities: []EntityLink{{ID: unknown}}}); err == nil {
+		t.Error("Link() to an unknown related entity succeeded")
+	}
+	if err := s.Link(ctx, set.ID, Links{Entities: []EntityLink{{ID: set.ID}}}); err == nil {
+		t.Error("Link() of an entity to itself succeeded")
+	}
+}
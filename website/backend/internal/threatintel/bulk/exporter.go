@@ -0,0 +1,308 @@
+package bulk
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/defender"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// Export formats
+const (
+	ExportMDE        = "mde"
+	ExportSentinel   = "sentinel"
+	ExportSharePoint = "sharepoint"
+)
+
+// ExportOptions selects the indicators to export and how they are rendered
+type ExportOptions struct {
+	Filter indicator.Filter
+	// Action is the MDE response to matches: "block" (default) or "audit"
+	Action string
+}
+
+// Exporter writes stored indicators in formats other tools can import
+type Exporter struct {
+	indicators *indicator.Store
+	// SourceSystem names this platform in Sentinel uploads
+	SourceSystem string
+}
+
+// NewExporter creates an exporter
+func NewExporter(indicators *indicator.Store) *Exporter {
+	return &Exporter{indicators: indicators, SourceSystem: "ClarityXDR"}
+}
+
+// ContentType returns the media type and file extension of an export format
+func ContentType(format string) (string, string, bool) {
+	switch format {
+	case ExportMDE:
+		return "text/csv", "csv", true
+	case ExportSentinel, ExportSharePoint:
+		return "application/json", "json", true
+	}
+	return "", "", false
+}
+
+// Export streams the indicators matching the options to w and returns how
+// many were written. Indicators the target cannot represent are skipped.
+func (e *Exporter) Export(ctx context.Context, w io.Writer, format string, opts ExportOptions) (int, error) {
+	switch format {
+	case ExportMDE:
+		return e.exportMDE(ctx, w, opts)
+	case ExportSentinel:
+		return e.exportSentinel(ctx, w, opts)
+	case ExportSharePoint:
+		return e.exportSharePoint(ctx, w, opts)
+	}
+	return 0, fmt.Errorf("unsupported export format %q", format)
+}
+
+// mdeHeader is the column layout of the MDE indicator import template
+var mdeHeader = []string{
+	"IndicatorType", "IndicatorValue", "ExpirationTime", "Action", "Severity", "Title",
+	"Description", "RecommendedActions", "RbacGroups", "Category", "MitreTechniques", "GenerateAlert",
+}
+
+var mitreTechnique = regexp.MustCompile(`^T\d{4}(\.\d{3})?$`)
+
+func (e *Exporter) exportMDE(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	out := csv.NewWriter(w)
+	if err := out.Write(mdeHeader); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	count := 0
+	err := e.indicators.ForEach(ctx, opts.Filter, func(ind *indicator.Indicator) error {
+		t, ok := defender.IndicatorType(canonicalType(ind))
+		if !ok {
+			return nil
+		}
+		// MDE rejects indicators that have already expired
+		expiration := ""
+		if ind.ExpiresAt != nil {
+			if ind.ExpiresAt.Before(now) {
+				return nil
+			}
+			expiration = ind.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+
+		var techniques []string
+		for _, tag := range ind.Tags {
+			if mitreTechnique.MatchString(tag) {
+				techniques = append(techniques, tag)
+			}
+		}
+
+		title := truncate(ind.ThreatType+": "+ind.Value, 255)
+		description := ind.Description
+		if description == "" {
+			description = fmt.Sprintf("%s indicator from %s", ind.ThreatType, ind.Source)
+		}
+
+		count++
+		return out.Write([]string{
+			t,
+			ind.Value,
+			expiration,
+			defender.Action(t, opts.Action),
+			defender.Severity(ind.Confidence),
+			title,
+			description,
+			"",
+			"",
+			"",
+			strings.Join(techniques, ","),
+			"TRUE",
+		})
+	})
+	if err != nil {
+		return count, err
+	}
+	out.Flush()
+	return count, out.Error()
+}
+
+// exportSentinel writes the request body of the Sentinel threat intelligence
+// upload API. The API accepts at most 100 indicators per request, so callers
+// uploading the file directly must split the indicators array.
+func (e *Exporter) exportSentinel(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	out := bufio.NewWriter(w)
+	source, _ := json.Marshal(e.SourceSystem)
+	fmt.Fprintf(out, `{"sourcesystem":%s,"indicators":[`, source)
+
+	count := 0
+	err := e.indicators.ForEach(ctx, opts.Filter, func(ind *indicator.Indicator) error {
+		object, err := indicator.ToSTIX(ind)
+		if err != nil {
+			return nil
+		}
+		data, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			out.WriteByte(',')
+		}
+		count++
+		_, err = out.Write(data)
+		return err
+	})
+	if err != nil {
+		return count, err
+	}
+	out.WriteString("]}\n")
+	return count, out.Flush()
+}
+
+// SharePointList is the list described by cti/SharePoint/IndicatorListSchema.json
+const SharePointList = "ThreatIndicatorsList"
+
+// sharePointTypes maps indicator types to the IndicatorType choices of the list
+var sharePointTypes = map[indicator.Type]string{
+	indicator.TypeIPv4:        "IPAddress",
+	indicator.TypeIPv6:        "IPAddress",
+	indicator.TypeCIDR:        "IPAddress",
+	indicator.TypeDomain:      "Domain",
+	indicator.TypeURL:         "URL",
+	indicator.TypeMD5:         "FileHash",
+	indicator.TypeSHA1:        "FileHash",
+	indicator.TypeSHA256:      "FileHash",
+	indicator.TypeCertificate: "Certificate",
+	indicator.TypeEmail:       "Email",
+}
+
+// sharePointTargets are the default deployment targets per indicator type
+// from cti/PowerShell/CTI-Config.json
+var sharePointTargets = map[string][]string{
+	"IPAddress":   {"MDE", "EntraID", "MDCA"},
+	"FileHash":    {"MDE"},
+	"URL":         {"MDE", "ExchangeTABL"},
+	"Domain":      {"MDE", "ExchangeTABL"},
+	"Certificate": {"MDE"},
+	"Email":       {"ExchangeTABL"},
+}
+
+// sharePointItem holds the list columns populated from an indicator.
+// Columns maintained by the list workflows, such as deployment status and
+// reputation, are left for SharePoint to fill in.
+type sharePointItem struct {
+	IndicatorID       string    `json:"IndicatorId"`
+	IndicatorType     string    `json:"IndicatorType"`
+	IndicatorValue    string    `json:"IndicatorValue"`
+	Title             string    `json:"Title"`
+	Description       string    `json:"Description,omitempty"`
+	TLP               string    `json:"TLP"`
+	Confidence        int       `json:"Confidence"`
+	Severity          string    `json:"Severity"`
+	Source            string    `json:"Source"`
+	ValidFrom         time.Time `json:"ValidFrom"`
+	ValidUntil        time.Time `json:"ValidUntil"`
+	ValidationStatus  string    `json:"ValidationStatus"`
+	Tags              string    `json:"Tags,omitempty"`
+	DeploymentTargets []string  `json:"DeploymentTargets"`
+}
+
+// exportSharePoint writes list items matching the SharePoint indicator list
+// schema, ready to be added with Add-PnPListItem
+func (e *Exporter) exportSharePoint(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, `{"list":%q,"items":[`, SharePointList)
+
+	now := time.Now()
+	count := 0
+	err := e.indicators.ForEach(ctx, opts.Filter, func(ind *indicator.Indicator) error {
+		t, ok := sharePointTypes[canonicalType(ind)]
+		if !ok {
+			return nil
+		}
+
+		item := sharePointItem{
+			IndicatorID:       ind.ID,
+			IndicatorType:     t,
+			IndicatorValue:    ind.Value,
+			Title:             truncate(ind.ThreatType+": "+ind.Value, 255),
+			Description:       ind.Description,
+			TLP:               "Amber",
+			Confidence:        ind.Confidence,
+			Severity:          sharePointSeverity(ind.Confidence),
+			Source:            ind.Source,
+			ValidFrom:         ind.CreatedAt.UTC(),
+			ValidationStatus:  "Valid",
+			Tags:              strings.Join(ind.Tags, ";"),
+			DeploymentTargets: sharePointTargets[t],
+		}
+		// The list requires an expiry and defaults it to 90 days
+		if ind.ExpiresAt != nil {
+			item.ValidUntil = ind.ExpiresAt.UTC()
+		} else {
+			item.ValidUntil = ind.CreatedAt.AddDate(0, 0, 90).UTC()
+		}
+		switch {
+		case !ind.IsActive:
+			item.ValidationStatus = "Invalid"
+		case item.ValidUntil.Before(now):
+			item.ValidationStatus = "Expired"
+		}
+
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			out.WriteByte(',')
+		}
+		count++
+		_, err = out.Write(data)
+		return err
+	})
+	if err != nil {
+		return count, err
+	}
+	out.WriteString("]}\n")
+	return count, out.Flush()
+}
+
+func sharePointSeverity(confidence int) string {
+	switch {
+	case confidence >= 90:
+		return "Critical"
+	case confidence >= 75:
+		return "High"
+	case confidence >= 50:
+		return "Medium"
+	}
+	return "Low"
+}
+
+// canonicalType resolves the generic type names, such as "hash", of rows
+// stored before types were normalized
+func canonicalType(ind *indicator.Indicator) indicator.Type {
+	if t, ok := indicator.ParseType(string(ind.Type)); ok {
+		return t
+	}
+	if t, ok := indicator.Detect(ind.Value); ok {
+		return t
+	}
+	return ind.Type
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,284 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+const (
+	// MaxRows is the largest number of rows read from a single import
+	MaxRows = 500000
+	// MaxUploadSize bounds the size of an uploaded import file
+	MaxUploadSize = 512 << 20
+
+	defaultBatchSize = 1000
+	previewSize      = 50
+	maxRowErrors     = 100
+	// progressInterval throttles job progress writes during large imports
+	progressInterval = 2 * time.Second
+	// defaultConfidence is the confidence of rows without one when the
+	// import gives none
+	defaultConfidence = 50
+)
+
+// ErrTooLarge is returned when an upload exceeds MaxUploadSize
+var ErrTooLarge = fmt.Errorf("import file exceeds %d MB", MaxUploadSize>>20)
+
+// Importer reads import files into deployment_mgmt.threat_indicators in the
+// background, recording progress in a Job
+type Importer struct {
+	ctx        context.Context
+	jobs       *JobStore
+	indicators *indicator.Store
+	normalizer *indicator.Normalizer
+	batchSize  int
+
+	running sync.WaitGroup
+}
+
+// NewImporter creates an importer whose background imports stop when ctx
+// is cancelled
+func NewImporter(ctx context.Context, jobs *JobStore, indicators *indicator.Store) *Importer {
+	return &Importer{
+		ctx:        ctx,
+		jobs:       jobs,
+		indicators: indicators,
+		normalizer: indicator.NewNormalizer(),
+		batchSize:  defaultBatchSize,
+	}
+}
+
+// Submit spools the upload to a temporary file, creates a job for it and
+// processes it in the background. The returned job is still queued.
+func (i *Importer) Submit(ctx context.Context, opts Options, body io.Reader) (*Job, error) {
+	switch opts.Format {
+	case FormatCSV, FormatText, FormatSTIX, FormatMDE:
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", opts.Format)
+	}
+	if opts.Source == "" {
+		opts.Source = "bulk-import"
+	}
+	if opts.ThreatType == "" {
+		opts.ThreatType = "unknown"
+	}
+	if opts.Confidence == nil {
+		confidence := defaultConfidence
+		opts.Confidence = &confidence
+	} else if *opts.Confidence < 0 || *opts.Confidence > 100 {
+		return nil, fmt.Errorf("confidence must be between 0 and 100")
+	}
+	if err := i.ctx.Err(); err != nil {
+		return nil, fmt.Errorf("the server is shutting down: %w", err)
+	}
+
+	file, err := os.CreateTemp("", "indicator-import-*")
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(file, io.LimitReader(body, MaxUploadSize+1))
+	if err == nil && n > MaxUploadSize {
+		err = ErrTooLarge
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	job, err := i.jobs.Create(ctx, opts)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	i.running.Add(1)
+	go func() {
+		defer i.running.Done()
+		defer os.Remove(file.Name())
+		defer file.Close()
+		i.Run(i.ctx, job, file)
+	}()
+	return job, nil
+}
+
+// Wait waits for the imports running in the background to end, which they
+// do soon after the importer's context is cancelled
+func (i *Importer) Wait() {
+	i.running.Wait()
+}
+
+// Run processes an import file for a job created by the job store. The
+// outcome is recorded on the job rather than returned.
+func (i *Importer) Run(ctx context.Context, job *Job, r io.Reader) {
+	if err := i.jobs.Start(ctx, job.ID); err != nil {
+		log.Printf("Error starting import job %s: %v", job.ID, err)
+	}
+	job.Status = StatusRunning
+
+	run := &importRun{
+		ctx:        ctx,
+		importer:   i,
+		job:        job,
+		seen:       make(map[string]bool),
+		lastReport: time.Now(),
+	}
+	err := run.read(r)
+	if err == nil {
+		err = run.flush()
+	}
+
+	job.Status = StatusCompleted
+	switch {
+	case err != nil && ctx.Err() != nil:
+		job.Status = StatusFailed
+		job.Error = "import interrupted before it finished; submit the file again"
+	case err != nil:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	}
+
+	// Record the outcome even if the import context was cancelled
+	saveCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := i.jobs.Save(saveCtx, job); err != nil {
+		log.Printf("Error recording import job %s: %v", job.ID, err)
+	}
+	log.Printf("Indicator import %s %s: %d rows, %d accepted, %d duplicates, %d rejected",
+		job.ID, job.Status, job.Rows, job.Accepted, job.Duplicates, job.Rejected)
+}
+
+// importRun holds the state of a single import
+type importRun struct {
+	ctx        context.Context
+	importer   *Importer
+	job        *Job
+	batch      []indicator.Indicator
+	seen       map[string]bool
+	lastReport time.Time
+}
+
+func (r *importRun) read(file io.Reader) error {
+	reader, err := NewReader(r.job.Options.Format, file)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+		row, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if r.job.Rows == MaxRows {
+			return fmt.Errorf("import stopped after %d rows; the remaining rows were not read", MaxRows)
+		}
+		r.job.Rows++
+		if err := r.add(row); err != nil {
+			return err
+		}
+	}
+}
+
+// add normalizes a row and queues it for storage
+func (r *importRun) add(row *Row) error {
+	if row.Err != nil {
+		r.reject(row, row.Err)
+		return nil
+	}
+
+	opts := r.job.Options
+	ind := row.Indicator
+	t, value, err := r.importer.normalizer.Normalize(string(ind.Type), ind.Value)
+	if err != nil {
+		r.reject(row, err)
+		return nil
+	}
+	ind.Type, ind.Value = t, value
+	ind.ClientID = opts.ClientID
+	ind.Source = opts.Source
+	ind.IsActive = true
+	if ind.ThreatType == "" {
+		ind.ThreatType = opts.ThreatType
+	}
+	if !row.HasConfidence {
+		ind.Confidence = *opts.Confidence
+	}
+	ind.Tags = append(append([]string(nil), ind.Tags...), opts.Tags...)
+
+	key := ind.Key()
+	if r.seen[key] {
+		r.job.Duplicates++
+		return nil
+	}
+	r.seen[key] = true
+	r.job.Accepted++
+
+	if len(r.job.Preview) < previewSize {
+		r.job.Preview = append(r.job.Preview, ind)
+	}
+	if opts.DryRun {
+		r.report()
+		return nil
+	}
+
+	r.batch = append(r.batch, ind)
+	if len(r.batch) >= r.importer.batchSize {
+		return r.flush()
+	}
+	return nil
+}
+
+func (r *importRun) reject(row *Row, err error) {
+	r.job.Rejected++
+	if len(r.job.Errors) < maxRowErrors {
+		r.job.Errors = append(r.job.Errors, RowError{
+			Row:   row.Number,
+			Value: row.Indicator.Value,
+			Error: err.Error(),
+		})
+	}
+}
+
+func (r *importRun) flush() error {
+	if len(r.batch) == 0 {
+		return nil
+	}
+	stored, err := r.importer.indicators.Upsert(r.ctx, r.batch)
+	if err != nil {
+		return err
+	}
+	r.job.Inserted += stored.Inserted
+	r.job.Updated += stored.Updated
+	r.batch = r.batch[:0]
+	r.report()
+	return nil
+}
+
+// report saves the job's progress, at most once per progressInterval
+func (r *importRun) report() {
+	if time.Since(r.lastReport) < progressInterval {
+		return
+	}
+	r.lastReport = time.Now()
+	if err := r.importer.jobs.Save(r.ctx, r.job); err != nil {
+		log.Printf("Error updating import job %s: %v", r.job.ID, err)
+	}
+}
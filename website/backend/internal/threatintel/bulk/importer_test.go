@@ -0,0 +1,164 @@
+package bulk
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+const importFile = "value,confidence\n" +
+	"45.33.32.156,90\n" +
+	"evil.example.org,0\n" +
+	"evil.example.net,\n" +
+	"hxxp://evil[.]example[.]net/a,\n" +
+	"45.33.32.156,80\n" +
+	"10.0.0.1,90\n"
+
+func newImporter(ctx context.Context, t *testing.T) (*Importer, *sql.DB) {
+	t.Helper()
+	db := dbtest.Open(t)
+	return NewImporter(ctx, NewJobStore(db), indicator.NewStore(db)), db
+}
+
+// confidences returns the stored confidence of each indicator value
+func confidences(t *testing.T, db *sql.DB) map[string]int {
+	t.Helper()
+	rows, err := db.Query(`SELECT indicator_value, confidence FROM deployment_mgmt.threat_indicators`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	out := make(map[string]int)
+	for rows.Next() {
+		var value string
+		var confidence int
+		if err := rows.Scan(&value, &confidence); err != nil {
+			t.Fatal(err)
+		}
+		out[value] = confidence
+	}
+	return out
+}
+
+func TestImporterSubmit(t *testing.T) {
+	ctx := context.Background()
+	importer, db := newImporter(ctx, t)
+	importer.batchSize = 2
+	confidence := 60
+
+	job, err := importer.Submit(ctx, Options{Format: FormatCSV, Confidence: &confidence}, strings.NewReader(importFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	importer.Wait()
+
+	job, err = importer.jobs.Get(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusCompleted || job.Rows != 6 || job.Accepted != 4 || job.Duplicates != 1 ||
+		job.Rejected != 1 || job.Inserted != 4 || job.FinishedAt == nil {
+		t.Errorf("job = %+v", job)
+	}
+	if len(job.Errors) != 1 || job.Errors[0].Row != 7 {
+		t.Errorf("row errors = %+v, want the private address on row 7", job.Errors)
+	}
+
+	want := map[string]int{
+		"45.33.32.156":              90,
+		"evil.example.org":          0,
+		"evil.example.net":          60,
+		"http://evil.example.net/a": 60,
+	}
+	got := confidences(t, db)
+	for value, confidence := range want {
+		if c, ok := got[value]; !ok || c != confidence {
+			t.Errorf("%s stored with confidence %d (%v), want %d", value, c, ok, confidence)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+}
+
+func TestImporterDefaults(t *testing.T) {
+	ctx := context.Background()
+	importer, db := newImporter(ctx, t)
+
+	job, err := importer.Submit(ctx, Options{Format: FormatText}, strings.NewReader("evil.example.org\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	importer.Wait()
+	if job.Options.Source != "bulk-import" || job.Options.ThreatType != "unknown" || *job.Options.Confidence != defaultConfidence {
+		t.Errorf("options = %+v", job.Options)
+	}
+	if got := confidences(t, db)["evil.example.org"]; got != defaultConfidence {
+		t.Errorf("stored confidence %d, want %d", got, defaultConfidence)
+	}
+
+	for _, confidence := range []int{-1, 101} {
+		confidence := confidence
+		if _, err := importer.Submit(ctx, Options{Format: FormatText, Confidence: &confidence}, strings.NewReader("x\n")); err == nil {
+			t.Errorf("an import with confidence %d was accepted", confidence)
+		}
+	}
+	if _, err := importer.Submit(ctx, Options{Format: "xlsx"}, strings.NewReader("x\n")); err == nil {
+		t.Error("an import in an unknown format was accepted")
+	}
+}
+
+func TestImporterDryRun(t *testing.T) {
+	ctx := context.Background()
+	importer, db := newImporter(ctx, t)
+
+	job, err := importer.Submit(ctx, Options{Format: FormatCSV, DryRun: true}, strings.NewReader(importFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	importer.Wait()
+	job, err = importer.jobs.Get(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusCompleted || job.Accepted != 4 || len(job.Preview) != 4 || job.Inserted != 0 {
+		t.Errorf("dry run job = %+v", job)
+	}
+	if got := confidences(t, db); len(got) != 0 {
+		t.Errorf("a dry run stored %v", got)
+	}
+}
+
+func TestImporterStopsWithItsContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	importer, db := newImporter(ctx, t)
+	jobs := importer.jobs
+
+	job, err := jobs.Create(context.Background(), Options{Format: FormatCSV, Confidence: new(int)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	importer.Run(ctx, job, strings.NewReader(importFile))
+
+	job, err = jobs.Get(context.Background(), job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusFailed || !strings.Contains(job.Error, "interrupted") || job.FinishedAt == nil {
+		t.Errorf("interrupted job = %+v, want it failed and finished", job)
+	}
+	if got := confidences(t, db); len(got) != 0 {
+		t.Errorf("an interrupted import stored %v", got)
+	}
+
+	// Once stopped, the importer takes no more files
+	if _, err := importer.Submit(context.Background(), Options{Format: FormatText}, strings.NewReader("evil.example.org\n")); err == nil {
+		t.Error("a stopped importer accepted an import")
+	}
+	importer.Wait()
+}
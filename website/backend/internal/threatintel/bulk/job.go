@@ -0,0 +1,158 @@
+package bulk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// Job statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// Options controls how an import file is interpreted. Values read from the
+// file take precedence over the defaults given here.
+type Options struct {
+	Format     string `json:"format"`
+	ClientID   string `json:"clientId,omitempty"`
+	Source     string `json:"source,omitempty"`
+	ThreatType string `json:"threatType,omitempty"`
+	// Confidence applies to rows without one; it defaults to 50
+	Confidence *int     `json:"confidence,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	DryRun     bool     `json:"dryRun"`
+}
+
+// RowError describes a row that was not imported
+type RowError struct {
+	Row   int    `json:"row"`
+	Value string `json:"value,omitempty"`
+	Error string `json:"error"`
+}
+
+// Job represents a row of deployment_mgmt.indicator_import_jobs
+type Job struct {
+	ID         string                `json:"jobId"`
+	Options    Options               `json:"options"`
+	Status     string                `json:"status"`
+	Rows       int                   `json:"rows"`
+	Accepted   int                   `json:"accepted"`
+	Duplicates int                   `json:"duplicates"`
+	Rejected   int                   `json:"rejected"`
+	Inserted   int                   `json:"inserted"`
+	Updated    int                   `json:"updated"`
+	Errors     []RowError            `json:"errors"`
+	Preview    []indicator.Indicator `json:"preview"`
+	Error      string                `json:"error,omitempty"`
+	CreatedAt  time.Time             `json:"createdAt"`
+	StartedAt  *time.Time            `json:"startedAt,omitempty"`
+	FinishedAt *time.Time            `json:"finishedAt,omitempty"`
+}
+
+// JobStore persists import jobs
+type JobStore struct {
+	db *sql.DB
+}
+
+// NewJobStore creates an import job store
+func NewJobStore(db *sql.DB) *JobStore {
+	return &JobStore{db: db}
+}
+
+// Create records a queued job for the given options
+func (s *JobStore) Create(ctx context.Context, opts Options) (*Job, error) {
+	options, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{
+		Options: opts,
+		Status:  StatusQueued,
+		Errors:  []RowError{},
+		Preview: []indicator.Indicator{},
+	}
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO deployment_mgmt.indicator_import_jobs (client_id, format, options, dry_run)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`,
+		sql.NullString{String: opts.ClientID, Valid: opts.ClientID != ""},
+		opts.Format, options, opts.DryRun).Scan(&job.ID, &job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Get loads a job
+func (s *JobStore) Get(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	var options, rowErrors, preview []byte
+	var jobErr sql.NullString
+	var started, finished sql.NullTime
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, options, status, rows_read, accepted, duplicates, rejected,
+			inserted, updated, row_errors, preview, error, created_at, started_at, finished_at
+		FROM deployment_mgmt.indicator_import_jobs
+		WHERE id = $1`, id).Scan(&job.ID, &options, &job.Status, &job.Rows, &job.Accepted,
+		&job.Duplicates, &job.Rejected, &job.Inserted, &job.Updated, &rowErrors, &preview,
+		&jobErr, &job.CreatedAt, &started, &finished)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(options, &job.Options); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(rowErrors, &job.Errors); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(preview, &job.Preview); err != nil {
+		return nil, err
+	}
+	job.Error = jobErr.String
+	if started.Valid {
+		job.StartedAt = &started.Time
+	}
+	if finished.Valid {
+		job.FinishedAt = &finished.Time
+	}
+	return &job, nil
+}
+
+// Start marks a job as running
+func (s *JobStore) Start(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.indicator_import_jobs
+		SET status = $2, started_at = NOW()
+		WHERE id = $1`, id, StatusRunning)
+	return err
+}
+
+// Save writes the status, counters, errors and preview of a job
+func (s *JobStore) Save(ctx context.Context, job *Job) error {
+	rowErrors, err := json.Marshal(job.Errors)
+	if err != nil {
+		return err
+	}
+	preview, err := json.Marshal(job.Preview)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.indicator_import_jobs
+		SET status = $2, rows_read = $3, accepted = $4, duplicates = $5, rejected = $6,
+			inserted = $7, updated = $8, row_errors = $9, preview = $10,
+			error = NULLIF($11, ''),
+			finished_at = CASE WHEN $2 IN ('completed', 'failed') THEN NOW() END
+		WHERE id = $1`, job.ID, job.Status, job.Rows, job.Accepted, job.Duplicates,
+		job.Rejected, job.Inserted, job.Updated, rowErrors, preview, job.Error)
+	return err
+}
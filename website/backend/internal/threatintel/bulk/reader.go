@@ -0,0 +1,343 @@
+package bulk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/stix"
+)
+
+// Import formats
+const (
+	FormatCSV  = "csv"
+	FormatText = "text"
+	FormatSTIX = "stix"
+	FormatMDE  = "mde"
+)
+
+// Row is one entry read from an import file. The indicator type is left as
+// written in the file and resolved when the indicator is normalized.
+type Row struct {
+	Number    int
+	Indicator indicator.Indicator
+	// HasConfidence is set when the file gave the indicator's confidence,
+	// which may be 0; other rows get the import's default
+	HasConfidence bool
+	// Err is set when the row cannot be interpreted; the import carries on
+	Err error
+}
+
+// Reader reads the rows of an import file one at a time
+type Reader interface {
+	// Next returns the next row, or io.EOF after the last one. Any other
+	// error means the file cannot be read further.
+	Next() (*Row, error)
+}
+
+// NewReader creates a reader for one of the import formats
+func NewReader(format string, r io.Reader) (Reader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r, csvColumns, nil)
+	case FormatMDE:
+		return newCSVReader(r, mdeColumns, checkMDERow)
+	case FormatText:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+		return &textReader{scanner: scanner}, nil
+	case FormatSTIX:
+		return &stixReader{bundle: stix.NewBundleReader(r)}, nil
+	}
+	return nil, fmt.Errorf("unsupported import format %q", format)
+}
+
+// maxLineLength bounds a single line of a text or CSV import
+const maxLineLength = 1 << 20
+
+// csvColumns maps header names, compared after lowercasing and removing
+// spaces, underscores and dashes, to indicator fields
+var csvColumns = map[string]string{
+	"value":          "value",
+	"indicator":      "value",
+	"indicatorvalue": "value",
+	"ioc":            "value",
+	"observable":     "value",
+	"type":           "type",
+	"indicatortype":  "type",
+	"threattype":     "threatType",
+	"category":       "threatType",
+	"confidence":     "confidence",
+	"description":    "description",
+	"tags":           "tags",
+	"labels":         "tags",
+	"expiration":     "expiration",
+	"expirationdate": "expiration",
+	"expires":        "expiration",
+	"validuntil":     "expiration",
+}
+
+// mdeColumns maps the columns of the Defender for Endpoint indicator import
+// template to indicator fields
+var mdeColumns = map[string]string{
+	"indicatortype":   "type",
+	"indicatorvalue":  "value",
+	"expirationtime":  "expiration",
+	"action":          "action",
+	"severity":        "severity",
+	"title":           "title",
+	"description":     "description",
+	"category":        "threatType",
+	"mitretechniques": "tags",
+}
+
+// mdeSeverityConfidence converts MDE alert severities into confidence scores
+var mdeSeverityConfidence = map[string]int{
+	"informational": 25,
+	"low":           50,
+	"medium":        70,
+	"high":          90,
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	check   func(get func(string) string) error
+	row     int
+}
+
+func newCSVReader(r io.Reader, names map[string]string, check func(get func(string) string) error) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		key := strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+		// A UTF-8 byte order mark is common in spreadsheet exports
+		key = strings.TrimPrefix(key, "\ufeff")
+		if field, ok := names[key]; ok {
+			if _, dup := columns[field]; !dup {
+				columns[field] = i
+			}
+		}
+	}
+	if _, ok := columns["value"]; !ok {
+		return nil, fmt.Errorf("csv header has no indicator value column")
+	}
+	return &csvReader{reader: reader, columns: columns, check: check, row: 1}, nil
+}
+
+func (c *csvReader) Next() (*Row, error) {
+	for {
+		record, err := c.reader.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		c.row++
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				return &Row{Number: c.row, Err: err}, nil
+			}
+			return nil, err
+		}
+
+		get := func(field string) string {
+			if i, ok := c.columns[field]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		value := get("value")
+		if value == "" {
+			continue
+		}
+
+		row := &Row{Number: c.row}
+		if c.check != nil {
+			if err := c.check(get); err != nil {
+				row.Indicator.Value = value
+				row.Err = err
+				return row, nil
+			}
+		}
+		row.Indicator, row.HasConfidence, row.Err = buildIndicator(get)
+		return row, nil
+	}
+}
+
+// buildIndicator creates an indicator from the mapped fields of one record
+// and reports whether the record gave its confidence
+func buildIndicator(get func(field string) string) (indicator.Indicator, bool, error) {
+	ind := indicator.Indicator{
+		Type:        indicator.Type(get("type")),
+		Value:       get("value"),
+		ThreatType:  get("threatType"),
+		Description: get("description"),
+	}
+	if ind.Description == "" {
+		ind.Description = get("title")
+	}
+
+	var hasConfidence bool
+	if v := get("confidence"); v != "" {
+		confidence, err := strconv.Atoi(v)
+		if err != nil || confidence < 0 || confidence > 100 {
+			return ind, false, fmt.Errorf("confidence must be a number between 0 and 100")
+		}
+		ind.Confidence, hasConfidence = confidence, true
+	} else if v := get("severity"); v != "" {
+		ind.Confidence, hasConfidence = mdeSeverityConfidence[strings.ToLower(v)]
+	}
+
+	if v := get("tags"); v != "" {
+		for _, tag := range strings.FieldsFunc(v, func(r rune) bool { return r == ';' || r == '|' || r == ',' }) {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				ind.Tags = append(ind.Tags, tag)
+			}
+		}
+	}
+
+	if v := get("expiration"); v != "" {
+		expires, err := parseTime(v)
+		if err != nil {
+			return ind, hasConfidence, err
+		}
+		ind.ExpiresAt = &expires
+	}
+	return ind, hasConfidence, nil
+}
+
+// checkMDERow rejects MDE rows that do not describe threats
+func checkMDERow(get func(string) string) error {
+	switch strings.ToLower(get("action")) {
+	case "allowed", "allow":
+		return fmt.Errorf("allow indicators are not threat indicators")
+	}
+	return nil
+}
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"1/2/2006 15:04",
+	"1/2/2006",
+}
+
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized expiration time %q", s)
+}
+
+// textReader reads one indicator per line. Blank lines and lines starting
+// with # or ; are skipped, as is anything after the first whitespace.
+type textReader struct {
+	scanner *bufio.Scanner
+	row     int
+}
+
+func (t *textReader) Next() (*Row, error) {
+	for t.scanner.Scan() {
+		t.row++
+		line := strings.TrimSpace(strings.TrimPrefix(t.scanner.Text(), "\ufeff"))
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		return &Row{
+			Number:    t.row,
+			Indicator: indicator.Indicator{Value: strings.Fields(line)[0]},
+		}, nil
+	}
+	if err := t.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// stixReader emits one row per observable of each indicator in a bundle.
+// Row numbers are the position of the object within the bundle.
+type stixReader struct {
+	bundle  *stix.BundleReader
+	object  int
+	pending []*Row
+}
+
+func (s *stixReader) Next() (*Row, error) {
+	for len(s.pending) == 0 {
+		raw, err := s.bundle.Next()
+		if err != nil {
+			return nil, err
+		}
+		s.object++
+		s.pending = s.rows(raw)
+	}
+	row := s.pending[0]
+	s.pending = s.pending[1:]
+	return row, nil
+}
+
+func (s *stixReader) rows(raw json.RawMessage) []*Row {
+	objectType, err := stix.ObjectType(raw)
+	if err != nil {
+		return []*Row{{Number: s.object, Err: err}}
+	}
+	if objectType != "indicator" {
+		return nil
+	}
+
+	var object stix.Indicator
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return []*Row{{Number: s.object, Err: fmt.Errorf("malformed indicator: %v", err)}}
+	}
+	if object.Revoked {
+		return nil
+	}
+	if object.PatternType != "stix" {
+		return []*Row{{Number: s.object, Err: fmt.Errorf("unsupported pattern type %q", object.PatternType)}}
+	}
+	observables, err := indicator.FromPattern(object.Pattern)
+	if err != nil {
+		return []*Row{{Number: s.object, Indicator: indicator.Indicator{Value: object.Pattern}, Err: err}}
+	}
+
+	var rows []*Row
+	for _, o := range observables {
+		ind := indicator.Indicator{
+			Type:        o.Type,
+			Value:       o.Value,
+			ExternalID:  object.ID,
+			Description: object.Description,
+			Tags:        object.Labels,
+			ExpiresAt:   object.ValidUntil,
+		}
+		if ind.Description == "" {
+			ind.Description = object.Name
+		}
+		if len(object.IndicatorTypes) > 0 {
+			ind.ThreatType = object.IndicatorTypes[0]
+		}
+		if object.Confidence != nil {
+			ind.Confidence = *object.Confidence
+		}
+		rows = append(rows, &Row{Number: s.object, Indicator: ind, HasConfidence: object.Confidence != nil})
+	}
+	return rows
+}
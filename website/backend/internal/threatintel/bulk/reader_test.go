@@ -0,0 +1,124 @@
+package bulk
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// readAll reads every row of an import file
+func readAll(t *testing.T, format, file string) []*Row {
+	t.Helper()
+	reader, err := NewReader(format, strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []*Row
+	for {
+		row, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return rows
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	rows := readAll(t, FormatCSV, "\ufeffIndicator Value,Indicator_Type,Confidence,Labels,Expires\n"+
+		"45.33.32.156,ipv4,90,c2;botnet,2026-12-31\n"+
+		"evil.example.org,,0,,\n"+
+		",domain,10,,\n"+
+		"evil.example.net,,,,\n"+
+		"evil.example.com,,high,,\n"+
+		"evil.example.info,,,,someday\n")
+
+	if len(rows) != 5 {
+		t.Fatalf("read %d rows, want 5 with values", len(rows))
+	}
+	first := rows[0]
+	if first.Number != 2 || first.Err != nil || first.Indicator.Value != "45.33.32.156" || first.Indicator.Type != "ipv4" ||
+		first.Indicator.Confidence != 90 || !first.HasConfidence || len(first.Indicator.Tags) != 2 ||
+		first.Indicator.ExpiresAt == nil {
+		t.Errorf("first row = %+v", first)
+	}
+	// An explicit 0 is a confidence; an empty column is not
+	if !rows[1].HasConfidence || rows[1].Indicator.Confidence != 0 {
+		t.Errorf("row with confidence 0 = %+v, want it kept", rows[1])
+	}
+	if rows[2].HasConfidence || rows[2].Number != 5 {
+		t.Errorf("row without confidence = %+v", rows[2])
+	}
+	if rows[3].Err == nil || rows[4].Err == nil {
+		t.Errorf("rows with an invalid confidence or expiry were accepted: %+v, %+v", rows[3], rows[4])
+	}
+}
+
+func TestCSVReaderNeedsValueColumn(t *testing.T) {
+	if _, err := NewReader(FormatCSV, strings.NewReader("type,confidence\nipv4,90\n")); err == nil {
+		t.Error("a CSV file without a value column was accepted")
+	}
+}
+
+func TestMDEReader(t *testing.T) {
+	rows := readAll(t, FormatMDE, "IndicatorType,IndicatorValue,ExpirationTime,Action,Severity,Title,Description,Category\n"+
+		"DomainName,evil.example.org,,Block,High,Phishing kit,,Phishing\n"+
+		"IpAddress,45.33.32.156,,Allowed,Low,Scanner,,\n"+
+		"FileSha256,e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855,,Audit,Unknown,,,\n")
+
+	if len(rows) != 3 {
+		t.Fatalf("read %d rows, want 3", len(rows))
+	}
+	ind := rows[0].Indicator
+	if rows[0].Err != nil || ind.Confidence != 90 || !rows[0].HasConfidence || ind.Description != "Phishing kit" ||
+		ind.ThreatType != "Phishing" {
+		t.Errorf("blocked domain = %+v", rows[0])
+	}
+	if rows[1].Err == nil {
+		t.Errorf("allow indicator = %+v, want it rejected", rows[1])
+	}
+	if rows[2].HasConfidence {
+		t.Errorf("row with an unknown severity = %+v, want the import's confidence", rows[2])
+	}
+}
+
+func TestTextReader(t *testing.T) {
+	rows := readAll(t, FormatText, "# blocklist\n\n45.33.32.156 scanner\n; comment\n  evil.example.org\n")
+	if len(rows) != 2 || rows[0].Number != 3 || rows[0].Indicator.Value != "45.33.32.156" ||
+		rows[1].Number != 5 || rows[1].Indicator.Value != "evil.example.org" {
+		t.Errorf("rows = %+v %+v", rows[0], rows[1])
+	}
+}
+
+func TestSTIXReader(t *testing.T) {
+	rows := readAll(t, FormatSTIX, `{"type": "bundle", "id": "bundle--1", "objects": [
+		{"type": "identity", "id": "identity--1", "name": "ACME"},
+		{"type": "indicator", "id": "indicator--1", "pattern_type": "stix", "confidence": 0,
+			"pattern": "[ipv4-addr:value = '45.33.32.156'] OR [domain-name:value = 'evil.example.org']",
+			"indicator_types": ["malicious-activity"], "labels": ["apt"]},
+		{"type": "indicator", "id": "indicator--2", "pattern_type": "stix", "revoked": true,
+			"pattern": "[ipv4-addr:value = '45.33.32.157']"},
+		{"type": "indicator", "id": "indicator--3", "pattern_type": "sigma", "pattern": "title: x"},
+		{"type": "indicator", "id": "indicator--4", "pattern_type": "stix",
+			"pattern": "[url:value = 'http://evil.example.org/a']"}
+	]}`)
+
+	if len(rows) != 4 {
+		t.Fatalf("read %d rows, want 4: %+v", len(rows), rows)
+	}
+	for _, row := range rows[:2] {
+		if row.Number != 2 || row.Err != nil || !row.HasConfidence || row.Indicator.Confidence != 0 ||
+			row.Indicator.ExternalID != "indicator--1" || row.Indicator.ThreatType != "malicious-activity" {
+			t.Errorf("observable of indicator--1 = %+v", row)
+		}
+	}
+	if rows[2].Number != 4 || rows[2].Err == nil {
+		t.Errorf("sigma indicator = %+v, want an error", rows[2])
+	}
+	if rows[3].Number != 5 || rows[3].HasConfidence {
+		t.Errorf("indicator without confidence = %+v", rows[3])
+	}
+}
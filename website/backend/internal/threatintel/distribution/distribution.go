@@ -0,0 +1,74 @@
+package distribution
+
+import (
+	"context"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// Targets indicators can be distributed to. The names match the
+// DeploymentTargets of cti/PowerShell/CTI-Config.json.
+const (
+	TargetSentinel     = "Sentinel"
+	TargetMDE          = "MDE"
+	TargetEntraID      = "EntraID"
+	TargetExchangeTABL = "ExchangeTABL"
+	TargetMDCA         = "MDCA"
+)
+
+// Sync states of an indicator on a target
+const (
+	StatePending  = "pending"
+	StateSynced   = "synced"
+	StateFailed   = "failed"
+	StateRemoving = "removing"
+	StateRemoved  = "removed"
+)
+
+// Tenant is the client environment a connector delivers to
+type Tenant struct {
+	ClientID string
+	TenantID string
+	Tokens   azure.TokenProvider
+	// Settings holds the client's connection settings for the target, such
+	// as the Sentinel workspace ID or the MDCA portal URL
+	Settings map[string]string
+}
+
+// Item is an indicator being published to or removed from a target
+type Item struct {
+	Indicator *indicator.Indicator
+	// ExternalID is the target's identifier from the last successful publish
+	ExternalID string
+}
+
+// Outcome reports the result of publishing or removing one item
+type Outcome struct {
+	// ExternalID is the target's identifier for a published item
+	ExternalID string
+	Err        error
+}
+
+// Connector delivers indicators to one kind of target
+type Connector interface {
+	// Target returns the name of the target, one of the Target constants
+	Target() string
+	// Supports reports whether the target can hold indicators of a type
+	Supports(t indicator.Type) bool
+	// Publish creates or updates items on the target and returns an
+	// outcome per item, in order. An error means no item was delivered.
+	Publish(ctx context.Context, tenant *Tenant, items []Item) ([]Outcome, error)
+	// Remove deletes items from the target. Items that are already gone
+	// count as removed.
+	Remove(ctx context.Context, tenant *Tenant, items []Item) ([]Outcome, error)
+}
+
+// failAll returns the same failed outcome for every item
+func failAll(items []Item, err error) []Outcome {
+	outcomes := make([]Outcome, len(items))
+	for i := range outcomes {
+		outcomes[i].Err = err
+	}
+	return outcomes
+}
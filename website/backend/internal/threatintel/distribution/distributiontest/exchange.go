@@ -0,0 +1,121 @@
+package distributiontest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// BlockEntry is a Tenant Allow/Block List entry held by ExchangeServer
+type BlockEntry struct {
+	ListType       string
+	Value          string
+	ExpirationDate string
+	Notes          string
+}
+
+// ExchangeServer is a fake of the Exchange Online admin API that supports
+// the Tenant Allow/Block List cmdlets
+type ExchangeServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	entries map[string]BlockEntry
+	// Reject lists entry values the fake reports as invalid
+	Reject []string
+}
+
+// NewExchangeServer starts a fake server; call Close when done
+func NewExchangeServer() *ExchangeServer {
+	s := &ExchangeServer{entries: make(map[string]BlockEntry)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Entries returns the block list entries, keyed by list type and value
+func (s *ExchangeServer) Entries() map[string]BlockEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]BlockEntry, len(s.entries))
+	for key, e := range s.entries {
+		out[key] = e
+	}
+	return out
+}
+
+func (s *ExchangeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/adminapi/beta/") ||
+		!strings.HasSuffix(r.URL.Path, "/InvokeCommand") {
+		writeError(w, http.StatusNotFound, "NotFound", "no route for "+r.URL.Path)
+		return
+	}
+
+	var req struct {
+		CmdletInput struct {
+			CmdletName string `json:"CmdletName"`
+			Parameters struct {
+				ListType       string   `json:"ListType"`
+				Block          bool     `json:"Block"`
+				Entries        []string `json:"Entries"`
+				ExpirationDate string   `json:"ExpirationDate"`
+				NoExpiration   bool     `json:"NoExpiration"`
+				Notes          string   `json:"Notes"`
+			} `json:"Parameters"`
+		} `json:"CmdletInput"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	params := req.CmdletInput.Parameters
+	switch params.ListType {
+	case "Url", "Sender", "FileHash":
+	default:
+		writeError(w, http.StatusBadRequest, "BadRequest", "invalid ListType "+params.ListType)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch req.CmdletInput.CmdletName {
+	case "New-TenantAllowBlockListItems":
+		if !params.Block {
+			writeError(w, http.StatusBadRequest, "BadRequest", "only block entries are supported")
+			return
+		}
+		if params.ExpirationDate == "" && !params.NoExpiration {
+			writeError(w, http.StatusBadRequest, "BadRequest", "ExpirationDate or NoExpiration is required")
+			return
+		}
+		for _, v := range params.Entries {
+			for _, bad := range s.Reject {
+				if v == bad {
+					writeError(w, http.StatusBadRequest, "BadRequest", "Entry "+v+" is invalid")
+					return
+				}
+			}
+		}
+		for _, v := range params.Entries {
+			key := params.ListType + ":" + v
+			if _, ok := s.entries[key]; ok {
+				writeError(w, http.StatusBadRequest, "BadRequest", "Entry "+v+" already exists")
+				return
+			}
+			s.entries[key] = BlockEntry{ListType: params.ListType, Value: v,
+				ExpirationDate: params.ExpirationDate, Notes: params.Notes}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"value": params.Entries})
+	case "Remove-TenantAllowBlockListItems":
+		for _, v := range params.Entries {
+			delete(s.entries, params.ListType+":"+v)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"value": []string{}})
+	default:
+		writeError(w, http.StatusBadRequest, "BadRequest", "unsupported cmdlet "+req.CmdletInput.CmdletName)
+	}
+}
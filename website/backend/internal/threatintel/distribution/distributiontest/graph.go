@@ -0,0 +1,112 @@
+package distributiontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+const namedLocationsPath = "/v1.0/identity/conditionalAccess/namedLocations"
+
+// NamedLocation is an IP named location held by GraphServer
+type NamedLocation struct {
+	ID          string   `json:"id"`
+	DisplayName string   `json:"displayName"`
+	IsTrusted   bool     `json:"isTrusted"`
+	CIDRs       []string `json:"-"`
+}
+
+// GraphServer is a fake of the Microsoft Graph named locations API
+type GraphServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	nextID    int
+	locations map[string]NamedLocation
+	// Reject lists CIDR address substrings the fake reports as invalid
+	Reject []string
+}
+
+// NewGraphServer starts a fake server; call Close when done
+func NewGraphServer() *GraphServer {
+	s := &GraphServer{locations: make(map[string]NamedLocation)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// NamedLocations returns the named locations, keyed by ID
+func (s *GraphServer) NamedLocations() map[string]NamedLocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]NamedLocation, len(s.locations))
+	for id, loc := range s.locations {
+		out[id] = loc
+	}
+	return out
+}
+
+func (s *GraphServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == namedLocationsPath:
+		s.create(w, r)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, namedLocationsPath+"/"):
+		id := strings.TrimPrefix(r.URL.Path, namedLocationsPath+"/")
+		s.mu.Lock()
+		_, ok := s.locations[id]
+		delete(s.locations, id)
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "Request_ResourceNotFound", "named location not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotFound, "Request_ResourceNotFound", "no route for "+r.URL.Path)
+	}
+}
+
+func (s *GraphServer) create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Type        string `json:"@odata.type"`
+		DisplayName string `json:"displayName"`
+		IsTrusted   bool   `json:"isTrusted"`
+		IPRanges    []struct {
+			CIDRAddress string `json:"cidrAddress"`
+		} `json:"ipRanges"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	if req.Type != "#microsoft.graph.ipNamedLocation" || req.DisplayName == "" || len(req.IPRanges) == 0 {
+		writeError(w, http.StatusBadRequest, "BadRequest", "an IP named location needs a name and ranges")
+		return
+	}
+	loc := NamedLocation{DisplayName: req.DisplayName, IsTrusted: req.IsTrusted}
+	for _, ipRange := range req.IPRanges {
+		if !strings.Contains(ipRange.CIDRAddress, "/") {
+			writeError(w, http.StatusBadRequest, "BadRequest", "cidrAddress must be in CIDR notation")
+			return
+		}
+		for _, bad := range s.Reject {
+			if strings.Contains(ipRange.CIDRAddress, bad) {
+				writeError(w, http.StatusBadRequest, "BadRequest", "invalid cidrAddress "+ipRange.CIDRAddress)
+				return
+			}
+		}
+		loc.CIDRs = append(loc.CIDRs, ipRange.CIDRAddress)
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	loc.ID = fmt.Sprintf("00000000-0000-0000-0000-%012d", s.nextID)
+	s.locations[loc.ID] = loc
+	s.mu.Unlock()
+	writeJSON(w, http.StatusCreated, loc)
+}
@@ -0,0 +1,100 @@
+package distributiontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// IPRange is an IP address range held by MDCAServer
+type IPRange struct {
+	ID       string
+	Name     string
+	Category int
+	Subnets  []string
+	Tags     []string
+}
+
+// MDCAServer is a fake of the Defender for Cloud Apps IP range API. Use
+// its URL as the tenant's portalUrl setting.
+type MDCAServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	nextID int
+	ranges map[string]IPRange
+}
+
+// NewMDCAServer starts a fake server; call Close when done
+func NewMDCAServer() *MDCAServer {
+	s := &MDCAServer{ranges: make(map[string]IPRange)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Ranges returns the IP ranges, keyed by ID
+func (s *MDCAServer) Ranges() map[string]IPRange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]IPRange, len(s.ranges))
+	for id, rng := range s.ranges {
+		out[id] = rng
+	}
+	return out
+}
+
+func (s *MDCAServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/subnet/create_rule/":
+		s.create(w, r)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/v1/subnet/"):
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/subnet/"), "/")
+		s.mu.Lock()
+		_, ok := s.ranges[id]
+		delete(s.ranges, id)
+		s.mu.Unlock()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Not found."})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"deleted": id})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Not found."})
+	}
+}
+
+func (s *MDCAServer) create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string   `json:"name"`
+		Category int      `json:"category"`
+		Subnets  []string `json:"subnets"`
+		Tags     []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+		return
+	}
+	if req.Name == "" || len(req.Subnets) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": "name and subnets are required"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rng := range s.ranges {
+		if rng.Name == req.Name {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"detail": "a range named " + req.Name + " already exists"})
+			return
+		}
+	}
+	s.nextID++
+	id := fmt.Sprintf("%024x", s.nextID)
+	s.ranges[id] = IPRange{ID: id, Name: req.Name, Category: req.Category, Subnets: req.Subnets, Tags: req.Tags}
+	writeJSON(w, http.StatusOK, id)
+}
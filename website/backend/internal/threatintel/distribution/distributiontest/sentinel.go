@@ -0,0 +1,101 @@
+package distributiontest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// SentinelServer is a fake Sentinel threat intelligence upload API. It
+// keeps the latest version of every uploaded STIX object per workspace.
+type SentinelServer struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	workspaces map[string]map[string]json.RawMessage
+	// Reject lists STIX pattern substrings the fake reports as invalid
+	Reject []string
+}
+
+// NewSentinelServer starts a fake server; call Close when done
+func NewSentinelServer() *SentinelServer {
+	s := &SentinelServer{workspaces: make(map[string]map[string]json.RawMessage)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Objects returns the objects uploaded to a workspace, keyed by STIX ID
+func (s *SentinelServer) Objects(workspace string) map[string]json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]json.RawMessage)
+	for id, obj := range s.workspaces[workspace] {
+		out[id] = obj
+	}
+	return out
+}
+
+func (s *SentinelServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	workspace := strings.TrimSuffix(strings.Trim(r.URL.Path, "/"), "/threatintelligence:upload-indicators")
+	if r.Method != http.MethodPost || workspace == strings.Trim(r.URL.Path, "/") || strings.Contains(workspace, "/") {
+		writeError(w, http.StatusNotFound, "NotFound", "no route for "+r.URL.Path)
+		return
+	}
+
+	var req struct {
+		SourceSystem string            `json:"sourcesystem"`
+		Indicators   []json.RawMessage `json:"indicators"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	if req.SourceSystem == "" {
+		writeError(w, http.StatusBadRequest, "BadRequest", "sourcesystem is required")
+		return
+	}
+	if len(req.Indicators) > 100 {
+		writeError(w, http.StatusBadRequest, "BadRequest", "at most 100 indicators per request")
+		return
+	}
+
+	type recordError struct {
+		RecordIndex   int      `json:"recordIndex"`
+		ErrorMessages []string `json:"errorMessages"`
+	}
+	errors := []recordError{}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.workspaces[workspace] == nil {
+		s.workspaces[workspace] = make(map[string]json.RawMessage)
+	}
+	for i, raw := range req.Indicators {
+		var obj struct {
+			Type    string `json:"type"`
+			ID      string `json:"id"`
+			Pattern string `json:"pattern"`
+		}
+		if err := json.Unmarshal(raw, &obj); err != nil || obj.Type != "indicator" || obj.ID == "" {
+			errors = append(errors, recordError{i, []string{"not a STIX indicator"}})
+			continue
+		}
+		rejected := false
+		for _, bad := range s.Reject {
+			if strings.Contains(obj.Pattern, bad) {
+				rejected = true
+			}
+		}
+		if rejected {
+			errors = append(errors, recordError{i, []string{"invalid pattern"}})
+			continue
+		}
+		s.workspaces[workspace][obj.ID] = raw
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"errors": errors})
+}
@@ -0,0 +1,32 @@
+// Package distributiontest provides in-process fakes of the Sentinel,
+// Microsoft Graph, Exchange Online and Defender for Cloud Apps APIs used by
+// the distribution connectors. The Defender for Endpoint fake lives in
+// defender/defendertest.
+package distributiontest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// authorized rejects requests without a bearer token
+func authorized(w http.ResponseWriter, r *http.Request) bool {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeError(w, http.StatusUnauthorized, "Unauthorized", "missing bearer token")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
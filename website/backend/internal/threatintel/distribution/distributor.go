@@ -0,0 +1,365 @@
+package distribution
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+const (
+	defaultSyncInterval = time.Minute
+	defaultBatchSize    = 100
+	// planBatchSize is how many indicators are planned per transaction
+	planBatchSize = 1000
+	// planOverlap re-reads recently changed indicators on every run so rows
+	// committed out of order are not missed; planning them twice is a no-op
+	planOverlap = time.Minute
+)
+
+// CredentialSource gives access to client tenants
+type CredentialSource interface {
+	Credentials(ctx context.Context, clientID string) (*azure.Credentials, error)
+	TokenProvider(ctx context.Context, clientID string) (azure.TokenProvider, error)
+}
+
+// Distributor keeps the indicators on each client target in line with the
+// routing rules. Every run it plans the indicators changed since the last
+// run, turns queued expiries into removals and delivers what is due.
+type Distributor struct {
+	store       *Store
+	indicators  *indicator.Store
+	credentials CredentialSource
+	connectors  map[string]Connector
+	batchSize   int
+	interval    time.Duration
+
+	// mu serialises planning and claiming and guards the plan cursor and
+	// connectors. Claimed items are leased, so delivery runs unlocked.
+	mu      sync.Mutex
+	planned time.Time
+}
+
+// NewDistributor creates a distributor with a connector for every target
+func NewDistributor(store *Store, indicators *indicator.Store, credentials CredentialSource) *Distributor {
+	client := &http.Client{Timeout: 60 * time.Second}
+	d := &Distributor{
+		store:       store,
+		indicators:  indicators,
+		credentials: credentials,
+		connectors:  make(map[string]Connector),
+		batchSize:   defaultBatchSize,
+		interval:    defaultSyncInterval,
+	}
+	d.RegisterConnector(NewSentinelConnector(client))
+	d.RegisterConnector(NewMDEConnector(client))
+	d.RegisterConnector(NewEntraConnector(client))
+	d.RegisterConnector(NewExchangeConnector(client))
+	d.RegisterConnector(NewMDCAConnector(client))
+	return d
+}
+
+// RegisterConnector adds or replaces the connector for its target
+func (d *Distributor) RegisterConnector(c Connector) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.connectors[c.Target()] = c
+}
+
+// Store returns the distributor's state store
+func (d *Distributor) Store() *Store {
+	return d.store
+}
+
+// Replan makes the next run plan every indicator again, for example after
+// routes or target configuration changed
+func (d *Distributor) Replan() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.planned = time.Time{}
+}
+
+// Run syncs periodically until the context is cancelled
+func (d *Distributor) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Sync(ctx, Scope{}); err != nil {
+			log.Printf("Error distributing threat indicators: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync plans changed indicators and delivers everything due in scope.
+// Failed items in a scope given explicitly are retried.
+func (d *Distributor) Sync(ctx context.Context, scope Scope) error {
+	if err := d.prepare(ctx, scope); err != nil {
+		return err
+	}
+	return d.deliver(ctx, scope)
+}
+
+// prepare retries failed items in an explicit scope, turns queued expiries
+// into removals and plans changed indicators
+func (d *Distributor) prepare(ctx context.Context, scope Scope) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if scope != (Scope{}) {
+		if _, err := d.store.Retry(ctx, scope); err != nil {
+			return fmt.Errorf("failed to retry failed deliveries: %v", err)
+		}
+	}
+	if _, err := d.store.DrainQueue(ctx); err != nil {
+		return fmt.Errorf("failed to drain distribution queue: %v", err)
+	}
+	if err := d.plan(ctx); err != nil {
+		return fmt.Errorf("failed to plan distribution: %v", err)
+	}
+	return nil
+}
+
+// plan assigns the indicators changed since the last run to client targets
+func (d *Distributor) plan(ctx context.Context) error {
+	routes, err := d.store.Routes(ctx, "")
+	if err != nil {
+		return err
+	}
+	router := NewRouter(routes)
+	targets, err := d.store.EnabledTargets(ctx)
+	if err != nil {
+		return err
+	}
+	allow, err := d.indicators.AllowList(ctx)
+	if err != nil {
+		return err
+	}
+
+	filter := indicator.Filter{IncludeInactive: true}
+	if !d.planned.IsZero() {
+		filter.UpdatedAfter = d.planned.Add(-planOverlap)
+	}
+	cursor := d.planned
+
+	var ids []string
+	var desired []Assignment
+	flush := func() error {
+		err := d.store.Plan(ctx, ids, desired)
+		ids, desired = ids[:0], desired[:0]
+		return err
+	}
+
+	err = d.indicators.ForEach(ctx, filter, func(ind *indicator.Indicator) error {
+		if ind.UpdatedAt.After(cursor) {
+			cursor = ind.UpdatedAt
+		}
+		ids = append(ids, ind.ID)
+		if ind.IsActive {
+			desired = append(desired, d.assign(router, allow, targets, ind)...)
+		}
+		if len(ids) >= planBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	d.planned = cursor
+	return nil
+}
+
+// assign returns the client targets an indicator belongs on. Global
+// indicators go to every client with a target configured, except clients
+// whose own allow-list covers them.
+func (d *Distributor) assign(router *Router, allow *indicator.AllowList,
+	targets map[string]map[string]map[string]string, ind *indicator.Indicator) []Assignment {
+
+	var assignments []Assignment
+	add := func(clientID string, enabled map[string]map[string]string) {
+		if _, ok := allow.MatchClient(clientID, ind); ok {
+			return
+		}
+		for _, target := range router.Targets(clientID, ind) {
+			c, ok := d.connectors[target]
+			if _, on := enabled[target]; !on || !ok || !c.Supports(ind.Type) {
+				continue
+			}
+			assignments = append(assignments, Assignment{
+				IndicatorID: ind.ID,
+				ClientID:    clientID,
+				Target:      target,
+				PlannedAt:   ind.UpdatedAt,
+			})
+		}
+	}
+
+	if ind.ClientID != "" {
+		add(ind.ClientID, targets[ind.ClientID])
+		return assignments
+	}
+	for clientID, enabled := range targets {
+		add(clientID, enabled)
+	}
+	return assignments
+}
+
+type deliveryKey struct {
+	clientID string
+	target   string
+	status   string
+}
+
+// claim takes the next batch of due items in scope, along with the
+// connectors to deliver them with
+func (d *Distributor) claim(ctx context.Context, scope Scope) ([]Claim, map[string]Connector, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	claims, err := d.store.Claim(ctx, scope, d.batchSize)
+	if err != nil || len(claims) == 0 {
+		return nil, nil, err
+	}
+	connectors := make(map[string]Connector, len(d.connectors))
+	for target, c := range d.connectors {
+		connectors[target] = c
+	}
+	return claims, connectors, nil
+}
+
+// deliver publishes and removes due items in scope until none are left
+func (d *Distributor) deliver(ctx context.Context, scope Scope) error {
+	for {
+		claims, connectors, err := d.claim(ctx, scope)
+		if err != nil {
+			return err
+		}
+		if len(claims) == 0 {
+			return nil
+		}
+
+		settings, err := d.store.EnabledTargets(ctx)
+		if err != nil {
+			return err
+		}
+
+		ids := make([]string, len(claims))
+		for i, c := range claims {
+			ids[i] = c.IndicatorID
+		}
+		indicators := make(map[string]*indicator.Indicator, len(claims))
+		err = d.indicators.ForEach(ctx, indicator.Filter{IDs: ids, IncludeInactive: true},
+			func(ind *indicator.Indicator) error {
+				indicators[ind.ID] = ind
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+
+		groups := make(map[deliveryKey][]Claim)
+		var order []deliveryKey
+		for _, c := range claims {
+			key := deliveryKey{c.ClientID, c.Target, c.Status}
+			if _, ok := groups[key]; !ok {
+				order = append(order, key)
+			}
+			groups[key] = append(groups[key], c)
+		}
+
+		tenants := make(map[string]*Tenant)
+		for _, key := range order {
+			group := groups[key]
+			outcomes := d.deliverGroup(ctx, connectors[key.target], key, group, indicators, settings, tenants)
+			for i, c := range group {
+				if err := d.store.Record(ctx, c, outcomes[i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// deliverGroup sends one batch of publishes or removals to a client target
+func (d *Distributor) deliverGroup(ctx context.Context, connector Connector, key deliveryKey, group []Claim,
+	indicators map[string]*indicator.Indicator, settings map[string]map[string]map[string]string,
+	tenants map[string]*Tenant) []Outcome {
+
+	outcomes := make([]Outcome, len(group))
+	fail := func(err error) []Outcome {
+		for i := range outcomes {
+			outcomes[i].Err = err
+		}
+		return outcomes
+	}
+
+	if connector == nil {
+		return fail(fmt.Errorf("no connector for target %s", key.target))
+	}
+
+	tenant, ok := tenants[key.clientID]
+	if !ok {
+		tenant = &Tenant{ClientID: key.clientID}
+		creds, err := d.credentials.Credentials(ctx, key.clientID)
+		if err != nil {
+			return fail(err)
+		}
+		tenant.TenantID = creds.TenantID
+		if tenant.Tokens, err = d.credentials.TokenProvider(ctx, key.clientID); err != nil {
+			return fail(err)
+		}
+		tenants[key.clientID] = tenant
+	}
+	// Removals are attempted even if the target has since been disabled
+	target := *tenant
+	target.Settings = settings[key.clientID][key.target]
+	if target.Settings == nil && key.status == StatePending {
+		return fail(fmt.Errorf("target %s is not configured for client %s", key.target, key.clientID))
+	}
+
+	var items []Item
+	var positions []int
+	for i, c := range group {
+		ind, ok := indicators[c.IndicatorID]
+		if !ok {
+			// Deleted indicators have nothing left to publish or revoke
+			continue
+		}
+		items = append(items, Item{Indicator: ind, ExternalID: c.ExternalID})
+		positions = append(positions, i)
+	}
+	if len(items) == 0 {
+		return outcomes
+	}
+
+	var results []Outcome
+	var err error
+	if key.status == StateRemoving {
+		results, err = connector.Remove(ctx, &target, items)
+	} else {
+		results, err = connector.Publish(ctx, &target, items)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	for i, pos := range positions {
+		if i < len(results) {
+			outcomes[pos] = results[i]
+		}
+	}
+	return outcomes
+}
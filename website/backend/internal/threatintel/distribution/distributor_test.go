@@ -0,0 +1,88 @@
+package distribution_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/defender/defendertest"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/distribution"
+)
+
+type staticCredentials struct{}
+
+func (staticCredentials) Credentials(ctx context.Context, clientID string) (*azure.Credentials, error) {
+	return &azure.Credentials{TenantID: "22222222-2222-2222-2222-222222222222"}, nil
+}
+
+func (staticCredentials) TokenProvider(ctx context.Context, clientID string) (azure.TokenProvider, error) {
+	return azure.StaticToken("test"), nil
+}
+
+func TestDistributorSync(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	server := defendertest.NewServer()
+	defer server.Close()
+
+	clientID := dbtest.CreateClient(t, db, "Contoso")
+	indicators := indicator.NewStore(db)
+	store := distribution.NewStore(db)
+	if err := store.SaveTarget(ctx, distribution.TargetConfig{
+		ClientID: clientID, Target: distribution.TargetMDE, Enabled: true, Settings: map[string]string{},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := indicators.Upsert(ctx, []indicator.Indicator{
+		{ClientID: clientID, Type: indicator.TypeIPv4, Value: "45.33.32.156", ThreatType: "C2", Confidence: 80, Source: "test"},
+		// Defender has no CIDR indicators, so this one is not planned there
+		{ClientID: clientID, Type: indicator.TypeCIDR, Value: "45.33.32.0/24", ThreatType: "C2", Confidence: 80, Source: "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := distribution.NewDistributor(store, indicators, staticCredentials{})
+	mde := distribution.NewMDEConnector(http.DefaultClient)
+	mde.BaseURL = server.URL
+	d.RegisterConnector(mde)
+
+	if err := d.Sync(ctx, distribution.Scope{}); err != nil {
+		t.Fatal(err)
+	}
+	held := server.Indicators()
+	if len(held) != 1 {
+		t.Fatalf("defender holds %v, want the IP only", held)
+	}
+
+	var ipID string
+	err = indicators.ForEach(ctx, indicator.Filter{Types: []indicator.Type{indicator.TypeIPv4}}, func(ind *indicator.Indicator) error {
+		ipID = ind.ID
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	states, err := store.States(ctx, ipID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 || states[0].Status != distribution.StateSynced || held[states[0].ExternalID].IndicatorValue != "45.33.32.156" {
+		t.Fatalf("states = %+v, want synced to the indicator defender holds", states)
+	}
+
+	// Retracting the indicator removes it on the next run
+	if _, err := db.Exec(`
+		UPDATE deployment_mgmt.threat_indicators SET is_active = false, updated_at = NOW() WHERE id = $1`, ipID); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Sync(ctx, distribution.Scope{}); err != nil {
+		t.Fatal(err)
+	}
+	if held := server.Indicators(); len(held) != 0 {
+		t.Fatalf("defender still holds %v", held)
+	}
+}
@@ -0,0 +1,117 @@
+package distribution
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// DefaultGraphURL is the Microsoft Graph endpoint
+const DefaultGraphURL = "https://graph.microsoft.com"
+
+// EntraConnector adds malicious addresses to Entra ID as untrusted named
+// locations, one per indicator, named like the CTI PowerShell module does
+// so Conditional Access policies can block sign-ins from them
+type EntraConnector struct {
+	HTTPClient *http.Client
+	BaseURL    string
+}
+
+// NewEntraConnector creates a connector for Microsoft Graph
+func NewEntraConnector(client *http.Client) *EntraConnector {
+	return &EntraConnector{HTTPClient: client, BaseURL: DefaultGraphURL}
+}
+
+// Target implements Connector
+func (c *EntraConnector) Target() string {
+	return TargetEntraID
+}
+
+// Supports implements Connector
+func (c *EntraConnector) Supports(t indicator.Type) bool {
+	return t == indicator.TypeIPv4 || t == indicator.TypeIPv6 || t == indicator.TypeCIDR
+}
+
+func (c *EntraConnector) endpoint() string {
+	return strings.TrimRight(c.BaseURL, "/") + "/v1.0/identity/conditionalAccess/namedLocations"
+}
+
+// Publish implements Connector. Named locations never change once created,
+// so items that already have one are left as they are.
+func (c *EntraConnector) Publish(ctx context.Context, tenant *Tenant, items []Item) ([]Outcome, error) {
+	outcomes := make([]Outcome, len(items))
+	for i, item := range items {
+		if item.ExternalID != "" {
+			outcomes[i].ExternalID = item.ExternalID
+			continue
+		}
+
+		cidr := item.Indicator.Value
+		rangeType := "#microsoft.graph.iPv4CidrRange"
+		if strings.Contains(cidr, ":") {
+			rangeType = "#microsoft.graph.iPv6CidrRange"
+		}
+		if !strings.Contains(cidr, "/") {
+			if rangeType == "#microsoft.graph.iPv4CidrRange" {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		var created struct {
+			ID string `json:"id"`
+		}
+		err := callJSON(ctx, c.HTTPClient, tenant.Tokens, azure.ScopeGraph, http.MethodPost, c.endpoint(),
+			map[string]interface{}{
+				"@odata.type": "#microsoft.graph.ipNamedLocation",
+				"displayName": namedLocationName(item.Indicator.Value),
+				"isTrusted":   false,
+				"ipRanges": []map[string]string{{
+					"@odata.type": rangeType,
+					"cidrAddress": cidr,
+				}},
+			}, &created)
+		if err != nil {
+			if i == 0 && isAuthError(err) {
+				return nil, err
+			}
+			outcomes[i].Err = err
+			continue
+		}
+		outcomes[i].ExternalID = created.ID
+	}
+	return outcomes, nil
+}
+
+// Remove implements Connector
+func (c *EntraConnector) Remove(ctx context.Context, tenant *Tenant, items []Item) ([]Outcome, error) {
+	outcomes := make([]Outcome, len(items))
+	for i, item := range items {
+		if item.ExternalID == "" {
+			continue
+		}
+		err := callJSON(ctx, c.HTTPClient, tenant.Tokens, azure.ScopeGraph, http.MethodDelete,
+			c.endpoint()+"/"+url.PathEscape(item.ExternalID), nil, nil)
+		if err != nil && !isNotFound(err) {
+			outcomes[i].Err = err
+		}
+	}
+	return outcomes, nil
+}
+
+// namedLocationName follows Add-EntraNamedLocation in CTI-Module.psm1
+func namedLocationName(value string) string {
+	return "CTI_Malicious_" + strings.NewReplacer(".", "_", ":", "_", "/", "_").Replace(value)
+}
+
+// isAuthError reports whether an error means the tenant rejected our
+// credentials, in which case further calls are pointless
+func isAuthError(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden)
+}
@@ -0,0 +1,118 @@
+package distribution_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/distribution"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/distribution/distributiontest"
+)
+
+func newEntraConnector(server *distributiontest.GraphServer) *distribution.EntraConnector {
+	c := distribution.NewEntraConnector(http.DefaultClient)
+	c.BaseURL = server.URL
+	return c
+}
+
+func TestEntraConnectorPublish(t *testing.T) {
+	server := distributiontest.NewGraphServer()
+	defer server.Close()
+	server.Reject = []string{"45.33.32.157"}
+	c := newEntraConnector(server)
+
+	items := []distribution.Item{
+		item("1", indicator.TypeIPv4, "45.33.32.156"),
+		item("2", indicator.TypeIPv6, "2600:3c01::f03c:91ff:fe18:bb2f"),
+		item("3", indicator.TypeCIDR, "198.51.0.0/16"),
+		item("4", indicator.TypeIPv4, "45.33.32.157"),
+	}
+	outcomes, err := c.Publish(context.Background(), tenant(nil), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes) != len(items) {
+		t.Fatalf("got %d outcomes for %d items", len(outcomes), len(items))
+	}
+	if outcomes[3].Err == nil || outcomes[3].ExternalID != "" {
+		t.Errorf("rejected outcome = %+v, want an error", outcomes[3])
+	}
+
+	locations := server.NamedLocations()
+	if len(locations) != 3 {
+		t.Fatalf("graph holds %d named locations, want 3", len(locations))
+	}
+	for i, want := range []struct{ name, cidr string }{
+		{"CTI_Malicious_45_33_32_156", "45.33.32.156/32"},
+		{"CTI_Malicious_2600_3c01__f03c_91ff_fe18_bb2f", "2600:3c01::f03c:91ff:fe18:bb2f/128"},
+		{"CTI_Malicious_198_51_0_0_16", "198.51.0.0/16"},
+	} {
+		if outcomes[i].Err != nil {
+			t.Fatalf("outcome %d: %v", i, outcomes[i].Err)
+		}
+		got := locations[outcomes[i].ExternalID]
+		if got.DisplayName != want.name || got.IsTrusted || !reflect.DeepEqual(got.CIDRs, []string{want.cidr}) {
+			t.Errorf("named location %s = %+v, want untrusted %s for %s", outcomes[i].ExternalID, got, want.name, want.cidr)
+		}
+	}
+
+	// Named locations are not recreated once published
+	items[0].ExternalID = outcomes[0].ExternalID
+	again, err := c.Publish(context.Background(), tenant(nil), items[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[0].ExternalID != outcomes[0].ExternalID || len(server.NamedLocations()) != 3 {
+		t.Errorf("republished as %s with %d locations, want %s kept", again[0].ExternalID,
+			len(server.NamedLocations()), outcomes[0].ExternalID)
+	}
+}
+
+func TestEntraConnectorPublishUnauthorized(t *testing.T) {
+	server := distributiontest.NewGraphServer()
+	defer server.Close()
+
+	// The fake refuses requests without a token, as Graph does expired ones
+	unauthorized := tenant(nil)
+	unauthorized.Tokens = azure.StaticToken("")
+	outcomes, err := newEntraConnector(server).Publish(context.Background(), unauthorized,
+		[]distribution.Item{item("1", indicator.TypeIPv4, "45.33.32.156")})
+	var apiErr *distribution.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Publish() = %+v, %v; want the 401 for the whole batch", outcomes, err)
+	}
+}
+
+func TestEntraConnectorRemove(t *testing.T) {
+	server := distributiontest.NewGraphServer()
+	defer server.Close()
+	c := newEntraConnector(server)
+
+	items := []distribution.Item{item("1", indicator.TypeIPv4, "45.33.32.156")}
+	outcomes, err := c.Publish(context.Background(), tenant(nil), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items[0].ExternalID = outcomes[0].ExternalID
+
+	// Locations never created and locations already deleted count as removed
+	gone := item("2", indicator.TypeIPv4, "45.33.32.157")
+	gone.ExternalID = "00000000-0000-0000-0000-000000000099"
+	items = append(items, item("3", indicator.TypeIPv4, "45.33.32.158"), gone)
+	removed, err := c.Remove(context.Background(), tenant(nil), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, o := range removed {
+		if o.Err != nil {
+			t.Errorf("outcome %d: %v", i, o.Err)
+		}
+	}
+	if locations := server.NamedLocations(); len(locations) != 0 {
+		t.Errorf("graph still holds %v", locations)
+	}
+}
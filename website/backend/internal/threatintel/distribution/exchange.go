@@ -0,0 +1,120 @@
+package distribution
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// DefaultExchangeURL is the Exchange Online admin API endpoint
+const DefaultExchangeURL = "https://outlook.office365.com"
+
+// ExchangeConnector blocks URLs, senders and file hashes through the
+// Exchange Online Tenant Allow/Block List. It runs the same cmdlets as
+// Add-TenantBlockListItem in CTI-Module.psm1 through the admin API.
+type ExchangeConnector struct {
+	HTTPClient *http.Client
+	BaseURL    string
+}
+
+// NewExchangeConnector creates a connector for Exchange Online
+func NewExchangeConnector(client *http.Client) *ExchangeConnector {
+	return &ExchangeConnector{HTTPClient: client, BaseURL: DefaultExchangeURL}
+}
+
+// Target implements Connector
+func (c *ExchangeConnector) Target() string {
+	return TargetExchangeTABL
+}
+
+// Supports implements Connector
+func (c *ExchangeConnector) Supports(t indicator.Type) bool {
+	return exchangeListType(t) != ""
+}
+
+// exchangeListType maps an indicator type to a Tenant Allow/Block List
+// type. The list only takes SHA256 file hashes.
+func exchangeListType(t indicator.Type) string {
+	switch t {
+	case indicator.TypeURL:
+		return "Url"
+	case indicator.TypeDomain, indicator.TypeEmail:
+		return "Sender"
+	case indicator.TypeSHA256:
+		return "FileHash"
+	}
+	return ""
+}
+
+func (c *ExchangeConnector) invoke(ctx context.Context, tenant *Tenant, cmdlet string, params map[string]interface{}) error {
+	endpoint := fmt.Sprintf("%s/adminapi/beta/%s/InvokeCommand",
+		strings.TrimRight(c.BaseURL, "/"), url.PathEscape(tenant.TenantID))
+	return callJSON(ctx, c.HTTPClient, tenant.Tokens, azure.ScopeExchange, http.MethodPost, endpoint,
+		map[string]interface{}{
+			"CmdletInput": map[string]interface{}{
+				"CmdletName": cmdlet,
+				"Parameters": params,
+			},
+		}, nil)
+}
+
+// Publish implements Connector. The list has no identifiers of its own, so
+// entries are addressed by list type and value.
+func (c *ExchangeConnector) Publish(ctx context.Context, tenant *Tenant, items []Item) ([]Outcome, error) {
+	outcomes := make([]Outcome, len(items))
+	for i, item := range items {
+		ind := item.Indicator
+		listType := exchangeListType(ind.Type)
+		params := map[string]interface{}{
+			"ListType": listType,
+			"Block":    true,
+			"Entries":  []string{ind.Value},
+			"Notes":    "ClarityXDR: " + ind.ThreatType,
+		}
+		if ind.ExpiresAt != nil {
+			params["ExpirationDate"] = ind.ExpiresAt.UTC().Format(time.RFC3339)
+		} else {
+			params["NoExpiration"] = true
+		}
+
+		err := c.invoke(ctx, tenant, "New-TenantAllowBlockListItems", params)
+		if err != nil && !isAlreadyExists(err) {
+			if i == 0 && isAuthError(err) {
+				return nil, err
+			}
+			outcomes[i].Err = err
+			continue
+		}
+		outcomes[i].ExternalID = listType + ":" + ind.Value
+	}
+	return outcomes, nil
+}
+
+// Remove implements Connector
+func (c *ExchangeConnector) Remove(ctx context.Context, tenant *Tenant, items []Item) ([]Outcome, error) {
+	outcomes := make([]Outcome, len(items))
+	for i, item := range items {
+		err := c.invoke(ctx, tenant, "Remove-TenantAllowBlockListItems", map[string]interface{}{
+			"ListType": exchangeListType(item.Indicator.Type),
+			"Entries":  []string{item.Indicator.Value},
+		})
+		if err != nil && !isNotFound(err) && !strings.Contains(strings.ToLower(err.Error()), "not found") {
+			outcomes[i].Err = err
+		}
+	}
+	return outcomes, nil
+}
+
+// isAlreadyExists reports whether Exchange refused an entry because the
+// list already holds it
+func isAlreadyExists(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && (apiErr.StatusCode == http.StatusConflict ||
+		strings.Contains(strings.ToLower(apiErr.Message), "already exists"))
+}
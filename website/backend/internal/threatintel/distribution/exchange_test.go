@@ -0,0 +1,96 @@
+package distribution_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/distribution"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/distribution/distributiontest"
+)
+
+func newExchangeConnector(server *distributiontest.ExchangeServer) *distribution.ExchangeConnector {
+	c := distribution.NewExchangeConnector(http.DefaultClient)
+	c.BaseURL = server.URL
+	return c
+}
+
+func TestExchangeConnectorPublish(t *testing.T) {
+	server := distributiontest.NewExchangeServer()
+	defer server.Close()
+	server.Reject = []string{"invalid.evil.org"}
+	c := newExchangeConnector(server)
+
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	items := []distribution.Item{
+		item("1", indicator.TypeURL, "http://evil.org/payload.exe"),
+		item("2", indicator.TypeEmail, "phisher@evil.org"),
+		item("3", indicator.TypeSHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"),
+		item("4", indicator.TypeDomain, "invalid.evil.org"),
+	}
+	items[0].Indicator.ExpiresAt = &expires
+	outcomes, err := c.Publish(context.Background(), tenant(nil), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcomes[3].Err == nil {
+		t.Error("the rejected sender was reported as published")
+	}
+
+	entries := server.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("block list holds %d entries, want 3", len(entries))
+	}
+	for i, want := range []struct{ externalID, expires string }{
+		{"Url:http://evil.org/payload.exe", "2030-01-02T03:04:05Z"},
+		{"Sender:phisher@evil.org", ""},
+		{"FileHash:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", ""},
+	} {
+		if outcomes[i].Err != nil || outcomes[i].ExternalID != want.externalID {
+			t.Fatalf("outcome %d = %+v, want %s", i, outcomes[i], want.externalID)
+		}
+		got := entries[want.externalID]
+		if got.ExpirationDate != want.expires || got.Notes != "ClarityXDR: C2" {
+			t.Errorf("entry %s = %+v, want expiring %q with the threat type noted", want.externalID, got, want.expires)
+		}
+	}
+
+	// Entries the list already holds count as published
+	again, err := c.Publish(context.Background(), tenant(nil), items[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[0].Err != nil || again[0].ExternalID != outcomes[0].ExternalID {
+		t.Errorf("republished outcome = %+v, want %s", again[0], outcomes[0].ExternalID)
+	}
+}
+
+func TestExchangeConnectorRemove(t *testing.T) {
+	server := distributiontest.NewExchangeServer()
+	defer server.Close()
+	c := newExchangeConnector(server)
+
+	items := []distribution.Item{
+		item("1", indicator.TypeURL, "http://evil.org/payload.exe"),
+		item("2", indicator.TypeDomain, "evil.org"),
+	}
+	if _, err := c.Publish(context.Background(), tenant(nil), items[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The domain was never listed; removing it succeeds all the same
+	removed, err := c.Remove(context.Background(), tenant(nil), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, o := range removed {
+		if o.Err != nil {
+			t.Errorf("outcome %d: %v", i, o.Err)
+		}
+	}
+	if entries := server.Entries(); len(entries) != 0 {
+		t.Errorf("block list still holds %v", entries)
+	}
+}
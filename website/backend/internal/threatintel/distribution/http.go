@@ -0,0 +1,101 @@
+package distribution
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+)
+
+// APIError is an unsuccessful response from a target API
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("target api returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("target api returned %d: %s", e.StatusCode, e.Message)
+}
+
+// callJSON sends a JSON request with a bearer token for scope and decodes
+// a JSON response into out
+func callJSON(ctx context.Context, client *http.Client, tokens azure.TokenProvider, scope, method, url string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	token, err := tokens.Token(ctx, scope)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return &APIError{StatusCode: resp.StatusCode, Message: errorMessage(resp.Body)}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// errorMessage extracts the message of the error formats used by Graph,
+// ARM, Exchange and Defender for Cloud Apps
+func errorMessage(r io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(r, 64*1024))
+	var body struct {
+		Error  json.RawMessage `json:"error"`
+		Detail string          `json:"detail"`
+	}
+	if json.Unmarshal(data, &body) != nil {
+		return string(bytes.TrimSpace(data))
+	}
+	var nested struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body.Error, &nested) == nil && nested.Message != "" {
+		return nested.Code + ": " + nested.Message
+	}
+	var plain string
+	if json.Unmarshal(body.Error, &plain) == nil && plain != "" {
+		return plain
+	}
+	if body.Detail != "" {
+		return body.Detail
+	}
+	return string(bytes.TrimSpace(data))
+}
+
+// isNotFound reports whether an error means the target no longer holds
+// the item
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,111 @@
+package distribution
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// mdcaCategoryRisky is the Defender for Cloud Apps IP range category for
+// risky addresses
+const mdcaCategoryRisky = 3
+
+// MDCAConnector tags malicious addresses as risky IP ranges in Defender for
+// Cloud Apps. Tenant settings must include "portalUrl", the tenant's portal
+// such as https://contoso.us3.portal.cloudappsecurity.com.
+type MDCAConnector struct {
+	HTTPClient *http.Client
+}
+
+// NewMDCAConnector creates a connector for Defender for Cloud Apps
+func NewMDCAConnector(client *http.Client) *MDCAConnector {
+	return &MDCAConnector{HTTPClient: client}
+}
+
+// Target implements Connector
+func (c *MDCAConnector) Target() string {
+	return TargetMDCA
+}
+
+// Supports implements Connector
+func (c *MDCAConnector) Supports(t indicator.Type) bool {
+	return t == indicator.TypeIPv4 || t == indicator.TypeIPv6 || t == indicator.TypeCIDR
+}
+
+func (c *MDCAConnector) portal(tenant *Tenant) (string, error) {
+	portal := strings.TrimRight(tenant.Settings["portalUrl"], "/")
+	if portal == "" {
+		return "", fmt.Errorf("client %s has no Defender for Cloud Apps portal URL", tenant.ClientID)
+	}
+	return portal, nil
+}
+
+// Publish implements Connector. IP ranges are left alone once created.
+func (c *MDCAConnector) Publish(ctx context.Context, tenant *Tenant, items []Item) ([]Outcome, error) {
+	portal, err := c.portal(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	outcomes := make([]Outcome, len(items))
+	for i, item := range items {
+		if item.ExternalID != "" {
+			outcomes[i].ExternalID = item.ExternalID
+			continue
+		}
+
+		subnet := item.Indicator.Value
+		if !strings.Contains(subnet, "/") {
+			if strings.Contains(subnet, ":") {
+				subnet += "/128"
+			} else {
+				subnet += "/32"
+			}
+		}
+
+		var id string
+		err := callJSON(ctx, c.HTTPClient, tenant.Tokens, azure.ScopeCloudAppsPortal, http.MethodPost,
+			portal+"/api/v1/subnet/create_rule/",
+			map[string]interface{}{
+				"name":     "CTI_RiskyIP_" + strings.NewReplacer(".", "_", ":", "_", "/", "_").Replace(item.Indicator.Value),
+				"category": mdcaCategoryRisky,
+				"subnets":  []string{subnet},
+				"tags":     []string{"ClarityXDR"},
+			}, &id)
+		if err != nil {
+			if i == 0 && isAuthError(err) {
+				return nil, err
+			}
+			outcomes[i].Err = err
+			continue
+		}
+		outcomes[i].ExternalID = id
+	}
+	return outcomes, nil
+}
+
+// Remove implements Connector
+func (c *MDCAConnector) Remove(ctx context.Context, tenant *Tenant, items []Item) ([]Outcome, error) {
+	portal, err := c.portal(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	outcomes := make([]Outcome, len(items))
+	for i, item := range items {
+		if item.ExternalID == "" {
+			continue
+		}
+		err := callJSON(ctx, c.HTTPClient, tenant.Tokens, azure.ScopeCloudAppsPortal, http.MethodDelete,
+			portal+"/api/v1/subnet/"+url.PathEscape(item.ExternalID)+"/", nil, nil)
+		if err != nil && !isNotFound(err) {
+			outcomes[i].Err = err
+		}
+	}
+	return outcomes, nil
+}
@@ -0,0 +1,102 @@
+package distribution_test
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/distribution"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/distribution/distributiontest"
+)
+
+func TestMDCAConnectorPublish(t *testing.T) {
+	server := distributiontest.NewMDCAServer()
+	defer server.Close()
+	c := distribution.NewMDCAConnector(http.DefaultClient)
+	portal := tenant(map[string]string{"portalUrl": server.URL + "/"})
+
+	items := []distribution.Item{
+		item("1", indicator.TypeIPv4, "45.33.32.156"),
+		item("2", indicator.TypeCIDR, "198.51.0.0/16"),
+	}
+	outcomes, err := c.Publish(context.Background(), portal, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges := server.Ranges()
+	for i, want := range []struct{ name, subnet string }{
+		{"CTI_RiskyIP_45_33_32_156", "45.33.32.156/32"},
+		{"CTI_RiskyIP_198_51_0_0_16", "198.51.0.0/16"},
+	} {
+		if outcomes[i].Err != nil {
+			t.Fatalf("outcome %d: %v", i, outcomes[i].Err)
+		}
+		got := ranges[outcomes[i].ExternalID]
+		if got.Name != want.name || got.Category != 3 || !reflect.DeepEqual(got.Subnets, []string{want.subnet}) ||
+			!reflect.DeepEqual(got.Tags, []string{"ClarityXDR"}) {
+			t.Errorf("range %s = %+v, want risky %s for %s", outcomes[i].ExternalID, got, want.name, want.subnet)
+		}
+	}
+
+	// Published ranges are kept, but a second range of the same name fails
+	items[0].ExternalID = outcomes[0].ExternalID
+	again, err := c.Publish(context.Background(), portal, []distribution.Item{
+		items[0], item("3", indicator.TypeCIDR, "198.51.0.0/16"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[0].Err != nil || again[0].ExternalID != outcomes[0].ExternalID {
+		t.Errorf("republished outcome = %+v, want %s kept", again[0], outcomes[0].ExternalID)
+	}
+	if again[1].Err == nil {
+		t.Error("a duplicate range name was reported as published")
+	}
+	if got := len(server.Ranges()); got != 2 {
+		t.Errorf("portal holds %d ranges, want 2", got)
+	}
+}
+
+func TestMDCAConnectorNeedsPortal(t *testing.T) {
+	c := distribution.NewMDCAConnector(http.DefaultClient)
+	items := []distribution.Item{item("1", indicator.TypeIPv4, "45.33.32.156")}
+	if _, err := c.Publish(context.Background(), tenant(nil), items); err == nil {
+		t.Error("Publish() without a portal URL succeeded")
+	}
+	if _, err := c.Remove(context.Background(), tenant(nil), items); err == nil {
+		t.Error("Remove() without a portal URL succeeded")
+	}
+}
+
+func TestMDCAConnectorRemove(t *testing.T) {
+	server := distributiontest.NewMDCAServer()
+	defer server.Close()
+	c := distribution.NewMDCAConnector(http.DefaultClient)
+	portal := tenant(map[string]string{"portalUrl": server.URL})
+
+	items := []distribution.Item{item("1", indicator.TypeIPv4, "45.33.32.156")}
+	outcomes, err := c.Publish(context.Background(), portal, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items[0].ExternalID = outcomes[0].ExternalID
+
+	// Ranges never created and ranges already deleted count as removed
+	gone := item("2", indicator.TypeIPv4, "45.33.32.157")
+	gone.ExternalID = "000000000000000000000099"
+	items = append(items, item("3", indicator.TypeIPv4, "45.33.32.158"), gone)
+	removed, err := c.Remove(context.Background(), portal, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, o := range removed {
+		if o.Err != nil {
+			t.Errorf("outcome %d: %v", i, o.Err)
+		}
+	}
+	if ranges := server.Ranges(); len(ranges) != 0 {
+		t.Errorf("portal still holds %v", ranges)
+	}
+}
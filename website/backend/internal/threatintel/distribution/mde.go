@@ -0,0 +1,116 @@
+package distribution
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/defender"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// MDEConnector manages custom indicators in Defender for Endpoint.
+// The optional tenant setting "action" selects "block" (default) or "audit".
+type MDEConnector struct {
+	HTTPClient *http.Client
+	BaseURL    string
+}
+
+// NewMDEConnector creates a connector for the public Defender API
+func NewMDEConnector(client *http.Client) *MDEConnector {
+	return &MDEConnector{HTTPClient: client, BaseURL: defender.DefaultBaseURL}
+}
+
+// Target implements Connector
+func (c *MDEConnector) Target() string {
+	return TargetMDE
+}
+
+// Supports implements Connector
+func (c *MDEConnector) Supports(t indicator.Type) bool {
+	_, ok := defender.IndicatorType(t)
+	return ok
+}
+
+func (c *MDEConnector) client(tenant *Tenant) *defender.Client {
+	return &defender.Client{HTTPClient: c.HTTPClient, BaseURL: c.BaseURL, Tokens: tenant.Tokens}
+}
+
+// Publish implements Connector
+func (c *MDEConnector) Publish(ctx context.Context, tenant *Tenant, items []Item) ([]Outcome, error) {
+	client := c.client(tenant)
+	outcomes := make([]Outcome, len(items))
+
+	for start := 0; start < len(items); start += defender.MaxImportBatch {
+		end := start + defender.MaxImportBatch
+		if end > len(items) {
+			end = len(items)
+		}
+
+		var batch []defender.Indicator
+		var positions []int
+		for i := start; i < end; i++ {
+			ind := items[i].Indicator
+			mdeType, _ := defender.IndicatorType(ind.Type)
+			description := ind.Description
+			if description == "" {
+				description = fmt.Sprintf("%s indicator from %s", ind.ThreatType, ind.Source)
+			}
+			batch = append(batch, defender.Indicator{
+				IndicatorValue: ind.Value,
+				IndicatorType:  mdeType,
+				Action:         defender.Action(mdeType, tenant.Settings["action"]),
+				Title:          "ClarityXDR: " + ind.ThreatType,
+				Description:    description,
+				ExpirationTime: ind.ExpiresAt,
+				Severity:       defender.Severity(ind.Confidence),
+				GenerateAlert:  true,
+			})
+			positions = append(positions, i)
+		}
+
+		results, err := client.ImportIndicators(ctx, batch)
+		if err != nil {
+			if start == 0 {
+				return nil, err
+			}
+			copy(outcomes[start:], failAll(items[start:], err))
+			break
+		}
+		// Results are matched by value as the API does not promise order
+		byValue := make(map[string]defender.ImportResult, len(results))
+		for _, r := range results {
+			byValue[r.Indicator] = r
+		}
+		for _, i := range positions {
+			r, ok := byValue[items[i].Indicator.Value]
+			switch {
+			case !ok:
+				outcomes[i].Err = fmt.Errorf("defender returned no result for indicator")
+			case r.IsFailed:
+				outcomes[i].Err = fmt.Errorf("defender rejected indicator: %s", r.FailureReason)
+			default:
+				outcomes[i].ExternalID = r.ID
+			}
+		}
+	}
+	return outcomes, nil
+}
+
+// Remove implements Connector
+func (c *MDEConnector) Remove(ctx context.Context, tenant *Tenant, items []Item) ([]Outcome, error) {
+	var ids []string
+	for _, item := range items {
+		if item.ExternalID != "" {
+			ids = append(ids, item.ExternalID)
+		}
+	}
+	outcomes := make([]Outcome, len(items))
+	if len(ids) == 0 {
+		return outcomes, nil
+	}
+	if err := c.client(tenant).DeleteIndicators(ctx, ids); err != nil {
+		return nil, err
+	}
+	return outcomes, nil
+}
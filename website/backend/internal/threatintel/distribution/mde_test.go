@@ -0,0 +1,137 @@
+package distribution_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/defender/defendertest"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/distribution"
+)
+
+func tenant(settings map[string]string) *distribution.Tenant {
+	return &distribution.Tenant{
+		ClientID: "11111111-1111-1111-1111-111111111111",
+		TenantID: "22222222-2222-2222-2222-222222222222",
+		Tokens:   azure.StaticToken("test"),
+		Settings: settings,
+	}
+}
+
+func item(id string, t indicator.Type, value string) distribution.Item {
+	return distribution.Item{Indicator: &indicator.Indicator{
+		ID: id, Type: t, Value: value, ThreatType: "C2", Confidence: 85, Source: "test", IsActive: true,
+	}}
+}
+
+func newMDEConnector(server *defendertest.Server) *distribution.MDEConnector {
+	c := distribution.NewMDEConnector(http.DefaultClient)
+	c.BaseURL = server.URL
+	return c
+}
+
+func TestMDEConnectorPublish(t *testing.T) {
+	server := defendertest.NewServer()
+	defer server.Close()
+	server.Reject["evil.example.org"] = "Invalid domain"
+	c := newMDEConnector(server)
+
+	items := []distribution.Item{
+		item("1", indicator.TypeIPv4, "45.33.32.156"),
+		item("2", indicator.TypeSHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"),
+		item("3", indicator.TypeDomain, "evil.example.org"),
+	}
+	outcomes, err := c.Publish(context.Background(), tenant(nil), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes) != len(items) {
+		t.Fatalf("got %d outcomes for %d items", len(outcomes), len(items))
+	}
+	if outcomes[2].Err == nil {
+		t.Error("the rejected domain was reported as published")
+	}
+
+	held := server.Indicators()
+	if len(held) != 2 {
+		t.Fatalf("defender holds %d indicators, want 2", len(held))
+	}
+	for i, want := range []struct{ mdeType, action string }{
+		{"IpAddress", "Block"},
+		{"FileSha256", "BlockAndRemediate"},
+	} {
+		if outcomes[i].Err != nil || outcomes[i].ExternalID == "" {
+			t.Fatalf("outcome %d = %+v, want an external ID", i, outcomes[i])
+		}
+		got := held[outcomes[i].ExternalID]
+		if got.IndicatorValue != items[i].Indicator.Value || got.IndicatorType != want.mdeType ||
+			got.Action != want.action || got.Severity != "High" {
+			t.Errorf("indicator %s = %+v, want %s with action %s", outcomes[i].ExternalID, got, want.mdeType, want.action)
+		}
+	}
+
+	// Publishing again updates the same indicators
+	again, err := c.Publish(context.Background(), tenant(map[string]string{"action": "audit"}), items[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[0].ExternalID != outcomes[0].ExternalID {
+		t.Errorf("republished as %s, want %s", again[0].ExternalID, outcomes[0].ExternalID)
+	}
+	if got := server.Indicators()[again[0].ExternalID].Action; got != "Audit" {
+		t.Errorf("action = %s, want the tenant's audit setting", got)
+	}
+}
+
+func TestMDEConnectorPublishBatches(t *testing.T) {
+	server := defendertest.NewServer()
+	defer server.Close()
+
+	var items []distribution.Item
+	for i := 0; i < 501; i++ {
+		items = append(items, item(fmt.Sprint(i), indicator.TypeIPv4, fmt.Sprintf("45.33.%d.%d", i/250, i%250+1)))
+	}
+	outcomes, err := newMDEConnector(server).Publish(context.Background(), tenant(nil), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, o := range outcomes {
+		if o.Err != nil {
+			t.Fatalf("outcome %d: %v", i, o.Err)
+		}
+	}
+	if got := len(server.Indicators()); got != len(items) {
+		t.Errorf("defender holds %d indicators, want %d", got, len(items))
+	}
+}
+
+func TestMDEConnectorRemove(t *testing.T) {
+	server := defendertest.NewServer()
+	defer server.Close()
+	c := newMDEConnector(server)
+
+	items := []distribution.Item{item("1", indicator.TypeIPv4, "45.33.32.156")}
+	outcomes, err := c.Publish(context.Background(), tenant(nil), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items[0].ExternalID = outcomes[0].ExternalID
+
+	// Items never published have nothing to delete
+	items = append(items, item("2", indicator.TypeIPv4, "45.33.32.157"))
+	removed, err := c.Remove(context.Background(), tenant(nil), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, o := range removed {
+		if o.Err != nil {
+			t.Errorf("outcome %d: %v", i, o.Err)
+		}
+	}
+	if held := server.Indicators(); len(held) != 0 {
+		t.Errorf("defender still holds %v", held)
+	}
+}
@@ -0,0 +1,90 @@
+package distribution
+
+import (
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// Route sends indicators of one type to a set of targets. Routes with a
+// ClientID apply to that client only and take precedence over global routes.
+type Route struct {
+	ID            string         `json:"id,omitempty"`
+	ClientID      string         `json:"clientId,omitempty"`
+	Type          indicator.Type `json:"type"`
+	Targets       []string       `json:"targets"`
+	MinConfidence int            `json:"minConfidence"`
+}
+
+// DefaultRoutes returns the DeploymentTargets of CTI-Config.json, with every
+// type also sent to Sentinel. They apply when no route is configured.
+func DefaultRoutes() []Route {
+	return []Route{
+		{Type: indicator.TypeIPv4, Targets: []string{TargetSentinel, TargetMDE, TargetEntraID, TargetMDCA}},
+		{Type: indicator.TypeIPv6, Targets: []string{TargetSentinel, TargetMDE, TargetEntraID, TargetMDCA}},
+		{Type: indicator.TypeCIDR, Targets: []string{TargetSentinel, TargetEntraID, TargetMDCA}},
+		{Type: indicator.TypeMD5, Targets: []string{TargetSentinel, TargetMDE}},
+		{Type: indicator.TypeSHA1, Targets: []string{TargetSentinel, TargetMDE}},
+		{Type: indicator.TypeSHA256, Targets: []string{TargetSentinel, TargetMDE, TargetExchangeTABL}},
+		{Type: indicator.TypeURL, Targets: []string{TargetSentinel, TargetMDE, TargetExchangeTABL}},
+		{Type: indicator.TypeDomain, Targets: []string{TargetSentinel, TargetMDE, TargetExchangeTABL}},
+		{Type: indicator.TypeCertificate, Targets: []string{TargetSentinel, TargetMDE}},
+		{Type: indicator.TypeEmail, Targets: []string{TargetSentinel, TargetExchangeTABL}},
+	}
+}
+
+// IsTarget reports whether name is one of the Target constants
+func IsTarget(name string) bool {
+	switch name {
+	case TargetSentinel, TargetMDE, TargetEntraID, TargetExchangeTABL, TargetMDCA:
+		return true
+	}
+	return false
+}
+
+type routeKey struct {
+	clientID string
+	t        indicator.Type
+}
+
+// Router resolves the targets of an indicator from configured routes
+type Router struct {
+	routes   map[routeKey]Route
+	defaults map[indicator.Type]Route
+}
+
+// NewRouter creates a router over the configured routes
+func NewRouter(routes []Route) *Router {
+	r := &Router{
+		routes:   make(map[routeKey]Route, len(routes)),
+		defaults: make(map[indicator.Type]Route),
+	}
+	for _, route := range routes {
+		r.routes[routeKey{route.ClientID, route.Type}] = route
+	}
+	for _, route := range DefaultRoutes() {
+		r.defaults[route.Type] = route
+	}
+	return r
+}
+
+// Resolve returns the route for indicators of a type seen by a client
+func (r *Router) Resolve(clientID string, t indicator.Type) (Route, bool) {
+	if clientID != "" {
+		if route, ok := r.routes[routeKey{clientID, t}]; ok {
+			return route, true
+		}
+	}
+	if route, ok := r.routes[routeKey{"", t}]; ok {
+		return route, true
+	}
+	route, ok := r.defaults[t]
+	return route, ok
+}
+
+// Targets returns the targets an indicator should be on for a client
+func (r *Router) Targets(clientID string, ind *indicator.Indicator) []string {
+	route, ok := r.Resolve(clientID, ind.Type)
+	if !ok || ind.Confidence < route.MinConfidence {
+		return nil
+	}
+	return route.Targets
+}
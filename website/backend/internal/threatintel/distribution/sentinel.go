@@ -0,0 +1,134 @@
+package distribution
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/stix"
+)
+
+const (
+	// DefaultSentinelURL is the Sentinel threat intelligence upload API
+	DefaultSentinelURL = "https://sentinelus.azure-api.net"
+	sentinelAPIVersion = "2022-07-01"
+	// sentinelMaxBatch is the most indicators the upload API accepts per call
+	sentinelMaxBatch = 100
+)
+
+// SentinelConnector uploads indicators to a client's Sentinel workspace as
+// STIX objects. Tenant settings must include "workspaceId".
+type SentinelConnector struct {
+	HTTPClient   *http.Client
+	BaseURL      string
+	SourceSystem string
+}
+
+// NewSentinelConnector creates a connector for the public upload API
+func NewSentinelConnector(client *http.Client) *SentinelConnector {
+	return &SentinelConnector{HTTPClient: client, BaseURL: DefaultSentinelURL, SourceSystem: "ClarityXDR"}
+}
+
+// Target implements Connector
+func (c *SentinelConnector) Target() string {
+	return TargetSentinel
+}
+
+// Supports implements Connector. Sentinel holds every type as a STIX pattern.
+func (c *SentinelConnector) Supports(t indicator.Type) bool {
+	switch t {
+	case indicator.TypeIPv4, indicator.TypeIPv6, indicator.TypeCIDR, indicator.TypeDomain,
+		indicator.TypeURL, indicator.TypeMD5, indicator.TypeSHA1, indicator.TypeSHA256,
+		indicator.TypeEmail, indicator.TypeCertificate:
+		return true
+	}
+	return false
+}
+
+// Publish implements Connector
+func (c *SentinelConnector) Publish(ctx context.Context, tenant *Tenant, items []Item) ([]Outcome, error) {
+	return c.upload(ctx, tenant, items, false)
+}
+
+// Remove implements Connector. Sentinel indicators cannot be deleted
+// through the upload API, so they are revoked instead.
+func (c *SentinelConnector) Remove(ctx context.Context, tenant *Tenant, items []Item) ([]Outcome, error) {
+	return c.upload(ctx, tenant, items, true)
+}
+
+func (c *SentinelConnector) upload(ctx context.Context, tenant *Tenant, items []Item, revoke bool) ([]Outcome, error) {
+	workspace := tenant.Settings["workspaceId"]
+	if workspace == "" {
+		return nil, fmt.Errorf("client %s has no Sentinel workspace", tenant.ClientID)
+	}
+
+	outcomes := make([]Outcome, len(items))
+	for start := 0; start < len(items); start += sentinelMaxBatch {
+		end := start + sentinelMaxBatch
+		if end > len(items) {
+			end = len(items)
+		}
+		if err := c.uploadBatch(ctx, tenant, workspace, items[start:end], outcomes[start:end], revoke); err != nil {
+			if start == 0 {
+				return nil, err
+			}
+			copy(outcomes[start:], failAll(items[start:], err))
+			break
+		}
+	}
+	return outcomes, nil
+}
+
+func (c *SentinelConnector) uploadBatch(ctx context.Context, tenant *Tenant, workspace string, items []Item, outcomes []Outcome, revoke bool) error {
+	var objects []stix.Indicator
+	var positions []int
+	for i, item := range items {
+		object, err := indicator.ToSTIX(item.Indicator)
+		if err != nil {
+			outcomes[i].Err = err
+			continue
+		}
+		if revoke {
+			object.Revoked = true
+			object.Modified = time.Now().UTC()
+		}
+		objects = append(objects, object)
+		positions = append(positions, i)
+		outcomes[i].ExternalID = object.ID
+	}
+	if len(objects) == 0 {
+		return nil
+	}
+
+	var resp struct {
+		Errors []struct {
+			RecordIndex   int      `json:"recordIndex"`
+			ErrorMessages []string `json:"errorMessages"`
+		} `json:"errors"`
+	}
+	endpoint := fmt.Sprintf("%s/%s/threatintelligence:upload-indicators?api-version=%s",
+		strings.TrimRight(c.BaseURL, "/"), url.PathEscape(workspace), sentinelAPIVersion)
+	err := callJSON(ctx, c.HTTPClient, tenant.Tokens, azure.ScopeManagement, http.MethodPost, endpoint,
+		map[string]interface{}{
+			"sourcesystem": c.SourceSystem,
+			"indicators":   objects,
+		}, &resp)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range resp.Errors {
+		if e.RecordIndex < 0 || e.RecordIndex >= len(positions) {
+			continue
+		}
+		msg, _ := json.Marshal(e.ErrorMessages)
+		outcomes[positions[e.RecordIndex]].Err = fmt.Errorf("sentinel rejected indicator: %s", msg)
+	}
+	return nil
+}
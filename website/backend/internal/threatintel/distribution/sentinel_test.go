@@ -0,0 +1,125 @@
+package distribution_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/distribution"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/distribution/distributiontest"
+)
+
+const workspace = "33333333-3333-3333-3333-333333333333"
+
+func newSentinelConnector(server *distributiontest.SentinelServer) *distribution.SentinelConnector {
+	c := distribution.NewSentinelConnector(http.DefaultClient)
+	c.BaseURL = server.URL
+	return c
+}
+
+func TestSentinelConnectorPublish(t *testing.T) {
+	server := distributiontest.NewSentinelServer()
+	defer server.Close()
+	server.Reject = []string{"evil.example.org"}
+
+	items := []distribution.Item{
+		item("1", indicator.TypeIPv4, "45.33.32.156"),
+		item("2", indicator.TypeDomain, "evil.example.org"),
+		item("3", indicator.TypeURL, "http://evil.org/payload.exe"),
+	}
+	outcomes, err := newSentinelConnector(server).Publish(context.Background(),
+		tenant(map[string]string{"workspaceId": workspace}), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcomes[1].Err == nil {
+		t.Error("the rejected pattern was reported as published")
+	}
+
+	objects := server.Objects(workspace)
+	if len(objects) != 2 {
+		t.Fatalf("workspace holds %d objects, want 2", len(objects))
+	}
+	for _, i := range []int{0, 2} {
+		want := "indicator--" + items[i].Indicator.ID
+		if outcomes[i].Err != nil || outcomes[i].ExternalID != want {
+			t.Fatalf("outcome %d = %+v, want published as %s", i, outcomes[i], want)
+		}
+		var object struct {
+			Pattern string `json:"pattern"`
+			Revoked bool   `json:"revoked"`
+		}
+		if err := json.Unmarshal(objects[want], &object); err != nil {
+			t.Fatal(err)
+		}
+		if object.Pattern == "" || object.Revoked {
+			t.Errorf("object %s = %+v, want an active pattern", want, object)
+		}
+	}
+}
+
+func TestSentinelConnectorRemoveRevokes(t *testing.T) {
+	server := distributiontest.NewSentinelServer()
+	defer server.Close()
+	c := newSentinelConnector(server)
+	target := tenant(map[string]string{"workspaceId": workspace})
+
+	items := []distribution.Item{item("1", indicator.TypeIPv4, "45.33.32.156")}
+	if _, err := c.Publish(context.Background(), target, items); err != nil {
+		t.Fatal(err)
+	}
+	outcomes, err := c.Remove(context.Background(), target, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcomes[0].Err != nil {
+		t.Fatal(outcomes[0].Err)
+	}
+
+	var object struct {
+		Revoked bool `json:"revoked"`
+	}
+	if err := json.Unmarshal(server.Objects(workspace)["indicator--1"], &object); err != nil {
+		t.Fatal(err)
+	}
+	if !object.Revoked {
+		t.Error("removed indicator was not revoked")
+	}
+}
+
+func TestSentinelConnectorBatches(t *testing.T) {
+	server := distributiontest.NewSentinelServer()
+	defer server.Close()
+
+	var items []distribution.Item
+	for i := 0; i < 150; i++ {
+		items = append(items, item(fmt.Sprint(i), indicator.TypeIPv4, fmt.Sprintf("45.33.32.%d", i+1)))
+	}
+	outcomes, err := newSentinelConnector(server).Publish(context.Background(),
+		tenant(map[string]string{"workspaceId": workspace}), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, o := range outcomes {
+		if o.Err != nil {
+			t.Fatalf("outcome %d: %v", i, o.Err)
+		}
+	}
+	if got := len(server.Objects(workspace)); got != len(items) {
+		t.Errorf("workspace holds %d objects, want %d", got, len(items))
+	}
+}
+
+func TestSentinelConnectorNeedsWorkspace(t *testing.T) {
+	server := distributiontest.NewSentinelServer()
+	defer server.Close()
+
+	_, err := newSentinelConnector(server).Publish(context.Background(), tenant(nil),
+		[]distribution.Item{item("1", indicator.TypeIPv4, "45.33.32.156")})
+	if err == nil {
+		t.Fatal("publishing without a workspace succeeded")
+	}
+}
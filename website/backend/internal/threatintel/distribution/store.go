@@ -0,0 +1,485 @@
+package distribution
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/lib/pq"
+)
+
+const (
+	// maxAttempts is how often delivery is tried before an item is failed
+	maxAttempts = 8
+	// claimLease keeps claimed items from being picked up again while a
+	// delivery is in flight
+	claimLease = 5 * time.Minute
+)
+
+// TargetConfig is a client's connection to one target
+type TargetConfig struct {
+	ClientID  string            `json:"clientId"`
+	Target    string            `json:"target"`
+	Enabled   bool              `json:"enabled"`
+	Settings  map[string]string `json:"settings"`
+	UpdatedAt time.Time         `json:"updatedAt,omitempty"`
+}
+
+// State is the sync state of an indicator on one client target
+type State struct {
+	ClientID      string     `json:"clientId"`
+	Target        string     `json:"target"`
+	Status        string     `json:"status"`
+	ExternalID    string     `json:"externalId,omitempty"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"lastError,omitempty"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	SyncedAt      *time.Time `json:"syncedAt,omitempty"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+// Assignment places an indicator on a client target
+type Assignment struct {
+	IndicatorID string
+	ClientID    string
+	Target      string
+	// PlannedAt is the indicator version the assignment was made for
+	PlannedAt time.Time
+}
+
+// Claim is a pending publish or removal taken for delivery
+type Claim struct {
+	ID          string
+	IndicatorID string
+	ClientID    string
+	Target      string
+	Status      string
+	ExternalID  string
+	Attempts    int
+}
+
+// Scope limits a sync to one client, one target or both
+type Scope struct {
+	ClientID string `json:"clientId,omitempty"`
+	Target   string `json:"target,omitempty"`
+}
+
+// Store persists routes, target configuration and sync state
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates a distribution store
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Routes returns the configured routes. An empty clientID returns every
+// route, global and client specific.
+func (s *Store) Routes(ctx context.Context, clientID string) ([]Route, error) {
+	query := `
+		SELECT id, COALESCE(client_id::text, ''), indicator_type, targets, min_confidence
+		FROM deployment_mgmt.distribution_routes`
+	var args []interface{}
+	if clientID != "" {
+		query += ` WHERE client_id IS NULL OR client_id = $1`
+		args = append(args, clientID)
+	}
+	query += ` ORDER BY client_id NULLS FIRST, indicator_type`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := []Route{}
+	for rows.Next() {
+		var route Route
+		var t string
+		if err := rows.Scan(&route.ID, &route.ClientID, &t, pq.Array(&route.Targets), &route.MinConfidence); err != nil {
+			return nil, err
+		}
+		route.Type = indicator.Type(t)
+		routes = append(routes, route)
+	}
+	return routes, rows.Err()
+}
+
+// ReplaceRoutes replaces the routes of a client, or the global routes when
+// clientID is empty
+func (s *Store) ReplaceRoutes(ctx context.Context, clientID string, routes []Route) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var client interface{}
+	if clientID != "" {
+		client = clientID
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM deployment_mgmt.distribution_routes
+		WHERE client_id IS NOT DISTINCT FROM $1::uuid`, client); err != nil {
+		return err
+	}
+	for _, route := range routes {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO deployment_mgmt.distribution_routes (client_id, indicator_type, targets, min_confidence)
+			VALUES ($1, $2, $3, $4)`,
+			client, string(route.Type), pq.Array(route.Targets), route.MinConfidence); err != nil {
+			return fmt.Errorf("failed to store route for %s: %v", route.Type, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Targets returns the target configuration of a client
+func (s *Store) Targets(ctx context.Context, clientID string) ([]TargetConfig, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT client_id, target, enabled, settings, updated_at
+		FROM deployment_mgmt.distribution_targets
+		WHERE client_id = $1
+		ORDER BY target`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	configs := []TargetConfig{}
+	for rows.Next() {
+		var cfg TargetConfig
+		var settings []byte
+		if err := rows.Scan(&cfg.ClientID, &cfg.Target, &cfg.Enabled, &settings, &cfg.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(settings, &cfg.Settings); err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, rows.Err()
+}
+
+// SaveTarget creates or replaces a client's configuration for a target
+func (s *Store) SaveTarget(ctx context.Context, cfg TargetConfig) error {
+	if cfg.Settings == nil {
+		cfg.Settings = map[string]string{}
+	}
+	settings, err := json.Marshal(cfg.Settings)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO deployment_mgmt.distribution_targets (client_id, target, enabled, settings)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (client_id, target) DO UPDATE SET
+			enabled = EXCLUDED.enabled,
+			settings = EXCLUDED.settings,
+			updated_at = NOW()`,
+		cfg.ClientID, cfg.Target, cfg.Enabled, settings)
+	return err
+}
+
+// EnabledTargets returns the settings of every enabled client target, keyed
+// by client and target. Clients with a completed Sentinel deployment get
+// the Sentinel target unless they configured it themselves, and Sentinel
+// settings fall back to the deployment's workspace.
+func (s *Store) EnabledTargets(ctx context.Context) (map[string]map[string]map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH workspaces AS (
+			SELECT DISTINCT ON (client_id) client_id, workspace_id
+			FROM deployment_mgmt.sentinel_deployments
+			WHERE deployment_status = 'completed' AND workspace_id IS NOT NULL
+			ORDER BY client_id, deployed_at DESC NULLS LAST
+		)
+		SELECT t.client_id, t.target,
+			CASE WHEN t.target = 'Sentinel' AND NOT t.settings ? 'workspaceId' AND w.workspace_id IS NOT NULL
+				THEN t.settings || jsonb_build_object('workspaceId', w.workspace_id)
+				ELSE t.settings
+			END
+		FROM deployment_mgmt.distribution_targets t
+		LEFT JOIN workspaces w ON w.client_id = t.client_id
+		WHERE t.enabled = true
+		UNION ALL
+		SELECT w.client_id, 'Sentinel', jsonb_build_object('workspaceId', w.workspace_id)
+		FROM workspaces w
+		WHERE NOT EXISTS (
+			SELECT 1 FROM deployment_mgmt.distribution_targets t
+			WHERE t.client_id = w.client_id AND t.target = 'Sentinel'
+		)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := make(map[string]map[string]map[string]string)
+	for rows.Next() {
+		var clientID, target string
+		var raw []byte
+		if err := rows.Scan(&clientID, &target, &raw); err != nil {
+			return nil, err
+		}
+		settings := map[string]string{}
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return nil, fmt.Errorf("invalid %s settings for client %s: %v", target, clientID, err)
+		}
+		if targets[clientID] == nil {
+			targets[clientID] = make(map[string]map[string]string)
+		}
+		targets[clientID][target] = settings
+	}
+	return targets, rows.Err()
+}
+
+// Plan records where a batch of indicators should be. New assignments and
+// assignments for a newer indicator version become pending; assignments of
+// the planned indicators that are no longer wanted are scheduled for
+// removal, or dropped if they never reached the target.
+func (s *Store) Plan(ctx context.Context, indicatorIDs []string, desired []Assignment) error {
+	if len(indicatorIDs) == 0 {
+		return nil
+	}
+	ids := make([]string, len(desired))
+	clients := make([]string, len(desired))
+	targets := make([]string, len(desired))
+	versions := make([]string, len(desired))
+	for i, a := range desired {
+		ids[i] = a.IndicatorID
+		clients[i] = a.ClientID
+		targets[i] = a.Target
+		versions[i] = a.PlannedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(desired) > 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO deployment_mgmt.indicator_distributions (indicator_id, client_id, target, planned_at)
+			SELECT * FROM unnest($1::uuid[], $2::uuid[], $3::text[], $4::timestamptz[])
+			ON CONFLICT (indicator_id, client_id, target) DO UPDATE SET
+				status = 'pending',
+				attempts = 0,
+				last_error = NULL,
+				next_attempt_at = NOW(),
+				planned_at = EXCLUDED.planned_at,
+				updated_at = NOW()
+			WHERE indicator_distributions.status IN ('removing', 'removed')
+			OR indicator_distributions.planned_at < EXCLUDED.planned_at`,
+			pq.Array(ids), pq.Array(clients), pq.Array(targets), pq.Array(versions))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE deployment_mgmt.indicator_distributions d
+		SET status = CASE WHEN d.external_id IS NOT NULL OR d.synced_at IS NOT NULL
+				THEN 'removing' ELSE 'removed' END,
+			attempts = 0,
+			last_error = NULL,
+			next_attempt_at = NOW(),
+			updated_at = NOW()
+		WHERE d.indicator_id = ANY($1::uuid[])
+		AND d.status NOT IN ('removing', 'removed')
+		AND NOT EXISTS (
+			SELECT 1 FROM unnest($2::uuid[], $3::uuid[], $4::text[]) AS w(indicator_id, client_id, target)
+			WHERE w.indicator_id = d.indicator_id AND w.client_id = d.client_id AND w.target = d.target
+		)`, pq.Array(indicatorIDs), pq.Array(ids), pq.Array(clients), pq.Array(targets))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DrainQueue processes the removals queued by the indicator sweeper.
+// Indicators that were renewed since are skipped. It returns the number of
+// queue entries processed.
+func (s *Store) DrainQueue(ctx context.Context) (int, error) {
+	res, err := s.db.ExecContext(ctx, `
+		WITH processed AS (
+			UPDATE deployment_mgmt.indicator_distribution_queue q
+			SET status = CASE WHEN i.is_active THEN 'cancelled' ELSE 'done' END,
+				attempts = q.attempts + 1,
+				processed_at = NOW()
+			FROM deployment_mgmt.threat_indicators i
+			WHERE q.indicator_id = i.id
+			AND q.action = 'remove'
+			AND q.status = 'pending'
+			RETURNING q.indicator_id, q.status
+		), removed AS (
+			UPDATE deployment_mgmt.indicator_distributions d
+			SET status = CASE WHEN d.external_id IS NOT NULL OR d.synced_at IS NOT NULL
+					THEN 'removing' ELSE 'removed' END,
+				attempts = 0,
+				last_error = NULL,
+				next_attempt_at = NOW(),
+				updated_at = NOW()
+			FROM processed p
+			WHERE d.indicator_id = p.indicator_id
+			AND p.status = 'done'
+			AND d.status NOT IN ('removing', 'removed')
+		)
+		SELECT 1 FROM processed`)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Retry schedules failed items in scope for another round of attempts
+func (s *Store) Retry(ctx context.Context, scope Scope) (int, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.indicator_distributions
+		SET status = CASE WHEN i.is_active THEN 'pending'
+				WHEN external_id IS NOT NULL OR synced_at IS NOT NULL THEN 'removing'
+				ELSE 'removed' END,
+			attempts = 0,
+			next_attempt_at = NOW(),
+			updated_at = NOW()
+		FROM deployment_mgmt.threat_indicators i
+		WHERE indicator_distributions.indicator_id = i.id
+		AND indicator_distributions.status = 'failed'
+		AND ($1 = '' OR indicator_distributions.client_id::text = $1)
+		AND ($2 = '' OR indicator_distributions.target = $2)`, scope.ClientID, scope.Target)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Claim takes up to limit due items in scope for delivery
+func (s *Store) Claim(ctx context.Context, scope Scope, limit int) ([]Claim, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE deployment_mgmt.indicator_distributions d
+		SET next_attempt_at = NOW() + $4::int * INTERVAL '1 second',
+			attempts = d.attempts + 1
+		FROM (
+			SELECT id FROM deployment_mgmt.indicator_distributions
+			WHERE status IN ('pending', 'removing')
+			AND next_attempt_at <= NOW()
+			AND ($1 = '' OR client_id::text = $1)
+			AND ($2 = '' OR target = $2)
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		) due
+		WHERE d.id = due.id
+		RETURNING d.id, d.indicator_id, d.client_id, d.target, d.status,
+			COALESCE(d.external_id, ''), d.attempts`,
+		scope.ClientID, scope.Target, limit, int(claimLease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claims []Claim
+	for rows.Next() {
+		var c Claim
+		if err := rows.Scan(&c.ID, &c.IndicatorID, &c.ClientID, &c.Target, &c.Status,
+			&c.ExternalID, &c.Attempts); err != nil {
+			return nil, err
+		}
+		claims = append(claims, c)
+	}
+	return claims, rows.Err()
+}
+
+// Record stores the outcome of delivering a claim. Failures are retried
+// with exponential backoff until maxAttempts is reached. A claim that was
+// re-planned while in flight is left to its new plan.
+func (s *Store) Record(ctx context.Context, c Claim, outcome Outcome) error {
+	if outcome.Err == nil {
+		status := StateSynced
+		if c.Status == StateRemoving {
+			status = StateRemoved
+		}
+		_, err := s.db.ExecContext(ctx, `
+			UPDATE deployment_mgmt.indicator_distributions
+			SET status = $3,
+				external_id = CASE WHEN $3 = 'synced' THEN COALESCE(NULLIF($4, ''), external_id) ELSE external_id END,
+				attempts = 0,
+				last_error = NULL,
+				synced_at = CASE WHEN $3 = 'synced' THEN NOW() ELSE synced_at END,
+				updated_at = NOW()
+			WHERE id = $1 AND status = $2`, c.ID, c.Status, status, outcome.ExternalID)
+		if err != nil {
+			return err
+		}
+		if status == StateSynced && c.Target == TargetSentinel {
+			_, err = s.db.ExecContext(ctx, `
+				UPDATE deployment_mgmt.threat_indicators
+				SET synced_to_sentinel = true, synced_at = NOW()
+				WHERE id = $1`, c.IndicatorID)
+		}
+		return err
+	}
+
+	status := c.Status
+	if c.Attempts >= maxAttempts {
+		status = StateFailed
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.indicator_distributions
+		SET status = $3,
+			last_error = $4,
+			next_attempt_at = NOW() + $5::int * INTERVAL '1 second',
+			updated_at = NOW()
+		WHERE id = $1 AND status = $2`,
+		c.ID, c.Status, status, outcome.Err.Error(), int(backoff(c.Attempts).Seconds()))
+	return err
+}
+
+// backoff doubles the retry delay with every attempt, up to six hours
+func backoff(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < 6*time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > 6*time.Hour {
+		delay = 6 * time.Hour
+	}
+	return delay
+}
+
+// States returns the sync state of an indicator on every client target
+func (s *Store) States(ctx context.Context, indicatorID string) ([]State, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT client_id, target, status, COALESCE(external_id, ''), attempts,
+			COALESCE(last_error, ''), next_attempt_at, synced_at, updated_at
+		FROM deployment_mgmt.indicator_distributions
+		WHERE indicator_id = $1
+		ORDER BY client_id, target`, indicatorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := []State{}
+	for rows.Next() {
+		var st State
+		var next, synced sql.NullTime
+		if err := rows.Scan(&st.ClientID, &st.Target, &st.Status, &st.ExternalID, &st.Attempts,
+			&st.LastError, &next, &synced, &st.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if next.Valid && (st.Status == StatePending || st.Status == StateRemoving) {
+			st.NextAttemptAt = &next.Time
+		}
+		if synced.Valid {
+			st.SyncedAt = &synced.Time
+		}
+		states = append(states, st)
+	}
+	return states, rows.Err()
+}
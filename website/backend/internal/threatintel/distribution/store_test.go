@@ -0,0 +1,295 @@
+package distribution
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// addIndicator stores an indicator for a client and returns it as read back
+func addIndicator(t *testing.T, db *sql.DB, clientID, value string) *indicator.Indicator {
+	t.Helper()
+	ctx := context.Background()
+	store := indicator.NewStore(db)
+	_, err := store.Upsert(ctx, []indicator.Indicator{{
+		ClientID: clientID, Type: indicator.TypeIPv4, Value: value,
+		ThreatType: "C2", Confidence: 80, Source: "test",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id string
+	if err := db.QueryRow(`
+		SELECT id FROM deployment_mgmt.threat_indicators
+		WHERE client_id = $1 AND indicator_value = $2`, clientID, value).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	ind, err := store.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ind
+}
+
+// state returns the sync state of an indicator on a target
+func state(t *testing.T, s *Store, indicatorID, target string) State {
+	t.Helper()
+	states, err := s.States(context.Background(), indicatorID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range states {
+		if st.Target == target {
+			return st
+		}
+	}
+	t.Fatalf("indicator %s has no %s state in %+v", indicatorID, target, states)
+	return State{}
+}
+
+func claimOne(t *testing.T, s *Store) Claim {
+	t.Helper()
+	claims, err := s.Claim(context.Background(), Scope{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims) != 1 {
+		t.Fatalf("claimed %d items, want 1: %+v", len(claims), claims)
+	}
+	return claims[0]
+}
+
+func TestStorePublishAndRemove(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	s := NewStore(db)
+	clientID := dbtest.CreateClient(t, db, "Contoso")
+	ind := addIndicator(t, db, clientID, "45.33.32.156")
+	assignment := Assignment{IndicatorID: ind.ID, ClientID: clientID, Target: TargetMDE, PlannedAt: ind.UpdatedAt}
+
+	if err := s.Plan(ctx, []string{ind.ID}, []Assignment{assignment}); err != nil {
+		t.Fatal(err)
+	}
+	if st := state(t, s, ind.ID, TargetMDE); st.Status != StatePending {
+		t.Fatalf("planned status = %s, want pending", st.Status)
+	}
+
+	c := claimOne(t, s)
+	if c.IndicatorID != ind.ID || c.Status != StatePending || c.Attempts != 1 {
+		t.Fatalf("claim = %+v, want the first attempt at publishing the indicator", c)
+	}
+	// Claimed items are leased until recorded
+	if again, err := s.Claim(ctx, Scope{}, 10); err != nil || len(again) != 0 {
+		t.Fatalf("second claim = %+v, %v; want nothing while the first is in flight", again, err)
+	}
+	if err := s.Record(ctx, c, Outcome{ExternalID: "mde-1"}); err != nil {
+		t.Fatal(err)
+	}
+	st := state(t, s, ind.ID, TargetMDE)
+	if st.Status != StateSynced || st.ExternalID != "mde-1" || st.SyncedAt == nil || st.Attempts != 0 {
+		t.Fatalf("state after publishing = %+v, want synced as mde-1", st)
+	}
+
+	// Planning the same version again changes nothing
+	if err := s.Plan(ctx, []string{ind.ID}, []Assignment{assignment}); err != nil {
+		t.Fatal(err)
+	}
+	if st := state(t, s, ind.ID, TargetMDE); st.Status != StateSynced {
+		t.Fatalf("status after replanning the same version = %s, want synced", st.Status)
+	}
+
+	// A newer version is published again
+	assignment.PlannedAt = assignment.PlannedAt.Add(time.Minute)
+	if err := s.Plan(ctx, []string{ind.ID}, []Assignment{assignment}); err != nil {
+		t.Fatal(err)
+	}
+	if st := state(t, s, ind.ID, TargetMDE); st.Status != StatePending || st.ExternalID != "mde-1" {
+		t.Fatalf("state after a newer version = %+v, want pending with the external ID kept", st)
+	}
+	if err := s.Record(ctx, claimOne(t, s), Outcome{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// No longer wanted, it is removed using the ID the target gave it
+	if err := s.Plan(ctx, []string{ind.ID}, nil); err != nil {
+		t.Fatal(err)
+	}
+	c = claimOne(t, s)
+	if c.Status != StateRemoving || c.ExternalID != "mde-1" {
+		t.Fatalf("removal claim = %+v, want removing mde-1", c)
+	}
+	if err := s.Record(ctx, c, Outcome{}); err != nil {
+		t.Fatal(err)
+	}
+	if st := state(t, s, ind.ID, TargetMDE); st.Status != StateRemoved {
+		t.Fatalf("status after removal = %s, want removed", st.Status)
+	}
+}
+
+func TestStorePlanDropsUndelivered(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	s := NewStore(db)
+	clientID := dbtest.CreateClient(t, db, "Contoso")
+	ind := addIndicator(t, db, clientID, "45.33.32.156")
+
+	err := s.Plan(ctx, []string{ind.ID}, []Assignment{
+		{IndicatorID: ind.ID, ClientID: clientID, Target: TargetSentinel, PlannedAt: ind.UpdatedAt},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Plan(ctx, []string{ind.ID}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if st := state(t, s, ind.ID, TargetSentinel); st.Status != StateRemoved {
+		t.Fatalf("status = %s, want removed without a delivery", st.Status)
+	}
+	if claims, err := s.Claim(ctx, Scope{}, 10); err != nil || len(claims) != 0 {
+		t.Fatalf("claims = %+v, %v; want nothing to remove", claims, err)
+	}
+}
+
+func TestStoreRecordFailure(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	s := NewStore(db)
+	clientID := dbtest.CreateClient(t, db, "Contoso")
+	ind := addIndicator(t, db, clientID, "45.33.32.156")
+	err := s.Plan(ctx, []string{ind.ID}, []Assignment{
+		{IndicatorID: ind.ID, ClientID: clientID, Target: TargetMDE, PlannedAt: ind.UpdatedAt},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := claimOne(t, s)
+	if err := s.Record(ctx, c, Outcome{Err: errors.New("throttled")}); err != nil {
+		t.Fatal(err)
+	}
+	st := state(t, s, ind.ID, TargetMDE)
+	if st.Status != StatePending || st.LastError != "throttled" {
+		t.Fatalf("state after a failure = %+v, want pending with the error", st)
+	}
+	if st.NextAttemptAt == nil || time.Until(*st.NextAttemptAt) < 30*time.Second {
+		t.Fatalf("next attempt = %v, want backed off by about %s", st.NextAttemptAt, backoff(1))
+	}
+
+	// The last attempt fails the item until it is retried
+	c.Attempts = maxAttempts
+	if err := s.Record(ctx, c, Outcome{Err: errors.New("throttled")}); err != nil {
+		t.Fatal(err)
+	}
+	if st := state(t, s, ind.ID, TargetMDE); st.Status != StateFailed {
+		t.Fatalf("status after %d attempts = %s, want failed", maxAttempts, st.Status)
+	}
+	n, err := s.Retry(ctx, Scope{ClientID: clientID})
+	if err != nil || n != 1 {
+		t.Fatalf("Retry = %d, %v; want 1 item", n, err)
+	}
+	if c := claimOne(t, s); c.Attempts != 1 {
+		t.Fatalf("retried claim = %+v, want its attempts reset", c)
+	}
+}
+
+func TestStoreRecordIgnoresReplannedClaims(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	s := NewStore(db)
+	clientID := dbtest.CreateClient(t, db, "Contoso")
+	ind := addIndicator(t, db, clientID, "45.33.32.156")
+	assignment := Assignment{IndicatorID: ind.ID, ClientID: clientID, Target: TargetMDE, PlannedAt: ind.UpdatedAt}
+	if err := s.Plan(ctx, []string{ind.ID}, []Assignment{assignment}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Record(ctx, claimOne(t, s), Outcome{ExternalID: "mde-1"}); err != nil {
+		t.Fatal(err)
+	}
+	assignment.PlannedAt = assignment.PlannedAt.Add(time.Minute)
+	if err := s.Plan(ctx, []string{ind.ID}, []Assignment{assignment}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The indicator is retracted while the publish is in flight
+	c := claimOne(t, s)
+	if err := s.Plan(ctx, []string{ind.ID}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Record(ctx, c, Outcome{ExternalID: "mde-2"}); err != nil {
+		t.Fatal(err)
+	}
+	st := state(t, s, ind.ID, TargetMDE)
+	if st.Status != StateRemoving || st.ExternalID != "mde-1" {
+		t.Fatalf("state = %+v, want the removal planned after the claim to stand", st)
+	}
+}
+
+func TestStoreDrainQueue(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	s := NewStore(db)
+	clientID := dbtest.CreateClient(t, db, "Contoso")
+	expired := addIndicator(t, db, clientID, "45.33.32.156")
+	renewed := addIndicator(t, db, clientID, "45.33.32.157")
+	for _, ind := range []*indicator.Indicator{expired, renewed} {
+		err := s.Plan(ctx, []string{ind.ID}, []Assignment{
+			{IndicatorID: ind.ID, ClientID: clientID, Target: TargetMDE, PlannedAt: ind.UpdatedAt},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	claims, err := s.Claim(ctx, Scope{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range claims {
+		if err := s.Record(ctx, c, Outcome{ExternalID: "mde-" + c.IndicatorID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The sweeper queued both, but one was renewed before the queue drained
+	if _, err := db.Exec(`
+		UPDATE deployment_mgmt.threat_indicators SET is_active = false WHERE id = $1`, expired.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO deployment_mgmt.indicator_distribution_queue (indicator_id, action, reason)
+		VALUES ($1, 'remove', 'expired'), ($2, 'remove', 'expired')`, expired.ID, renewed.ID); err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.DrainQueue(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("DrainQueue = %d, %v; want 2 entries processed", n, err)
+	}
+	if st := state(t, s, expired.ID, TargetMDE); st.Status != StateRemoving {
+		t.Errorf("expired indicator status = %s, want removing", st.Status)
+	}
+	if st := state(t, s, renewed.ID, TargetMDE); st.Status != StateSynced {
+		t.Errorf("renewed indicator status = %s, want synced", st.Status)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{20, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package enrichment
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/lib/pq"
+)
+
+// Cache keeps provider results so repeated lookups of an indicator do not
+// spend provider quota
+type Cache interface {
+	// Get returns an unexpired result, or nil
+	Get(ctx context.Context, t indicator.Type, value, provider string) (*Result, error)
+	// Put stores a result until it is ttl old
+	Put(ctx context.Context, t indicator.Type, value string, r *Result, ttl time.Duration) error
+}
+
+// DBCache stores results in deployment_mgmt.indicator_enrichments, which
+// also backs the enrichment shown with an indicator
+type DBCache struct {
+	db *sql.DB
+}
+
+// NewDBCache creates a database backed cache
+func NewDBCache(db *sql.DB) *DBCache {
+	return &DBCache{db: db}
+}
+
+// Get implements Cache
+func (c *DBCache) Get(ctx context.Context, t indicator.Type, value, provider string) (*Result, error) {
+	r := Result{Provider: provider, Cached: true}
+	var verdict string
+	var details []byte
+	err := c.db.QueryRowContext(ctx, `
+		SELECT verdict, score, tags, details, enriched_at
+		FROM deployment_mgmt.indicator_enrichments
+		WHERE indicator_type = $1 AND indicator_value = $2 AND provider = $3
+		AND expires_at > NOW()`, string(t), value, provider).Scan(
+		&verdict, &r.Score, pq.Array(&r.Tags), &details, &r.EnrichedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.Verdict = Verdict(verdict)
+	if len(details) > 0 {
+		if err := json.Unmarshal(details, &r.Details); err != nil {
+			return nil, err
+		}
+	}
+	return &r, nil
+}
+
+// Put implements Cache
+func (c *DBCache) Put(ctx context.Context, t indicator.Type, value string, r *Result, ttl time.Duration) error {
+	details, err := json.Marshal(r.Details)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.ExecContext(ctx, `
+		INSERT INTO deployment_mgmt.indicator_enrichments
+			(indicator_type, indicator_value, provider, verdict, score, tags, details, enriched_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8 + $9::int * INTERVAL '1 second')
+		ON CONFLICT (indicator_type, indicator_value, provider) DO UPDATE SET
+			verdict = EXCLUDED.verdict,
+			score = EXCLUDED.score,
+			tags = EXCLUDED.tags,
+			details = EXCLUDED.details,
+			enriched_at = EXCLUDED.enriched_at,
+			expires_at = EXCLUDED.expires_at`,
+		string(t), value, r.Provider, string(r.Verdict), r.Score, pq.Array(r.Tags), details,
+		r.EnrichedAt, int(ttl.Seconds()))
+	return err
+}
+
+// Results returns the latest result of every provider for an indicator,
+// expired or not
+func (c *DBCache) Results(ctx context.Context, t indicator.Type, value string) ([]Result, error) {
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT provider, verdict, score, tags, details, enriched_at
+		FROM deployment_mgmt.indicator_enrichments
+		WHERE indicator_type = $1 AND indicator_value = $2
+		ORDER BY provider`, string(t), value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []Result{}
+	for rows.Next() {
+		r := Result{Cached: true}
+		var verdict string
+		var details []byte
+		if err := rows.Scan(&r.Provider, &verdict, &r.Score, pq.Array(&r.Tags), &details, &r.EnrichedAt); err != nil {
+			return nil, err
+		}
+		r.Verdict = Verdict(verdict)
+		if len(details) > 0 {
+			if err := json.Unmarshal(details, &r.Details); err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// MemoryCache is an in-process cache for running without a database
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	result  Result
+	expires time.Time
+}
+
+// NewMemoryCache creates an empty in-process cache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]memoryEntry)}
+}
+
+func memoryKey(t indicator.Type, value, provider string) string {
+	return string(t) + "\x00" + value + "\x00" + provider
+}
+
+// Get implements Cache
+func (c *MemoryCache) Get(ctx context.Context, t indicator.Type, value, provider string) (*Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[memoryKey(t, value, provider)]
+	if !ok || !time.Now().Before(e.expires) {
+		return nil, nil
+	}
+	r := e.result
+	r.Cached = true
+	return &r, nil
+}
+
+// Put implements Cache
+func (c *MemoryCache) Put(ctx context.Context, t indicator.Type, value string, r *Result, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[memoryKey(t, value, r.Provider)] = memoryEntry{result: *r, expires: r.EnrichedAt.Add(ttl)}
+	return nil
+}
@@ -0,0 +1,37 @@
+package enrichment_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/enrichment"
+)
+
+func TestMemoryCacheExpiry(t *testing.T) {
+	ctx := context.Background()
+	c := enrichment.NewMemoryCache()
+	fresh := &enrichment.Result{Provider: "virustotal", Verdict: enrichment.VerdictMalicious, EnrichedAt: time.Now()}
+	stale := &enrichment.Result{Provider: "msti", Verdict: enrichment.VerdictHarmless, EnrichedAt: time.Now().Add(-2 * time.Hour)}
+	for _, r := range []*enrichment.Result{fresh, stale} {
+		if err := c.Put(ctx, indicator.TypeIPv4, "45.33.32.156", r, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := c.Get(ctx, indicator.TypeIPv4, "45.33.32.156", "virustotal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Verdict != enrichment.VerdictMalicious || !got.Cached {
+		t.Fatalf("fresh result = %+v, want it cached", got)
+	}
+	// The TTL runs from when the provider answered, not when it was stored
+	if got, _ := c.Get(ctx, indicator.TypeIPv4, "45.33.32.156", "msti"); got != nil {
+		t.Errorf("stale result = %+v, want expired", got)
+	}
+	if got, _ := c.Get(ctx, indicator.TypeDomain, "45.33.32.156", "virustotal"); got != nil {
+		t.Errorf("result for another type = %+v, want nothing", got)
+	}
+}
@@ -0,0 +1,143 @@
+package enrichment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// DefaultCriminalIPURL is the Criminal IP API
+const DefaultCriminalIPURL = "https://api.criminalip.io"
+
+// CriminalIP looks IP addresses up in Criminal IP, like the
+// http-criminalIP-Enrichment Logic App
+type CriminalIP struct {
+	APIKey     string
+	BaseURL    string
+	HTTPClient *http.Client
+	Quota      Limit
+}
+
+// NewCriminalIP creates a Criminal IP provider
+func NewCriminalIP(apiKey string) *CriminalIP {
+	return &CriminalIP{
+		APIKey:     apiKey,
+		BaseURL:    DefaultCriminalIPURL,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Quota:      Limit{Requests: 1, Per: time.Second},
+	}
+}
+
+// Name implements Provider
+func (c *CriminalIP) Name() string {
+	return "criminalip"
+}
+
+// Limit implements Provider
+func (c *CriminalIP) Limit() Limit {
+	return c.Quota
+}
+
+// Supports implements Provider
+func (c *CriminalIP) Supports(t indicator.Type) bool {
+	return t == indicator.TypeIPv4 || t == indicator.TypeIPv6
+}
+
+// criminalIPLevels maps Criminal IP score levels to a verdict and score
+var criminalIPLevels = map[string]struct {
+	verdict Verdict
+	score   int
+}{
+	"critical":  {VerdictMalicious, 100},
+	"dangerous": {VerdictMalicious, 80},
+	"moderate":  {VerdictSuspicious, 50},
+	"low":       {VerdictHarmless, 20},
+	"safe":      {VerdictHarmless, 0},
+}
+
+// Lookup implements Provider
+func (c *CriminalIP) Lookup(ctx context.Context, ind *indicator.Indicator) (*Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimRight(c.BaseURL, "/")+"/v1/asset/ip/report/summary?ip="+url.QueryEscape(ind.Value), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-api-key", c.APIKey)
+
+	// The summary is returned either at the top level or under "data"
+	type summary struct {
+		Score       json.RawMessage `json:"score"`
+		CountryName string          `json:"country_name"`
+		Country     string          `json:"country"`
+		ISP         string          `json:"isp"`
+	}
+	var body struct {
+		summary
+		Status int      `json:"status"`
+		Data   *summary `json:"data"`
+	}
+	found, err := getJSON(c.HTTPClient, req, c.Name(), &body)
+	if err != nil {
+		return nil, err
+	}
+	s := body.summary
+	if body.Data != nil {
+		s = *body.Data
+	}
+	if !found || len(s.Score) == 0 {
+		return &Result{Verdict: VerdictUnknown}, nil
+	}
+
+	// Scores are either a single level or separate inbound and outbound
+	// levels, of which the worse counts
+	var levels []string
+	var single string
+	if json.Unmarshal(s.Score, &single) == nil {
+		levels = append(levels, single)
+	} else {
+		var split struct {
+			Inbound  string `json:"inbound"`
+			Outbound string `json:"outbound"`
+		}
+		if err := json.Unmarshal(s.Score, &split); err != nil {
+			return nil, err
+		}
+		levels = append(levels, split.Inbound, split.Outbound)
+	}
+
+	r := &Result{Verdict: VerdictUnknown, Details: map[string]interface{}{}}
+	for _, level := range levels {
+		l, ok := criminalIPLevels[strings.ToLower(level)]
+		if !ok {
+			continue
+		}
+		if l.score >= r.Score {
+			r.Score = l.score
+			if l.verdict.severity() > r.Verdict.severity() {
+				r.Verdict = l.verdict
+			}
+			r.Details["level"] = level
+		}
+	}
+	if country := firstNonEmpty(s.CountryName, s.Country); country != "" {
+		r.Details["country"] = country
+	}
+	if s.ISP != "" {
+		r.Details["isp"] = s.ISP
+	}
+	return r, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,305 @@
+package enrichment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/lib/pq"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultTTL is how long a provider result is reused
+	DefaultTTL = 24 * time.Hour
+	// DefaultNegativeTTL is how long an unknown verdict is reused; services
+	// often learn about new indicators within hours
+	DefaultNegativeTTL = 6 * time.Hour
+
+	defaultRefreshAfter = 7 * 24 * time.Hour
+	defaultRetryAfter   = time.Hour
+	defaultInterval     = 5 * time.Minute
+	defaultBatchSize    = 50
+)
+
+// source is a provider with its rate limiter
+type source struct {
+	Provider
+	limiter *rate.Limiter
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+func newSource(p Provider) *source {
+	limit := p.Limit()
+	l := rate.NewLimiter(rate.Inf, 1)
+	if limit.Requests > 0 && limit.Per > 0 {
+		l = rate.NewLimiter(rate.Every(limit.Per/time.Duration(limit.Requests)), 1)
+	}
+	return &source{Provider: p, limiter: l}
+}
+
+func (s *source) paused() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pausedUntil, time.Now().Before(s.pausedUntil)
+}
+
+func (s *source) pause(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if until := time.Now().Add(d); until.After(s.pausedUntil) {
+		s.pausedUntil = until
+	}
+}
+
+// Report is the outcome of enriching one indicator
+type Report struct {
+	IndicatorID string            `json:"indicatorId"`
+	Results     []Result          `json:"results"`
+	Errors      map[string]string `json:"errors,omitempty"`
+	Assessment  Assessment        `json:"assessment"`
+}
+
+// Enricher runs indicators past every provider that supports them and
+// applies the combined assessment. Background runs pick active indicators
+// that were never enriched or whose enrichment is older than a week.
+type Enricher struct {
+	db          *sql.DB
+	indicators  *indicator.Store
+	cache       Cache
+	sources     []*source
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	interval    time.Duration
+	batchSize   int
+}
+
+// NewEnricher creates an enricher over the given providers
+func NewEnricher(db *sql.DB, indicators *indicator.Store, cache Cache, providers ...Provider) *Enricher {
+	e := &Enricher{
+		db:          db,
+		indicators:  indicators,
+		cache:       cache,
+		TTL:         DefaultTTL,
+		NegativeTTL: DefaultNegativeTTL,
+		interval:    defaultInterval,
+		batchSize:   defaultBatchSize,
+	}
+	for _, p := range providers {
+		e.sources = append(e.sources, newSource(p))
+	}
+	return e
+}
+
+// Providers returns the names of the configured providers
+func (e *Enricher) Providers() []string {
+	names := make([]string, len(e.sources))
+	for i, s := range e.sources {
+		names[i] = s.Name()
+	}
+	return names
+}
+
+// Lookup collects the results of every provider supporting the indicator,
+// from the cache where possible. When wait is false, providers that would
+// have to wait for quota are skipped and reported as errors.
+func (e *Enricher) Lookup(ctx context.Context, ind *indicator.Indicator, wait bool) ([]Result, map[string]string) {
+	var results []Result
+	errs := make(map[string]string)
+
+	for _, s := range e.sources {
+		if !s.Supports(ind.Type) {
+			continue
+		}
+		cached, err := e.cache.Get(ctx, ind.Type, ind.Value, s.Name())
+		if err != nil {
+			log.Printf("Error reading enrichment cache for %s: %v", s.Name(), err)
+		}
+		if cached != nil {
+			results = append(results, *cached)
+			continue
+		}
+
+		if until, paused := s.paused(); paused {
+			errs[s.Name()] = fmt.Sprintf("rate limited until %s", until.Format(time.RFC3339))
+			continue
+		}
+		if wait {
+			if err := s.limiter.Wait(ctx); err != nil {
+				errs[s.Name()] = err.Error()
+				continue
+			}
+		} else if !s.limiter.Allow() {
+			errs[s.Name()] = "rate limited"
+			continue
+		}
+
+		r, err := s.Lookup(ctx, ind)
+		if err != nil {
+			var limited *RateLimitError
+			if errors.As(err, &limited) {
+				s.pause(limited.RetryAfter)
+			}
+			errs[s.Name()] = err.Error()
+			continue
+		}
+		r.Provider = s.Name()
+		if r.EnrichedAt.IsZero() {
+			r.EnrichedAt = time.Now().UTC()
+		}
+		ttl := e.TTL
+		if r.Verdict == VerdictUnknown {
+			ttl = e.NegativeTTL
+		}
+		if err := e.cache.Put(ctx, ind.Type, ind.Value, r, ttl); err != nil {
+			log.Printf("Error writing enrichment cache for %s: %v", s.Name(), err)
+		}
+		results = append(results, *r)
+	}
+	return results, errs
+}
+
+// Enrich looks an indicator up and applies the assessment to it
+func (e *Enricher) Enrich(ctx context.Context, ind *indicator.Indicator, wait bool) (*Report, error) {
+	results, errs := e.Lookup(ctx, ind, wait)
+	report := &Report{
+		IndicatorID: ind.ID,
+		Results:     results,
+		Assessment:  Assess(results),
+	}
+	if len(errs) > 0 {
+		report.Errors = errs
+	}
+	if report.Results == nil {
+		report.Results = []Result{}
+	}
+
+	// Incomplete enrichments are retried sooner than complete ones are refreshed
+	enrichedAt := time.Now()
+	if len(errs) > 0 {
+		enrichedAt = enrichedAt.Add(defaultRetryAfter - defaultRefreshAfter)
+	}
+	if err := e.apply(ctx, ind.ID, report.Assessment, enrichedAt); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// apply replaces the indicator's previous enrichment adjustment and tags
+// with the new assessment. The adjustment is applied to base_confidence as
+// well so confidence decay starts from the enriched value.
+func (e *Enricher) apply(ctx context.Context, id string, a Assessment, enrichedAt time.Time) error {
+	tags := append([]string{}, a.Tags...)
+	sort.Strings(tags)
+	_, err := e.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.threat_indicators
+		SET confidence = LEAST(100, GREATEST(0, confidence - enrichment_adjustment + $2)),
+			base_confidence = LEAST(100, GREATEST(0,
+				COALESCE(base_confidence, confidence) - enrichment_adjustment + $2)),
+			tags = ARRAY(
+				SELECT DISTINCT t FROM unnest(COALESCE(tags, '{}')) t
+				WHERE NOT t = ANY(enrichment_tags)
+				UNION SELECT unnest($3::text[])
+			),
+			enrichment_adjustment = $2,
+			enrichment_tags = $3,
+			enriched_at = $4,
+			updated_at = CASE WHEN enrichment_adjustment <> $2 OR enrichment_tags <> $3::text[]
+				THEN NOW() ELSE updated_at END
+		WHERE id = $1`, id, a.Adjustment, pq.Array(tags), enrichedAt)
+	return err
+}
+
+// Run enriches indicators in the background until the context is cancelled
+func (e *Enricher) Run(ctx context.Context) {
+	if len(e.sources) == 0 {
+		return
+	}
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := e.EnrichDue(ctx)
+		if err != nil {
+			log.Printf("Error enriching threat indicators: %v", err)
+		} else if n > 0 {
+			log.Printf("Enriched %d threat indicators", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// EnrichDue enriches one batch of indicators that are due, highest
+// confidence first, and returns how many were enriched
+func (e *Enricher) EnrichDue(ctx context.Context) (int, error) {
+	var types []string
+	for _, t := range []indicator.Type{
+		indicator.TypeIPv4, indicator.TypeIPv6, indicator.TypeCIDR, indicator.TypeDomain,
+		indicator.TypeURL, indicator.TypeMD5, indicator.TypeSHA1, indicator.TypeSHA256,
+		indicator.TypeEmail, indicator.TypeCertificate,
+	} {
+		for _, s := range e.sources {
+			if s.Supports(t) {
+				types = append(types, string(t))
+				break
+			}
+		}
+	}
+	if len(types) == 0 {
+		return 0, nil
+	}
+
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT id FROM deployment_mgmt.threat_indicators
+		WHERE is_active = true
+		AND indicator_type = ANY($1)
+		AND (enriched_at IS NULL OR enriched_at < NOW() - $2::int * INTERVAL '1 second')
+		ORDER BY enriched_at NULLS FIRST, confidence DESC
+		LIMIT $3`, pq.Array(types), int(defaultRefreshAfter.Seconds()), e.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	var due []*indicator.Indicator
+	err = e.indicators.ForEach(ctx, indicator.Filter{IDs: ids}, func(ind *indicator.Indicator) error {
+		due = append(due, ind)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	enriched := 0
+	for _, ind := range due {
+		if _, err := e.Enrich(ctx, ind, true); err != nil {
+			return enriched, err
+		}
+		enriched++
+	}
+	return enriched, nil
+}
@@ -0,0 +1,106 @@
+package enrichment_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/enrichment"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/enrichment/enrichmenttest"
+)
+
+func ip(value string) *indicator.Indicator {
+	return &indicator.Indicator{ID: "1", Type: indicator.TypeIPv4, Value: value}
+}
+
+func TestEnricherCachesKnownVerdictsLonger(t *testing.T) {
+	ctx := context.Background()
+	p := enrichmenttest.NewProvider("virustotal")
+	p.Set("45.33.32.156", enrichment.VerdictMalicious, 90)
+	e := enrichment.NewEnricher(nil, nil, enrichment.NewMemoryCache(), p)
+	e.TTL = time.Hour
+	// Unknown verdicts expire at once, so they reach the provider every time
+	e.NegativeTTL = 0
+
+	for i := 0; i < 2; i++ {
+		results, errs := e.Lookup(ctx, ip("45.33.32.156"), false)
+		if len(errs) != 0 || len(results) != 1 || results[0].Verdict != enrichment.VerdictMalicious {
+			t.Fatalf("lookup %d = %+v, %v", i, results, errs)
+		}
+		if results[0].Cached != (i > 0) || results[0].Provider != "virustotal" {
+			t.Errorf("lookup %d = %+v, want cached only after the first", i, results[0])
+		}
+	}
+	if p.Calls() != 1 {
+		t.Fatalf("known verdict looked up %d times, want 1", p.Calls())
+	}
+
+	for i := 0; i < 2; i++ {
+		results, _ := e.Lookup(ctx, ip("198.51.100.7"), false)
+		if len(results) != 1 || results[0].Verdict != enrichment.VerdictUnknown || results[0].Cached {
+			t.Fatalf("lookup %d of an unknown value = %+v, want a fresh unknown verdict", i, results)
+		}
+	}
+	if p.Calls() != 3 {
+		t.Errorf("provider called %d times, want the unknown value looked up twice", p.Calls())
+	}
+}
+
+func TestEnricherSkipsWithoutQuota(t *testing.T) {
+	ctx := context.Background()
+	p := enrichmenttest.NewProvider("virustotal")
+	p.Quota = enrichment.Limit{Requests: 1, Per: time.Hour}
+	e := enrichment.NewEnricher(nil, nil, enrichment.NewMemoryCache(), p)
+
+	if _, errs := e.Lookup(ctx, ip("45.33.32.156"), false); len(errs) != 0 {
+		t.Fatalf("first lookup failed: %v", errs)
+	}
+	results, errs := e.Lookup(ctx, ip("45.33.32.157"), false)
+	if len(results) != 0 || errs["virustotal"] != "rate limited" {
+		t.Fatalf("lookup over quota = %+v, %v; want it skipped", results, errs)
+	}
+	if p.Calls() != 1 {
+		t.Errorf("provider called %d times, want 1", p.Calls())
+	}
+
+	// Waiting gives up when the context ends first
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, errs := e.Lookup(waitCtx, ip("45.33.32.158"), true); errs["virustotal"] == "" {
+		t.Error("waiting lookup succeeded without quota")
+	}
+	if p.Calls() != 1 {
+		t.Errorf("provider called %d times, want 1", p.Calls())
+	}
+}
+
+func TestEnricherPausesOnRateLimitError(t *testing.T) {
+	ctx := context.Background()
+	limited := enrichmenttest.NewProvider("virustotal")
+	limited.Err = &enrichment.RateLimitError{Provider: "virustotal", RetryAfter: time.Hour}
+	other := enrichmenttest.NewProvider("msti")
+	other.Set("45.33.32.156", enrichment.VerdictSuspicious, 50)
+	e := enrichment.NewEnricher(nil, nil, enrichment.NewMemoryCache(), limited, other)
+
+	results, errs := e.Lookup(ctx, ip("45.33.32.156"), true)
+	if len(results) != 1 || results[0].Provider != "msti" {
+		t.Fatalf("results = %+v, want the other provider's", results)
+	}
+	if !strings.Contains(errs["virustotal"], "rate limit exceeded") {
+		t.Fatalf("errors = %v, want the rate limit reported", errs)
+	}
+
+	// The paused provider is not asked again, even when waiting is allowed
+	_, errs = e.Lookup(ctx, ip("45.33.32.157"), true)
+	if !strings.HasPrefix(errs["virustotal"], "rate limited until ") {
+		t.Fatalf("errors = %v, want the provider paused", errs)
+	}
+	if limited.Calls() != 1 {
+		t.Errorf("paused provider called %d times, want 1", limited.Calls())
+	}
+	if other.Calls() != 2 {
+		t.Errorf("other provider called %d times, want 2", other.Calls())
+	}
+}
@@ -0,0 +1,162 @@
+// Package enrichment looks indicators up in external reputation services
+// and folds the verdicts back into their confidence and tags
+package enrichment
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// Verdict is a provider's opinion of an indicator
+type Verdict string
+
+// Verdicts, from most to least severe
+const (
+	VerdictMalicious  Verdict = "malicious"
+	VerdictSuspicious Verdict = "suspicious"
+	VerdictHarmless   Verdict = "harmless"
+	VerdictUnknown    Verdict = "unknown"
+)
+
+func (v Verdict) severity() int {
+	switch v {
+	case VerdictMalicious:
+		return 3
+	case VerdictSuspicious:
+		return 2
+	case VerdictHarmless:
+		return 1
+	}
+	return 0
+}
+
+// Result is one provider's report on an indicator
+type Result struct {
+	Provider string  `json:"provider"`
+	Verdict  Verdict `json:"verdict"`
+	// Score is the provider's maliciousness score scaled to 0-100
+	Score      int                    `json:"score"`
+	Tags       []string               `json:"tags,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	EnrichedAt time.Time              `json:"enrichedAt"`
+	Cached     bool                   `json:"cached"`
+}
+
+// Limit is a provider's request quota
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// Provider looks indicators up in one reputation service
+type Provider interface {
+	// Name identifies the provider in results, tags and the cache
+	Name() string
+	// Supports reports whether the service can look up a type
+	Supports(t indicator.Type) bool
+	// Limit returns the request quota the enricher keeps to
+	Limit() Limit
+	// Lookup reports on an indicator. Indicators the service has never
+	// seen are reported with VerdictUnknown rather than an error.
+	Lookup(ctx context.Context, ind *indicator.Indicator) (*Result, error)
+}
+
+// RateLimitError is returned by providers when the service refuses a
+// request for exceeding its quota
+type RateLimitError struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded, retry after %s", e.Provider, e.RetryAfter)
+}
+
+// Assessment is the combined effect of provider results on an indicator
+type Assessment struct {
+	Verdict Verdict `json:"verdict"`
+	// Adjustment is added to the indicator's reported confidence
+	Adjustment int      `json:"adjustment"`
+	Tags       []string `json:"tags"`
+}
+
+const (
+	maliciousBoost     = 20
+	maliciousFollowUp  = 10
+	maxBoost           = 40
+	suspiciousBoost    = 5
+	maxSuspiciousBoost = 10
+	harmlessPenalty    = 15
+	maxPenalty         = 30
+	// maxProviderTags caps the tags taken over from each provider
+	maxProviderTags = 5
+)
+
+// Assess combines provider results. The first malicious verdict raises
+// confidence by 20 and each further one by 10, up to 40; suspicious
+// verdicts add 5 each, up to 10. Only when no provider flags the indicator
+// do harmless verdicts lower confidence, by 15 each, up to 30.
+func Assess(results []Result) Assessment {
+	a := Assessment{Verdict: VerdictUnknown}
+	var malicious, suspicious, harmless int
+	tags := make(map[string]bool)
+
+	for _, r := range results {
+		if r.Verdict.severity() > a.Verdict.severity() {
+			a.Verdict = r.Verdict
+		}
+		switch r.Verdict {
+		case VerdictMalicious:
+			malicious++
+		case VerdictSuspicious:
+			suspicious++
+		case VerdictHarmless:
+			harmless++
+		}
+		if r.Verdict != VerdictUnknown {
+			tags[r.Provider+":"+string(r.Verdict)] = true
+		}
+		for i, tag := range r.Tags {
+			if i == maxProviderTags {
+				break
+			}
+			if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+				tags[tag] = true
+			}
+		}
+	}
+
+	if malicious > 0 {
+		a.Adjustment = min(maliciousBoost+(malicious-1)*maliciousFollowUp, maxBoost)
+	}
+	a.Adjustment += min(suspicious*suspiciousBoost, maxSuspiciousBoost)
+	if malicious == 0 && suspicious == 0 {
+		a.Adjustment = -min(harmless*harmlessPenalty, maxPenalty)
+	}
+
+	a.Tags = make([]string, 0, len(tags))
+	for tag := range tags {
+		a.Tags = append(a.Tags, tag)
+	}
+	sort.Strings(a.Tags)
+	return a
+}
+
+// verdictFromStats grades engine detection counts the way the VirusTotal
+// enrichment Logic Apps present them
+func verdictFromStats(malicious, suspicious, harmless int) Verdict {
+	switch {
+	case malicious >= 3:
+		return VerdictMalicious
+	case malicious > 0 || suspicious >= 2:
+		return VerdictSuspicious
+	case harmless > 0:
+		return VerdictHarmless
+	}
+	return VerdictUnknown
+}
@@ -0,0 +1,65 @@
+package enrichment_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/enrichment"
+)
+
+func verdicts(vs ...enrichment.Verdict) []enrichment.Result {
+	results := make([]enrichment.Result, len(vs))
+	for i, v := range vs {
+		results[i] = enrichment.Result{Provider: string(rune('a' + i)), Verdict: v}
+	}
+	return results
+}
+
+func TestAssess(t *testing.T) {
+	const (
+		mal  = enrichment.VerdictMalicious
+		sus  = enrichment.VerdictSuspicious
+		good = enrichment.VerdictHarmless
+		unk  = enrichment.VerdictUnknown
+	)
+	tests := []struct {
+		name       string
+		results    []enrichment.Result
+		verdict    enrichment.Verdict
+		adjustment int
+	}{
+		{"no results", nil, unk, 0},
+		{"only unknown", verdicts(unk, unk), unk, 0},
+		{"one malicious", verdicts(mal), mal, 20},
+		{"two malicious", verdicts(mal, mal), mal, 30},
+		{"three malicious", verdicts(mal, mal, mal), mal, 40},
+		{"malicious capped", verdicts(mal, mal, mal, mal, mal), mal, 40},
+		{"one suspicious", verdicts(sus), sus, 5},
+		{"suspicious capped", verdicts(sus, sus, sus), sus, 10},
+		{"malicious and suspicious", verdicts(mal, sus, sus, sus), mal, 30},
+		{"one harmless", verdicts(good), good, -15},
+		{"harmless capped", verdicts(good, good, good), good, -30},
+		{"harmless ignored when suspicious", verdicts(good, good, sus), sus, 5},
+		{"harmless ignored when malicious", verdicts(good, mal), mal, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := enrichment.Assess(tt.results)
+			if a.Verdict != tt.verdict || a.Adjustment != tt.adjustment {
+				t.Errorf("Assess = %s %+d, want %s %+d", a.Verdict, a.Adjustment, tt.verdict, tt.adjustment)
+			}
+		})
+	}
+}
+
+func TestAssessTags(t *testing.T) {
+	// Only the first five tags of each provider count, blank ones included
+	a := enrichment.Assess([]enrichment.Result{
+		{Provider: "virustotal", Verdict: enrichment.VerdictMalicious, Tags: []string{" Emotet ", "trojan", "", "c2", "loader", "botnet", "dropped"}},
+		{Provider: "msti", Verdict: enrichment.VerdictUnknown, Tags: []string{"emotet"}},
+	})
+	want := []string{"c2", "emotet", "loader", "trojan", "virustotal:malicious"}
+	if !reflect.DeepEqual(a.Tags, want) {
+		t.Errorf("tags = %v, want %v", a.Tags, want)
+	}
+}
@@ -0,0 +1,86 @@
+// Package enrichmenttest provides mock enrichment providers that answer
+// from fixed results, for running enrichment offline
+package enrichmenttest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/enrichment"
+)
+
+// Provider is a mock enrichment provider. Values without a configured
+// result get Default, or an unknown verdict if Default is nil.
+type Provider struct {
+	ProviderName string
+	Types        []indicator.Type
+	Quota        enrichment.Limit
+	Default      *enrichment.Result
+	// Err, when set, is returned for every lookup
+	Err error
+
+	mu      sync.Mutex
+	results map[string]enrichment.Result
+	calls   int
+}
+
+// NewProvider creates a mock provider supporting every indicator type
+func NewProvider(name string) *Provider {
+	return &Provider{ProviderName: name, results: make(map[string]enrichment.Result)}
+}
+
+// Set configures the result for a value
+func (p *Provider) Set(value string, verdict enrichment.Verdict, score int, tags ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.results[value] = enrichment.Result{Verdict: verdict, Score: score, Tags: tags}
+}
+
+// Calls returns how many lookups reached the provider
+func (p *Provider) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+// Name implements enrichment.Provider
+func (p *Provider) Name() string {
+	return p.ProviderName
+}
+
+// Limit implements enrichment.Provider
+func (p *Provider) Limit() enrichment.Limit {
+	return p.Quota
+}
+
+// Supports implements enrichment.Provider
+func (p *Provider) Supports(t indicator.Type) bool {
+	if len(p.Types) == 0 {
+		return true
+	}
+	for _, supported := range p.Types {
+		if supported == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup implements enrichment.Provider
+func (p *Provider) Lookup(ctx context.Context, ind *indicator.Indicator) (*enrichment.Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.Err != nil {
+		return nil, p.Err
+	}
+	if r, ok := p.results[ind.Value]; ok {
+		return &r, nil
+	}
+	if p.Default != nil {
+		r := *p.Default
+		return &r, nil
+	}
+	return &enrichment.Result{Verdict: enrichment.VerdictUnknown}, nil
+}
@@ -0,0 +1,43 @@
+package enrichment
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// getJSON performs a lookup request and decodes the JSON response into
+// out. It reports whether the service knew the indicator.
+func getJSON(client *http.Client, req *http.Request, provider string, out interface{}) (bool, error) {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return false, &RateLimitError{Provider: provider, RetryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+	case resp.StatusCode >= 300:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return false, fmt.Errorf("%s returned %d: %s", provider, resp.StatusCode, body)
+	}
+	return true, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// retryAfter parses a Retry-After header, defaulting to a minute
+func retryAfter(header string) time.Duration {
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil && time.Until(t) > 0 {
+		return time.Until(t)
+	}
+	return time.Minute
+}
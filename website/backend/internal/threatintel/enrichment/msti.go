@@ -0,0 +1,96 @@
+package enrichment
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// MicrosoftTI looks hosts up in Microsoft Defender Threat Intelligence
+// through Microsoft Graph, like the http-ms-ti-enrichment Logic App. The
+// app registration needs the ThreatIntelligence.Read.All permission.
+type MicrosoftTI struct {
+	Tokens     azure.TokenProvider
+	BaseURL    string
+	HTTPClient *http.Client
+	Quota      Limit
+}
+
+// NewMicrosoftTI creates a Microsoft Defender Threat Intelligence provider
+func NewMicrosoftTI(tokens azure.TokenProvider) *MicrosoftTI {
+	return &MicrosoftTI{
+		Tokens:     tokens,
+		BaseURL:    "https://graph.microsoft.com",
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Quota:      Limit{Requests: 60, Per: time.Minute},
+	}
+}
+
+// Name implements Provider
+func (m *MicrosoftTI) Name() string {
+	return "msti"
+}
+
+// Limit implements Provider
+func (m *MicrosoftTI) Limit() Limit {
+	return m.Quota
+}
+
+// Supports implements Provider
+func (m *MicrosoftTI) Supports(t indicator.Type) bool {
+	return t == indicator.TypeIPv4 || t == indicator.TypeIPv6 || t == indicator.TypeDomain
+}
+
+// Lookup implements Provider
+func (m *MicrosoftTI) Lookup(ctx context.Context, ind *indicator.Indicator) (*Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(m.BaseURL, "/")+
+		"/v1.0/security/threatIntelligence/hosts/"+url.PathEscape(ind.Value)+"/reputation", nil)
+	if err != nil {
+		return nil, err
+	}
+	token, err := m.Tokens.Token(ctx, azure.ScopeGraph)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var body struct {
+		Classification string `json:"classification"`
+		Score          int    `json:"score"`
+		Rules          []struct {
+			Name     string `json:"name"`
+			Severity string `json:"severity"`
+		} `json:"rules"`
+	}
+	found, err := getJSON(m.HTTPClient, req, m.Name(), &body)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return &Result{Verdict: VerdictUnknown}, nil
+	}
+
+	r := &Result{Verdict: VerdictUnknown, Score: body.Score}
+	switch strings.ToLower(body.Classification) {
+	case "malicious":
+		r.Verdict = VerdictMalicious
+	case "suspicious":
+		r.Verdict = VerdictSuspicious
+	case "neutral":
+		r.Verdict = VerdictHarmless
+	}
+	var rules []string
+	for _, rule := range body.Rules {
+		rules = append(rules, rule.Name)
+	}
+	r.Details = map[string]interface{}{
+		"classification": body.Classification,
+		"rules":          rules,
+	}
+	return r, nil
+}
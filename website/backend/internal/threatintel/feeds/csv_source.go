@@ -0,0 +1,136 @@
+package feeds
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// CSVSource reads delimited indicator lists, one indicator per row
+type CSVSource struct {
+	HTTPClient *http.Client
+}
+
+// Fetch implements Source
+func (s *CSVSource) Fetch(ctx context.Context, feed *Feed, sink Sink) error {
+	body, size, err := download(ctx, s.HTTPClient, feed, "text/csv")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	reader := csv.NewReader(&progressReader{r: body, sink: sink, total: size})
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if feed.Config.Delimiter != "" {
+		reader.Comma = []rune(feed.Config.Delimiter)[0]
+	}
+
+	columns := map[string]int{"value": 0}
+	if feed.Config.HasHeader {
+		header, err := reader.Read()
+		if err != nil {
+			return fmt.Errorf("failed to read csv header: %v", err)
+		}
+		columns, err = resolveColumns(feed.Config.Fields, header)
+		if err != nil {
+			return err
+		}
+	} else if len(feed.Config.Fields) > 0 {
+		columns, err = resolveColumns(feed.Config.Fields, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read csv row: %v", err)
+		}
+
+		get := func(field string) string {
+			if i, ok := columns[field]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		ind, ok := buildIndicator(feed, get)
+		if !ok {
+			continue
+		}
+		if err := sink.Emit(ind); err != nil {
+			return err
+		}
+	}
+}
+
+// resolveColumns maps indicator fields to column indexes, accepting either
+// header names or zero-based indexes in the feed configuration
+func resolveColumns(fields map[string]string, header []string) (map[string]int, error) {
+	columns := make(map[string]int)
+	for field, column := range fields {
+		if i, err := strconv.Atoi(column); err == nil {
+			columns[field] = i
+			continue
+		}
+		found := false
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), column) {
+				columns[field] = i
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("csv column %q for field %s not found", column, field)
+		}
+	}
+	if _, ok := columns["value"]; !ok {
+		return nil, fmt.Errorf("csv feed has no value column configured")
+	}
+	return columns, nil
+}
+
+// buildIndicator creates an indicator from the mapped fields of one record
+func buildIndicator(feed *Feed, get func(field string) string) (indicator.Indicator, bool) {
+	value := get("value")
+	if value == "" {
+		return indicator.Indicator{}, false
+	}
+
+	// The type is resolved when the indicator is normalized; an empty
+	// type is detected from the value
+	typeName := get("type")
+	if typeName == "" {
+		typeName = feed.Config.DefaultType
+	}
+
+	ind := feed.newIndicator(indicator.Type(typeName), value)
+	if v := get("threatType"); v != "" {
+		ind.ThreatType = v
+	}
+	if v, err := strconv.Atoi(get("confidence")); err == nil && v >= 0 && v <= 100 {
+		ind.Confidence = v
+	}
+	ind.Description = get("description")
+	if v := get("tags"); v != "" {
+		for _, tag := range strings.FieldsFunc(v, func(r rune) bool { return r == ';' || r == '|' || r == ',' }) {
+			ind.Tags = append(ind.Tags, strings.TrimSpace(tag))
+		}
+	}
+	return ind, true
+}
@@ -0,0 +1,72 @@
+package feeds
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serve starts a server answering every request with body
+func serve(t *testing.T, body string, check func(*http.Request)) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if check != nil {
+			check(r)
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCSVSourceFetch(t *testing.T) {
+	server := serve(t, `# exported 2026-03-01
+indicator;kind;score;labels
+45.33.32.156;ipv4;90;c2|botnet
+evil.example.org;;;
+;domain;10;
+`, func(r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			t.Errorf("request carries API key %q", r.Header.Get("X-Api-Key"))
+		}
+	})
+	feed := &Feed{Name: "Abuse", URL: server.URL, Config: Config{
+		Delimiter: ";", HasHeader: true, APIKey: "secret", APIKeyHeader: "X-Api-Key",
+		Fields:      map[string]string{"value": "indicator", "type": "kind", "confidence": "score", "tags": "labels"},
+		DefaultType: "domain", DefaultConfidence: 40,
+	}}
+	var sink recorder
+	if err := (&CSVSource{HTTPClient: server.Client()}).Fetch(context.Background(), feed, &sink); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.indicators) != 2 {
+		t.Fatalf("emitted %+v, want the two rows with values", sink.indicators)
+	}
+	first, second := sink.indicators[0], sink.indicators[1]
+	if first.Value != "45.33.32.156" || first.Type != "ipv4" || first.Confidence != 90 || len(first.Tags) != 2 ||
+		first.Source != "Abuse" || first.ThreatType != "unknown" {
+		t.Errorf("first indicator = %+v", first)
+	}
+	if second.Value != "evil.example.org" || second.Type != "domain" || second.Confidence != 40 {
+		t.Errorf("second indicator = %+v, want the feed defaults", second)
+	}
+}
+
+func TestCSVSourceColumns(t *testing.T) {
+	server := serve(t, "a,45.33.32.156\n", nil)
+	var sink recorder
+	feed := &Feed{URL: server.URL, Config: Config{Fields: map[string]string{"value": "1"}}}
+	if err := (&CSVSource{HTTPClient: server.Client()}).Fetch(context.Background(), feed, &sink); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.indicators) != 1 || sink.indicators[0].Value != "45.33.32.156" {
+		t.Errorf("emitted %+v, want the value of column 1", sink.indicators)
+	}
+
+	feed = &Feed{URL: server.URL, Config: Config{HasHeader: true, Fields: map[string]string{"value": "missing"}}}
+	if err := (&CSVSource{HTTPClient: server.Client()}).Fetch(context.Background(), feed, &sink); err == nil {
+		t.Error("a feed naming a missing column was read")
+	}
+}
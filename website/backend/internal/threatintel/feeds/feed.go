@@ -0,0 +1,154 @@
+package feeds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// Feed types as stored in deployment_mgmt.threat_feeds.feed_type
+const (
+	FeedTypeSTIX  = "STIX"
+	FeedTypeTAXII = "TAXII"
+	FeedTypeCSV   = "CSV"
+	FeedTypeJSON  = "JSON"
+)
+
+// Sync statuses written to deployment_mgmt.threat_feeds.last_sync_status
+const (
+	StatusRunning = "running"
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+)
+
+// Feed represents a row of deployment_mgmt.threat_feeds
+type Feed struct {
+	ID              string
+	Name            string
+	URL             string
+	Type            string
+	Enabled         bool
+	UpdateFrequency time.Duration
+	LastUpdate      *time.Time
+	// Cursor is the TAXII added_after value reached by the last successful sync
+	Cursor time.Time
+	Config Config
+}
+
+// Config is the feed-type specific JSON stored in threat_feeds.configuration
+type Config struct {
+	// TAXII collection to poll; the feed URL is the API root
+	CollectionID string `json:"collectionId,omitempty"`
+	PageSize     int    `json:"pageSize,omitempty"`
+
+	// Credentials sent with every request
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	APIKey       string `json:"apiKey,omitempty"`
+	APIKeyHeader string `json:"apiKeyHeader,omitempty"`
+
+	// CSV and JSON layout. Fields maps indicator attributes (value, type,
+	// threatType, confidence, description, tags) to CSV column names or
+	// indexes, or to dot-separated paths inside each JSON item.
+	Delimiter string            `json:"delimiter,omitempty"`
+	HasHeader bool              `json:"hasHeader,omitempty"`
+	ItemsPath string            `json:"itemsPath,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+
+	// Defaults applied to indicators that do not carry their own values
+	DefaultType       string   `json:"defaultType,omitempty"`
+	DefaultThreatType string   `json:"defaultThreatType,omitempty"`
+	DefaultConfidence int      `json:"defaultConfidence,omitempty"`
+	Tags              []string `json:"tags,omitempty"`
+	// ClientID scopes the feed to a single client; empty means global
+	ClientID string `json:"clientId,omitempty"`
+}
+
+// Sink receives the indicators produced by a Source
+type Sink interface {
+	// Emit queues an indicator for storage
+	Emit(ind indicator.Indicator) error
+	// Progress reports how much of the feed has been read; total is
+	// zero or negative when the size of the feed is unknown
+	Progress(done, total int64)
+	// Cursor records the incremental position reached so far
+	Cursor(t time.Time)
+}
+
+// Source fetches indicators for a feed type
+type Source interface {
+	Fetch(ctx context.Context, feed *Feed, sink Sink) error
+}
+
+// newIndicator applies the feed defaults to an indicator read from the feed
+func (f *Feed) newIndicator(t indicator.Type, value string) indicator.Indicator {
+	threatType := f.Config.DefaultThreatType
+	if threatType == "" {
+		threatType = "unknown"
+	}
+	confidence := f.Config.DefaultConfidence
+	if confidence == 0 {
+		confidence = 50
+	}
+	return indicator.Indicator{
+		ClientID:   f.Config.ClientID,
+		Type:       t,
+		Value:      strings.TrimSpace(value),
+		ThreatType: threatType,
+		Confidence: confidence,
+		Source:     f.Name,
+		Tags:       append([]string(nil), f.Config.Tags...),
+		FeedID:     f.ID,
+		IsActive:   true,
+	}
+}
+
+// download opens the feed URL with the configured credentials
+func download(ctx context.Context, client *http.Client, feed *Feed, accept string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.URL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if feed.Config.APIKey != "" {
+		header := feed.Config.APIKeyHeader
+		if header == "" {
+			header = "Authorization"
+		}
+		req.Header.Set(header, feed.Config.APIKey)
+	} else if feed.Config.Username != "" {
+		req.SetBasicAuth(feed.Config.Username, feed.Config.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to download feed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("feed server returned %d", resp.StatusCode)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// progressReader reports bytes read against the expected content length
+type progressReader struct {
+	r     io.Reader
+	sink  Sink
+	read  int64
+	total int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	p.sink.Progress(p.read, p.total)
+	return n, err
+}
@@ -0,0 +1,99 @@
+package feeds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// JSONSource reads an array of indicator objects from a JSON document
+type JSONSource struct {
+	HTTPClient *http.Client
+}
+
+// Fetch implements Source
+func (s *JSONSource) Fetch(ctx context.Context, feed *Feed, sink Sink) error {
+	body, size, err := download(ctx, s.HTTPClient, feed, "application/json")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(&progressReader{r: body, sink: sink, total: size})
+	if err != nil {
+		return fmt.Errorf("failed to read feed: %v", err)
+	}
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return fmt.Errorf("invalid json feed: %v", err)
+	}
+
+	items, ok := lookupPath(document, feed.Config.ItemsPath).([]interface{})
+	if !ok {
+		return fmt.Errorf("json feed has no array at %q", feed.Config.ItemsPath)
+	}
+
+	fields := feed.Config.Fields
+	if _, ok := fields["value"]; !ok {
+		return fmt.Errorf("json feed has no value field configured")
+	}
+
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		get := func(field string) string {
+			path, ok := fields[field]
+			if !ok {
+				return ""
+			}
+			return stringValue(lookupPath(item, path))
+		}
+		ind, ok := buildIndicator(feed, get)
+		if !ok {
+			continue
+		}
+		if err := sink.Emit(ind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupPath walks a dot-separated path through decoded JSON objects
+func lookupPath(document interface{}, path string) interface{} {
+	if path == "" {
+		return document
+	}
+	current := document
+	for _, key := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[key]
+	}
+	return current
+}
+
+func stringValue(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	case []interface{}:
+		var parts []string
+		for _, element := range value {
+			parts = append(parts, stringValue(element))
+		}
+		return strings.Join(parts, ";")
+	}
+	return ""
+}
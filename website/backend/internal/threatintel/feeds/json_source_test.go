@@ -0,0 +1,52 @@
+package feeds
+
+import (
+	"context"
+	"testing"
+)
+
+func TestJSONSourceFetch(t *testing.T) {
+	server := serve(t, `{"data": {"items": [
+		{"ioc": {"value": "45.33.32.156", "type": "ipv4"}, "confidence": 75, "tags": ["c2", "apt"]},
+		{"ioc": {"value": ""}},
+		{"ioc": {"value": "evil.example.org"}, "confidence": 200}
+	]}}`, nil)
+	feed := &Feed{URL: server.URL, Config: Config{
+		ItemsPath: "data.items",
+		Fields:    map[string]string{"value": "ioc.value", "type": "ioc.type", "confidence": "confidence", "tags": "tags"},
+	}}
+	var sink recorder
+	if err := (&JSONSource{HTTPClient: server.Client()}).Fetch(context.Background(), feed, &sink); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.indicators) != 2 {
+		t.Fatalf("emitted %+v, want the two items with values", sink.indicators)
+	}
+	first, second := sink.indicators[0], sink.indicators[1]
+	if first.Type != "ipv4" || first.Confidence != 75 || len(first.Tags) != 2 {
+		t.Errorf("first indicator = %+v", first)
+	}
+	// Out-of-range confidences fall back to the default
+	if second.Value != "evil.example.org" || second.Confidence != 50 {
+		t.Errorf("second indicator = %+v", second)
+	}
+}
+
+func TestJSONSourceErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name, body string
+		config     Config
+	}{
+		{"invalid json", `{"items": [`, Config{Fields: map[string]string{"value": "v"}}},
+		{"no array", `{"items": {}}`, Config{ItemsPath: "items", Fields: map[string]string{"value": "v"}}},
+		{"no value field", `[]`, Config{}},
+	} {
+		server := serve(t, tt.body, nil)
+		var sink recorder
+		feed := &Feed{URL: server.URL, Config: tt.config}
+		if err := (&JSONSource{HTTPClient: server.Client()}).Fetch(context.Background(), feed, &sink); err == nil {
+			t.Errorf("%s: fetched without an error", tt.name)
+		}
+	}
+}
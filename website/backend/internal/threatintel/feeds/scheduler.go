@@ -0,0 +1,243 @@
+package feeds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+const (
+	defaultBatchSize     = 500
+	defaultCheckInterval = time.Minute
+	// staleSyncTimeout lets a new sync take over one left "running" by a crashed process
+	staleSyncTimeout = 2 * time.Hour
+	// progressInterval throttles sync_progress writes during large syncs
+	progressInterval = 2 * time.Second
+)
+
+// Result summarises a single feed sync
+type Result struct {
+	FeedID     string `json:"feedId"`
+	Received   int    `json:"received"`
+	Stored     int    `json:"stored"`
+	Duplicates int    `json:"duplicates"`
+	Rejected   int    `json:"rejected"`
+	// Quarantined counts stored indicators held back by the allow-list
+	Quarantined int `json:"quarantined"`
+}
+
+// Scheduler periodically syncs due feeds through the registered sources
+type Scheduler struct {
+	store         *Store
+	normalizer    *indicator.Normalizer
+	sources       map[string]Source
+	batchSize     int
+	checkInterval time.Duration
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// NewScheduler creates a scheduler with the STIX, TAXII, CSV and JSON sources registered
+func NewScheduler(store *Store) *Scheduler {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	s := &Scheduler{
+		store:         store,
+		normalizer:    indicator.NewNormalizer(),
+		sources:       make(map[string]Source),
+		batchSize:     defaultBatchSize,
+		checkInterval: defaultCheckInterval,
+		running:       make(map[string]bool),
+	}
+	s.RegisterSource(FeedTypeSTIX, &STIXSource{HTTPClient: client})
+	s.RegisterSource(FeedTypeTAXII, &TAXIISource{HTTPClient: client})
+	s.RegisterSource(FeedTypeCSV, &CSVSource{HTTPClient: client})
+	s.RegisterSource(FeedTypeJSON, &JSONSource{HTTPClient: client})
+	return s
+}
+
+// RegisterSource sets the source used for a feed type, replacing any existing one
+func (s *Scheduler) RegisterSource(feedType string, source Source) {
+	s.sources[feedType] = source
+}
+
+// Run syncs due feeds until the context is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		s.SyncDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncDue syncs every feed whose update interval has elapsed
+func (s *Scheduler) SyncDue(ctx context.Context) {
+	due, err := s.store.ListDue(ctx, staleSyncTimeout)
+	if err != nil {
+		log.Printf("Error listing due threat feeds: %v", err)
+		return
+	}
+	for _, feed := range due {
+		if _, err := s.sync(ctx, feed); err != nil {
+			log.Printf("Threat feed %s sync failed: %v", feed.Name, err)
+		}
+	}
+}
+
+// SyncFeed syncs a single feed immediately, regardless of its schedule
+func (s *Scheduler) SyncFeed(ctx context.Context, feedID string) (*Result, error) {
+	feed, err := s.store.Get(ctx, feedID)
+	if err != nil {
+		return nil, err
+	}
+	return s.sync(ctx, feed)
+}
+
+func (s *Scheduler) sync(ctx context.Context, feed *Feed) (*Result, error) {
+	source, ok := s.sources[feed.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported feed type %q", feed.Type)
+	}
+
+	s.mu.Lock()
+	if s.running[feed.ID] {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("feed %s is already syncing", feed.Name)
+	}
+	s.running[feed.ID] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, feed.ID)
+		s.mu.Unlock()
+	}()
+
+	started, err := s.store.BeginSync(ctx, feed.ID, staleSyncTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if !started {
+		return nil, fmt.Errorf("feed %s is already syncing", feed.Name)
+	}
+
+	run := &syncRun{
+		ctx:        ctx,
+		store:      s.store,
+		normalizer: s.normalizer,
+		feed:       feed,
+		batchSize:  s.batchSize,
+		seen:       make(map[string]bool),
+		result:     &Result{FeedID: feed.ID},
+	}
+	syncErr := source.Fetch(ctx, feed, run)
+	if syncErr == nil {
+		syncErr = run.flush()
+	}
+
+	// Record the outcome even if the sync context was cancelled
+	finishCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.store.FinishSync(finishCtx, feed.ID, syncErr, run.cursor); err != nil {
+		log.Printf("Error recording sync status for feed %s: %v", feed.Name, err)
+	}
+	if syncErr != nil {
+		return run.result, syncErr
+	}
+
+	log.Printf("Threat feed %s synced: %d received, %d stored, %d duplicates, %d rejected, %d quarantined",
+		feed.Name, run.result.Received, run.result.Stored, run.result.Duplicates, run.result.Rejected,
+		run.result.Quarantined)
+	return run.result, nil
+}
+
+// syncRun is the Sink for a single feed sync. It normalizes and
+// deduplicates indicators within the run and writes them in batches.
+type syncRun struct {
+	ctx        context.Context
+	store      *Store
+	normalizer *indicator.Normalizer
+	feed       *Feed
+	batchSize  int
+	batch      []indicator.Indicator
+	seen       map[string]bool
+	cursor     time.Time
+	result     *Result
+
+	lastProgress     int
+	lastProgressTime time.Time
+}
+
+func (r *syncRun) Emit(ind indicator.Indicator) error {
+	r.result.Received++
+	t, value, err := r.normalizer.Normalize(string(ind.Type), ind.Value)
+	if err != nil {
+		r.result.Rejected++
+		return nil
+	}
+	ind.Type, ind.Value = t, value
+
+	key := ind.Key()
+	if r.seen[key] {
+		r.result.Duplicates++
+		return nil
+	}
+	r.seen[key] = true
+
+	r.batch = append(r.batch, ind)
+	if len(r.batch) >= r.batchSize {
+		return r.flush()
+	}
+	return nil
+}
+
+func (r *syncRun) flush() error {
+	if len(r.batch) == 0 {
+		return nil
+	}
+	stored, err := r.store.UpsertIndicators(r.ctx, r.batch)
+	if err != nil {
+		return err
+	}
+	r.result.Stored += len(r.batch)
+	r.result.Quarantined += stored.Quarantined
+	r.batch = r.batch[:0]
+	return nil
+}
+
+func (r *syncRun) Progress(done, total int64) {
+	var progress int
+	if total > 0 {
+		progress = int(done * 100 / total)
+	} else {
+		// Unknown size: approach but never reach 100 until the sync finishes
+		progress = 100 - int(100/(done+1))
+	}
+	if progress >= 100 {
+		progress = 99
+	}
+	if progress <= r.lastProgress || time.Since(r.lastProgressTime) < progressInterval {
+		return
+	}
+	r.lastProgress = progress
+	r.lastProgressTime = time.Now()
+	if err := r.store.UpdateProgress(r.ctx, r.feed.ID, progress); err != nil {
+		log.Printf("Error updating progress for feed %s: %v", r.feed.Name, err)
+	}
+}
+
+func (r *syncRun) Cursor(t time.Time) {
+	if t.After(r.cursor) {
+		r.cursor = t
+	}
+}
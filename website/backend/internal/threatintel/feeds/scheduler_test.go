@@ -0,0 +1,167 @@
+package feeds
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// staticSource emits fixed values, then reaches a cursor and fails with err
+type staticSource struct {
+	values []string
+	cursor time.Time
+	err    error
+}
+
+func (s *staticSource) Fetch(ctx context.Context, feed *Feed, sink Sink) error {
+	for i, value := range s.values {
+		if err := sink.Emit(feed.newIndicator("", value)); err != nil {
+			return err
+		}
+		sink.Progress(int64(i+1), int64(len(s.values)))
+	}
+	if !s.cursor.IsZero() {
+		sink.Cursor(s.cursor)
+	}
+	return s.err
+}
+
+func newScheduler(store *Store, source Source) *Scheduler {
+	s := NewScheduler(store)
+	s.batchSize = 2
+	s.RegisterSource(FeedTypeCSV, source)
+	return s
+}
+
+func TestSchedulerSyncFeed(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	id := addFeed(t, db, "Abuse", "https://feeds.example.org/abuse.csv", FeedTypeCSV, true)
+	cursor := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	source := &staticSource{
+		values: []string{"45.33.32.156", "evil.example.org", "45.33.32.156", "not an indicator", "hxxp://evil[.]example[.]net/a"},
+		cursor: cursor,
+	}
+	s := newScheduler(NewStore(db), source)
+
+	result, err := s.SyncFeed(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Result{FeedID: id, Received: 5, Stored: 3, Duplicates: 1, Rejected: 1}
+	if *result != want {
+		t.Errorf("result = %+v, want %+v", *result, want)
+	}
+
+	var count int
+	var status string
+	var saved time.Time
+	if err := db.QueryRow(`
+		SELECT indicator_count, last_sync_status, sync_cursor
+		FROM deployment_mgmt.threat_feeds WHERE id = $1`, id).Scan(&count, &status, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || status != StatusSuccess || !saved.Equal(cursor) {
+		t.Errorf("feed has %d indicators, status %s and cursor %v, want 3, success and %v", count, status, saved, cursor)
+	}
+
+	var threatType, tags string
+	if err := db.QueryRow(`
+		SELECT threat_type, array_to_string(tags, ',') FROM deployment_mgmt.threat_indicators
+		WHERE feed_id = $1 AND indicator_value = 'http://evil.example.net/a'`, id).Scan(&threatType, &tags); err != nil {
+		t.Fatalf("the refanged URL was not stored: %v", err)
+	}
+	if threatType != "C2" || tags != "feed" {
+		t.Errorf("stored indicator has threat type %q and tags %q, want the feed defaults", threatType, tags)
+	}
+}
+
+func TestSchedulerRecordsFailedSyncs(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	id := addFeed(t, db, "Abuse", "https://feeds.example.org/abuse.csv", FeedTypeCSV, true)
+	source := &staticSource{
+		values: []string{"45.33.32.156"},
+		cursor: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		err:    errors.New("feed server returned 502"),
+	}
+	s := newScheduler(NewStore(db), source)
+
+	if _, err := s.SyncFeed(ctx, id); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Fatalf("SyncFeed() = %v, want the source error", err)
+	}
+	var status, syncErr string
+	var cursorSet bool
+	if err := db.QueryRow(`
+		SELECT last_sync_status, last_sync_error, sync_cursor IS NOT NULL
+		FROM deployment_mgmt.threat_feeds WHERE id = $1`, id).Scan(&status, &syncErr, &cursorSet); err != nil {
+		t.Fatal(err)
+	}
+	if status != StatusFailed || !strings.Contains(syncErr, "502") || cursorSet {
+		t.Errorf("feed status %s, error %q, cursor set %v, want failed with the error and no cursor", status, syncErr, cursorSet)
+	}
+}
+
+func TestSchedulerRefusesRunningFeeds(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	store := NewStore(db)
+	id := addFeed(t, db, "Abuse", "https://feeds.example.org/abuse.csv", FeedTypeCSV, true)
+	s := newScheduler(store, &staticSource{values: []string{"45.33.32.156"}})
+
+	// Another replica is syncing the feed
+	if _, err := store.BeginSync(ctx, id, staleSyncTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SyncFeed(ctx, id); err == nil || !strings.Contains(err.Error(), "already syncing") {
+		t.Errorf("SyncFeed() of a running feed = %v, want refused", err)
+	}
+}
+
+func TestSchedulerSyncDue(t *testing.T) {
+	db := dbtest.Open(t)
+	store := NewStore(db)
+	due := addFeed(t, db, "Due", "https://feeds.example.org/due.csv", FeedTypeCSV, true)
+	other := addFeed(t, db, "Other type", "https://feeds.example.org/other.json", FeedTypeJSON, true)
+	exec(t, db, `UPDATE deployment_mgmt.threat_feeds SET last_update = NOW() WHERE id = $1`, other)
+	s := newScheduler(store, &staticSource{values: []string{"45.33.32.156"}})
+
+	s.SyncDue(context.Background())
+	var n int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM deployment_mgmt.threat_indicators WHERE feed_id = $1`, due).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("the due feed stored %d indicators, want 1", n)
+	}
+	var status sql.NullString
+	if err := db.QueryRow(`
+		SELECT last_sync_status FROM deployment_mgmt.threat_feeds WHERE id = $1`, other).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Valid {
+		t.Errorf("the feed not due was synced, ending %s", status.String)
+	}
+}
+
+// recorder is a Sink keeping what a source emitted
+type recorder struct {
+	indicators []indicator.Indicator
+	cursor     time.Time
+}
+
+func (r *recorder) Emit(ind indicator.Indicator) error {
+	r.indicators = append(r.indicators, ind)
+	return nil
+}
+
+func (r *recorder) Progress(done, total int64) {}
+
+func (r *recorder) Cursor(t time.Time) { r.cursor = t }
@@ -0,0 +1,158 @@
+package feeds
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// Store persists feeds and their indicators
+type Store struct {
+	db         *sql.DB
+	indicators *indicator.Store
+}
+
+// NewStore creates a feed store
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db, indicators: indicator.NewStore(db)}
+}
+
+const feedColumns = `
+	id, name, COALESCE(feed_url, ''), feed_type, enabled,
+	COALESCE(update_frequency_hours, 24), last_update, sync_cursor, configuration`
+
+// Get loads a single feed
+func (s *Store) Get(ctx context.Context, id string) (*Feed, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+feedColumns+`
+		FROM deployment_mgmt.threat_feeds WHERE id = $1`, id)
+	return scanFeed(row)
+}
+
+// ListDue returns the enabled feeds whose update interval has elapsed.
+// Feeds still running are skipped unless their sync started longer than
+// stale ago, so BeginSync can take over syncs a crashed process left.
+func (s *Store) ListDue(ctx context.Context, stale time.Duration) ([]*Feed, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+feedColumns+`
+		FROM deployment_mgmt.threat_feeds
+		WHERE enabled = true
+		AND feed_url IS NOT NULL
+		AND (COALESCE(last_sync_status, '') <> 'running'
+			OR last_sync_started_at IS NULL
+			OR last_sync_started_at < NOW() - make_interval(secs => $1))
+		AND (last_update IS NULL
+			OR last_update + make_interval(hours => COALESCE(update_frequency_hours, 24)) <= NOW())
+		ORDER BY last_update NULLS FIRST`, stale.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feeds []*Feed
+	for rows.Next() {
+		feed, err := scanFeed(rows)
+		if err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+	}
+	return feeds, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFeed(row scanner) (*Feed, error) {
+	var feed Feed
+	var hours int
+	var lastUpdate, cursor sql.NullTime
+	var config []byte
+
+	if err := row.Scan(&feed.ID, &feed.Name, &feed.URL, &feed.Type, &feed.Enabled,
+		&hours, &lastUpdate, &cursor, &config); err != nil {
+		return nil, err
+	}
+	feed.Type = strings.ToUpper(feed.Type)
+	feed.UpdateFrequency = time.Duration(hours) * time.Hour
+	if lastUpdate.Valid {
+		feed.LastUpdate = &lastUpdate.Time
+	}
+	if cursor.Valid {
+		feed.Cursor = cursor.Time
+	}
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &feed.Config); err != nil {
+			return nil, fmt.Errorf("invalid configuration for feed %s: %v", feed.Name, err)
+		}
+	}
+	return &feed, nil
+}
+
+// BeginSync marks a feed as running. It returns false when another sync of
+// the same feed is already in progress; syncs stuck for longer than the
+// stale timeout are taken over.
+func (s *Store) BeginSync(ctx context.Context, id string, stale time.Duration) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.threat_feeds
+		SET last_sync_status = 'running', sync_progress = 0,
+			last_sync_error = NULL, last_sync_started_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+		AND (COALESCE(last_sync_status, '') <> 'running'
+			OR last_sync_started_at IS NULL
+			OR last_sync_started_at < NOW() - make_interval(secs => $2))
+	`, id, stale.Seconds())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// UpdateProgress records the sync percentage of a running feed
+func (s *Store) UpdateProgress(ctx context.Context, id string, progress int) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.threat_feeds SET sync_progress = $2 WHERE id = $1
+	`, id, progress)
+	return err
+}
+
+// FinishSync records the outcome of a sync. The cursor is only advanced
+// on success so a failed incremental sync is retried from the same point.
+func (s *Store) FinishSync(ctx context.Context, id string, syncErr error, cursor time.Time) error {
+	if syncErr != nil {
+		_, err := s.db.ExecContext(ctx, `
+			UPDATE deployment_mgmt.threat_feeds
+			SET last_sync_status = 'failed', last_sync_error = $2,
+				last_update = NOW(), updated_at = NOW()
+			WHERE id = $1
+		`, id, syncErr.Error())
+		return err
+	}
+
+	var nullCursor sql.NullTime
+	if !cursor.IsZero() {
+		nullCursor = sql.NullTime{Time: cursor, Valid: true}
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.threat_feeds
+		SET last_sync_status = 'success', last_sync_error = NULL, sync_progress = 100,
+			sync_cursor = COALESCE($2, sync_cursor),
+			indicator_count = (
+				SELECT COUNT(*) FROM deployment_mgmt.threat_indicators
+				WHERE feed_id = $1 AND is_active = true
+			),
+			last_update = NOW(), updated_at = NOW()
+		WHERE id = $1
+	`, id, nullCursor)
+	return err
+}
+
+// UpsertIndicators stores a batch of indicators read from a feed
+func (s *Store) UpsertIndicators(ctx context.Context, indicators []indicator.Indicator) (indicator.UpsertResult, error) {
+	return s.indicators.Upsert(ctx, indicators)
+}
@@ -0,0 +1,140 @@
+package feeds
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+)
+
+// addFeed stores a feed and returns its ID
+func addFeed(t *testing.T, db *sql.DB, name, url, feedType string, enabled bool) string {
+	t.Helper()
+	var id string
+	if err := db.QueryRow(`
+		INSERT INTO deployment_mgmt.threat_feeds (name, feed_url, feed_type, enabled, configuration)
+		VALUES ($1, NULLIF($2, ''), $3, $4, '{"defaultThreatType": "C2", "tags": ["feed"]}')
+		RETURNING id`, name, url, feedType, enabled).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func exec(t *testing.T, db *sql.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreGet(t *testing.T) {
+	db := dbtest.Open(t)
+	s := NewStore(db)
+	id := addFeed(t, db, "Abuse", "https://feeds.example.org/abuse.csv", "csv", true)
+
+	feed, err := s.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Type != FeedTypeCSV || feed.UpdateFrequency != 24*time.Hour || feed.LastUpdate != nil ||
+		feed.Config.DefaultThreatType != "C2" || len(feed.Config.Tags) != 1 {
+		t.Errorf("feed = %+v", feed)
+	}
+}
+
+func TestStoreListDue(t *testing.T) {
+	db := dbtest.Open(t)
+	s := NewStore(db)
+	url := "https://feeds.example.org/list.csv"
+	never := addFeed(t, db, "Never synced", url, FeedTypeCSV, true)
+	recent := addFeed(t, db, "Recently synced", url, FeedTypeCSV, true)
+	overdue := addFeed(t, db, "Overdue", url, FeedTypeCSV, true)
+	addFeed(t, db, "Disabled", url, FeedTypeCSV, false)
+	addFeed(t, db, "Without URL", "", FeedTypeCSV, true)
+	running := addFeed(t, db, "Running", url, FeedTypeCSV, true)
+	stale := addFeed(t, db, "Stale", url, FeedTypeCSV, true)
+
+	exec(t, db, `UPDATE deployment_mgmt.threat_feeds SET last_update = NOW() WHERE id = $1`, recent)
+	exec(t, db, `UPDATE deployment_mgmt.threat_feeds SET last_update = NOW() - INTERVAL '25 hours' WHERE id = $1`, overdue)
+	exec(t, db, `UPDATE deployment_mgmt.threat_feeds
+		SET last_sync_status = 'running', last_sync_started_at = NOW() WHERE id = $1`, running)
+	exec(t, db, `UPDATE deployment_mgmt.threat_feeds
+		SET last_sync_status = 'running', last_sync_started_at = NOW() - INTERVAL '3 hours' WHERE id = $1`, stale)
+
+	due, err := s.ListDue(context.Background(), staleSyncTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for _, feed := range due {
+		got[feed.ID] = true
+	}
+	if len(due) != 3 || !got[never] || !got[overdue] || !got[stale] {
+		names := make([]string, len(due))
+		for i, feed := range due {
+			names[i] = feed.Name
+		}
+		t.Errorf("due feeds = %v, want Never synced, Overdue and Stale", names)
+	}
+}
+
+func TestStoreBeginSync(t *testing.T) {
+	db := dbtest.Open(t)
+	s := NewStore(db)
+	ctx := context.Background()
+	id := addFeed(t, db, "Abuse", "https://feeds.example.org/abuse.csv", FeedTypeCSV, true)
+
+	if started, err := s.BeginSync(ctx, id, time.Hour); err != nil || !started {
+		t.Fatalf("BeginSync() = %v, %v, want started", started, err)
+	}
+	if started, err := s.BeginSync(ctx, id, time.Hour); err != nil || started {
+		t.Fatalf("BeginSync() of a running feed = %v, %v, want refused", started, err)
+	}
+	// A sync running for longer than the timeout was left by a crash
+	exec(t, db, `UPDATE deployment_mgmt.threat_feeds
+		SET last_sync_started_at = NOW() - INTERVAL '2 hours' WHERE id = $1`, id)
+	if started, err := s.BeginSync(ctx, id, time.Hour); err != nil || !started {
+		t.Fatalf("BeginSync() of a stale feed = %v, %v, want taken over", started, err)
+	}
+}
+
+func TestStoreFinishSync(t *testing.T) {
+	db := dbtest.Open(t)
+	s := NewStore(db)
+	ctx := context.Background()
+	id := addFeed(t, db, "Abuse", "https://feeds.example.org/abuse.csv", FeedTypeCSV, true)
+	cursor := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, err := s.BeginSync(ctx, id, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FinishSync(ctx, id, nil, cursor); err != nil {
+		t.Fatal(err)
+	}
+	var status, syncErr string
+	var progress int
+	var saved time.Time
+	read := func() {
+		t.Helper()
+		if err := db.QueryRow(`
+			SELECT last_sync_status, COALESCE(last_sync_error, ''), sync_progress, sync_cursor
+			FROM deployment_mgmt.threat_feeds WHERE id = $1`, id).Scan(&status, &syncErr, &progress, &saved); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read()
+	if status != StatusSuccess || syncErr != "" || progress != 100 || !saved.Equal(cursor) {
+		t.Errorf("after success: status %s, error %q, progress %d, cursor %v", status, syncErr, progress, saved)
+	}
+
+	// A failed sync keeps the cursor, so the next one retries from it
+	if err := s.FinishSync(ctx, id, context.DeadlineExceeded, cursor.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	read()
+	if status != StatusFailed || syncErr != context.DeadlineExceeded.Error() || !saved.Equal(cursor) {
+		t.Errorf("after failure: status %s, error %q, cursor %v", status, syncErr, saved)
+	}
+}
@@ -0,0 +1,135 @@
+package sightings
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/azure"
+)
+
+// Row is one result row, keyed by column name
+type Row map[string]interface{}
+
+// QueryBackend runs KQL against a client's telemetry
+type QueryBackend interface {
+	Query(ctx context.Context, clientID, query string, timespan time.Duration) ([]Row, error)
+}
+
+// DefaultLogAnalyticsURL is the Log Analytics query API
+const DefaultLogAnalyticsURL = "https://api.loganalytics.io"
+
+// TokenSource returns a token provider for a client tenant
+type TokenSource interface {
+	TokenProvider(ctx context.Context, clientID string) (azure.TokenProvider, error)
+}
+
+// LogAnalyticsBackend queries the Log Analytics workspace of a client's
+// Sentinel deployment
+type LogAnalyticsBackend struct {
+	HTTPClient *http.Client
+	BaseURL    string
+
+	db     *sql.DB
+	tokens TokenSource
+}
+
+// NewLogAnalyticsBackend creates a backend that signs in to client tenants
+// with tokens
+func NewLogAnalyticsBackend(db *sql.DB, tokens TokenSource) *LogAnalyticsBackend {
+	return &LogAnalyticsBackend{
+		HTTPClient: &http.Client{Timeout: 3 * time.Minute},
+		BaseURL:    DefaultLogAnalyticsURL,
+		db:         db,
+		tokens:     tokens,
+	}
+}
+
+// Query implements QueryBackend
+func (b *LogAnalyticsBackend) Query(ctx context.Context, clientID, query string, timespan time.Duration) ([]Row, error) {
+	var workspace string
+	err := b.db.QueryRowContext(ctx, `
+		SELECT workspace_id FROM deployment_mgmt.sentinel_deployments
+		WHERE client_id = $1 AND deployment_status = 'completed' AND workspace_id IS NOT NULL
+		ORDER BY deployed_at DESC NULLS LAST
+		LIMIT 1`, clientID).Scan(&workspace)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("client %s has no Sentinel workspace", clientID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := b.tokens.TokenProvider(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	token, err := tokens.Token(ctx, azure.ScopeLogAnalytics)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"query":    query,
+		"timespan": fmt.Sprintf("PT%dS", int(timespan.Seconds())),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimRight(b.BaseURL, "/")+"/v1/workspaces/"+url.PathEscape(workspace)+"/query", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("log analytics query failed with %d: %s", resp.StatusCode, msg)
+	}
+	return decodeTables(resp.Body)
+}
+
+// decodeTables converts the primary table of a Log Analytics response to rows
+func decodeTables(r io.Reader) ([]Row, error) {
+	var result struct {
+		Tables []struct {
+			Columns []struct {
+				Name string `json:"name"`
+			} `json:"columns"`
+			Rows [][]interface{} `json:"rows"`
+		} `json:"tables"`
+	}
+	if err := json.NewDecoder(r).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Tables) == 0 {
+		return nil, nil
+	}
+
+	table := result.Tables[0]
+	rows := make([]Row, 0, len(table.Rows))
+	for _, values := range table.Rows {
+		row := make(Row, len(table.Columns))
+		for i, col := range table.Columns {
+			if i < len(values) {
+				row[col.Name] = values[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
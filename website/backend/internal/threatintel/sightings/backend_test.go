@@ -0,0 +1,67 @@
+package sightings
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeTables(t *testing.T) {
+	rows, err := decodeTables(strings.NewReader(`{"tables": [
+		{"name": "PrimaryResult",
+		 "columns": [{"name": "IOC", "type": "string"}, {"name": "Count", "type": "long"},
+		             {"name": "FirstSeen", "type": "datetime"}, {"name": "Entities", "type": "dynamic"}],
+		 "rows": [["45.33.32.156", 3, "2024-03-01T10:00:00Z", "[\"laptop-1\",\"laptop-2\"]"],
+		          ["45.33.32.157", 1]]},
+		{"name": "Ignored", "columns": [{"name": "x"}], "rows": [[1]]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Row{
+		{"IOC": "45.33.32.156", "Count": float64(3), "FirstSeen": "2024-03-01T10:00:00Z",
+			"Entities": `["laptop-1","laptop-2"]`},
+		{"IOC": "45.33.32.157", "Count": float64(1)},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("decodeTables() = %v, want %v", rows, want)
+	}
+
+	if rows, err := decodeTables(strings.NewReader(`{"tables": []}`)); err != nil || rows != nil {
+		t.Errorf("decodeTables(no tables) = %v, %v; want nothing", rows, err)
+	}
+	if _, err := decodeTables(strings.NewReader(`<html>`)); err == nil {
+		t.Error("decodeTables(not JSON) succeeded")
+	}
+}
+
+func TestParseHit(t *testing.T) {
+	first := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	h, err := parseHit(Row{"IOC": "Evil.ORG", "Count": float64(3), "FirstSeen": "2024-03-01T10:00:00Z",
+		"LastSeen": first.Add(time.Hour), "Entities": `["laptop-1","laptop-2"]`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &hit{ioc: "evil.org", count: 3, firstSeen: first, lastSeen: first.Add(time.Hour),
+		entities: []string{"laptop-1", "laptop-2"}}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("parseHit() = %+v, want %+v", h, want)
+	}
+
+	// Entities that are not a JSON array are kept as one entity
+	h, err = parseHit(Row{"IOC": "evil.org", "FirstSeen": first, "LastSeen": first, "Entities": "laptop-1"})
+	if err != nil || !reflect.DeepEqual(h.entities, []string{"laptop-1"}) {
+		t.Errorf("parseHit(plain entity) = %+v, %v; want the entity kept", h, err)
+	}
+
+	for _, row := range []Row{
+		{"Count": float64(1), "FirstSeen": first, "LastSeen": first},
+		{"IOC": "evil.org", "FirstSeen": "yesterday", "LastSeen": first},
+		{"IOC": "evil.org", "FirstSeen": first},
+	} {
+		if _, err := parseHit(row); err == nil {
+			t.Errorf("parseHit(%v) succeeded", row)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package sightings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+const (
+	// DefaultLookback is how far back hunts search by default
+	DefaultLookback = 7 * 24 * time.Hour
+	// MaxLookback is the longest hunt accepted
+	MaxLookback = 90 * 24 * time.Hour
+)
+
+// HuntRequest selects the indicators and clients to hunt in. Empty
+// selections mean every active indicator and every client with telemetry.
+type HuntRequest struct {
+	ClientID     string        `json:"clientId,omitempty"`
+	IndicatorIDs []string      `json:"indicatorIds,omitempty"`
+	Lookback     time.Duration `json:"-"`
+}
+
+// HuntResult summarises a hunt
+type HuntResult struct {
+	Clients   int         `json:"clients"`
+	Queries   int         `json:"queries"`
+	Sightings []*Sighting `json:"sightings"`
+	Errors    []string    `json:"errors,omitempty"`
+}
+
+// Hunter runs indicator hunts across client telemetry
+type Hunter struct {
+	backend    QueryBackend
+	store      *Store
+	indicators *indicator.Store
+	tickets    Ticketer
+	Probes     []Probe
+}
+
+// NewHunter creates a hunter using the default probes
+func NewHunter(backend QueryBackend, store *Store, indicators *indicator.Store, tickets Ticketer) *Hunter {
+	return &Hunter{backend: backend, store: store, indicators: indicators, tickets: tickets, Probes: DefaultProbes}
+}
+
+// Store returns the hunter's sighting store
+func (h *Hunter) Store() *Store {
+	return h.store
+}
+
+// RaiseTicket raises a ticket for a sighting. A sighting already linked to
+// a ticket is returned unchanged.
+func (h *Hunter) RaiseTicket(ctx context.Context, id string) (*Sighting, error) {
+	s, err := h.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if s.TicketID != nil {
+		return s, nil
+	}
+	ind, err := h.indicators.Get(ctx, s.IndicatorID)
+	if err != nil {
+		return nil, err
+	}
+	ticketID, err := h.tickets.RaiseTicket(ctx, s, ind)
+	if err != nil {
+		return nil, fmt.Errorf("failed to raise ticket: %v", err)
+	}
+	if err := h.store.SetTicket(ctx, s.ID, ticketID); err != nil {
+		return nil, err
+	}
+	s.TicketID = &ticketID
+	return s, nil
+}
+
+// Hunt searches the requested clients for the requested indicators and
+// records every hit. Query failures are collected and do not stop the hunt.
+func (h *Hunter) Hunt(ctx context.Context, req HuntRequest) (*HuntResult, error) {
+	lookback := req.Lookback
+	if lookback <= 0 {
+		lookback = DefaultLookback
+	}
+	if lookback > MaxLookback {
+		lookback = MaxLookback
+	}
+
+	clients := []string{req.ClientID}
+	if req.ClientID == "" {
+		var err error
+		if clients, err = h.store.HuntableClients(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	filter := indicator.Filter{ClientID: req.ClientID, IDs: req.IndicatorIDs}
+	var indicators []*indicator.Indicator
+	err := h.indicators.ForEach(ctx, filter, func(ind *indicator.Indicator) error {
+		indicators = append(indicators, ind)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &HuntResult{Clients: len(clients), Sightings: []*Sighting{}}
+	for _, clientID := range clients {
+		if err := h.huntClient(ctx, clientID, indicators, lookback, result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (h *Hunter) huntClient(ctx context.Context, clientID string, indicators []*indicator.Indicator,
+	lookback time.Duration, result *HuntResult) error {
+
+	// Global indicators apply to every client, client indicators to their own
+	byValue := make(map[indicator.Type]map[string][]*indicator.Indicator)
+	for _, ind := range indicators {
+		if ind.ClientID != "" && ind.ClientID != clientID {
+			continue
+		}
+		if byValue[ind.Type] == nil {
+			byValue[ind.Type] = make(map[string][]*indicator.Indicator)
+		}
+		value := strings.ToLower(ind.Value)
+		byValue[ind.Type][value] = append(byValue[ind.Type][value], ind)
+	}
+
+	for _, probe := range h.Probes {
+		matches := byValue[probe.Type]
+		if len(matches) == 0 {
+			continue
+		}
+		values := make([]string, 0, len(matches))
+		for v := range matches {
+			values = append(values, v)
+		}
+
+		for _, batch := range chunk(values) {
+			result.Queries++
+			rows, err := h.backend.Query(ctx, clientID, BuildQuery(probe, batch, lookback), lookback)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				result.Errors = append(result.Errors, fmt.Sprintf("%s %s for client %s: %v",
+					probe.Table, probe.Type, clientID, err))
+				continue
+			}
+			for _, row := range rows {
+				hit, err := parseHit(row)
+				if err != nil {
+					result.Errors = append(result.Errors, fmt.Sprintf("%s for client %s: %v", probe.Table, clientID, err))
+					continue
+				}
+				for _, ind := range matches[hit.ioc] {
+					s := &Sighting{
+						IndicatorID:    ind.ID,
+						IndicatorType:  string(ind.Type),
+						IndicatorValue: ind.Value,
+						ClientID:       clientID,
+						Source:         probe.Table,
+						Count:          hit.count,
+						FirstSeen:      hit.firstSeen,
+						LastSeen:       hit.lastSeen,
+						Entities:       hit.entities,
+					}
+					if err := h.store.Record(ctx, s); err != nil {
+						return err
+					}
+					result.Sightings = append(result.Sightings, s)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type hit struct {
+	ioc                 string
+	count               int
+	firstSeen, lastSeen time.Time
+	entities            []string
+}
+
+// parseHit reads a row produced by BuildQuery
+func parseHit(row Row) (*hit, error) {
+	var h hit
+	var ok bool
+	if h.ioc, ok = row["IOC"].(string); !ok {
+		return nil, fmt.Errorf("result row has no IOC")
+	}
+	h.ioc = strings.ToLower(h.ioc)
+
+	switch n := row["Count"].(type) {
+	case float64:
+		h.count = int(n)
+	case json.Number:
+		c, _ := n.Int64()
+		h.count = int(c)
+	case int:
+		h.count = n
+	}
+
+	var err error
+	if h.firstSeen, err = parseTime(row["FirstSeen"]); err != nil {
+		return nil, err
+	}
+	if h.lastSeen, err = parseTime(row["LastSeen"]); err != nil {
+		return nil, err
+	}
+
+	// Dynamic columns arrive as JSON text from Log Analytics
+	switch e := row["Entities"].(type) {
+	case string:
+		if err := json.Unmarshal([]byte(e), &h.entities); err != nil {
+			h.entities = []string{e}
+		}
+	case []interface{}:
+		for _, v := range e {
+			if s, ok := v.(string); ok {
+				h.entities = append(h.entities, s)
+			}
+		}
+	case []string:
+		h.entities = e
+	}
+	if h.entities == nil {
+		h.entities = []string{}
+	}
+	return &h, nil
+}
+
+func parseTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		return time.Parse(time.RFC3339Nano, t)
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %v", v)
+}
@@ -0,0 +1,257 @@
+package sightings_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/sightings"
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/sightings/sightingstest"
+)
+
+const sha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// addIndicators stores indicators and returns their IDs keyed by value
+func addIndicators(t *testing.T, db *sql.DB, indicators ...indicator.Indicator) map[string]string {
+	t.Helper()
+	ctx := context.Background()
+	store := indicator.NewStore(db)
+	if _, err := store.Upsert(ctx, indicators); err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]string)
+	err := store.ForEach(ctx, indicator.Filter{IncludeInactive: true}, func(ind *indicator.Indicator) error {
+		ids[ind.Value] = ind.ID
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// addWorkspace gives a client a completed Sentinel deployment to hunt in
+func addWorkspace(t *testing.T, db *sql.DB, clientID string) {
+	t.Helper()
+	if _, err := db.Exec(`
+		INSERT INTO deployment_mgmt.sentinel_deployments
+			(client_id, workspace_name, workspace_id, subscription_id, resource_group, location,
+			 deployment_status, configuration, deployed_at)
+		VALUES ($1, 'sentinel', 'workspace-' || $1, 'sub', 'rg', 'westeurope', 'completed', '{}', NOW())`,
+		clientID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newHunter(db *sql.DB, backend sightings.QueryBackend) *sightings.Hunter {
+	return sightings.NewHunter(backend, sightings.NewStore(db), indicator.NewStore(db), sightings.NewTicketStore(db))
+}
+
+func TestHunterHunt(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	contoso := dbtest.CreateClient(t, db, "Contoso")
+	fabrikam := dbtest.CreateClient(t, db, "Fabrikam")
+	ids := addIndicators(t, db,
+		indicator.Indicator{Type: indicator.TypeIPv4, Value: "45.33.32.156", ThreatType: "C2", Confidence: 80, Source: "test"},
+		indicator.Indicator{ClientID: contoso, Type: indicator.TypeSHA256, Value: sha256,
+			ThreatType: "Malware", Confidence: 90, Source: "test"},
+		indicator.Indicator{ClientID: fabrikam, Type: indicator.TypeDomain, Value: "evil.org",
+			ThreatType: "Phishing", Confidence: 70, Source: "test"},
+	)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	backend := sightingstest.NewBackend()
+	backend.Add(contoso,
+		sightingstest.Event{Table: "DeviceNetworkEvents", Value: "45.33.32.156", Entity: "laptop-1", Time: now.Add(-2 * time.Hour)},
+		sightingstest.Event{Table: "DeviceNetworkEvents", Value: "45.33.32.156", Entity: "laptop-2", Time: now.Add(-time.Hour)},
+		sightingstest.Event{Table: "SigninLogs", Value: "45.33.32.156", Entity: "alice@contoso.com", Time: now.Add(-time.Hour)},
+		sightingstest.Event{Table: "DeviceFileEvents", Value: strings.ToUpper(sha256), Entity: "laptop-1", Time: now},
+		// Fabrikam's domain is not hunted for in Contoso
+		sightingstest.Event{Table: "DeviceNetworkEvents", Value: "evil.org", Entity: "laptop-1", Time: now},
+		// Outside the lookback
+		sightingstest.Event{Table: "DeviceNetworkEvents", Value: "45.33.32.156", Entity: "laptop-3", Time: now.Add(-30 * 24 * time.Hour)},
+	)
+
+	hunter := newHunter(db, backend)
+	result, err := hunter.Hunt(ctx, sightings.HuntRequest{ClientID: contoso})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Clients != 1 || len(result.Errors) != 0 {
+		t.Fatalf("Hunt() = %+v, want one client without errors", result)
+	}
+	// Two probes per address type and one for the hash
+	if result.Queries != 3 || len(backend.Queries()) != 3 {
+		t.Errorf("ran %d queries (%d seen by the backend), want 3", result.Queries, len(backend.Queries()))
+	}
+	for _, q := range backend.Queries() {
+		if strings.Contains(q, "evil.org") {
+			t.Errorf("query for Contoso mentions Fabrikam's indicator:\n%s", q)
+		}
+	}
+
+	type seen struct {
+		IndicatorID, Source string
+		Count               int
+		Entities            []string
+	}
+	var got []seen
+	for _, s := range result.Sightings {
+		if s.ID == "" || s.ClientID != contoso {
+			t.Errorf("sighting %+v was not recorded for Contoso", s)
+		}
+		got = append(got, seen{s.IndicatorID, s.Source, s.Count, s.Entities})
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].Source < got[j].Source })
+	want := []seen{
+		{ids[sha256], "DeviceFileEvents", 1, []string{"laptop-1"}},
+		{ids["45.33.32.156"], "DeviceNetworkEvents", 2, []string{"laptop-1", "laptop-2"}},
+		{ids["45.33.32.156"], "SigninLogs", 1, []string{"alice@contoso.com"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sightings = %+v, want %+v", got, want)
+	}
+
+	// Hunting again merges into the recorded sightings
+	backend.Add(contoso, sightingstest.Event{Table: "DeviceNetworkEvents", Value: "45.33.32.156", Entity: "laptop-4", Time: now})
+	if _, err := hunter.Hunt(ctx, sightings.HuntRequest{ClientID: contoso}); err != nil {
+		t.Fatal(err)
+	}
+	stored, total, err := hunter.Store().Search(ctx, sightings.Filter{ClientID: contoso, Source: "DeviceNetworkEvents"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || stored[0].Count != 3 || len(stored[0].Entities) != 3 || !stored[0].LastSeen.Equal(now) {
+		t.Errorf("stored sightings = %d: %+v, want one seen 3 times by 3 devices until %s", total, stored, now)
+	}
+}
+
+func TestHunterHuntsEveryClient(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	contoso := dbtest.CreateClient(t, db, "Contoso")
+	fabrikam := dbtest.CreateClient(t, db, "Fabrikam")
+	dbtest.CreateClient(t, db, "Without Sentinel")
+	addWorkspace(t, db, contoso)
+	addWorkspace(t, db, fabrikam)
+	addIndicators(t, db, indicator.Indicator{Type: indicator.TypeIPv4, Value: "45.33.32.156",
+		ThreatType: "C2", Confidence: 80, Source: "test"})
+
+	backend := sightingstest.NewBackend()
+	backend.Add(fabrikam, sightingstest.Event{Table: "SigninLogs", Value: "45.33.32.156",
+		Entity: "bob@fabrikam.com", Time: time.Now()})
+	result, err := newHunter(db, backend).Hunt(ctx, sightings.HuntRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Clients != 2 || result.Queries != 4 {
+		t.Errorf("Hunt() = %+v, want 2 clients with 2 queries each", result)
+	}
+	if len(result.Sightings) != 1 || result.Sightings[0].ClientID != fabrikam {
+		t.Errorf("sightings = %+v, want one in Fabrikam", result.Sightings)
+	}
+}
+
+func TestHunterChunksQueries(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	contoso := dbtest.CreateClient(t, db, "Contoso")
+	var indicators []indicator.Indicator
+	for i := 0; i < 1001; i++ {
+		indicators = append(indicators, indicator.Indicator{Type: indicator.TypeIPv4,
+			Value: fmt.Sprintf("45.33.%d.%d", i/250, i%250+1), ThreatType: "C2", Confidence: 80, Source: "test"})
+	}
+	addIndicators(t, db, indicators...)
+
+	backend := sightingstest.NewBackend()
+	hunter := newHunter(db, backend)
+	hunter.Probes = []sightings.Probe{sightings.DefaultProbes[0]}
+	result, err := hunter.Hunt(ctx, sightings.HuntRequest{ClientID: contoso})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Queries != 2 {
+		t.Fatalf("ran %d queries for 1001 values, want 2", result.Queries)
+	}
+	values := 0
+	for _, q := range backend.Queries() {
+		values += strings.Count(strings.SplitN(q, "\n", 2)[0], `"`) / 2
+	}
+	if values != 1001 {
+		t.Errorf("queries hunted for %d values, want 1001", values)
+	}
+}
+
+func TestHunterCollectsQueryErrors(t *testing.T) {
+	db := dbtest.Open(t)
+	contoso := dbtest.CreateClient(t, db, "Contoso")
+	addIndicators(t, db, indicator.Indicator{Type: indicator.TypeIPv4, Value: "45.33.32.156",
+		ThreatType: "C2", Confidence: 80, Source: "test"})
+
+	backend := sightingstest.NewBackend()
+	backend.Err = errors.New("workspace not found")
+	result, err := newHunter(db, backend).Hunt(context.Background(), sightings.HuntRequest{ClientID: contoso})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 2 || !strings.Contains(result.Errors[0], "workspace not found") {
+		t.Errorf("errors = %q, want one per failed query", result.Errors)
+	}
+	if len(result.Sightings) != 0 {
+		t.Errorf("sightings = %+v, want none", result.Sightings)
+	}
+}
+
+func TestHunterRaiseTicket(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	contoso := dbtest.CreateClient(t, db, "Contoso")
+	addIndicators(t, db, indicator.Indicator{ClientID: contoso, Type: indicator.TypeSHA256, Value: sha256,
+		ThreatType: "Malware", Confidence: 90, Source: "test"})
+	backend := sightingstest.NewBackend()
+	backend.Add(contoso, sightingstest.Event{Table: "DeviceFileEvents", Value: sha256, Entity: "laptop-1", Time: time.Now()})
+	hunter := newHunter(db, backend)
+	result, err := hunter.Hunt(ctx, sightings.HuntRequest{ClientID: contoso})
+	if err != nil || len(result.Sightings) != 1 {
+		t.Fatalf("Hunt() = %+v, %v; want one sighting", result, err)
+	}
+
+	s, err := hunter.RaiseTicket(ctx, result.Sightings[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.TicketID == nil {
+		t.Fatal("the sighting was not linked to a ticket")
+	}
+	var title, description, agent, priority string
+	if err := db.QueryRow(`
+		SELECT title, description, agent_type, priority FROM tickets WHERE id = $1`, *s.TicketID).
+		Scan(&title, &description, &agent, &priority); err != nil {
+		t.Fatal(err)
+	}
+	if title != "Indicator sighting: "+sha256+" in DeviceFileEvents" || agent != "kql_hunting" || priority != "critical" {
+		t.Errorf("ticket = %q for %s at %s priority, want a critical kql_hunting ticket", title, agent, priority)
+	}
+	if !strings.Contains(description, "Affected: laptop-1") || !strings.Contains(description, "sighting--"+s.ID) {
+		t.Errorf("ticket description = %q, want the affected device and sighting", description)
+	}
+
+	// Raising it again returns the same ticket
+	again, err := hunter.RaiseTicket(ctx, s.ID)
+	if err != nil || again.TicketID == nil || *again.TicketID != *s.TicketID {
+		t.Errorf("second RaiseTicket() = %+v, %v; want ticket %d", again, err, *s.TicketID)
+	}
+	var tickets int
+	if err := db.QueryRow("SELECT COUNT(*) FROM tickets").Scan(&tickets); err != nil || tickets != 1 {
+		t.Errorf("%d tickets, %v; want 1", tickets, err)
+	}
+}
@@ -0,0 +1,92 @@
+// Package sightings hunts for indicators in client telemetry and records
+// the hits as STIX sightings
+package sightings
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+// maxQueryValues caps the indicator values inlined into one query
+const maxQueryValues = 1000
+
+// Probe describes where indicators of one type show up in a table
+type Probe struct {
+	// Table is the Log Analytics table searched
+	Table string
+	Type  indicator.Type
+	// Match is a KQL expression yielding the value compared, case
+	// insensitively, with indicator values
+	Match string
+	// Entity is a KQL expression naming the device, user or message
+	// involved in a hit
+	Entity string
+}
+
+// hostFromURL extracts the lowercase host name of a URL column
+const hostFromURL = `tolower(extract(@"^(?:[a-zA-Z][a-zA-Z0-9+.-]*://)?(?:[^@/]*@)?([^/:?#]+)", 1, %s))`
+
+// DefaultProbes searches device network and file events, email URLs and
+// Entra ID sign-ins
+var DefaultProbes = []Probe{
+	{Table: "DeviceNetworkEvents", Type: indicator.TypeIPv4, Match: "RemoteIP", Entity: "DeviceName"},
+	{Table: "DeviceNetworkEvents", Type: indicator.TypeIPv6, Match: "RemoteIP", Entity: "DeviceName"},
+	{Table: "DeviceNetworkEvents", Type: indicator.TypeDomain, Match: fmt.Sprintf(hostFromURL, "RemoteUrl"), Entity: "DeviceName"},
+	{Table: "DeviceFileEvents", Type: indicator.TypeMD5, Match: "MD5", Entity: "DeviceName"},
+	{Table: "DeviceFileEvents", Type: indicator.TypeSHA1, Match: "SHA1", Entity: "DeviceName"},
+	{Table: "DeviceFileEvents", Type: indicator.TypeSHA256, Match: "SHA256", Entity: "DeviceName"},
+	{Table: "EmailUrlInfo", Type: indicator.TypeURL, Match: "Url", Entity: "NetworkMessageId"},
+	{Table: "EmailUrlInfo", Type: indicator.TypeDomain, Match: "UrlDomain", Entity: "NetworkMessageId"},
+	{Table: "SigninLogs", Type: indicator.TypeIPv4, Match: "IPAddress", Entity: "UserPrincipalName"},
+	{Table: "SigninLogs", Type: indicator.TypeIPv6, Match: "IPAddress", Entity: "UserPrincipalName"},
+}
+
+// BuildQuery returns a query summarising the hits on values in the probe's
+// table over the lookback period. Every result row has the columns IOC,
+// Count, FirstSeen, LastSeen and Entities.
+func BuildQuery(p Probe, values []string, lookback time.Duration) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = kqlString(strings.ToLower(v))
+	}
+	return fmt.Sprintf(`let iocs = dynamic([%s]);
+%s
+| where TimeGenerated > ago(%s)
+| extend IOC = tolower(tostring(%s))
+| where IOC in (iocs)
+| summarize Count = count(), FirstSeen = min(TimeGenerated), LastSeen = max(TimeGenerated),
+    Entities = make_set(tostring(%s), 10) by IOC`,
+		strings.Join(quoted, ", "), p.Table, kqlTimespan(lookback), p.Match, p.Entity)
+}
+
+// kqlString quotes a value as a KQL string literal
+func kqlString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`).Replace(s) + `"`
+}
+
+// kqlTimespan renders a duration as a KQL timespan literal
+func kqlTimespan(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+// chunk splits values into groups of at most maxQueryValues
+func chunk(values []string) [][]string {
+	var chunks [][]string
+	for len(values) > maxQueryValues {
+		chunks = append(chunks, values[:maxQueryValues])
+		values = values[maxQueryValues:]
+	}
+	if len(values) > 0 {
+		chunks = append(chunks, values)
+	}
+	return chunks
+}
@@ -0,0 +1,94 @@
+package sightings
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+)
+
+func TestBuildQuery(t *testing.T) {
+	probe := Probe{Table: "DeviceNetworkEvents", Type: indicator.TypeDomain, Match: "RemoteUrl", Entity: "DeviceName"}
+	got := BuildQuery(probe, []string{"Evil.ORG", `say "hi"`, `back\slash`, "two\r\nlines"}, 7*24*time.Hour)
+	want := `let iocs = dynamic(["evil.org", "say \"hi\"", "back\\slash", "two\r\nlines"]);
+DeviceNetworkEvents
+| where TimeGenerated > ago(7d)
+| extend IOC = tolower(tostring(RemoteUrl))
+| where IOC in (iocs)
+| summarize Count = count(), FirstSeen = min(TimeGenerated), LastSeen = max(TimeGenerated),
+    Entities = make_set(tostring(DeviceName), 10) by IOC`
+	if got != want {
+		t.Errorf("BuildQuery() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestKQLString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"evil.org", `"evil.org"`},
+		{`"); drop`, `"\"); drop"`},
+		{`C:\Windows`, `"C:\\Windows"`},
+		{"a\nb", `"a\nb"`},
+	}
+	for _, tt := range tests {
+		if got := kqlString(tt.in); got != tt.want {
+			t.Errorf("kqlString(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestKQLTimespan(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{7 * 24 * time.Hour, "7d"},
+		{90 * 24 * time.Hour, "90d"},
+		{36 * time.Hour, "36h"},
+		{time.Hour, "1h"},
+		{90 * time.Minute, "90m"},
+	}
+	for _, tt := range tests {
+		if got := kqlTimespan(tt.d); got != tt.want {
+			t.Errorf("kqlTimespan(%s) = %s, want %s", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestChunk(t *testing.T) {
+	values := func(n int) []string {
+		out := make([]string, n)
+		for i := range out {
+			out[i] = fmt.Sprint(i)
+		}
+		return out
+	}
+	tests := []struct {
+		n    int
+		want []int
+	}{
+		{0, nil},
+		{1, []int{1}},
+		{maxQueryValues, []int{maxQueryValues}},
+		{maxQueryValues + 1, []int{maxQueryValues, 1}},
+		{2*maxQueryValues + 500, []int{maxQueryValues, maxQueryValues, 500}},
+	}
+	for _, tt := range tests {
+		chunks := chunk(values(tt.n))
+		var sizes []int
+		var joined []string
+		for _, c := range chunks {
+			sizes = append(sizes, len(c))
+			joined = append(joined, c...)
+		}
+		if fmt.Sprint(sizes) != fmt.Sprint(tt.want) {
+			t.Errorf("chunk(%d values) sizes = %v, want %v", tt.n, sizes, tt.want)
+		}
+		if strings.Join(joined, ",") != strings.Join(values(tt.n), ",") {
+			t.Errorf("chunk(%d values) lost or reordered values", tt.n)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Package sightingstest provides a query backend that answers hunts from
+// canned telemetry, for running sighting correlation offline
+package sightingstest
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/sightings"
+)
+
+// Event is one telemetry record a client produced
+type Event struct {
+	Table  string
+	Value  string
+	Entity string
+	Time   time.Time
+}
+
+// Backend answers queries built by sightings.BuildQuery by filtering its
+// events on the table, indicator values and time range of the query
+type Backend struct {
+	mu      sync.Mutex
+	events  map[string][]Event
+	queries []string
+	// Err, when set, is returned for every query
+	Err error
+}
+
+// NewBackend creates a backend without events
+func NewBackend() *Backend {
+	return &Backend{events: make(map[string][]Event)}
+}
+
+// Add records events for a client
+func (b *Backend) Add(clientID string, events ...Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events[clientID] = append(b.events[clientID], events...)
+}
+
+// Queries returns the queries run so far
+func (b *Backend) Queries() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.queries...)
+}
+
+var (
+	iocsRegex  = regexp.MustCompile(`let iocs = dynamic\(\[(.*)\]\);`)
+	tableRegex = regexp.MustCompile(`\n(\w+)\n`)
+	valueRegex = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+)
+
+// Query implements sightings.QueryBackend
+func (b *Backend) Query(ctx context.Context, clientID, query string, timespan time.Duration) ([]sightings.Row, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queries = append(b.queries, query)
+	if b.Err != nil {
+		return nil, b.Err
+	}
+
+	table := ""
+	if m := tableRegex.FindStringSubmatch(query); m != nil {
+		table = m[1]
+	}
+	wanted := make(map[string]bool)
+	if m := iocsRegex.FindStringSubmatch(query); m != nil {
+		for _, v := range valueRegex.FindAllStringSubmatch(m[1], -1) {
+			wanted[strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(v[1])] = true
+		}
+	}
+
+	since := time.Now().Add(-timespan)
+	hits := make(map[string]*sightings.Row)
+	var order []string
+	for _, e := range b.events[clientID] {
+		value := strings.ToLower(e.Value)
+		if e.Table != table || !wanted[value] || e.Time.Before(since) {
+			continue
+		}
+		row, ok := hits[value]
+		if !ok {
+			row = &sightings.Row{"IOC": value, "Count": float64(0), "FirstSeen": e.Time, "LastSeen": e.Time,
+				"Entities": []interface{}{}}
+			hits[value] = row
+			order = append(order, value)
+		}
+		r := *row
+		r["Count"] = r["Count"].(float64) + 1
+		if e.Time.Before(r["FirstSeen"].(time.Time)) {
+			r["FirstSeen"] = e.Time
+		}
+		if e.Time.After(r["LastSeen"].(time.Time)) {
+			r["LastSeen"] = e.Time
+		}
+		entities := r["Entities"].([]interface{})
+		seen := false
+		for _, existing := range entities {
+			seen = seen || existing == e.Entity
+		}
+		if !seen && e.Entity != "" && len(entities) < 10 {
+			r["Entities"] = append(entities, e.Entity)
+		}
+	}
+
+	rows := make([]sightings.Row, 0, len(order))
+	for _, v := range order {
+		rows = append(rows, *hits[v])
+	}
+	return rows, nil
+}
@@ -0,0 +1,215 @@
+package sightings
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/stix"
+	"github.com/lib/pq"
+)
+
+// Sighting is an indicator seen in a client's telemetry
+type Sighting struct {
+	ID             string    `json:"id"`
+	IndicatorID    string    `json:"indicatorId"`
+	IndicatorType  string    `json:"indicatorType"`
+	IndicatorValue string    `json:"indicatorValue"`
+	ClientID       string    `json:"clientId"`
+	Source         string    `json:"source"`
+	Count          int       `json:"count"`
+	FirstSeen      time.Time `json:"firstSeen"`
+	LastSeen       time.Time `json:"lastSeen"`
+	Entities       []string  `json:"entities"`
+	TicketID       *int64    `json:"ticketId,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// STIX returns the sighting as a STIX object. The client is referenced as
+// the identity the indicator was sighted at.
+func (s *Sighting) STIX() stix.Sighting {
+	first, last := s.FirstSeen, s.LastSeen
+	return stix.Sighting{
+		Object: stix.Object{
+			Type:        "sighting",
+			SpecVersion: stix.SpecVersion,
+			ID:          "sighting--" + s.ID,
+			Created:     s.CreatedAt,
+			Modified:    s.UpdatedAt,
+		},
+		Description:      "Seen in " + s.Source,
+		FirstSeen:        &first,
+		LastSeen:         &last,
+		Count:            s.Count,
+		SightingOfRef:    "indicator--" + s.IndicatorID,
+		WhereSightedRefs: []string{"identity--" + s.ClientID},
+	}
+}
+
+// Filter selects sightings to search
+type Filter struct {
+	ClientID    string
+	IndicatorID string
+	// Value matches indicator values containing it
+	Value  string
+	Source string
+	// Since limits results to sightings last seen after it
+	Since  time.Time
+	Limit  int
+	Offset int
+}
+
+// Store persists sightings in deployment_mgmt.indicator_sightings
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates a sighting store
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Record stores a sighting, merging it into an earlier sighting of the
+// indicator in the same client and source. Count reflects the latest hunt,
+// which covers its whole lookback period.
+func (s *Store) Record(ctx context.Context, sighting *Sighting) error {
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO deployment_mgmt.indicator_sightings
+			(indicator_id, client_id, source, sighting_count, first_seen, last_seen, entities)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (indicator_id, client_id, source) DO UPDATE SET
+			sighting_count = EXCLUDED.sighting_count,
+			first_seen = LEAST(indicator_sightings.first_seen, EXCLUDED.first_seen),
+			last_seen = GREATEST(indicator_sightings.last_seen, EXCLUDED.last_seen),
+			entities = ARRAY(SELECT DISTINCT unnest(indicator_sightings.entities || EXCLUDED.entities)),
+			updated_at = NOW()
+		RETURNING id, created_at, updated_at`,
+		sighting.IndicatorID, sighting.ClientID, sighting.Source, sighting.Count,
+		sighting.FirstSeen, sighting.LastSeen, pq.Array(sighting.Entities)).Scan(
+		&sighting.ID, &sighting.CreatedAt, &sighting.UpdatedAt)
+}
+
+const sightingColumns = `
+	s.id, s.indicator_id, i.indicator_type, i.indicator_value, s.client_id, s.source,
+	s.sighting_count, s.first_seen, s.last_seen, s.entities, s.ticket_id, s.created_at, s.updated_at`
+
+func scanSighting(row interface{ Scan(...interface{}) error }) (*Sighting, error) {
+	var s Sighting
+	var ticket sql.NullInt64
+	if err := row.Scan(&s.ID, &s.IndicatorID, &s.IndicatorType, &s.IndicatorValue, &s.ClientID, &s.Source,
+		&s.Count, &s.FirstSeen, &s.LastSeen, pq.Array(&s.Entities), &ticket, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if ticket.Valid {
+		s.TicketID = &ticket.Int64
+	}
+	if s.Entities == nil {
+		s.Entities = []string{}
+	}
+	return &s, nil
+}
+
+// Get returns one sighting
+func (s *Store) Get(ctx context.Context, id string) (*Sighting, error) {
+	return scanSighting(s.db.QueryRowContext(ctx, `
+		SELECT `+sightingColumns+`
+		FROM deployment_mgmt.indicator_sightings s
+		JOIN deployment_mgmt.threat_indicators i ON i.id = s.indicator_id
+		WHERE s.id = $1`, id))
+}
+
+// Search returns the sightings matching the filter, most recently seen
+// first, and the total number of matches
+func (s *Store) Search(ctx context.Context, filter Filter) ([]*Sighting, int, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.ClientID != "" {
+		conditions = append(conditions, "s.client_id = "+arg(filter.ClientID))
+	}
+	if filter.IndicatorID != "" {
+		conditions = append(conditions, "s.indicator_id = "+arg(filter.IndicatorID))
+	}
+	if filter.Value != "" {
+		conditions = append(conditions, "i.indicator_value ILIKE '%' || "+arg(filter.Value)+" || '%'")
+	}
+	if filter.Source != "" {
+		conditions = append(conditions, "s.source = "+arg(filter.Source))
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "s.last_seen >= "+arg(filter.Since))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	from := `
+		FROM deployment_mgmt.indicator_sightings s
+		JOIN deployment_mgmt.threat_indicators i ON i.id = s.indicator_id
+		` + where
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*)"+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sightingColumns+from+`
+		ORDER BY s.last_seen DESC, s.id
+		LIMIT `+arg(limit)+` OFFSET `+arg(filter.Offset), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	sightings := []*Sighting{}
+	for rows.Next() {
+		sighting, err := scanSighting(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		sightings = append(sightings, sighting)
+	}
+	return sightings, total, rows.Err()
+}
+
+// SetTicket links a sighting to the ticket raised for it
+func (s *Store) SetTicket(ctx context.Context, id string, ticketID int64) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE deployment_mgmt.indicator_sightings
+		SET ticket_id = $2, updated_at = NOW()
+		WHERE id = $1`, id, ticketID)
+	return err
+}
+
+// HuntableClients returns the clients with a Sentinel workspace to query
+func (s *Store) HuntableClients(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT client_id FROM deployment_mgmt.sentinel_deployments
+		WHERE deployment_status = 'completed' AND workspace_id IS NOT NULL AND client_id IS NOT NULL
+		ORDER BY client_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		clients = append(clients, id)
+	}
+	return clients, rows.Err()
+}
@@ -0,0 +1,59 @@
+package sightings
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/indicator"
+	"github.com/lib/pq"
+)
+
+// Ticketer raises tickets for sightings
+type Ticketer interface {
+	RaiseTicket(ctx context.Context, s *Sighting, ind *indicator.Indicator) (int64, error)
+}
+
+// TicketStore raises sightings as tickets for the KQL hunting agent
+type TicketStore struct {
+	db *sql.DB
+}
+
+// NewTicketStore creates a ticket store
+func NewTicketStore(db *sql.DB) *TicketStore {
+	return &TicketStore{db: db}
+}
+
+// RaiseTicket implements Ticketer. Priority follows the indicator's
+// confidence.
+func (t *TicketStore) RaiseTicket(ctx context.Context, s *Sighting, ind *indicator.Indicator) (int64, error) {
+	priority := "low"
+	switch {
+	case ind.Confidence >= 90:
+		priority = "critical"
+	case ind.Confidence >= 75:
+		priority = "high"
+	case ind.Confidence >= 50:
+		priority = "medium"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Threat indicator %s (%s, %s, confidence %d) was seen %d times in %s ",
+		ind.Value, ind.Type, ind.ThreatType, ind.Confidence, s.Count, s.Source)
+	fmt.Fprintf(&b, "between %s and %s.\n", s.FirstSeen.UTC().Format("2006-01-02 15:04 MST"),
+		s.LastSeen.UTC().Format("2006-01-02 15:04 MST"))
+	if len(s.Entities) > 0 {
+		fmt.Fprintf(&b, "\nAffected: %s\n", strings.Join(s.Entities, ", "))
+	}
+	fmt.Fprintf(&b, "\nClient: %s\nSighting: sighting--%s\nIndicator source: %s\n", s.ClientID, s.ID, ind.Source)
+
+	var id int64
+	err := t.db.QueryRowContext(ctx, `
+		INSERT INTO tickets (title, description, agent_type, status, priority, tags)
+		VALUES ($1, $2, 'kql_hunting', 'open', $3, $4)
+		RETURNING id`,
+		fmt.Sprintf("Indicator sighting: %s in %s", ind.Value, s.Source), b.String(), priority,
+		pq.Array([]string{"sighting", "threat-intel", string(ind.Type)})).Scan(&id)
+	return id, err
+}
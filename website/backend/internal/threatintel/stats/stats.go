@@ -0,0 +1,259 @@
+// Package stats computes threat intelligence health and volume figures
+// for the dashboard
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Overall sync states of a Snapshot
+const (
+	StatusIdle     = "idle"
+	StatusSyncing  = "syncing"
+	StatusDegraded = "degraded"
+)
+
+// Feed health values
+const (
+	HealthHealthy  = "healthy"
+	HealthSyncing  = "syncing"
+	HealthOverdue  = "overdue"
+	HealthFailing  = "failing"
+	HealthNever    = "never_synced"
+	HealthDisabled = "disabled"
+)
+
+// overdueGrace is how long past its interval a feed may go before it
+// counts as overdue, covering the scheduler's check interval and sync time
+const overdueGrace = 15 * time.Minute
+
+// FeedHealth is the sync state of one feed
+type FeedHealth struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Type      string     `json:"type"`
+	Enabled   bool       `json:"enabled"`
+	Health    string     `json:"health"`
+	Status    string     `json:"status,omitempty"`
+	Progress  int        `json:"progress"`
+	LastSync  *time.Time `json:"lastSync,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+	// FrequencyHours is the configured sync interval
+	FrequencyHours int `json:"frequencyHours"`
+	// LagSeconds is how long the feed is past its next scheduled sync
+	LagSeconds     int64 `json:"lagSeconds"`
+	IndicatorCount int   `json:"indicatorCount"`
+}
+
+// Count is the number of indicators with one value of a dimension
+type Count struct {
+	Key   string `json:"key"`
+	Label string `json:"label,omitempty"`
+	Count int    `json:"count"`
+}
+
+// IndicatorCounts breaks active indicators down by dimension
+type IndicatorCounts struct {
+	ByType       []Count `json:"byType"`
+	BySource     []Count `json:"bySource"`
+	ByConfidence []Count `json:"byConfidence"`
+	// ByClient counts client indicators; global ones are under an empty key
+	ByClient []Count `json:"byClient"`
+}
+
+// TargetCounts is the distribution backlog of one target
+type TargetCounts struct {
+	Target   string `json:"target"`
+	Pending  int    `json:"pending"`
+	Removing int    `json:"removing"`
+	Failed   int    `json:"failed"`
+	Synced   int    `json:"synced"`
+}
+
+// Snapshot is the state of threat intelligence at one moment
+type Snapshot struct {
+	GeneratedAt     time.Time       `json:"generatedAt"`
+	SyncStatus      string          `json:"syncStatus"`
+	TotalIndicators int             `json:"totalIndicators"`
+	ActiveFeeds     int             `json:"activeFeeds"`
+	LastUpdate      *time.Time      `json:"lastUpdate,omitempty"`
+	Feeds           []FeedHealth    `json:"feeds"`
+	Indicators      IndicatorCounts `json:"indicators"`
+	Distribution    []TargetCounts  `json:"distribution"`
+}
+
+// Collector reads snapshots from the database
+type Collector struct {
+	db *sql.DB
+}
+
+// NewCollector creates a collector
+func NewCollector(db *sql.DB) *Collector {
+	return &Collector{db: db}
+}
+
+// Collect computes a snapshot
+func (c *Collector) Collect(ctx context.Context) (*Snapshot, error) {
+	now := time.Now().UTC()
+	snap := &Snapshot{GeneratedAt: now, SyncStatus: StatusIdle}
+
+	var lastUpdate sql.NullTime
+	err := c.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM deployment_mgmt.threat_indicators WHERE is_active = true),
+			(SELECT MAX(updated_at) FROM deployment_mgmt.threat_indicators)`).Scan(
+		&snap.TotalIndicators, &lastUpdate)
+	if err != nil {
+		return nil, err
+	}
+	if lastUpdate.Valid {
+		snap.LastUpdate = &lastUpdate.Time
+	}
+
+	if snap.Feeds, err = c.feeds(ctx, now); err != nil {
+		return nil, err
+	}
+	for _, f := range snap.Feeds {
+		if f.Enabled {
+			snap.ActiveFeeds++
+		}
+		switch f.Health {
+		case HealthSyncing:
+			snap.SyncStatus = StatusSyncing
+		case HealthFailing, HealthOverdue:
+			if snap.SyncStatus == StatusIdle {
+				snap.SyncStatus = StatusDegraded
+			}
+		}
+	}
+
+	if snap.Indicators.ByType, err = c.counts(ctx, `
+		SELECT indicator_type, '', COUNT(*) FROM deployment_mgmt.threat_indicators
+		WHERE is_active = true GROUP BY 1 ORDER BY 3 DESC, 1`); err != nil {
+		return nil, err
+	}
+	if snap.Indicators.BySource, err = c.counts(ctx, `
+		SELECT COALESCE(source, ''), '', COUNT(*) FROM deployment_mgmt.threat_indicators
+		WHERE is_active = true GROUP BY 1 ORDER BY 3 DESC, 1`); err != nil {
+		return nil, err
+	}
+	if snap.Indicators.ByConfidence, err = c.counts(ctx, `
+		SELECT band, '', COUNT(*) FROM (
+			SELECT CASE
+				WHEN COALESCE(confidence, 0) >= 90 THEN '90-100'
+				WHEN confidence >= 75 THEN '75-89'
+				WHEN confidence >= 50 THEN '50-74'
+				ELSE '0-49' END AS band
+			FROM deployment_mgmt.threat_indicators WHERE is_active = true
+		) b GROUP BY 1 ORDER BY 1 DESC`); err != nil {
+		return nil, err
+	}
+	if snap.Indicators.ByClient, err = c.counts(ctx, `
+		SELECT COALESCE(i.client_id::text, ''), COALESCE(c.name, ''), COUNT(*)
+		FROM deployment_mgmt.threat_indicators i
+		LEFT JOIN client_mgmt.clients c ON c.id = i.client_id
+		WHERE i.is_active = true GROUP BY 1, 2 ORDER BY 3 DESC, 1`); err != nil {
+		return nil, err
+	}
+
+	if snap.Distribution, err = c.distribution(ctx); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+func (c *Collector) feeds(ctx context.Context, now time.Time) ([]FeedHealth, error) {
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT id, name, feed_type, COALESCE(enabled, false), COALESCE(last_sync_status, ''),
+			COALESCE(sync_progress, 0), last_update, COALESCE(last_sync_error, ''),
+			COALESCE(update_frequency_hours, 24), COALESCE(indicator_count, 0)
+		FROM deployment_mgmt.threat_feeds
+		ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	feeds := []FeedHealth{}
+	for rows.Next() {
+		var f FeedHealth
+		var lastSync sql.NullTime
+		if err := rows.Scan(&f.ID, &f.Name, &f.Type, &f.Enabled, &f.Status, &f.Progress,
+			&lastSync, &f.LastError, &f.FrequencyHours, &f.IndicatorCount); err != nil {
+			return nil, err
+		}
+		if lastSync.Valid {
+			f.LastSync = &lastSync.Time
+			next := lastSync.Time.Add(time.Duration(f.FrequencyHours) * time.Hour)
+			if lag := now.Sub(next); lag > 0 {
+				f.LagSeconds = int64(lag / time.Second)
+			}
+		}
+		f.Health = feedHealth(&f)
+		feeds = append(feeds, f)
+	}
+	return feeds, rows.Err()
+}
+
+// feedHealth classifies a feed from its sync state and lag
+func feedHealth(f *FeedHealth) string {
+	switch {
+	case !f.Enabled:
+		return HealthDisabled
+	case f.Status == "running":
+		return HealthSyncing
+	case f.Status == "failed":
+		return HealthFailing
+	case f.LastSync == nil:
+		return HealthNever
+	case time.Duration(f.LagSeconds)*time.Second > overdueGrace:
+		return HealthOverdue
+	}
+	return HealthHealthy
+}
+
+func (c *Collector) counts(ctx context.Context, query string) ([]Count, error) {
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []Count{}
+	for rows.Next() {
+		var n Count
+		if err := rows.Scan(&n.Key, &n.Label, &n.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, n)
+	}
+	return counts, rows.Err()
+}
+
+func (c *Collector) distribution(ctx context.Context) ([]TargetCounts, error) {
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT target,
+			COUNT(*) FILTER (WHERE status = 'pending'),
+			COUNT(*) FILTER (WHERE status = 'removing'),
+			COUNT(*) FILTER (WHERE status = 'failed'),
+			COUNT(*) FILTER (WHERE status = 'synced')
+		FROM deployment_mgmt.indicator_distributions
+		GROUP BY target
+		ORDER BY target`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := []TargetCounts{}
+	for rows.Next() {
+		var t TargetCounts
+		if err := rows.Scan(&t.Target, &t.Pending, &t.Removing, &t.Failed, &t.Synced); err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
@@ -0,0 +1,128 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/dbtest"
+)
+
+func TestFeedHealth(t *testing.T) {
+	synced := time.Now()
+	tests := []struct {
+		name string
+		feed FeedHealth
+		want string
+	}{
+		{"disabled wins", FeedHealth{Enabled: false, Status: "failed", LastSync: &synced}, HealthDisabled},
+		{"running", FeedHealth{Enabled: true, Status: "running", LastSync: &synced, LagSeconds: 86400}, HealthSyncing},
+		{"failed", FeedHealth{Enabled: true, Status: "failed", LastSync: &synced}, HealthFailing},
+		{"never synced", FeedHealth{Enabled: true}, HealthNever},
+		{"within the grace period", FeedHealth{Enabled: true, Status: "success", LastSync: &synced,
+			LagSeconds: int64(overdueGrace / time.Second)}, HealthHealthy},
+		{"past the grace period", FeedHealth{Enabled: true, Status: "success", LastSync: &synced,
+			LagSeconds: int64(overdueGrace/time.Second) + 1}, HealthOverdue},
+		{"on schedule", FeedHealth{Enabled: true, Status: "success", LastSync: &synced}, HealthHealthy},
+	}
+	for _, tt := range tests {
+		if got := feedHealth(&tt.feed); got != tt.want {
+			t.Errorf("%s: feedHealth() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func exec(t *testing.T, db *sql.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addIndicator stores an indicator and returns its ID
+func addIndicator(t *testing.T, db *sql.DB, clientID interface{}, indicatorType, value, source string,
+	confidence int, active bool) string {
+	t.Helper()
+	var id string
+	if err := db.QueryRow(`
+		INSERT INTO deployment_mgmt.threat_indicators
+			(client_id, indicator_type, indicator_value, threat_type, confidence, source, is_active)
+		VALUES ($1, $2, $3, 'C2', $4, $5, $6)
+		RETURNING id`, clientID, indicatorType, value, confidence, source, active).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestCollectorCollect(t *testing.T) {
+	db := dbtest.Open(t)
+	contoso := dbtest.CreateClient(t, db, "Contoso")
+	exec(t, db, `
+		INSERT INTO deployment_mgmt.threat_feeds
+			(name, feed_type, enabled, update_frequency_hours, last_update, last_sync_status, indicator_count)
+		VALUES
+			('A healthy', 'csv', true, 24, NOW() - INTERVAL '1 hour', 'success', 3),
+			('B overdue', 'csv', true, 1, NOW() - INTERVAL '3 hours', 'success', 0),
+			('C never', 'json', true, 24, NULL, NULL, 0),
+			('D disabled', 'csv', false, 24, NULL, 'failed', 0)`)
+
+	first := addIndicator(t, db, nil, "ipv4", "45.33.32.156", "feed-a", 95, true)
+	addIndicator(t, db, nil, "ipv4", "45.33.32.157", "feed-a", 80, true)
+	addIndicator(t, db, contoso, "domain", "evil.org", "feed-b", 40, true)
+	addIndicator(t, db, contoso, "domain", "retired.org", "feed-b", 90, false)
+	for _, st := range []struct{ target, status string }{
+		{"MDE", "synced"}, {"Sentinel", "pending"},
+	} {
+		exec(t, db, `
+			INSERT INTO deployment_mgmt.indicator_distributions (indicator_id, client_id, target, status)
+			VALUES ($1, $2, $3, $4)`, first, contoso, st.target, st.status)
+	}
+
+	snap, err := NewCollector(db).Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.TotalIndicators != 3 || snap.ActiveFeeds != 3 || snap.LastUpdate == nil {
+		t.Errorf("snapshot = %d indicators, %d active feeds, updated %v; want 3, 3 and a time",
+			snap.TotalIndicators, snap.ActiveFeeds, snap.LastUpdate)
+	}
+	// An overdue feed degrades the overall state
+	if snap.SyncStatus != StatusDegraded {
+		t.Errorf("sync status = %s, want %s", snap.SyncStatus, StatusDegraded)
+	}
+
+	var health []string
+	for _, f := range snap.Feeds {
+		health = append(health, f.Name+": "+f.Health)
+	}
+	wantHealth := []string{"A healthy: healthy", "B overdue: overdue", "C never: never_synced", "D disabled: disabled"}
+	if !reflect.DeepEqual(health, wantHealth) {
+		t.Errorf("feed health = %q, want %q", health, wantHealth)
+	}
+	if lag := snap.Feeds[1].LagSeconds; lag < 7000 || lag > 7300 {
+		t.Errorf("overdue feed lag = %ds, want about two hours", lag)
+	}
+
+	want := IndicatorCounts{
+		ByType:       []Count{{Key: "ipv4", Count: 2}, {Key: "domain", Count: 1}},
+		BySource:     []Count{{Key: "feed-a", Count: 2}, {Key: "feed-b", Count: 1}},
+		ByConfidence: []Count{{Key: "90-100", Count: 1}, {Key: "75-89", Count: 1}, {Key: "0-49", Count: 1}},
+		ByClient:     []Count{{Key: "", Count: 2}, {Key: contoso, Label: "Contoso", Count: 1}},
+	}
+	if !reflect.DeepEqual(snap.Indicators, want) {
+		t.Errorf("indicator counts = %+v, want %+v", snap.Indicators, want)
+	}
+
+	wantTargets := []TargetCounts{{Target: "MDE", Synced: 1}, {Target: "Sentinel", Pending: 1}}
+	if !reflect.DeepEqual(snap.Distribution, wantTargets) {
+		t.Errorf("distribution = %+v, want %+v", snap.Distribution, wantTargets)
+	}
+
+	// A running sync outranks the overdue feed
+	exec(t, db, `UPDATE deployment_mgmt.threat_feeds SET last_sync_status = 'running' WHERE name = 'C never'`)
+	if snap, err := NewCollector(db).Collect(context.Background()); err != nil || snap.SyncStatus != StatusSyncing {
+		t.Errorf("Collect() while syncing = %v, %v; want %s", snap, err, StatusSyncing)
+	}
+}
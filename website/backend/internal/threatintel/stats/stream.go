@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultStreamInterval is how often streamed stats are recomputed, and
+	// DefaultStreamSyncInterval the faster rate used while a feed syncs
+	DefaultStreamInterval     = 15 * time.Second
+	DefaultStreamSyncInterval = 2 * time.Second
+)
+
+// Source computes snapshots. Collector is the source read from the database.
+type Source interface {
+	Collect(ctx context.Context) (*Snapshot, error)
+}
+
+// Stream serves snapshots as Server-Sent Events. A "stats" event is sent on
+// connect and whenever the figures change, an "error" event when a snapshot
+// fails, and comments keep idle connections open.
+type Stream struct {
+	Source       Source
+	Interval     time.Duration
+	SyncInterval time.Duration
+}
+
+// NewStream creates a stream of a source's snapshots at the default rates
+func NewStream(source Source) *Stream {
+	return &Stream{Source: source, Interval: DefaultStreamInterval, SyncInterval: DefaultStreamSyncInterval}
+}
+
+// ServeHTTP implements http.Handler. It returns when the client goes away.
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	// The stream outlives the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var last []byte
+	for {
+		snapshot, err := s.Source.Collect(r.Context())
+		if r.Context().Err() != nil {
+			return
+		}
+		interval := s.Interval
+		if err != nil {
+			log.Printf("Error collecting threat intel stats: %v", err)
+			fmt.Fprintf(w, "event: error\ndata: %q\n\n", err.Error())
+		} else {
+			if snapshot.SyncStatus == StatusSyncing {
+				interval = s.SyncInterval
+			}
+			// Compare without the timestamp so unchanged figures are not resent
+			generated := snapshot.GeneratedAt
+			snapshot.GeneratedAt = time.Time{}
+			data, _ := json.Marshal(snapshot)
+			if string(data) != string(last) {
+				last = data
+				snapshot.GeneratedAt = generated
+				data, _ = json.Marshal(snapshot)
+				fmt.Fprintf(w, "event: stats\ndata: %s\n\n", data)
+			} else {
+				fmt.Fprint(w, ": keepalive\n\n")
+			}
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package stats_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ClarityXDR/prod/website/backend/internal/threatintel/stats"
+)
+
+// script is a Source returning its results in turn, then the last one again
+type script struct {
+	mu      sync.Mutex
+	results []result
+	calls   int
+}
+
+type result struct {
+	snap *stats.Snapshot
+	err  error
+}
+
+func (s *script) Collect(ctx context.Context) (*stats.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.results[len(s.results)-1]
+	if s.calls < len(s.results) {
+		r = s.results[s.calls]
+	}
+	s.calls++
+	if r.err != nil {
+		return nil, r.err
+	}
+	// The stream clears GeneratedAt, so hand out copies
+	snap := *r.snap
+	snap.GeneratedAt = time.Now()
+	return &snap, nil
+}
+
+// readEvent returns the next event or comment of a stream, without the
+// blank line ending it
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended after %q: %v", lines, err)
+		}
+		if line == "\n" {
+			return strings.Join(lines, "")
+		}
+		lines = append(lines, line)
+	}
+}
+
+// statsData decodes the data line of a stats event
+func statsData(t *testing.T, event string) stats.Snapshot {
+	t.Helper()
+	if !strings.HasPrefix(event, "event: stats\ndata: ") || strings.Count(event, "\n") != 2 {
+		t.Fatalf("event = %q, want a stats event with one data line", event)
+	}
+	var snap stats.Snapshot
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "event: stats\ndata: ")), &snap); err != nil {
+		t.Fatalf("stats data: %v", err)
+	}
+	return snap
+}
+
+func TestStream(t *testing.T) {
+	idle := &stats.Snapshot{SyncStatus: stats.StatusIdle, TotalIndicators: 10, Feeds: []stats.FeedHealth{}}
+	syncing := &stats.Snapshot{SyncStatus: stats.StatusSyncing, TotalIndicators: 12, Feeds: []stats.FeedHealth{}}
+	source := &script{results: []result{
+		{snap: idle}, {snap: idle}, {err: errors.New(`database "ti" is down`)}, {snap: syncing},
+	}}
+
+	finished := make(chan struct{})
+	stream := &stats.Stream{Source: source, Interval: time.Millisecond, SyncInterval: time.Millisecond}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(finished)
+		stream.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" ||
+		resp.Header.Get("Cache-Control") != "no-cache" {
+		t.Fatalf("response = %d %v, want an uncached event stream", resp.StatusCode, resp.Header)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	if snap := statsData(t, readEvent(t, r)); snap.TotalIndicators != 10 || snap.GeneratedAt.IsZero() {
+		t.Errorf("first snapshot = %+v, want the idle figures with their time", snap)
+	}
+	// Unchanged figures are not resent
+	if event := readEvent(t, r); event != ": keepalive\n" {
+		t.Errorf("event after unchanged figures = %q, want a keepalive comment", event)
+	}
+	if event := readEvent(t, r); event != "event: error\ndata: \"database \\\"ti\\\" is down\"\n" {
+		t.Errorf("event after a failure = %q, want an error event with the quoted message", event)
+	}
+	if snap := statsData(t, readEvent(t, r)); snap.SyncStatus != stats.StatusSyncing || snap.TotalIndicators != 12 {
+		t.Errorf("changed snapshot = %+v, want the syncing figures", snap)
+	}
+	if event := readEvent(t, r); event != ": keepalive\n" {
+		t.Errorf("event after unchanged figures = %q, want a keepalive comment", event)
+	}
+
+	// The handler returns once the client goes away
+	cancel()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream kept running after the client disconnected")
+	}
+}
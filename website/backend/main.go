@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/ClarityXDR/prod/website/backend/app"
 	"github.com/ClarityXDR/prod/website/backend/config"
 	"github.com/ClarityXDR/prod/website/backend/database"
 	"github.com/ClarityXDR/prod/website/backend/handlers"
@@ -27,28 +32,15 @@ func main() {
 	// Create router
 	r := mux.NewRouter()
 
-	// Initialize handlers
-	agentHandler := handlers.NewAgentHandler(db)
-	kqlHandler := handlers.NewKQLHandler(db)
-	clientHandler := handlers.NewClientHandler(db)
-	rulesHandler := handlers.NewRulesHandler(db)
-	licenseHandler := handlers.NewLicenseHandler(db)
-	logicAppHandler := handlers.NewLogicAppHandler(db)
-	mdeHandler := handlers.NewMDEHandler(db)
-	threatIntelHandler := handlers.NewThreatIntelHandler(db)
-	sentinelHandler := handlers.NewSentinelHandler(db)
-
-	// Register routes
-	api := r.PathPrefix("/api").Subrouter()
-	agentHandler.RegisterRoutes(api)
-	kqlHandler.RegisterRoutes(api)
-	clientHandler.RegisterRoutes(api)
-	rulesHandler.RegisterRoutes(api)
-	licenseHandler.RegisterRoutes(api)
-	logicAppHandler.RegisterRoutes(api)
-	mdeHandler.RegisterRoutes(api)
-	threatIntelHandler.RegisterRoutes(api)
-	sentinelHandler.RegisterRoutes(api)
+	// Start background jobs and register the API. The jobs stop on
+	// interrupt or SIGTERM.
+	jobsCtx, stopJobs := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopJobs()
+	backend, err := app.Start(jobsCtx, cfg, db, r)
+	if err != nil {
+		log.Fatal("Failed to start:", err)
+	}
+	handlers.NewLicenseHandler(db).RegisterRoutes(backend.API)
 
 	// Static file serving for the React app
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./frontend/build/")))
@@ -69,8 +61,17 @@ func main() {
 		port = "8080"
 	}
 
+	srv := &http.Server{Addr: ":" + port, Handler: handler}
+	go func() {
+		<-jobsCtx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
 	log.Printf("Server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, handler); err != nil {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatal("Server failed to start:", err)
 	}
+	backend.Wait()
 }
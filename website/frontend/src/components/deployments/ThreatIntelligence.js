@@ -28,6 +28,10 @@ const ThreatIntelligence = ({ setGlobalAlert }) => {
     fetchIndicators();
     fetchFeeds();
     fetchStats();
+
+    const events = new EventSource('/api/threat-intel/stats/stream');
+    events.addEventListener('stats', (e) => setStats(JSON.parse(e.data)));
+    return () => events.close();
   }, []);
 
   const fetchClients = async () => {
@@ -42,7 +46,7 @@ const ThreatIntelligence = ({ setGlobalAlert }) => {
   const fetchIndicators = async () => {
     try {
       const response = await axios.get('/api/threat-intel/indicators');
-      setIndicators(response.data);
+      setIndicators(response.data.indicators);
     } catch (err) {
       console.error('Failed to fetch indicators:', err);
     }
@@ -172,7 +176,7 @@ const ThreatIntelligence = ({ setGlobalAlert }) => {
           <Card>
             <Card.Body>
               <h6>Sync Status</h6>
-              <Badge bg={stats.syncStatus === 'syncing' ? 'warning' : 'success'}>
+              <Badge bg={stats.syncStatus === 'syncing' ? 'warning' : stats.syncStatus === 'degraded' ? 'danger' : 'success'}>
                 {stats.syncStatus}
               </Badge>
             </Card.Body>
@@ -0,0 +1,18 @@
+-- Threat intelligence feed ingestion
+
+-- Feed sync state
+ALTER TABLE deployment_mgmt.threat_feeds
+    ADD COLUMN IF NOT EXISTS last_sync_error TEXT,
+    ADD COLUMN IF NOT EXISTS last_sync_started_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS sync_cursor TIMESTAMP WITH TIME ZONE;
+
+-- Indicator provenance
+ALTER TABLE deployment_mgmt.threat_indicators
+    ADD COLUMN IF NOT EXISTS feed_id UUID REFERENCES deployment_mgmt.threat_feeds(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS external_id VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP WITH TIME ZONE;
+
+-- The unique index deduplicating global indicators is built by
+-- 21-indicator-global-unique.sql, once existing rows are normalized
+
+CREATE INDEX IF NOT EXISTS idx_threat_indicators_feed_id ON deployment_mgmt.threat_indicators(feed_id);
@@ -0,0 +1,18 @@
+-- TAXII 2.1 server
+
+-- Credentials used by clients and partner tools to pull indicators.
+-- Credentials without a client can only read the global collection.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.taxii_credentials (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    client_id UUID REFERENCES client_mgmt.clients(id) ON DELETE CASCADE,
+    username VARCHAR(100) NOT NULL UNIQUE,
+    password_hash VARCHAR(255) NOT NULL,
+    description TEXT,
+    is_active BOOLEAN DEFAULT TRUE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Collections are paged by modification time
+CREATE INDEX IF NOT EXISTS idx_threat_indicators_updated_at
+    ON deployment_mgmt.threat_indicators(updated_at, id);
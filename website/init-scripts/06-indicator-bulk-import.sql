@@ -0,0 +1,27 @@
+-- Bulk indicator imports
+
+-- Each upload is processed in the background as a job. Dry runs read and
+-- validate the file without storing indicators.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.indicator_import_jobs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    client_id UUID REFERENCES client_mgmt.clients(id) ON DELETE CASCADE,
+    format VARCHAR(20) NOT NULL,
+    options JSONB NOT NULL DEFAULT '{}',
+    dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued', -- queued, running, completed, failed
+    rows_read INTEGER NOT NULL DEFAULT 0,
+    accepted INTEGER NOT NULL DEFAULT 0,
+    duplicates INTEGER NOT NULL DEFAULT 0,
+    rejected INTEGER NOT NULL DEFAULT 0,
+    inserted INTEGER NOT NULL DEFAULT 0,
+    updated INTEGER NOT NULL DEFAULT 0,
+    row_errors JSONB NOT NULL DEFAULT '[]',
+    preview JSONB NOT NULL DEFAULT '[]',
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_indicator_import_jobs_created_at
+    ON deployment_mgmt.indicator_import_jobs(created_at);
@@ -0,0 +1,45 @@
+-- Indicator expiration and aging
+
+-- base_confidence is the confidence last reported for an indicator; the
+-- sweeper decays confidence from it according to the time since last_seen_at
+ALTER TABLE deployment_mgmt.threat_indicators
+    ADD COLUMN IF NOT EXISTS base_confidence INTEGER CHECK (base_confidence >= 0 AND base_confidence <= 100);
+
+UPDATE deployment_mgmt.threat_indicators
+SET base_confidence = confidence
+WHERE base_confidence IS NULL;
+
+UPDATE deployment_mgmt.threat_indicators
+SET last_seen_at = COALESCE(updated_at, created_at)
+WHERE last_seen_at IS NULL;
+
+-- Give existing indicators the IndicatorExpiration defaults from CTI-Config.json
+UPDATE deployment_mgmt.threat_indicators
+SET expiration_date = last_seen_at + CASE
+        WHEN indicator_type IN ('md5', 'sha1', 'sha256', 'hash', 'certificate') THEN INTERVAL '90 days'
+        WHEN indicator_type = 'domain' THEN INTERVAL '60 days'
+        WHEN indicator_type = 'email' THEN INTERVAL '15 days'
+        ELSE INTERVAL '30 days'
+    END
+WHERE expiration_date IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_threat_indicators_expiration
+    ON deployment_mgmt.threat_indicators(expiration_date) WHERE is_active = true;
+
+-- Changes waiting to be pushed to the platforms indicators are distributed
+-- to. Consumers re-check the indicator before acting, since it may have
+-- been renewed after the entry was queued.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.indicator_distribution_queue (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    indicator_id UUID NOT NULL REFERENCES deployment_mgmt.threat_indicators(id) ON DELETE CASCADE,
+    action VARCHAR(20) NOT NULL, -- remove
+    reason VARCHAR(100),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, done, failed, cancelled
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    processed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_indicator_distribution_queue_pending
+    ON deployment_mgmt.indicator_distribution_queue(created_at) WHERE status = 'pending';
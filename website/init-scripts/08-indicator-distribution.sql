@@ -0,0 +1,54 @@
+-- Indicator distribution to client security platforms
+
+-- Routing rules send indicators of a type to a set of targets. Client rules
+-- take precedence over global rules (client_id NULL); types without a rule
+-- use the DeploymentTargets of CTI-Config.json.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.distribution_routes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    client_id UUID REFERENCES client_mgmt.clients(id) ON DELETE CASCADE,
+    indicator_type VARCHAR(50) NOT NULL,
+    targets TEXT[] NOT NULL DEFAULT '{}',
+    min_confidence INTEGER NOT NULL DEFAULT 0 CHECK (min_confidence >= 0 AND min_confidence <= 100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_distribution_routes_client_type
+    ON deployment_mgmt.distribution_routes(client_id, indicator_type) WHERE client_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_distribution_routes_global_type
+    ON deployment_mgmt.distribution_routes(indicator_type) WHERE client_id IS NULL;
+
+-- Targets enabled for each client, with their connection settings such as
+-- the Sentinel workspaceId or the Defender for Cloud Apps portalUrl
+CREATE TABLE IF NOT EXISTS deployment_mgmt.distribution_targets (
+    client_id UUID NOT NULL REFERENCES client_mgmt.clients(id) ON DELETE CASCADE,
+    target VARCHAR(50) NOT NULL, -- Sentinel, MDE, EntraID, ExchangeTABL, MDCA
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    settings JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (client_id, target)
+);
+
+-- Sync state of each indicator on each client target. planned_at is the
+-- indicator version the row was planned for.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.indicator_distributions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    indicator_id UUID NOT NULL REFERENCES deployment_mgmt.threat_indicators(id) ON DELETE CASCADE,
+    client_id UUID NOT NULL REFERENCES client_mgmt.clients(id) ON DELETE CASCADE,
+    target VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, synced, failed, removing, removed
+    external_id VARCHAR(500),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    planned_at TIMESTAMP WITH TIME ZONE,
+    next_attempt_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    synced_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(indicator_id, client_id, target)
+);
+
+CREATE INDEX IF NOT EXISTS idx_indicator_distributions_due
+    ON deployment_mgmt.indicator_distributions(next_attempt_at) WHERE status IN ('pending', 'removing');
+CREATE INDEX IF NOT EXISTS idx_indicator_distributions_client_target
+    ON deployment_mgmt.indicator_distributions(client_id, target, status);
@@ -0,0 +1,26 @@
+-- Indicator enrichment from external reputation services
+
+-- Provider results, shared by every indicator with the same type and value.
+-- Rows are reused as a cache until expires_at.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.indicator_enrichments (
+    indicator_type VARCHAR(50) NOT NULL,
+    indicator_value TEXT NOT NULL,
+    provider VARCHAR(50) NOT NULL, -- virustotal, criminalip, msti
+    verdict VARCHAR(20) NOT NULL, -- malicious, suspicious, harmless, unknown
+    score INTEGER NOT NULL DEFAULT 0 CHECK (score >= 0 AND score <= 100),
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    details JSONB,
+    enriched_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (indicator_type, indicator_value, provider)
+);
+
+-- The confidence adjustment and tags of the last enrichment, so the next
+-- one can replace rather than stack on them
+ALTER TABLE deployment_mgmt.threat_indicators
+    ADD COLUMN IF NOT EXISTS enrichment_adjustment INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS enrichment_tags TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS enriched_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_threat_indicators_enriched_at
+    ON deployment_mgmt.threat_indicators(enriched_at NULLS FIRST) WHERE is_active = true;
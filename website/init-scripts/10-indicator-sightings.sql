@@ -0,0 +1,24 @@
+-- Indicator sightings found by hunting client telemetry
+
+-- One row per indicator, client and telemetry table, updated by every hunt
+-- that finds it again. ticket_id points at the public.tickets row raised
+-- for the sighting, if any.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.indicator_sightings (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    indicator_id UUID NOT NULL REFERENCES deployment_mgmt.threat_indicators(id) ON DELETE CASCADE,
+    client_id UUID NOT NULL REFERENCES client_mgmt.clients(id) ON DELETE CASCADE,
+    source VARCHAR(100) NOT NULL, -- DeviceNetworkEvents, DeviceFileEvents, EmailUrlInfo, SigninLogs
+    sighting_count INTEGER NOT NULL DEFAULT 0,
+    first_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+    entities TEXT[] NOT NULL DEFAULT '{}',
+    ticket_id INTEGER,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (indicator_id, client_id, source)
+);
+
+CREATE INDEX IF NOT EXISTS idx_indicator_sightings_client
+    ON deployment_mgmt.indicator_sightings(client_id, last_seen DESC);
+CREATE INDEX IF NOT EXISTS idx_indicator_sightings_last_seen
+    ON deployment_mgmt.indicator_sightings(last_seen DESC);
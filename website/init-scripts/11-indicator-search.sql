@@ -0,0 +1,18 @@
+-- Indexes behind indicator search
+
+-- Search pages newest first
+CREATE INDEX IF NOT EXISTS idx_threat_indicators_created_at
+    ON deployment_mgmt.threat_indicators(created_at DESC, id DESC);
+
+-- Value prefix matches
+CREATE INDEX IF NOT EXISTS idx_threat_indicators_value_prefix
+    ON deployment_mgmt.threat_indicators(indicator_value text_pattern_ops);
+
+CREATE INDEX IF NOT EXISTS idx_threat_indicators_tags
+    ON deployment_mgmt.threat_indicators USING GIN(tags);
+
+CREATE INDEX IF NOT EXISTS idx_threat_indicators_threat_type
+    ON deployment_mgmt.threat_indicators(threat_type);
+
+CREATE INDEX IF NOT EXISTS idx_threat_indicators_source
+    ON deployment_mgmt.threat_indicators(source);
@@ -0,0 +1,61 @@
+-- Indicator allow-list and review workflow
+
+-- Values that must never be deployed as malicious. Entries without a
+-- client_id apply to every client.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.indicator_allowlist (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    client_id UUID REFERENCES client_mgmt.clients(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL, -- exact, domain, cidr, hash_set
+    value TEXT NOT NULL, -- the hash set name for hash_set entries
+    description TEXT,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_indicator_allowlist_unique
+    ON deployment_mgmt.indicator_allowlist(
+        COALESCE(client_id, '00000000-0000-0000-0000-000000000000'::uuid), kind, value);
+
+-- Members of hash_set entries, such as a software publisher's signed binaries
+CREATE TABLE IF NOT EXISTS deployment_mgmt.indicator_allowlist_hashes (
+    entry_id UUID NOT NULL REFERENCES deployment_mgmt.indicator_allowlist(id) ON DELETE CASCADE,
+    hash VARCHAR(64) NOT NULL,
+    PRIMARY KEY (entry_id, hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_indicator_allowlist_hashes_hash
+    ON deployment_mgmt.indicator_allowlist_hashes(hash);
+
+-- review_status is NULL for unreviewed indicators, 'quarantined' when the
+-- allow-list held one back, 'approved' once an analyst released it and
+-- 'false_positive' once retracted. Quarantined and retracted indicators
+-- are kept inactive.
+ALTER TABLE deployment_mgmt.threat_indicators
+    ADD COLUMN IF NOT EXISTS review_status VARCHAR(20),
+    ADD COLUMN IF NOT EXISTS review_reason TEXT,
+    ADD COLUMN IF NOT EXISTS reviewed_by VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_threat_indicators_review_status
+    ON deployment_mgmt.threat_indicators(review_status) WHERE review_status IS NOT NULL;
+
+-- Public resolvers and infrastructure every tenant depends on
+INSERT INTO deployment_mgmt.indicator_allowlist (kind, value, description, created_by) VALUES
+    ('exact', '8.8.8.8', 'Google Public DNS', 'system'),
+    ('exact', '8.8.4.4', 'Google Public DNS', 'system'),
+    ('exact', '1.1.1.1', 'Cloudflare DNS', 'system'),
+    ('exact', '1.0.0.1', 'Cloudflare DNS', 'system'),
+    ('exact', '9.9.9.9', 'Quad9 DNS', 'system'),
+    ('exact', '208.67.222.222', 'OpenDNS', 'system'),
+    ('exact', '208.67.220.220', 'OpenDNS', 'system'),
+    ('exact', '2001:4860:4860::8888', 'Google Public DNS', 'system'),
+    ('exact', '2606:4700:4700::1111', 'Cloudflare DNS', 'system'),
+    ('domain', 'microsoft.com', 'Microsoft', 'system'),
+    ('domain', 'microsoftonline.com', 'Microsoft Entra ID', 'system'),
+    ('domain', 'windows.net', 'Microsoft Azure', 'system'),
+    ('domain', 'windowsupdate.com', 'Windows Update', 'system'),
+    ('domain', 'office.com', 'Microsoft 365', 'system'),
+    ('domain', 'office365.com', 'Microsoft 365', 'system'),
+    ('domain', 'google.com', 'Google', 'system'),
+    ('domain', 'apple.com', 'Apple', 'system')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,69 @@
+-- Threat actors, intrusion sets, campaigns and malware families
+
+-- kind is the STIX object type the entity models. external_id is the STIX
+-- identifier of imported entities; types holds threat_actor_types or
+-- malware_types.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.threat_entities (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kind VARCHAR(20) NOT NULL, -- threat-actor, intrusion-set, campaign, malware
+    name VARCHAR(255) NOT NULL,
+    aliases TEXT[] NOT NULL DEFAULT '{}',
+    description TEXT,
+    types TEXT[] NOT NULL DEFAULT '{}',
+    motivation VARCHAR(50),
+    sophistication VARCHAR(50),
+    objective TEXT,
+    first_seen TIMESTAMP WITH TIME ZONE,
+    last_seen TIMESTAMP WITH TIME ZONE,
+    external_id VARCHAR(255) UNIQUE,
+    external_references JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_threat_entities_kind_name
+    ON deployment_mgmt.threat_entities(kind, lower(name));
+CREATE INDEX IF NOT EXISTS idx_threat_entities_aliases
+    ON deployment_mgmt.threat_entities USING GIN (aliases);
+
+-- Indicators indicating an entity
+CREATE TABLE IF NOT EXISTS deployment_mgmt.threat_entity_indicators (
+    entity_id UUID NOT NULL REFERENCES deployment_mgmt.threat_entities(id) ON DELETE CASCADE,
+    indicator_id UUID NOT NULL REFERENCES deployment_mgmt.threat_indicators(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (entity_id, indicator_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_threat_entity_indicators_indicator
+    ON deployment_mgmt.threat_entity_indicators(indicator_id);
+
+-- MDE master rules detecting an entity
+CREATE TABLE IF NOT EXISTS deployment_mgmt.threat_entity_rules (
+    entity_id UUID NOT NULL REFERENCES deployment_mgmt.threat_entities(id) ON DELETE CASCADE,
+    rule_id UUID NOT NULL REFERENCES mde_rules.master_rules(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (entity_id, rule_id)
+);
+
+-- Tickets about an entity. The tickets table is created after these
+-- scripts, by 22-tickets.sql, which adds the foreign key on ticket_id.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.threat_entity_tickets (
+    entity_id UUID NOT NULL REFERENCES deployment_mgmt.threat_entities(id) ON DELETE CASCADE,
+    ticket_id INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (entity_id, ticket_id)
+);
+
+-- STIX relationships between entities, such as a campaign attributed-to an
+-- intrusion set or an intrusion set that uses a malware family
+CREATE TABLE IF NOT EXISTS deployment_mgmt.threat_entity_relationships (
+    source_id UUID NOT NULL REFERENCES deployment_mgmt.threat_entities(id) ON DELETE CASCADE,
+    target_id UUID NOT NULL REFERENCES deployment_mgmt.threat_entities(id) ON DELETE CASCADE,
+    relationship_type VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (source_id, target_id, relationship_type),
+    CHECK (source_id <> target_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_threat_entity_relationships_target
+    ON deployment_mgmt.threat_entity_relationships(target_id);
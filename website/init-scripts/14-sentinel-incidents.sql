@@ -0,0 +1,76 @@
+-- Sentinel incident sync
+
+-- Sync state of each registered workspace. incidents_synced_until is the
+-- last modification time of the incidents synced, where the next sync
+-- resumes.
+ALTER TABLE deployment_mgmt.sentinel_deployments
+    ADD COLUMN IF NOT EXISTS incidents_synced_until TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS incidents_synced_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS incident_sync_error TEXT;
+
+-- Incidents copied from client workspaces. incident_name is the incident's
+-- identifier in Sentinel and etag guards status changes written back.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.sentinel_incidents (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    deployment_id UUID NOT NULL REFERENCES deployment_mgmt.sentinel_deployments(id) ON DELETE CASCADE,
+    client_id UUID NOT NULL REFERENCES client_mgmt.clients(id) ON DELETE CASCADE,
+    incident_name VARCHAR(255) NOT NULL,
+    resource_id TEXT NOT NULL,
+    etag VARCHAR(100),
+    incident_number INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT,
+    severity VARCHAR(20) NOT NULL, -- Informational, Low, Medium, High
+    status VARCHAR(20) NOT NULL, -- New, Active, Closed
+    classification VARCHAR(50),
+    classification_reason VARCHAR(50),
+    classification_comment TEXT,
+    owner_object_id VARCHAR(100),
+    owner_email VARCHAR(255),
+    owner_assigned_to VARCHAR(255),
+    owner_upn VARCHAR(255),
+    labels TEXT[] NOT NULL DEFAULT '{}',
+    alert_count INTEGER NOT NULL DEFAULT 0,
+    first_activity_at TIMESTAMP WITH TIME ZONE,
+    last_activity_at TIMESTAMP WITH TIME ZONE,
+    created_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_modified_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    incident_url TEXT,
+    synced_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (deployment_id, incident_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sentinel_incidents_client
+    ON deployment_mgmt.sentinel_incidents(client_id, last_modified_time DESC);
+CREATE INDEX IF NOT EXISTS idx_sentinel_incidents_open
+    ON deployment_mgmt.sentinel_incidents(status, severity) WHERE status <> 'Closed';
+
+CREATE TABLE IF NOT EXISTS deployment_mgmt.sentinel_incident_alerts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    incident_id UUID NOT NULL REFERENCES deployment_mgmt.sentinel_incidents(id) ON DELETE CASCADE,
+    alert_name VARCHAR(255) NOT NULL,
+    display_name TEXT NOT NULL,
+    severity VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    product_name VARCHAR(255),
+    tactics TEXT[] NOT NULL DEFAULT '{}',
+    time_generated TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sentinel_incident_alerts_incident
+    ON deployment_mgmt.sentinel_incident_alerts(incident_id);
+
+-- Accounts, hosts, IP addresses and other entities of an incident, with
+-- their kind-specific properties as Sentinel reports them
+CREATE TABLE IF NOT EXISTS deployment_mgmt.sentinel_incident_entities (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    incident_id UUID NOT NULL REFERENCES deployment_mgmt.sentinel_incidents(id) ON DELETE CASCADE,
+    entity_name VARCHAR(255) NOT NULL,
+    kind VARCHAR(50) NOT NULL,
+    friendly_name TEXT,
+    properties JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS idx_sentinel_incident_entities_incident
+    ON deployment_mgmt.sentinel_incident_entities(incident_id);
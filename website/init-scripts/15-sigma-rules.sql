@@ -0,0 +1,14 @@
+-- Sigma rule import
+
+-- Where a master rule was imported from. source_id is the rule's id in the
+-- source, such as a Sigma rule's UUID, and source_text the rule as
+-- imported, which is returned when the rule is exported back.
+ALTER TABLE mde_rules.master_rules
+    ADD COLUMN IF NOT EXISTS source VARCHAR(50),
+    ADD COLUMN IF NOT EXISTS source_id VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS source_text TEXT,
+    ADD COLUMN IF NOT EXISTS conversion_warnings TEXT[];
+
+-- Re-importing a rule updates it. Rules without a source_id never conflict.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_master_rules_source
+    ON mde_rules.master_rules(source, source_id);
@@ -0,0 +1,13 @@
+-- Logic App template catalog
+
+-- content_hash is the SHA-256 of a template's content; a template whose
+-- file changes is indexed as a new version. The parameters column holds
+-- the schema worked out of the template: its trigger input, definition
+-- parameters, API connections and API permissions.
+ALTER TABLE deployment_mgmt.deployment_templates
+    ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64);
+
+-- Deployments are made from the active version of a template
+CREATE UNIQUE INDEX IF NOT EXISTS idx_deployment_templates_active
+    ON deployment_mgmt.deployment_templates(template_type, name)
+    WHERE is_active;
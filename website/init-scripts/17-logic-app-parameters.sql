@@ -0,0 +1,8 @@
+-- Logic App deployment parameters
+
+-- The parameter values a deployment was requested with. Values of secure
+-- parameters are masked in parameters and stored encrypted with
+-- ENCRYPTION_KEY in secure_parameters.
+ALTER TABLE deployment_mgmt.logic_app_deployments
+    ADD COLUMN IF NOT EXISTS parameters JSONB,
+    ADD COLUMN IF NOT EXISTS secure_parameters BYTEA;
@@ -0,0 +1,10 @@
+-- Logic App lifecycle audit
+
+-- Who asked for an action and how it ended. performed_by only names
+-- agents; actor holds the user or service that asked.
+ALTER TABLE deployment_mgmt.deployment_audit
+    ADD COLUMN IF NOT EXISTS actor VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS outcome VARCHAR(20);
+
+CREATE INDEX IF NOT EXISTS idx_deployment_audit_deployment
+    ON deployment_mgmt.deployment_audit(deployment_type, deployment_id, created_at);
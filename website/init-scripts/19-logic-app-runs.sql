@@ -0,0 +1,51 @@
+-- Logic App run history and failure monitoring
+
+-- Run sync state of each deployment. runs_synced_until is the start time
+-- the next poll resumes from: the earliest run still in progress or the
+-- latest run seen.
+ALTER TABLE deployment_mgmt.logic_app_deployments
+    ADD COLUMN IF NOT EXISTS runs_synced_until TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS runs_synced_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS run_sync_error TEXT;
+
+-- Runs of deployed workflows. run_name is the run's identifier in Azure;
+-- failed_actions lists the actions that failed with their errors.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.logic_app_runs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    deployment_id UUID NOT NULL REFERENCES deployment_mgmt.logic_app_deployments(id) ON DELETE CASCADE,
+    client_id UUID REFERENCES client_mgmt.clients(id) ON DELETE CASCADE,
+    run_name VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL, -- Running, Waiting, Succeeded, Failed, Cancelled, Skipped, TimedOut
+    trigger_name VARCHAR(255),
+    start_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    end_time TIMESTAMP WITH TIME ZONE,
+    error_code VARCHAR(255),
+    error_message TEXT,
+    failed_actions JSONB NOT NULL DEFAULT '[]',
+    synced_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (deployment_id, run_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_logic_app_runs_deployment
+    ON deployment_mgmt.logic_app_runs(deployment_id, start_time DESC);
+CREATE INDEX IF NOT EXISTS idx_logic_app_runs_client
+    ON deployment_mgmt.logic_app_runs(client_id, start_time DESC);
+
+-- Alerts raised when a playbook fails several runs in a row. An alert is
+-- open until a run succeeds again; a deployment has at most one open.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.logic_app_alerts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    deployment_id UUID NOT NULL REFERENCES deployment_mgmt.logic_app_deployments(id) ON DELETE CASCADE,
+    client_id UUID REFERENCES client_mgmt.clients(id) ON DELETE CASCADE,
+    consecutive_failures INTEGER NOT NULL,
+    last_run_name VARCHAR(255) NOT NULL,
+    last_error TEXT,
+    notify_error TEXT,
+    raised_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_logic_app_alerts_open
+    ON deployment_mgmt.logic_app_alerts(deployment_id) WHERE resolved_at IS NULL;
@@ -0,0 +1,36 @@
+-- Response actions taken on Defender for Endpoint machines
+
+-- Actions started through the API. defender_action_id is the machine
+-- action's ID in Defender; status follows it until it is final. The
+-- foreign key on ticket_id is added by 22-tickets.sql, which creates the
+-- tickets table. polling_since is set while a poll is asking Defender for
+-- the action's status, so that one poll runs at a time.
+CREATE TABLE IF NOT EXISTS deployment_mgmt.response_actions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    client_id UUID NOT NULL REFERENCES client_mgmt.clients(id) ON DELETE CASCADE,
+    machine_id VARCHAR(64) NOT NULL,
+    computer_name VARCHAR(255),
+    action_type VARCHAR(50) NOT NULL, -- Isolate, Unisolate, RunAntiVirusScan, RestrictCodeExecution, UnrestrictCodeExecution, CollectInvestigationPackage
+    defender_action_id VARCHAR(64) NOT NULL UNIQUE,
+    status VARCHAR(20) NOT NULL, -- Pending, InProgress, Succeeded, Failed, TimeOut, Cancelled
+    comment TEXT NOT NULL,
+    isolation_type VARCHAR(20),
+    scan_type VARCHAR(20),
+    ticket_id INTEGER,
+    requested_by VARCHAR(255),
+    poll_error TEXT,
+    polled_at TIMESTAMP WITH TIME ZONE,
+    polling_since TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_response_actions_machine
+    ON deployment_mgmt.response_actions(client_id, machine_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_response_actions_open
+    ON deployment_mgmt.response_actions(polled_at NULLS FIRST)
+    WHERE completed_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_response_actions_ticket
+    ON deployment_mgmt.response_actions(ticket_id)
+    WHERE ticket_id IS NOT NULL;
@@ -0,0 +1,16 @@
+-- Deduplicate global indicators. They have no client_id, which the
+-- (client_id, indicator_type, indicator_value) constraint treats as
+-- distinct, so they conflict on a partial unique index instead.
+--
+-- Databases holding indicators stored before values were normalized on the
+-- way in need them normalized and merged with the Go normalizer first, which
+-- builds this index itself:
+--
+--     indicator-backfill
+--
+-- Canonicalizing in SQL cannot match it exactly (punycode, URL escaping),
+-- and rows that differ from what feeds store would come back as duplicates.
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_threat_indicators_global_unique
+    ON deployment_mgmt.threat_indicators(indicator_type, indicator_value)
+    WHERE client_id IS NULL;
@@ -0,0 +1,61 @@
+-- Support tickets
+
+-- Tickets are raised by users, by the agents and by the background jobs:
+-- indicator sightings, Logic App failures and response actions. These
+-- tables used to be created only by the backend's internal migrations,
+-- which the server does not run. The columns match those migrations, so
+-- either may run first. user_id and assigned_to point at the users table
+-- those migrations own, so they have no foreign keys here.
+CREATE TABLE IF NOT EXISTS public.tickets (
+    id SERIAL PRIMARY KEY,
+    title VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    user_id INTEGER,
+    agent_type VARCHAR(50) NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'open',
+    priority VARCHAR(50) NOT NULL DEFAULT 'medium',
+    assigned_to INTEGER,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    due_at TIMESTAMP WITH TIME ZONE,
+    tags TEXT[]
+);
+
+ALTER TABLE public.tickets
+    ADD COLUMN IF NOT EXISTS tags TEXT[];
+
+CREATE INDEX IF NOT EXISTS idx_tickets_status ON public.tickets(status);
+CREATE INDEX IF NOT EXISTS idx_tickets_created_at ON public.tickets(created_at DESC);
+
+CREATE TABLE IF NOT EXISTS public.ticket_comments (
+    id SERIAL PRIMARY KEY,
+    ticket_id INTEGER NOT NULL REFERENCES public.tickets(id) ON DELETE CASCADE,
+    user_id INTEGER,
+    content TEXT NOT NULL,
+    is_internal BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ticket_comments_ticket ON public.ticket_comments(ticket_id);
+
+-- Tables created by earlier scripts refer to tickets without foreign keys,
+-- since the tickets table did not exist yet when they ran
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'indicator_sightings_ticket_id_fkey') THEN
+        ALTER TABLE deployment_mgmt.indicator_sightings
+            ADD CONSTRAINT indicator_sightings_ticket_id_fkey
+            FOREIGN KEY (ticket_id) REFERENCES public.tickets(id) ON DELETE SET NULL;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'threat_entity_tickets_ticket_id_fkey') THEN
+        ALTER TABLE deployment_mgmt.threat_entity_tickets
+            ADD CONSTRAINT threat_entity_tickets_ticket_id_fkey
+            FOREIGN KEY (ticket_id) REFERENCES public.tickets(id) ON DELETE CASCADE;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'response_actions_ticket_id_fkey') THEN
+        ALTER TABLE deployment_mgmt.response_actions
+            ADD CONSTRAINT response_actions_ticket_id_fkey
+            FOREIGN KEY (ticket_id) REFERENCES public.tickets(id) ON DELETE SET NULL;
+    END IF;
+END $$;
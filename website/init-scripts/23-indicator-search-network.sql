@@ -0,0 +1,8 @@
+-- Index behind the indicator search "within" filter
+
+-- The expression matches networkColumn in internal/indicator/search.go.
+-- Values of other types are NULL, so the inet cast never sees them.
+CREATE INDEX IF NOT EXISTS idx_threat_indicators_network
+    ON deployment_mgmt.threat_indicators USING GIST (
+        (CASE WHEN indicator_type IN ('ipv4', 'ipv6', 'cidr') THEN indicator_value::inet END) inet_ops
+    );